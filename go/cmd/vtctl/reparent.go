@@ -28,6 +28,11 @@ func init() {
 		commandReparentShard,
 		"[-force] [-leave-master-read-only] <keyspace/shard|zk shard path> <tablet alias|zk tablet path>",
 		"Specify which shard to reparent and which tablet should be the new master."})
+	addCommand("Shards", command{
+		"EmergencyReparentShard",
+		commandEmergencyReparentShard,
+		"[-preferred-cell=<cell>] [-leave-master-read-only] <keyspace/shard|zk shard path>",
+		"Reparents a shard whose master is dead to its most advanced replica, picked in the preferred cell if it has one. The slaves that are behind it are left stopped."})
 }
 
 func commandDemoteMaster(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
@@ -60,3 +65,15 @@ func commandReparentShard(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []
 	tabletAlias := tabletParamToTabletAlias(subFlags.Arg(1))
 	return "", wr.ReparentShard(keyspace, shard, tabletAlias, *leaveMasterReadOnly, *force)
 }
+
+func commandEmergencyReparentShard(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
+	preferredCell := subFlags.String("preferred-cell", "", "cell to pick the new master from, if it has replicas")
+	leaveMasterReadOnly := subFlags.Bool("leave-master-read-only", false, "leaves the master read-only after reparenting")
+	subFlags.Parse(args)
+	if subFlags.NArg() != 1 {
+		log.Fatalf("action EmergencyReparentShard requires <keyspace/shard|zk shard path>")
+	}
+
+	keyspace, shard := shardParamToKeyspaceShard(subFlags.Arg(0))
+	return "", wr.EmergencyReparentShard(keyspace, shard, *preferredCell, *leaveMasterReadOnly)
+}
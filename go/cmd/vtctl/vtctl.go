@@ -6,6 +6,7 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -23,13 +24,17 @@ import (
 	"github.com/youtube/vitess/go/flagutil"
 	"github.com/youtube/vitess/go/jscfg"
 	"github.com/youtube/vitess/go/vt/client2"
+	"github.com/youtube/vitess/go/vt/context"
 	hk "github.com/youtube/vitess/go/vt/hook"
 	"github.com/youtube/vitess/go/vt/key"
 	"github.com/youtube/vitess/go/vt/logutil"
 	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
 	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
 	"github.com/youtube/vitess/go/vt/tabletmanager/initiator"
+	"github.com/youtube/vitess/go/vt/tabletserver"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
 	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
 	"github.com/youtube/vitess/go/vt/wrangler"
 )
 
@@ -119,6 +124,20 @@ var commands = []commandGroup{
 			command{"MultiRestore", commandMultiRestore,
 				"[-force] [-concurrency=4] [-fetch-concurrency=4] [-insert-table-concurrency=4] [-fetch-retry-count=3] [-strategy=] <dst tablet alias|destination zk path> <source zk path>...",
 				"Restores a snapshot from multiple hosts."},
+			command{"Backup", commandBackup,
+				"[-concurrency=4] <tablet alias|zk tablet path>",
+				"Stop mysqld and copy compressed data files into the backup storage, then restart mysqld. The tablet goes back to its original type after the backup."},
+			command{"RestoreFromBackup", commandRestoreFromBackup,
+				"[-concurrency=4] [-dont-wait-for-slave-start] <dst tablet alias|zk dst tablet path> <keyspace/shard|zk shard path>",
+				"Restore the latest complete backup of the shard from the backup storage, and restart replication from the shard master. No source tablet is needed.\n" +
+					"NOTE: This does not wait for replication to catch up. The destination tablet must be 'idle' to begin with. It will transition to 'spare' once the restore is complete."},
+			command{"PointInTimeRecovery", commandPointInTimeRecovery,
+				"[-concurrency=4] [-source=<tablet alias>] -gtid=<flavor/gtid>|-timestamp=<time> <dst tablet alias|zk dst tablet path> <keyspace/shard|zk shard path>",
+				"Restore the shard into the tablet as it was at the given GTID, or at the given timestamp (RFC 3339, or seconds since the epoch). The latest backup taken before the target is restored, then the binlogs of the source tablet (the shard master by default) are replayed up to the target.\n" +
+					"NOTE: The destination tablet must be 'idle' to begin with. It stays in 'restore' type after the recovery, without replication, so the data can be inspected. Use -wait-time to give enough time for the whole recovery."},
+			command{"ListBackups", commandListBackups,
+				"<keyspace/shard|zk shard path>",
+				"List the backups of a shard in the backup storage, from the oldest to the newest."},
 			command{"ExecuteHook", commandExecuteHook,
 				"<tablet alias|zk tablet path> <hook name> [<param1=value1> <param2=value2> ...]",
 				"This runs the specified hook on the given tablet."},
@@ -128,6 +147,9 @@ var commands = []commandGroup{
 			command{"ExecuteFetch", commandExecuteFetch,
 				"[--max_rows=10000] [--want_fields] [--disable_binlogs] <tablet alias|zk tablet path> <sql command>",
 				"Runs the given sql command as a DBA on the remote tablet"},
+			command{"ExplainQuery", commandExplainQuery,
+				"[-bind_variables=<json>] <tablet alias|zk tablet path> <sql command>",
+				"Describes how the query service of the tablet would execute the query, without executing it."},
 		},
 	},
 	commandGroup{
@@ -174,6 +196,15 @@ var commands = []commandGroup{
 			command{"DeleteShard", commandDeleteShard,
 				"<keyspace/shard|zk shard path> ...",
 				"Deletes the given shard(s)"},
+			command{"GetQueryRules", commandGetQueryRules,
+				"<keyspace/shard|zk shard path>",
+				"Outputs the json version of the query rules of the shard to stdout."},
+			command{"SetQueryRules", commandSetQueryRules,
+				"{-rules=<rules> || -rules-file=<rules file>} <keyspace/shard|zk shard path> ...",
+				"Validates and saves the query rules of the given shard(s). Use <keyspace>/* for all the current shards of a keyspace: the rules are saved on each of them, so the shards created later (e.g. by resharding) need them saved again. The tablets apply them right away, on top of their -customrules file."},
+			command{"ValidateQueryRules", commandValidateQueryRules,
+				"<keyspace/shard|zk shard path> ...",
+				"Validates the query rules saved for the given shard(s)."},
 		},
 	},
 	commandGroup{
@@ -199,6 +230,12 @@ var commands = []commandGroup{
 			command{"MigrateServedFrom", commandMigrateServedFrom,
 				"[-reverse] [-skip-rebuild] <destination keyspace/shard|zk destination shard path> <served type>",
 				"Makes the destination keyspace/shard serve the given type. Will also rebuild the serving graph."},
+			command{"GetVSchema", commandGetVSchema,
+				"",
+				"Outputs the json version of the VSchema, used by vtgate to route queries, to stdout."},
+			command{"ApplyVSchema", commandApplyVSchema,
+				"{-vschema=<vschema> || -vschema-file=<vschema file>}",
+				"Validates and saves the VSchema. vtgate processes need to be restarted to use it."},
 		},
 	},
 	commandGroup{
@@ -325,6 +362,10 @@ func init() {
 		}
 	}
 	stdin = bufio.NewReader(os.Stdin)
+
+	addCommand("Generic", command{"ListCommands", commandListCommands,
+		"",
+		"HIDDEN Outputs the json version of the command table, used by the vtctld API."})
 }
 
 func confirm(prompt string, force bool) bool {
@@ -925,6 +966,82 @@ func commandClone(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string)
 	return "", wr.Clone(srcTabletAlias, dstTabletAliases, *force, *concurrency, *fetchConcurrency, *fetchRetryCount, *serverMode)
 }
 
+func commandBackup(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
+	concurrency := subFlags.Int("concurrency", 4, "how many compression jobs to run simultaneously")
+	subFlags.Parse(args)
+	if subFlags.NArg() != 1 {
+		log.Fatalf("action Backup requires <tablet alias|zk tablet path>")
+	}
+
+	tabletAlias := tabletParamToTabletAlias(subFlags.Arg(0))
+	return "", wr.Backup(tabletAlias, *concurrency)
+}
+
+func commandRestoreFromBackup(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
+	concurrency := subFlags.Int("concurrency", 4, "how many files to restore simultaneously")
+	dontWaitForSlaveStart := subFlags.Bool("dont-wait-for-slave-start", false, "won't wait for replication to start")
+	subFlags.Parse(args)
+	if subFlags.NArg() != 2 {
+		log.Fatalf("action RestoreFromBackup requires <dst tablet alias|zk dst tablet path> <keyspace/shard|zk shard path>")
+	}
+
+	dstTabletAlias := tabletParamToTabletAlias(subFlags.Arg(0))
+	keyspace, shard := shardParamToKeyspaceShard(subFlags.Arg(1))
+	return "", wr.RestoreFromBackup(dstTabletAlias, keyspace, shard, *concurrency, *dontWaitForSlaveStart)
+}
+
+func commandPointInTimeRecovery(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
+	concurrency := subFlags.Int("concurrency", 4, "how many files to restore simultaneously")
+	source := subFlags.String("source", "", "tablet to stream the binlogs from, defaults to the shard master")
+	gtidStr := subFlags.String("gtid", "", "GTID to stop at, as flavor/gtid")
+	timestampStr := subFlags.String("timestamp", "", "time to stop at, if no GTID is given")
+	subFlags.Parse(args)
+	if subFlags.NArg() != 2 {
+		log.Fatalf("action PointInTimeRecovery requires <dst tablet alias|zk dst tablet path> <keyspace/shard|zk shard path>")
+	}
+	stopAtGTID, err := myproto.DecodeGTID(*gtidStr)
+	if err != nil {
+		return "", err
+	}
+	stopAtTimestamp, err := wrangler.ParseRecoveryTimestamp(*timestampStr)
+	if err != nil {
+		return "", err
+	}
+	if (stopAtGTID == nil) == (stopAtTimestamp == 0) {
+		log.Fatalf("action PointInTimeRecovery requires exactly one of -gtid and -timestamp")
+	}
+	var sourceTabletAlias topo.TabletAlias
+	if *source != "" {
+		sourceTabletAlias = tabletParamToTabletAlias(*source)
+	}
+
+	dstTabletAlias := tabletParamToTabletAlias(subFlags.Arg(0))
+	keyspace, shard := shardParamToKeyspaceShard(subFlags.Arg(1))
+	pos, err := wr.PointInTimeRecovery(dstTabletAlias, keyspace, shard, sourceTabletAlias, *concurrency, stopAtGTID, stopAtTimestamp)
+	if err != nil {
+		return "", err
+	}
+	fmt.Println(jscfg.ToJson(pos))
+	return "", nil
+}
+
+func commandListBackups(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
+	subFlags.Parse(args)
+	if subFlags.NArg() != 1 {
+		log.Fatalf("action ListBackups requires <keyspace/shard|zk shard path>")
+	}
+
+	keyspace, shard := shardParamToKeyspaceShard(subFlags.Arg(0))
+	bhs, err := wr.ListBackups(keyspace, shard)
+	if err != nil {
+		return "", err
+	}
+	for _, bh := range bhs {
+		fmt.Println(bh.Name())
+	}
+	return "", nil
+}
+
 func commandMultiRestore(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (status string, err error) {
 	fetchRetryCount := subFlags.Int("fetch-retry-count", 3, "how many times to retry a failed transfer")
 	concurrency := subFlags.Int("concurrency", 8, "how many concurrent jobs to run simultaneously")
@@ -1030,6 +1147,72 @@ func commandExecuteHook(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []st
 	return "", err
 }
 
+func commandExplainQuery(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
+	bindVariables := subFlags.String("bind_variables", "", "the bind variables of the query, as a json object")
+	subFlags.Parse(args)
+	if subFlags.NArg() != 2 {
+		log.Fatalf("action ExplainQuery requires <tablet alias|zk tablet path> <sql command>")
+	}
+
+	bindVars, err := parseBindVariables(*bindVariables)
+	if err != nil {
+		return "", err
+	}
+	tabletInfo, err := wr.TopoServer().GetTablet(tabletParamToTabletAlias(subFlags.Arg(0)))
+	if err != nil {
+		return "", err
+	}
+	endPoint, err := tabletInfo.EndPoint()
+	if err != nil {
+		return "", err
+	}
+	conn, err := tabletconn.GetDialer()(&context.DummyContext{}, *endPoint, tabletInfo.Keyspace, tabletInfo.Shard, 30*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	explanation, err := conn.ExplainQuery(&context.DummyContext{}, subFlags.Arg(1), bindVars)
+	if err == nil {
+		fmt.Println(jscfg.ToJson(explanation))
+	}
+	return "", err
+}
+
+// parseBindVariables decodes a json object of bind variables.
+// Integers are returned as int64, the type vttablet expects.
+func parseBindVariables(data string) (map[string]interface{}, error) {
+	bindVars := make(map[string]interface{})
+	if data == "" {
+		return bindVars, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&bindVars); err != nil {
+		return nil, fmt.Errorf("invalid bind variables: %v", err)
+	}
+	for name, value := range bindVars {
+		bindVars[name] = bindValue(value)
+	}
+	return bindVars, nil
+}
+
+func bindValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case []interface{}:
+		for i, v := range value {
+			value[i] = bindValue(v)
+		}
+	}
+	return value
+}
+
 func commandCreateShard(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
 	force := subFlags.Bool("force", false, "will keep going even if the keyspace already exists")
 	parent := subFlags.Bool("parent", false, "creates the parent keyspace if it doesn't exist")
@@ -1067,6 +1250,69 @@ func commandGetShard(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []strin
 	return "", err
 }
 
+func commandGetQueryRules(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
+	subFlags.Parse(args)
+	if subFlags.NArg() != 1 {
+		log.Fatalf("action GetQueryRules requires <keyspace/shard|zk shard path>")
+	}
+
+	keyspace, shard := shardParamToKeyspaceShard(subFlags.Arg(0))
+	rules, err := wr.TopoServer().GetQueryRules(keyspace, shard)
+	if err == nil {
+		fmt.Println(rules)
+	}
+	return "", err
+}
+
+func commandSetQueryRules(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
+	rules := subFlags.String("rules", "", "the json query rules")
+	rulesFile := subFlags.String("rules-file", "", "file containing the json query rules")
+	subFlags.Parse(args)
+	if subFlags.NArg() == 0 {
+		log.Fatalf("action SetQueryRules requires <keyspace/shard|zk shard path> ...")
+	}
+
+	data := getFileParam(*rules, *rulesFile, "rules")
+	if err := tabletserver.NewQueryRules().UnmarshalJSON([]byte(data)); err != nil {
+		return "", fmt.Errorf("invalid query rules: %v", err)
+	}
+	keyspaceShards := shardParamsToKeyspaceShards(wr, subFlags.Args())
+	for _, ks := range keyspaceShards {
+		if err := wr.TopoServer().SaveQueryRules(ks.Keyspace, ks.Shard, data); err != nil {
+			return "", fmt.Errorf("SaveQueryRules(%v/%v) failed: %v", ks.Keyspace, ks.Shard, err)
+		}
+	}
+	return "", nil
+}
+
+func commandValidateQueryRules(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
+	subFlags.Parse(args)
+	if subFlags.NArg() == 0 {
+		log.Fatalf("action ValidateQueryRules requires <keyspace/shard|zk shard path> ...")
+	}
+
+	hasErrors := false
+	keyspaceShards := shardParamsToKeyspaceShards(wr, subFlags.Args())
+	for _, ks := range keyspaceShards {
+		data, err := wr.TopoServer().GetQueryRules(ks.Keyspace, ks.Shard)
+		switch err {
+		case nil:
+			err = tabletserver.NewQueryRules().UnmarshalJSON([]byte(data))
+		case topo.ErrNoNode:
+			// no rules is valid
+			continue
+		}
+		if err != nil {
+			log.Errorf("%v/%v: invalid query rules: %v", ks.Keyspace, ks.Shard, err)
+			hasErrors = true
+		}
+	}
+	if hasErrors {
+		return "", fmt.Errorf("some query rules are invalid")
+	}
+	return "", nil
+}
+
 func commandRebuildShardGraph(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
 	cells := subFlags.String("cells", "", "comma separated list of cells to update")
 	subFlags.Parse(args)
@@ -1394,6 +1640,33 @@ func commandMigrateServedFrom(wr *wrangler.Wrangler, subFlags *flag.FlagSet, arg
 	return "", wr.MigrateServedFrom(keyspace, shard, servedType, *reverse, *skipRebuild)
 }
 
+func commandGetVSchema(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
+	subFlags.Parse(args)
+	if subFlags.NArg() != 0 {
+		log.Fatalf("action GetVSchema doesn't take any parameter")
+	}
+	vschema, err := wr.TopoServer().GetVSchema()
+	if err == nil {
+		fmt.Println(vschema)
+	}
+	return "", err
+}
+
+func commandApplyVSchema(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
+	vschema := subFlags.String("vschema", "", "the json VSchema")
+	vschemaFile := subFlags.String("vschema-file", "", "file containing the json VSchema")
+	subFlags.Parse(args)
+	if subFlags.NArg() != 0 {
+		log.Fatalf("action ApplyVSchema doesn't take any parameter")
+	}
+
+	data := getFileParam(*vschema, *vschemaFile, "vschema")
+	if _, err := planbuilder.NewVSchema([]byte(data)); err != nil {
+		return "", err
+	}
+	return "", wr.TopoServer().SaveVSchema(data)
+}
+
 func commandWaitForAction(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
 	subFlags.Parse(args)
 	if subFlags.NArg() != 1 {
@@ -1772,6 +2045,42 @@ func commandGetShardReplication(wr *wrangler.Wrangler, subFlags *flag.FlagSet, a
 	return "", err
 }
 
+// commandInfo is the json version of a command.
+type commandInfo struct {
+	Name   string
+	Params string
+	Help   string
+	Hidden bool
+}
+
+// commandGroupInfo is the json version of a commandGroup.
+type commandGroupInfo struct {
+	Name     string
+	Commands []commandInfo
+}
+
+func commandListCommands(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
+	subFlags.Parse(args)
+	if subFlags.NArg() != 0 {
+		log.Fatalf("action ListCommands doesn't take any parameter")
+	}
+
+	groups := make([]commandGroupInfo, len(commands))
+	for i, group := range commands {
+		groups[i].Name = group.name
+		for _, cmd := range group.commands {
+			hidden := strings.HasPrefix(cmd.help, "HIDDEN")
+			help := cmd.help
+			if hidden {
+				help = strings.TrimSpace(strings.TrimPrefix(help, "HIDDEN"))
+			}
+			groups[i].Commands = append(groups[i].Commands, commandInfo{cmd.name, cmd.params, help, hidden})
+		}
+	}
+	fmt.Println(jscfg.ToJson(groups))
+	return "", nil
+}
+
 // signal handling, centralized here
 func installSignalHandlers() {
 	sigChan := make(chan os.Signal, 1)
@@ -0,0 +1,532 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// The JSON API runs the vtctl commands on behalf of automation. Each
+// command runs as a job, in a vtctl subprocess that uses the same
+// topology server as vtctld:
+//
+//   GET  /api/commands          the command table, by group
+//   POST /api/commands/<name>   starts a job, the body is a CommandRequest
+//   GET  /api/jobs              all the running and recent jobs
+//   GET  /api/jobs/<id>         one job, with its result once it's done
+//   GET  /api/jobs/<id>/log     streams the log of a job until it's done
+//
+// A POST with the 'wait' parameter waits for the job to be done.
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/acl"
+	"github.com/youtube/vitess/go/vt/env"
+	"github.com/youtube/vitess/go/vt/logutil"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+var (
+	vtctlBinaryPath = flag.String("vtctl_binary_path", "", "Full path (including filename) to vtctl binary, used by the JSON API. If not set, tries VTROOT/bin/vtctl.")
+	apiJobHistory   = flag.Int("api_job_history", 100, "number of finished jobs the JSON API remembers")
+)
+
+const (
+	apiPrefix = "/api/"
+
+	JOB_RUNNING = "running"
+	JOB_DONE    = "done"
+	JOB_FAILED  = "failed"
+)
+
+// CommandInfo describes a vtctl command, as listed by
+// 'vtctl ListCommands'.
+type CommandInfo struct {
+	Name   string
+	Params string
+	Help   string
+	Hidden bool
+}
+
+// CommandGroup is a group of vtctl commands.
+type CommandGroup struct {
+	Name     string
+	Commands []CommandInfo
+}
+
+// CommandRequest is the body of a request to run a vtctl command.
+type CommandRequest struct {
+	// Flags are the command flags, by name without the leading
+	// dash. The values are strings, numbers or booleans.
+	Flags map[string]interface{}
+
+	// Args are the positional arguments of the command.
+	Args []string
+}
+
+// commandLine returns the command line arguments for req: the flags
+// sorted by name, then the positional arguments.
+func (req *CommandRequest) commandLine() ([]string, error) {
+	names := make([]string, 0, len(req.Flags))
+	for name := range req.Flags {
+		if name == "" || strings.HasPrefix(name, "-") || strings.Contains(name, "=") {
+			return nil, fmt.Errorf("invalid flag name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]string, 0, len(names)+len(req.Args))
+	for _, name := range names {
+		switch value := req.Flags[name].(type) {
+		case string, bool, json.Number, float64:
+			result = append(result, fmt.Sprintf("-%v=%v", name, value))
+		default:
+			return nil, fmt.Errorf("invalid value for flag %v: %v", name, value)
+		}
+	}
+	for _, arg := range req.Args {
+		if strings.HasPrefix(arg, "-") {
+			return nil, fmt.Errorf("positional argument %q cannot start with a dash", arg)
+		}
+		result = append(result, arg)
+	}
+	return result, nil
+}
+
+// Job is a vtctl command run by the API.
+type Job struct {
+	ID      string
+	Command string
+	Args    []string
+	State   string
+	Start   time.Time
+	End     time.Time
+	Error   string `json:",omitempty"`
+
+	// Output is what the command printed on stdout. If it is json,
+	// it is also in Result.
+	Output string          `json:",omitempty"`
+	Result json.RawMessage `json:",omitempty"`
+
+	// finished is closed when the job is done
+	finished chan struct{}
+
+	mu  sync.Mutex
+	log []byte
+	// logChanged is closed when the log changes, and nil when
+	// the job is done.
+	logChanged chan struct{}
+}
+
+// Write is part of the io.Writer interface, it appends to the log.
+func (job *Job) Write(p []byte) (int, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.log = append(job.log, p...)
+	close(job.logChanged)
+	job.logChanged = make(chan struct{})
+	return len(p), nil
+}
+
+// logFrom returns the log after offset, and a channel that is closed
+// when there is more. The channel is nil if the job is done.
+func (job *Job) logFrom(offset int) ([]byte, <-chan struct{}) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.log[offset:], job.logChanged
+}
+
+// finish records the result of the job.
+func (job *Job) finish(stdout []byte, err error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.End = time.Now()
+	job.Output = string(stdout)
+	var result interface{}
+	if json.Unmarshal(stdout, &result) == nil {
+		job.Result = json.RawMessage(stdout)
+	}
+	if err != nil {
+		job.State = JOB_FAILED
+		job.Error = err.Error()
+	} else {
+		job.State = JOB_DONE
+	}
+	close(job.logChanged)
+	job.logChanged = nil
+	close(job.finished)
+}
+
+// jobFields has the fields of Job, without its methods.
+type jobFields Job
+
+// MarshalJSON is part of the json.Marshaler interface, it protects
+// the fields that change while the job runs.
+func (job *Job) MarshalJSON() ([]byte, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return json.Marshal((*jobFields)(job))
+}
+
+// JobRepository runs the vtctl jobs, and remembers the most recent
+// ones.
+type JobRepository struct {
+	binary string
+	// flags are the global vtctl flags
+	flags   []string
+	history int
+
+	mu     sync.Mutex
+	nextID int64
+	jobs   map[string]*Job
+	// finished has the IDs of the finished jobs, oldest first
+	finished []string
+	commands []CommandGroup
+}
+
+// NewJobRepository creates a JobRepository that runs binary with
+// flags, and remembers up to history finished jobs.
+func NewJobRepository(binary string, flags []string, history int) *JobRepository {
+	return &JobRepository{
+		binary:  binary,
+		flags:   flags,
+		history: history,
+		// start from the current time, so the IDs are not
+		// reused when vtctld restarts
+		nextID: time.Now().Unix(),
+		jobs:   make(map[string]*Job),
+	}
+}
+
+// Commands returns the vtctl command table. It is read once from
+// the vtctl binary.
+func (jr *JobRepository) Commands() ([]CommandGroup, error) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	if jr.commands != nil {
+		return jr.commands, nil
+	}
+
+	args := append(append([]string{}, jr.flags...), "ListCommands")
+	output, err := exec.Command(jr.binary, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list the vtctl commands: %v", err)
+	}
+	var commands []CommandGroup
+	if err := json.Unmarshal(output, &commands); err != nil {
+		return nil, fmt.Errorf("cannot parse the vtctl commands: %v", err)
+	}
+	jr.commands = commands
+	return commands, nil
+}
+
+// findCommand returns the command named name.
+func (jr *JobRepository) findCommand(name string) (*CommandInfo, error) {
+	groups, err := jr.Commands()
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		for i, cmd := range group.Commands {
+			if strings.ToLower(cmd.Name) == strings.ToLower(name) {
+				return &group.Commands[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Start starts a job that runs command with args.
+func (jr *JobRepository) Start(command string, args []string) *Job {
+	jr.mu.Lock()
+	jr.nextID++
+	job := &Job{
+		ID:         strconv.FormatInt(jr.nextID, 10),
+		Command:    command,
+		Args:       args,
+		State:      JOB_RUNNING,
+		Start:      time.Now(),
+		finished:   make(chan struct{}),
+		logChanged: make(chan struct{}),
+	}
+	jr.jobs[job.ID] = job
+	jr.mu.Unlock()
+
+	cmdArgs := append(append(append([]string{}, jr.flags...), command), args...)
+	cmd := exec.Command(jr.binary, cmdArgs...)
+	stdout := new(bytes.Buffer)
+	cmd.Stdout = stdout
+	cmd.Stderr = job
+	log.Infof("api job %v: %v %v", job.ID, command, args)
+	go func() {
+		err := cmd.Run()
+		job.finish(stdout.Bytes(), err)
+		log.Infof("api job %v: %v", job.ID, job.State)
+		jr.retire(job)
+	}()
+	return job
+}
+
+// retire records that job is finished, and forgets the oldest
+// finished jobs.
+func (jr *JobRepository) retire(job *Job) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.finished = append(jr.finished, job.ID)
+	for len(jr.finished) > jr.history {
+		delete(jr.jobs, jr.finished[0])
+		jr.finished = jr.finished[1:]
+	}
+}
+
+// Job returns the job with the given ID, or nil.
+func (jr *JobRepository) Job(id string) *Job {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	return jr.jobs[id]
+}
+
+// Jobs returns all the jobs, oldest first.
+func (jr *JobRepository) Jobs() []*Job {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	result := make([]*Job, 0, len(jr.jobs))
+	for _, job := range jr.jobs {
+		result = append(result, job)
+	}
+	sort.Sort(jobsByID(result))
+	return result
+}
+
+type jobsByID []*Job
+
+func (jobs jobsByID) Len() int      { return len(jobs) }
+func (jobs jobsByID) Swap(i, j int) { jobs[i], jobs[j] = jobs[j], jobs[i] }
+func (jobs jobsByID) Less(i, j int) bool {
+	a, _ := strconv.ParseInt(jobs[i].ID, 10, 64)
+	b, _ := strconv.ParseInt(jobs[j].ID, 10, 64)
+	return a < b
+}
+
+// commandRole returns the acl role required to run a command: the
+// commands that only read are for debugging, the others for admins.
+func commandRole(name string) string {
+	for _, prefix := range []string{"Get", "List", "Validate", "Resolve", "Ping", "RpcPing", "ReadTabletAction", "ShardReplicationPositions", "ExplainQuery", "PreflightSchema"} {
+		if strings.HasPrefix(name, prefix) {
+			return acl.DEBUGGING
+		}
+	}
+	return acl.ADMIN
+}
+
+// sendJSON writes data as the json response.
+func sendJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	buf, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		status = http.StatusInternalServerError
+		buf, _ = json.Marshal(map[string]string{"Error": err.Error()})
+	}
+	w.WriteHeader(status)
+	w.Write(buf)
+}
+
+// sendJSONError writes an error as the json response.
+func sendJSONError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	sendJSON(w, status, map[string]string{"Error": fmt.Sprintf(format, args...)})
+}
+
+// APIHandler serves the JSON API.
+type APIHandler struct {
+	jobs *JobRepository
+}
+
+// ServeHTTP is part of the http.Handler interface.
+func (ah *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/"), "/")
+	switch {
+	case parts[0] == "commands" && len(parts) == 1:
+		ah.listCommands(w, r)
+	case parts[0] == "commands" && len(parts) == 2:
+		ah.runCommand(w, r, parts[1])
+	case parts[0] == "jobs" && len(parts) == 1:
+		ah.listJobs(w, r)
+	case parts[0] == "jobs" && len(parts) == 2:
+		ah.getJob(w, r, parts[1])
+	case parts[0] == "jobs" && len(parts) == 3 && parts[2] == "log":
+		ah.streamLog(w, r, parts[1])
+	default:
+		sendJSONError(w, http.StatusNotFound, "unknown api path %v", r.URL.Path)
+	}
+}
+
+// checkMethod checks the http method of r. If it is not method, it
+// sends the error and returns false.
+func checkMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		sendJSONError(w, http.StatusMethodNotAllowed, "%v requires %v", r.URL.Path, method)
+		return false
+	}
+	return true
+}
+
+// checkAccess checks r is allowed for the acl role. If not, it sends
+// the error and returns false.
+func checkAccess(w http.ResponseWriter, r *http.Request, role string) bool {
+	if err := acl.CheckAccessHTTP(r, role); err != nil {
+		sendJSONError(w, http.StatusForbidden, "Access denied: %v", err)
+		return false
+	}
+	return true
+}
+
+// checkRequest checks the http method and the acl role of r.
+func checkRequest(w http.ResponseWriter, r *http.Request, method, role string) bool {
+	return checkMethod(w, r, method) && checkAccess(w, r, role)
+}
+
+func (ah *APIHandler) listCommands(w http.ResponseWriter, r *http.Request) {
+	if !checkRequest(w, r, "GET", acl.MONITORING) {
+		return
+	}
+	groups, err := ah.jobs.Commands()
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	sendJSON(w, http.StatusOK, groups)
+}
+
+func (ah *APIHandler) runCommand(w http.ResponseWriter, r *http.Request, name string) {
+	if !checkMethod(w, r, "POST") {
+		return
+	}
+	cmd, err := ah.jobs.findCommand(name)
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	if cmd == nil {
+		sendJSONError(w, http.StatusNotFound, "unknown command %v", name)
+		return
+	}
+	if !checkAccess(w, r, commandRole(cmd.Name)) {
+		return
+	}
+
+	req := &CommandRequest{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(req); err != nil && err != io.EOF {
+		sendJSONError(w, http.StatusBadRequest, "cannot parse the request: %v", err)
+		return
+	}
+	args, err := req.commandLine()
+	if err != nil {
+		sendJSONError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	job := ah.jobs.Start(cmd.Name, args)
+	if r.FormValue("wait") == "" {
+		sendJSON(w, http.StatusAccepted, job)
+		return
+	}
+	<-job.finished
+	sendJSON(w, http.StatusOK, job)
+}
+
+func (ah *APIHandler) listJobs(w http.ResponseWriter, r *http.Request) {
+	if !checkRequest(w, r, "GET", acl.DEBUGGING) {
+		return
+	}
+	sendJSON(w, http.StatusOK, ah.jobs.Jobs())
+}
+
+func (ah *APIHandler) getJob(w http.ResponseWriter, r *http.Request, id string) {
+	if !checkRequest(w, r, "GET", acl.DEBUGGING) {
+		return
+	}
+	job := ah.jobs.Job(id)
+	if job == nil {
+		sendJSONError(w, http.StatusNotFound, "unknown job %v", id)
+		return
+	}
+	sendJSON(w, http.StatusOK, job)
+}
+
+// streamLog sends the log of a job as it is written, until the job is
+// done.
+func (ah *APIHandler) streamLog(w http.ResponseWriter, r *http.Request, id string) {
+	if !checkRequest(w, r, "GET", acl.DEBUGGING) {
+		return
+	}
+	job := ah.jobs.Job(id)
+	if job == nil {
+		sendJSONError(w, http.StatusNotFound, "unknown job %v", id)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	flusher, _ := w.(http.Flusher)
+	offset := 0
+	for {
+		data, changed := job.logFrom(offset)
+		if len(data) > 0 {
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			offset += len(data)
+		}
+		if changed == nil {
+			return
+		}
+		<-changed
+	}
+}
+
+// vtctlBinary returns the path of the vtctl binary.
+func vtctlBinary() (string, error) {
+	p := *vtctlBinaryPath
+	if p == "" {
+		vtroot, err := env.VtRoot()
+		if err != nil {
+			return "", err
+		}
+		p = path.Join(vtroot, "bin/vtctl")
+	}
+	if _, err := os.Stat(p); err != nil {
+		return "", fmt.Errorf("vtctl binary %s not found: %v", p, err)
+	}
+	return p, nil
+}
+
+// initAPI registers the JSON API handler. It is disabled if the vtctl
+// binary cannot be found.
+func initAPI() {
+	binary, err := vtctlBinary()
+	if err != nil {
+		log.Warningf("JSON API disabled: %v", err)
+		return
+	}
+	flags := []string{"-alsologtostderr"}
+	flags = append(flags, logutil.GetSubprocessFlags()...)
+	flags = append(flags, topo.GetSubprocessFlags()...)
+	http.Handle(apiPrefix, &APIHandler{NewJobRepository(binary, flags, *apiJobHistory)})
+}
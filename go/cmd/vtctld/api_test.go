@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/youtube/vitess/go/acl"
+)
+
+// fakeVtctl is a vtctl binary that knows a few commands.
+const fakeVtctl = `#!/bin/sh
+# skip the global flags
+while [ "${1#-}" != "$1" ]; do shift; done
+case "$1" in
+ListCommands)
+  echo '[{"Name":"Tablets","Commands":[{"Name":"GetTablet","Params":"<tablet alias>","Help":"Outputs the tablet."},{"Name":"ScrapTablet","Params":"[-force] <tablet alias>","Help":"Scraps a tablet."}]}]'
+  ;;
+GetTablet)
+  echo "getting $2" >&2
+  echo "{\"Alias\": \"$2\"}"
+  ;;
+ScrapTablet)
+  echo "cannot scrap $2 $3" >&2
+  exit 1
+  ;;
+esac
+`
+
+// readOnlyPolicy denies the admin role to the requests with the
+// ReadOnly header.
+type readOnlyPolicy struct{}
+
+func (readOnlyPolicy) CheckAccessActor(actor, role string) error {
+	return nil
+}
+
+func (readOnlyPolicy) CheckAccessHTTP(req *http.Request, role string) error {
+	if role == acl.ADMIN && req.Header.Get("ReadOnly") != "" {
+		return errors.New("read only")
+	}
+	return nil
+}
+
+func init() {
+	acl.RegisterPolicy("vtctld_api_test", readOnlyPolicy{})
+	flag.Set("security_policy", "vtctld_api_test")
+}
+
+func TestCommandLine(t *testing.T) {
+	req := &CommandRequest{}
+	decoder := json.NewDecoder(strings.NewReader(`{"Flags": {"wait-time": "10s", "force": true, "port": 15000}, "Args": ["ks/0", "cell-1"]}`))
+	decoder.UseNumber()
+	if err := decoder.Decode(req); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	got, err := req.commandLine()
+	if err != nil {
+		t.Fatalf("commandLine failed: %v", err)
+	}
+	want := []string{"-force=true", "-port=15000", "-wait-time=10s", "ks/0", "cell-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	for _, bad := range []*CommandRequest{
+		&CommandRequest{Flags: map[string]interface{}{"-force": true}},
+		&CommandRequest{Flags: map[string]interface{}{"tags": []interface{}{"a"}}},
+		&CommandRequest{Args: []string{"-force"}},
+	} {
+		if _, err := bad.commandLine(); err == nil {
+			t.Errorf("commandLine(%v) should have failed", bad)
+		}
+	}
+}
+
+func apiCall(t *testing.T, method, url, body string, readOnly bool) (int, map[string]interface{}) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	if readOnly {
+		req.Header.Set("ReadOnly", "true")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%v %v failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("cannot read %v %v: %v", method, url, err)
+	}
+	result := make(map[string]interface{})
+	if strings.HasPrefix(string(data), "{") {
+		if err := json.Unmarshal(data, &result); err != nil {
+			t.Fatalf("cannot parse %v %v: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode, result
+}
+
+func TestAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vtctld_api_test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	binary := path.Join(dir, "vtctl")
+	if err := ioutil.WriteFile(binary, []byte(fakeVtctl), 0755); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	server := httptest.NewServer(&APIHandler{NewJobRepository(binary, []string{"-global"}, 1)})
+	defer server.Close()
+	api := server.URL + apiPrefix
+
+	// the command table
+	resp, err := http.Get(api + "commands")
+	if err != nil {
+		t.Fatalf("GET commands failed: %v", err)
+	}
+	var groups []CommandGroup
+	err = json.NewDecoder(resp.Body).Decode(&groups)
+	resp.Body.Close()
+	if err != nil || len(groups) != 1 || len(groups[0].Commands) != 2 || groups[0].Commands[0].Name != "GetTablet" {
+		t.Fatalf("unexpected command table: %v %v", groups, err)
+	}
+
+	// a successful job, with a json result
+	status, job := apiCall(t, "POST", api+"commands/GetTablet?wait=true", `{"Args": ["cell-1"]}`, true)
+	if status != http.StatusOK || job["State"] != JOB_DONE {
+		t.Fatalf("unexpected GetTablet job: %v %v", status, job)
+	}
+	if result, ok := job["Result"].(map[string]interface{}); !ok || result["Alias"] != "cell-1" {
+		t.Errorf("unexpected GetTablet result: %v", job["Result"])
+	}
+	getTabletID := job["ID"].(string)
+	resp, err = http.Get(api + "jobs/" + getTabletID + "/log")
+	if err != nil {
+		t.Fatalf("GET log failed: %v", err)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(data) != "getting cell-1\n" {
+		t.Errorf("unexpected GetTablet log: %q", data)
+	}
+
+	// the acl role
+	if status, _ := apiCall(t, "POST", api+"commands/ScrapTablet", `{"Args": ["cell-1"]}`, true); status != http.StatusForbidden {
+		t.Errorf("ScrapTablet should be forbidden, got %v", status)
+	}
+
+	// a failed job, that replaces the GetTablet job in the history
+	status, job = apiCall(t, "POST", api+"commands/ScrapTablet?wait=true", `{"Flags": {"force": true}, "Args": ["cell-1"]}`, false)
+	if status != http.StatusOK || job["State"] != JOB_FAILED || job["Error"] == "" {
+		t.Errorf("unexpected ScrapTablet job: %v %v", status, job)
+	}
+	if status, job = apiCall(t, "GET", api+"jobs/"+job["ID"].(string), "", false); status != http.StatusOK || job["Command"] != "ScrapTablet" {
+		t.Errorf("unexpected ScrapTablet job: %v %v", status, job)
+	}
+	if status, _ := apiCall(t, "GET", api+"jobs/"+getTabletID, "", false); status != http.StatusNotFound {
+		t.Errorf("GetTablet job should be forgotten, got %v", status)
+	}
+
+	// unknown commands
+	if status, _ := apiCall(t, "POST", api+"commands/Unknown", "", false); status != http.StatusNotFound {
+		t.Errorf("unknown command should not be found, got %v", status)
+	}
+}
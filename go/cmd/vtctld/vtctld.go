@@ -434,6 +434,9 @@ func main() {
 			return "", wr.DeleteTablet(tabletAlias)
 		})
 
+	// JSON API for all the vtctl commands
+	initAPI()
+
 	// toplevel index
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		templateLoader.ServeTemplate("index.html", indexContent, w, r)
@@ -8,10 +8,12 @@ import (
 	"flag"
 	"time"
 
+	log "github.com/golang/glog"
 	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/vt/servenv"
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/vtgate"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
 )
 
 var (
@@ -19,6 +21,10 @@ var (
 	retryDelay = flag.Duration("retry-delay", 200*time.Millisecond, "retry delay")
 	retryCount = flag.Int("retry-count", 10, "retry count")
 	timeout    = flag.Duration("timeout", 5*time.Second, "connection and call timeout")
+
+	tabletManagerProtocol = flag.String("tablet_manager_protocol", "bson", "the protocol to use to talk to vttablet, for the snapshot reads")
+
+	vschemaRefreshInterval = flag.Duration("vschema_refresh_interval", 1*time.Minute, "how often to reload the VSchema from the topology, 0 to never reload it")
 )
 
 var resilientSrvTopoServer *vtgate.ResilientSrvTopoServer
@@ -49,6 +55,33 @@ func main() {
 	topoReader = NewTopoReader(resilientSrvTopoServer)
 	topo.RegisterTopoReader(topoReader)
 
-	vtgate.Init(resilientSrvTopoServer, *cell, *retryDelay, *retryCount, *timeout)
+	// A missing or unreadable VSchema is used as an empty one:
+	// ExecuteSQL can't route any query until it's reloaded, but
+	// the other APIs work.
+	vschema, err := planbuilder.LoadVSchema(ts)
+	if err != nil {
+		log.Warningf("cannot load vschema, using an empty one: %v", err)
+		vschema = nil
+	}
+
+	snapshotTM := vtgate.NewSnapshotTabletManager(ts, *tabletManagerProtocol)
+	vtgate.Init(resilientSrvTopoServer, snapshotTM, vschema, *cell, *retryDelay, *retryCount, *timeout)
+	if *vschemaRefreshInterval > 0 {
+		go reloadVSchema(ts, *vschemaRefreshInterval)
+	}
 	servenv.RunDefault()
 }
+
+// reloadVSchema reloads the VSchema from the topology every
+// interval. If it can't be read, the previous one is kept.
+func reloadVSchema(ts topo.Server, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		vschema, err := planbuilder.LoadVSchema(ts)
+		if err != nil {
+			log.Warningf("cannot reload vschema, keeping the previous one: %v", err)
+			continue
+		}
+		vtgate.RpcVTGate.SetVSchema(vschema)
+	}
+}
@@ -60,6 +60,9 @@ func main() {
 
 	scheduler.Enable(activeModules)
 	scheduler.EnableDryRun(dryRunModules)
+	servenv.OnRun(scheduler.AddStatusParts)
+	go scheduler.RunElection()
+	servenv.OnTerm(scheduler.StopElection)
 	go scheduler.Run()
 	servenv.RunDefault()
 }
@@ -62,7 +62,7 @@ func main() {
 	}
 	tabletserver.InitQueryService()
 
-	err = tabletserver.AllowQueries(&dbConfigs.App, schemaOverrides, tabletserver.LoadCustomRules(), mysqld, true)
+	err = tabletserver.AllowQueries(&dbConfigs.App, schemaOverrides, tabletserver.LoadCustomRules(), mysqld, true, false)
 	if err != nil {
 		return
 	}
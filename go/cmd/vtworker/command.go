@@ -45,6 +45,11 @@ var commands = []commandGroup{
 		"Workers copying data for backups and clones",
 		[]command{},
 	},
+	commandGroup{
+		"Schema",
+		"Workers changing the schema",
+		[]command{},
+	},
 }
 
 func init() {
@@ -0,0 +1,135 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/servenv"
+	"github.com/youtube/vitess/go/vt/worker"
+	"github.com/youtube/vitess/go/vt/wrangler"
+)
+
+const (
+	defaultChunkCount        = 100
+	defaultMaxReplicationLag = 10 * time.Second
+)
+
+const onlineSchemaChangeHTML = `
+<!DOCTYPE html>
+<head>
+  <title>Online Schema Change Action</title>
+</head>
+<body>
+  <h1>Online Schema Change Action</h1>
+    <form action="/Schema/OnlineSchemaChange" method="post">
+      <LABEL for="keyspace">Keyspace: </LABEL>
+        <INPUT type="text" id="keyspace" name="keyspace" value=""></BR>
+      <LABEL for="shards">Shards: </LABEL>
+        <INPUT type="text" id="shards" name="shards" value=""></BR>
+      <LABEL for="table">Table: </LABEL>
+        <INPUT type="text" id="table" name="table" value=""></BR>
+      <LABEL for="alter">Alter: </LABEL>
+        <INPUT type="text" id="alter" name="alter" value=""></BR>
+      <LABEL for="chunkCount">Chunk Count: </LABEL>
+        <INPUT type="text" id="chunkCount" name="chunkCount" value="{{.DefaultChunkCount}}"></BR>
+      <LABEL for="minTableSizeForSplit">Minimun Table Size For Split: </LABEL>
+        <INPUT type="text" id="minTableSizeForSplit" name="minTableSizeForSplit" value="{{.DefaultMinTableSizeForSplit}}"></BR>
+      <LABEL for="maxReplicationLag">Max Replication Lag: </LABEL>
+        <INPUT type="text" id="maxReplicationLag" name="maxReplicationLag" value="{{.DefaultMaxReplicationLag}}"></BR>
+      <LABEL for="resume">Resume: </LABEL>
+        <INPUT type="checkbox" id="resume" name="resume" value="true"></BR>
+      <INPUT type="submit" name="submit" value="Change"/>
+    </form>
+
+  <h1>Help</h1>
+    <p>Runs 'ALTER TABLE &lt;table&gt; &lt;alter&gt;' on the masters of the shards (comma separated, all the shards of the keyspace if empty), one shard at a time, without blocking the writes to the table. The table needs a primary key.</p>
+    <p>The rows are copied in chunks to a shadow table with the new schema, while the changed rows are found in the binlogs and copied again. The tables are swapped with a short lock at the end, and the original table is kept as _&lt;table&gt;_old. The copy waits while a slave is more than the max replication lag behind.</p>
+    <p>Resume continues a previous job that was interrupted, using the checkpoint stored in the master _vt database. The chunks that were already copied are skipped.</p>
+  </body>
+`
+
+var onlineSchemaChangeTemplate = loadTemplate("onlineSchemaChange", onlineSchemaChangeHTML)
+
+func commandOnlineSchemaChange(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) worker.Worker {
+	shards := subFlags.String("shards", "", "comma separated list of shards to change, defaults to all the shards of the keyspace")
+	chunkCount := subFlags.Int("chunk_count", defaultChunkCount, "number of chunks to copy the table in")
+	minTableSizeForSplit := subFlags.Int("min_table_size_for_split", defaultMinTableSizeForSplit, "tables bigger than this size on disk in bytes will be copied in chunk_count chunks if possible")
+	maxReplicationLag := subFlags.Duration("max_replication_lag", defaultMaxReplicationLag, "the copy waits while a slave is more than this behind")
+	resume := subFlags.Bool("resume", false, "resume a previous job from its checkpoint, skipping the chunks that are already copied")
+	subFlags.Parse(args)
+	if subFlags.NArg() != 3 {
+		log.Fatalf("command OnlineSchemaChange requires <keyspace> <table> <alter>")
+	}
+
+	var shardArray []string
+	if *shards != "" {
+		shardArray = strings.Split(*shards, ",")
+	}
+	return worker.NewOnlineSchemaChangeWorker(wr, subFlags.Arg(0), shardArray, subFlags.Arg(1), subFlags.Arg(2), *chunkCount, uint64(*minTableSizeForSplit), *maxReplicationLag, *resume)
+}
+
+func interactiveOnlineSchemaChange(wr *wrangler.Wrangler, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		httpError(w, "cannot parse form: %s", err)
+		return
+	}
+
+	keyspace := r.FormValue("keyspace")
+	table := r.FormValue("table")
+	alter := r.FormValue("alter")
+	if keyspace == "" || table == "" || alter == "" {
+		// display the input form
+		result := make(map[string]interface{})
+		result["DefaultChunkCount"] = strconv.Itoa(defaultChunkCount)
+		result["DefaultMinTableSizeForSplit"] = strconv.Itoa(defaultMinTableSizeForSplit)
+		result["DefaultMaxReplicationLag"] = defaultMaxReplicationLag.String()
+		executeTemplate(w, onlineSchemaChangeTemplate, result)
+		return
+	}
+
+	// get other parameters
+	var shardArray []string
+	if shards := r.FormValue("shards"); shards != "" {
+		shardArray = strings.Split(shards, ",")
+	}
+	chunkCount, err := strconv.ParseInt(r.FormValue("chunkCount"), 0, 64)
+	if err != nil {
+		httpError(w, "cannot parse chunkCount: %s", err)
+		return
+	}
+	minTableSizeForSplit, err := strconv.ParseInt(r.FormValue("minTableSizeForSplit"), 0, 64)
+	if err != nil {
+		httpError(w, "cannot parse minTableSizeForSplit: %s", err)
+		return
+	}
+	maxReplicationLag, err := time.ParseDuration(r.FormValue("maxReplicationLag"))
+	if err != nil {
+		httpError(w, "cannot parse maxReplicationLag: %s", err)
+		return
+	}
+	resume := r.FormValue("resume") == "true"
+
+	// start the schema change job
+	wrk := worker.NewOnlineSchemaChangeWorker(wr, keyspace, shardArray, table, alter, int(chunkCount), uint64(minTableSizeForSplit), maxReplicationLag, resume)
+	if _, err := setAndStartWorker(wrk); err != nil {
+		httpError(w, "cannot set worker: %s", err)
+		return
+	}
+
+	http.Redirect(w, r, servenv.StatusURLPath(), http.StatusTemporaryRedirect)
+}
+
+func init() {
+	addCommand("Schema", command{"OnlineSchemaChange",
+		commandOnlineSchemaChange, interactiveOnlineSchemaChange,
+		"[--shards=''] [--chunk_count=100] [--max_replication_lag=10s] [--resume] <keyspace> <table> <alter>",
+		"Alters a table shard by shard, through a shadow table, without blocking its writes."})
+}
@@ -0,0 +1,151 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	log "github.com/golang/glog"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/servenv"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/worker"
+	"github.com/youtube/vitess/go/vt/wrangler"
+)
+
+const pointInTimeRecoveryHTML = `
+<!DOCTYPE html>
+<head>
+  <title>Point In Time Recovery Action</title>
+</head>
+<body>
+  <h1>Point In Time Recovery Action</h1>
+    <form action="/Clones/PointInTimeRecovery" method="post">
+      <LABEL for="keyspace">Keyspace: </LABEL>
+        <INPUT type="text" id="keyspace" name="keyspace" value=""></BR>
+      <LABEL for="shard">Shard: </LABEL>
+        <INPUT type="text" id="shard" name="shard" value=""></BR>
+      <LABEL for="tablet">Destination Tablet: </LABEL>
+        <INPUT type="text" id="tablet" name="tablet" value=""></BR>
+      <LABEL for="source">Source Tablet: </LABEL>
+        <INPUT type="text" id="source" name="source" value=""></BR>
+      <LABEL for="gtid">GTID: </LABEL>
+        <INPUT type="text" id="gtid" name="gtid" value=""></BR>
+      <LABEL for="timestamp">Timestamp: </LABEL>
+        <INPUT type="text" id="timestamp" name="timestamp" value=""></BR>
+      <LABEL for="concurrency">Concurrency: </LABEL>
+        <INPUT type="text" id="concurrency" name="concurrency" value="4"></BR>
+      <INPUT type="submit" value="Recover"/>
+    </form>
+
+  <h1>Help</h1>
+    <p>The destination tablet (cell-uid) must be idle. The latest backup of the shard taken before the target is restored into it, then the binlogs of the source tablet (the shard master if empty) are replayed up to the target.</p>
+    <p>The target is either a GTID (flavor/gtid), or a timestamp (RFC 3339, or seconds since the epoch). The destination tablet stays in restore type, without replication, so the data can be inspected.</p>
+  </body>
+`
+
+var pointInTimeRecoveryTemplate = loadTemplate("pointInTimeRecovery", pointInTimeRecoveryHTML)
+
+func commandPointInTimeRecovery(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) worker.Worker {
+	concurrency := subFlags.Int("concurrency", 4, "how many files to restore simultaneously")
+	source := subFlags.String("source", "", "tablet to stream the binlogs from, defaults to the shard master")
+	gtidStr := subFlags.String("gtid", "", "GTID to stop at, as flavor/gtid")
+	timestampStr := subFlags.String("timestamp", "", "time to stop at, if no GTID is given")
+	subFlags.Parse(args)
+	if subFlags.NArg() != 2 {
+		log.Fatalf("command PointInTimeRecovery requires <dst tablet alias> <keyspace/shard|zk shard path>")
+	}
+	stopAtGTID, err := myproto.DecodeGTID(*gtidStr)
+	if err != nil {
+		log.Fatalf("cannot parse gtid: %v", err)
+	}
+	stopAtTimestamp, err := wrangler.ParseRecoveryTimestamp(*timestampStr)
+	if err != nil {
+		log.Fatalf("cannot parse timestamp: %v", err)
+	}
+	if (stopAtGTID == nil) == (stopAtTimestamp == 0) {
+		log.Fatalf("command PointInTimeRecovery requires exactly one of -gtid and -timestamp")
+	}
+	var sourceTabletAlias topo.TabletAlias
+	if *source != "" {
+		if sourceTabletAlias, err = topo.ParseTabletAliasString(*source); err != nil {
+			log.Fatalf("cannot parse source tablet alias: %v", err)
+		}
+	}
+	dstTabletAlias, err := topo.ParseTabletAliasString(subFlags.Arg(0))
+	if err != nil {
+		log.Fatalf("cannot parse destination tablet alias: %v", err)
+	}
+
+	keyspace, shard := shardParamToKeyspaceShard(subFlags.Arg(1))
+	return worker.NewPointInTimeRecoveryWorker(wr, keyspace, shard, dstTabletAlias, sourceTabletAlias, *concurrency, stopAtGTID, stopAtTimestamp)
+}
+
+func interactivePointInTimeRecovery(wr *wrangler.Wrangler, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		httpError(w, "cannot parse form: %s", err)
+		return
+	}
+
+	keyspace := r.FormValue("keyspace")
+	shard := r.FormValue("shard")
+	if keyspace == "" || shard == "" {
+		// display the input form
+		executeTemplate(w, pointInTimeRecoveryTemplate, nil)
+		return
+	}
+
+	// get other parameters
+	dstTabletAlias, err := topo.ParseTabletAliasString(r.FormValue("tablet"))
+	if err != nil {
+		httpError(w, "cannot parse tablet: %s", err)
+		return
+	}
+	var sourceTabletAlias topo.TabletAlias
+	if source := r.FormValue("source"); source != "" {
+		if sourceTabletAlias, err = topo.ParseTabletAliasString(source); err != nil {
+			httpError(w, "cannot parse source: %s", err)
+			return
+		}
+	}
+	stopAtGTID, err := myproto.DecodeGTID(r.FormValue("gtid"))
+	if err != nil {
+		httpError(w, "cannot parse gtid: %s", err)
+		return
+	}
+	stopAtTimestamp, err := wrangler.ParseRecoveryTimestamp(r.FormValue("timestamp"))
+	if err != nil {
+		httpError(w, "cannot parse timestamp: %s", err)
+		return
+	}
+	if (stopAtGTID == nil) == (stopAtTimestamp == 0) {
+		httpError(w, "invalid target: %s", fmt.Errorf("exactly one of gtid and timestamp is required"))
+		return
+	}
+	concurrency, err := strconv.ParseInt(r.FormValue("concurrency"), 0, 64)
+	if err != nil {
+		httpError(w, "cannot parse concurrency: %s", err)
+		return
+	}
+
+	// start the recovery job
+	wrk := worker.NewPointInTimeRecoveryWorker(wr, keyspace, shard, dstTabletAlias, sourceTabletAlias, int(concurrency), stopAtGTID, stopAtTimestamp)
+	if _, err := setAndStartWorker(wrk); err != nil {
+		httpError(w, "cannot set worker: %s", err)
+		return
+	}
+
+	http.Redirect(w, r, servenv.StatusURLPath(), http.StatusTemporaryRedirect)
+}
+
+func init() {
+	addCommand("Clones", command{"PointInTimeRecovery",
+		commandPointInTimeRecovery, interactivePointInTimeRecovery,
+		"[--concurrency=4] [--source=<tablet alias>] --gtid=<flavor/gtid>|--timestamp=<time> <dst tablet alias> <keyspace/shard|zk shard path>",
+		"Restores a shard into an idle tablet as it was at a GTID or a time, from a backup and the binlogs."})
+}
@@ -0,0 +1,247 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/concurrency"
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/servenv"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/worker"
+	"github.com/youtube/vitess/go/vt/wrangler"
+)
+
+const splitCloneHTML = `
+<!DOCTYPE html>
+<head>
+  <title>Split Clone Action</title>
+</head>
+<body>
+  <h1>Split Clone Action</h1>
+
+    {{if .Error}}
+      <b>Error:</b> {{.Error}}</br>
+    {{else}}
+      <p>Choose the source shard for this action.</p>
+      <ul>
+      {{range $i, $si := .Shards}}
+        <li><a href="/Clones/SplitClone?keyspace={{$si.Keyspace}}&shard={{$si.Shard}}">{{$si.Keyspace}}/{{$si.Shard}}</a></li>
+      {{end}}
+      </ul>
+    {{end}}
+</body>
+`
+
+const splitCloneHTML2 = `
+<!DOCTYPE html>
+<head>
+  <title>Split Clone Action</title>
+</head>
+<body>
+  <p>Source shard: {{.Keyspace}}/{{.Shard}}</p>
+  <h1>Split Clone Action</h1>
+    <form action="/Clones/SplitClone" method="post">
+      <LABEL for="excludeTables">Exclude Tables: </LABEL>
+        <INPUT type="text" id="excludeTables" name="excludeTables" value=""></BR>
+      <LABEL for="strategy">Strategy: </LABEL>
+        <INPUT type="text" id="strategy" name="strategy" value="populateBlpCheckpoint"></BR>
+      <LABEL for="sourceReaderCount">Source Reader Count: </LABEL>
+        <INPUT type="text" id="sourceReaderCount" name="sourceReaderCount" value="{{.DefaultSourceReaderCount}}"></BR>
+      <LABEL for="minTableSizeForSplit">Minimun Table Size For Split: </LABEL>
+        <INPUT type="text" id="minTableSizeForSplit" name="minTableSizeForSplit" value="{{.DefaultMinTableSizeForSplit}}"></BR>
+      <LABEL for="destinationWriterCount">Destination Writer Count: </LABEL>
+        <INPUT type="text" id="destinationWriterCount" name="destinationWriterCount" value="{{.DefaultDestinationWriterCount}}"></BR>
+      <LABEL for="resume">Resume: </LABEL>
+        <INPUT type="checkbox" id="resume" name="resume" value="true"></BR>
+      <INPUT type="hidden" name="keyspace" value="{{.Keyspace}}"/>
+      <INPUT type="hidden" name="shard" value="{{.Shard}}"/>
+      <INPUT type="submit" name="submit" value="Clone"/>
+    </form>
+
+  <h1>Help</h1>
+    <p>Strategy can have the following values, comma separated:</p>
+    <ul>
+      <li><b>populateBlpCheckpoint</b>: creates (if necessary) and populates the blp_checkpoint table in the destination. Required for filtered replication to start.</li>
+      <li><b>dontStartBinlogPlayer</b>: (requires populateBlpCheckpoint) will setup, but not start binlog replication on the destination. The flag has to be manually cleared from the _vt.blp_checkpoint table.</li>
+      <li><b>skipAutoIncrement(TTT)</b>: we won't add the AUTO_INCREMENT back to that table.</li>
+    </ul>
+    <p>Resume continues a previous job that was interrupted, using the checkpoint stored in the destination _vt database. The chunks that were already copied are skipped. It copies from the same source tablet: a job that fails leaves it as a checker with replication stopped at the job position, and replication restarts once the resumed job is done.</p>
+    <p>The following flags are also supported, but their use is very strongly discouraged:</p>
+    <ul>
+      <li><b>delayPrimaryKey</b>: we won't add the primary key until after the table is populated.</li>
+      <li><b>delaySecondaryIndexes</b>: we won't add the secondary indexes until after the table is populated.</li>
+      <li><b>useMyIsam</b>: create the table as MyISAM, then convert it to InnoDB after population.</li>
+    </ul>
+  </body>
+`
+
+var splitCloneTemplate = loadTemplate("splitClone", splitCloneHTML)
+var splitCloneTemplate2 = loadTemplate("splitClone2", splitCloneHTML2)
+
+func commandSplitClone(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) worker.Worker {
+	excludeTables := subFlags.String("exclude_tables", "", "comma separated list of tables to exclude")
+	strategy := subFlags.String("strategy", "", "which strategy to use for restore, use 'mysqlctl multirestore -help' for more info")
+	sourceReaderCount := subFlags.Int("source_reader_count", defaultSourceReaderCount, "number of concurrent streaming queries to use on the source")
+	minTableSizeForSplit := subFlags.Int("min_table_size_for_split", defaultMinTableSizeForSplit, "tables bigger than this size on disk in bytes will be split into source_reader_count chunks if possible")
+	destinationWriterCount := subFlags.Int("destination_writer_count", defaultDestinationWriterCount, "number of concurrent RPCs to execute on each destination master")
+	resume := subFlags.Bool("resume", false, "resume a previous job from its checkpoint, skipping the chunks that are already copied")
+	subFlags.Parse(args)
+	if subFlags.NArg() != 1 {
+		log.Fatalf("command SplitClone requires <source keyspace/shard|zk shard path>")
+	}
+
+	keyspace, shard := shardParamToKeyspaceShard(subFlags.Arg(0))
+	var excludeTableArray []string
+	if *excludeTables != "" {
+		excludeTableArray = strings.Split(*excludeTables, ",")
+	}
+	return worker.NewSplitCloneWorker(wr, *cell, keyspace, shard, excludeTableArray, *strategy, *sourceReaderCount, uint64(*minTableSizeForSplit), *destinationWriterCount, *resume)
+}
+
+// shardsToSplit returns all the shards that have other shards inside
+// their KeyRange, that could be destinations of a split.
+func shardsToSplit(wr *wrangler.Wrangler) ([]map[string]string, error) {
+	keyspaces, err := wr.TopoServer().GetKeyspaces()
+	if err != nil {
+		return nil, err
+	}
+
+	wg := sync.WaitGroup{}
+	mu := sync.Mutex{} // protects result
+	result := make([]map[string]string, 0, len(keyspaces))
+	rec := concurrency.AllErrorRecorder{}
+	for _, keyspace := range keyspaces {
+		wg.Add(1)
+		go func(keyspace string) {
+			defer wg.Done()
+			shards, err := wr.TopoServer().GetShardNames(keyspace)
+			if err != nil {
+				rec.RecordError(err)
+				return
+			}
+			shardMap := make(map[string]*topo.ShardInfo, len(shards))
+			for _, shard := range shards {
+				si, err := wr.TopoServer().GetShard(keyspace, shard)
+				if err != nil {
+					rec.RecordError(err)
+					return
+				}
+				shardMap[shard] = si
+			}
+			for shard, si := range shardMap {
+				for otherShard, otherSi := range shardMap {
+					if otherShard == shard {
+						continue
+					}
+					if overlap, err := key.KeyRangesOverlap(si.KeyRange, otherSi.KeyRange); err == nil && overlap == otherSi.KeyRange {
+						mu.Lock()
+						result = append(result, map[string]string{
+							"Keyspace": keyspace,
+							"Shard":    shard,
+						})
+						mu.Unlock()
+						break
+					}
+				}
+			}
+		}(keyspace)
+	}
+	wg.Wait()
+
+	if rec.HasErrors() {
+		return nil, rec.Error()
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("There are no shards to split")
+	}
+	return result, nil
+}
+
+func interactiveSplitClone(wr *wrangler.Wrangler, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		httpError(w, "cannot parse form: %s", err)
+		return
+	}
+
+	keyspace := r.FormValue("keyspace")
+	shard := r.FormValue("shard")
+	if keyspace == "" || shard == "" {
+		// display the list of possible shards to choose from
+		result := make(map[string]interface{})
+		shards, err := shardsToSplit(wr)
+		if err != nil {
+			result["Error"] = err.Error()
+		} else {
+			result["Shards"] = shards
+		}
+
+		executeTemplate(w, splitCloneTemplate, result)
+		return
+	}
+
+	submit := r.FormValue("submit")
+	if submit == "" {
+		// display the input form
+		result := make(map[string]interface{})
+		result["Keyspace"] = keyspace
+		result["Shard"] = shard
+		result["DefaultSourceReaderCount"] = fmt.Sprintf("%v", defaultSourceReaderCount)
+		result["DefaultMinTableSizeForSplit"] = fmt.Sprintf("%v", defaultMinTableSizeForSplit)
+		result["DefaultDestinationWriterCount"] = fmt.Sprintf("%v", defaultDestinationWriterCount)
+		executeTemplate(w, splitCloneTemplate2, result)
+		return
+	}
+
+	// get other parameters
+	var excludeTableArray []string
+	if excludeTables := r.FormValue("excludeTables"); excludeTables != "" {
+		excludeTableArray = strings.Split(excludeTables, ",")
+	}
+	strategy := r.FormValue("strategy")
+	sourceReaderCountStr := r.FormValue("sourceReaderCount")
+	sourceReaderCount, err := strconv.ParseInt(sourceReaderCountStr, 0, 64)
+	if err != nil {
+		httpError(w, "cannot parse sourceReaderCount: %s", err)
+		return
+	}
+	minTableSizeForSplitStr := r.FormValue("minTableSizeForSplit")
+	minTableSizeForSplit, err := strconv.ParseInt(minTableSizeForSplitStr, 0, 64)
+	if err != nil {
+		httpError(w, "cannot parse minTableSizeForSplit: %s", err)
+		return
+	}
+	destinationWriterCountStr := r.FormValue("destinationWriterCount")
+	destinationWriterCount, err := strconv.ParseInt(destinationWriterCountStr, 0, 64)
+	if err != nil {
+		httpError(w, "cannot parse destinationWriterCount: %s", err)
+		return
+	}
+
+	resume := r.FormValue("resume") == "true"
+
+	// start the clone job
+	wrk := worker.NewSplitCloneWorker(wr, *cell, keyspace, shard, excludeTableArray, strategy, int(sourceReaderCount), uint64(minTableSizeForSplit), int(destinationWriterCount), resume)
+	if _, err := setAndStartWorker(wrk); err != nil {
+		httpError(w, "cannot set worker: %s", err)
+		return
+	}
+
+	http.Redirect(w, r, servenv.StatusURLPath(), http.StatusTemporaryRedirect)
+}
+
+func init() {
+	addCommand("Clones", command{"SplitClone",
+		commandSplitClone, interactiveSplitClone,
+		"[--exclude_tables=''] [--strategy=''] [--resume] <source keyspace/shard|zk shard path>",
+		"Replicates the data and creates configuration for a horizontal split."})
+}
@@ -65,6 +65,8 @@ const verticalSplitCloneHTML2 = `
         <INPUT type="text" id="minTableSizeForSplit" name="minTableSizeForSplit" value="{{.DefaultMinTableSizeForSplit}}"></BR>
       <LABEL for="destinationWriterCount">Destination Writer Count: </LABEL>
         <INPUT type="text" id="destinationWriterCount" name="destinationWriterCount" value="{{.DefaultDestinationWriterCount}}"></BR>
+      <LABEL for="resume">Resume: </LABEL>
+        <INPUT type="checkbox" id="resume" name="resume" value="true"></BR>
       <INPUT type="hidden" name="keyspace" value="{{.Keyspace}}"/>
       <INPUT type="submit" value="Clone"/>
     </form>
@@ -76,6 +78,7 @@ const verticalSplitCloneHTML2 = `
       <li><b>dontStartBinlogPlayer</b>: (requires populateBlpCheckpoint) will setup, but not start binlog replication on the destination. The flag has to be manually cleared from the _vt.blp_checkpoint table.</li>
       <li><b>skipAutoIncrement(TTT)</b>: we won't add the AUTO_INCREMENT back to that table.</li>
     </ul>
+    <p>Resume continues a previous job that was interrupted, using the checkpoint stored in the destination _vt database. The chunks that were already copied are skipped. It copies from the same source tablet: a job that fails leaves it as a checker with replication stopped at the job position, and replication restarts once the resumed job is done.</p>
     <p>The following flags are also supported, but their use is very strongly discouraged:</p>
     <ul>
       <li><b>delayPrimaryKey</b>: we won't add the primary key until after the table is populated.</li>
@@ -95,6 +98,7 @@ func commandVerticalSplitClone(wr *wrangler.Wrangler, subFlags *flag.FlagSet, ar
 	sourceReaderCount := subFlags.Int("source_reader_count", defaultSourceReaderCount, "number of concurrent streaming queries to use on the source")
 	minTableSizeForSplit := subFlags.Int("min_table_size_for_split", defaultMinTableSizeForSplit, "tables bigger than this size on disk in bytes will be split into source_reader_count chunks if possible")
 	destinationWriterCount := subFlags.Int("destination_writer_count", defaultDestinationWriterCount, "number of concurrent RPCs to execute on the destination")
+	resume := subFlags.Bool("resume", false, "resume a previous job from its checkpoint, skipping the chunks that are already copied")
 	subFlags.Parse(args)
 	if subFlags.NArg() != 1 {
 		log.Fatalf("command VerticalSplitClone requires <destination keyspace/shard|zk shard path>")
@@ -105,7 +109,7 @@ func commandVerticalSplitClone(wr *wrangler.Wrangler, subFlags *flag.FlagSet, ar
 	if *tables != "" {
 		tableArray = strings.Split(*tables, ",")
 	}
-	return worker.NewVerticalSplitCloneWorker(wr, *cell, keyspace, shard, tableArray, *strategy, *sourceReaderCount, uint64(*minTableSizeForSplit), *destinationWriterCount)
+	return worker.NewVerticalSplitCloneWorker(wr, *cell, keyspace, shard, tableArray, *strategy, *sourceReaderCount, uint64(*minTableSizeForSplit), *destinationWriterCount, *resume)
 }
 
 // keyspacesWithServedFrom returns all the keyspaces that have ServedFrom set
@@ -202,8 +206,10 @@ func interactiveVerticalSplitClone(wr *wrangler.Wrangler, w http.ResponseWriter,
 		return
 	}
 
+	resume := r.FormValue("resume") == "true"
+
 	// start the clone job
-	wrk := worker.NewVerticalSplitCloneWorker(wr, *cell, keyspace, "0", tableArray, strategy, int(sourceReaderCount), uint64(minTableSizeForSplit), int(destinationWriterCount))
+	wrk := worker.NewVerticalSplitCloneWorker(wr, *cell, keyspace, "0", tableArray, strategy, int(sourceReaderCount), uint64(minTableSizeForSplit), int(destinationWriterCount), resume)
 	if _, err := setAndStartWorker(wrk); err != nil {
 		httpError(w, "cannot set worker: %s", err)
 		return
@@ -215,6 +221,6 @@ func interactiveVerticalSplitClone(wr *wrangler.Wrangler, w http.ResponseWriter,
 func init() {
 	addCommand("Clones", command{"VerticalSplitClone",
 		commandVerticalSplitClone, interactiveVerticalSplitClone,
-		"[--tables=''] [--strategy=''] <destination keyspace/shard|zk shard path>",
+		"[--tables=''] [--strategy=''] [--resume] <destination keyspace/shard|zk shard path>",
 		"Replicates the data and creates configuration for a vertical split."})
 }
@@ -14,6 +14,8 @@ import (
 	"net/http"
 	"reflect"
 	"sync"
+
+	"github.com/youtube/vitess/go/trace"
 )
 
 // ServerError represents an error that has been returned from
@@ -35,6 +37,7 @@ type Call struct {
 	Done          chan *Call  // Strobes when call is complete (nil for streaming RPCs)
 	Stream        bool        // True for a streaming RPC call, false otherwise
 	Subseq        uint64      // The next expected subseq in the packets
+	Span          *trace.Span // The trace span of the caller, nil if not traced.
 }
 
 // Client represents an RPC Client.
@@ -88,6 +91,7 @@ func (client *Client) send(call *Call) {
 	// Encode and send the request.
 	client.request.Seq = seq
 	client.request.ServiceMethod = call.ServiceMethod
+	client.request.TraceID, client.request.SpanID = call.Span.IDs()
 	err := client.codec.WriteRequest(&client.request, call.Args)
 	if err != nil {
 		client.mutex.Lock()
@@ -339,6 +343,12 @@ func (client *Client) Go(serviceMethod string, args interface{}, reply interface
 // Go invokes the streaming function asynchronously.  It returns the Call structure representing
 // the invocation.
 func (client *Client) StreamGo(serviceMethod string, args interface{}, replyStream interface{}) *Call {
+	return client.StreamGoWithSpan(nil, serviceMethod, args, replyStream)
+}
+
+// StreamGoWithSpan is StreamGo for a caller traced by span: the server
+// side of the call is part of the same trace.
+func (client *Client) StreamGoWithSpan(span *trace.Span, serviceMethod string, args interface{}, replyStream interface{}) *Call {
 	// first check the replyStream object is a stream of pointers to a data structure
 	typ := reflect.TypeOf(replyStream)
 	// FIXME: check the direction of the channel, maybe?
@@ -353,6 +363,7 @@ func (client *Client) StreamGo(serviceMethod string, args interface{}, replyStre
 	call.Reply = replyStream
 	call.Stream = true
 	call.Subseq = 0
+	call.Span = span
 	client.send(call)
 	return call
 }
@@ -362,3 +373,18 @@ func (client *Client) Call(serviceMethod string, args interface{}, reply interfa
 	call := <-client.Go(serviceMethod, args, reply, make(chan *Call, 1)).Done
 	return call.Error
 }
+
+// CallWithSpan is Call for a caller traced by span: the server side of
+// the call is part of the same trace.
+func (client *Client) CallWithSpan(span *trace.Span, serviceMethod string, args interface{}, reply interface{}) error {
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          make(chan *Call, 1),
+		Span:          span,
+	}
+	client.send(call)
+	call = <-call.Done
+	return call.Error
+}
@@ -137,6 +137,8 @@ import (
 	"sync"
 	"unicode"
 	"unicode/utf8"
+
+	"github.com/youtube/vitess/go/trace"
 )
 
 const (
@@ -176,6 +178,8 @@ type service struct {
 type Request struct {
 	ServiceMethod string   // format: "Service.Method"
 	Seq           uint64   // sequence number chosen by client
+	TraceID       uint64   // trace of the caller, 0 if not traced
+	SpanID        uint64   // span of the caller in the trace
 	next          *Request // for free list in Server
 }
 
@@ -191,6 +195,15 @@ type Response struct {
 
 const lastStreamResponseError = "EOS"
 
+// SpanContext is implemented by the connection contexts that can
+// carry the trace span of a request. When a request is traced, the
+// method is called with the context returned by WithSpan.
+type SpanContext interface {
+	// WithSpan returns a copy of the context for the request
+	// traced by span. It must have the same type.
+	WithSpan(span *trace.Span) interface{}
+}
+
 // Server represents an RPC Server.
 type Server struct {
 	mu         sync.Mutex // protects the serviceMap
@@ -403,6 +416,14 @@ func (s *service) call(server *Server, sending *sync.Mutex, mtype *methodType, r
 	mtype.Lock()
 	mtype.numCalls++
 	mtype.Unlock()
+	if req.TraceID != 0 {
+		// the caller traces this request, our span is its child
+		span := trace.StartRemoteSpan(req.TraceID, req.SpanID, req.ServiceMethod)
+		defer span.Finish()
+		if sc, ok := context.(SpanContext); ok {
+			context = sc.WithSpan(span)
+		}
+	}
 	function := mtype.method.Func
 	var returnValues []reflect.Value
 
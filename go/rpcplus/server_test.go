@@ -17,6 +17,8 @@ import (
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/youtube/vitess/go/trace"
 )
 
 var (
@@ -508,6 +510,54 @@ func TestClientWriteError(t *testing.T) {
 	w.done <- true
 }
 
+type spanContext struct {
+	span *trace.Span
+}
+
+func (sc *spanContext) WithSpan(span *trace.Span) interface{} {
+	return &spanContext{span}
+}
+
+type Traced int
+
+type TracedReply struct {
+	TraceID, ParentID uint64
+}
+
+func (t *Traced) Span(context *spanContext, args string, reply *TracedReply) error {
+	if context.span != nil {
+		reply.TraceID = context.span.TraceID
+		reply.ParentID = context.span.ParentID
+	}
+	return nil
+}
+
+func TestTracePropagation(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Traced))
+	cli, srv := net.Pipe()
+	go server.ServeConnWithContext(srv, &spanContext{})
+	client := NewClient(cli)
+	defer client.Close()
+
+	reply := new(TracedReply)
+	if err := client.Call("Traced.Span", "", reply); err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if reply.TraceID != 0 {
+		t.Errorf("untraced call has a server span: %v", reply)
+	}
+
+	span := &trace.Span{TraceID: 12, SpanID: 34}
+	reply = new(TracedReply)
+	if err := client.CallWithSpan(span, "Traced.Span", "", reply); err != nil {
+		t.Fatalf("CallWithSpan failed: %v", err)
+	}
+	if reply.TraceID != 12 || reply.ParentID != 34 {
+		t.Errorf("want server span child of 12/34, got %v", reply)
+	}
+}
+
 func benchmarkEndToEnd(dial func() (*Client, error), b *testing.B) {
 	b.StopTimer()
 	once.Do(startServer)
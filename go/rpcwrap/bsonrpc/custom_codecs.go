@@ -22,6 +22,10 @@ func (req *RequestBson) MarshalBson(buf *bytes2.ChunkedWriter, key string) {
 
 	bson.EncodeString(buf, "ServiceMethod", req.ServiceMethod)
 	bson.EncodeUint64(buf, "Seq", req.Seq)
+	if req.TraceID != 0 {
+		bson.EncodeUint64(buf, "TraceID", req.TraceID)
+		bson.EncodeUint64(buf, "SpanID", req.SpanID)
+	}
 
 	lenWriter.Close()
 }
@@ -38,6 +42,10 @@ func (req *RequestBson) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 			req.ServiceMethod = bson.DecodeString(buf, kind)
 		case "Seq":
 			req.Seq = bson.DecodeUint64(buf, kind)
+		case "TraceID":
+			req.TraceID = bson.DecodeUint64(buf, kind)
+		case "SpanID":
+			req.SpanID = bson.DecodeUint64(buf, kind)
 		default:
 			bson.Skip(buf, kind)
 		}
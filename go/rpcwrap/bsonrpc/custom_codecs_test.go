@@ -69,6 +69,52 @@ func TestRequestBson(t *testing.T) {
 	}
 }
 
+type reflectTracedRequestBson struct {
+	ServiceMethod string
+	Seq           uint64
+	TraceID       uint64
+	SpanID        uint64
+}
+
+func TestTracedRequestBson(t *testing.T) {
+	reflected, err := bson.Marshal(&reflectTracedRequestBson{
+		ServiceMethod: "aa",
+		Seq:           1,
+		TraceID:       2,
+		SpanID:        3,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	want := string(reflected)
+
+	custom := RequestBson{
+		&rpc.Request{
+			ServiceMethod: "aa",
+			Seq:           1,
+			TraceID:       2,
+			SpanID:        3,
+		},
+	}
+	encoded, err := bson.Marshal(&custom)
+	if err != nil {
+		t.Error(err)
+	}
+	got := string(encoded)
+	if want != got {
+		t.Errorf("want\n%#v, got\n%#v", want, got)
+	}
+
+	unmarshalled := RequestBson{Request: new(rpc.Request)}
+	err = bson.Unmarshal(encoded, &unmarshalled)
+	if err != nil {
+		t.Error(err)
+	}
+	if unmarshalled.TraceID != 2 || unmarshalled.SpanID != 3 {
+		t.Errorf("want trace 2 span 3, got %#v", unmarshalled.Request)
+	}
+}
+
 type reflectResponseBson struct {
 	ServiceMethod string
 	Seq           uint64
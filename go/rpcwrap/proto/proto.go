@@ -3,11 +3,16 @@ package proto
 import (
 	"fmt"
 	"html/template"
+
+	"github.com/youtube/vitess/go/trace"
 )
 
 type Context struct {
 	RemoteAddr string
 	Username   string
+
+	// span is the trace span of the request, see WithSpan
+	span *trace.Span
 }
 
 // GetRemoteAddr implements Context.GetRemoteAddr
@@ -20,6 +25,19 @@ func (ctx *Context) GetUsername() string {
 	return ctx.Username
 }
 
+// Span returns the trace span of the request, or nil.
+func (ctx *Context) Span() *trace.Span {
+	return ctx.span
+}
+
+// WithSpan returns a copy of the connection context for a request
+// traced by span. It implements rpcplus.SpanContext.
+func (ctx *Context) WithSpan(span *trace.Span) interface{} {
+	result := *ctx
+	result.span = span
+	return &result
+}
+
 // HTML implements Context.HTML
 func (ctx *Context) HTML() template.HTML {
 	result := "<b>RemoteAddr:</b> " + ctx.RemoteAddr + "</br>\n"
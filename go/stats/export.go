@@ -31,36 +31,32 @@ type NewVarHook func(name string, v expvar.Var)
 
 type varGroup struct {
 	sync.Mutex
-	vars       map[string]expvar.Var
-	newVarHook NewVarHook
+	vars        map[string]expvar.Var
+	newVarHooks []NewVarHook
 }
 
 func (vg *varGroup) register(nvh NewVarHook) {
 	vg.Lock()
 	defer vg.Unlock()
-	if vg.newVarHook != nil {
-		panic("You've already registered a function")
-	}
 	if nvh == nil {
 		panic("nil not allowed")
 	}
-	vg.newVarHook = nvh
+	vg.newVarHooks = append(vg.newVarHooks, nvh)
 	// Call hook on existing vars because some might have been
 	// created before the call to register
 	for k, v := range vg.vars {
 		nvh(k, v)
 	}
-	vg.vars = nil
 }
 
 func (vg *varGroup) publish(name string, v expvar.Var) {
 	vg.Lock()
 	defer vg.Unlock()
 	expvar.Publish(name, v)
-	if vg.newVarHook != nil {
-		vg.newVarHook(name, v)
-	} else {
-		vg.vars[name] = v
+	// The vars are kept for the hooks registered later.
+	vg.vars[name] = v
+	for _, nvh := range vg.newVarHooks {
+		nvh(name, v)
 	}
 }
 
@@ -69,7 +65,8 @@ var defaultVarGroup = varGroup{vars: make(map[string]expvar.Var)}
 // Register allows you to register a callback function
 // that will be called whenever a new stats variable gets
 // created. This can be used to build alternate methods
-// of exporting stats variables.
+// of exporting stats variables. Several functions can be
+// registered, each is called for all the variables.
 func Register(nvh NewVarHook) {
 	defaultVarGroup.register(nvh)
 }
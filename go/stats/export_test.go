@@ -6,13 +6,37 @@ package stats
 
 import (
 	"expvar"
+	"reflect"
 	"testing"
 	"time"
 )
 
 func clear() {
 	defaultVarGroup.vars = make(map[string]expvar.Var)
-	defaultVarGroup.newVarHook = nil
+	defaultVarGroup.newVarHooks = nil
+}
+
+func TestRegisterTwice(t *testing.T) {
+	clear()
+	first := make(map[string]expvar.Var)
+	Register(func(name string, v expvar.Var) {
+		first[name] = v
+	})
+	v1 := NewInt("RegisterTwice1")
+	second := make(map[string]expvar.Var)
+	Register(func(name string, v expvar.Var) {
+		second[name] = v
+	})
+	v2 := NewInt("RegisterTwice2")
+
+	// both hooks get all the variables
+	want := map[string]expvar.Var{"RegisterTwice1": v1, "RegisterTwice2": v2}
+	if !reflect.DeepEqual(first, want) {
+		t.Errorf("first hook got %v, want %v", first, want)
+	}
+	if !reflect.DeepEqual(second, want) {
+		t.Errorf("second hook got %v, want %v", second, want)
+	}
 }
 
 func TestNoHook(t *testing.T) {
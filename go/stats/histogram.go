@@ -129,6 +129,12 @@ func (h *Histogram) Total() (total int64) {
 	return h.total
 }
 
+// Cutoffs returns the upper bounds of the buckets, the last bucket
+// has no upper bound.
+func (h *Histogram) Cutoffs() []int64 {
+	return h.cutoffs
+}
+
 func (h *Histogram) Labels() []string {
 	return h.labels
 }
@@ -0,0 +1,278 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package prometheus exports the stats variables in the Prometheus
+// text format. An Exporter is fed by registering its NewVarHook with
+// stats.Register, and serves the variables over http:
+//   - Int, Float, Duration (in seconds) and States (the current state)
+//     are untyped values.
+//   - Counters and Timings use a 'key' label for their names, the
+//     multidimensional versions use their own labels.
+//   - Histograms and Timings (in seconds) are histograms with
+//     cumulative buckets.
+//   - Rates are gauges with the latest rate.
+//
+// The other variables (strings, JSON) are not exported.
+//
+// The characters Prometheus doesn't allow in metric names are
+// replaced by '_'. If two variables end up with the same metric
+// name, only the first one is exported, the collision is logged and
+// reported by Collisions.
+package prometheus
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
+)
+
+// ContentType is the http content type of the Prometheus text format.
+const ContentType = "text/plain; version=0.0.4"
+
+// Exporter keeps track of the stats variables, and writes them in
+// the Prometheus text format.
+type Exporter struct {
+	mu sync.Mutex
+	// vars are the variables, and names their stats names, by
+	// metric name.
+	vars  map[string]expvar.Var
+	names map[string]string
+	// collisions are the messages about the variables that are
+	// not exported because of a metric name collision.
+	collisions []string
+}
+
+// NewExporter creates an Exporter. Use stats.Register(e.NewVarHook)
+// to feed it.
+func NewExporter() *Exporter {
+	return &Exporter{
+		vars:  make(map[string]expvar.Var),
+		names: make(map[string]string),
+	}
+}
+
+// NewVarHook records a new variable, it is a stats.NewVarHook.
+func (e *Exporter) NewVarHook(name string, v expvar.Var) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	metric := metricName(name)
+	if other, ok := e.names[metric]; ok && other != name {
+		msg := fmt.Sprintf("stats variable %v is not exported to Prometheus, its metric name %v is already used by %v", name, metric, other)
+		log.Error(msg)
+		e.collisions = append(e.collisions, msg)
+		return
+	}
+	e.vars[metric] = v
+	e.names[metric] = name
+}
+
+// Collisions returns a message for each variable that is not
+// exported because its metric name is already used.
+func (e *Exporter) Collisions() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	collisions := make([]string, len(e.collisions))
+	copy(collisions, e.collisions)
+	return collisions
+}
+
+// ServeHTTP is part of the http.Handler interface.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	e.Write(w)
+}
+
+// Write writes all the variables it can export, sorted by name.
+func (e *Exporter) Write(w io.Writer) error {
+	e.mu.Lock()
+	names := make([]string, 0, len(e.vars))
+	vars := make(map[string]expvar.Var, len(e.vars))
+	for name, v := range e.vars {
+		names = append(names, name)
+		vars[name] = v
+	}
+	e.mu.Unlock()
+	sort.Strings(names)
+
+	b := bytes.NewBuffer(make([]byte, 0, 4096))
+	for _, name := range names {
+		writeVar(b, name, vars[name])
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func writeVar(b *bytes.Buffer, name string, v expvar.Var) {
+	switch v := v.(type) {
+	case *stats.Int:
+		writeValue(b, name, float64(v.Get()))
+	case stats.IntFunc:
+		writeValue(b, name, float64(v()))
+	case *stats.Float:
+		writeValue(b, name, v.Get())
+	case stats.FloatFunc:
+		writeValue(b, name, v())
+	case *stats.Duration:
+		writeValue(b, name, v.Get().Seconds())
+	case stats.DurationFunc:
+		writeValue(b, name, v().Seconds())
+	case *stats.States:
+		writeValue(b, name, float64(v.Get()))
+	case *stats.Histogram:
+		fmt.Fprintf(b, "# TYPE %s histogram\n", name)
+		writeHistogram(b, name, nil, nil, v, 1)
+	case *stats.MultiTimings:
+		writeTimings(b, name, v.Labels(), v.Histograms())
+	case *stats.Timings:
+		writeTimings(b, name, []string{"key"}, v.Histograms())
+	case *stats.Rates:
+		writeRates(b, name, v.Get())
+	case stats.MultiTracker:
+		// MultiCounters and MultiCountersFunc
+		writeCounts(b, name, v.Labels(), v.Counts())
+	case stats.CountTracker:
+		// Counters and CountersFunc
+		writeCounts(b, name, []string{"key"}, v.Counts())
+	}
+}
+
+func writeValue(b *bytes.Buffer, name string, value float64) {
+	fmt.Fprintf(b, "# TYPE %s untyped\n", name)
+	fmt.Fprintf(b, "%s %s\n", name, formatFloat(value))
+}
+
+func writeCounts(b *bytes.Buffer, name string, labels []string, counts map[string]int64) {
+	fmt.Fprintf(b, "# TYPE %s untyped\n", name)
+	for _, key := range sortedKeys(counts) {
+		fmt.Fprintf(b, "%s%s %d\n", name, formatLabels(labels, splitKey(key, len(labels))), counts[key])
+	}
+}
+
+func writeRates(b *bytes.Buffer, name string, rates map[string][]float64) {
+	fmt.Fprintf(b, "# TYPE %s gauge\n", name)
+	keys := make([]string, 0, len(rates))
+	for key := range rates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values := rates[key]
+		if len(values) == 0 {
+			continue
+		}
+		// the latest rate is last
+		fmt.Fprintf(b, "%s%s %s\n", name, formatLabels([]string{"key"}, []string{key}), formatFloat(values[len(values)-1]))
+	}
+}
+
+// writeTimings exports the histograms of a Timings, converting
+// nanoseconds to seconds.
+func writeTimings(b *bytes.Buffer, name string, labels []string, histograms map[string]*stats.Histogram) {
+	fmt.Fprintf(b, "# TYPE %s histogram\n", name)
+	keys := make([]string, 0, len(histograms))
+	for key := range histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeHistogram(b, name, labels, splitKey(key, len(labels)), histograms[key], 1e9)
+	}
+}
+
+// writeHistogram writes the cumulative buckets, sum and count of h.
+// Its cutoffs and total are divided by scale.
+func writeHistogram(b *bytes.Buffer, name string, labels, values []string, h *stats.Histogram, scale float64) {
+	counts := h.Counts()
+	cutoffs := h.Cutoffs()
+	bucketLabels := append(append([]string(nil), labels...), "le")
+	cumulative := int64(0)
+	for i, label := range h.Labels() {
+		cumulative += counts[label]
+		le := math.Inf(1)
+		if i < len(cutoffs) {
+			le = float64(cutoffs[i]) / scale
+		}
+		bucketValues := append(append([]string(nil), values...), formatFloat(le))
+		fmt.Fprintf(b, "%s_bucket%s %d\n", name, formatLabels(bucketLabels, bucketValues), cumulative)
+	}
+	fmt.Fprintf(b, "%s_sum%s %s\n", name, formatLabels(labels, values), formatFloat(float64(h.Total())/scale))
+	fmt.Fprintf(b, "%s_count%s %d\n", name, formatLabels(labels, values), cumulative)
+}
+
+// splitKey splits a multidimensional key in its n values. The last
+// value keeps the extra dots, if any.
+func splitKey(key string, n int) []string {
+	if n == 1 {
+		return []string{key}
+	}
+	values := strings.SplitN(key, ".", n)
+	for len(values) < n {
+		values = append(values, "")
+	}
+	return values
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, len(labels))
+	for i, label := range labels {
+		parts[i] = fmt.Sprintf("%s=\"%s\"", sanitize(label, false), labelValueEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func metricName(name string) string {
+	return sanitize(name, true)
+}
+
+// sanitize replaces the characters Prometheus doesn't allow in
+// metric names (with colons) or label names (without) by '_'.
+func sanitize(name string, allowColon bool) string {
+	result := []byte(name)
+	for i, c := range result {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		case c == ':' && allowColon:
+		default:
+			result[i] = '_'
+		}
+	}
+	return string(result)
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,154 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prometheus
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/stats"
+)
+
+func TestExporterValues(t *testing.T) {
+	e := NewExporter()
+	i := new(stats.Int)
+	i.Set(12)
+	e.NewVarHook("Queries", i)
+	d := new(stats.Duration)
+	d.Set(1500 * time.Millisecond)
+	e.NewVarHook("Uptime", d)
+	e.NewVarHook("Load.Avg", stats.FloatFunc(func() float64 { return 0.25 }))
+	e.NewVarHook("Version", stats.StringFunc(func() string { return "ignored" }))
+
+	b := new(bytes.Buffer)
+	if err := e.Write(b); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	want := `# TYPE Load_Avg untyped
+Load_Avg 0.25
+# TYPE Queries untyped
+Queries 12
+# TYPE Uptime untyped
+Uptime 1.5
+`
+	if b.String() != want {
+		t.Errorf("want\n%v\ngot\n%v", want, b.String())
+	}
+}
+
+func TestExporterCounters(t *testing.T) {
+	e := NewExporter()
+	c := stats.NewCounters("")
+	c.Add("select", 3)
+	c.Add("in\"sert", 1)
+	e.NewVarHook("Counts", c)
+	mc := stats.NewMultiCounters("", []string{"Table", "Plan"})
+	mc.Add([]string{"t1", "PASS_SELECT"}, 2)
+	mc.Add([]string{"t2", "PK.IN"}, 5)
+	e.NewVarHook("TableCounts", mc)
+
+	b := new(bytes.Buffer)
+	if err := e.Write(b); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	want := `# TYPE Counts untyped
+Counts{key="in\"sert"} 1
+Counts{key="select"} 3
+# TYPE TableCounts untyped
+TableCounts{Table="t1",Plan="PASS_SELECT"} 2
+TableCounts{Table="t2",Plan="PK.IN"} 5
+`
+	if b.String() != want {
+		t.Errorf("want\n%v\ngot\n%v", want, b.String())
+	}
+}
+
+func TestExporterHistograms(t *testing.T) {
+	e := NewExporter()
+	h := stats.NewHistogram("", []int64{1, 5})
+	for _, v := range []int64{1, 2, 3, 10} {
+		h.Add(v)
+	}
+	e.NewVarHook("Sizes", h)
+	mt := stats.NewMultiTimings("", []string{"Keyspace", "Shard"})
+	mt.Add([]string{"ks", "0"}, 2*time.Millisecond)
+	e.NewVarHook("Queries", mt)
+
+	b := new(bytes.Buffer)
+	if err := e.Write(b); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	want := `# TYPE Queries histogram
+Queries_bucket{Keyspace="ks",Shard="0",le="0.0005"} 0
+Queries_bucket{Keyspace="ks",Shard="0",le="0.001"} 0
+Queries_bucket{Keyspace="ks",Shard="0",le="0.005"} 1
+Queries_bucket{Keyspace="ks",Shard="0",le="0.01"} 1
+Queries_bucket{Keyspace="ks",Shard="0",le="0.05"} 1
+Queries_bucket{Keyspace="ks",Shard="0",le="0.1"} 1
+Queries_bucket{Keyspace="ks",Shard="0",le="0.5"} 1
+Queries_bucket{Keyspace="ks",Shard="0",le="1"} 1
+Queries_bucket{Keyspace="ks",Shard="0",le="5"} 1
+Queries_bucket{Keyspace="ks",Shard="0",le="10"} 1
+Queries_bucket{Keyspace="ks",Shard="0",le="+Inf"} 1
+Queries_sum{Keyspace="ks",Shard="0"} 0.002
+Queries_count{Keyspace="ks",Shard="0"} 1
+# TYPE Sizes histogram
+Sizes_bucket{le="1"} 1
+Sizes_bucket{le="5"} 3
+Sizes_bucket{le="+Inf"} 4
+Sizes_sum 16
+Sizes_count 4
+`
+	if b.String() != want {
+		t.Errorf("want\n%v\ngot\n%v", want, b.String())
+	}
+}
+
+func TestExporterCollisions(t *testing.T) {
+	e := NewExporter()
+	first := new(stats.Int)
+	first.Set(1)
+	e.NewVarHook("Load.Avg", first)
+	second := new(stats.Int)
+	second.Set(2)
+	e.NewVarHook("Load_Avg", second)
+	// the same variable published again is not a collision
+	e.NewVarHook("Load.Avg", first)
+
+	b := new(bytes.Buffer)
+	if err := e.Write(b); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	want := `# TYPE Load_Avg untyped
+Load_Avg 1
+`
+	if b.String() != want {
+		t.Errorf("want\n%v\ngot\n%v", want, b.String())
+	}
+	collisions := e.Collisions()
+	if len(collisions) != 1 || !strings.Contains(collisions[0], "Load_Avg is not exported") || !strings.Contains(collisions[0], "already used by Load.Avg") {
+		t.Errorf("unexpected collisions: %v", collisions)
+	}
+}
+
+func TestExporterServeHTTP(t *testing.T) {
+	e := NewExporter()
+	i := new(stats.Int)
+	i.Set(1)
+	e.NewVarHook("Alive", i)
+
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, &http.Request{})
+	if got := w.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("want content type %v, got %v", ContentType, got)
+	}
+	if !strings.Contains(w.Body.String(), "Alive 1\n") {
+		t.Errorf("unexpected body: %v", w.Body.String())
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxSpansPerTrace limits the memory a single trace can use in a
+// Collector.
+const maxSpansPerTrace = 1000
+
+// Collector is an Exporter that keeps the Spans of the most recent
+// traces in memory, and displays them over http.
+type Collector struct {
+	mu       sync.Mutex
+	capacity int
+	traces   map[uint64][]*Span
+	// order has the trace IDs, oldest first
+	order []uint64
+}
+
+// NewCollector creates a Collector that remembers up to capacity
+// traces.
+func NewCollector(capacity int) *Collector {
+	return &Collector{
+		capacity: capacity,
+		traces:   make(map[uint64][]*Span),
+	}
+}
+
+// ExportSpan is part of the Exporter interface.
+func (c *Collector) ExportSpan(span *Span) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	spans, ok := c.traces[span.TraceID]
+	if !ok {
+		if len(c.order) >= c.capacity {
+			delete(c.traces, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.order = append(c.order, span.TraceID)
+	}
+	if len(spans) < maxSpansPerTrace {
+		c.traces[span.TraceID] = append(spans, span)
+	}
+}
+
+// Traces returns the Spans of the collected traces, most recent
+// trace first. The Spans of a trace are in tree order: a Span is
+// followed by its children, sorted by start time. Spans whose
+// parent is not in the Collector (it ran in another process, or is
+// not finished yet) are at the top.
+func (c *Collector) Traces() [][]*Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := make([][]*Span, 0, len(c.order))
+	for i := len(c.order) - 1; i >= 0; i-- {
+		result = append(result, treeOrder(c.traces[c.order[i]]))
+	}
+	return result
+}
+
+type byStart []*Span
+
+func (s byStart) Len() int           { return len(s) }
+func (s byStart) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byStart) Less(i, j int) bool { return s[i].Start.Before(s[j].Start) }
+
+// treeOrder sorts spans in tree order.
+func treeOrder(spans []*Span) []*Span {
+	byID := make(map[uint64]bool, len(spans))
+	for _, span := range spans {
+		byID[span.SpanID] = true
+	}
+	children := make(map[uint64][]*Span)
+	var roots []*Span
+	for _, span := range spans {
+		if byID[span.ParentID] {
+			children[span.ParentID] = append(children[span.ParentID], span)
+		} else {
+			roots = append(roots, span)
+		}
+	}
+
+	result := make([]*Span, 0, len(spans))
+	var walk func(level []*Span)
+	walk = func(level []*Span) {
+		sort.Stable(byStart(level))
+		for _, span := range level {
+			result = append(result, span)
+			walk(children[span.SpanID])
+		}
+	}
+	walk(roots)
+	return result
+}
+
+// depths returns the depth of each span in the tree.
+func depths(spans []*Span) map[*Span]int {
+	byID := make(map[uint64]*Span, len(spans))
+	for _, span := range spans {
+		byID[span.SpanID] = span
+	}
+	result := make(map[*Span]int, len(spans))
+	for _, span := range spans {
+		if parent, ok := byID[span.ParentID]; ok {
+			// the parent comes first in tree order
+			result[span] = result[parent] + 1
+		}
+	}
+	return result
+}
+
+var collectorTmpl = template.Must(template.New("traces").Funcs(template.FuncMap{
+	"stampMicro": func(t time.Time) string { return t.Format(time.StampMicro) },
+	"indent":     func(depth int) int { return depth * 20 },
+}).Parse(`<html>
+<head><title>Traces</title></head>
+<body>
+{{range .}}
+<table border="1" cellpadding="2">
+<tr><th>Span</th><th>Start</th><th>Duration</th><th>Annotations</th></tr>
+{{range .}}
+<tr>
+  <td style="padding-left: {{indent .Depth}}px">{{.Span.Name}}</td>
+  <td>{{stampMicro .Span.Start}}</td>
+  <td>{{.Span.Duration}}</td>
+  <td>{{range $key, $value := .Span.Annotations}}{{$key}}={{$value}} {{end}}</td>
+</tr>
+{{end}}
+</table>
+<br>
+{{end}}
+</body>
+</html>
+`))
+
+type spanRow struct {
+	Span  *Span
+	Depth int
+}
+
+// ServeHTTP displays the collected traces.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	traces := c.Traces()
+	rows := make([][]spanRow, len(traces))
+	for i, spans := range traces {
+		d := depths(spans)
+		rows[i] = make([]spanRow, len(spans))
+		for j, span := range spans {
+			rows[i][j] = spanRow{span, d[span]}
+		}
+	}
+	if err := collectorTmpl.Execute(w, rows); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package trace records the timing of the steps of a request, as it
+// goes through the servers. A trace is a tree of Spans: every Span
+// has a name, a start and an end time, and the ID of its parent.
+// The IDs of a Span are sent along with RPCs, so the server side
+// Span becomes a child of the client side Span.
+//
+// All the methods of Span accept a nil receiver, and do nothing:
+// a nil *Span is an untraced request. Only a sample of the requests
+// are traced, see SetSamplingRate.
+//
+// Finished Spans are sent to the registered Exporters.
+package trace
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Span is one timed step of a traced request.
+type Span struct {
+	TraceID  uint64
+	SpanID   uint64
+	ParentID uint64 // 0 for the root of the trace
+	Name     string
+	Start    time.Time
+	End      time.Time
+
+	mu          sync.Mutex
+	annotations map[string]string
+}
+
+// Exporter receives the finished Spans.
+type Exporter interface {
+	// ExportSpan is called once per Span, when it is finished.
+	ExportSpan(span *Span)
+}
+
+var (
+	mu           sync.Mutex
+	random       = rand.New(rand.NewSource(time.Now().UnixNano()))
+	samplingRate float64
+	exporters    []Exporter
+)
+
+// SetSamplingRate sets the fraction of the requests that start a
+// new trace, between 0 (none, the default) and 1 (all of them).
+func SetSamplingRate(rate float64) {
+	mu.Lock()
+	defer mu.Unlock()
+	samplingRate = rate
+}
+
+// RegisterExporter adds an Exporter for the finished Spans.
+func RegisterExporter(exporter Exporter) {
+	mu.Lock()
+	defer mu.Unlock()
+	exporters = append(exporters, exporter)
+}
+
+// newID returns a random non-zero ID. It returns 0 if the request
+// is not sampled and sample is true.
+func newID(sample bool) uint64 {
+	mu.Lock()
+	defer mu.Unlock()
+	if sample && (samplingRate <= 0 || random.Float64() >= samplingRate) {
+		return 0
+	}
+	for {
+		if id := uint64(random.Int63()); id != 0 {
+			return id
+		}
+	}
+}
+
+func newSpan(traceID, parentID uint64, name string) *Span {
+	return &Span{
+		TraceID:  traceID,
+		SpanID:   newID(false),
+		ParentID: parentID,
+		Name:     name,
+		Start:    time.Now(),
+	}
+}
+
+// StartTrace starts a new trace, if the request is sampled. It
+// returns the root Span, or nil.
+func StartTrace(name string) *Span {
+	traceID := newID(true)
+	if traceID == 0 {
+		return nil
+	}
+	return newSpan(traceID, 0, name)
+}
+
+// StartRemoteSpan starts the server side Span of a request. traceID
+// and parentID are the IDs sent by the client, see Span.IDs. If the
+// client didn't trace the request, it may start a new trace.
+func StartRemoteSpan(traceID, parentID uint64, name string) *Span {
+	if traceID == 0 {
+		return StartTrace(name)
+	}
+	return newSpan(traceID, parentID, name)
+}
+
+// NewChild starts a Span for a step of s.
+func (s *Span) NewChild(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return newSpan(s.TraceID, s.SpanID, name)
+}
+
+// IDs returns the trace and span IDs to send to a server, so its
+// Span becomes a child of s. They are 0 if s is nil.
+func (s *Span) IDs() (traceID, spanID uint64) {
+	if s == nil {
+		return 0, 0
+	}
+	return s.TraceID, s.SpanID
+}
+
+// Annotate attaches a key / value pair to s.
+func (s *Span) Annotate(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.annotations == nil {
+		s.annotations = make(map[string]string)
+	}
+	s.annotations[key] = value
+}
+
+// Annotations returns a copy of the annotations of s.
+func (s *Span) Annotations() map[string]string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make(map[string]string, len(s.annotations))
+	for key, value := range s.annotations {
+		result[key] = value
+	}
+	return result
+}
+
+// Duration returns how long s took, or has been running for if it is
+// not finished.
+func (s *Span) Duration() time.Duration {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.End.IsZero() {
+		return time.Now().Sub(s.Start)
+	}
+	return s.End.Sub(s.Start)
+}
+
+// Finish records the end time of s, and exports it. Only the first
+// call has an effect.
+func (s *Span) Finish() {
+	if s == nil || !s.setEnd() {
+		return
+	}
+	for _, exporter := range registeredExporters() {
+		exporter.ExportSpan(s)
+	}
+}
+
+// setEnd records the end time of s. It returns false if s was
+// already finished.
+func (s *Span) setEnd() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.End.IsZero() {
+		return false
+	}
+	s.End = time.Now()
+	return true
+}
+
+func registeredExporters() []Exporter {
+	mu.Lock()
+	defer mu.Unlock()
+	return exporters
+}
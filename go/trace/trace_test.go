@@ -0,0 +1,87 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNilSpan(t *testing.T) {
+	var s *Span
+	if child := s.NewChild("child"); child != nil {
+		t.Errorf("child of nil span: %v", child)
+	}
+	s.Annotate("key", "value")
+	s.Finish()
+	if traceID, spanID := s.IDs(); traceID != 0 || spanID != 0 {
+		t.Errorf("IDs of nil span: %v %v", traceID, spanID)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	defer SetSamplingRate(0)
+
+	SetSamplingRate(0)
+	if s := StartTrace("root"); s != nil {
+		t.Errorf("StartTrace with 0 rate returned %v", s)
+	}
+	if s := StartRemoteSpan(0, 0, "server"); s != nil {
+		t.Errorf("StartRemoteSpan of untraced request returned %v", s)
+	}
+	if s := StartRemoteSpan(12, 34, "server"); s == nil || s.TraceID != 12 || s.ParentID != 34 {
+		t.Errorf("StartRemoteSpan of traced request returned %v", s)
+	}
+
+	SetSamplingRate(1)
+	s := StartTrace("root")
+	if s == nil || s.TraceID == 0 || s.SpanID == 0 || s.ParentID != 0 {
+		t.Fatalf("StartTrace with rate 1 returned %v", s)
+	}
+	child := s.NewChild("child")
+	if child.TraceID != s.TraceID || child.ParentID != s.SpanID || child.SpanID == s.SpanID {
+		t.Errorf("bad child %v of %v", child, s)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	defer SetSamplingRate(0)
+	SetSamplingRate(1)
+
+	c := NewCollector(2)
+	for i := 0; i < 3; i++ {
+		root := StartTrace("root")
+		child := root.NewChild("child")
+		child.Annotate("shard", "-80")
+		grandChild := child.NewChild("grandchild")
+		// a child that started later, exported first
+		other := root.NewChild("other")
+		c.ExportSpan(other)
+		c.ExportSpan(grandChild)
+		c.ExportSpan(child)
+		c.ExportSpan(root)
+	}
+
+	traces := c.Traces()
+	if len(traces) != 2 {
+		t.Fatalf("want 2 traces, got %v", len(traces))
+	}
+	var names []string
+	for _, span := range traces[0] {
+		names = append(names, span.Name)
+	}
+	if got, want := strings.Join(names, ","), "root,child,grandchild,other"; got != want {
+		t.Errorf("want spans %v, got %v", want, got)
+	}
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, &http.Request{})
+	body := w.Body.String()
+	if !strings.Contains(body, "grandchild") || !strings.Contains(body, "shard=-80") {
+		t.Errorf("unexpected page: %v", body)
+	}
+}
@@ -21,6 +21,15 @@ type binlogConnStreamer struct {
 	dbname string
 	mysqld *mysqlctl.Mysqld
 
+	// keyspaceIdColumn is the column of the keyspace id. If it's set, the
+	// statements decoded from row based events have a keyspace_id comment.
+	keyspaceIdColumn string
+
+	// tables has the schemas of the tables of the row based events,
+	// loaded with loadTable. It's cleared by the DDLs.
+	tables    map[string]*rowsTable
+	loadTable func(dbname, table string) (*rowsTable, error)
+
 	svm  sync2.ServiceManager
 	conn *mysqlctl.SlaveConnection
 
@@ -39,6 +48,10 @@ func newBinlogConnStreamer(dbname string, mysqld *mysqlctl.Mysqld) BinlogStreame
 	return &binlogConnStreamer{
 		dbname: dbname,
 		mysqld: mysqld,
+		tables: make(map[string]*rowsTable),
+		loadTable: func(dbname, table string) (*rowsTable, error) {
+			return loadTableSchema(mysqld, dbname, table)
+		},
 	}
 }
 
@@ -86,12 +99,32 @@ func (bls *binlogConnStreamer) Stop() {
 	bls.svm.Stop()
 }
 
+// tableSchema returns the schema of a table of the database, and loads
+// it the first time.
+func (bls *binlogConnStreamer) tableSchema(name string) (*rowsTable, error) {
+	if table, ok := bls.tables[name]; ok {
+		return table, nil
+	}
+	table, err := bls.loadTable(bls.dbname, name)
+	if err != nil {
+		return nil, err
+	}
+	bls.tables[name] = table
+	return table, nil
+}
+
 // parseEvents processes the raw binlog dump stream from the server, one event
 // at a time, and groups them into transactions.
 func (bls *binlogConnStreamer) parseEvents(events <-chan proto.BinlogEvent, sendTransaction sendTransactionFunc) (err error) {
 	var statements []proto.Statement
 	var timestamp int64
 	var format proto.BinlogFormat
+	// tableMaps has the TABLE_MAP_EVENTs seen in the current transaction,
+	// that describe the tables of the following row events.
+	tableMaps := make(map[uint64]*proto.TableMap)
+	// The GTIDs of the events are added to the position we started
+	// from, see myproto.AddGTID.
+	bls.pos = bls.startPos
 
 	// A commit can be triggered either by a COMMIT query, or by an XID_EVENT.
 	commit := func() error {
@@ -107,6 +140,7 @@ func (bls *binlogConnStreamer) parseEvents(events <-chan proto.BinlogEvent, send
 			return fmt.Errorf("send reply error: %v", err)
 		}
 		statements = nil
+		tableMaps = make(map[uint64]*proto.TableMap)
 		bls.startPos = bls.pos
 		return nil
 	}
@@ -156,10 +190,13 @@ func (bls *binlogConnStreamer) parseEvents(events <-chan proto.BinlogEvent, send
 		// something special like GTID_EVENT (MariaDB, MySQL 5.6), or it could be
 		// an arbitrary event with a GTID in the header (Google MySQL).
 		if ev.HasGTID(format) {
-			bls.pos, err = ev.GTID(format)
+			gtid, err := ev.GTID(format)
 			if err != nil {
 				return fmt.Errorf("can't get GTID from binlog event: %v, event data: %#v", err, ev)
 			}
+			if bls.pos, err = myproto.AddGTID(bls.pos, gtid); err != nil {
+				return fmt.Errorf("can't add GTID %v to position %v: %v", gtid, bls.pos, err)
+			}
 			// If it's a dedicated GTID_EVENT, there's nothing else to do.
 			if ev.IsGTID() {
 				continue
@@ -189,6 +226,33 @@ func (bls *binlogConnStreamer) parseEvents(events <-chan proto.BinlogEvent, send
 				Category: proto.BL_SET,
 				Sql:      []byte(fmt.Sprintf("SET @@RAND_SEED1=%d, @@RAND_SEED2=%d", seed1, seed2)),
 			})
+		case ev.IsTableMap(): // TABLE_MAP_EVENT
+			tm, err := ev.TableMap(format)
+			if err != nil {
+				return fmt.Errorf("can't parse TABLE_MAP_EVENT: %v, event data: %#v", err, ev)
+			}
+			tableMaps[tm.TableID] = tm
+		case ev.IsWriteRows() || ev.IsUpdateRows() || ev.IsDeleteRows(): // *_ROWS_EVENT
+			tableID := ev.TableID(format)
+			tm, ok := tableMaps[tableID]
+			if !ok {
+				return fmt.Errorf("unknown table id %v in rows event: %#v", tableID, ev)
+			}
+			if tm.Database != bls.dbname {
+				continue
+			}
+			if statements == nil {
+				timestamp = int64(ev.Timestamp())
+			}
+			table, err := bls.tableSchema(tm.Name)
+			if err != nil {
+				return fmt.Errorf("can't get the schema of table %v: %v", tm.Name, err)
+			}
+			rowStatements, err := rowsStatements(ev, format, tm, table, bls.keyspaceIdColumn)
+			if err != nil {
+				return fmt.Errorf("can't decode rows event: %v, event data: %#v", err, ev)
+			}
+			statements = append(statements, rowStatements...)
 		case ev.IsQuery(): // QUERY_EVENT
 			// Remember the timestamp at the beginning of a transaction.
 			if statements == nil {
@@ -211,6 +275,8 @@ func (bls *binlogConnStreamer) parseEvents(events <-chan proto.BinlogEvent, send
 				statements = nil
 				bls.startPos = bls.pos
 			case proto.BL_DDL:
+				// The schema of the tables may have changed.
+				bls.tables = make(map[string]*rowsTable)
 				statements = append(statements, proto.Statement{
 					Category: proto.BL_SET,
 					Sql:      []byte(fmt.Sprintf("SET TIMESTAMP=%d", ev.Timestamp())),
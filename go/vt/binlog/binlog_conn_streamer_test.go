@@ -5,9 +5,11 @@
 package binlog
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -19,14 +21,18 @@ import (
 
 // sample Google MySQL event data
 var (
-	rotateEvent   = []byte{0x0, 0x0, 0x0, 0x0, 0x4, 0x88, 0xf3, 0x0, 0x0, 0x33, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x20, 0x0, 0x23, 0x3, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x76, 0x74, 0x2d, 0x30, 0x30, 0x30, 0x30, 0x30, 0x36, 0x32, 0x33, 0x34, 0x34, 0x2d, 0x62, 0x69, 0x6e, 0x2e, 0x30, 0x30, 0x30, 0x30, 0x30, 0x31}
-	formatEvent   = []byte{0x98, 0x68, 0xe9, 0x53, 0xf, 0x88, 0xf3, 0x0, 0x0, 0x66, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x4, 0x0, 0x35, 0x2e, 0x31, 0x2e, 0x36, 0x33, 0x2d, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2d, 0x6c, 0x6f, 0x67, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1b, 0x38, 0xd, 0x0, 0x8, 0x0, 0x12, 0x0, 0x4, 0x4, 0x4, 0x4, 0x12, 0x0, 0x0, 0x53, 0x0, 0x4, 0x1a, 0x8, 0x0, 0x0, 0x0, 0x8, 0x8, 0x8, 0x2}
-	beginEvent    = []byte{0x98, 0x68, 0xe9, 0x53, 0x2, 0x88, 0xf3, 0x0, 0x0, 0x58, 0x0, 0x0, 0x0, 0xc2, 0x0, 0x0, 0x0, 0x8, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x23, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x40, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x21, 0x0, 0x21, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x42, 0x45, 0x47, 0x49, 0x4e}
-	commitEvent   = []byte{0x98, 0x68, 0xe9, 0x53, 0x2, 0x88, 0xf3, 0x0, 0x0, 0x59, 0x0, 0x0, 0x0, 0xc2, 0x0, 0x0, 0x0, 0x8, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x23, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x40, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x21, 0x0, 0x21, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x43, 0x4f, 0x4d, 0x4d, 0x49, 0x54}
-	rollbackEvent = []byte{0x98, 0x68, 0xe9, 0x53, 0x2, 0x88, 0xf3, 0x0, 0x0, 0x5b, 0x0, 0x0, 0x0, 0xc2, 0x0, 0x0, 0x0, 0x8, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x23, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x40, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x21, 0x0, 0x21, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x52, 0x4f, 0x4c, 0x4c, 0x42, 0x41, 0x43, 0x4b}
-	insertEvent   = []byte{0x98, 0x68, 0xe9, 0x53, 0x2, 0x88, 0xf3, 0x0, 0x0, 0x9f, 0x0, 0x0, 0x0, 0x61, 0x1, 0x0, 0x0, 0x0, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x23, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x40, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x21, 0x0, 0x21, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x69, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x20, 0x69, 0x6e, 0x74, 0x6f, 0x20, 0x76, 0x74, 0x5f, 0x61, 0x28, 0x65, 0x69, 0x64, 0x2c, 0x20, 0x69, 0x64, 0x29, 0x20, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x20, 0x28, 0x31, 0x2c, 0x20, 0x31, 0x29, 0x20, 0x2f, 0x2a, 0x20, 0x5f, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x20, 0x76, 0x74, 0x5f, 0x61, 0x20, 0x28, 0x65, 0x69, 0x64, 0x20, 0x69, 0x64, 0x20, 0x29, 0x20, 0x28, 0x31, 0x20, 0x31, 0x20, 0x29, 0x3b, 0x20, 0x2a, 0x2f}
-	createEvent   = []byte{0x98, 0x68, 0xe9, 0x53, 0x2, 0x88, 0xf3, 0x0, 0x0, 0xca, 0x0, 0x0, 0x0, 0xed, 0x3, 0x0, 0x0, 0x0, 0x0, 0xa, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x40, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x8, 0x0, 0x8, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x20, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x20, 0x69, 0x66, 0x20, 0x6e, 0x6f, 0x74, 0x20, 0x65, 0x78, 0x69, 0x73, 0x74, 0x73, 0x20, 0x76, 0x74, 0x5f, 0x69, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x20, 0x28, 0xa, 0x69, 0x64, 0x20, 0x62, 0x69, 0x67, 0x69, 0x6e, 0x74, 0x20, 0x61, 0x75, 0x74, 0x6f, 0x5f, 0x69, 0x6e, 0x63, 0x72, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x2c, 0xa, 0x6d, 0x73, 0x67, 0x20, 0x76, 0x61, 0x72, 0x63, 0x68, 0x61, 0x72, 0x28, 0x36, 0x34, 0x29, 0x2c, 0xa, 0x70, 0x72, 0x69, 0x6d, 0x61, 0x72, 0x79, 0x20, 0x6b, 0x65, 0x79, 0x20, 0x28, 0x69, 0x64, 0x29, 0xa, 0x29, 0x20, 0x45, 0x6e, 0x67, 0x69, 0x6e, 0x65, 0x3d, 0x49, 0x6e, 0x6e, 0x6f, 0x44, 0x42}
-	xidEvent      = []byte{0x98, 0x68, 0xe9, 0x53, 0x10, 0x88, 0xf3, 0x0, 0x0, 0x23, 0x0, 0x0, 0x0, 0x4e, 0xa, 0x0, 0x0, 0x0, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x78, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
+	rotateEvent     = []byte{0x0, 0x0, 0x0, 0x0, 0x4, 0x88, 0xf3, 0x0, 0x0, 0x33, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x20, 0x0, 0x23, 0x3, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x76, 0x74, 0x2d, 0x30, 0x30, 0x30, 0x30, 0x30, 0x36, 0x32, 0x33, 0x34, 0x34, 0x2d, 0x62, 0x69, 0x6e, 0x2e, 0x30, 0x30, 0x30, 0x30, 0x30, 0x31}
+	formatEvent     = []byte{0x98, 0x68, 0xe9, 0x53, 0xf, 0x88, 0xf3, 0x0, 0x0, 0x66, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x4, 0x0, 0x35, 0x2e, 0x31, 0x2e, 0x36, 0x33, 0x2d, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2d, 0x6c, 0x6f, 0x67, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1b, 0x38, 0xd, 0x0, 0x8, 0x0, 0x12, 0x0, 0x4, 0x4, 0x4, 0x4, 0x12, 0x0, 0x0, 0x53, 0x0, 0x4, 0x1a, 0x8, 0x0, 0x0, 0x0, 0x8, 0x8, 0x8, 0x2}
+	beginEvent      = []byte{0x98, 0x68, 0xe9, 0x53, 0x2, 0x88, 0xf3, 0x0, 0x0, 0x58, 0x0, 0x0, 0x0, 0xc2, 0x0, 0x0, 0x0, 0x8, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x23, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x40, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x21, 0x0, 0x21, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x42, 0x45, 0x47, 0x49, 0x4e}
+	commitEvent     = []byte{0x98, 0x68, 0xe9, 0x53, 0x2, 0x88, 0xf3, 0x0, 0x0, 0x59, 0x0, 0x0, 0x0, 0xc2, 0x0, 0x0, 0x0, 0x8, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x23, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x40, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x21, 0x0, 0x21, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x43, 0x4f, 0x4d, 0x4d, 0x49, 0x54}
+	rollbackEvent   = []byte{0x98, 0x68, 0xe9, 0x53, 0x2, 0x88, 0xf3, 0x0, 0x0, 0x5b, 0x0, 0x0, 0x0, 0xc2, 0x0, 0x0, 0x0, 0x8, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x23, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x40, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x21, 0x0, 0x21, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x52, 0x4f, 0x4c, 0x4c, 0x42, 0x41, 0x43, 0x4b}
+	insertEvent     = []byte{0x98, 0x68, 0xe9, 0x53, 0x2, 0x88, 0xf3, 0x0, 0x0, 0x9f, 0x0, 0x0, 0x0, 0x61, 0x1, 0x0, 0x0, 0x0, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x23, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x40, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x21, 0x0, 0x21, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x69, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x20, 0x69, 0x6e, 0x74, 0x6f, 0x20, 0x76, 0x74, 0x5f, 0x61, 0x28, 0x65, 0x69, 0x64, 0x2c, 0x20, 0x69, 0x64, 0x29, 0x20, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x20, 0x28, 0x31, 0x2c, 0x20, 0x31, 0x29, 0x20, 0x2f, 0x2a, 0x20, 0x5f, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x20, 0x76, 0x74, 0x5f, 0x61, 0x20, 0x28, 0x65, 0x69, 0x64, 0x20, 0x69, 0x64, 0x20, 0x29, 0x20, 0x28, 0x31, 0x20, 0x31, 0x20, 0x29, 0x3b, 0x20, 0x2a, 0x2f}
+	createEvent     = []byte{0x98, 0x68, 0xe9, 0x53, 0x2, 0x88, 0xf3, 0x0, 0x0, 0xca, 0x0, 0x0, 0x0, 0xed, 0x3, 0x0, 0x0, 0x0, 0x0, 0xa, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x40, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x8, 0x0, 0x8, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x20, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x20, 0x69, 0x66, 0x20, 0x6e, 0x6f, 0x74, 0x20, 0x65, 0x78, 0x69, 0x73, 0x74, 0x73, 0x20, 0x76, 0x74, 0x5f, 0x69, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x20, 0x28, 0xa, 0x69, 0x64, 0x20, 0x62, 0x69, 0x67, 0x69, 0x6e, 0x74, 0x20, 0x61, 0x75, 0x74, 0x6f, 0x5f, 0x69, 0x6e, 0x63, 0x72, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x2c, 0xa, 0x6d, 0x73, 0x67, 0x20, 0x76, 0x61, 0x72, 0x63, 0x68, 0x61, 0x72, 0x28, 0x36, 0x34, 0x29, 0x2c, 0xa, 0x70, 0x72, 0x69, 0x6d, 0x61, 0x72, 0x79, 0x20, 0x6b, 0x65, 0x79, 0x20, 0x28, 0x69, 0x64, 0x29, 0xa, 0x29, 0x20, 0x45, 0x6e, 0x67, 0x69, 0x6e, 0x65, 0x3d, 0x49, 0x6e, 0x6e, 0x6f, 0x44, 0x42}
+	xidEvent        = []byte{0x98, 0x68, 0xe9, 0x53, 0x10, 0x88, 0xf3, 0x0, 0x0, 0x23, 0x0, 0x0, 0x0, 0x4e, 0xa, 0x0, 0x0, 0x0, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x78, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
+	tableMapEvent   = []byte{0x98, 0x68, 0xe9, 0x53, 0x13, 0x88, 0xf3, 0x0, 0x0, 0x46, 0x0, 0x0, 0x0, 0x9, 0x2, 0x0, 0x0, 0x0, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x42, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x10, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x4, 0x76, 0x74, 0x5f, 0x61, 0x0, 0x4, 0x8, 0xf, 0xf6, 0xc, 0x4, 0x40, 0x0, 0xa, 0x2, 0xe}
+	writeRowsEvent  = []byte{0x98, 0x68, 0xe9, 0x53, 0x17, 0x88, 0xf3, 0x0, 0x0, 0x4d, 0x0, 0x0, 0x0, 0x56, 0x2, 0x0, 0x0, 0x0, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x42, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x4, 0xf, 0x0, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x61, 0x62, 0x63, 0x80, 0x0, 0x4, 0xd2, 0x38, 0x95, 0xee, 0xe4, 0x65, 0x51, 0x12, 0x0, 0x0, 0xa, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0xff, 0xfb, 0x2d, 0xc7}
+	updateRowsEvent = []byte{0x98, 0x68, 0xe9, 0x53, 0x18, 0x88, 0xf3, 0x0, 0x0, 0x5a, 0x0, 0x0, 0x0, 0xb0, 0x2, 0x0, 0x0, 0x0, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x42, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x4, 0xf, 0xf, 0x0, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x61, 0x62, 0x63, 0x80, 0x0, 0x4, 0xd2, 0x38, 0x95, 0xee, 0xe4, 0x65, 0x51, 0x12, 0x0, 0x0, 0x0, 0x3, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x61, 0x62, 0x63, 0x80, 0x0, 0x4, 0xd2, 0x38, 0x95, 0xee, 0xe4, 0x65, 0x51, 0x12, 0x0, 0x0}
+	deleteRowsEvent = []byte{0x98, 0x68, 0xe9, 0x53, 0x19, 0x88, 0xf3, 0x0, 0x0, 0x33, 0x0, 0x0, 0x0, 0xe3, 0x2, 0x0, 0x0, 0x0, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x42, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x4, 0xf, 0xa, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0xff, 0xfb, 0x2d, 0xc7}
 )
 
 func sendTestEvents(channel chan<- proto.BinlogEvent, events [][]byte) {
@@ -477,3 +483,370 @@ func TestBinlogConnStreamerParseEventsCreate(t *testing.T) {
 		t.Errorf("binlogConnStreamer.parseEvents(): got %#v, want %#v", got, want)
 	}
 }
+
+// rowsTestTable is the schema of the table of the rows events.
+func rowsTestTable(dbname, name string) (*rowsTable, error) {
+	if dbname != "vt_test_keyspace" || name != "vt_a" {
+		return nil, fmt.Errorf("unknown table %v.%v", dbname, name)
+	}
+	table := newRowsTable(name)
+	table.addColumn("id", "bigint(20) unsigned")
+	table.addColumn("name", "varchar(64)")
+	table.addColumn("price", "decimal(10,2)")
+	table.addColumn("ts", "datetime")
+	table.PKColumns = []int{0}
+	return table, nil
+}
+
+func TestBinlogConnStreamerParseEventsRows(t *testing.T) {
+	input := [][]byte{
+		rotateEvent,
+		formatEvent,
+		beginEvent,
+		tableMapEvent,
+		writeRowsEvent,
+		updateRowsEvent,
+		deleteRowsEvent,
+		xidEvent,
+	}
+
+	bls := newBinlogConnStreamer("vt_test_keyspace", nil).(*binlogConnStreamer)
+	bls.loadTable = rowsTestTable
+	bls.keyspaceIdColumn = "id"
+	events := make(chan proto.BinlogEvent)
+
+	want := []proto.BinlogTransaction{
+		proto.BinlogTransaction{
+			Statements: []proto.Statement{
+				proto.Statement{Category: proto.BL_SET, Sql: []byte("SET TIMESTAMP=1407805592")},
+				proto.Statement{Category: proto.BL_DML, Sql: []byte("INSERT INTO `vt_a` (`id`, `name`, `price`, `ts`) VALUES (1, 'abc', 1234.56, '2014-08-11 15:30:45') /* EMD keyspace_id:1 */ /* _stream vt_a (id ) (1 ); */")},
+				proto.Statement{Category: proto.BL_DML, Sql: []byte("INSERT INTO `vt_a` (`id`, `name`, `price`, `ts`) VALUES (18446744073709551614, null, -1234.56, null) /* EMD keyspace_id:18446744073709551614 */ /* _stream vt_a (id ) (18446744073709551614 ); */")},
+				proto.Statement{Category: proto.BL_SET, Sql: []byte("SET TIMESTAMP=1407805592")},
+				proto.Statement{Category: proto.BL_DML, Sql: []byte("UPDATE `vt_a` SET `id` = 3, `name` = 'abc', `price` = 1234.56, `ts` = '2014-08-11 15:30:45' WHERE `id` = 1 /* EMD keyspace_id:3 */ /* _stream vt_a (id ) (1 ) (3 ); */")},
+				proto.Statement{Category: proto.BL_SET, Sql: []byte("SET TIMESTAMP=1407805592")},
+				proto.Statement{Category: proto.BL_DML, Sql: []byte("DELETE FROM `vt_a` WHERE `id` = 18446744073709551614 /* EMD keyspace_id:18446744073709551614 */ /* _stream vt_a (id ) (18446744073709551614 ); */")},
+			},
+			Timestamp: 1407805592,
+			GTIDField: myproto.GTIDField{
+				Value: myproto.GoogleGTID{GroupID: 0x0d}},
+		},
+	}
+	var got []proto.BinlogTransaction
+	sendTransaction := func(trans *proto.BinlogTransaction) error {
+		got = append(got, *trans)
+		return nil
+	}
+
+	go sendTestEvents(events, input)
+	bls.svm.Go(func(svm *sync2.ServiceManager) {
+		err := bls.parseEvents(events, sendTransaction)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	bls.svm.Wait()
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("binlogConnStreamer.parseEvents(): got %#v, want %#v", got, want)
+	}
+}
+
+func TestBinlogConnStreamerParseEventsRowsSchemaReload(t *testing.T) {
+	input := [][]byte{
+		rotateEvent,
+		formatEvent,
+		beginEvent,
+		tableMapEvent,
+		writeRowsEvent,
+		xidEvent,
+		beginEvent,
+		tableMapEvent,
+		deleteRowsEvent,
+		xidEvent,
+		createEvent,
+		beginEvent,
+		tableMapEvent,
+		deleteRowsEvent,
+		xidEvent,
+	}
+
+	bls := newBinlogConnStreamer("vt_test_keyspace", nil).(*binlogConnStreamer)
+	loads := 0
+	bls.loadTable = func(dbname, name string) (*rowsTable, error) {
+		loads++
+		return rowsTestTable(dbname, name)
+	}
+	events := make(chan proto.BinlogEvent)
+	sendTransaction := func(trans *proto.BinlogTransaction) error {
+		return nil
+	}
+
+	go sendTestEvents(events, input)
+	bls.svm.Go(func(svm *sync2.ServiceManager) {
+		err := bls.parseEvents(events, sendTransaction)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	bls.svm.Wait()
+
+	// the schema is loaded once, and again after the DDL
+	if loads != 2 {
+		t.Errorf("schema of vt_a loaded %v times, want 2", loads)
+	}
+}
+
+func TestBinlogConnStreamerParseEventsRowsSchemaChanged(t *testing.T) {
+	input := [][]byte{
+		rotateEvent,
+		formatEvent,
+		beginEvent,
+		tableMapEvent,
+		writeRowsEvent,
+		xidEvent,
+	}
+
+	testcases := []struct {
+		// change alters the current schema of vt_a, which is not
+		// the one of the events anymore.
+		change func(table *rowsTable)
+		want   string
+	}{
+		{
+			change: func(table *rowsTable) { table.addColumn("added", "int(11)") },
+			want:   "table vt_a has 5 columns, binlog event has 4: the table changed after the event",
+		},
+		{
+			change: func(table *rowsTable) { table.columnTypes[2] = "int(11)" },
+			want:   "column price of table vt_a is int(11), binlog event has type 246: the table changed after the event",
+		},
+	}
+	for _, tc := range testcases {
+		bls := newBinlogConnStreamer("vt_test_keyspace", nil).(*binlogConnStreamer)
+		bls.loadTable = func(dbname, name string) (*rowsTable, error) {
+			table, err := rowsTestTable(dbname, name)
+			if err != nil {
+				return nil, err
+			}
+			tc.change(table)
+			return table, nil
+		}
+		events := make(chan proto.BinlogEvent)
+		sent := 0
+		sendTransaction := func(trans *proto.BinlogTransaction) error {
+			sent++
+			return nil
+		}
+
+		go sendTestEvents(events, input)
+		bls.svm.Go(func(svm *sync2.ServiceManager) {
+			err := bls.parseEvents(events, sendTransaction)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("wrong error, got %v, want %v", err, tc.want)
+			}
+		})
+		bls.svm.Wait()
+		if sent != 0 {
+			t.Errorf("%v transactions sent, want none", sent)
+		}
+	}
+}
+
+func TestBinlogConnStreamerParseEventsRowsOtherDatabase(t *testing.T) {
+	input := [][]byte{
+		rotateEvent,
+		formatEvent,
+		beginEvent,
+		tableMapEvent,
+		writeRowsEvent,
+		xidEvent,
+	}
+
+	bls := newBinlogConnStreamer("vt_other_keyspace", nil).(*binlogConnStreamer)
+	events := make(chan proto.BinlogEvent)
+
+	want := []proto.BinlogTransaction{
+		proto.BinlogTransaction{
+			Timestamp: 1407805592,
+			GTIDField: myproto.GTIDField{
+				Value: myproto.GoogleGTID{GroupID: 0x0d}},
+		},
+	}
+	var got []proto.BinlogTransaction
+	sendTransaction := func(trans *proto.BinlogTransaction) error {
+		got = append(got, *trans)
+		return nil
+	}
+
+	go sendTestEvents(events, input)
+	bls.svm.Go(func(svm *sync2.ServiceManager) {
+		err := bls.parseEvents(events, sendTransaction)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	bls.svm.Wait()
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("binlogConnStreamer.parseEvents(): got %#v, want %#v", got, want)
+	}
+}
+
+func TestBinlogConnStreamerParseEventsRowsUnknownTable(t *testing.T) {
+	input := [][]byte{
+		rotateEvent,
+		formatEvent,
+		beginEvent,
+		writeRowsEvent,
+		xidEvent,
+	}
+
+	bls := newBinlogConnStreamer("vt_test_keyspace", nil).(*binlogConnStreamer)
+	events := make(chan proto.BinlogEvent)
+
+	want := "unknown table id 66 in rows event"
+	sendTransaction := func(trans *proto.BinlogTransaction) error {
+		return nil
+	}
+
+	go sendTestEvents(events, input)
+	bls.svm.Go(func(svm *sync2.ServiceManager) {
+		err := bls.parseEvents(events, sendTransaction)
+		if err == nil {
+			t.Errorf("expected error, got none")
+			return
+		}
+		if got := err.Error(); !strings.HasPrefix(got, want) {
+			t.Errorf("wrong error, got %#v, want %#v", got, want)
+		}
+	})
+	bls.svm.Wait()
+}
+
+// mysql56Event returns a MySQL 5.6 event with a 19 bytes header.
+func mysql56Event(eventType byte, data []byte) []byte {
+	ev := make([]byte, 19, 19+len(data))
+	ev[4] = eventType
+	binary.LittleEndian.PutUint32(ev[9:9+4], uint32(19+len(data)))
+	return append(ev, data...)
+}
+
+// mysql56GTIDEvent returns a GTID_LOG_EVENT for SID:gno.
+func mysql56GTIDEvent(sid myproto.SID, gno uint64) []byte {
+	data := make([]byte, 1+16+8)
+	copy(data[1:1+16], sid[:])
+	binary.LittleEndian.PutUint64(data[1+16:], gno)
+	return mysql56Event(33, data)
+}
+
+func TestBinlogConnStreamerParseEventsMysql56GTIDSet(t *testing.T) {
+	sid1 := myproto.SID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	sid2 := myproto.SID{16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31}
+	formatData := make([]byte, 2+50+4+1)
+	formatData[0] = 4
+	copy(formatData[2:], "5.6.20-log")
+	formatData[2+50+4] = 19
+	mysql56XIDEvent := mysql56Event(16, make([]byte, 8))
+	input := [][]byte{
+		mysql56Event(15, formatData),
+		mysql56GTIDEvent(sid1, 11),
+		mysql56XIDEvent,
+		mysql56GTIDEvent(sid2, 7),
+		mysql56XIDEvent,
+	}
+
+	// the start position has two SIDs, and gaps
+	bls := newBinlogConnStreamer("", nil).(*binlogConnStreamer)
+	bls.startPos = myproto.MustParseGTID("MySQL56", sid1.String()+":1-5:8-10,"+sid2.String()+":1-3")
+	events := make(chan proto.BinlogEvent)
+
+	want := []string{
+		sid1.String() + ":1-5:8-11," + sid2.String() + ":1-3",
+		sid1.String() + ":1-5:8-11," + sid2.String() + ":1-3:7",
+	}
+	var got []string
+	sendTransaction := func(trans *proto.BinlogTransaction) error {
+		got = append(got, trans.GTIDField.Value.String())
+		return nil
+	}
+
+	go func() {
+		for _, buf := range input {
+			events <- mysqlctl.NewMysql56BinlogEvent(buf)
+		}
+		close(events)
+	}()
+	bls.svm.Go(func(svm *sync2.ServiceManager) {
+		err := bls.parseEvents(events, sendTransaction)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	bls.svm.Wait()
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("binlogConnStreamer.parseEvents(): got %v, want %v", got, want)
+	}
+}
+
+func TestBinlogConnStreamerParseEventsRowsMysql56(t *testing.T) {
+	// a table with a TIMESTAMP column, from MySQL 5.6.20
+	tableMapEvent := []byte{0x9f, 0x52, 0xe9, 0x53, 0x13, 0x01, 0x00, 0x00, 0x00, 0x41, 0x00, 0x00, 0x00, 0x72, 0x03, 0x00, 0x00, 0x00, 0x00, 0x42, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x10, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x00, 0x04, 0x76, 0x74, 0x5f, 0x61, 0x00, 0x05, 0x08, 0x0f, 0xf6, 0x12, 0x11, 0x06, 0x40, 0x00, 0x0a, 0x02, 0x03, 0x00, 0x0e}
+	writeRowsEvent := []byte{0x9f, 0x52, 0xe9, 0x53, 0x1e, 0x01, 0x00, 0x00, 0x00, 0x4e, 0x00, 0x00, 0x00, 0xc0, 0x03, 0x00, 0x00, 0x00, 0x00, 0x42, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x02, 0x00, 0x05, 0x1f, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x61, 0x62, 0x63, 0x80, 0x00, 0x04, 0xd2, 0x38, 0x99, 0x93, 0x96, 0xf7, 0xad, 0x04, 0xce, 0x53, 0xe8, 0xe1, 0xa5, 0x0a, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0xff, 0xfb, 0x2d, 0xc7, 0x53, 0xe8, 0xe1, 0xa5}
+	formatData := make([]byte, 2+50+4+1)
+	formatData[0] = 4
+	copy(formatData[2:], "5.6.20-log")
+	formatData[2+50+4] = 19
+	sid := myproto.SID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	input := [][]byte{
+		mysql56Event(15, formatData),
+		mysql56GTIDEvent(sid, 1),
+		tableMapEvent,
+		writeRowsEvent,
+		mysql56Event(16, make([]byte, 8)),
+	}
+
+	bls := newBinlogConnStreamer("vt_test_keyspace", nil).(*binlogConnStreamer)
+	bls.startPos = myproto.MustParseGTID("MySQL56", "")
+	bls.loadTable = func(dbname, name string) (*rowsTable, error) {
+		table, err := rowsTestTable(dbname, name)
+		if err != nil {
+			return nil, err
+		}
+		table.Columns[3].Name = "created"
+		table.addColumn("updated", "timestamp")
+		return table, nil
+	}
+	events := make(chan proto.BinlogEvent)
+
+	want := []proto.Statement{
+		proto.Statement{Category: proto.BL_SET, Sql: []byte("SET TIMESTAMP=1407799967")},
+		proto.Statement{Category: proto.BL_SET, Sql: []byte("SET @@session.time_zone='+00:00'")},
+		proto.Statement{Category: proto.BL_DML, Sql: []byte("INSERT INTO `vt_a` (`id`, `name`, `price`, `created`, `updated`) VALUES (1, 'abc', 1234.56, '2014-08-11 15:30:45.123', '2014-08-11 15:30:45') /* _stream vt_a (id ) (1 ); */")},
+		proto.Statement{Category: proto.BL_DML, Sql: []byte("INSERT INTO `vt_a` (`id`, `name`, `price`, `created`, `updated`) VALUES (18446744073709551614, null, -1234.56, null, '2014-08-11 15:30:45') /* _stream vt_a (id ) (18446744073709551614 ); */")},
+		proto.Statement{Category: proto.BL_SET, Sql: []byte("SET @@session.time_zone=@@global.time_zone")},
+	}
+	var got []proto.Statement
+	sendTransaction := func(trans *proto.BinlogTransaction) error {
+		got = append(got, trans.Statements...)
+		return nil
+	}
+
+	go func() {
+		for _, buf := range input {
+			events <- mysqlctl.NewMysql56BinlogEvent(buf)
+		}
+		close(events)
+	}()
+	bls.svm.Go(func(svm *sync2.ServiceManager) {
+		err := bls.parseEvents(events, sendTransaction)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	bls.svm.Wait()
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("binlogConnStreamer.parseEvents(): got %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,398 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/binlog/proto"
+	"github.com/youtube/vitess/go/vt/mysqlctl"
+	"github.com/youtube/vitess/go/vt/schema"
+)
+
+// The statements decoded from the row based replication events write the
+// TIMESTAMP values in UTC. They set the time_zone of the session to UTC,
+// and back to the default of the server.
+var (
+	SET_TIME_ZONE_UTC     = []byte("SET @@session.time_zone='+00:00'")
+	SET_TIME_ZONE_DEFAULT = []byte("SET @@session.time_zone=@@global.time_zone")
+)
+
+// tableSchemaQuery gets the columns of a table in order, with their
+// position in the primary key.
+const tableSchemaQuery = "SELECT c.column_name, c.column_type, k.ordinal_position" +
+	" FROM information_schema.columns c LEFT JOIN information_schema.key_column_usage k" +
+	" ON k.table_schema = c.table_schema AND k.table_name = c.table_name" +
+	" AND k.column_name = c.column_name AND k.constraint_name = 'PRIMARY'" +
+	" WHERE c.table_schema = %s AND c.table_name = %s ORDER BY c.ordinal_position"
+
+// rowsTable is the schema of a table of the row based replication
+// events.
+type rowsTable struct {
+	*schema.Table
+
+	// columnTypes are the types of the columns, as in the
+	// column_type of information_schema.
+	columnTypes []string
+}
+
+func newRowsTable(name string) *rowsTable {
+	return &rowsTable{Table: schema.NewTable(name)}
+}
+
+func (table *rowsTable) addColumn(name, columnType string) {
+	table.AddColumn(name, columnType, sqltypes.NULL, "")
+	table.columnTypes = append(table.columnTypes, columnType)
+}
+
+// binlogTypes are the types a column can have in a TABLE_MAP_EVENT,
+// by the name of its type in information_schema. ENUM and SET columns
+// are written as MYSQL_TYPE_STRING (254), with their real type in the
+// metadata. The spatial types are all MYSQL_TYPE_GEOMETRY (255).
+var binlogTypes = map[string][]byte{
+	"tinyint":    {1},
+	"smallint":   {2},
+	"mediumint":  {9},
+	"int":        {3},
+	"integer":    {3},
+	"bigint":     {8},
+	"float":      {4},
+	"double":     {5},
+	"real":       {5},
+	"decimal":    {246, 0},
+	"numeric":    {246, 0},
+	"bit":        {16},
+	"year":       {13},
+	"date":       {10, 14},
+	"time":       {11, 19},
+	"datetime":   {12, 18},
+	"timestamp":  {7, 17},
+	"char":       {254},
+	"binary":     {254},
+	"enum":       {254, 247},
+	"set":        {254, 248},
+	"varchar":    {15, 253},
+	"varbinary":  {15, 253},
+	"tinytext":   {252, 249},
+	"text":       {252},
+	"mediumtext": {252, 250},
+	"longtext":   {252, 251},
+	"tinyblob":   {252, 249},
+	"blob":       {252},
+	"mediumblob": {252, 250},
+	"longblob":   {252, 251},
+	"geometry":   {255},
+	"point":      {255},
+	"linestring": {255},
+	"polygon":    {255},
+}
+
+// columnTypeMatches returns false if a column of type columnType
+// cannot have the type typ in a TABLE_MAP_EVENT. The unknown types
+// match any type.
+func columnTypeMatches(columnType string, typ byte) bool {
+	name := strings.ToLower(columnType)
+	if i := strings.IndexAny(name, "( "); i != -1 {
+		name = name[:i]
+	}
+	types, ok := binlogTypes[name]
+	if !ok {
+		return true
+	}
+	return bytes.IndexByte(types, typ) != -1
+}
+
+// loadTableSchema reads the column names and types, and the primary key
+// of a table from information_schema. The row based replication events
+// don't have them.
+func loadTableSchema(mysqld *mysqlctl.Mysqld, dbname, table string) (*rowsTable, error) {
+	conn, err := mysqld.GetDbaConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Recycle()
+	qr, err := conn.ExecuteFetch(fmt.Sprintf(tableSchemaQuery, encodeString(dbname), encodeString(table)), 10000, false)
+	if err != nil {
+		return nil, err
+	}
+	return newTableSchema(table, qr)
+}
+
+// newTableSchema builds the schema of a table from the result of
+// tableSchemaQuery.
+func newTableSchema(name string, qr *mproto.QueryResult) (*rowsTable, error) {
+	if len(qr.Rows) == 0 {
+		return nil, fmt.Errorf("unknown table %v", name)
+	}
+	table := newRowsTable(name)
+	var pk []int
+	for i, row := range qr.Rows {
+		table.addColumn(row[0].String(), row[1].String())
+		if row[2].IsNull() {
+			continue
+		}
+		position, err := strconv.Atoi(row[2].String())
+		if err != nil || position < 1 || position > len(qr.Rows) {
+			return nil, fmt.Errorf("invalid primary key position %v for column %v of table %v", row[2].String(), row[0].String(), name)
+		}
+		for len(pk) < position {
+			pk = append(pk, -1)
+		}
+		pk[position-1] = i
+	}
+	for _, index := range pk {
+		if index == -1 {
+			return nil, fmt.Errorf("incomplete primary key for table %v", name)
+		}
+	}
+	table.PKColumns = pk
+	return table, nil
+}
+
+func encodeString(s string) string {
+	buf := bytes.NewBuffer(nil)
+	sqltypes.MakeString([]byte(s)).EncodeSql(buf)
+	return buf.String()
+}
+
+// rowsStatements converts a WRITE_ROWS, UPDATE_ROWS or DELETE_ROWS event
+// into one DML statement per row. The statements have the comments vttablet
+// adds to the ones it executes, so the row based replication events go
+// through the keyrange and tables filters and the event streamer like the
+// statement based ones:
+//   - a '/* EMD keyspace_id:<id> */' comment if keyspaceIdColumn is set,
+//   - a '/* _stream <table> (<pk columns>) (<pk values>) ...; */' comment
+//     if the table has a primary key.
+//
+// table is the schema of the table, which the event doesn't have. It's
+// the current schema, loaded from the database when the streamer sees
+// the table for the first time, and after each DDL. It may not be the
+// schema the event was written with: the events written before a
+// change of the columns are refused when the number or the types of
+// their columns don't match the schema. The events written before a
+// rename or a reorder of columns of the same types can't be detected.
+func rowsStatements(ev proto.BinlogEvent, format proto.BinlogFormat, tm *proto.TableMap, table *rowsTable, keyspaceIdColumn string) ([]proto.Statement, error) {
+	if len(table.Columns) != len(tm.Types) {
+		return nil, fmt.Errorf("table %v has %v columns, binlog event has %v: the table changed after the event", tm.Name, len(table.Columns), len(tm.Types))
+	}
+	for i, typ := range tm.Types {
+		if !columnTypeMatches(table.columnTypes[i], typ) {
+			return nil, fmt.Errorf("column %v of table %v is %v, binlog event has type %v: the table changed after the event", table.Columns[i].Name, tm.Name, table.columnTypes[i], typ)
+		}
+	}
+	tm.Unsigned = make([]bool, len(table.Columns))
+	for i, col := range table.Columns {
+		tm.Unsigned[i] = col.IsUnsigned
+	}
+
+	rows, err := ev.Rows(format, tm)
+	if err != nil {
+		return nil, err
+	}
+	keyspaceIdIndex := -1
+	if keyspaceIdColumn != "" {
+		keyspaceIdIndex = table.FindColumn(keyspaceIdColumn)
+	}
+
+	statements := make([]proto.Statement, 0, len(rows.Rows)+3)
+	statements = append(statements, proto.Statement{
+		Category: proto.BL_SET,
+		Sql:      []byte(fmt.Sprintf("SET TIMESTAMP=%d", ev.Timestamp())),
+	})
+	utc := tm.HasTimestamp()
+	if utc {
+		statements = append(statements, proto.Statement{Category: proto.BL_SET, Sql: SET_TIME_ZONE_UTC})
+	}
+	for _, row := range rows.Rows {
+		buf := bytes.NewBuffer(make([]byte, 0, 256))
+		var pkRows [][]sqltypes.Value
+		var keyspaceId sqltypes.Value
+		switch {
+		case ev.IsWriteRows():
+			fmt.Fprintf(buf, "INSERT INTO %s (", quoteName(table.Name))
+			writeColumnNames(buf, table, rows.DataColumns)
+			buf.WriteString(") VALUES (")
+			first := true
+			for i, v := range row.Data {
+				if !rows.DataColumns[i] {
+					continue
+				}
+				if !first {
+					buf.WriteString(", ")
+				}
+				first = false
+				v.EncodeSql(buf)
+			}
+			buf.WriteString(")")
+			pkRows = append(pkRows, pkValues(table, row.Data))
+			keyspaceId = columnValue(row.Data, keyspaceIdIndex)
+		case ev.IsUpdateRows():
+			fmt.Fprintf(buf, "UPDATE %s SET ", quoteName(table.Name))
+			writeAssignments(buf, table, rows.DataColumns, row.Data, false)
+			buf.WriteString(" WHERE ")
+			if err := writeWhere(buf, table, rows.IdentifyColumns, row.Identify); err != nil {
+				return nil, err
+			}
+			// The columns that are not in the after image didn't change.
+			after := make([]sqltypes.Value, len(row.Data))
+			for i, v := range row.Data {
+				if rows.DataColumns[i] {
+					after[i] = v
+				} else {
+					after[i] = row.Identify[i]
+				}
+			}
+			pkBefore, pkAfter := pkValues(table, row.Identify), pkValues(table, after)
+			pkRows = append(pkRows, pkBefore)
+			if !valuesEqual(pkBefore, pkAfter) {
+				pkRows = append(pkRows, pkAfter)
+			}
+			keyspaceId = columnValue(after, keyspaceIdIndex)
+		default:
+			fmt.Fprintf(buf, "DELETE FROM %s WHERE ", quoteName(table.Name))
+			if err := writeWhere(buf, table, rows.IdentifyColumns, row.Identify); err != nil {
+				return nil, err
+			}
+			pkRows = append(pkRows, pkValues(table, row.Identify))
+			keyspaceId = columnValue(row.Identify, keyspaceIdIndex)
+		}
+
+		if !keyspaceId.IsNull() {
+			buf.WriteString(" ")
+			buf.Write(KEYSPACE_ID_COMMENT)
+			if keyspaceId.IsNumeric() {
+				buf.Write(keyspaceId.Raw())
+			} else {
+				buf.WriteString(base64.StdEncoding.EncodeToString(keyspaceId.Raw()))
+			}
+			buf.WriteString(" */")
+		}
+		if len(table.PKColumns) != 0 {
+			writeStreamComment(buf, table, pkRows)
+		}
+		statements = append(statements, proto.Statement{Category: proto.BL_DML, Sql: buf.Bytes()})
+	}
+	if utc {
+		statements = append(statements, proto.Statement{Category: proto.BL_SET, Sql: SET_TIME_ZONE_DEFAULT})
+	}
+	return statements, nil
+}
+
+func quoteName(name string) string {
+	return "`" + name + "`"
+}
+
+func writeColumnNames(buf *bytes.Buffer, table *rowsTable, columns []bool) {
+	first := true
+	for i, col := range table.Columns {
+		if !columns[i] {
+			continue
+		}
+		if !first {
+			buf.WriteString(", ")
+		}
+		first = false
+		buf.WriteString(quoteName(col.Name))
+	}
+}
+
+// writeAssignments writes 'col = value' for the columns of the image.
+// In a WHERE clause, they are separated by AND and the NULL values are
+// matched with IS NULL.
+func writeAssignments(buf *bytes.Buffer, table *rowsTable, columns []bool, values []sqltypes.Value, where bool) {
+	first := true
+	for i, col := range table.Columns {
+		if !columns[i] {
+			continue
+		}
+		if !first {
+			if where {
+				buf.WriteString(" AND ")
+			} else {
+				buf.WriteString(", ")
+			}
+		}
+		first = false
+		buf.WriteString(quoteName(col.Name))
+		if where && values[i].IsNull() {
+			buf.WriteString(" IS NULL")
+			continue
+		}
+		buf.WriteString(" = ")
+		values[i].EncodeSql(buf)
+	}
+}
+
+// writeWhere writes the condition that identifies the row: its primary
+// key, or all its columns if the table has no primary key.
+func writeWhere(buf *bytes.Buffer, table *rowsTable, columns []bool, values []sqltypes.Value) error {
+	if len(table.PKColumns) == 0 {
+		writeAssignments(buf, table, columns, values, true)
+		buf.WriteString(" LIMIT 1")
+		return nil
+	}
+	for i, index := range table.PKColumns {
+		if !columns[index] {
+			return fmt.Errorf("primary key column %v of table %v is not in the binlog event", table.Columns[index].Name, table.Name)
+		}
+		if i != 0 {
+			buf.WriteString(" AND ")
+		}
+		buf.WriteString(quoteName(table.Columns[index].Name))
+		buf.WriteString(" = ")
+		values[index].EncodeSql(buf)
+	}
+	return nil
+}
+
+// writeStreamComment writes the same comment as buildStreamComment
+// in tabletserver.
+func writeStreamComment(buf *bytes.Buffer, table *rowsTable, pkRows [][]sqltypes.Value) {
+	fmt.Fprintf(buf, " /* _stream %s (", table.Name)
+	for _, index := range table.PKColumns {
+		buf.WriteString(table.Columns[index].Name)
+		buf.WriteString(" ")
+	}
+	buf.WriteString(")")
+	for _, pkRow := range pkRows {
+		buf.WriteString(" (")
+		for _, v := range pkRow {
+			v.EncodeAscii(buf)
+			buf.WriteString(" ")
+		}
+		buf.WriteString(")")
+	}
+	buf.WriteString("; */")
+}
+
+func pkValues(table *rowsTable, values []sqltypes.Value) []sqltypes.Value {
+	pk := make([]sqltypes.Value, len(table.PKColumns))
+	for i, index := range table.PKColumns {
+		pk[i] = values[index]
+	}
+	return pk
+}
+
+func columnValue(values []sqltypes.Value, index int) sqltypes.Value {
+	if index < 0 {
+		return sqltypes.NULL
+	}
+	return values[index]
+}
+
+func valuesEqual(a, b []sqltypes.Value) bool {
+	for i := range a {
+		if !bytes.Equal(a[i].Raw(), b[i].Raw()) {
+			return false
+		}
+	}
+	return true
+}
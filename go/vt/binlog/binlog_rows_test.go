@@ -0,0 +1,93 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+)
+
+func tableSchemaResult(rows ...[]string) *mproto.QueryResult {
+	qr := &mproto.QueryResult{}
+	for _, row := range rows {
+		values := []sqltypes.Value{sqltypes.MakeString([]byte(row[0])), sqltypes.MakeString([]byte(row[1])), sqltypes.NULL}
+		if row[2] != "" {
+			values[2] = sqltypes.MakeNumeric([]byte(row[2]))
+		}
+		qr.Rows = append(qr.Rows, values)
+	}
+	return qr
+}
+
+func TestNewTableSchema(t *testing.T) {
+	// the primary key is (b, a)
+	qr := tableSchemaResult(
+		[]string{"a", "int(11)", "2"},
+		[]string{"b", "bigint(20) unsigned", "1"},
+		[]string{"c", "varchar(64)", ""},
+	)
+	table, err := newTableSchema("t", qr)
+	if err != nil {
+		t.Fatalf("newTableSchema failed: %v", err)
+	}
+	if want := []int{1, 0}; !reflect.DeepEqual(table.PKColumns, want) {
+		t.Errorf("PKColumns = %v, want %v", table.PKColumns, want)
+	}
+	var names []string
+	var unsigned []bool
+	for _, col := range table.Columns {
+		names = append(names, col.Name)
+		unsigned = append(unsigned, col.IsUnsigned)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("columns = %v, want %v", names, want)
+	}
+	if want := []bool{false, true, false}; !reflect.DeepEqual(unsigned, want) {
+		t.Errorf("unsigned = %v, want %v", unsigned, want)
+	}
+
+	testcases := []struct {
+		qr   *mproto.QueryResult
+		want string
+	}{
+		{tableSchemaResult(), "unknown table t"},
+		{tableSchemaResult([]string{"a", "int(11)", "3"}), "invalid primary key position 3"},
+		{tableSchemaResult([]string{"a", "int(11)", "2"}, []string{"b", "int(11)", ""}), "incomplete primary key"},
+	}
+	for _, tc := range testcases {
+		if _, err := newTableSchema("t", tc.qr); err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("newTableSchema(%v) = %v, want %v", tc.qr.Rows, err, tc.want)
+		}
+	}
+}
+
+func TestColumnTypeMatches(t *testing.T) {
+	testcases := []struct {
+		columnType string
+		typ        byte
+		want       bool
+	}{
+		{"int(11)", 3, true},
+		{"int(10) unsigned", 3, true},
+		{"bigint(20)", 3, false},
+		{"varchar(64)", 15, true},
+		{"varchar(64)", 252, false},
+		{"decimal(10,2)", 246, true},
+		{"datetime", 18, true},
+		{"TIMESTAMP", 17, true},
+		{"enum('a','b')", 254, true},
+		{"text", 15, false},
+		{"json", 245, true},
+	}
+	for _, tc := range testcases {
+		if got := columnTypeMatches(tc.columnType, tc.typ); got != tc.want {
+			t.Errorf("columnTypeMatches(%v, %v) = %v, want %v", tc.columnType, tc.typ, got, tc.want)
+		}
+	}
+}
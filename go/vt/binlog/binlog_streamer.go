@@ -52,6 +52,17 @@ func NewBinlogStreamer(dbname string, mysqld *mysqlctl.Mysqld) BinlogStreamer {
 	return fn(dbname, mysqld)
 }
 
+// NewKeyRangeBinlogStreamer creates a BinlogStreamer for the keyrange filter.
+// keyspaceIdColumn is the column that has the keyspace id, which the row based
+// replication events don't have a comment for.
+func NewKeyRangeBinlogStreamer(dbname string, mysqld *mysqlctl.Mysqld, keyspaceIdColumn string) BinlogStreamer {
+	bls := NewBinlogStreamer(dbname, mysqld)
+	if cs, ok := bls.(*binlogConnStreamer); ok {
+		cs.keyspaceIdColumn = keyspaceIdColumn
+	}
+	return bls
+}
+
 type newBinlogStreamerFunc func(string, *mysqlctl.Mysqld) BinlogStreamer
 
 // sendTransactionFunc is used to send binlog events.
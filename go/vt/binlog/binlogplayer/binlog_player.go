@@ -34,6 +34,10 @@ var (
 	// flags for the blp_checkpoint table. The database entry is just
 	// a join(",") of these flags.
 	BLP_FLAG_DONT_START = "DontStart"
+
+	// source_shard_uid used in the blp_checkpoint table by point in
+	// time recovery, out of the range of the SourceShards indexes.
+	BLP_POINT_IN_TIME_RECOVERY_UID uint32 = 0xFFFFFFFF
 )
 
 // BinlogPlayerStats is the internal stats of a player. It is a different
@@ -76,31 +80,36 @@ type BinlogPlayer struct {
 	dbClient VtClient
 
 	// for key range base requests
-	keyspaceIdType key.KeyspaceIdType
-	keyRange       key.KeyRange
+	keyspaceIdType   key.KeyspaceIdType
+	keyRange         key.KeyRange
+	keyspaceIdColumn string
 
 	// for table base requests
 	tables []string
 
 	// common to all
-	blpPos     proto.BlpPosition
-	stopAtGTID myproto.GTID
-	blplStats  *BinlogPlayerStats
+	blpPos          proto.BlpPosition
+	stopAtGTID      myproto.GTID
+	stopAtTimestamp int64
+	caughtUpGTID    myproto.GTID
+	blplStats       *BinlogPlayerStats
 }
 
 // NewBinlogPlayerKeyRange returns a new BinlogPlayer pointing at the server
 // replicating the provided keyrange, starting at the startPosition.GTID,
 // and updating _vt.blp_checkpoint with uid=startPosition.Uid.
+// keyspaceIdColumn is used to filter the row based replication events.
 // If stopAtGTID != nil, it will stop when reaching that GTID.
-func NewBinlogPlayerKeyRange(dbClient VtClient, addr string, keyspaceIdType key.KeyspaceIdType, keyRange key.KeyRange, startPosition *proto.BlpPosition, stopAtGTID myproto.GTID, blplStats *BinlogPlayerStats) *BinlogPlayer {
+func NewBinlogPlayerKeyRange(dbClient VtClient, addr string, keyspaceIdType key.KeyspaceIdType, keyRange key.KeyRange, keyspaceIdColumn string, startPosition *proto.BlpPosition, stopAtGTID myproto.GTID, blplStats *BinlogPlayerStats) *BinlogPlayer {
 	return &BinlogPlayer{
-		addr:           addr,
-		dbClient:       dbClient,
-		keyspaceIdType: keyspaceIdType,
-		keyRange:       keyRange,
-		blpPos:         *startPosition,
-		stopAtGTID:     stopAtGTID,
-		blplStats:      blplStats,
+		addr:             addr,
+		dbClient:         dbClient,
+		keyspaceIdType:   keyspaceIdType,
+		keyRange:         keyRange,
+		keyspaceIdColumn: keyspaceIdColumn,
+		blpPos:           *startPosition,
+		stopAtGTID:       stopAtGTID,
+		blplStats:        blplStats,
 	}
 }
 
@@ -119,6 +128,17 @@ func NewBinlogPlayerTables(dbClient VtClient, addr string, tables []string, star
 	}
 }
 
+// StopAtTimestamp makes the player stop before the first transaction
+// that happened after timestamp (in seconds since the epoch). The
+// transactions without a timestamp are always applied.
+// If caughtUpGTID != nil, it is a position of the source read after
+// timestamp had passed, and the player also stops once it reaches
+// it, instead of waiting for a later transaction that may never come.
+func (blp *BinlogPlayer) StopAtTimestamp(timestamp int64, caughtUpGTID myproto.GTID) {
+	blp.stopAtTimestamp = timestamp
+	blp.caughtUpGTID = caughtUpGTID
+}
+
 // writeRecoveryPosition will write the current GTID as the recovery position
 // for the next transaction.
 // We will also try to get the timestamp for the transaction. Two cases:
@@ -208,6 +228,32 @@ func (blp *BinlogPlayer) exec(sql string) (*mproto.QueryResult, error) {
 	return qr, err
 }
 
+// reachedPosition returns true if the player has executed all the
+// transactions up to gtid.
+func (blp *BinlogPlayer) reachedPosition(gtid myproto.GTID) (bool, error) {
+	pos, err := myproto.ToGTIDSet(blp.blpPos.GTIDField.Value)
+	if err != nil {
+		return false, fmt.Errorf("invalid position %v: %v", blp.blpPos.GTIDField, err)
+	}
+	stop, err := myproto.ToGTIDSet(gtid)
+	if err != nil {
+		return false, fmt.Errorf("invalid stopping point %v: %v", gtid, err)
+	}
+	return pos.AtLeast(stop)
+}
+
+// reachedStopPosition returns true if the player has executed all the
+// transactions up to stopAtGTID, or up to caughtUpGTID.
+func (blp *BinlogPlayer) reachedStopPosition() (bool, error) {
+	if blp.stopAtGTID != nil {
+		return blp.reachedPosition(blp.stopAtGTID)
+	}
+	if blp.caughtUpGTID != nil {
+		return blp.reachedPosition(blp.caughtUpGTID)
+	}
+	return false, nil
+}
+
 // ApplyBinlogEvents makes a gob rpc request to BinlogServer
 // and processes the events. It will return nil if 'interrupted'
 // was closed, or if we reached the stopping point.
@@ -232,21 +278,33 @@ func (blp *BinlogPlayer) ApplyBinlogEvents(interrupted chan struct{}) error {
 	}
 	if blp.stopAtGTID != nil {
 		// We need to stop at some point. Sanity check the point.
-		//
-		// TODO(enisoc): Stop trying to compare GTIDs, since we can't do that
-		// reliably for some MySQL flavors.
-		cmp, err := blp.blpPos.GTIDField.Value.TryCompare(blp.stopAtGTID)
+		reached, err := blp.reachedStopPosition()
 		if err != nil {
 			return err
 		}
-		if cmp > 0 { // blp.blpPos.GTIDField.Value > blp.stopAtGTID
-			return fmt.Errorf("starting point %v greater than stopping point %v", blp.blpPos.GTIDField, blp.stopAtGTID)
-		} else if cmp == 0 { // blp.blpPos.GTIDField.Value == blp.stopAtGTID
+		if reached {
+			if blp.blpPos.GTIDField.Value != blp.stopAtGTID {
+				return fmt.Errorf("starting point %v greater than stopping point %v", blp.blpPos.GTIDField, blp.stopAtGTID)
+			}
 			log.Infof("Not starting BinlogPlayer, we're already at the desired position %v", blp.stopAtGTID)
 			return nil
 		}
 		log.Infof("Will stop player when reaching %v", blp.stopAtGTID)
 	}
+	if blp.stopAtTimestamp != 0 {
+		if blp.caughtUpGTID != nil {
+			reached, err := blp.reachedStopPosition()
+			if err != nil {
+				return err
+			}
+			if reached {
+				log.Infof("Not starting BinlogPlayer, we're already caught up with the source at %v", blp.caughtUpGTID)
+				return nil
+			}
+			log.Infof("Will stop player when reaching %v", blp.caughtUpGTID)
+		}
+		log.Infof("Will stop player before the first transaction after %v", time.Unix(blp.stopAtTimestamp, 0))
+	}
 
 	binlogPlayerClientFactory, ok := binlogPlayerClientFactories[*binlogPlayerProtocol]
 	if !ok {
@@ -270,9 +328,10 @@ func (blp *BinlogPlayer) ApplyBinlogEvents(interrupted chan struct{}) error {
 		resp = blplClient.StreamTables(req, responseChan)
 	} else {
 		req := &proto.KeyRangeRequest{
-			KeyspaceIdType: blp.keyspaceIdType,
-			KeyRange:       blp.keyRange,
-			GTIDField:      blp.blpPos.GTIDField,
+			KeyspaceIdType:   blp.keyspaceIdType,
+			KeyRange:         blp.keyRange,
+			KeyspaceIdColumn: blp.keyspaceIdColumn,
+			GTIDField:        blp.blpPos.GTIDField,
 		}
 		resp = blplClient.StreamKeyRange(req, responseChan)
 	}
@@ -284,22 +343,23 @@ processLoop:
 			if !ok {
 				break processLoop
 			}
+			if blp.stopAtTimestamp != 0 && response.Timestamp > blp.stopAtTimestamp {
+				log.Infof("Reached a transaction after the stopping timestamp, done playing logs")
+				return nil
+			}
 			for {
 				ok, err = blp.processTransaction(response)
 				if err != nil {
 					return fmt.Errorf("Error in processing binlog event %v", err)
 				}
 				if ok {
-					if blp.stopAtGTID != nil {
-						// TODO(enisoc): Stop trying to compare GTIDs.
-						cmp, err := blp.blpPos.GTIDField.Value.TryCompare(blp.stopAtGTID)
-						if err != nil {
-							return err
-						}
-						if cmp >= 0 { // blp.blpPos.GTIDField.Value >= blp.stopAtGTID
-							log.Infof("Reached stopping position, done playing logs")
-							return nil
-						}
+					reached, err := blp.reachedStopPosition()
+					if err != nil {
+						return err
+					}
+					if reached {
+						log.Infof("Reached stopping position, done playing logs")
+						return nil
 					}
 					break
 				}
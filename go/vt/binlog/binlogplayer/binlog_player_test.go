@@ -7,6 +7,7 @@ package binlogplayer
 import (
 	"testing"
 
+	"github.com/youtube/vitess/go/vt/binlog/proto"
 	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
 )
 
@@ -53,3 +54,28 @@ func TestQueryBlpCheckpoint(t *testing.T) {
 		t.Errorf("QueryBlpCheckpoint(482821) = %#v, want %#v", got, want)
 	}
 }
+
+func TestStopAtTimestampCaughtUp(t *testing.T) {
+	start := &proto.BlpPosition{
+		Uid:       BLP_POINT_IN_TIME_RECOVERY_UID,
+		GTIDField: myproto.GTIDField{Value: myproto.GoogleGTID{GroupID: 12}},
+	}
+	blp := NewBinlogPlayerTables(nil, "", []string{"t1"}, start, nil, NewBinlogPlayerStats())
+
+	// without a source position, only a later transaction stops it
+	blp.StopAtTimestamp(1000, nil)
+	if reached, err := blp.reachedStopPosition(); err != nil || reached {
+		t.Errorf("reachedStopPosition() = (%v, %v), want (false, nil)", reached, err)
+	}
+
+	blp.StopAtTimestamp(1000, myproto.GoogleGTID{GroupID: 14})
+	if reached, err := blp.reachedStopPosition(); err != nil || reached {
+		t.Errorf("reachedStopPosition() behind the source = (%v, %v), want (false, nil)", reached, err)
+	}
+
+	// caught up with the source, it doesn't even connect
+	blp.StopAtTimestamp(1000, myproto.GoogleGTID{GroupID: 12})
+	if err := blp.ApplyBinlogEvents(make(chan struct{})); err != nil {
+		t.Errorf("ApplyBinlogEvents() caught up with the source = %v, want nil", err)
+	}
+}
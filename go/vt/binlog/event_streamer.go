@@ -67,6 +67,9 @@ func (evs *EventStreamer) transactionToEvent(trans *proto.BinlogTransaction) err
 				if insertid, err = strconv.ParseInt(string(stmt.Sql[BINLOG_SET_INSERT_LEN:]), 10, 64); err != nil {
 					return fmt.Errorf("%v: %s", err, stmt.Sql)
 				}
+			} else if bytes.Equal(stmt.Sql, SET_TIME_ZONE_UTC) || bytes.Equal(stmt.Sql, SET_TIME_ZONE_DEFAULT) {
+				// The events have the values of the statements as is.
+				continue
 			} else {
 				return fmt.Errorf("unrecognized: %s", stmt.Sql)
 			}
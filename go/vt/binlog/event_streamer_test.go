@@ -97,9 +97,15 @@ func TestDMLEvent(t *testing.T) {
 			}, {
 				Category: proto.BL_SET,
 				Sql:      []byte("SET INSERT_ID=10"),
+			}, {
+				Category: proto.BL_SET,
+				Sql:      SET_TIME_ZONE_UTC,
 			}, {
 				Category: proto.BL_DML,
 				Sql:      []byte("query /* _stream vtocc_e (eid id name)  (null -1 'bmFtZQ==' ) (null 18446744073709551615 'bmFtZQ==' ); */"),
+			}, {
+				Category: proto.BL_SET,
+				Sql:      SET_TIME_ZONE_DEFAULT,
 			}, {
 				Category: proto.BL_DML,
 				Sql:      []byte("query"),
@@ -5,6 +5,7 @@
 package proto
 
 import (
+	"github.com/youtube/vitess/go/sqltypes"
 	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
 )
 
@@ -43,6 +44,14 @@ type BinlogEvent interface {
 	IsIntVar() bool
 	// IsRand returns true if this is a RAND_EVENT.
 	IsRand() bool
+	// IsTableMap returns true if this is a TABLE_MAP_EVENT.
+	IsTableMap() bool
+	// IsWriteRows returns true if this is a WRITE_ROWS_EVENT (v1 or v2).
+	IsWriteRows() bool
+	// IsUpdateRows returns true if this is an UPDATE_ROWS_EVENT (v1 or v2).
+	IsUpdateRows() bool
+	// IsDeleteRows returns true if this is a DELETE_ROWS_EVENT (v1 or v2).
+	IsDeleteRows() bool
 	// HasGTID returns true if this event contains a GTID. That could either be
 	// because it's a GTID_EVENT (MariaDB, MySQL 5.6), or because it is some
 	// arbitrary event type that has a GTID in the header (Google MySQL).
@@ -66,6 +75,16 @@ type BinlogEvent interface {
 	// Rand returns the two seed values for a RAND_EVENT.
 	// This is only valid if IsRand() returns true.
 	Rand(BinlogFormat) (uint64, uint64, error)
+	// TableID returns the table ID of a TABLE_MAP_EVENT or of a rows event.
+	// This is only valid if IsTableMap() or one of the Is*Rows() returns true.
+	TableID(BinlogFormat) uint64
+	// TableMap returns the table description of a TABLE_MAP_EVENT.
+	// This is only valid if IsTableMap() returns true.
+	TableMap(BinlogFormat) (*TableMap, error)
+	// Rows returns the rows of a WRITE_ROWS_EVENT, UPDATE_ROWS_EVENT or
+	// DELETE_ROWS_EVENT, decoded with the TableMap of their table.
+	// This is only valid if one of the Is*Rows() returns true.
+	Rows(BinlogFormat, *TableMap) (Rows, error)
 }
 
 // BinlogFormat contains relevant data from the FORMAT_DESCRIPTION_EVENT.
@@ -84,3 +103,56 @@ type BinlogFormat struct {
 func (f BinlogFormat) IsZero() bool {
 	return f.FormatVersion == 0 && f.HeaderLength == 0
 }
+
+// TableMap describes a table, as sent by the TABLE_MAP_EVENT that precedes
+// the row events on the table.
+type TableMap struct {
+	TableID  uint64
+	Database string
+	Name     string
+
+	// Types are the MySQL types of the columns.
+	Types []byte
+	// Metadata is the type specific metadata of each column, like the
+	// maximum length of a VARCHAR or the precision of a DECIMAL.
+	Metadata []uint16
+	// CanBeNull tells which columns are nullable.
+	CanBeNull []bool
+
+	// Unsigned tells which integer columns are unsigned. It's not part
+	// of the event, the caller sets it from the table schema before
+	// decoding the rows.
+	Unsigned []bool
+}
+
+// HasTimestamp returns true if the table has TIMESTAMP columns
+// (MYSQL_TYPE_TIMESTAMP or MYSQL_TYPE_TIMESTAMP2), whose values are
+// stored in UTC.
+func (tm *TableMap) HasTimestamp() bool {
+	for _, typ := range tm.Types {
+		if typ == 7 || typ == 17 {
+			return true
+		}
+	}
+	return false
+}
+
+// Rows contains the rows of a WRITE_ROWS_EVENT, UPDATE_ROWS_EVENT or
+// DELETE_ROWS_EVENT.
+type Rows struct {
+	// IdentifyColumns tells which columns are in the Identify images,
+	// and DataColumns which ones are in the Data images.
+	IdentifyColumns []bool
+	DataColumns     []bool
+
+	Rows []Row
+}
+
+// Row is one row of a rows event. Identify is the row before the change
+// (UPDATE_ROWS_EVENT and DELETE_ROWS_EVENT), and Data the row after it
+// (WRITE_ROWS_EVENT and UPDATE_ROWS_EVENT). Both have one value per column
+// of the table, the columns that are not in the image are NULL.
+type Row struct {
+	Identify []sqltypes.Value
+	Data     []sqltypes.Value
+}
@@ -19,6 +19,9 @@ type KeyRangeRequest struct {
 	GTIDField      myproto.GTIDField
 	KeyspaceIdType key.KeyspaceIdType
 	KeyRange       key.KeyRange
+	// KeyspaceIdColumn is the column of the keyspace id, used to
+	// filter the row based replication events.
+	KeyspaceIdColumn string
 }
 
 // TablesRequest is used to make a request for StreamTables.
@@ -239,7 +239,7 @@ func (updateStream *UpdateStream) StreamKeyRange(req *proto.KeyRangeRequest, sen
 	defer streamCount.Add("KeyRange", -1)
 	log.Infof("ServeUpdateStream starting @ %#v", req.GTIDField.Value)
 
-	bls := NewBinlogStreamer(updateStream.dbname, updateStream.mysqld)
+	bls := NewKeyRangeBinlogStreamer(updateStream.dbname, updateStream.mysqld, req.KeyspaceIdColumn)
 	updateStream.streams.Add(bls)
 	defer updateStream.streams.Delete(bls)
 
@@ -0,0 +1,39 @@
+package context
+
+import "github.com/youtube/vitess/go/trace"
+
+// Span returns the trace span of the request ctx is for, or nil if
+// the request is not traced. A Context carries a span if it has a
+// Span() *trace.Span method.
+func Span(ctx Context) *trace.Span {
+	if sc, ok := ctx.(interface {
+		Span() *trace.Span
+	}); ok {
+		return sc.Span()
+	}
+	return nil
+}
+
+// WithSpan returns a Context that carries span, and delegates
+// everything else to ctx.
+func WithSpan(ctx Context, span *trace.Span) Context {
+	return &spanContext{Context: ctx, span: span}
+}
+
+// StartSpan starts a child of the span of ctx. It returns the new
+// span, and a Context that carries it. If ctx is not traced, it
+// returns nil and ctx.
+func StartSpan(ctx Context, name string) (*trace.Span, Context) {
+	span := Span(ctx).NewChild(name)
+	if span == nil {
+		return nil, ctx
+	}
+	return span, WithSpan(ctx, span)
+}
+
+type spanContext struct {
+	Context
+	span *trace.Span
+}
+
+func (sc *spanContext) Span() *trace.Span { return sc.span }
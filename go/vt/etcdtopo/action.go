@@ -0,0 +1,112 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdtopo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+/*
+This file contains the remote tablet action code of etcdtopo.Server
+*/
+
+// actionPathToTabletAlias parses an actionPath back.
+// actionPath is /vt/tablets/<cell>-<uid>/_Action/<index>
+func actionPathToTabletAlias(actionPath string) (topo.TabletAlias, error) {
+	pathParts := strings.Split(actionPath, "/")
+	if len(pathParts) != 6 || pathParts[0] != "" || pathParts[1] != "vt" || pathParts[2] != "tablets" || pathParts[4] != actionDirname {
+		return topo.TabletAlias{}, fmt.Errorf("invalid action path: %v", actionPath)
+	}
+	return topo.ParseTabletAliasString(pathParts[3])
+}
+
+// actionLogPathForActionPath returns the path of the response
+// for the given action.
+func actionLogPathForActionPath(actionPath string) string {
+	return strings.Replace(actionPath, "/"+actionDirname+"/", "/"+actionLogDirname+"/", 1)
+}
+
+// WriteTabletAction implements topo.Server.
+func (s *Server) WriteTabletAction(tabletAlias topo.TabletAlias, contents string) (string, error) {
+	cell, err := s.getCell(tabletAlias.Cell)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := cell.CreateInOrder(tabletActionDirPath(tabletAlias), contents, 0 /* ttl */)
+	if err != nil {
+		return "", convertError(err)
+	}
+	if resp.Node == nil {
+		return "", ErrBadResponse
+	}
+	return resp.Node.Key, nil
+}
+
+// WaitForTabletAction implements topo.Server.
+func (s *Server) WaitForTabletAction(actionPath string, waitTime time.Duration, interrupted chan struct{}) (string, error) {
+	tabletAlias, err := actionPathToTabletAlias(actionPath)
+	if err != nil {
+		return "", err
+	}
+	cell, err := s.getCell(tabletAlias.Cell)
+	if err != nil {
+		return "", err
+	}
+
+	timer := time.NewTimer(waitTime)
+	defer timer.Stop()
+
+	actionLogPath := actionLogPathForActionPath(actionPath)
+	for {
+		resp, err := cell.Get(actionLogPath, false /* sort */, false /* recursive */)
+		if err == nil {
+			if resp.Node == nil {
+				return "", ErrBadResponse
+			}
+			return resp.Node.Value, nil
+		}
+		if etcdErrorCode(err) != EcodeKeyNotFound {
+			return "", fmt.Errorf("action err: %v %v", actionLogPath, err)
+		}
+
+		// The response is not there yet, wait for it.
+		if err := waitForChange(cell, actionLogPath, etcdIndex(err)+1, false /* recursive */, timer.C, interrupted); err != nil {
+			return "", err
+		}
+	}
+}
+
+// PurgeTabletActions implements topo.Server.
+func (s *Server) PurgeTabletActions(tabletAlias topo.TabletAlias, canBePurged func(data string) bool) error {
+	cell, err := s.getCell(tabletAlias.Cell)
+	if err != nil {
+		return err
+	}
+
+	resp, err := cell.Get(tabletActionDirPath(tabletAlias), true /* sort */, false /* recursive */)
+	if err != nil {
+		return convertError(err)
+	}
+	if resp.Node == nil {
+		return ErrBadResponse
+	}
+
+	// Purge newer items first so the action queues don't try to process something.
+	for i := len(resp.Node.Nodes) - 1; i >= 0; i-- {
+		node := resp.Node.Nodes[i]
+		if !canBePurged(node.Value) {
+			continue
+		}
+		if _, err := cell.Delete(node.Key, true /* recursive */); err != nil && etcdErrorCode(err) != EcodeKeyNotFound {
+			return fmt.Errorf("PurgeTabletActions(%v) err: %v", node.Key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdtopo
+
+import (
+	"path"
+	"strconv"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+/*
+This file contains the code to support the local agent process for etcdtopo.Server
+*/
+
+// pidTTL is the TTL of the tablet pid node. It is refreshed every
+// pidTTL/3 while the tablet is running.
+var pidTTL = 30 * time.Second
+
+// ValidateTabletActions implements topo.Server.
+func (s *Server) ValidateTabletActions(tabletAlias topo.TabletAlias) error {
+	cell, err := s.getCell(tabletAlias.Cell)
+	if err != nil {
+		return err
+	}
+
+	// Ensure that the action directory is there. There is no conflict
+	// creating it.
+	if _, err := cell.CreateDir(tabletActionDirPath(tabletAlias), 0 /* ttl */); err != nil && etcdErrorCode(err) != EcodeNodeExist {
+		return err
+	}
+	return nil
+}
+
+// CreateTabletPidNode implements topo.Server.
+func (s *Server) CreateTabletPidNode(tabletAlias topo.TabletAlias, contents string, done chan struct{}) error {
+	cell, err := s.getCell(tabletAlias.Cell)
+	if err != nil {
+		return err
+	}
+
+	pidPath := path.Join(tabletDirPath(tabletAlias), pidFilename)
+	if _, err := cell.Set(pidPath, contents, uint64(pidTTL.Seconds())); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(pidTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				if _, err := cell.Delete(pidPath, false /* recursive */); err != nil {
+					log.Warningf("cannot delete pid node %v: %v", pidPath, err)
+				}
+				return
+			case <-ticker.C:
+			}
+			if _, err := cell.Set(pidPath, contents, uint64(pidTTL.Seconds())); err != nil {
+				log.Warningf("cannot refresh pid node %v, will try again: %v", pidPath, err)
+			}
+		}
+	}()
+	return nil
+}
+
+// ValidateTabletPidNode implements topo.Server.
+func (s *Server) ValidateTabletPidNode(tabletAlias topo.TabletAlias) error {
+	cell, err := s.getCell(tabletAlias.Cell)
+	if err != nil {
+		return err
+	}
+
+	_, err = cell.Get(path.Join(tabletDirPath(tabletAlias), pidFilename), false /* sort */, false /* recursive */)
+	return convertError(err)
+}
+
+// handleActionQueue processes all pending actions, until it can't
+// read one or one fails. No error is returned for action failures.
+// It returns the etcd index the queue was read at, so the caller can
+// watch for changes after it.
+func (s *Server) handleActionQueue(cell Client, tabletAlias topo.TabletAlias, dispatchAction func(actionPath, data string) error) (uint64, error) {
+	resp, err := cell.Get(tabletActionDirPath(tabletAlias), true /* sort */, false /* recursive */)
+	if err != nil {
+		return 0, err
+	}
+	if resp.Node == nil {
+		return 0, ErrBadResponse
+	}
+
+	for _, node := range resp.Node.Nodes {
+		if _, err := strconv.ParseUint(path.Base(node.Key), 10, 64); err != nil {
+			// This is handy if you want to restart a stuck queue.
+			log.Warningf("remove invalid event from action queue: %v", node.Key)
+			cell.Delete(node.Key, true /* recursive */)
+			continue
+		}
+
+		if err := dispatchAction(node.Key, node.Value); err != nil {
+			break
+		}
+	}
+	return resp.EtcdIndex, nil
+}
+
+// ActionEventLoop implements topo.Server.
+func (s *Server) ActionEventLoop(tabletAlias topo.TabletAlias, dispatchAction func(actionPath, data string) error, done chan struct{}) {
+	for {
+		// Process any pending actions when we startup, before
+		// we start listening for events.
+		cell, err := s.getCell(tabletAlias.Cell)
+		var index uint64
+		if err == nil {
+			index, err = s.handleActionQueue(cell, tabletAlias, dispatchAction)
+		}
+		if err != nil {
+			log.Warningf("failed to read the action queue, will try again in 5 seconds: %v", err)
+			select {
+			case <-time.After(5 * time.Second):
+				continue
+			case <-done:
+				return
+			}
+		}
+
+		// Wait for anything to change in the queue, and handle it.
+		if err := waitForChange(cell, tabletActionDirPath(tabletAlias), index+1, true /* recursive */, nil, done); err == topo.ErrInterrupted {
+			return
+		}
+	}
+}
+
+// ReadTabletActionPath implements topo.Server.
+func (s *Server) ReadTabletActionPath(actionPath string) (topo.TabletAlias, string, int64, error) {
+	tabletAlias, err := actionPathToTabletAlias(actionPath)
+	if err != nil {
+		return topo.TabletAlias{}, "", 0, err
+	}
+	cell, err := s.getCell(tabletAlias.Cell)
+	if err != nil {
+		return topo.TabletAlias{}, "", 0, err
+	}
+
+	resp, err := cell.Get(actionPath, false /* sort */, false /* recursive */)
+	if err != nil {
+		return topo.TabletAlias{}, "", 0, convertError(err)
+	}
+	if resp.Node == nil {
+		return topo.TabletAlias{}, "", 0, ErrBadResponse
+	}
+
+	return tabletAlias, resp.Node.Value, int64(resp.Node.ModifiedIndex), nil
+}
+
+// UpdateTabletAction implements topo.Server.
+func (s *Server) UpdateTabletAction(actionPath, data string, version int64) error {
+	tabletAlias, err := actionPathToTabletAlias(actionPath)
+	if err != nil {
+		return err
+	}
+	cell, err := s.getCell(tabletAlias.Cell)
+	if err != nil {
+		return err
+	}
+
+	if version == -1 {
+		_, err = cell.Update(actionPath, data, 0 /* ttl */)
+	} else {
+		_, err = cell.CompareAndSwap(actionPath, data, 0 /* ttl */, "" /* prevValue */, uint64(version))
+	}
+	return convertError(err)
+}
+
+// StoreTabletActionResponse implements topo.Server.
+// It stores the data both in action and actionlog.
+func (s *Server) StoreTabletActionResponse(actionPath, data string) error {
+	tabletAlias, err := actionPathToTabletAlias(actionPath)
+	if err != nil {
+		return err
+	}
+	cell, err := s.getCell(tabletAlias.Cell)
+	if err != nil {
+		return err
+	}
+
+	if _, err := cell.Update(actionPath, data, 0 /* ttl */); err != nil {
+		return convertError(err)
+	}
+	_, err = cell.Set(actionLogPathForActionPath(actionPath), data, 0 /* ttl */)
+	return convertError(err)
+}
+
+// UnblockTabletAction implements topo.Server.
+func (s *Server) UnblockTabletAction(actionPath string) error {
+	tabletAlias, err := actionPathToTabletAlias(actionPath)
+	if err != nil {
+		return err
+	}
+	cell, err := s.getCell(tabletAlias.Cell)
+	if err != nil {
+		return err
+	}
+
+	_, err = cell.Delete(actionPath, false /* recursive */)
+	return convertError(err)
+}
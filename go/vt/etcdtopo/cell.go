@@ -0,0 +1,26 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdtopo
+
+import (
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+/*
+This file contains the cell management methods of etcdtopo.Server
+*/
+
+// GetKnownCells implements topo.Server.
+func (s *Server) GetKnownCells() ([]string, error) {
+	resp, err := s.getGlobal().Get(cellsDirPath, true /* sort */, false /* recursive */)
+	if err != nil {
+		err = convertError(err)
+		if err == topo.ErrNoNode {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return getNodeNames(resp)
+}
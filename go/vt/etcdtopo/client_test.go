@@ -0,0 +1,353 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdtopo
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// fakeNode is a node in the in-memory tree of a fakeCluster.
+type fakeNode struct {
+	node     etcd.Node
+	children map[string]*fakeNode
+}
+
+func (fn *fakeNode) isDir() bool {
+	return fn.children != nil
+}
+
+// toNode returns a copy of the node as returned by etcd, with its
+// direct children (and their descendants if recursive is set).
+func (fn *fakeNode) toNode(withChildren, recursive bool) *etcd.Node {
+	n := fn.node
+	n.Nodes = nil
+	if fn.isDir() && withChildren {
+		for _, child := range fn.children {
+			n.Nodes = append(n.Nodes, child.toNode(recursive, recursive))
+		}
+		sort.Sort(n.Nodes)
+	}
+	return &n
+}
+
+// fakeEvent is a change that happened in a fakeCluster.
+type fakeEvent struct {
+	index    uint64
+	response *etcd.Response
+}
+
+// fakeCluster is an in-memory emulation of an etcd cluster, with the
+// semantics of the etcd v2 API that etcdtopo relies on. TTLs are
+// recorded, but nodes never expire.
+type fakeCluster struct {
+	mu      sync.Mutex
+	root    *fakeNode
+	index   uint64
+	events  []fakeEvent
+	changed chan struct{}
+}
+
+func newFakeCluster() *fakeCluster {
+	return &fakeCluster{
+		root: &fakeNode{
+			node:     etcd.Node{Key: "/", Dir: true},
+			children: make(map[string]*fakeNode),
+		},
+		changed: make(chan struct{}),
+	}
+}
+
+// fakeClient is a Client talking to a fakeCluster.
+type fakeClient struct {
+	*fakeCluster
+}
+
+// newFakeClientFactory returns a function to use as Server.newClient.
+// It returns a client to the same fakeCluster for the same addresses.
+func newFakeClientFactory() func(machines []string) Client {
+	var mu sync.Mutex
+	clusters := make(map[string]*fakeCluster)
+	return func(machines []string) Client {
+		mu.Lock()
+		defer mu.Unlock()
+		key := strings.Join(machines, ",")
+		c, ok := clusters[key]
+		if !ok {
+			c = newFakeCluster()
+			clusters[key] = c
+		}
+		return &fakeClient{c}
+	}
+}
+
+func (c *fakeCluster) newError(code int, key string) error {
+	return &etcd.EtcdError{
+		ErrorCode: code,
+		Message:   fmt.Sprintf("fake etcd error %v", code),
+		Cause:     key,
+		Index:     c.index,
+	}
+}
+
+func splitKey(key string) []string {
+	key = path.Clean("/" + key)
+	if key == "/" {
+		return nil
+	}
+	return strings.Split(key[1:], "/")
+}
+
+// find returns the node for key, and its parent.
+func (c *fakeCluster) find(key string) (node, parent *fakeNode) {
+	node = c.root
+	for _, name := range splitKey(key) {
+		if !node.isDir() {
+			return nil, nil
+		}
+		parent = node
+		node = node.children[name]
+		if node == nil {
+			return nil, parent
+		}
+	}
+	return node, parent
+}
+
+// mkdirs creates all the parent directories of key, and returns the
+// direct parent.
+func (c *fakeCluster) mkdirs(key string) (*fakeNode, error) {
+	node := c.root
+	names := splitKey(key)
+	for i, name := range names[:len(names)-1] {
+		child := node.children[name]
+		if child == nil {
+			c.index++
+			child = &fakeNode{
+				node: etcd.Node{
+					Key:           "/" + strings.Join(names[:i+1], "/"),
+					Dir:           true,
+					CreatedIndex:  c.index,
+					ModifiedIndex: c.index,
+				},
+				children: make(map[string]*fakeNode),
+			}
+			node.children[name] = child
+		}
+		if !child.isDir() {
+			return nil, c.newError(EcodeNotDir, child.node.Key)
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// record adds an event to the history, and wakes up the watchers.
+func (c *fakeCluster) record(action string, node, prevNode *etcd.Node) *etcd.Response {
+	resp := &etcd.Response{
+		Action:    action,
+		Node:      node,
+		PrevNode:  prevNode,
+		EtcdIndex: c.index,
+	}
+	c.events = append(c.events, fakeEvent{index: c.index, response: resp})
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return resp
+}
+
+// write creates or updates a file or a directory. mustExist and
+// mustNotExist control whether it is an update or a create.
+func (c *fakeCluster) write(action, key, value string, dir bool, ttl uint64, mustExist, mustNotExist bool) (*etcd.Response, error) {
+	key = path.Clean("/" + key)
+	existing, _ := c.find(key)
+	if existing != nil && mustNotExist {
+		return nil, c.newError(EcodeNodeExist, key)
+	}
+	if existing == nil && mustExist {
+		return nil, c.newError(EcodeKeyNotFound, key)
+	}
+	if existing != nil && existing.isDir() != dir {
+		return nil, c.newError(EcodeNotFile, key)
+	}
+
+	parent, err := c.mkdirs(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.index++
+	var prevNode *etcd.Node
+	if existing != nil {
+		prevNode = existing.toNode(false, false)
+		existing.node.Value = value
+		existing.node.TTL = int64(ttl)
+		existing.node.ModifiedIndex = c.index
+	} else {
+		existing = &fakeNode{
+			node: etcd.Node{
+				Key:           key,
+				Value:         value,
+				Dir:           dir,
+				TTL:           int64(ttl),
+				CreatedIndex:  c.index,
+				ModifiedIndex: c.index,
+			},
+		}
+		if dir {
+			existing.children = make(map[string]*fakeNode)
+		}
+		parent.children[path.Base(key)] = existing
+	}
+	return c.record(action, existing.toNode(false, false), prevNode), nil
+}
+
+func (c *fakeClient) Get(key string, sort, recursive bool) (*etcd.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, _ := c.find(key)
+	if node == nil {
+		return nil, c.newError(EcodeKeyNotFound, key)
+	}
+	return &etcd.Response{
+		Action:    "get",
+		Node:      node.toNode(true, recursive),
+		EtcdIndex: c.index,
+	}, nil
+}
+
+func (c *fakeClient) Set(key string, value string, ttl uint64) (*etcd.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write("set", key, value, false, ttl, false, false)
+}
+
+func (c *fakeClient) Create(key string, value string, ttl uint64) (*etcd.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write("create", key, value, false, ttl, false, true)
+}
+
+func (c *fakeClient) CreateDir(key string, ttl uint64) (*etcd.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write("create", key, "", true, ttl, false, true)
+}
+
+func (c *fakeClient) Update(key string, value string, ttl uint64) (*etcd.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write("update", key, value, false, ttl, true, false)
+}
+
+func (c *fakeClient) CreateInOrder(dir string, value string, ttl uint64) (*etcd.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := path.Join(dir, fmt.Sprintf("%020d", c.index+1))
+	return c.write("create", key, value, false, ttl, false, true)
+}
+
+func (c *fakeClient) CompareAndSwap(key string, value string, ttl uint64, prevValue string, prevIndex uint64) (*etcd.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, _ := c.find(key)
+	if node == nil {
+		return nil, c.newError(EcodeKeyNotFound, key)
+	}
+	if node.isDir() {
+		return nil, c.newError(EcodeNotFile, key)
+	}
+	if (prevValue != "" && prevValue != node.node.Value) || (prevIndex != 0 && prevIndex != node.node.ModifiedIndex) {
+		return nil, c.newError(EcodeTestFailed, key)
+	}
+	return c.write("compareAndSwap", key, value, false, ttl, true, false)
+}
+
+func (c *fakeClient) delete(key string, recursive bool) (*etcd.Response, error) {
+	key = path.Clean("/" + key)
+	node, parent := c.find(key)
+	if node == nil || parent == nil {
+		return nil, c.newError(EcodeKeyNotFound, key)
+	}
+	if node.isDir() && !recursive {
+		return nil, c.newError(EcodeNotFile, key)
+	}
+	delete(parent.children, path.Base(key))
+
+	c.index++
+	prevNode := node.toNode(false, false)
+	deleted := *prevNode
+	deleted.Value = ""
+	deleted.ModifiedIndex = c.index
+	return c.record("delete", &deleted, prevNode), nil
+}
+
+func (c *fakeClient) Delete(key string, recursive bool) (*etcd.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.delete(key, recursive)
+}
+
+func (c *fakeClient) CompareAndDelete(key string, prevValue string, prevIndex uint64) (*etcd.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, _ := c.find(key)
+	if node == nil {
+		return nil, c.newError(EcodeKeyNotFound, key)
+	}
+	if (prevValue != "" && prevValue != node.node.Value) || (prevIndex != 0 && prevIndex != node.node.ModifiedIndex) {
+		return nil, c.newError(EcodeTestFailed, key)
+	}
+	return c.delete(key, false)
+}
+
+// matches returns true if an event on eventKey should fire a watch
+// on key. Deleting a directory fires the watches on its children.
+func matches(key, eventKey, action string, recursive bool) bool {
+	switch {
+	case key == eventKey:
+		return true
+	case recursive && strings.HasPrefix(eventKey, key+"/"):
+		return true
+	case action == "delete" && strings.HasPrefix(key, eventKey+"/"):
+		return true
+	}
+	return false
+}
+
+func (c *fakeClient) Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error) {
+	if receiver != nil {
+		return nil, fmt.Errorf("fakeClient.Watch doesn't support receiver channels")
+	}
+	prefix = path.Clean("/" + prefix)
+	for {
+		c.mu.Lock()
+		for _, ev := range c.events {
+			if ev.index >= waitIndex && matches(prefix, ev.response.Node.Key, ev.response.Action, recursive) {
+				c.mu.Unlock()
+				return ev.response, nil
+			}
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-stop:
+			return nil, etcd.ErrWatchStoppedByUser
+		}
+	}
+}
+
+func (c *fakeClient) Close() {
+}
@@ -0,0 +1,148 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdtopo
+
+import (
+	"flag"
+	"path"
+
+	"github.com/youtube/vitess/go/flagutil"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+/*
+This file contains the flags and the key layout used by etcdtopo.Server.
+
+The global etcd cluster contains:
+  /vt/cells/<cell>                            comma separated addresses of the cell cluster
+  /vt/keyspaces/<keyspace>/_Data              topo.Keyspace
+  /vt/keyspaces/<keyspace>/_Lock              keyspace action lock
+  /vt/keyspaces/<keyspace>/_ActionLog/<index> keyspace action results
+  /vt/keyspaces/<keyspace>/shards/<shard>/... same layout for shards
+  /vt/keyspaces/<keyspace>/shards/<shard>/_QueryRules
+                                              query rules (JSON)
+  /vt/vschema                                 VSchema (JSON)
+
+Each cell cluster contains:
+  /vt/tablets/<alias>/_Data                   topo.Tablet
+  /vt/tablets/<alias>/_Action/<index>         queued tablet actions
+  /vt/tablets/<alias>/_ActionLog/<index>      tablet action results
+  /vt/tablets/<alias>/_Pid                    tablet pid node
+  /vt/replication/<keyspace>/<shard>          topo.ShardReplication
+  /vt/ns/<keyspace>/_Data                     topo.SrvKeyspace
+  /vt/ns/<keyspace>/<shard>/_Data             topo.SrvShard
+  /vt/ns/<keyspace>/<shard>/_Lock             serving shard action lock
+  /vt/ns/<keyspace>/<shard>/<tablet type>     topo.EndPoints
+*/
+
+var globalAddrs flagutil.StringListValue
+
+func init() {
+	flag.Var(&globalAddrs, "etcd_global_addrs", "comma-separated list of addresses (http://host:port) for the global etcd cluster")
+}
+
+const (
+	rootPath = "/vt"
+
+	cellsDirPath     = rootPath + "/cells"
+	keyspacesDirPath = rootPath + "/keyspaces"
+	tabletsDirPath   = rootPath + "/tablets"
+	replicationPath  = rootPath + "/replication"
+	servingDirPath   = rootPath + "/ns"
+	vschemaFilePath  = rootPath + "/vschema"
+
+	// dataFilename is the name of the file holding the object
+	// describing a directory (keyspace, shard, tablet, ...).
+	dataFilename = "_Data"
+
+	// lockFilename is the name of the file used to lock a directory.
+	lockFilename = "_Lock"
+
+	// actionDirname and actionLogDirname hold the action queue
+	// and the action results for a directory.
+	actionDirname    = "_Action"
+	actionLogDirname = "_ActionLog"
+
+	// electionDirname holds the election lock for a directory.
+	electionDirname = "_Election"
+
+	// pidFilename is the name of the tablet pid node.
+	pidFilename = "_Pid"
+
+	// queryRulesFilename holds the query rules of a shard.
+	queryRulesFilename = "_QueryRules"
+)
+
+func cellFilePath(cell string) string {
+	return path.Join(cellsDirPath, cell)
+}
+
+func keyspaceDirPath(keyspace string) string {
+	return path.Join(keyspacesDirPath, keyspace)
+}
+
+func keyspaceFilePath(keyspace string) string {
+	return path.Join(keyspaceDirPath(keyspace), dataFilename)
+}
+
+func shardsDirPath(keyspace string) string {
+	return path.Join(keyspaceDirPath(keyspace), "shards")
+}
+
+func shardDirPath(keyspace, shard string) string {
+	return path.Join(shardsDirPath(keyspace), shard)
+}
+
+func shardFilePath(keyspace, shard string) string {
+	return path.Join(shardDirPath(keyspace, shard), dataFilename)
+}
+
+func queryRulesFilePath(keyspace, shard string) string {
+	return path.Join(shardDirPath(keyspace, shard), queryRulesFilename)
+}
+
+func shardElectionDirPath(keyspace, shard string) string {
+	return path.Join(shardDirPath(keyspace, shard), electionDirname)
+}
+
+func tabletDirPath(tablet topo.TabletAlias) string {
+	return path.Join(tabletsDirPath, tablet.String())
+}
+
+func tabletFilePath(tablet topo.TabletAlias) string {
+	return path.Join(tabletDirPath(tablet), dataFilename)
+}
+
+func tabletActionDirPath(tablet topo.TabletAlias) string {
+	return path.Join(tabletDirPath(tablet), actionDirname)
+}
+
+func shardReplicationDirPath(keyspace string) string {
+	return path.Join(replicationPath, keyspace)
+}
+
+func shardReplicationFilePath(keyspace, shard string) string {
+	return path.Join(shardReplicationDirPath(keyspace), shard)
+}
+
+func srvKeyspaceDirPath(keyspace string) string {
+	return path.Join(servingDirPath, keyspace)
+}
+
+func srvKeyspaceFilePath(keyspace string) string {
+	return path.Join(srvKeyspaceDirPath(keyspace), dataFilename)
+}
+
+func srvShardDirPath(keyspace, shard string) string {
+	return path.Join(srvKeyspaceDirPath(keyspace), shard)
+}
+
+func srvShardFilePath(keyspace, shard string) string {
+	return path.Join(srvShardDirPath(keyspace, shard), dataFilename)
+}
+
+func endPointsFilePath(keyspace, shard string, tabletType topo.TabletType) string {
+	return path.Join(srvShardDirPath(keyspace, shard), string(tabletType))
+}
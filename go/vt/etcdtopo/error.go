@@ -0,0 +1,71 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdtopo
+
+import (
+	"errors"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// Error codes returned by etcd:
+// https://github.com/coreos/etcd/blob/master/Documentation/errorcode.md
+const (
+	EcodeKeyNotFound    = 100
+	EcodeTestFailed     = 101
+	EcodeNotFile        = 102
+	EcodeNoMorePeer     = 103
+	EcodeNotDir         = 104
+	EcodeNodeExist      = 105
+	EcodeKeyIsPreserved = 106
+	EcodeRootROnly      = 107
+	EcodeDirNotEmpty    = 108
+)
+
+// ErrBadResponse is returned when etcd sends back a response
+// without the node we asked for.
+var ErrBadResponse = errors.New("etcd request returned success, but response is missing required data")
+
+// etcdErrorCode returns the etcd error code of err, or 0 if err
+// didn't come from etcd.
+func etcdErrorCode(err error) int {
+	switch typeErr := err.(type) {
+	case *etcd.EtcdError:
+		return typeErr.ErrorCode
+	case etcd.EtcdError:
+		return typeErr.ErrorCode
+	}
+	return 0
+}
+
+// convertError converts etcd-specific errors to corresponding topo errors,
+// if they exist. Otherwise, it returns the original error.
+func convertError(err error) error {
+	switch etcdErrorCode(err) {
+	case EcodeKeyNotFound:
+		return topo.ErrNoNode
+	case EcodeTestFailed:
+		return topo.ErrBadVersion
+	case EcodeNodeExist:
+		return topo.ErrNodeExists
+	case EcodeDirNotEmpty:
+		return topo.ErrNotEmpty
+	}
+	return err
+}
+
+// etcdIndex returns the current etcd index carried by an error, so
+// a caller can start watching right after it. It returns 0 if the
+// error doesn't have one.
+func etcdIndex(err error) uint64 {
+	switch typeErr := err.(type) {
+	case *etcd.EtcdError:
+		return typeErr.Index
+	case etcd.EtcdError:
+		return typeErr.Index
+	}
+	return 0
+}
@@ -0,0 +1,180 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdtopo
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/topo/test"
+)
+
+// This file runs the topo.Server tests against a real etcd, started
+// from the etcd binary in $PATH. They are skipped if there is none.
+
+// freeAddr returns a local address nobody listens on.
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("cannot find a free port: %v", err)
+	}
+	defer l.Close()
+	return "http://" + l.Addr().String()
+}
+
+// startEtcd starts a single node etcd cluster with its data in a
+// temporary directory, and returns its client address and a function
+// to stop it.
+func startEtcd(t *testing.T) (string, func()) {
+	binary, err := exec.LookPath("etcd")
+	if err != nil {
+		t.Skipf("no etcd binary in $PATH, skipping: %v", err)
+	}
+	dataDir, err := ioutil.TempDir("", "etcdtopo_test")
+	if err != nil {
+		t.Fatalf("cannot create data directory: %v", err)
+	}
+	clientAddr := freeAddr(t)
+	peerAddr := freeAddr(t)
+	cmd := exec.Command(binary,
+		"-name", "vt_test",
+		"-data-dir", dataDir,
+		"-listen-client-urls", clientAddr,
+		"-advertise-client-urls", clientAddr,
+		"-listen-peer-urls", peerAddr,
+		"-initial-advertise-peer-urls", peerAddr,
+		"-initial-cluster", "vt_test="+peerAddr)
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dataDir)
+		t.Fatalf("cannot start etcd: %v", err)
+	}
+	stop := func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		os.RemoveAll(dataDir)
+	}
+
+	// wait until it answers
+	client := newEtcdClient([]string{clientAddr})
+	defer client.Close()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		_, err := client.Get("/", false /* sort */, false /* recursive */)
+		if err == nil {
+			return clientAddr, stop
+		}
+		if time.Now().After(deadline) {
+			stop()
+			t.Fatalf("etcd didn't start: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// newEtcdTestServer returns a Server talking to the etcd at addr, for
+// the global cluster and the provided cells. The previous test data
+// is deleted first.
+func newEtcdTestServer(t *testing.T, addr string, cells []string) *Server {
+	s := newServer(func(machines []string) Client {
+		return newEtcdClient([]string{addr})
+	})
+	if _, err := s.getGlobal().Delete(rootPath, true /* recursive */); err != nil && etcdErrorCode(err) != EcodeKeyNotFound {
+		t.Fatalf("cannot delete the previous test data: %v", err)
+	}
+	for _, cell := range cells {
+		if _, err := s.getGlobal().Set(cellFilePath(cell), addr, 0 /* ttl */); err != nil {
+			t.Fatalf("cannot register cell %v: %v", cell, err)
+		}
+	}
+	return s
+}
+
+func TestEtcdServer(t *testing.T) {
+	addr, stop := startEtcd(t)
+	defer stop()
+
+	checks := []struct {
+		name string
+		// waits is set for the tests that wait on timeouts
+		waits bool
+		check func(*testing.T, topo.Server)
+	}{
+		{"Keyspace", false, test.CheckKeyspace},
+		{"VSchema", false, test.CheckVSchema},
+		{"QueryRules", false, test.CheckQueryRules},
+		{"Shard", false, test.CheckShard},
+		{"Tablet", false, test.CheckTablet},
+		{"ShardReplication", false, test.CheckShardReplication},
+		{"ServingGraph", false, test.CheckServingGraph},
+		{"WatchEndPoints", false, test.CheckWatchEndPoints},
+		{"WatchSrvKeyspace", false, test.CheckWatchSrvKeyspace},
+		{"KeyspaceLock", false, test.CheckKeyspaceLock},
+		{"ShardLock", true, test.CheckShardLock},
+		{"ShardElection", true, test.CheckShardElection},
+		{"SrvShardLock", true, test.CheckSrvShardLock},
+		{"Pid", false, test.CheckPid},
+		{"Actions", false, test.CheckActions},
+	}
+	for _, c := range checks {
+		if c.waits && testing.Short() {
+			continue
+		}
+		t.Logf("running %v against etcd at %v", c.name, addr)
+		func() {
+			ts := newEtcdTestServer(t, addr, []string{"test"})
+			defer ts.Close()
+			c.check(t, ts)
+		}()
+	}
+}
+
+func TestEtcdLockLost(t *testing.T) {
+	addr, stop := startEtcd(t)
+	defer stop()
+	checkLockLost(t, newEtcdTestServer(t, addr, []string{"test"}))
+}
+
+func TestLockLost(t *testing.T) {
+	checkLockLost(t, newTestServer(t, []string{"test"}))
+}
+
+// checkLockLost makes sure the holder of a lock that was deleted
+// cannot release it as if nothing happened.
+func checkLockLost(t *testing.T, ts *Server) {
+	defer ts.Close()
+	defer func(ttl time.Duration) { lockTTL = ttl }(lockTTL)
+	lockTTL = 3 * time.Second
+
+	if err := ts.CreateKeyspace("test_keyspace", &topo.Keyspace{}); err != nil {
+		t.Fatalf("CreateKeyspace: %v", err)
+	}
+	if err := topo.CreateShard(ts, "test_keyspace", "0"); err != nil {
+		t.Fatalf("CreateShard: %v", err)
+	}
+	lockPath, err := ts.LockShardForAction("test_keyspace", "0", "fake-content", time.Second, nil)
+	if err != nil {
+		t.Fatalf("LockShardForAction: %v", err)
+	}
+
+	// somebody deletes the lock, the next refresh notices it
+	if _, err := ts.getGlobal().Delete(path.Join(shardDirPath("test_keyspace", "0"), lockFilename), false /* recursive */); err != nil {
+		t.Fatalf("cannot delete the lock: %v", err)
+	}
+	time.Sleep(lockTTL / 2)
+
+	if err := ts.UnlockShardForAction("test_keyspace", "0", lockPath, "fake-results"); err == nil || !strings.Contains(err.Error(), "was lost") {
+		t.Errorf("UnlockShardForAction of a lost lock: got %v, want a lost lock error", err)
+	}
+	if _, err := ts.getGlobal().Get(path.Join(shardDirPath("test_keyspace", "0"), actionLogDirname, lockPath), false /* sort */, false /* recursive */); etcdErrorCode(err) != EcodeKeyNotFound {
+		t.Errorf("the results of the action were logged: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdtopo
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/youtube/vitess/go/event"
+	"github.com/youtube/vitess/go/jscfg"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/topo/events"
+)
+
+/*
+This file contains the Keyspace management code for etcdtopo.Server
+*/
+
+// CreateKeyspace implements topo.Server.
+func (s *Server) CreateKeyspace(keyspace string, value *topo.Keyspace) error {
+	global := s.getGlobal()
+
+	if _, err := global.Create(keyspaceFilePath(keyspace), jscfg.ToJson(value), 0 /* ttl */); err != nil {
+		return convertError(err)
+	}
+	if _, err := global.CreateDir(shardsDirPath(keyspace), 0 /* ttl */); err != nil && etcdErrorCode(err) != EcodeNodeExist {
+		return fmt.Errorf("error creating keyspace shards directory: %v %v", keyspace, err)
+	}
+
+	event.Dispatch(&events.KeyspaceChange{
+		KeyspaceInfo: *topo.NewKeyspaceInfo(keyspace, value),
+		Status:       "created",
+	})
+	return nil
+}
+
+// UpdateKeyspace implements topo.Server.
+func (s *Server) UpdateKeyspace(ki *topo.KeyspaceInfo) error {
+	if _, err := s.getGlobal().Update(keyspaceFilePath(ki.KeyspaceName()), jscfg.ToJson(ki.Keyspace), 0 /* ttl */); err != nil {
+		return convertError(err)
+	}
+
+	event.Dispatch(&events.KeyspaceChange{
+		KeyspaceInfo: *ki,
+		Status:       "updated",
+	})
+	return nil
+}
+
+// GetKeyspace implements topo.Server.
+func (s *Server) GetKeyspace(keyspace string) (*topo.KeyspaceInfo, error) {
+	resp, err := s.getGlobal().Get(keyspaceFilePath(keyspace), false /* sort */, false /* recursive */)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	k := &topo.Keyspace{}
+	if err := json.Unmarshal([]byte(resp.Node.Value), k); err != nil {
+		return nil, fmt.Errorf("bad keyspace data %v", err)
+	}
+
+	return topo.NewKeyspaceInfo(keyspace, k), nil
+}
+
+// GetKeyspaces implements topo.Server.
+func (s *Server) GetKeyspaces() ([]string, error) {
+	resp, err := s.getGlobal().Get(keyspacesDirPath, true /* sort */, false /* recursive */)
+	if err != nil {
+		err = convertError(err)
+		if err == topo.ErrNoNode {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return getNodeNames(resp)
+}
+
+// DeleteKeyspaceShards implements topo.Server.
+func (s *Server) DeleteKeyspaceShards(keyspace string) error {
+	if _, err := s.getGlobal().Delete(shardsDirPath(keyspace), true /* recursive */); err != nil && etcdErrorCode(err) != EcodeKeyNotFound {
+		return err
+	}
+
+	event.Dispatch(&events.KeyspaceChange{
+		KeyspaceInfo: *topo.NewKeyspaceInfo(keyspace, nil),
+		Status:       "deleted all shards",
+	})
+	return nil
+}
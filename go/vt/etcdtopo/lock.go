@@ -0,0 +1,303 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdtopo
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+/*
+This file contains the lock management code for etcdtopo.Server
+
+A lock is a _Lock file created in the locked directory, with a
+TTL. The lock holder keeps refreshing the TTL, so the lock goes away
+if the holder dies. The lock path returned to the caller is the
+CreatedIndex of the lock file, which doesn't change on refresh.
+
+If the lock file cannot be refreshed before it expires, or was
+deleted or taken over, the lock is lost: another process may have
+locked the same directory meanwhile. The holder is told when it
+releases the lock, which then fails without writing the action
+results, so the action is reported as failed.
+*/
+
+// lockTTL is the TTL of the lock files. They are refreshed every
+// lockTTL/3 by the lock holder.
+var lockTTL = 30 * time.Second
+
+// lockRefresher keeps the TTL of a lock file we hold up to date.
+type lockRefresher struct {
+	stop chan struct{}
+	done chan struct{}
+
+	// lost is set if the lock was lost. It can only be read
+	// after done is closed.
+	lost error
+}
+
+// lockForAction creates the lock file in dirPath, waiting for the
+// current holder to release it if needed.
+func (s *Server) lockForAction(client Client, dirPath, contents string, timeout time.Duration, interrupted chan struct{}) (string, error) {
+	lockPath := path.Join(dirPath, lockFilename)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		resp, err := client.Create(lockPath, contents, uint64(lockTTL.Seconds()))
+		if err == nil {
+			if resp.Node == nil {
+				return "", ErrBadResponse
+			}
+			s.startLockRefresher(client, lockPath, contents, resp.Node.ModifiedIndex)
+			return strconv.FormatUint(resp.Node.CreatedIndex, 10), nil
+		}
+		if etcdErrorCode(err) != EcodeNodeExist {
+			return "", fmt.Errorf("failed to obtain action lock: %v %v", lockPath, err)
+		}
+
+		// Somebody else holds the lock, wait for it to change.
+		if err := waitForChange(client, lockPath, etcdIndex(err)+1, false /* recursive */, timer.C, interrupted); err != nil {
+			log.Warningf("Failed to obtain action lock %v: %v", lockPath, err)
+			if resp, getErr := client.Get(lockPath, false /* sort */, false /* recursive */); getErr == nil && resp.Node != nil {
+				log.Warningf("------ Most likely blocking action: %v\n%v", lockPath, resp.Node.Value)
+			}
+			return "", err
+		}
+	}
+}
+
+// unlockForAction writes the results to the action log, and releases
+// the lock file in dirPath, if it is still the one identified by lockID.
+func (s *Server) unlockForAction(client Client, dirPath, lockID, results string) error {
+	index, err := strconv.ParseUint(lockID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid lock path %v: %v", lockID, err)
+	}
+	lockPath := path.Join(dirPath, lockFilename)
+	if err := s.stopLockRefresher(lockPath); err != nil {
+		return err
+	}
+
+	resp, err := client.Get(lockPath, false /* sort */, false /* recursive */)
+	if err != nil {
+		return fmt.Errorf("cannot read lock %v: %v", lockPath, err)
+	}
+	if resp.Node == nil {
+		return ErrBadResponse
+	}
+	if resp.Node.CreatedIndex != index {
+		return fmt.Errorf("lock %v is not held by %v anymore (now held by %v)", lockPath, lockID, resp.Node.CreatedIndex)
+	}
+
+	// Write the data to the actionlog
+	actionLogPath := path.Join(dirPath, actionLogDirname, lockID)
+	if _, err := client.Set(actionLogPath, results, 0 /* ttl */); err != nil {
+		log.Warningf("Cannot create actionlog path %v, will keep the lock, use 'etcdctl rm' to clear the lock", actionLogPath)
+		return err
+	}
+
+	// and delete the lock, if it didn't change
+	_, err = client.CompareAndDelete(lockPath, "" /* prevValue */, resp.Node.ModifiedIndex)
+	return convertError(err)
+}
+
+// startLockRefresher starts refreshing the TTL of the lock file. It
+// keeps retrying on errors until the lock file expires, and gives up
+// right away if the lock file was deleted or changed.
+func (s *Server) startLockRefresher(client Client, lockPath, contents string, index uint64) {
+	lr := &lockRefresher{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	s.mu.Lock()
+	s.locks[lockPath] = lr
+	s.mu.Unlock()
+
+	go func() {
+		defer close(lr.done)
+		ticker := time.NewTicker(lockTTL / 3)
+		defer ticker.Stop()
+		refreshed := time.Now()
+		for {
+			select {
+			case <-lr.stop:
+				return
+			case <-ticker.C:
+			}
+
+			resp, err := client.CompareAndSwap(lockPath, contents, uint64(lockTTL.Seconds()), "" /* prevValue */, index)
+			if err != nil {
+				code := etcdErrorCode(err)
+				if code != EcodeKeyNotFound && code != EcodeTestFailed && time.Now().Sub(refreshed) < lockTTL {
+					log.Warningf("Failed to refresh lock %v, will retry: %v", lockPath, err)
+					continue
+				}
+				lr.lost = fmt.Errorf("lock %v was lost while the action was running: %v", lockPath, err)
+				log.Errorf("%v", lr.lost)
+				return
+			}
+			refreshed = time.Now()
+			if resp.Node != nil {
+				index = resp.Node.ModifiedIndex
+			}
+		}
+	}()
+}
+
+// stopLockRefresher stops refreshing the lock file, and waits until
+// the refresher is done. It returns an error if the lock was lost.
+func (s *Server) stopLockRefresher(lockPath string) error {
+	s.mu.Lock()
+	lr, ok := s.locks[lockPath]
+	delete(s.locks, lockPath)
+	s.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	close(lr.stop)
+	<-lr.done
+	return lr.lost
+}
+
+// waitForChange waits for the node (or any node under it if
+// recursive is set) to change after waitIndex. It can be stopped
+// by the expired channel (returns topo.ErrTimeout) or the interrupted
+// channel (returns topo.ErrInterrupted).
+func waitForChange(client Client, key string, waitIndex uint64, recursive bool, expired <-chan time.Time, interrupted chan struct{}) error {
+	stop := make(chan bool)
+	result := make(chan error, 1)
+	go func() {
+		_, err := client.Watch(key, waitIndex, recursive, nil, stop)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			// Just log it, the caller will re-read the
+			// node and set up another watch if needed. Wait
+			// a bit so we don't spin on a persistent error.
+			log.Warningf("watch on %v failed, will retry: %v", key, err)
+			select {
+			case <-time.After(time.Second):
+			case <-expired:
+				return topo.ErrTimeout
+			case <-interrupted:
+				return topo.ErrInterrupted
+			}
+		}
+		return nil
+	case <-expired:
+		close(stop)
+		return topo.ErrTimeout
+	case <-interrupted:
+		close(stop)
+		return topo.ErrInterrupted
+	}
+}
+
+// LockSrvShardForAction implements topo.Server.
+func (s *Server) LockSrvShardForAction(cellName, keyspace, shard, contents string, timeout time.Duration, interrupted chan struct{}) (string, error) {
+	cell, err := s.getCell(cellName)
+	if err != nil {
+		return "", err
+	}
+	return s.lockForAction(cell, srvShardDirPath(keyspace, shard), contents, timeout, interrupted)
+}
+
+// UnlockSrvShardForAction implements topo.Server.
+func (s *Server) UnlockSrvShardForAction(cellName, keyspace, shard, lockPath, results string) error {
+	cell, err := s.getCell(cellName)
+	if err != nil {
+		return err
+	}
+	return s.unlockForAction(cell, srvShardDirPath(keyspace, shard), lockPath, results)
+}
+
+// LockKeyspaceForAction implements topo.Server.
+func (s *Server) LockKeyspaceForAction(keyspace, contents string, timeout time.Duration, interrupted chan struct{}) (string, error) {
+	// Don't create a lock for a keyspace that doesn't exist.
+	global := s.getGlobal()
+	if _, err := global.Get(keyspaceFilePath(keyspace), false /* sort */, false /* recursive */); err != nil {
+		return "", convertError(err)
+	}
+	return s.lockForAction(global, keyspaceDirPath(keyspace), contents, timeout, interrupted)
+}
+
+// UnlockKeyspaceForAction implements topo.Server.
+func (s *Server) UnlockKeyspaceForAction(keyspace, lockPath, results string) error {
+	return s.unlockForAction(s.getGlobal(), keyspaceDirPath(keyspace), lockPath, results)
+}
+
+// LockShardForAction implements topo.Server.
+func (s *Server) LockShardForAction(keyspace, shard, contents string, timeout time.Duration, interrupted chan struct{}) (string, error) {
+	// Don't create a lock for a shard that doesn't exist.
+	global := s.getGlobal()
+	if _, err := global.Get(shardFilePath(keyspace, shard), false /* sort */, false /* recursive */); err != nil {
+		return "", convertError(err)
+	}
+	return s.lockForAction(global, shardDirPath(keyspace, shard), contents, timeout, interrupted)
+}
+
+// UnlockShardForAction implements topo.Server.
+func (s *Server) UnlockShardForAction(keyspace, shard, lockPath, results string) error {
+	return s.unlockForAction(s.getGlobal(), shardDirPath(keyspace, shard), lockPath, results)
+}
+
+// LockShardForElection implements topo.Server.
+func (s *Server) LockShardForElection(keyspace, shard, contents string, timeout time.Duration, interrupted chan struct{}) (string, error) {
+	// Don't create a lock for a shard that doesn't exist.
+	global := s.getGlobal()
+	if _, err := global.Get(shardFilePath(keyspace, shard), false /* sort */, false /* recursive */); err != nil {
+		return "", convertError(err)
+	}
+	return s.lockForAction(global, shardElectionDirPath(keyspace, shard), contents, timeout, interrupted)
+}
+
+// UnlockShardForElection implements topo.Server.
+func (s *Server) UnlockShardForElection(keyspace, shard, lockPath string) error {
+	index, err := strconv.ParseUint(lockPath, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid lock path %v: %v", lockPath, err)
+	}
+	electionLockPath := path.Join(shardElectionDirPath(keyspace, shard), lockFilename)
+	if err := s.stopLockRefresher(electionLockPath); err != nil {
+		return err
+	}
+
+	// there is no actionlog for elections, just delete the lock
+	// if it is still ours
+	resp, err := s.getGlobal().Get(electionLockPath, false /* sort */, false /* recursive */)
+	if err != nil {
+		return convertError(err)
+	}
+	if resp.Node == nil {
+		return ErrBadResponse
+	}
+	if resp.Node.CreatedIndex != index {
+		return fmt.Errorf("lock %v is not held by %v anymore (now held by %v)", electionLockPath, lockPath, resp.Node.CreatedIndex)
+	}
+	_, err = s.getGlobal().CompareAndDelete(electionLockPath, "" /* prevValue */, resp.Node.ModifiedIndex)
+	return convertError(err)
+}
+
+// GetShardElectionLeader implements topo.Server.
+func (s *Server) GetShardElectionLeader(keyspace, shard string) (string, error) {
+	resp, err := s.getGlobal().Get(path.Join(shardElectionDirPath(keyspace, shard), lockFilename), false /* sort */, false /* recursive */)
+	if err != nil {
+		return "", convertError(err)
+	}
+	if resp.Node == nil {
+		return "", ErrBadResponse
+	}
+	return resp.Node.Value, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdtopo
+
+import (
+	"github.com/coreos/go-etcd/etcd"
+)
+
+/*
+This file contains the query rules management code for etcdtopo.Server
+*/
+
+// SaveQueryRules implements topo.Server.
+func (s *Server) SaveQueryRules(keyspace, shard, rules string) error {
+	// etcd creates the parent directories, make sure the shard exists.
+	if _, err := s.getGlobal().Get(shardFilePath(keyspace, shard), false /* sort */, false /* recursive */); err != nil {
+		return convertError(err)
+	}
+	if _, err := s.getGlobal().Set(queryRulesFilePath(keyspace, shard), rules, 0 /* ttl */); err != nil {
+		return convertError(err)
+	}
+	return nil
+}
+
+// GetQueryRules implements topo.Server.
+func (s *Server) GetQueryRules(keyspace, shard string) (string, error) {
+	resp, err := s.getGlobal().Get(queryRulesFilePath(keyspace, shard), false /* sort */, false /* recursive */)
+	if err != nil {
+		return "", convertError(err)
+	}
+	if resp.Node == nil {
+		return "", ErrBadResponse
+	}
+	return resp.Node.Value, nil
+}
+
+// WatchQueryRules implements topo.Server.
+func (s *Server) WatchQueryRules(keyspace, shard string, stopWatching chan struct{}) (<-chan string, error) {
+	notifications := make(chan string, 10)
+	go func() {
+		defer close(notifications)
+		watchNode(s.getGlobal(), queryRulesFilePath(keyspace, shard), stopWatching, func(node *etcd.Node) bool {
+			var rules string
+			if node != nil {
+				rules = node.Value
+			}
+			select {
+			case notifications <- rules:
+				return true
+			case <-stopWatching:
+				return false
+			}
+		})
+	}()
+	return notifications, nil
+}
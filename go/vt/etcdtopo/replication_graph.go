@@ -0,0 +1,97 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdtopo
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/youtube/vitess/go/jscfg"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+/*
+This file contains the replication graph management code for etcdtopo.Server
+*/
+
+// UpdateShardReplicationFields implements topo.Server.
+func (s *Server) UpdateShardReplicationFields(cell, keyspace, shard string, update func(*topo.ShardReplication) error) error {
+	client, err := s.getCell(cell)
+	if err != nil {
+		return err
+	}
+	filePath := shardReplicationFilePath(keyspace, shard)
+
+	for {
+		// Read the existing value, or start with an empty one.
+		var version uint64
+		sr := &topo.ShardReplication{}
+		resp, err := client.Get(filePath, false /* sort */, false /* recursive */)
+		switch {
+		case err == nil:
+			if resp.Node == nil {
+				return ErrBadResponse
+			}
+			if err := json.Unmarshal([]byte(resp.Node.Value), sr); err != nil {
+				return fmt.Errorf("bad ShardReplication data %v", err)
+			}
+			version = resp.Node.ModifiedIndex
+		case etcdErrorCode(err) != EcodeKeyNotFound:
+			return err
+		}
+
+		if err := update(sr); err != nil {
+			return err
+		}
+
+		// Write it back, making sure nobody changed it in between.
+		data := jscfg.ToJson(sr)
+		if version == 0 {
+			_, err = client.Create(filePath, data, 0 /* ttl */)
+		} else {
+			_, err = client.CompareAndSwap(filePath, data, 0 /* ttl */, "" /* prevValue */, version)
+		}
+		switch etcdErrorCode(err) {
+		case EcodeNodeExist, EcodeTestFailed, EcodeKeyNotFound:
+			// Somebody else changed it, try again.
+			continue
+		}
+		return err
+	}
+}
+
+// GetShardReplication implements topo.Server.
+func (s *Server) GetShardReplication(cell, keyspace, shard string) (*topo.ShardReplicationInfo, error) {
+	client, err := s.getCell(cell)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Get(shardReplicationFilePath(keyspace, shard), false /* sort */, false /* recursive */)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	if resp.Node == nil {
+		return nil, ErrBadResponse
+	}
+
+	sr := &topo.ShardReplication{}
+	if err := json.Unmarshal([]byte(resp.Node.Value), sr); err != nil {
+		return nil, fmt.Errorf("bad ShardReplication data %v", err)
+	}
+
+	return topo.NewShardReplicationInfo(sr, cell, keyspace, shard), nil
+}
+
+// DeleteShardReplication implements topo.Server.
+func (s *Server) DeleteShardReplication(cell, keyspace, shard string) error {
+	client, err := s.getCell(cell)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Delete(shardReplicationFilePath(keyspace, shard), false /* recursive */)
+	return convertError(err)
+}
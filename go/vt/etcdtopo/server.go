@@ -0,0 +1,146 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package etcdtopo implements topo.Server with etcd as the backend.
+//
+// We expect the following behavior from the etcd client library:
+//
+//   - Get and Delete return EcodeKeyNotFound if the node doesn't exist.
+//   - Create returns EcodeNodeExist if the node already exists.
+//   - Intermediate directories are always created automatically if necessary.
+//   - CompareAndSwap returns EcodeKeyNotFound if the node doesn't exist already.
+//     It returns EcodeTestFailed if the provided version index doesn't match.
+//   - Update returns EcodeKeyNotFound if the node doesn't exist already.
+//
+// There is one global etcd cluster, and one etcd cluster per cell.
+// The addresses of the cell clusters are stored in the global one.
+package etcdtopo
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// Server is the implementation of topo.Server for etcd.
+type Server struct {
+	// newClient is the function used to create a new Client.
+	newClient func(machines []string) Client
+
+	// mu protects all the fields below.
+	mu     sync.Mutex
+	global Client
+	cells  map[string]Client
+
+	// locks maps the path of each lock file we hold to its
+	// TTL refresher.
+	locks map[string]*lockRefresher
+}
+
+// NewServer returns a new etcdtopo.Server that will connect to the
+// global cluster given by -etcd_global_addrs.
+func NewServer() *Server {
+	return newServer(newEtcdClient)
+}
+
+func newServer(newClient func(machines []string) Client) *Server {
+	return &Server{
+		newClient: newClient,
+		cells:     make(map[string]Client),
+		locks:     make(map[string]*lockRefresher),
+	}
+}
+
+func init() {
+	topo.RegisterServer("etcd", NewServer())
+}
+
+// Close implements topo.Server.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, lr := range s.locks {
+		close(lr.stop)
+	}
+	s.locks = make(map[string]*lockRefresher)
+	for _, c := range s.cells {
+		c.Close()
+	}
+	s.cells = make(map[string]Client)
+	if s.global != nil {
+		s.global.Close()
+		s.global = nil
+	}
+}
+
+// GetSubprocessFlags implements topo.Server.
+func (s *Server) GetSubprocessFlags() []string {
+	return []string{"-etcd_global_addrs", globalAddrs.String()}
+}
+
+// getGlobal returns the client for the global cluster.
+func (s *Server) getGlobal() Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.global == nil {
+		s.global = s.newClient(globalAddrs)
+	}
+	return s.global
+}
+
+// getCell returns the client for the given cell cluster, reading
+// its addresses from the global cluster the first time.
+func (s *Server) getCell(cell string) (Client, error) {
+	s.mu.Lock()
+	c, ok := s.cells[cell]
+	s.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	resp, err := s.getGlobal().Get(cellFilePath(cell), false /* sort */, false /* recursive */)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	if resp.Node == nil || resp.Node.Value == "" {
+		return nil, fmt.Errorf("no addresses for cell %v in global etcd", cell)
+	}
+	addrs := strings.Split(resp.Node.Value, ",")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.cells[cell]; ok {
+		// somebody else beat us to it
+		return c, nil
+	}
+	c = s.newClient(addrs)
+	s.cells[cell] = c
+	return c, nil
+}
+
+// getNodeNames returns the sorted base names of the children of a
+// directory node, skipping the ones used internally (they start with
+// an underscore).
+func getNodeNames(resp *etcd.Response) ([]string, error) {
+	if resp.Node == nil || !resp.Node.Dir {
+		return nil, fmt.Errorf("not a directory: %v", resp)
+	}
+	names := make([]string, 0, len(resp.Node.Nodes))
+	for _, n := range resp.Node.Nodes {
+		name := path.Base(n.Key)
+		if strings.HasPrefix(name, "_") {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,347 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdtopo
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/jscfg"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+/*
+This file contains the serving graph management code of etcdtopo.Server
+*/
+
+// GetSrvTabletTypesPerShard implements topo.Server.
+func (s *Server) GetSrvTabletTypesPerShard(cellName, keyspace, shard string) ([]topo.TabletType, error) {
+	cell, err := s.getCell(cellName)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cell.Get(srvShardDirPath(keyspace, shard), false /* sort */, false /* recursive */)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	// getNodeNames skips _Data and _Lock, leaving only the tablet types.
+	names, err := getNodeNames(resp)
+	if err != nil {
+		return nil, err
+	}
+	tabletTypes := make([]topo.TabletType, 0, len(names))
+	for _, name := range names {
+		tabletTypes = append(tabletTypes, topo.TabletType(name))
+	}
+	return tabletTypes, nil
+}
+
+// UpdateEndPoints implements topo.Server.
+func (s *Server) UpdateEndPoints(cellName, keyspace, shard string, tabletType topo.TabletType, addrs *topo.EndPoints) error {
+	cell, err := s.getCell(cellName)
+	if err != nil {
+		return err
+	}
+
+	_, err = cell.Set(endPointsFilePath(keyspace, shard, tabletType), jscfg.ToJson(addrs), 0 /* ttl */)
+	return convertError(err)
+}
+
+// GetEndPoints implements topo.Server.
+func (s *Server) GetEndPoints(cellName, keyspace, shard string, tabletType topo.TabletType) (*topo.EndPoints, error) {
+	cell, err := s.getCell(cellName)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cell.Get(endPointsFilePath(keyspace, shard, tabletType), false /* sort */, false /* recursive */)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	if resp.Node == nil {
+		return nil, ErrBadResponse
+	}
+
+	value := &topo.EndPoints{}
+	if resp.Node.Value != "" {
+		if err := json.Unmarshal([]byte(resp.Node.Value), value); err != nil {
+			return nil, fmt.Errorf("EndPoints unmarshal failed: %v %v", resp.Node.Value, err)
+		}
+	}
+	return value, nil
+}
+
+// DeleteEndPoints implements topo.Server.
+func (s *Server) DeleteEndPoints(cellName, keyspace, shard string, tabletType topo.TabletType) error {
+	cell, err := s.getCell(cellName)
+	if err != nil {
+		return err
+	}
+
+	_, err = cell.Delete(endPointsFilePath(keyspace, shard, tabletType), false /* recursive */)
+	return convertError(err)
+}
+
+// UpdateSrvShard implements topo.Server.
+func (s *Server) UpdateSrvShard(cellName, keyspace, shard string, srvShard *topo.SrvShard) error {
+	cell, err := s.getCell(cellName)
+	if err != nil {
+		return err
+	}
+
+	_, err = cell.Set(srvShardFilePath(keyspace, shard), jscfg.ToJson(srvShard), 0 /* ttl */)
+	return convertError(err)
+}
+
+// GetSrvShard implements topo.Server.
+func (s *Server) GetSrvShard(cellName, keyspace, shard string) (*topo.SrvShard, error) {
+	cell, err := s.getCell(cellName)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cell.Get(srvShardFilePath(keyspace, shard), false /* sort */, false /* recursive */)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	if resp.Node == nil {
+		return nil, ErrBadResponse
+	}
+
+	value := topo.NewSrvShard(int64(resp.Node.ModifiedIndex))
+	if resp.Node.Value != "" {
+		if err := json.Unmarshal([]byte(resp.Node.Value), value); err != nil {
+			return nil, fmt.Errorf("SrvShard unmarshal failed: %v %v", resp.Node.Value, err)
+		}
+	}
+	return value, nil
+}
+
+// DeleteSrvShard implements topo.Server.
+func (s *Server) DeleteSrvShard(cellName, keyspace, shard string) error {
+	cell, err := s.getCell(cellName)
+	if err != nil {
+		return err
+	}
+
+	_, err = cell.Delete(srvShardFilePath(keyspace, shard), false /* recursive */)
+	return convertError(err)
+}
+
+// UpdateSrvKeyspace implements topo.Server.
+func (s *Server) UpdateSrvKeyspace(cellName, keyspace string, srvKeyspace *topo.SrvKeyspace) error {
+	cell, err := s.getCell(cellName)
+	if err != nil {
+		return err
+	}
+
+	_, err = cell.Set(srvKeyspaceFilePath(keyspace), jscfg.ToJson(srvKeyspace), 0 /* ttl */)
+	return convertError(err)
+}
+
+// GetSrvKeyspace implements topo.Server.
+func (s *Server) GetSrvKeyspace(cellName, keyspace string) (*topo.SrvKeyspace, error) {
+	cell, err := s.getCell(cellName)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cell.Get(srvKeyspaceFilePath(keyspace), false /* sort */, false /* recursive */)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	if resp.Node == nil {
+		return nil, ErrBadResponse
+	}
+
+	value := topo.NewSrvKeyspace(int64(resp.Node.ModifiedIndex))
+	if resp.Node.Value != "" {
+		if err := json.Unmarshal([]byte(resp.Node.Value), value); err != nil {
+			return nil, fmt.Errorf("SrvKeyspace unmarshal failed: %v %v", resp.Node.Value, err)
+		}
+	}
+	return value, nil
+}
+
+// GetSrvKeyspaceNames implements topo.Server.
+func (s *Server) GetSrvKeyspaceNames(cellName string) ([]string, error) {
+	cell, err := s.getCell(cellName)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cell.Get(servingDirPath, true /* sort */, false /* recursive */)
+	if err != nil {
+		err = convertError(err)
+		if err == topo.ErrNoNode {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return getNodeNames(resp)
+}
+
+// UpdateTabletEndpoint implements topo.Server.
+func (s *Server) UpdateTabletEndpoint(cellName, keyspace, shard string, tabletType topo.TabletType, addr *topo.EndPoint) error {
+	cell, err := s.getCell(cellName)
+	if err != nil {
+		return err
+	}
+	filePath := endPointsFilePath(keyspace, shard, tabletType)
+
+	for {
+		resp, err := cell.Get(filePath, false /* sort */, false /* recursive */)
+		if err != nil {
+			if etcdErrorCode(err) == EcodeKeyNotFound {
+				// We haven't been placed in the serving graph
+				// yet, so don't update. Assume the next process
+				// that rebuilds the graph will get the updated
+				// tablet location.
+				return nil
+			}
+			return err
+		}
+		if resp.Node == nil {
+			return ErrBadResponse
+		}
+
+		addrs := topo.NewEndPoints()
+		if resp.Node.Value != "" {
+			if err := json.Unmarshal([]byte(resp.Node.Value), addrs); err != nil {
+				return fmt.Errorf("EndPoints unmarshal failed: %v %v", resp.Node.Value, err)
+			}
+		}
+
+		foundTablet := false
+		for i, entry := range addrs.Entries {
+			if entry.Uid == addr.Uid {
+				foundTablet = true
+				if topo.EndPointEquality(&entry, addr) {
+					// nothing to change
+					return nil
+				}
+				addrs.Entries[i] = *addr
+				break
+			}
+		}
+		if !foundTablet {
+			addrs.Entries = append(addrs.Entries, *addr)
+		}
+
+		_, err = cell.CompareAndSwap(filePath, jscfg.ToJson(addrs), 0 /* ttl */, "" /* prevValue */, resp.Node.ModifiedIndex)
+		switch etcdErrorCode(err) {
+		case EcodeTestFailed:
+			// Somebody else changed it, try again.
+			continue
+		case EcodeKeyNotFound:
+			// It was deleted in the meantime, see above.
+			return nil
+		}
+		return err
+	}
+}
+
+// watchNode watches key, and calls notify with its node every time it
+// changes, until stopWatching is closed or notify returns false. The
+// node is nil if it doesn't exist.
+func watchNode(client Client, key string, stopWatching chan struct{}, notify func(node *etcd.Node) bool) {
+	for {
+		var waitIndex uint64
+		resp, err := client.Get(key, false /* sort */, false /* recursive */)
+		switch {
+		case err == nil:
+			if !notify(resp.Node) {
+				return
+			}
+			waitIndex = resp.EtcdIndex + 1
+		case etcdErrorCode(err) == EcodeKeyNotFound:
+			if !notify(nil) {
+				return
+			}
+			waitIndex = etcdIndex(err) + 1
+		default:
+			log.Warningf("watch on %v failed, will retry in 5 seconds: %v", key, err)
+			select {
+			case <-time.After(5 * time.Second):
+				continue
+			case <-stopWatching:
+				return
+			}
+		}
+
+		if err := waitForChange(client, key, waitIndex, false /* recursive */, nil, stopWatching); err != nil {
+			return
+		}
+	}
+}
+
+// WatchEndPoints implements topo.Server.
+func (s *Server) WatchEndPoints(cellName, keyspace, shard string, tabletType topo.TabletType, stopWatching chan struct{}) (<-chan *topo.EndPoints, error) {
+	cell, err := s.getCell(cellName)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := make(chan *topo.EndPoints, 10)
+	go func() {
+		defer close(notifications)
+		watchNode(cell, endPointsFilePath(keyspace, shard, tabletType), stopWatching, func(node *etcd.Node) bool {
+			var addrs *topo.EndPoints
+			if node != nil {
+				addrs = &topo.EndPoints{}
+				if node.Value != "" {
+					if err := json.Unmarshal([]byte(node.Value), addrs); err != nil {
+						log.Errorf("EndPoints unmarshal failed: %v %v", node.Value, err)
+						return true
+					}
+				}
+			}
+			select {
+			case notifications <- addrs:
+				return true
+			case <-stopWatching:
+				return false
+			}
+		})
+	}()
+	return notifications, nil
+}
+
+// WatchSrvKeyspace implements topo.Server.
+func (s *Server) WatchSrvKeyspace(cellName, keyspace string, stopWatching chan struct{}) (<-chan *topo.SrvKeyspace, error) {
+	cell, err := s.getCell(cellName)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := make(chan *topo.SrvKeyspace, 10)
+	go func() {
+		defer close(notifications)
+		watchNode(cell, srvKeyspaceFilePath(keyspace), stopWatching, func(node *etcd.Node) bool {
+			var srvKeyspace *topo.SrvKeyspace
+			if node != nil {
+				srvKeyspace = topo.NewSrvKeyspace(int64(node.ModifiedIndex))
+				if node.Value != "" {
+					if err := json.Unmarshal([]byte(node.Value), srvKeyspace); err != nil {
+						log.Errorf("SrvKeyspace unmarshal failed: %v %v", node.Value, err)
+						return true
+					}
+				}
+			}
+			select {
+			case notifications <- srvKeyspace:
+				return true
+			case <-stopWatching:
+				return false
+			}
+		})
+	}()
+	return notifications, nil
+}
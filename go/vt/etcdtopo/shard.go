@@ -0,0 +1,94 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdtopo
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/youtube/vitess/go/event"
+	"github.com/youtube/vitess/go/jscfg"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/topo/events"
+)
+
+/*
+This file contains the shard management code for etcdtopo.Server
+*/
+
+// CreateShard implements topo.Server.
+func (s *Server) CreateShard(keyspace, shard string, value *topo.Shard) error {
+	if _, err := s.getGlobal().Create(shardFilePath(keyspace, shard), jscfg.ToJson(value), 0 /* ttl */); err != nil {
+		return convertError(err)
+	}
+
+	event.Dispatch(&events.ShardChange{
+		ShardInfo: *topo.NewShardInfo(keyspace, shard, value),
+		Status:    "created",
+	})
+	return nil
+}
+
+// UpdateShard implements topo.Server.
+func (s *Server) UpdateShard(si *topo.ShardInfo) error {
+	if _, err := s.getGlobal().Update(shardFilePath(si.Keyspace(), si.ShardName()), jscfg.ToJson(si.Shard), 0 /* ttl */); err != nil {
+		return convertError(err)
+	}
+
+	event.Dispatch(&events.ShardChange{
+		ShardInfo: *si,
+		Status:    "updated",
+	})
+	return nil
+}
+
+// ValidateShard implements topo.Server.
+func (s *Server) ValidateShard(keyspace, shard string) error {
+	_, err := s.GetShard(keyspace, shard)
+	return err
+}
+
+// GetShard implements topo.Server.
+func (s *Server) GetShard(keyspace, shard string) (*topo.ShardInfo, error) {
+	resp, err := s.getGlobal().Get(shardFilePath(keyspace, shard), false /* sort */, false /* recursive */)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	value := &topo.Shard{}
+	if err := json.Unmarshal([]byte(resp.Node.Value), value); err != nil {
+		return nil, fmt.Errorf("bad shard data %v", err)
+	}
+
+	return topo.NewShardInfo(keyspace, shard, value), nil
+}
+
+// GetShardCritical implements topo.Server.
+func (s *Server) GetShardCritical(keyspace, shard string) (*topo.ShardInfo, error) {
+	// etcd reads are always served by the leader, so they are consistent.
+	return s.GetShard(keyspace, shard)
+}
+
+// GetShardNames implements topo.Server.
+func (s *Server) GetShardNames(keyspace string) ([]string, error) {
+	resp, err := s.getGlobal().Get(shardsDirPath(keyspace), true /* sort */, false /* recursive */)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return getNodeNames(resp)
+}
+
+// DeleteShard implements topo.Server.
+func (s *Server) DeleteShard(keyspace, shard string) error {
+	if _, err := s.getGlobal().Delete(shardDirPath(keyspace, shard), true /* recursive */); err != nil {
+		return convertError(err)
+	}
+
+	event.Dispatch(&events.ShardChange{
+		ShardInfo: *topo.NewShardInfo(keyspace, shard, nil),
+		Status:    "deleted",
+	})
+	return nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdtopo
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/youtube/vitess/go/event"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/topo/events"
+)
+
+/*
+This file contains the tablet management parts of etcdtopo.Server
+*/
+
+func tabletFromJson(data string) (*topo.Tablet, error) {
+	t := &topo.Tablet{}
+	if err := json.Unmarshal([]byte(data), t); err != nil {
+		return nil, fmt.Errorf("bad tablet data %v", err)
+	}
+	return t, nil
+}
+
+// CreateTablet implements topo.Server.
+func (s *Server) CreateTablet(tablet *topo.Tablet) error {
+	cell, err := s.getCell(tablet.Alias.Cell)
+	if err != nil {
+		return err
+	}
+
+	if _, err := cell.Create(tabletFilePath(tablet.Alias), tablet.Json(), 0 /* ttl */); err != nil {
+		return convertError(err)
+	}
+	if _, err := cell.CreateDir(tabletActionDirPath(tablet.Alias), 0 /* ttl */); err != nil && etcdErrorCode(err) != EcodeNodeExist {
+		return err
+	}
+
+	event.Dispatch(&events.TabletChange{
+		Tablet: *tablet,
+		Status: "created",
+	})
+	return nil
+}
+
+// UpdateTablet implements topo.Server.
+func (s *Server) UpdateTablet(ti *topo.TabletInfo, existingVersion int64) (int64, error) {
+	cell, err := s.getCell(ti.Alias.Cell)
+	if err != nil {
+		return -1, err
+	}
+
+	var resp *etcd.Response
+	if existingVersion == -1 {
+		// Set unconditionally, but only if it already exists.
+		resp, err = cell.Update(tabletFilePath(ti.Alias), ti.Json(), 0 /* ttl */)
+	} else {
+		resp, err = cell.CompareAndSwap(tabletFilePath(ti.Alias), ti.Json(), 0 /* ttl */, "" /* prevValue */, uint64(existingVersion))
+	}
+	if err != nil {
+		return -1, convertError(err)
+	}
+	if resp.Node == nil {
+		return -1, ErrBadResponse
+	}
+
+	event.Dispatch(&events.TabletChange{
+		Tablet: *ti.Tablet,
+		Status: "updated",
+	})
+	return int64(resp.Node.ModifiedIndex), nil
+}
+
+// UpdateTabletFields implements topo.Server.
+func (s *Server) UpdateTabletFields(tabletAlias topo.TabletAlias, update func(*topo.Tablet) error) error {
+	for {
+		ti, err := s.GetTablet(tabletAlias)
+		if err != nil {
+			return err
+		}
+		if err := update(ti.Tablet); err != nil {
+			return err
+		}
+		// UpdateTablet dispatches the TabletChange event on success.
+		if _, err = s.UpdateTablet(ti, ti.Version()); err != topo.ErrBadVersion {
+			return err
+		}
+	}
+}
+
+// DeleteTablet implements topo.Server.
+func (s *Server) DeleteTablet(tabletAlias topo.TabletAlias) error {
+	cell, err := s.getCell(tabletAlias.Cell)
+	if err != nil {
+		return err
+	}
+
+	// Get the keyspace and shard names for the TabletChange event.
+	ti, tiErr := s.GetTablet(tabletAlias)
+
+	if _, err = cell.Delete(tabletDirPath(tabletAlias), true /* recursive */); err != nil {
+		return convertError(err)
+	}
+
+	// Only try to log if we have the required info.
+	if tiErr == nil {
+		// Only copy the identity info for the tablet. The rest has been deleted.
+		event.Dispatch(&events.TabletChange{
+			Tablet: topo.Tablet{
+				Alias:    tabletAlias,
+				Keyspace: ti.Tablet.Keyspace,
+				Shard:    ti.Tablet.Shard,
+			},
+			Status: "deleted",
+		})
+	}
+	return nil
+}
+
+// ValidateTablet implements topo.Server.
+func (s *Server) ValidateTablet(tabletAlias topo.TabletAlias) error {
+	cell, err := s.getCell(tabletAlias.Cell)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range []string{tabletFilePath(tabletAlias), tabletActionDirPath(tabletAlias)} {
+		if _, err := cell.Get(p, false /* sort */, false /* recursive */); err != nil {
+			return convertError(err)
+		}
+	}
+	return nil
+}
+
+// GetTablet implements topo.Server.
+func (s *Server) GetTablet(tabletAlias topo.TabletAlias) (*topo.TabletInfo, error) {
+	cell, err := s.getCell(tabletAlias.Cell)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cell.Get(tabletFilePath(tabletAlias), false /* sort */, false /* recursive */)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	if resp.Node == nil {
+		return nil, ErrBadResponse
+	}
+
+	value, err := tabletFromJson(resp.Node.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return topo.NewTabletInfo(value, int64(resp.Node.ModifiedIndex)), nil
+}
+
+// GetTabletsByCell implements topo.Server.
+func (s *Server) GetTabletsByCell(cellName string) ([]topo.TabletAlias, error) {
+	cell, err := s.getCell(cellName)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cell.Get(tabletsDirPath, true /* sort */, false /* recursive */)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	nodes, err := getNodeNames(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	tablets := make([]topo.TabletAlias, 0, len(nodes))
+	for _, node := range nodes {
+		tabletAlias, err := topo.ParseTabletAliasString(node)
+		if err != nil {
+			return nil, err
+		}
+		tablets = append(tablets, tabletAlias)
+	}
+	return tablets, nil
+}
@@ -0,0 +1,21 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdtopo
+
+import (
+	"testing"
+)
+
+// newTestServer returns a Server backed by fake etcd clusters, with
+// the provided cells registered in the global cluster.
+func newTestServer(t *testing.T, cells []string) *Server {
+	s := newServer(newFakeClientFactory())
+	for _, cell := range cells {
+		if _, err := s.getGlobal().Set(cellFilePath(cell), cell, 0 /* ttl */); err != nil {
+			t.Fatalf("cannot register cell %v: %v", cell, err)
+		}
+	}
+	return s
+}
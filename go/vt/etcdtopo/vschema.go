@@ -0,0 +1,29 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdtopo
+
+/*
+This file contains the VSchema management code for etcdtopo.Server
+*/
+
+// SaveVSchema implements topo.Server.
+func (s *Server) SaveVSchema(vschema string) error {
+	if _, err := s.getGlobal().Set(vschemaFilePath, vschema, 0 /* ttl */); err != nil {
+		return convertError(err)
+	}
+	return nil
+}
+
+// GetVSchema implements topo.Server.
+func (s *Server) GetVSchema() (string, error) {
+	resp, err := s.getGlobal().Get(vschemaFilePath, false /* sort */, false /* recursive */)
+	if err != nil {
+		return "", convertError(err)
+	}
+	if resp.Node == nil {
+		return "", ErrBadResponse
+	}
+	return resp.Node.Value, nil
+}
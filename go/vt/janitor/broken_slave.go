@@ -0,0 +1,111 @@
+package janitor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/concurrency"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/wrangler"
+)
+
+// brokenSlaveTimeout is how long we wait for a slave to report its
+// position, or to restart replication.
+var brokenSlaveTimeout = 30 * time.Second
+
+// brokenSlaveJanitor looks for serving slaves of the shard master that
+// are not replicating, and restarts replication on them.
+type brokenSlaveJanitor struct {
+	wr       *wrangler.Wrangler
+	keyspace string
+	shard    string
+	history  history
+}
+
+func (janitor *brokenSlaveJanitor) Configure(wr *wrangler.Wrangler, keyspace, shard string) error {
+	janitor.wr = wr
+	janitor.keyspace = keyspace
+	janitor.shard = shard
+	return nil
+}
+
+func (janitor *brokenSlaveJanitor) Run(active bool) error {
+	janitor.wr.ResetActionTimeout(wrangler.DefaultActionTimeout)
+
+	si, err := janitor.wr.TopoServer().GetShard(janitor.keyspace, janitor.shard)
+	if err != nil {
+		return err
+	}
+	if si.MasterAlias.IsZero() {
+		log.Infof("no master for shard %v/%v, not checking slaves", janitor.keyspace, janitor.shard)
+		return nil
+	}
+
+	tabletMap, err := topo.GetTabletMapForShard(janitor.wr.TopoServer(), janitor.keyspace, janitor.shard)
+	switch err {
+	case nil:
+	case topo.ErrPartialResult:
+		log.Warningf("some cells were not reachable for %v/%v, checking the slaves we found", janitor.keyspace, janitor.shard)
+	default:
+		return err
+	}
+
+	wg := sync.WaitGroup{}
+	rec := concurrency.AllErrorRecorder{}
+	for _, ti := range tabletMap {
+		// Only the serving slaves are supposed to replicate all
+		// the time. The other types may have stopped replication
+		// on purpose (backups, schema changes, ...).
+		if ti.Type == topo.TYPE_MASTER || !topo.IsInServingGraph(ti.Type) || ti.Parent != si.MasterAlias {
+			continue
+		}
+		wg.Add(1)
+		go func(ti *topo.TabletInfo) {
+			defer wg.Done()
+			rec.RecordError(janitor.checkSlave(ti, active))
+		}(ti)
+	}
+	wg.Wait()
+	return rec.Error()
+}
+
+// checkSlave restarts replication on the slave if it is not running.
+func (janitor *brokenSlaveJanitor) checkSlave(ti *topo.TabletInfo, active bool) error {
+	pos, err := janitor.wr.ActionInitiator().SlavePosition(ti, brokenSlaveTimeout)
+	if err != nil {
+		return fmt.Errorf("SlavePosition(%v) failed: %v", ti.Alias, err)
+	}
+	if pos.SecondsBehindMaster != myproto.InvalidLagSeconds {
+		return nil
+	}
+
+	if !active {
+		janitor.history.Add("[dry run] would restart replication on %v", ti.Alias)
+		log.Infof("[dry run] would restart replication on %v", ti.Alias)
+		return nil
+	}
+
+	log.Infof("restarting replication on %v", ti.Alias)
+	if err := janitor.wr.ActionInitiator().StartSlave(ti.Alias, brokenSlaveTimeout); err != nil {
+		janitor.history.Add("restarting replication on %v failed: %v", ti.Alias, err)
+		return fmt.Errorf("StartSlave(%v) failed: %v", ti.Alias, err)
+	}
+	janitor.history.Add("restarted replication on %v", ti.Alias)
+	return nil
+}
+
+// History returns the recent events of the janitor.
+func (janitor *brokenSlaveJanitor) History() []HistoryEntry {
+	return janitor.history.Entries()
+}
+
+func (janitor *brokenSlaveJanitor) StatusTemplate() string {
+	return historyTemplate
+}
+
+func init() {
+	Register("broken_slave", &brokenSlaveJanitor{})
+}
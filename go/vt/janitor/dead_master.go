@@ -0,0 +1,145 @@
+package janitor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/wrangler"
+)
+
+var (
+	// deadMasterPingTimeout is how long we wait for the master
+	// to answer a ping.
+	deadMasterPingTimeout = 10 * time.Second
+
+	// deadMasterFailureThreshold is how many consecutive runs the
+	// master has to fail the ping before we reparent.
+	deadMasterFailureThreshold = 3
+)
+
+// deadMasterJanitor pings the master of the shard, and if it doesn't
+// answer for a few consecutive runs, runs an emergency reparent of the
+// shard to the most advanced replica.
+type deadMasterJanitor struct {
+	wr       *wrangler.Wrangler
+	keyspace string
+	shard    string
+	history  history
+
+	// mu protects master and failures
+	mu       sync.Mutex
+	master   topo.TabletAlias
+	failures int
+}
+
+func (janitor *deadMasterJanitor) Configure(wr *wrangler.Wrangler, keyspace, shard string) error {
+	janitor.wr = wr
+	janitor.keyspace = keyspace
+	janitor.shard = shard
+	return nil
+}
+
+func (janitor *deadMasterJanitor) Run(active bool) error {
+	janitor.wr.ResetActionTimeout(wrangler.DefaultActionTimeout)
+
+	si, err := janitor.wr.TopoServer().GetShard(janitor.keyspace, janitor.shard)
+	if err != nil {
+		return err
+	}
+	if si.MasterAlias.IsZero() {
+		// nothing to watch, a reparent is probably in progress
+		janitor.recordPing(si.MasterAlias, true)
+		log.Infof("no master for shard %v/%v", janitor.keyspace, janitor.shard)
+		return nil
+	}
+
+	err = janitor.wr.ActionInitiator().RpcPing(si.MasterAlias, deadMasterPingTimeout)
+	if err == nil {
+		janitor.recordPing(si.MasterAlias, true)
+		return nil
+	}
+	log.Warningf("master %v of %v/%v failed ping: %v", si.MasterAlias, janitor.keyspace, janitor.shard, err)
+
+	failures := janitor.recordPing(si.MasterAlias, false)
+	if failures < deadMasterFailureThreshold {
+		janitor.history.Add("master %v failed ping (%v/%v)", si.MasterAlias, failures, deadMasterFailureThreshold)
+		return nil
+	}
+
+	if !active {
+		janitor.history.Add("[dry run] would reparent away from dead master %v", si.MasterAlias)
+		log.Infof("[dry run] would reparent %v/%v away from dead master %v", janitor.keyspace, janitor.shard, si.MasterAlias)
+		return nil
+	}
+
+	// The emergency reparent picks the most advanced replica, and
+	// scraps the old master.
+	janitor.history.Add("reparenting away from dead master %v", si.MasterAlias)
+	log.Infof("reparenting %v/%v away from dead master %v", janitor.keyspace, janitor.shard, si.MasterAlias)
+	if err := janitor.wr.EmergencyReparentShard(janitor.keyspace, janitor.shard, "", false); err != nil {
+		janitor.history.Add("reparenting away from %v failed: %v", si.MasterAlias, err)
+		return fmt.Errorf("EmergencyReparentShard(%v/%v) failed: %v", janitor.keyspace, janitor.shard, err)
+	}
+	si, err = janitor.wr.TopoServer().GetShard(janitor.keyspace, janitor.shard)
+	if err != nil {
+		return err
+	}
+	janitor.history.Add("reparented to %v", si.MasterAlias)
+	janitor.recordPing(si.MasterAlias, true)
+	return nil
+}
+
+// recordPing records the result of a ping of the given master, and
+// returns how many consecutive pings it failed.
+func (janitor *deadMasterJanitor) recordPing(master topo.TabletAlias, healthy bool) int {
+	janitor.mu.Lock()
+	defer janitor.mu.Unlock()
+	if master != janitor.master || healthy {
+		janitor.master = master
+		janitor.failures = 0
+	}
+	if !healthy {
+		janitor.failures++
+	}
+	return janitor.failures
+}
+
+// Master returns the master being watched.
+func (janitor *deadMasterJanitor) Master() topo.TabletAlias {
+	janitor.mu.Lock()
+	defer janitor.mu.Unlock()
+	return janitor.master
+}
+
+// Failures returns the number of consecutive pings the master failed.
+func (janitor *deadMasterJanitor) Failures() int {
+	janitor.mu.Lock()
+	defer janitor.mu.Unlock()
+	return janitor.failures
+}
+
+// FailureThreshold returns the number of failed pings after which
+// the master is considered dead.
+func (janitor *deadMasterJanitor) FailureThreshold() int {
+	return deadMasterFailureThreshold
+}
+
+// History returns the recent events of the janitor.
+func (janitor *deadMasterJanitor) History() []HistoryEntry {
+	return janitor.history.Entries()
+}
+
+func (janitor *deadMasterJanitor) StatusTemplate() string {
+	return `
+{{with $j := .Janitor}}
+<p>Master {{$j.Master}}: {{$j.Failures}} consecutive failed pings (reparent after {{$j.FailureThreshold}}).</p>
+{{end}}
+` + historyTemplate
+}
+
+func init() {
+	Register("dead_master", &deadMasterJanitor{})
+}
@@ -0,0 +1,77 @@
+package janitor
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/memorytopo"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/wrangler"
+	"github.com/youtube/vitess/go/vt/wrangler/testlib"
+)
+
+func TestDeadMasterJanitor(t *testing.T) {
+	flag.Set("tablet_manager_protocol", testlib.FakeTabletManagerProtocol)
+	ts := memorytopo.NewTestServer(t, []string{"cell1"})
+	defer ts.Close()
+	wr := wrangler.New(ts, time.Minute, time.Second)
+	wr.UseRPCs = false
+
+	// the master is not running, the replica is
+	master := testlib.NewFakeTablet(t, wr, "cell1", 0, topo.TYPE_MASTER)
+	replica := testlib.NewFakeTablet(t, wr, "cell1", 1, topo.TYPE_REPLICA,
+		testlib.TabletParent(master.Tablet.Alias))
+	replica.FakeMysqlDaemon.ReadOnly = true
+	replica.FakeMysqlDaemon.Replicating = true
+	replica.FakeMysqlDaemon.CurrentSlaveStatus = &myproto.ReplicationPosition{
+		MasterLogFile:       "vt-0000000000-bin.000003",
+		MasterLogPosition:   400,
+		MasterLogFileIo:     "vt-0000000000-bin.000003",
+		MasterLogPositionIo: 400,
+	}
+	replica.FakeMysqlDaemon.PromoteSlaveResult = &myproto.ReplicationState{
+		MasterHost: "101.0.0.1",
+		MasterPort: 3301,
+	}
+	replica.StartActionLoop(t, wr)
+	defer replica.StopActionLoop(t)
+
+	janitor := &deadMasterJanitor{}
+	if err := janitor.Configure(wr, "test_keyspace", "0"); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+
+	// dry runs only count the failed pings
+	for i := 0; i < deadMasterFailureThreshold; i++ {
+		if err := janitor.Run(false); err != nil {
+			t.Fatalf("Run(false) failed: %v", err)
+		}
+	}
+	if janitor.Failures() != deadMasterFailureThreshold {
+		t.Errorf("Failures() = %v, want %v", janitor.Failures(), deadMasterFailureThreshold)
+	}
+	si, err := ts.GetShard("test_keyspace", "0")
+	if err != nil {
+		t.Fatalf("GetShard failed: %v", err)
+	}
+	if si.MasterAlias != master.Tablet.Alias {
+		t.Errorf("dry run reparented the shard to %v", si.MasterAlias)
+	}
+
+	// the next run reparents to the replica
+	if err := janitor.Run(true); err != nil {
+		t.Fatalf("Run(true) failed: %v", err)
+	}
+	si, err = ts.GetShard("test_keyspace", "0")
+	if err != nil {
+		t.Fatalf("GetShard failed: %v", err)
+	}
+	if si.MasterAlias != replica.Tablet.Alias {
+		t.Errorf("shard master is %v, want %v", si.MasterAlias, replica.Tablet.Alias)
+	}
+	if janitor.Master() != replica.Tablet.Alias || janitor.Failures() != 0 {
+		t.Errorf("janitor watches %v with %v failures, want %v with none", janitor.Master(), janitor.Failures(), replica.Tablet.Alias)
+	}
+}
@@ -1,14 +1,150 @@
 package janitor
 
-// TODO(szopa): Implement master election using ZooKeeper.
+import (
+	"fmt"
+	"os"
+	"time"
 
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// The election uses the shard election lock of the topology
+// server. The scheduler holding the lock is the master, and the only
+// one running its janitors in active mode. The lock goes away if the
+// master process dies, and the next candidate waiting for it takes
+// over.
+
+var (
+	// electionLockTimeout is how long a candidate waits for the
+	// election lock before trying again.
+	electionLockTimeout = 10 * time.Minute
+
+	// electionCheckInterval is how often the master checks it
+	// still holds the election lock.
+	electionCheckInterval = 10 * time.Second
+
+	// electionRetryDelay is how long a candidate waits after an
+	// error before trying again.
+	electionRetryDelay = 10 * time.Second
+)
+
+// candidateID returns the identifier of this process, stored in the
+// election lock.
+func candidateID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%v:%v", hostname, os.Getpid())
+}
+
+// IsMaster returns true if the scheduler is the elected master for
+// its keyspace/shard.
 func (scheduler *Scheduler) IsMaster() bool {
-	return true
+	scheduler.electionMu.Lock()
+	defer scheduler.electionMu.Unlock()
+	return scheduler.isMaster
+}
+
+func (scheduler *Scheduler) setMaster(isMaster bool) {
+	scheduler.electionMu.Lock()
+	defer scheduler.electionMu.Unlock()
+	scheduler.isMaster = isMaster
 }
 
+// RunElection competes for the election lock of the shard, and keeps
+// the scheduler master as long as it holds it. It runs until the
+// election is stopped, and can only be called once.
 func (scheduler *Scheduler) RunElection() {
+	scheduler.electionMu.Lock()
+	scheduler.electionStarted = true
+	scheduler.electionMu.Unlock()
+	defer close(scheduler.electionDone)
+
+	for {
+		select {
+		case <-scheduler.stopElection:
+			return
+		default:
+		}
+
+		lockPath, err := scheduler.ts.LockShardForElection(scheduler.Keyspace, scheduler.Shard, scheduler.id, electionLockTimeout, scheduler.stopElection)
+		switch err {
+		case nil:
+		case topo.ErrTimeout:
+			continue
+		case topo.ErrInterrupted:
+			return
+		default:
+			log.Warningf("LockShardForElection(%v/%v) failed: %v", scheduler.Keyspace, scheduler.Shard, err)
+			select {
+			case <-time.After(electionRetryDelay):
+				continue
+			case <-scheduler.stopElection:
+				return
+			}
+		}
+
+		log.Infof("%v is now the master for %v/%v", scheduler.id, scheduler.Keyspace, scheduler.Shard)
+		scheduler.setMaster(true)
+		scheduler.waitForMastershipLoss()
+		scheduler.setMaster(false)
+		log.Infof("%v is not the master for %v/%v anymore", scheduler.id, scheduler.Keyspace, scheduler.Shard)
+
+		if err := scheduler.ts.UnlockShardForElection(scheduler.Keyspace, scheduler.Shard, lockPath); err != nil {
+			log.Warningf("UnlockShardForElection(%v/%v) failed: %v", scheduler.Keyspace, scheduler.Shard, err)
+		}
+	}
+}
+
+// StopElection makes RunElection return, and waits until it gave up
+// the election lock if it was running, so another candidate can take
+// over right away. It is called on shutdown.
+func (scheduler *Scheduler) StopElection() {
+	scheduler.stopElectionOnce.Do(func() {
+		close(scheduler.stopElection)
+	})
+	scheduler.electionMu.Lock()
+	started := scheduler.electionStarted
+	scheduler.electionMu.Unlock()
+	if started {
+		<-scheduler.electionDone
+	}
+}
+
+// waitForMastershipLoss returns when the scheduler doesn't hold the
+// election lock anymore, or the election is stopped. If the leader
+// cannot be checked, we step down to be safe.
+func (scheduler *Scheduler) waitForMastershipLoss() {
+	for {
+		select {
+		case <-time.After(electionCheckInterval):
+		case <-scheduler.stopElection:
+			return
+		}
+
+		leader, err := scheduler.ts.GetShardElectionLeader(scheduler.Keyspace, scheduler.Shard)
+		if err != nil {
+			log.Warningf("GetShardElectionLeader(%v/%v) failed, stepping down: %v", scheduler.Keyspace, scheduler.Shard, err)
+			return
+		}
+		if leader != scheduler.id {
+			log.Warningf("%v lost the election lock for %v/%v to %v", scheduler.id, scheduler.Keyspace, scheduler.Shard, leader)
+			return
+		}
+	}
 }
 
+// CurrentMasterID returns the identifier of the current master for
+// the keyspace/shard.
 func (scheduler *Scheduler) CurrentMasterID() string {
-	return "master election not implemented"
+	leader, err := scheduler.ts.GetShardElectionLeader(scheduler.Keyspace, scheduler.Shard)
+	switch err {
+	case nil:
+		return leader
+	case topo.ErrNoNode:
+		return "no master elected"
+	}
+	return fmt.Sprintf("cannot read master: %v", err)
 }
@@ -0,0 +1,57 @@
+package janitor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// historyLength is how many events a janitor module keeps to
+// display on its status page.
+const historyLength = 50
+
+// HistoryEntry is something a janitor module did, or would have done
+// in dry run mode.
+type HistoryEntry struct {
+	Time    time.Time
+	Message string
+}
+
+// history is a thread safe list of the most recent events of a
+// janitor module, newest first.
+type history struct {
+	mu      sync.Mutex
+	entries []HistoryEntry
+}
+
+func (h *history) Add(format string, args ...interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry := HistoryEntry{Time: time.Now(), Message: fmt.Sprintf(format, args...)}
+	h.entries = append([]HistoryEntry{entry}, h.entries...)
+	if len(h.entries) > historyLength {
+		h.entries = h.entries[:historyLength]
+	}
+}
+
+// Entries returns a copy of the events, newest first.
+func (h *history) Entries() []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result := make([]HistoryEntry, len(h.entries))
+	copy(result, h.entries)
+	return result
+}
+
+// historyTemplate displays the history of a janitor module. The
+// module is expected to have a History method returning the entries.
+const historyTemplate = `
+<table>
+  <tr><th>Time</th><th>Event</th></tr>
+  {{range .Janitor.History}}
+  <tr><td>{{.Time.Format "Jan 2, 2006 at 15:04:05 (MST)"}}</td><td>{{.Message}}</td></tr>
+  {{else}}
+  <tr><td colspan="2">nothing to report</td></tr>
+  {{end}}
+</table>
+`
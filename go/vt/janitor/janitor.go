@@ -8,6 +8,7 @@ import (
 	"time"
 
 	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/servenv"
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/wrangler"
 )
@@ -60,6 +61,17 @@ type Scheduler struct {
 	janitors  map[string]*JanitorInfo
 	ts        topo.Server
 	wrangler  *wrangler.Wrangler
+
+	// id identifies this scheduler in the election.
+	id string
+	// stopElection makes RunElection return when closed.
+	stopElection     chan struct{}
+	stopElectionOnce sync.Once
+	// electionDone is closed when RunElection returns.
+	electionDone    chan struct{}
+	electionMu      sync.Mutex
+	electionStarted bool
+	isMaster        bool
 }
 
 func New(keyspace, shard string, ts topo.Server, wr *wrangler.Wrangler, sleepTime time.Duration) (*Scheduler, error) {
@@ -74,6 +86,10 @@ func New(keyspace, shard string, ts topo.Server, wr *wrangler.Wrangler, sleepTim
 		sleepTime: sleepTime,
 		janitors:  make(map[string]*JanitorInfo),
 		mux:       http.NewServeMux(),
+
+		id:           candidateID(),
+		stopElection: make(chan struct{}),
+		electionDone: make(chan struct{}),
 	}, nil
 }
 
@@ -160,6 +176,27 @@ func (scheduler *Scheduler) EnableDryRun(janitorNames []string) error {
 	return nil
 }
 
+// janitorStatusHeader is displayed on the status page before the
+// template of each janitor.
+const janitorStatusHeader = `
+<p>Mode: {{if .Active}}active{{else}}dry run{{end}}, runs: {{.Runs}}, consecutive errors: {{.ErrorCount}}, average runtime: {{.AverageRuntime}}</p>
+`
+
+// AddStatusParts adds a section to the status page for each enabled
+// janitor that implements JanitorWithStatus.
+func (scheduler *Scheduler) AddStatusParts() {
+	for name, ji := range scheduler.janitors {
+		withStatus, ok := ji.Janitor.(JanitorWithStatus)
+		if !ok {
+			continue
+		}
+		ji := ji
+		servenv.AddStatusPart("Janitor "+name, janitorStatusHeader+withStatus.StatusTemplate(), func() interface{} {
+			return ji
+		})
+	}
+}
+
 func (scheduler *Scheduler) runJanitor(name string) {
 	janitor, ok := scheduler.janitors[name]
 	if !ok {
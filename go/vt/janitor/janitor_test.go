@@ -1,11 +1,15 @@
 package janitor
 
 import (
+	"bytes"
 	"errors"
+	"html/template"
 	"math"
 	"testing"
 	"time"
 
+	"github.com/youtube/vitess/go/vt/memorytopo"
+	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/wrangler"
 )
 
@@ -75,3 +79,90 @@ func TestRunJanitor(t *testing.T) {
 	}
 
 }
+
+func waitForMaster(t *testing.T, schedulers ...*Scheduler) *Scheduler {
+	timeout := time.After(5 * time.Second)
+	for {
+		var masters []*Scheduler
+		for _, s := range schedulers {
+			if s.IsMaster() {
+				masters = append(masters, s)
+			}
+		}
+		if len(masters) > 1 {
+			t.Fatalf("more than one master: %v and %v", masters[0].id, masters[1].id)
+		}
+		if len(masters) == 1 {
+			return masters[0]
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("timeout waiting for a master")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestElection(t *testing.T) {
+	defer func(interval time.Duration) {
+		electionCheckInterval = interval
+	}(electionCheckInterval)
+	electionCheckInterval = 10 * time.Millisecond
+	ts := memorytopo.NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	if err := ts.CreateKeyspace("ks", &topo.Keyspace{}); err != nil {
+		t.Fatalf("CreateKeyspace: %v", err)
+	}
+	if err := topo.CreateShard(ts, "ks", "0"); err != nil {
+		t.Fatalf("CreateShard: %v", err)
+	}
+
+	s1, _ := New("ks", "0", ts, nil, 0)
+	s1.id = "candidate1"
+	s2, _ := New("ks", "0", ts, nil, 0)
+	s2.id = "candidate2"
+	go s1.RunElection()
+	go s2.RunElection()
+
+	master := waitForMaster(t, s1, s2)
+	if got := s1.CurrentMasterID(); got != master.id {
+		t.Errorf("CurrentMasterID: want %v, got %v", master.id, got)
+	}
+
+	// when the master goes away, the other candidate takes over
+	master.StopElection()
+	if master.IsMaster() {
+		t.Errorf("%v is still master after StopElection", master.id)
+	}
+	other := s1
+	if master == s1 {
+		other = s2
+	}
+	if got := waitForMaster(t, other); got != other {
+		t.Errorf("waitForMaster: want %v, got %v", other.id, got.id)
+	}
+	if got := master.CurrentMasterID(); got != other.id {
+		t.Errorf("CurrentMasterID: want %v, got %v", other.id, got)
+	}
+	other.StopElection()
+	if got := other.CurrentMasterID(); got != "no master elected" {
+		t.Errorf("CurrentMasterID after the election stopped: got %v", got)
+	}
+}
+
+func TestStatusTemplates(t *testing.T) {
+	for name, janitor := range janitorRepository {
+		withStatus, ok := janitor.(JanitorWithStatus)
+		if !ok {
+			continue
+		}
+		tmpl, err := template.New(name).Parse(janitorStatusHeader + withStatus.StatusTemplate())
+		if err != nil {
+			t.Errorf("cannot parse template of %v: %v", name, err)
+			continue
+		}
+		if err := tmpl.Execute(new(bytes.Buffer), newJanitorInfo(janitor)); err != nil {
+			t.Errorf("cannot execute template of %v: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package janitor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/wrangler"
+)
+
+// servingGraphJanitor compares the serving graph of the shard in each
+// cell with what the tablet records say it should be, and rebuilds
+// the cells that drifted.
+type servingGraphJanitor struct {
+	wr       *wrangler.Wrangler
+	keyspace string
+	shard    string
+	history  history
+}
+
+func (janitor *servingGraphJanitor) Configure(wr *wrangler.Wrangler, keyspace, shard string) error {
+	janitor.wr = wr
+	janitor.keyspace = keyspace
+	janitor.shard = shard
+	return nil
+}
+
+func (janitor *servingGraphJanitor) Run(active bool) error {
+	janitor.wr.ResetActionTimeout(wrangler.DefaultActionTimeout)
+
+	si, err := janitor.wr.TopoServer().GetShard(janitor.keyspace, janitor.shard)
+	if err != nil {
+		return err
+	}
+
+	var cells []string
+	for _, cell := range si.Cells {
+		diffs, err := janitor.cellDrift(cell)
+		if err != nil {
+			return fmt.Errorf("cannot check serving graph of %v/%v in cell %v: %v", janitor.keyspace, janitor.shard, cell, err)
+		}
+		if len(diffs) == 0 {
+			continue
+		}
+		log.Warningf("serving graph of %v/%v in cell %v drifted: %v", janitor.keyspace, janitor.shard, cell, strings.Join(diffs, ", "))
+		cells = append(cells, cell)
+		if !active {
+			janitor.history.Add("[dry run] would rebuild cell %v: %v", cell, strings.Join(diffs, ", "))
+		} else {
+			janitor.history.Add("rebuilding cell %v: %v", cell, strings.Join(diffs, ", "))
+		}
+	}
+	if len(cells) == 0 || !active {
+		return nil
+	}
+
+	if err := janitor.wr.RebuildShardGraph(janitor.keyspace, janitor.shard, cells); err != nil {
+		janitor.history.Add("rebuilding cells %v failed: %v", cells, err)
+		return fmt.Errorf("RebuildShardGraph(%v/%v, %v) failed: %v", janitor.keyspace, janitor.shard, cells, err)
+	}
+	return nil
+}
+
+// cellDrift returns the differences between the serving graph of the
+// shard in a cell and the tablet records. It uses the same rules as
+// topotools.RebuildShard to find the expected end points.
+func (janitor *servingGraphJanitor) cellDrift(cell string) ([]string, error) {
+	ts := janitor.wr.TopoServer()
+	tablets, err := topo.GetTabletMapForShardByCell(ts, janitor.keyspace, janitor.shard, []string{cell})
+	switch err {
+	case nil:
+	case topo.ErrPartialResult:
+		// the rebuild ignores the missing tablets too
+		log.Warningf("some tablets of %v/%v in cell %v could not be read", janitor.keyspace, janitor.shard, cell)
+	default:
+		return nil, err
+	}
+
+	expected := make(map[topo.TabletType]map[uint32]*topo.EndPoint)
+	for _, ti := range tablets {
+		if ti.Alias.Cell != cell || !ti.IsInReplicationGraph() || !ti.IsInServingGraph() {
+			continue
+		}
+		entry, err := ti.Tablet.EndPoint()
+		if err != nil {
+			// the rebuild would skip it too
+			continue
+		}
+		if expected[ti.Type] == nil {
+			expected[ti.Type] = make(map[uint32]*topo.EndPoint)
+		}
+		expected[ti.Type][entry.Uid] = entry
+	}
+
+	actual := make(map[topo.TabletType]map[uint32]*topo.EndPoint)
+	tabletTypes, err := ts.GetSrvTabletTypesPerShard(cell, janitor.keyspace, janitor.shard)
+	if err != nil && err != topo.ErrNoNode {
+		return nil, err
+	}
+	for _, tabletType := range tabletTypes {
+		addrs, err := ts.GetEndPoints(cell, janitor.keyspace, janitor.shard, tabletType)
+		if err == topo.ErrNoNode {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		actual[tabletType] = make(map[uint32]*topo.EndPoint)
+		for i := range addrs.Entries {
+			actual[tabletType][addrs.Entries[i].Uid] = &addrs.Entries[i]
+		}
+	}
+
+	var diffs []string
+	for tabletType, entries := range expected {
+		for uid, entry := range entries {
+			other, ok := actual[tabletType][uid]
+			switch {
+			case !ok:
+				diffs = append(diffs, fmt.Sprintf("%v %v missing", tabletType, uid))
+			case !topo.EndPointEquality(entry, other):
+				diffs = append(diffs, fmt.Sprintf("%v %v outdated", tabletType, uid))
+			}
+		}
+	}
+	for tabletType, entries := range actual {
+		for uid := range entries {
+			if _, ok := expected[tabletType][uid]; !ok {
+				diffs = append(diffs, fmt.Sprintf("%v %v extra", tabletType, uid))
+			}
+		}
+	}
+	sort.Strings(diffs)
+	return diffs, nil
+}
+
+// History returns the recent events of the janitor.
+func (janitor *servingGraphJanitor) History() []HistoryEntry {
+	return janitor.history.Entries()
+}
+
+func (janitor *servingGraphJanitor) StatusTemplate() string {
+	return historyTemplate
+}
+
+func init() {
+	Register("serving_graph", &servingGraphJanitor{})
+}
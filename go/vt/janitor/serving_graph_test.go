@@ -0,0 +1,64 @@
+package janitor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/memorytopo"
+	_ "github.com/youtube/vitess/go/vt/tabletmanager/gorpctmclient"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/wrangler"
+	"github.com/youtube/vitess/go/vt/wrangler/testlib"
+)
+
+func TestServingGraphJanitor(t *testing.T) {
+	ts := memorytopo.NewTestServer(t, []string{"cell1"})
+	defer ts.Close()
+	wr := wrangler.New(ts, time.Minute, time.Second)
+	wr.UseRPCs = false
+
+	master := testlib.NewFakeTablet(t, wr, "cell1", 0, topo.TYPE_MASTER)
+	testlib.NewFakeTablet(t, wr, "cell1", 1, topo.TYPE_REPLICA, testlib.TabletParent(master.Tablet.Alias))
+	if err := wr.RebuildShardGraph("test_keyspace", "0", nil); err != nil {
+		t.Fatalf("RebuildShardGraph failed: %v", err)
+	}
+
+	janitor := &servingGraphJanitor{}
+	if err := janitor.Configure(wr, "test_keyspace", "0"); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+	diffs, err := janitor.cellDrift("cell1")
+	if err != nil || len(diffs) != 0 {
+		t.Fatalf("cellDrift after rebuild: got %v %v, want no drift", diffs, err)
+	}
+
+	// remove the replica from the serving graph
+	if err := ts.DeleteEndPoints("cell1", "test_keyspace", "0", topo.TYPE_REPLICA); err != nil {
+		t.Fatalf("DeleteEndPoints failed: %v", err)
+	}
+	want := []string{"replica 1 missing"}
+	if diffs, err := janitor.cellDrift("cell1"); err != nil || !reflect.DeepEqual(diffs, want) {
+		t.Fatalf("cellDrift: got %v %v, want %v", diffs, err, want)
+	}
+
+	// dry run doesn't fix anything
+	if err := janitor.Run(false); err != nil {
+		t.Fatalf("Run(false) failed: %v", err)
+	}
+	if diffs, err := janitor.cellDrift("cell1"); err != nil || !reflect.DeepEqual(diffs, want) {
+		t.Fatalf("cellDrift after dry run: got %v %v, want %v", diffs, err, want)
+	}
+	if history := janitor.History(); len(history) != 1 || !strings.HasPrefix(history[0].Message, "[dry run]") {
+		t.Errorf("History after dry run: got %v", history)
+	}
+
+	// active run rebuilds the cell
+	if err := janitor.Run(true); err != nil {
+		t.Fatalf("Run(true) failed: %v", err)
+	}
+	if diffs, err := janitor.cellDrift("cell1"); err != nil || len(diffs) != 0 {
+		t.Errorf("cellDrift after run: got %v %v, want no drift", diffs, err)
+	}
+}
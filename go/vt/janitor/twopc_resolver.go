@@ -0,0 +1,152 @@
+package janitor
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/concurrency"
+	"github.com/youtube/vitess/go/vt/context"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"github.com/youtube/vitess/go/vt/wrangler"
+)
+
+var (
+	// twoPCAbandonAge is how old a distributed transaction of the
+	// coordinator log has to be before the resolver takes it over
+	// from the vtgate that started it.
+	twoPCAbandonAge = 5 * time.Minute
+
+	// twoPCDialTimeout is how long we wait to connect to a tablet.
+	twoPCDialTimeout = 30 * time.Second
+)
+
+// twoPCResolverJanitor finishes the distributed transactions that
+// the shard master coordinates, and that were abandoned by their
+// vtgate: the prepared participants are committed if the commit
+// decision was recorded, and rolled back otherwise.
+type twoPCResolverJanitor struct {
+	wr       *wrangler.Wrangler
+	keyspace string
+	shard    string
+	history  history
+}
+
+func (janitor *twoPCResolverJanitor) Configure(wr *wrangler.Wrangler, keyspace, shard string) error {
+	janitor.wr = wr
+	janitor.keyspace = keyspace
+	janitor.shard = shard
+	return nil
+}
+
+func (janitor *twoPCResolverJanitor) Run(active bool) error {
+	mm, err := janitor.dialMaster(janitor.keyspace, janitor.shard)
+	if err != nil {
+		return err
+	}
+	defer mm.Close()
+
+	transactions, err := mm.UnresolvedTransactions(&context.DummyContext{}, twoPCAbandonAge)
+	if err != nil {
+		return fmt.Errorf("UnresolvedTransactions(%v/%v) failed: %v", janitor.keyspace, janitor.shard, err)
+	}
+	rec := concurrency.AllErrorRecorder{}
+	for _, dt := range transactions {
+		rec.RecordError(janitor.resolve(mm, dt, active))
+	}
+	return rec.Error()
+}
+
+// resolve commits or rolls back the participants of the distributed
+// transaction, then removes it from the coordinator log.
+func (janitor *twoPCResolverJanitor) resolve(mm tabletconn.TabletConn, dt tproto.DistributedTransaction, active bool) error {
+	commit := dt.State == tproto.DT_COMMIT
+	action := "roll back"
+	if commit {
+		action = "commit"
+	}
+	if !active {
+		janitor.history.Add("[dry run] would %v distributed transaction %v (%v)", action, dt.Dtid, dt.State)
+		log.Infof("[dry run] would %v distributed transaction %v (%v)", action, dt.Dtid, dt.State)
+		return nil
+	}
+
+	ctx := &context.DummyContext{}
+	if dt.State == tproto.DT_PREPARE {
+		// SetRollback fails if vtgate records the commit
+		// decision in the meantime, the next run commits then.
+		if err := mm.SetRollback(ctx, dt.Dtid, 0); err != nil {
+			return fmt.Errorf("SetRollback(%v) failed: %v", dt.Dtid, err)
+		}
+	}
+
+	rec := concurrency.AllErrorRecorder{}
+	for _, participant := range dt.Participants {
+		rec.RecordError(janitor.resolveParticipant(participant, dt.Dtid, commit))
+	}
+	if rec.HasErrors() {
+		janitor.history.Add("cannot %v distributed transaction %v: %v", action, dt.Dtid, rec.Error())
+		return rec.Error()
+	}
+	if err := mm.ConcludeTransaction(ctx, dt.Dtid); err != nil {
+		return fmt.Errorf("ConcludeTransaction(%v) failed: %v", dt.Dtid, err)
+	}
+	janitor.history.Add("distributed transaction %v is resolved (%v)", dt.Dtid, action)
+	log.Infof("distributed transaction %v is resolved (%v)", dt.Dtid, action)
+	return nil
+}
+
+// resolveParticipant commits or rolls back dtid on the participant.
+// Both calls do nothing if the participant was already resolved.
+func (janitor *twoPCResolverJanitor) resolveParticipant(participant tproto.TxParticipant, dtid string, commit bool) error {
+	conn, err := janitor.dialMaster(participant.Keyspace, participant.Shard)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if commit {
+		err = conn.CommitPrepared(&context.DummyContext{}, dtid)
+	} else {
+		err = conn.RollbackPrepared(&context.DummyContext{}, dtid, 0)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot resolve %v on %v/%v: %v", dtid, participant.Keyspace, participant.Shard, err)
+	}
+	return nil
+}
+
+// dialMaster connects to the query service of the master of the shard.
+func (janitor *twoPCResolverJanitor) dialMaster(keyspace, shard string) (tabletconn.TabletConn, error) {
+	si, err := janitor.wr.TopoServer().GetShard(keyspace, shard)
+	if err != nil {
+		return nil, err
+	}
+	if si.MasterAlias.IsZero() {
+		return nil, fmt.Errorf("no master for shard %v/%v", keyspace, shard)
+	}
+	ti, err := janitor.wr.TopoServer().GetTablet(si.MasterAlias)
+	if err != nil {
+		return nil, err
+	}
+	endPoint, err := ti.EndPoint()
+	if err != nil {
+		return nil, err
+	}
+	return tabletconn.GetDialer()(&context.DummyContext{}, *endPoint, keyspace, shard, twoPCDialTimeout)
+}
+
+// History returns the recent events of the janitor.
+func (janitor *twoPCResolverJanitor) History() []HistoryEntry {
+	return janitor.history.Entries()
+}
+
+func (janitor *twoPCResolverJanitor) StatusTemplate() string {
+	return `
+<p>Distributed transactions older than ` + twoPCAbandonAge.String() + ` are resolved.</p>
+` + historyTemplate
+}
+
+func init() {
+	Register("twopc_resolver", &twoPCResolverJanitor{})
+}
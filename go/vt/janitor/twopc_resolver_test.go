@@ -0,0 +1,216 @@
+package janitor
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/context"
+	"github.com/youtube/vitess/go/vt/memorytopo"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/wrangler"
+	"github.com/youtube/vitess/go/vt/wrangler/testlib"
+)
+
+// fakeTwoPCShards are the query services of the shard masters, for
+// the connections of the fakeTwoPCConn dialer.
+type fakeTwoPCShards struct {
+	mu sync.Mutex
+	// calls are the two-phase commit calls, by shard
+	calls map[string][]string
+	// transactions is the coordinator log of shard 0
+	transactions []tproto.DistributedTransaction
+	// failures are the calls that fail
+	failures map[string]bool
+}
+
+var twoPCShards = &fakeTwoPCShards{}
+
+func (shards *fakeTwoPCShards) reset(transactions []tproto.DistributedTransaction, failures ...string) {
+	shards.mu.Lock()
+	defer shards.mu.Unlock()
+	shards.calls = make(map[string][]string)
+	shards.transactions = transactions
+	shards.failures = make(map[string]bool)
+	for _, failure := range failures {
+		shards.failures[failure] = true
+	}
+}
+
+func (shards *fakeTwoPCShards) call(shard, format string, args ...interface{}) error {
+	shards.mu.Lock()
+	defer shards.mu.Unlock()
+	call := fmt.Sprintf(format, args...)
+	shards.calls[shard] = append(shards.calls[shard], call)
+	if shards.failures[shard+" "+call] {
+		return fmt.Errorf("%v failed", call)
+	}
+	return nil
+}
+
+func (shards *fakeTwoPCShards) takeCalls() map[string][]string {
+	shards.mu.Lock()
+	defer shards.mu.Unlock()
+	calls := shards.calls
+	shards.calls = make(map[string][]string)
+	return calls
+}
+
+// fakeTwoPCConn implements the two-phase commit calls of
+// tabletconn.TabletConn, the other calls panic.
+type fakeTwoPCConn struct {
+	tabletconn.TabletConn
+	shard string
+}
+
+func (conn *fakeTwoPCConn) UnresolvedTransactions(context context.Context, abandonAge time.Duration) ([]tproto.DistributedTransaction, error) {
+	if err := twoPCShards.call(conn.shard, "UnresolvedTransactions"); err != nil {
+		return nil, err
+	}
+	twoPCShards.mu.Lock()
+	defer twoPCShards.mu.Unlock()
+	return twoPCShards.transactions, nil
+}
+
+func (conn *fakeTwoPCConn) SetRollback(context context.Context, dtid string, transactionId int64) error {
+	return twoPCShards.call(conn.shard, "SetRollback(%v)", dtid)
+}
+
+func (conn *fakeTwoPCConn) ConcludeTransaction(context context.Context, dtid string) error {
+	return twoPCShards.call(conn.shard, "ConcludeTransaction(%v)", dtid)
+}
+
+func (conn *fakeTwoPCConn) CommitPrepared(context context.Context, dtid string) error {
+	return twoPCShards.call(conn.shard, "CommitPrepared(%v)", dtid)
+}
+
+func (conn *fakeTwoPCConn) RollbackPrepared(context context.Context, dtid string, transactionId int64) error {
+	return twoPCShards.call(conn.shard, "RollbackPrepared(%v)", dtid)
+}
+
+func (conn *fakeTwoPCConn) Close() {
+}
+
+func init() {
+	tabletconn.RegisterDialer("janitor_twopc_test", func(context context.Context, endPoint topo.EndPoint, keyspace, shard string, timeout time.Duration) (tabletconn.TabletConn, error) {
+		return &fakeTwoPCConn{shard: shard}, nil
+	})
+}
+
+func TestTwoPCResolverJanitor(t *testing.T) {
+	flag.Set("tablet_protocol", "janitor_twopc_test")
+	ts := memorytopo.NewTestServer(t, []string{"cell1"})
+	defer ts.Close()
+	wr := wrangler.New(ts, time.Minute, time.Second)
+	wr.UseRPCs = false
+
+	for i, shard := range []string{"0", "-80", "80-"} {
+		testlib.NewFakeTablet(t, wr, "cell1", uint32(i), topo.TYPE_MASTER,
+			testlib.TabletKeyspaceShard(t, "test_keyspace", shard))
+	}
+	janitor := &twoPCResolverJanitor{}
+	if err := janitor.Configure(wr, "test_keyspace", "0"); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+
+	participants := []tproto.TxParticipant{
+		{Keyspace: "test_keyspace", Shard: "-80"},
+		{Keyspace: "test_keyspace", Shard: "80-"},
+	}
+	twoPCShards.reset([]tproto.DistributedTransaction{
+		{Dtid: "dt1", State: tproto.DT_PREPARE, Participants: participants},
+		{Dtid: "dt2", State: tproto.DT_COMMIT, Participants: participants},
+		{Dtid: "dt3", State: tproto.DT_ROLLBACK, Participants: participants[:1]},
+	})
+
+	// dry run doesn't resolve anything
+	if err := janitor.Run(false); err != nil {
+		t.Fatalf("Run(false) failed: %v", err)
+	}
+	want := map[string][]string{"0": {"UnresolvedTransactions"}}
+	if got := twoPCShards.takeCalls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("dry run: got %v, want %v", got, want)
+	}
+	if history := janitor.History(); len(history) != 3 || !strings.HasPrefix(history[0].Message, "[dry run]") {
+		t.Errorf("History after dry run: got %v", history)
+	}
+
+	// the prepared transaction is marked for rollback first
+	if err := janitor.Run(true); err != nil {
+		t.Fatalf("Run(true) failed: %v", err)
+	}
+	want = map[string][]string{
+		"0": {
+			"UnresolvedTransactions",
+			"SetRollback(dt1)",
+			"ConcludeTransaction(dt1)",
+			"ConcludeTransaction(dt2)",
+			"ConcludeTransaction(dt3)",
+		},
+		"-80": {"RollbackPrepared(dt1)", "CommitPrepared(dt2)", "RollbackPrepared(dt3)"},
+		"80-": {"RollbackPrepared(dt1)", "CommitPrepared(dt2)"},
+	}
+	if got := twoPCShards.takeCalls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Run: got %v, want %v", got, want)
+	}
+}
+
+func TestTwoPCResolverJanitorFailures(t *testing.T) {
+	flag.Set("tablet_protocol", "janitor_twopc_test")
+	ts := memorytopo.NewTestServer(t, []string{"cell1"})
+	defer ts.Close()
+	wr := wrangler.New(ts, time.Minute, time.Second)
+	wr.UseRPCs = false
+
+	for i, shard := range []string{"0", "-80", "80-"} {
+		testlib.NewFakeTablet(t, wr, "cell1", uint32(i), topo.TYPE_MASTER,
+			testlib.TabletKeyspaceShard(t, "test_keyspace", shard))
+	}
+	janitor := &twoPCResolverJanitor{}
+	if err := janitor.Configure(wr, "test_keyspace", "0"); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+
+	// vtgate recorded the commit decision of dt1 meanwhile, and
+	// dt2 cannot be committed on 80-: they stay in the coordinator
+	// log for the next run.
+	participants := []tproto.TxParticipant{
+		{Keyspace: "test_keyspace", Shard: "-80"},
+		{Keyspace: "test_keyspace", Shard: "80-"},
+	}
+	twoPCShards.reset([]tproto.DistributedTransaction{
+		{Dtid: "dt1", State: tproto.DT_PREPARE, Participants: participants},
+		{Dtid: "dt2", State: tproto.DT_COMMIT, Participants: participants},
+	}, "0 SetRollback(dt1)", "80- CommitPrepared(dt2)")
+	err := janitor.Run(true)
+	if err == nil || !strings.Contains(err.Error(), "SetRollback(dt1) failed") || !strings.Contains(err.Error(), "CommitPrepared(dt2) failed") {
+		t.Errorf("Run: got %v, want the SetRollback and CommitPrepared errors", err)
+	}
+	want := map[string][]string{
+		"0":   {"UnresolvedTransactions", "SetRollback(dt1)"},
+		"-80": {"CommitPrepared(dt2)"},
+		"80-": {"CommitPrepared(dt2)"},
+	}
+	if got := twoPCShards.takeCalls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Run: got %v, want %v", got, want)
+	}
+
+	// no master for the coordinator shard
+	si, err := ts.GetShard("test_keyspace", "0")
+	if err != nil {
+		t.Fatalf("GetShard failed: %v", err)
+	}
+	si.MasterAlias = topo.TabletAlias{}
+	if err := ts.UpdateShard(si); err != nil {
+		t.Fatalf("UpdateShard failed: %v", err)
+	}
+	if err := janitor.Run(true); err == nil || !strings.Contains(err.Error(), "no master") {
+		t.Errorf("Run without a master: got %v, want a no master error", err)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memorytopo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+/*
+This file contains the remote tablet action code of memorytopo.Server
+*/
+
+// tabletAction is an action in the queue of a tablet.
+type tabletAction struct {
+	id       int64
+	contents string
+	version  int64
+}
+
+// actionPath returns the action path for an action.
+// actionPath is <cell>-<uid>/action/<id>
+func actionPath(tabletAlias topo.TabletAlias, id int64) string {
+	return fmt.Sprintf("%v/action/%v", tabletAlias, id)
+}
+
+// parseActionPath parses an actionPath back.
+func parseActionPath(actionPath string) (topo.TabletAlias, int64, error) {
+	pathParts := strings.Split(actionPath, "/")
+	if len(pathParts) != 3 || pathParts[1] != "action" {
+		return topo.TabletAlias{}, 0, fmt.Errorf("invalid action path: %v", actionPath)
+	}
+	tabletAlias, err := topo.ParseTabletAliasString(pathParts[0])
+	if err != nil {
+		return topo.TabletAlias{}, 0, err
+	}
+	id, err := strconv.ParseInt(pathParts[2], 10, 64)
+	if err != nil {
+		return topo.TabletAlias{}, 0, fmt.Errorf("invalid action path: %v", actionPath)
+	}
+	return tabletAlias, id, nil
+}
+
+// notifyQueueChanged wakes up the action loop of the tablet. It must be
+// called with s.mu held.
+func (t *tablet) notifyQueueChanged() {
+	close(t.queueChanged)
+	t.queueChanged = make(chan struct{})
+}
+
+// findAction returns the tablet and the index of an action in its
+// queue. It must be called with s.mu held.
+func (s *Server) findAction(actionPath string) (*tablet, int, error) {
+	tabletAlias, id, err := parseActionPath(actionPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	t := s.getTablet(tabletAlias)
+	if t == nil {
+		return nil, 0, topo.ErrNoNode
+	}
+	for i, a := range t.actions {
+		if a.id == id {
+			return t, i, nil
+		}
+	}
+	return nil, 0, topo.ErrNoNode
+}
+
+// WriteTabletAction implements topo.Server.
+func (s *Server) WriteTabletAction(tabletAlias topo.TabletAlias, contents string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.getTablet(tabletAlias)
+	if t == nil {
+		return "", topo.ErrNoNode
+	}
+	id := s.changed()
+	t.actions = append(t.actions, &tabletAction{
+		id:       id,
+		contents: contents,
+		version:  id,
+	})
+	t.notifyQueueChanged()
+	return actionPath(tabletAlias, id), nil
+}
+
+// WaitForTabletAction implements topo.Server.
+func (s *Server) WaitForTabletAction(actionPath string, waitTime time.Duration, interrupted chan struct{}) (string, error) {
+	tabletAlias, id, err := parseActionPath(actionPath)
+	if err != nil {
+		return "", err
+	}
+
+	timer := time.NewTimer(waitTime)
+	defer timer.Stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		t := s.getTablet(tabletAlias)
+		if t == nil {
+			return "", fmt.Errorf("action err: %v %v", actionPath, topo.ErrNoNode)
+		}
+		if response, ok := t.actionLog[id]; ok {
+			return response, nil
+		}
+
+		// The response is not there yet, wait for it.
+		if err := s.waitForChange(timer.C, interrupted); err != nil {
+			return "", err
+		}
+	}
+}
+
+// PurgeTabletActions implements topo.Server.
+func (s *Server) PurgeTabletActions(tabletAlias topo.TabletAlias, canBePurged func(data string) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.getTablet(tabletAlias)
+	if t == nil {
+		return topo.ErrNoNode
+	}
+	actions := make([]*tabletAction, 0, len(t.actions))
+	for _, a := range t.actions {
+		if !canBePurged(a.contents) {
+			actions = append(actions, a)
+		}
+	}
+	if len(actions) != len(t.actions) {
+		t.actions = actions
+		t.notifyQueueChanged()
+		s.changed()
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memorytopo
+
+import (
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+/*
+This file contains the code to support the local agent process for memorytopo.Server
+*/
+
+// ValidateTabletActions implements topo.Server.
+func (s *Server) ValidateTabletActions(tabletAlias topo.TabletAlias) error {
+	// The action queue is created with the tablet.
+	return s.ValidateTablet(tabletAlias)
+}
+
+// CreateTabletPidNode implements topo.Server.
+func (s *Server) CreateTabletPidNode(tabletAlias topo.TabletAlias, contents string, done chan struct{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.getTablet(tabletAlias)
+	if t == nil {
+		return topo.ErrNoNode
+	}
+	t.pid = contents
+	t.hasPid = true
+	s.changed()
+
+	go func() {
+		<-done
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if t.pid == contents {
+			t.pid = ""
+			t.hasPid = false
+			s.changed()
+		}
+	}()
+	return nil
+}
+
+// ValidateTabletPidNode implements topo.Server.
+func (s *Server) ValidateTabletPidNode(tabletAlias topo.TabletAlias) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.getTablet(tabletAlias)
+	if t == nil || !t.hasPid {
+		return topo.ErrNoNode
+	}
+	return nil
+}
+
+// ActionEventLoop implements topo.Server.
+func (s *Server) ActionEventLoop(tabletAlias topo.TabletAlias, dispatchAction func(actionPath, data string) error, done chan struct{}) {
+	for {
+		// Read the current queue, and the channel that will tell
+		// us when it changes.
+		s.mu.Lock()
+		t := s.getTablet(tabletAlias)
+		if t == nil {
+			s.mu.Unlock()
+			log.Warningf("tablet %v doesn't exist, will try again in 5 seconds", tabletAlias)
+			select {
+			case <-time.After(5 * time.Second):
+				continue
+			case <-done:
+				return
+			}
+		}
+		actions := make([]tabletAction, len(t.actions))
+		for i, a := range t.actions {
+			actions[i] = *a
+		}
+		queueChanged := t.queueChanged
+		s.mu.Unlock()
+
+		// Process all pending actions, until one fails.
+		for _, a := range actions {
+			if err := dispatchAction(actionPath(tabletAlias, a.id), a.contents); err != nil {
+				break
+			}
+		}
+
+		select {
+		case <-queueChanged:
+		case <-done:
+			return
+		}
+	}
+}
+
+// ReadTabletActionPath implements topo.Server.
+func (s *Server) ReadTabletActionPath(actionPath string) (topo.TabletAlias, string, int64, error) {
+	tabletAlias, _, err := parseActionPath(actionPath)
+	if err != nil {
+		return topo.TabletAlias{}, "", 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, i, err := s.findAction(actionPath)
+	if err != nil {
+		return topo.TabletAlias{}, "", 0, err
+	}
+	return tabletAlias, t.actions[i].contents, t.actions[i].version, nil
+}
+
+// UpdateTabletAction implements topo.Server.
+func (s *Server) UpdateTabletAction(actionPath, data string, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, i, err := s.findAction(actionPath)
+	if err != nil {
+		return err
+	}
+	a := t.actions[i]
+	if version != -1 && a.version != version {
+		return topo.ErrBadVersion
+	}
+	a.contents = data
+	a.version = s.changed()
+	return nil
+}
+
+// StoreTabletActionResponse implements topo.Server.
+// It stores the data both in the action and the action log.
+func (s *Server) StoreTabletActionResponse(actionPath, data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, i, err := s.findAction(actionPath)
+	if err != nil {
+		return err
+	}
+	a := t.actions[i]
+	a.contents = data
+	a.version = s.changed()
+	t.actionLog[a.id] = data
+	return nil
+}
+
+// UnblockTabletAction implements topo.Server.
+func (s *Server) UnblockTabletAction(actionPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, i, err := s.findAction(actionPath)
+	if err != nil {
+		return err
+	}
+	t.actions = append(t.actions[:i], t.actions[i+1:]...)
+	t.notifyQueueChanged()
+	s.changed()
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memorytopo
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/youtube/vitess/go/event"
+	"github.com/youtube/vitess/go/jscfg"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/topo/events"
+)
+
+/*
+This file contains the Keyspace management code for memorytopo.Server
+*/
+
+// CreateKeyspace implements topo.Server.
+func (s *Server) CreateKeyspace(keyspaceName string, value *topo.Keyspace) error {
+	s.mu.Lock()
+	ks, ok := s.keyspaces[keyspaceName]
+	if ok && ks.value != "" {
+		s.mu.Unlock()
+		return topo.ErrNodeExists
+	}
+	if !ok {
+		ks = &keyspace{
+			shards: make(map[string]*shard),
+		}
+		s.keyspaces[keyspaceName] = ks
+	}
+	ks.value = jscfg.ToJson(value)
+	s.changed()
+	s.mu.Unlock()
+
+	event.Dispatch(&events.KeyspaceChange{
+		KeyspaceInfo: *topo.NewKeyspaceInfo(keyspaceName, value),
+		Status:       "created",
+	})
+	return nil
+}
+
+// UpdateKeyspace implements topo.Server.
+func (s *Server) UpdateKeyspace(ki *topo.KeyspaceInfo) error {
+	s.mu.Lock()
+	ks, ok := s.keyspaces[ki.KeyspaceName()]
+	if !ok || ks.value == "" {
+		s.mu.Unlock()
+		return topo.ErrNoNode
+	}
+	ks.value = jscfg.ToJson(ki.Keyspace)
+	s.changed()
+	s.mu.Unlock()
+
+	event.Dispatch(&events.KeyspaceChange{
+		KeyspaceInfo: *ki,
+		Status:       "updated",
+	})
+	return nil
+}
+
+// GetKeyspace implements topo.Server.
+func (s *Server) GetKeyspace(keyspaceName string) (*topo.KeyspaceInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ks, ok := s.keyspaces[keyspaceName]
+	if !ok || ks.value == "" {
+		return nil, topo.ErrNoNode
+	}
+
+	k := &topo.Keyspace{}
+	if err := json.Unmarshal([]byte(ks.value), k); err != nil {
+		return nil, fmt.Errorf("bad keyspace data %v", err)
+	}
+	return topo.NewKeyspaceInfo(keyspaceName, k), nil
+}
+
+// GetKeyspaces implements topo.Server.
+func (s *Server) GetKeyspaces() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]string, 0, len(s.keyspaces))
+	for name, ks := range s.keyspaces {
+		if ks.value != "" {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// DeleteKeyspaceShards implements topo.Server.
+func (s *Server) DeleteKeyspaceShards(keyspaceName string) error {
+	s.mu.Lock()
+	if ks, ok := s.keyspaces[keyspaceName]; ok {
+		ks.shards = nil
+		s.changed()
+	}
+	s.mu.Unlock()
+
+	event.Dispatch(&events.KeyspaceChange{
+		KeyspaceInfo: *topo.NewKeyspaceInfo(keyspaceName, nil),
+		Status:       "deleted all shards",
+	})
+	return nil
+}
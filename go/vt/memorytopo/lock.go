@@ -0,0 +1,179 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memorytopo
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+/*
+This file contains the lock management code for memorytopo.Server
+
+The lock path returned to the caller is the version of the Server
+when the lock was taken. The results passed to the unlock functions
+are only logged, as there is nobody to read them back.
+*/
+
+// lockForAction takes the lock returned by getLock, waiting for the
+// current holder to release it if needed. getLock is called with
+// s.mu held, every time the lock is checked.
+func (s *Server) lockForAction(name string, getLock func() (*actionLock, error), contents string, timeout time.Duration, interrupted chan struct{}) (string, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		l, err := getLock()
+		if err != nil {
+			return "", err
+		}
+		if l.id == 0 {
+			l.id = s.changed()
+			l.contents = contents
+			return strconv.FormatInt(l.id, 10), nil
+		}
+
+		// Somebody else holds the lock, wait for a change.
+		if err := s.waitForChange(timer.C, interrupted); err != nil {
+			log.Warningf("Failed to obtain action lock %v: %v", name, err)
+			log.Warningf("------ Most likely blocking action: %v\n%v", name, l.contents)
+			return "", err
+		}
+	}
+}
+
+// unlockForAction releases the lock returned by getLock, if it is
+// still the one identified by lockPath.
+func (s *Server) unlockForAction(name string, getLock func() (*actionLock, error), lockPath, results string) error {
+	id, err := strconv.ParseInt(lockPath, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid lock path %v: %v", lockPath, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, err := getLock()
+	if err != nil {
+		return err
+	}
+	if l.id != id {
+		return fmt.Errorf("lock %v is not held by %v anymore (now held by %v)", name, lockPath, l.id)
+	}
+	l.id = 0
+	l.contents = ""
+	s.changed()
+	log.V(6).Infof("Unlocked %v: %v", name, results)
+	return nil
+}
+
+// LockSrvShardForAction implements topo.Server.
+func (s *Server) LockSrvShardForAction(cellName, keyspace, shard, contents string, timeout time.Duration, interrupted chan struct{}) (string, error) {
+	return s.lockForAction(fmt.Sprintf("SrvShard %v/%v/%v", cellName, keyspace, shard), func() (*actionLock, error) {
+		return &s.getOrCreateSrvShard(cellName, keyspace, shard).lock, nil
+	}, contents, timeout, interrupted)
+}
+
+// UnlockSrvShardForAction implements topo.Server.
+func (s *Server) UnlockSrvShardForAction(cellName, keyspace, shard, lockPath, results string) error {
+	return s.unlockForAction(fmt.Sprintf("SrvShard %v/%v/%v", cellName, keyspace, shard), func() (*actionLock, error) {
+		ss := s.getSrvShard(cellName, keyspace, shard)
+		if ss == nil {
+			return nil, topo.ErrNoNode
+		}
+		return &ss.lock, nil
+	}, lockPath, results)
+}
+
+// getKeyspaceLock returns the lock of a keyspace. It must be called
+// with s.mu held.
+func (s *Server) getKeyspaceLock(keyspaceName string) (*actionLock, error) {
+	ks, ok := s.keyspaces[keyspaceName]
+	if !ok || ks.value == "" {
+		return nil, topo.ErrNoNode
+	}
+	return &ks.lock, nil
+}
+
+// LockKeyspaceForAction implements topo.Server.
+func (s *Server) LockKeyspaceForAction(keyspace, contents string, timeout time.Duration, interrupted chan struct{}) (string, error) {
+	return s.lockForAction(fmt.Sprintf("Keyspace %v", keyspace), func() (*actionLock, error) {
+		return s.getKeyspaceLock(keyspace)
+	}, contents, timeout, interrupted)
+}
+
+// UnlockKeyspaceForAction implements topo.Server.
+func (s *Server) UnlockKeyspaceForAction(keyspace, lockPath, results string) error {
+	return s.unlockForAction(fmt.Sprintf("Keyspace %v", keyspace), func() (*actionLock, error) {
+		return s.getKeyspaceLock(keyspace)
+	}, lockPath, results)
+}
+
+// getShardLock returns the lock of a shard. It must be called with
+// s.mu held.
+func (s *Server) getShardLock(keyspace, shard string) (*actionLock, error) {
+	sh := s.getShard(keyspace, shard)
+	if sh == nil {
+		return nil, topo.ErrNoNode
+	}
+	return &sh.lock, nil
+}
+
+// LockShardForAction implements topo.Server.
+func (s *Server) LockShardForAction(keyspace, shard, contents string, timeout time.Duration, interrupted chan struct{}) (string, error) {
+	return s.lockForAction(fmt.Sprintf("Shard %v/%v", keyspace, shard), func() (*actionLock, error) {
+		return s.getShardLock(keyspace, shard)
+	}, contents, timeout, interrupted)
+}
+
+// UnlockShardForAction implements topo.Server.
+func (s *Server) UnlockShardForAction(keyspace, shard, lockPath, results string) error {
+	return s.unlockForAction(fmt.Sprintf("Shard %v/%v", keyspace, shard), func() (*actionLock, error) {
+		return s.getShardLock(keyspace, shard)
+	}, lockPath, results)
+}
+
+// getShardElectionLock returns the election lock of a shard. It must
+// be called with s.mu held.
+func (s *Server) getShardElectionLock(keyspace, shard string) (*actionLock, error) {
+	sh := s.getShard(keyspace, shard)
+	if sh == nil {
+		return nil, topo.ErrNoNode
+	}
+	return &sh.election, nil
+}
+
+// LockShardForElection implements topo.Server.
+func (s *Server) LockShardForElection(keyspace, shard, contents string, timeout time.Duration, interrupted chan struct{}) (string, error) {
+	return s.lockForAction(fmt.Sprintf("Election %v/%v", keyspace, shard), func() (*actionLock, error) {
+		return s.getShardElectionLock(keyspace, shard)
+	}, contents, timeout, interrupted)
+}
+
+// UnlockShardForElection implements topo.Server.
+func (s *Server) UnlockShardForElection(keyspace, shard, lockPath string) error {
+	return s.unlockForAction(fmt.Sprintf("Election %v/%v", keyspace, shard), func() (*actionLock, error) {
+		return s.getShardElectionLock(keyspace, shard)
+	}, lockPath, "")
+}
+
+// GetShardElectionLeader implements topo.Server.
+func (s *Server) GetShardElectionLeader(keyspace, shard string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, err := s.getShardElectionLock(keyspace, shard)
+	if err != nil {
+		return "", err
+	}
+	if l.id == 0 {
+		return "", topo.ErrNoNode
+	}
+	return l.contents, nil
+}
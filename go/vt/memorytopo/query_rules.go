@@ -0,0 +1,61 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memorytopo
+
+import (
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+/*
+This file contains the query rules management code for memorytopo.Server
+*/
+
+// SaveQueryRules implements topo.Server.
+func (s *Server) SaveQueryRules(keyspace, shard, rules string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh := s.getShard(keyspace, shard)
+	if sh == nil {
+		return topo.ErrNoNode
+	}
+	sh.queryRules = rules
+	sh.hasQueryRules = true
+	s.changed()
+	return nil
+}
+
+// GetQueryRules implements topo.Server.
+func (s *Server) GetQueryRules(keyspace, shard string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh := s.getShard(keyspace, shard)
+	if sh == nil || !sh.hasQueryRules {
+		return "", topo.ErrNoNode
+	}
+	return sh.queryRules, nil
+}
+
+// WatchQueryRules implements topo.Server.
+func (s *Server) WatchQueryRules(keyspace, shard string, stopWatching chan struct{}) (<-chan string, error) {
+	notifications := make(chan string, 10)
+	go func() {
+		defer close(notifications)
+		s.watchValue(func() (string, int64) {
+			sh := s.getShard(keyspace, shard)
+			if sh == nil {
+				return "", 0
+			}
+			return sh.queryRules, 0
+		}, stopWatching, func(value string, version int64) bool {
+			select {
+			case notifications <- value:
+				return true
+			case <-stopWatching:
+				return false
+			}
+		})
+	}()
+	return notifications, nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memorytopo
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/youtube/vitess/go/jscfg"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+/*
+This file contains the replication graph management code of memorytopo.Server
+*/
+
+// UpdateShardReplicationFields implements topo.Server.
+func (s *Server) UpdateShardReplicationFields(cellName, keyspace, shard string, update func(*topo.ShardReplication) error) error {
+	key := path.Join(keyspace, shard)
+	for {
+		// Read the current value, if any.
+		s.mu.Lock()
+		oldValue, exists := s.getOrCreateCell(cellName).replication[key]
+		s.mu.Unlock()
+
+		sr := &topo.ShardReplication{}
+		if exists {
+			if err := json.Unmarshal([]byte(oldValue), sr); err != nil {
+				return fmt.Errorf("bad ShardReplication data %v", err)
+			}
+		}
+		if err := update(sr); err != nil {
+			return err
+		}
+		newValue := jscfg.ToJson(sr)
+
+		// Save the new value, if nobody changed it in the meantime.
+		s.mu.Lock()
+		c := s.cells[cellName]
+		if value, ok := c.replication[key]; ok != exists || value != oldValue {
+			s.mu.Unlock()
+			continue
+		}
+		c.replication[key] = newValue
+		s.changed()
+		s.mu.Unlock()
+		return nil
+	}
+}
+
+// GetShardReplication implements topo.Server.
+func (s *Server) GetShardReplication(cellName, keyspace, shard string) (*topo.ShardReplicationInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.cells[cellName]
+	if !ok {
+		return nil, topo.ErrNoNode
+	}
+	value, ok := c.replication[path.Join(keyspace, shard)]
+	if !ok {
+		return nil, topo.ErrNoNode
+	}
+
+	sr := &topo.ShardReplication{}
+	if err := json.Unmarshal([]byte(value), sr); err != nil {
+		return nil, fmt.Errorf("bad ShardReplication data %v", err)
+	}
+	return topo.NewShardReplicationInfo(sr, cellName, keyspace, shard), nil
+}
+
+// DeleteShardReplication implements topo.Server.
+func (s *Server) DeleteShardReplication(cellName, keyspace, shard string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.cells[cellName]
+	if !ok {
+		return topo.ErrNoNode
+	}
+	key := path.Join(keyspace, shard)
+	if _, ok := c.replication[key]; !ok {
+		return topo.ErrNoNode
+	}
+	delete(c.replication, key)
+	s.changed()
+	return nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package memorytopo implements topo.Server entirely in memory. It
+// is meant to be used by unit tests, and by clusters that run in a
+// single process. All objects are stored as their JSON
+// representation, so callers never share data with the Server.
+//
+// Every change to the Server gets a new version number, taken from
+// a single counter. Versions are used for atomic updates of tablets,
+// and as lock paths.
+package memorytopo
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// Server is the in-memory implementation of topo.Server.
+type Server struct {
+	// mu protects all the fields below.
+	mu sync.Mutex
+
+	// version is the version of the last change.
+	version int64
+
+	// changes is closed and replaced every time something changes,
+	// so waiters can wait on it.
+	changes chan struct{}
+
+	// keyspaces is the global data.
+	keyspaces map[string]*keyspace
+
+	// vschema is the global VSchema, if hasVSchema is set.
+	vschema    string
+	hasVSchema bool
+
+	// cells has the per-cell data.
+	cells map[string]*cell
+}
+
+// actionLock is a lock on an object. id is 0 if the lock is free.
+type actionLock struct {
+	id       int64
+	contents string
+}
+
+// keyspace has the data for a keyspace and its shards. value is
+// empty if the keyspace was never created, but has shards.
+type keyspace struct {
+	value  string
+	lock   actionLock
+	shards map[string]*shard
+}
+
+type shard struct {
+	value    string
+	lock     actionLock
+	election actionLock
+
+	// queryRules is only valid if hasQueryRules is set.
+	queryRules    string
+	hasQueryRules bool
+}
+
+// cell has the data for one cell.
+type cell struct {
+	tablets      map[topo.TabletAlias]*tablet
+	replication  map[string]string
+	srvKeyspaces map[string]*srvKeyspace
+}
+
+// NewServer returns a new empty Server, that knows about the
+// provided cells. Other cells are added as they are used.
+func NewServer(cells []string) *Server {
+	s := &Server{
+		changes:   make(chan struct{}),
+		keyspaces: make(map[string]*keyspace),
+		cells:     make(map[string]*cell),
+	}
+	for _, c := range cells {
+		s.getOrCreateCell(c)
+	}
+	return s
+}
+
+func init() {
+	topo.RegisterServer("memory", NewServer(nil))
+}
+
+// changed records a change: it wakes up everybody waiting on
+// s.changes, and returns the new version. It must be called with
+// s.mu held.
+func (s *Server) changed() int64 {
+	s.version++
+	close(s.changes)
+	s.changes = make(chan struct{})
+	return s.version
+}
+
+// waitForChange waits until something changes in the Server, the
+// expired channel fires (returns topo.ErrTimeout) or interrupted is
+// closed (returns topo.ErrInterrupted). It must be called with s.mu
+// held, and returns with s.mu held.
+func (s *Server) waitForChange(expired <-chan time.Time, interrupted chan struct{}) error {
+	changes := s.changes
+	s.mu.Unlock()
+	defer s.mu.Lock()
+
+	select {
+	case <-changes:
+		return nil
+	case <-expired:
+		return topo.ErrTimeout
+	case <-interrupted:
+		return topo.ErrInterrupted
+	}
+}
+
+// getOrCreateCell returns the data for a cell, creating it if
+// needed. It must be called with s.mu held.
+func (s *Server) getOrCreateCell(cellName string) *cell {
+	c, ok := s.cells[cellName]
+	if !ok {
+		c = &cell{
+			tablets:      make(map[topo.TabletAlias]*tablet),
+			replication:  make(map[string]string),
+			srvKeyspaces: make(map[string]*srvKeyspace),
+		}
+		s.cells[cellName] = c
+	}
+	return c
+}
+
+// Close implements topo.Server.
+func (s *Server) Close() {
+}
+
+// GetSubprocessFlags implements topo.Server. The data is not shared
+// with subprocesses, they will start with an empty topology.
+func (s *Server) GetSubprocessFlags() []string {
+	return nil
+}
+
+// GetKnownCells implements topo.Server.
+func (s *Server) GetKnownCells() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]string, 0, len(s.cells))
+	for c := range s.cells {
+		result = append(result, c)
+	}
+	sort.Strings(result)
+	return result, nil
+}
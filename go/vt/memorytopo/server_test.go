@@ -0,0 +1,113 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memorytopo
+
+import (
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/topo/test"
+)
+
+func TestKeyspace(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckKeyspace(t, ts)
+}
+
+func TestVSchema(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckVSchema(t, ts)
+}
+
+func TestQueryRules(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckQueryRules(t, ts)
+}
+
+func TestShard(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckShard(t, ts)
+}
+
+func TestTablet(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckTablet(t, ts)
+}
+
+func TestShardReplication(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckShardReplication(t, ts)
+}
+
+func TestServingGraph(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckServingGraph(t, ts)
+}
+
+func TestWatchEndPoints(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckWatchEndPoints(t, ts)
+}
+
+func TestWatchSrvKeyspace(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckWatchSrvKeyspace(t, ts)
+}
+
+func TestKeyspaceLock(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckKeyspaceLock(t, ts)
+}
+
+func TestShardLock(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping wait-based test in short mode.")
+	}
+
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckShardLock(t, ts)
+}
+
+func TestShardElection(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping wait-based test in short mode.")
+	}
+
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckShardElection(t, ts)
+}
+
+func TestSrvShardLock(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping wait-based test in short mode.")
+	}
+
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckSrvShardLock(t, ts)
+}
+
+func TestPid(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckPid(t, ts)
+}
+
+func TestActions(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckActions(t, ts)
+}
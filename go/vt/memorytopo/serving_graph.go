@@ -0,0 +1,376 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memorytopo
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/jscfg"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+/*
+This file contains the serving graph management code of memorytopo.Server
+*/
+
+// srvKeyspace has the serving data for a keyspace in a cell. value
+// is empty if the SrvKeyspace was never saved, but some shards were.
+type srvKeyspace struct {
+	value   string
+	version int64
+	shards  map[string]*srvShard
+}
+
+// srvShard has the serving data for a shard in a cell. value is
+// empty if the SrvShard was never saved.
+type srvShard struct {
+	value     string
+	version   int64
+	lock      actionLock
+	endPoints map[topo.TabletType]string
+}
+
+// getSrvShard returns the serving data for a shard, or nil. It must
+// be called with s.mu held.
+func (s *Server) getSrvShard(cellName, keyspace, shard string) *srvShard {
+	c, ok := s.cells[cellName]
+	if !ok {
+		return nil
+	}
+	sk, ok := c.srvKeyspaces[keyspace]
+	if !ok {
+		return nil
+	}
+	return sk.shards[shard]
+}
+
+// getOrCreateSrvKeyspace returns the serving data for a keyspace,
+// creating it if needed. It must be called with s.mu held.
+func (s *Server) getOrCreateSrvKeyspace(cellName, keyspace string) *srvKeyspace {
+	c := s.getOrCreateCell(cellName)
+	sk, ok := c.srvKeyspaces[keyspace]
+	if !ok {
+		sk = &srvKeyspace{
+			shards: make(map[string]*srvShard),
+		}
+		c.srvKeyspaces[keyspace] = sk
+	}
+	return sk
+}
+
+// getOrCreateSrvShard returns the serving data for a shard,
+// creating it if needed. It must be called with s.mu held.
+func (s *Server) getOrCreateSrvShard(cellName, keyspace, shard string) *srvShard {
+	sk := s.getOrCreateSrvKeyspace(cellName, keyspace)
+	ss, ok := sk.shards[shard]
+	if !ok {
+		ss = &srvShard{
+			endPoints: make(map[topo.TabletType]string),
+		}
+		sk.shards[shard] = ss
+	}
+	return ss
+}
+
+// GetSrvTabletTypesPerShard implements topo.Server.
+func (s *Server) GetSrvTabletTypesPerShard(cellName, keyspace, shard string) ([]topo.TabletType, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss := s.getSrvShard(cellName, keyspace, shard)
+	if ss == nil {
+		return nil, topo.ErrNoNode
+	}
+	names := make([]string, 0, len(ss.endPoints))
+	for tabletType := range ss.endPoints {
+		names = append(names, string(tabletType))
+	}
+	sort.Strings(names)
+	tabletTypes := make([]topo.TabletType, len(names))
+	for i, name := range names {
+		tabletTypes[i] = topo.TabletType(name)
+	}
+	return tabletTypes, nil
+}
+
+// UpdateEndPoints implements topo.Server.
+func (s *Server) UpdateEndPoints(cellName, keyspace, shard string, tabletType topo.TabletType, addrs *topo.EndPoints) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.getOrCreateSrvShard(cellName, keyspace, shard).endPoints[tabletType] = jscfg.ToJson(addrs)
+	s.changed()
+	return nil
+}
+
+// GetEndPoints implements topo.Server.
+func (s *Server) GetEndPoints(cellName, keyspace, shard string, tabletType topo.TabletType) (*topo.EndPoints, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss := s.getSrvShard(cellName, keyspace, shard)
+	if ss == nil {
+		return nil, topo.ErrNoNode
+	}
+	data, ok := ss.endPoints[tabletType]
+	if !ok {
+		return nil, topo.ErrNoNode
+	}
+
+	value := &topo.EndPoints{}
+	if err := json.Unmarshal([]byte(data), value); err != nil {
+		return nil, fmt.Errorf("EndPoints unmarshal failed: %v %v", data, err)
+	}
+	return value, nil
+}
+
+// DeleteEndPoints implements topo.Server.
+func (s *Server) DeleteEndPoints(cellName, keyspace, shard string, tabletType topo.TabletType) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss := s.getSrvShard(cellName, keyspace, shard)
+	if ss == nil {
+		return topo.ErrNoNode
+	}
+	if _, ok := ss.endPoints[tabletType]; !ok {
+		return topo.ErrNoNode
+	}
+	delete(ss.endPoints, tabletType)
+	s.changed()
+	return nil
+}
+
+// UpdateSrvShard implements topo.Server.
+func (s *Server) UpdateSrvShard(cellName, keyspace, shard string, srvShard *topo.SrvShard) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss := s.getOrCreateSrvShard(cellName, keyspace, shard)
+	ss.value = jscfg.ToJson(srvShard)
+	ss.version = s.changed()
+	return nil
+}
+
+// GetSrvShard implements topo.Server.
+func (s *Server) GetSrvShard(cellName, keyspace, shard string) (*topo.SrvShard, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss := s.getSrvShard(cellName, keyspace, shard)
+	if ss == nil || ss.value == "" {
+		return nil, topo.ErrNoNode
+	}
+
+	value := topo.NewSrvShard(ss.version)
+	if err := json.Unmarshal([]byte(ss.value), value); err != nil {
+		return nil, fmt.Errorf("SrvShard unmarshal failed: %v %v", ss.value, err)
+	}
+	return value, nil
+}
+
+// DeleteSrvShard implements topo.Server.
+func (s *Server) DeleteSrvShard(cellName, keyspace, shard string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss := s.getSrvShard(cellName, keyspace, shard)
+	if ss == nil || ss.value == "" {
+		return topo.ErrNoNode
+	}
+	ss.value = ""
+	ss.version = s.changed()
+	return nil
+}
+
+// UpdateSrvKeyspace implements topo.Server.
+func (s *Server) UpdateSrvKeyspace(cellName, keyspace string, srvKeyspace *topo.SrvKeyspace) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sk := s.getOrCreateSrvKeyspace(cellName, keyspace)
+	sk.value = jscfg.ToJson(srvKeyspace)
+	sk.version = s.changed()
+	return nil
+}
+
+// GetSrvKeyspace implements topo.Server.
+func (s *Server) GetSrvKeyspace(cellName, keyspace string) (*topo.SrvKeyspace, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.cells[cellName]
+	if !ok {
+		return nil, topo.ErrNoNode
+	}
+	sk, ok := c.srvKeyspaces[keyspace]
+	if !ok || sk.value == "" {
+		return nil, topo.ErrNoNode
+	}
+
+	value := topo.NewSrvKeyspace(sk.version)
+	if err := json.Unmarshal([]byte(sk.value), value); err != nil {
+		return nil, fmt.Errorf("SrvKeyspace unmarshal failed: %v %v", sk.value, err)
+	}
+	return value, nil
+}
+
+// GetSrvKeyspaceNames implements topo.Server.
+func (s *Server) GetSrvKeyspaceNames(cellName string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.cells[cellName]
+	if !ok {
+		return nil, nil
+	}
+	result := make([]string, 0, len(c.srvKeyspaces))
+	for name := range c.srvKeyspaces {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// UpdateTabletEndpoint implements topo.Server.
+func (s *Server) UpdateTabletEndpoint(cellName, keyspace, shard string, tabletType topo.TabletType, addr *topo.EndPoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss := s.getSrvShard(cellName, keyspace, shard)
+	if ss == nil {
+		return nil
+	}
+	data, ok := ss.endPoints[tabletType]
+	if !ok {
+		// We haven't been placed in the serving graph yet, so
+		// don't update. Assume the next process that rebuilds
+		// the graph will get the updated tablet location.
+		return nil
+	}
+
+	addrs := topo.NewEndPoints()
+	if err := json.Unmarshal([]byte(data), addrs); err != nil {
+		return fmt.Errorf("EndPoints unmarshal failed: %v %v", data, err)
+	}
+
+	foundTablet := false
+	for i, entry := range addrs.Entries {
+		if entry.Uid == addr.Uid {
+			foundTablet = true
+			if topo.EndPointEquality(&entry, addr) {
+				// nothing to change
+				return nil
+			}
+			addrs.Entries[i] = *addr
+			break
+		}
+	}
+	if !foundTablet {
+		addrs.Entries = append(addrs.Entries, *addr)
+	}
+
+	ss.endPoints[tabletType] = jscfg.ToJson(addrs)
+	s.changed()
+	return nil
+}
+
+// watchValue calls notify with the value returned by read every time
+// it changes, until stopWatching is closed or notify returns false.
+// read is called with s.mu held, and returns an empty string if the
+// object doesn't exist.
+func (s *Server) watchValue(read func() (string, int64), stopWatching chan struct{}, notify func(value string, version int64) bool) {
+	first := true
+	var lastValue string
+	for {
+		s.mu.Lock()
+		value, version := read()
+		changes := s.changes
+		s.mu.Unlock()
+
+		if first || value != lastValue {
+			if !notify(value, version) {
+				return
+			}
+			first = false
+			lastValue = value
+		}
+
+		select {
+		case <-changes:
+		case <-stopWatching:
+			return
+		}
+	}
+}
+
+// WatchEndPoints implements topo.Server.
+func (s *Server) WatchEndPoints(cellName, keyspace, shard string, tabletType topo.TabletType, stopWatching chan struct{}) (<-chan *topo.EndPoints, error) {
+	notifications := make(chan *topo.EndPoints, 10)
+	go func() {
+		defer close(notifications)
+		s.watchValue(func() (string, int64) {
+			ss := s.getSrvShard(cellName, keyspace, shard)
+			if ss == nil {
+				return "", 0
+			}
+			return ss.endPoints[tabletType], 0
+		}, stopWatching, func(value string, version int64) bool {
+			var addrs *topo.EndPoints
+			if value != "" {
+				addrs = &topo.EndPoints{}
+				if err := json.Unmarshal([]byte(value), addrs); err != nil {
+					log.Errorf("EndPoints unmarshal failed: %v %v", value, err)
+					return true
+				}
+			}
+			select {
+			case notifications <- addrs:
+				return true
+			case <-stopWatching:
+				return false
+			}
+		})
+	}()
+	return notifications, nil
+}
+
+// WatchSrvKeyspace implements topo.Server.
+func (s *Server) WatchSrvKeyspace(cellName, keyspace string, stopWatching chan struct{}) (<-chan *topo.SrvKeyspace, error) {
+	notifications := make(chan *topo.SrvKeyspace, 10)
+	go func() {
+		defer close(notifications)
+		s.watchValue(func() (string, int64) {
+			c, ok := s.cells[cellName]
+			if !ok {
+				return "", 0
+			}
+			sk, ok := c.srvKeyspaces[keyspace]
+			if !ok {
+				return "", 0
+			}
+			return sk.value, sk.version
+		}, stopWatching, func(value string, version int64) bool {
+			var srvKeyspace *topo.SrvKeyspace
+			if value != "" {
+				srvKeyspace = topo.NewSrvKeyspace(version)
+				if err := json.Unmarshal([]byte(value), srvKeyspace); err != nil {
+					log.Errorf("SrvKeyspace unmarshal failed: %v %v", value, err)
+					return true
+				}
+			}
+			select {
+			case notifications <- srvKeyspace:
+				return true
+			case <-stopWatching:
+				return false
+			}
+		})
+	}()
+	return notifications, nil
+}
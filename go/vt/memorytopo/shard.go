@@ -0,0 +1,141 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memorytopo
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/youtube/vitess/go/event"
+	"github.com/youtube/vitess/go/jscfg"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/topo/events"
+)
+
+/*
+This file contains the shard management code for memorytopo.Server
+*/
+
+// getShard returns the data for a shard, or nil. It must be called
+// with s.mu held.
+func (s *Server) getShard(keyspaceName, shardName string) *shard {
+	ks, ok := s.keyspaces[keyspaceName]
+	if !ok {
+		return nil
+	}
+	return ks.shards[shardName]
+}
+
+// CreateShard implements topo.Server.
+func (s *Server) CreateShard(keyspaceName, shardName string, value *topo.Shard) error {
+	s.mu.Lock()
+	ks, ok := s.keyspaces[keyspaceName]
+	if !ok {
+		ks = &keyspace{}
+		s.keyspaces[keyspaceName] = ks
+	}
+	if ks.shards == nil {
+		ks.shards = make(map[string]*shard)
+	}
+	if _, ok := ks.shards[shardName]; ok {
+		s.mu.Unlock()
+		return topo.ErrNodeExists
+	}
+	ks.shards[shardName] = &shard{
+		value: jscfg.ToJson(value),
+	}
+	s.changed()
+	s.mu.Unlock()
+
+	event.Dispatch(&events.ShardChange{
+		ShardInfo: *topo.NewShardInfo(keyspaceName, shardName, value),
+		Status:    "created",
+	})
+	return nil
+}
+
+// UpdateShard implements topo.Server.
+func (s *Server) UpdateShard(si *topo.ShardInfo) error {
+	s.mu.Lock()
+	sh := s.getShard(si.Keyspace(), si.ShardName())
+	if sh == nil {
+		s.mu.Unlock()
+		return topo.ErrNoNode
+	}
+	sh.value = jscfg.ToJson(si.Shard)
+	s.changed()
+	s.mu.Unlock()
+
+	event.Dispatch(&events.ShardChange{
+		ShardInfo: *si,
+		Status:    "updated",
+	})
+	return nil
+}
+
+// ValidateShard implements topo.Server.
+func (s *Server) ValidateShard(keyspace, shard string) error {
+	_, err := s.GetShard(keyspace, shard)
+	return err
+}
+
+// GetShard implements topo.Server.
+func (s *Server) GetShard(keyspaceName, shardName string) (*topo.ShardInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sh := s.getShard(keyspaceName, shardName)
+	if sh == nil {
+		return nil, topo.ErrNoNode
+	}
+
+	value := &topo.Shard{}
+	if err := json.Unmarshal([]byte(sh.value), value); err != nil {
+		return nil, fmt.Errorf("bad shard data %v", err)
+	}
+	return topo.NewShardInfo(keyspaceName, shardName, value), nil
+}
+
+// GetShardCritical implements topo.Server.
+func (s *Server) GetShardCritical(keyspace, shard string) (*topo.ShardInfo, error) {
+	// There is no cache, GetShard is always consistent.
+	return s.GetShard(keyspace, shard)
+}
+
+// GetShardNames implements topo.Server.
+func (s *Server) GetShardNames(keyspaceName string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ks, ok := s.keyspaces[keyspaceName]
+	if !ok || ks.shards == nil {
+		return nil, topo.ErrNoNode
+	}
+	result := make([]string, 0, len(ks.shards))
+	for name := range ks.shards {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// DeleteShard implements topo.Server.
+func (s *Server) DeleteShard(keyspaceName, shardName string) error {
+	s.mu.Lock()
+	if s.getShard(keyspaceName, shardName) == nil {
+		s.mu.Unlock()
+		return topo.ErrNoNode
+	}
+	delete(s.keyspaces[keyspaceName].shards, shardName)
+	s.changed()
+	s.mu.Unlock()
+
+	event.Dispatch(&events.ShardChange{
+		ShardInfo: *topo.NewShardInfo(keyspaceName, shardName, nil),
+		Status:    "deleted",
+	})
+	return nil
+}
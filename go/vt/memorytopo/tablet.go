@@ -0,0 +1,192 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memorytopo
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/youtube/vitess/go/event"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/topo/events"
+)
+
+/*
+This file contains the tablet management parts of memorytopo.Server
+*/
+
+// tablet has the data for a tablet, its pid node and its action queue.
+type tablet struct {
+	value   string
+	version int64
+
+	// pid is the contents of the pid node, if hasPid is set.
+	pid    string
+	hasPid bool
+
+	// actions is the action queue, sorted by id.
+	actions []*tabletAction
+
+	// actionLog has the responses for the actions, by id.
+	actionLog map[int64]string
+
+	// queueChanged is closed and replaced every time an action
+	// is added to or removed from the queue.
+	queueChanged chan struct{}
+}
+
+// getTablet returns the data for a tablet, or nil. It must be
+// called with s.mu held.
+func (s *Server) getTablet(tabletAlias topo.TabletAlias) *tablet {
+	c, ok := s.cells[tabletAlias.Cell]
+	if !ok {
+		return nil
+	}
+	return c.tablets[tabletAlias]
+}
+
+// CreateTablet implements topo.Server.
+func (s *Server) CreateTablet(t *topo.Tablet) error {
+	s.mu.Lock()
+	c := s.getOrCreateCell(t.Alias.Cell)
+	if _, ok := c.tablets[t.Alias]; ok {
+		s.mu.Unlock()
+		return topo.ErrNodeExists
+	}
+	c.tablets[t.Alias] = &tablet{
+		value:        t.Json(),
+		version:      s.changed(),
+		actionLog:    make(map[int64]string),
+		queueChanged: make(chan struct{}),
+	}
+	s.mu.Unlock()
+
+	event.Dispatch(&events.TabletChange{
+		Tablet: *t,
+		Status: "created",
+	})
+	return nil
+}
+
+// UpdateTablet implements topo.Server.
+func (s *Server) UpdateTablet(ti *topo.TabletInfo, existingVersion int64) (int64, error) {
+	s.mu.Lock()
+	t := s.getTablet(ti.Alias)
+	if t == nil {
+		s.mu.Unlock()
+		return -1, topo.ErrNoNode
+	}
+	if existingVersion != -1 && t.version != existingVersion {
+		s.mu.Unlock()
+		return -1, topo.ErrBadVersion
+	}
+	t.value = ti.Json()
+	t.version = s.changed()
+	newVersion := t.version
+	s.mu.Unlock()
+
+	event.Dispatch(&events.TabletChange{
+		Tablet: *ti.Tablet,
+		Status: "updated",
+	})
+	return newVersion, nil
+}
+
+// UpdateTabletFields implements topo.Server.
+func (s *Server) UpdateTabletFields(tabletAlias topo.TabletAlias, update func(*topo.Tablet) error) error {
+	for {
+		ti, err := s.GetTablet(tabletAlias)
+		if err != nil {
+			return err
+		}
+		if err := update(ti.Tablet); err != nil {
+			return err
+		}
+		// UpdateTablet dispatches the TabletChange event on success.
+		if _, err = s.UpdateTablet(ti, ti.Version()); err != topo.ErrBadVersion {
+			return err
+		}
+	}
+}
+
+// DeleteTablet implements topo.Server.
+func (s *Server) DeleteTablet(tabletAlias topo.TabletAlias) error {
+	s.mu.Lock()
+	t := s.getTablet(tabletAlias)
+	if t == nil {
+		s.mu.Unlock()
+		return topo.ErrNoNode
+	}
+	delete(s.cells[tabletAlias.Cell].tablets, tabletAlias)
+	close(t.queueChanged)
+	s.changed()
+	s.mu.Unlock()
+
+	// Only copy the identity info for the tablet. The rest has been deleted.
+	if value, err := tabletFromJson(t.value); err == nil {
+		event.Dispatch(&events.TabletChange{
+			Tablet: topo.Tablet{
+				Alias:    tabletAlias,
+				Keyspace: value.Keyspace,
+				Shard:    value.Shard,
+			},
+			Status: "deleted",
+		})
+	}
+	return nil
+}
+
+// ValidateTablet implements topo.Server.
+func (s *Server) ValidateTablet(tabletAlias topo.TabletAlias) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.getTablet(tabletAlias) == nil {
+		return topo.ErrNoNode
+	}
+	return nil
+}
+
+func tabletFromJson(data string) (*topo.Tablet, error) {
+	t := &topo.Tablet{}
+	if err := json.Unmarshal([]byte(data), t); err != nil {
+		return nil, fmt.Errorf("bad tablet data %v", err)
+	}
+	return t, nil
+}
+
+// GetTablet implements topo.Server.
+func (s *Server) GetTablet(tabletAlias topo.TabletAlias) (*topo.TabletInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.getTablet(tabletAlias)
+	if t == nil {
+		return nil, topo.ErrNoNode
+	}
+	value, err := tabletFromJson(t.value)
+	if err != nil {
+		return nil, err
+	}
+	return topo.NewTabletInfo(value, t.version), nil
+}
+
+// GetTabletsByCell implements topo.Server.
+func (s *Server) GetTabletsByCell(cellName string) ([]topo.TabletAlias, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.cells[cellName]
+	if !ok {
+		return nil, topo.ErrNoNode
+	}
+	result := make(topo.TabletAliasList, 0, len(c.tablets))
+	for alias := range c.tablets {
+		result = append(result, alias)
+	}
+	sort.Sort(result)
+	return result, nil
+}
@@ -0,0 +1,17 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memorytopo
+
+import (
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// NewTestServer returns a new empty Server for the provided cells,
+// with the same signature as zktopo.NewTestServer.
+func NewTestServer(t *testing.T, cells []string) topo.Server {
+	return NewServer(cells)
+}
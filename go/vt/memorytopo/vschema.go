@@ -0,0 +1,33 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memorytopo
+
+import (
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+/*
+This file contains the VSchema management code for memorytopo.Server
+*/
+
+// SaveVSchema implements topo.Server.
+func (s *Server) SaveVSchema(vschema string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vschema = vschema
+	s.hasVSchema = true
+	s.changed()
+	return nil
+}
+
+// GetVSchema implements topo.Server.
+func (s *Server) GetVSchema() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.hasVSchema {
+		return "", topo.ErrNoNode
+	}
+	return s.vschema, nil
+}
@@ -0,0 +1,602 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/cgzip"
+	"github.com/youtube/vitess/go/vt/hook"
+	"github.com/youtube/vitess/go/vt/mysqlctl/backupstorage"
+	"github.com/youtube/vitess/go/vt/mysqlctl/proto"
+)
+
+// These methods deal with backing up a mysql instance to a
+// BackupStorage, and restoring a mysql instance from it. Unlike
+// snapshots, no source tablet is needed for the restore.
+
+const (
+	// backupManifest is the name of the JSON file that describes a
+	// backup. It is written last, so a backup without it is incomplete.
+	backupManifest = "MANIFEST"
+)
+
+const (
+	// the three bases for files to restore
+	backupInnodbDataHomeDir     = "InnoDBData"
+	backupInnodbLogGroupHomeDir = "InnoDBLog"
+	backupData                  = "Data"
+)
+
+var (
+	// ErrNoBackup is returned when there is no complete backup to
+	// restore from.
+	ErrNoBackup = errors.New("no available backup")
+)
+
+// BackupBucket returns the bucket that holds the backups of a shard.
+func BackupBucket(keyspace, shard string) string {
+	return path.Join(keyspace, shard)
+}
+
+// FileEntry is one file to backup
+type FileEntry struct {
+	// Base is one of:
+	// - backupInnodbDataHomeDir for files that go into Mycnf.InnodbDataHomeDir
+	// - backupInnodbLogGroupHomeDir for files that go into Mycnf.InnodbLogGroupHomeDir
+	// - backupData for files that go into Mycnf.DataDir
+	Base string
+
+	// Name is the file name, relative to Base
+	Name string
+
+	// Hash is the hash of the compressed data, as stored in the backup
+	Hash string
+}
+
+// fullPath returns the local path of the file.
+func (fe *FileEntry) fullPath(cnf *Mycnf) (string, error) {
+	// find the root to use
+	var root string
+	switch fe.Base {
+	case backupInnodbDataHomeDir:
+		root = cnf.InnodbDataHomeDir
+	case backupInnodbLogGroupHomeDir:
+		root = cnf.InnodbLogGroupHomeDir
+	case backupData:
+		root = cnf.DataDir
+	default:
+		return "", fmt.Errorf("unknown base: %v", fe.Base)
+	}
+	return path.Join(root, fe.Name), nil
+}
+
+// BackupManifest represents the backup. It lists all the files, and
+// the replication position at the time of the backup.
+type BackupManifest struct {
+	// FileEntries contains all the files in the backup. In the
+	// backup storage, the file at index i is named strconv.Itoa(i).
+	FileEntries []FileEntry
+
+	// ReplicationPosition is the position at which the backup was taken
+	ReplicationPosition proto.ReplicationPosition
+
+	// Time is when ReplicationPosition was read, in seconds since
+	// the epoch. It is 0 for backups that didn't record it.
+	Time int64
+}
+
+// findFilesToBackup returns the files of the mysql instance that
+// need to be backed up: the innodb data and logs, and the database
+// directories.
+func findFilesToBackup(cnf *Mycnf) ([]FileEntry, error) {
+	var result []FileEntry
+	var err error
+
+	// first add inno db files
+	result, err = addDirectory(result, backupInnodbDataHomeDir, cnf.InnodbDataHomeDir, "")
+	if err != nil {
+		return nil, err
+	}
+	result, err = addDirectory(result, backupInnodbLogGroupHomeDir, cnf.InnodbLogGroupHomeDir, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// then add the database directories
+	dbDirs, err := findDatabaseDirs(cnf.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, dd := range dbDirs {
+		result, err = addDirectory(result, backupData, cnf.DataDir, dd.name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// addDirectory adds the files of baseDir/subDir to the list, with
+// names relative to baseDir.
+func addDirectory(fes []FileEntry, base string, baseDir string, subDir string) ([]FileEntry, error) {
+	entries, err := ioutil.ReadDir(path.Join(baseDir, subDir))
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		fes = append(fes, FileEntry{
+			Base: base,
+			Name: path.Join(subDir, entry.Name()),
+		})
+	}
+	return fes, nil
+}
+
+// Backup is the main entry point for a backup:
+// - uses the BackupStorage service to store a new backup
+// - shuts down Mysqld during the backup
+// - remember if we were replicating, restore the exact same state
+func (mysqld *Mysqld) Backup(bucket, name string, backupConcurrency int, hookExtraEnv map[string]string) error {
+	bs, err := backupstorage.GetBackupStorage()
+	if err != nil {
+		return err
+	}
+	bh, err := bs.StartBackup(bucket, name)
+	if err != nil {
+		return fmt.Errorf("StartBackup failed: %v", err)
+	}
+
+	if err = mysqld.backup(bh, backupConcurrency, hookExtraEnv); err != nil {
+		if abortErr := bh.AbortBackup(); abortErr != nil {
+			log.Errorf("failed to abort backup: %v", abortErr)
+		}
+		return err
+	}
+	return bh.EndBackup()
+}
+
+func (mysqld *Mysqld) backup(bh backupstorage.BackupHandle, backupConcurrency int, hookExtraEnv map[string]string) error {
+	// save initial state so we can restore
+	slaveStartRequired := false
+	sourceIsMaster := false
+	var replicationPosition *proto.ReplicationPosition
+
+	// see if we need to restart replication after backup
+	log.Infof("getting current replication status")
+	slaveStatus, err := mysqld.slaveStatus()
+	switch err {
+	case nil:
+		slaveStartRequired = (slaveStatus["Slave_IO_Running"] == "Yes" && slaveStatus["Slave_SQL_Running"] == "Yes")
+	case ErrNotSlave:
+		// keep going if we're the master, might be a degenerate case
+		sourceIsMaster = true
+	default:
+		return fmt.Errorf("cannot get slave status: %v", err)
+	}
+
+	// get the read-only flag
+	readOnly, err := mysqld.IsReadOnly()
+	if err != nil {
+		return fmt.Errorf("cannot get read only status: %v", err)
+	}
+
+	// get the replication position
+	if sourceIsMaster {
+		if !readOnly {
+			log.Infof("turning master read-only before backup")
+			if err = mysqld.SetReadOnly(true); err != nil {
+				return fmt.Errorf("cannot set read only: %v", err)
+			}
+		}
+		replicationPosition, err = mysqld.MasterStatus()
+		if err != nil {
+			return fmt.Errorf("cannot get master position: %v", err)
+		}
+	} else {
+		if err = mysqld.StopSlave(hookExtraEnv); err != nil {
+			return fmt.Errorf("cannot stop slave: %v", err)
+		}
+		replicationPosition, err = mysqld.SlaveStatus()
+		if err != nil {
+			return fmt.Errorf("cannot get slave position: %v", err)
+		}
+	}
+	backupTime := time.Now().Unix()
+	log.Infof("using replication position: %#v", replicationPosition)
+
+	// shutdown mysqld
+	if err = mysqld.Shutdown(true, MysqlWaitTime); err != nil {
+		return fmt.Errorf("cannot shutdown mysqld: %v", err)
+	}
+
+	// backup everything, then write the manifest
+	backupErr := backupFilesAndManifest(mysqld.config, bh, replicationPosition, backupTime, backupConcurrency)
+
+	// restore our state, even if the backup failed
+	if err = mysqld.SnapshotSourceEnd(slaveStartRequired, readOnly, false /*deleteSnapshot*/, hookExtraEnv); err != nil {
+		if backupErr != nil {
+			log.Errorf("backup failed: %v", backupErr)
+		}
+		return fmt.Errorf("cannot restart mysqld after backup: %v", err)
+	}
+	return backupErr
+}
+
+// backupFilesAndManifest finds the files to backup, stores them,
+// and writes the manifest last.
+func backupFilesAndManifest(cnf *Mycnf, bh backupstorage.BackupHandle, replicationPosition *proto.ReplicationPosition, backupTime int64, backupConcurrency int) error {
+	fes, err := findFilesToBackup(cnf)
+	if err != nil {
+		return fmt.Errorf("cannot find files to backup: %v", err)
+	}
+	log.Infof("found %v files to backup", len(fes))
+
+	if err := backupFiles(cnf, bh, fes, backupConcurrency); err != nil {
+		return err
+	}
+	return writeBackupManifest(bh, &BackupManifest{
+		FileEntries:         fes,
+		ReplicationPosition: *replicationPosition,
+		Time:                backupTime,
+	})
+}
+
+// backupFiles compresses and stores all the files in parallel, and
+// fills in their Hash.
+func backupFiles(cnf *Mycnf, bh backupstorage.BackupHandle, fes []FileEntry, backupConcurrency int) error {
+	workQueue := make(chan int, len(fes))
+	for i := range fes {
+		workQueue <- i
+	}
+	close(workQueue)
+
+	resultQueue := make(chan error, len(fes))
+	for i := 0; i < backupConcurrency; i++ {
+		go func() {
+			for i := range workQueue {
+				resultQueue <- backupFile(cnf, bh, &fes[i], strconv.Itoa(i))
+			}
+		}()
+	}
+
+	var err error
+	for i := 0; i < len(fes); i++ {
+		if backupErr := <-resultQueue; backupErr != nil {
+			err = backupErr
+		}
+	}
+	return err
+}
+
+// backupFile compresses one file into the backup, and computes the
+// hash of the compressed data.
+func backupFile(cnf *Mycnf, bh backupstorage.BackupHandle, fe *FileEntry, name string) error {
+	// open the source file for reading
+	srcPath, err := fe.fullPath(cnf)
+	if err != nil {
+		return err
+	}
+	source, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	// open the destination file for writing, and a buffer
+	wc, err := bh.AddFile(name)
+	if err != nil {
+		return fmt.Errorf("cannot add file %v: %v", name, err)
+	}
+	closed := false
+	defer func() {
+		if !closed {
+			wc.Close()
+		}
+	}()
+	dst := bufio.NewWriterSize(wc, 2*1024*1024)
+
+	// create the hasher and the tee on top
+	hasher := newHasher()
+	tee := io.MultiWriter(dst, hasher)
+
+	// create the gzip compression filter
+	gzip, err := cgzip.NewWriterLevel(tee, cgzip.Z_BEST_SPEED)
+	if err != nil {
+		return fmt.Errorf("cannot create gziper: %v", err)
+	}
+
+	// copy from the source file to gzip to tee to output file and hasher
+	if _, err = io.Copy(gzip, bufio.NewReaderSize(source, 2*1024*1024)); err != nil {
+		return fmt.Errorf("cannot copy data: %v", err)
+	}
+
+	// close gzip to flush it, and flush the buffer
+	if err = gzip.Close(); err != nil {
+		return fmt.Errorf("cannot close gzip: %v", err)
+	}
+	if err = dst.Flush(); err != nil {
+		return fmt.Errorf("cannot flush dst: %v", err)
+	}
+	closed = true
+	if err = wc.Close(); err != nil {
+		return fmt.Errorf("cannot close file %v: %v", name, err)
+	}
+
+	// save the hash
+	fe.Hash = hasher.HashString()
+	log.Infof("backed up %v/%v as %v:%v", fe.Base, fe.Name, name, fe.Hash)
+	return nil
+}
+
+func writeBackupManifest(bh backupstorage.BackupHandle, bm *BackupManifest) error {
+	data, err := json.MarshalIndent(bm, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot JSON encode %v: %v", backupManifest, err)
+	}
+	wc, err := bh.AddFile(backupManifest)
+	if err != nil {
+		return fmt.Errorf("cannot add %v to backup: %v", backupManifest, err)
+	}
+	if _, err := wc.Write(data); err != nil {
+		wc.Close()
+		return fmt.Errorf("cannot write %v: %v", backupManifest, err)
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("cannot close %v: %v", backupManifest, err)
+	}
+	return nil
+}
+
+// ReadBackupManifest reads the manifest of a backup. It returns an
+// error if the backup is not complete.
+func ReadBackupManifest(bh backupstorage.BackupHandle) (*BackupManifest, error) {
+	rc, err := bh.ReadFile(backupManifest)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %v: %v", backupManifest, err)
+	}
+	defer rc.Close()
+	bm := &BackupManifest{}
+	if err := json.NewDecoder(rc).Decode(bm); err != nil {
+		return nil, fmt.Errorf("cannot JSON decode %v: %v", backupManifest, err)
+	}
+	return bm, nil
+}
+
+// findBackup returns the most recent complete backup of the bucket
+// that accept returns true for, or ErrNoBackup.
+func findBackup(bucket string, accept func(bm *BackupManifest) bool) (backupstorage.BackupHandle, *BackupManifest, error) {
+	bs, err := backupstorage.GetBackupStorage()
+	if err != nil {
+		return nil, nil, err
+	}
+	bhs, err := bs.ListBackups(bucket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ListBackups failed: %v", err)
+	}
+	for i := len(bhs) - 1; i >= 0; i-- {
+		bm, err := ReadBackupManifest(bhs[i])
+		if err != nil {
+			log.Warningf("skipping backup %v/%v: %v", bucket, bhs[i].Name(), err)
+			continue
+		}
+		if !accept(bm) {
+			log.Infof("skipping backup %v/%v: not usable for this restore", bucket, bhs[i].Name())
+			continue
+		}
+		return bhs[i], bm, nil
+	}
+	return nil, nil, ErrNoBackup
+}
+
+// restoreBackup replaces the data of mysqld with the files of the
+// backup, and restarts mysqld. Replication is not configured.
+func (mysqld *Mysqld) restoreBackup(bh backupstorage.BackupHandle, bm *BackupManifest, restoreConcurrency int, hookExtraEnv map[string]string) error {
+	log.Infof("restoring from backup %v/%v", bh.Bucket(), bh.Name())
+
+	log.V(6).Infof("ValidateCloneTarget")
+	if err := mysqld.ValidateCloneTarget(hookExtraEnv); err != nil {
+		return err
+	}
+
+	log.V(6).Infof("Shutdown mysqld")
+	if err := mysqld.Shutdown(true, MysqlWaitTime); err != nil {
+		return err
+	}
+
+	log.V(6).Infof("Restore files")
+	if err := mysqld.cleanRestoreDirs(bm); err != nil {
+		return err
+	}
+	if err := restoreFiles(mysqld.config, bh, bm.FileEntries, restoreConcurrency); err != nil {
+		return err
+	}
+
+	log.V(6).Infof("Restart mysqld")
+	return mysqld.Start(MysqlWaitTime)
+}
+
+// RestoreFromBackup is the main entry point for backup restore.
+// It will:
+// - find the most recent complete backup of the bucket
+// - validate the target (self), and shut down mysqld
+// - copy and uncompress the files, checking their hash
+// - restart mysqld and start replicating from masterAddr
+//   (the mysql address of the shard master) at the backup position
+//
+// With file based replication positions, the master must not have
+// changed since the backup was taken.
+func (mysqld *Mysqld) RestoreFromBackup(bucket string, restoreConcurrency int, masterAddr string, dontWaitForSlaveStart bool, hookExtraEnv map[string]string) error {
+	bh, bm, err := findBackup(bucket, func(bm *BackupManifest) bool { return true })
+	if err != nil {
+		return err
+	}
+
+	rs, err := proto.NewReplicationState(masterAddr)
+	if err != nil {
+		return err
+	}
+	rs.ReplicationPosition = bm.ReplicationPosition
+
+	if err := mysqld.restoreBackup(bh, bm, restoreConcurrency, hookExtraEnv); err != nil {
+		return err
+	}
+
+	cmdList, err := StartReplicationCommands(mysqld, rs)
+	if err != nil {
+		return err
+	}
+	if err := mysqld.ExecuteSuperQueryList(cmdList); err != nil {
+		return err
+	}
+
+	if !dontWaitForSlaveStart {
+		if err := mysqld.WaitForSlaveStart(SlaveStartDeadline); err != nil {
+			return err
+		}
+	}
+
+	h := hook.NewSimpleHook("postflight_restore")
+	h.ExtraEnv = hookExtraEnv
+	return h.ExecuteOptional()
+}
+
+// RestoreFromBackupForRecovery restores the most recent backup of the
+// bucket that was taken before the recovery target, and returns its
+// replication position. The target is stopAtGTID if set, stopAtTimestamp
+// (in seconds since the epoch) otherwise. mysqld is left running without
+// replication, so the binlogs from the backup position to the target
+// can be replayed on top of it.
+func (mysqld *Mysqld) RestoreFromBackupForRecovery(bucket string, restoreConcurrency int, stopAtGTID proto.GTID, stopAtTimestamp int64, hookExtraEnv map[string]string) (*proto.ReplicationPosition, error) {
+	accept, err := recoveryBackupFilter(stopAtGTID, stopAtTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	bh, bm, err := findBackup(bucket, accept)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mysqld.restoreBackup(bh, bm, restoreConcurrency, hookExtraEnv); err != nil {
+		return nil, err
+	}
+
+	// the backup may come from a slave, make sure we don't replicate
+	if err := mysqld.ExecuteSuperQueryList([]string{"STOP SLAVE", "RESET SLAVE"}); err != nil {
+		return nil, err
+	}
+	return &bm.ReplicationPosition, nil
+}
+
+// recoveryBackupFilter returns the filter for findBackup that accepts
+// the backups taken before the recovery target. The backups need a GTID
+// position, so the binlogs can be replayed from it.
+func recoveryBackupFilter(stopAtGTID proto.GTID, stopAtTimestamp int64) (func(bm *BackupManifest) bool, error) {
+	if stopAtGTID == nil {
+		if stopAtTimestamp == 0 {
+			return nil, fmt.Errorf("no recovery target")
+		}
+		return func(bm *BackupManifest) bool {
+			return bm.ReplicationPosition.MasterLogGTIDField.Value != nil && bm.Time != 0 && bm.Time <= stopAtTimestamp
+		}, nil
+	}
+
+	target, err := proto.ToGTIDSet(stopAtGTID)
+	if err != nil {
+		return nil, err
+	}
+	return func(bm *BackupManifest) bool {
+		pos, err := proto.ToGTIDSet(bm.ReplicationPosition.MasterLogGTIDField.Value)
+		if err != nil {
+			return false
+		}
+		ok, err := target.AtLeast(pos)
+		return err == nil && ok
+	}, nil
+}
+
+// cleanRestoreDirs removes the innodb directories, and the database
+// directories that are in the backup.
+func (mysqld *Mysqld) cleanRestoreDirs(bm *BackupManifest) error {
+	cleanDirs := []string{mysqld.config.InnodbDataHomeDir, mysqld.config.InnodbLogGroupHomeDir}
+	seen := make(map[string]bool)
+	for _, fe := range bm.FileEntries {
+		if fe.Base != backupData {
+			continue
+		}
+		dir := path.Join(mysqld.config.DataDir, path.Dir(fe.Name))
+		if !seen[dir] {
+			seen[dir] = true
+			cleanDirs = append(cleanDirs, dir)
+		}
+	}
+
+	for _, dir := range cleanDirs {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0775); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreFiles copies and uncompresses all the files of a backup in
+// parallel.
+func restoreFiles(cnf *Mycnf, bh backupstorage.BackupHandle, fes []FileEntry, restoreConcurrency int) error {
+	workQueue := make(chan int, len(fes))
+	for i := range fes {
+		workQueue <- i
+	}
+	close(workQueue)
+
+	resultQueue := make(chan error, len(fes))
+	for i := 0; i < restoreConcurrency; i++ {
+		go func() {
+			for i := range workQueue {
+				resultQueue <- restoreFile(cnf, bh, &fes[i], strconv.Itoa(i))
+			}
+		}()
+	}
+
+	var err error
+	for i := 0; i < len(fes); i++ {
+		if restoreErr := <-resultQueue; restoreErr != nil {
+			err = restoreErr
+		}
+	}
+	return err
+}
+
+// restoreFile uncompresses one file from the backup, and checks its hash.
+func restoreFile(cnf *Mycnf, bh backupstorage.BackupHandle, fe *FileEntry, name string) error {
+	// open the source file for reading
+	source, err := bh.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("cannot read file %v: %v", name, err)
+	}
+	defer source.Close()
+
+	// uncompressAndCheck creates the destination directory if needed
+	dstFilename, err := fe.fullPath(cnf)
+	if err != nil {
+		return err
+	}
+	return uncompressAndCheck(bufio.NewReaderSize(source, 2*1024*1024), fe.Hash, dstFilename, true)
+}
@@ -0,0 +1,235 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/filebackupstorage"
+	"github.com/youtube/vitess/go/vt/mysqlctl/proto"
+)
+
+// newBackupTestMycnf creates the mysql directories under root.
+func newBackupTestMycnf(t *testing.T, root string) *Mycnf {
+	cnf := &Mycnf{
+		DataDir:               path.Join(root, "data"),
+		InnodbDataHomeDir:     path.Join(root, "innodb", "data"),
+		InnodbLogGroupHomeDir: path.Join(root, "innodb", "logs"),
+	}
+	for _, dir := range []string{cnf.DataDir, cnf.InnodbDataHomeDir, cnf.InnodbLogGroupHomeDir} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			t.Fatalf("failed to create directory %v: %v", dir, err)
+		}
+	}
+	return cnf
+}
+
+func writeBackupTestFile(t *testing.T, name, contents string) {
+	if err := os.MkdirAll(path.Dir(name), os.ModePerm); err != nil {
+		t.Fatalf("failed to create directory for %v: %v", name, err)
+	}
+	if err := ioutil.WriteFile(name, []byte(contents), 0664); err != nil {
+		t.Fatalf("failed to write %v: %v", name, err)
+	}
+}
+
+type fileEntryList []FileEntry
+
+func (l fileEntryList) Len() int           { return len(l) }
+func (l fileEntryList) Less(i, j int) bool { return l[i].Base+l[i].Name < l[j].Base+l[j].Name }
+func (l fileEntryList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+func TestFindFilesToBackup(t *testing.T) {
+	root, err := ioutil.TempDir("", "backuptest")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(root)
+	cnf := newBackupTestMycnf(t, root)
+
+	writeBackupTestFile(t, path.Join(cnf.InnodbDataHomeDir, "innodb_data_1"), "innodb data 1 contents")
+	writeBackupTestFile(t, path.Join(cnf.InnodbLogGroupHomeDir, "innodb_log_1"), "innodb log 1 contents")
+	writeBackupTestFile(t, path.Join(cnf.DataDir, "vt_db", "db.opt"), "db.opt file")
+	writeBackupTestFile(t, path.Join(cnf.DataDir, "vt_db2", "table1.frm"), "frm file")
+	// neither db.opt nor .frm: not a database
+	writeBackupTestFile(t, path.Join(cnf.DataDir, "lost+found", "file"), "not a database")
+	// files at the top of the data directory are not backed up
+	writeBackupTestFile(t, path.Join(cnf.DataDir, "relay-log.info"), "relay log info")
+
+	result, err := findFilesToBackup(cnf)
+	if err != nil {
+		t.Fatalf("findFilesToBackup failed: %v", err)
+	}
+	sort.Sort(fileEntryList(result))
+	want := []FileEntry{
+		{Base: backupData, Name: "vt_db/db.opt"},
+		{Base: backupData, Name: "vt_db2/table1.frm"},
+		{Base: backupInnodbDataHomeDir, Name: "innodb_data_1"},
+		{Base: backupInnodbLogGroupHomeDir, Name: "innodb_log_1"},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("findFilesToBackup() = %#v, want %#v", result, want)
+	}
+}
+
+func TestBackupAndRestoreFiles(t *testing.T) {
+	root, err := ioutil.TempDir("", "backuptest")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(root)
+	srcCnf := newBackupTestMycnf(t, path.Join(root, "src"))
+	dstCnf := newBackupTestMycnf(t, path.Join(root, "dst"))
+	fbs := filebackupstorage.NewFileBackupStorage(path.Join(root, "backups"))
+
+	contents := map[string]string{
+		path.Join(srcCnf.InnodbDataHomeDir, "innodb_data_1"):    "innodb data 1 contents",
+		path.Join(srcCnf.InnodbLogGroupHomeDir, "innodb_log_1"): "innodb log 1 contents",
+		path.Join(srcCnf.DataDir, "vt_db", "db.opt"):            "db.opt file",
+		path.Join(srcCnf.DataDir, "vt_db", "table1.frm"):        "frm file",
+	}
+	for name, data := range contents {
+		writeBackupTestFile(t, name, data)
+	}
+	// a leftover file in the destination is removed by the restore
+	writeBackupTestFile(t, path.Join(dstCnf.DataDir, "vt_db", "old.frm"), "old frm file")
+
+	// backup the source
+	bucket := BackupBucket("test_keyspace", "0")
+	bh, err := fbs.StartBackup(bucket, "2014-01-01.000000.cell-0000000001")
+	if err != nil {
+		t.Fatalf("StartBackup failed: %v", err)
+	}
+	pos := proto.ReplicationPosition{MasterLogFile: "vt-0000000001-bin.000001", MasterLogPosition: 1234}
+	if err := backupFilesAndManifest(srcCnf, bh, &pos, 1388534400, 2); err != nil {
+		t.Fatalf("backupFilesAndManifest failed: %v", err)
+	}
+	if err := bh.EndBackup(); err != nil {
+		t.Fatalf("EndBackup failed: %v", err)
+	}
+
+	// read it back
+	bhs, err := fbs.ListBackups(bucket)
+	if err != nil || len(bhs) != 1 {
+		t.Fatalf("ListBackups returned wrong result: %v %v", bhs, err)
+	}
+	bm, err := ReadBackupManifest(bhs[0])
+	if err != nil {
+		t.Fatalf("ReadBackupManifest failed: %v", err)
+	}
+	if len(bm.FileEntries) != len(contents) || bm.ReplicationPosition.MasterLogPosition != 1234 || bm.Time != 1388534400 {
+		t.Fatalf("ReadBackupManifest returned wrong manifest: %#v", bm)
+	}
+
+	// and restore it into the destination
+	mysqld := &Mysqld{config: dstCnf}
+	if err := mysqld.cleanRestoreDirs(bm); err != nil {
+		t.Fatalf("cleanRestoreDirs failed: %v", err)
+	}
+	if err := restoreFiles(dstCnf, bhs[0], bm.FileEntries, 2); err != nil {
+		t.Fatalf("restoreFiles failed: %v", err)
+	}
+	for name, data := range contents {
+		dstName := path.Join(root, "dst", name[len(path.Join(root, "src")):])
+		got, err := ioutil.ReadFile(dstName)
+		if err != nil {
+			t.Errorf("cannot read restored file %v: %v", dstName, err)
+			continue
+		}
+		if string(got) != data {
+			t.Errorf("restored file %v has wrong contents: %#v, want %#v", dstName, string(got), data)
+		}
+	}
+	if _, err := os.Stat(path.Join(dstCnf.DataDir, "vt_db", "old.frm")); !os.IsNotExist(err) {
+		t.Errorf("leftover file was not removed by the restore: %v", err)
+	}
+
+	// a corrupted file fails the restore
+	bm.FileEntries[0].Hash = "00000000"
+	if err := restoreFiles(dstCnf, bhs[0], bm.FileEntries, 2); err == nil {
+		t.Errorf("restoreFiles with a wrong hash didn't fail")
+	}
+}
+
+func TestFindBackupForRecovery(t *testing.T) {
+	root, err := ioutil.TempDir("", "backuptest")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(root)
+	*filebackupstorage.FileBackupStorageRoot = root
+	fbs := filebackupstorage.NewFileBackupStorage(root)
+
+	// three complete backups, and an aborted one
+	bucket := BackupBucket("test_keyspace", "0")
+	for _, b := range []struct {
+		name     string
+		gtid     string
+		time     int64
+		complete bool
+	}{
+		{"2014-01-01.000000.cell-0000000001", "0-1-100", 1388534400, true},
+		{"2014-01-02.000000.cell-0000000001", "0-1-200", 1388620800, true},
+		{"2014-01-03.000000.cell-0000000001", "0-1-300", 1388707200, true},
+		{"2014-01-04.000000.cell-0000000001", "0-1-400", 1388793600, false},
+	} {
+		bh, err := fbs.StartBackup(bucket, b.name)
+		if err != nil {
+			t.Fatalf("StartBackup failed: %v", err)
+		}
+		if !b.complete {
+			continue
+		}
+		bm := &BackupManifest{Time: b.time}
+		bm.ReplicationPosition.MasterLogGTIDField.Value = proto.MustParseGTID("MariaDB", b.gtid)
+		if err := writeBackupManifest(bh, bm); err != nil {
+			t.Fatalf("writeBackupManifest failed: %v", err)
+		}
+		if err := bh.EndBackup(); err != nil {
+			t.Fatalf("EndBackup failed: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		stopAtGTID      proto.GTID
+		stopAtTimestamp int64
+		want            string
+	}{
+		{proto.MustParseGTID("MariaDB", "0-1-250"), 0, "2014-01-02.000000.cell-0000000001"},
+		{proto.MustParseGTID("MariaDB", "0-1-200"), 0, "2014-01-02.000000.cell-0000000001"},
+		{proto.MustParseGTID("MariaDB", "0-1-500"), 0, "2014-01-03.000000.cell-0000000001"},
+		{proto.MustParseGTID("MariaDB", "0-1-50"), 0, ""},
+		{nil, 1388620800 + 3600, "2014-01-02.000000.cell-0000000001"},
+		{nil, 1388534400 - 3600, ""},
+	} {
+		accept, err := recoveryBackupFilter(tc.stopAtGTID, tc.stopAtTimestamp)
+		if err != nil {
+			t.Fatalf("recoveryBackupFilter(%v, %v) failed: %v", tc.stopAtGTID, tc.stopAtTimestamp, err)
+		}
+		bh, _, err := findBackup(bucket, accept)
+		if tc.want == "" {
+			if err != ErrNoBackup {
+				t.Errorf("findBackup(%v, %v) returned %v, want ErrNoBackup", tc.stopAtGTID, tc.stopAtTimestamp, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("findBackup(%v, %v) failed: %v", tc.stopAtGTID, tc.stopAtTimestamp, err)
+			continue
+		}
+		if bh.Name() != tc.want {
+			t.Errorf("findBackup(%v, %v) = %v, want %v", tc.stopAtGTID, tc.stopAtTimestamp, bh.Name(), tc.want)
+		}
+	}
+
+	if _, err := recoveryBackupFilter(nil, 0); err == nil {
+		t.Errorf("recoveryBackupFilter without a target didn't fail")
+	}
+}
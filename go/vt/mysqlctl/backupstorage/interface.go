@@ -0,0 +1,93 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package backupstorage contains the interface and the registration
+// mechanism for the services that store backups. A backup is a named
+// set of files, and backups are grouped in buckets (usually one per
+// keyspace/shard).
+package backupstorage
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	log "github.com/golang/glog"
+)
+
+var backupStorageImplementation = flag.String("backup_storage_implementation", "file", "which implementation to use for the backup storage")
+
+// BackupHandle describes an individual backup.
+type BackupHandle interface {
+	// Bucket is the location of the backup. Will contain keyspace/shard.
+	Bucket() string
+
+	// Name is the individual name of the backup. Will contain
+	// timestamp.tabletAlias, so names sort chronologically.
+	Name() string
+
+	// AddFile opens a new file to be added to the backup.
+	// Only works for read-write backups (created by StartBackup).
+	// filename is guaranteed to only contain alphanumerical
+	// characters, hyphens and dots.
+	// It should be thread safe, it is possible to call AddFile in
+	// multiple go routines once a backup has been started.
+	AddFile(filename string) (io.WriteCloser, error)
+
+	// EndBackup stops and closes a backup. The contents should be kept.
+	// Only works for read-write backups (created by StartBackup).
+	EndBackup() error
+
+	// AbortBackup stops a backup, and removes the contents that
+	// have been copied already. It is called if an error occurs
+	// while the backup is being taken, and the backup cannot be finished.
+	// Only works for read-write backups (created by StartBackup).
+	AbortBackup() error
+
+	// ReadFile starts reading a file from a backup.
+	// Only works for read-only backups (created by ListBackups).
+	ReadFile(filename string) (io.ReadCloser, error)
+}
+
+// BackupStorage is the interface to the storage system
+type BackupStorage interface {
+	// ListBackups returns all the backups in a bucket. The
+	// returned backups are read-only (ReadFile can be called, but
+	// AddFile/EndBackup/AbortBackup cannot), and sorted from the
+	// oldest to the newest.
+	ListBackups(bucket string) ([]BackupHandle, error)
+
+	// StartBackup creates a new backup with the given name.
+	// If a backup with the same name already exists, it's an error.
+	// The returned backup is read-write
+	// (AddFile/EndBackup/AbortBackup can all be called, not ReadFile)
+	StartBackup(bucket, name string) (BackupHandle, error)
+
+	// RemoveBackup removes all the data associated with a backup.
+	// It will not appear in ListBackups after RemoveBackup succeeds.
+	RemoveBackup(bucket, name string) error
+}
+
+// BackupStorageMap contains the registered implementations for BackupStorage
+var BackupStorageMap = make(map[string]BackupStorage)
+
+// RegisterBackupStorage registers a BackupStorage implementation under
+// the given name. It is meant to be called from the init() function
+// of the implementation package.
+func RegisterBackupStorage(name string, bs BackupStorage) {
+	if _, ok := BackupStorageMap[name]; ok {
+		log.Fatalf("BackupStorage %s already exists", name)
+	}
+	BackupStorageMap[name] = bs
+}
+
+// GetBackupStorage returns the current BackupStorage implementation.
+// Should be called after flags have been initialized.
+func GetBackupStorage() (BackupStorage, error) {
+	bs, ok := BackupStorageMap[*backupStorageImplementation]
+	if !ok {
+		return nil, fmt.Errorf("no registered implementation of BackupStorage named %v", *backupStorageImplementation)
+	}
+	return bs, nil
+}
@@ -118,6 +118,32 @@ func (ev binlogEvent) IsRand() bool {
 	return ev.Type() == 13
 }
 
+// IsTableMap implements BinlogEvent.IsTableMap().
+func (ev binlogEvent) IsTableMap() bool {
+	return ev.Type() == 19
+}
+
+// IsWriteRows implements BinlogEvent.IsWriteRows().
+func (ev binlogEvent) IsWriteRows() bool {
+	return ev.Type() == 23 || ev.Type() == 30
+}
+
+// IsUpdateRows implements BinlogEvent.IsUpdateRows().
+func (ev binlogEvent) IsUpdateRows() bool {
+	return ev.Type() == 24 || ev.Type() == 31
+}
+
+// IsDeleteRows implements BinlogEvent.IsDeleteRows().
+func (ev binlogEvent) IsDeleteRows() bool {
+	return ev.Type() == 25 || ev.Type() == 32
+}
+
+// isRowsV2 returns true for the v2 rows events of MySQL 5.6, which have
+// an extra data block in their post-header.
+func (ev binlogEvent) isRowsV2() bool {
+	return ev.Type() >= 30 && ev.Type() <= 32
+}
+
 // Format implements BinlogEvent.Format().
 //
 // Expected format (L = total length of event data):
@@ -0,0 +1,652 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/youtube/vitess/go/sqltypes"
+	blproto "github.com/youtube/vitess/go/vt/binlog/proto"
+)
+
+// MySQL column types, as they appear in the TABLE_MAP_EVENT.
+const (
+	typeDecimal    = 0
+	typeTiny       = 1
+	typeShort      = 2
+	typeLong       = 3
+	typeFloat      = 4
+	typeDouble     = 5
+	typeNull       = 6
+	typeTimestamp  = 7
+	typeLongLong   = 8
+	typeInt24      = 9
+	typeDate       = 10
+	typeTime       = 11
+	typeDateTime   = 12
+	typeYear       = 13
+	typeNewDate    = 14
+	typeVarchar    = 15
+	typeBit        = 16
+	typeTimestamp2 = 17
+	typeDateTime2  = 18
+	typeTime2      = 19
+	typeNewDecimal = 246
+	typeEnum       = 247
+	typeSet        = 248
+	typeTinyBlob   = 249
+	typeMediumBlob = 250
+	typeLongBlob   = 251
+	typeBlob       = 252
+	typeVarString  = 253
+	typeString     = 254
+	typeGeometry   = 255
+)
+
+// TableID implements BinlogEvent.TableID().
+//
+// Expected format (post-header of TABLE_MAP_EVENT and rows events):
+//   # bytes   field
+//   6         table id
+//   2         flags
+func (ev binlogEvent) TableID(f blproto.BinlogFormat) uint64 {
+	data := ev.Bytes()[f.HeaderLength:]
+	var tableID uint64
+	for i := 5; i >= 0; i-- {
+		tableID = tableID<<8 | uint64(data[i])
+	}
+	return tableID
+}
+
+// TableMap implements BinlogEvent.TableMap().
+//
+// Expected format (L = total length of event data):
+//   # bytes   field
+//   6         table id
+//   2         flags
+//   1         length of database name (X)
+//   X+1       database name + NULL terminator
+//   1         length of table name (Y)
+//   Y+1       table name + NULL terminator
+//   1-9       column count (N), length encoded
+//   N         column types
+//   1-9       length of metadata block (M), length encoded
+//   M         metadata block
+//   (N+7)/8   bitmap of the nullable columns
+func (ev binlogEvent) TableMap(f blproto.BinlogFormat) (*blproto.TableMap, error) {
+	data := ev.Bytes()[f.HeaderLength:]
+	if len(data) < 6+2 {
+		return nil, fmt.Errorf("TABLE_MAP_EVENT data is too short: %v bytes", len(data))
+	}
+	tm := &blproto.TableMap{TableID: ev.TableID(f)}
+
+	pos := 6 + 2
+	var err error
+	if tm.Database, pos, err = readTableMapName(data, pos); err != nil {
+		return nil, fmt.Errorf("can't read database name: %v", err)
+	}
+	if tm.Name, pos, err = readTableMapName(data, pos); err != nil {
+		return nil, fmt.Errorf("can't read table name: %v", err)
+	}
+
+	columnCount, pos, ok := readLenEncInt(data, pos)
+	if !ok || pos+int(columnCount) > len(data) {
+		return nil, fmt.Errorf("can't read the types of %v columns", columnCount)
+	}
+	tm.Types = make([]byte, columnCount)
+	copy(tm.Types, data[pos:pos+int(columnCount)])
+	pos += int(columnCount)
+
+	metaLen, pos, ok := readLenEncInt(data, pos)
+	if !ok || pos+int(metaLen) > len(data) {
+		return nil, fmt.Errorf("can't read the metadata block of %v bytes", metaLen)
+	}
+	if tm.Metadata, err = readMetadata(tm.Types, data[pos:pos+int(metaLen)]); err != nil {
+		return nil, err
+	}
+	pos += int(metaLen)
+
+	if tm.CanBeNull, pos, ok = readBitmap(data, pos, int(columnCount)); !ok {
+		return nil, fmt.Errorf("can't read the nullable columns bitmap")
+	}
+	return tm, nil
+}
+
+// Rows implements BinlogEvent.Rows().
+//
+// Expected format (L = total length of event data):
+//   # bytes   field
+//   6         table id
+//   2         flags
+//   2         length of the extra data (E), including this field (v2 only)
+//   E-2       extra data (v2 only)
+//   1-9       column count (N), length encoded
+//   (N+7)/8   bitmap of the columns of the first image
+//   (N+7)/8   bitmap of the columns of the second image (UPDATE_ROWS_EVENT only)
+//   ...       rows, each one being one image (two for UPDATE_ROWS_EVENT)
+//             made of a NULL bitmap followed by the non-NULL values
+func (ev binlogEvent) Rows(f blproto.BinlogFormat, tm *blproto.TableMap) (blproto.Rows, error) {
+	var rows blproto.Rows
+	data := ev.Bytes()[f.HeaderLength:]
+	if len(data) < 6+2 {
+		return rows, fmt.Errorf("rows event data is too short: %v bytes", len(data))
+	}
+
+	pos := 6 + 2
+	if ev.isRowsV2() {
+		if pos+2 > len(data) {
+			return rows, fmt.Errorf("can't read the extra data length")
+		}
+		pos += int(binary.LittleEndian.Uint16(data[pos : pos+2]))
+	}
+
+	columnCount, pos, ok := readLenEncInt(data, pos)
+	if !ok {
+		return rows, fmt.Errorf("can't read the column count")
+	}
+	if int(columnCount) != len(tm.Types) {
+		return rows, fmt.Errorf("rows event has %v columns, table map of %v.%v has %v", columnCount, tm.Database, tm.Name, len(tm.Types))
+	}
+
+	var first []bool
+	if first, pos, ok = readBitmap(data, pos, int(columnCount)); !ok {
+		return rows, fmt.Errorf("can't read the columns bitmap")
+	}
+	switch {
+	case ev.IsWriteRows():
+		rows.DataColumns = first
+	case ev.IsDeleteRows():
+		rows.IdentifyColumns = first
+	default:
+		rows.IdentifyColumns = first
+		if rows.DataColumns, pos, ok = readBitmap(data, pos, int(columnCount)); !ok {
+			return rows, fmt.Errorf("can't read the second columns bitmap")
+		}
+	}
+
+	var err error
+	for pos < len(data) {
+		var row blproto.Row
+		if rows.IdentifyColumns != nil {
+			if row.Identify, pos, err = readImage(data, pos, tm, rows.IdentifyColumns); err != nil {
+				return rows, err
+			}
+		}
+		if rows.DataColumns != nil {
+			if row.Data, pos, err = readImage(data, pos, tm, rows.DataColumns); err != nil {
+				return rows, err
+			}
+		}
+		rows.Rows = append(rows.Rows, row)
+	}
+	return rows, nil
+}
+
+// readTableMapName reads a name prefixed by its length and followed
+// by a NULL terminator.
+func readTableMapName(data []byte, pos int) (string, int, error) {
+	if pos >= len(data) {
+		return "", pos, fmt.Errorf("name length is outside buffer")
+	}
+	length := int(data[pos])
+	pos++
+	if pos+length+1 > len(data) {
+		return "", pos, fmt.Errorf("name of length %v is outside buffer", length)
+	}
+	return string(data[pos : pos+length]), pos + length + 1, nil
+}
+
+// readLenEncInt reads a length encoded integer.
+func readLenEncInt(data []byte, pos int) (uint64, int, bool) {
+	if pos >= len(data) {
+		return 0, pos, false
+	}
+	size := 0
+	switch data[pos] {
+	case 0xfc:
+		size = 2
+	case 0xfd:
+		size = 3
+	case 0xfe:
+		size = 8
+	case 0xfb, 0xff:
+		return 0, pos, false
+	default:
+		return uint64(data[pos]), pos + 1, true
+	}
+	pos++
+	if pos+size > len(data) {
+		return 0, pos, false
+	}
+	var value uint64
+	for i := size - 1; i >= 0; i-- {
+		value = value<<8 | uint64(data[pos+i])
+	}
+	return value, pos + size, true
+}
+
+// readBitmap reads a bitmap of count bits.
+func readBitmap(data []byte, pos, count int) ([]bool, int, bool) {
+	size := (count + 7) / 8
+	if pos+size > len(data) {
+		return nil, pos, false
+	}
+	bitmap := make([]bool, count)
+	for i := range bitmap {
+		bitmap[i] = data[pos+i/8]&(1<<uint(i%8)) != 0
+	}
+	return bitmap, pos + size, true
+}
+
+// readMetadata reads the metadata block of a TABLE_MAP_EVENT. The
+// metadata of a column takes 0, 1 or 2 bytes depending on its type.
+func readMetadata(types []byte, data []byte) ([]uint16, error) {
+	metadata := make([]uint16, len(types))
+	pos := 0
+	for i, typ := range types {
+		size := 0
+		switch typ {
+		case typeFloat, typeDouble, typeBlob, typeGeometry, typeTimestamp2, typeDateTime2, typeTime2:
+			size = 1
+		case typeVarchar, typeVarString, typeBit, typeNewDecimal, typeString, typeEnum, typeSet:
+			size = 2
+		}
+		if pos+size > len(data) {
+			return nil, fmt.Errorf("metadata of column %v is outside buffer", i)
+		}
+		switch size {
+		case 1:
+			metadata[i] = uint16(data[pos])
+		case 2:
+			switch typ {
+			case typeNewDecimal, typeString, typeEnum, typeSet:
+				// (precision, scale) or (real type, length)
+				metadata[i] = uint16(data[pos])<<8 | uint16(data[pos+1])
+			default:
+				metadata[i] = binary.LittleEndian.Uint16(data[pos : pos+2])
+			}
+		}
+		pos += size
+	}
+	return metadata, nil
+}
+
+// readImage reads one image of a row. It has one value per column of
+// the table, the columns that are not in the image are NULL.
+func readImage(data []byte, pos int, tm *blproto.TableMap, columns []bool) ([]sqltypes.Value, int, error) {
+	present := 0
+	for _, c := range columns {
+		if c {
+			present++
+		}
+	}
+	nulls, pos, ok := readBitmap(data, pos, present)
+	if !ok {
+		return nil, pos, fmt.Errorf("can't read the NULL bitmap of a row")
+	}
+
+	values := make([]sqltypes.Value, len(columns))
+	n := 0
+	for i, c := range columns {
+		if !c {
+			continue
+		}
+		if nulls[n] {
+			n++
+			continue
+		}
+		n++
+		unsigned := i < len(tm.Unsigned) && tm.Unsigned[i]
+		var err error
+		if values[i], pos, err = readCell(data, pos, tm.Types[i], tm.Metadata[i], unsigned); err != nil {
+			return nil, pos, fmt.Errorf("can't read column %v of %v.%v: %v", i, tm.Database, tm.Name, err)
+		}
+	}
+	return values, pos, nil
+}
+
+// readCell reads the value of one column of a row.
+func readCell(data []byte, pos int, typ byte, metadata uint16, unsigned bool) (sqltypes.Value, int, error) {
+	// need makes sure the next size bytes are in the buffer.
+	need := func(size int) error {
+		if pos+size > len(data) {
+			return fmt.Errorf("value of %v bytes is outside buffer", size)
+		}
+		return nil
+	}
+
+	switch typ {
+	case typeTiny, typeShort, typeInt24, typeLong, typeLongLong:
+		size := map[byte]int{typeTiny: 1, typeShort: 2, typeInt24: 3, typeLong: 4, typeLongLong: 8}[typ]
+		if err := need(size); err != nil {
+			return sqltypes.NULL, pos, err
+		}
+		value := readUintLE(data[pos : pos+size])
+		if unsigned {
+			return sqltypes.MakeNumeric(strconv.AppendUint(nil, value, 10)), pos + size, nil
+		}
+		// sign extension
+		shift := uint(64 - 8*size)
+		return sqltypes.MakeNumeric(strconv.AppendInt(nil, int64(value<<shift)>>shift, 10)), pos + size, nil
+
+	case typeYear:
+		if err := need(1); err != nil {
+			return sqltypes.NULL, pos, err
+		}
+		year := 0
+		if data[pos] != 0 {
+			year = 1900 + int(data[pos])
+		}
+		return sqltypes.MakeNumeric(strconv.AppendInt(nil, int64(year), 10)), pos + 1, nil
+
+	case typeFloat:
+		if err := need(4); err != nil {
+			return sqltypes.NULL, pos, err
+		}
+		f := math.Float32frombits(binary.LittleEndian.Uint32(data[pos : pos+4]))
+		return sqltypes.MakeFractional(strconv.AppendFloat(nil, float64(f), 'g', -1, 32)), pos + 4, nil
+
+	case typeDouble:
+		if err := need(8); err != nil {
+			return sqltypes.NULL, pos, err
+		}
+		f := math.Float64frombits(binary.LittleEndian.Uint64(data[pos : pos+8]))
+		return sqltypes.MakeFractional(strconv.AppendFloat(nil, f, 'g', -1, 64)), pos + 8, nil
+
+	case typeNewDecimal:
+		precision, scale := int(metadata>>8), int(metadata&0xff)
+		value, size, err := readDecimal(data[pos:], precision, scale)
+		if err != nil {
+			return sqltypes.NULL, pos, err
+		}
+		return sqltypes.MakeFractional([]byte(value)), pos + size, nil
+
+	case typeBit:
+		nbits := int(metadata>>8)*8 + int(metadata&0xff)
+		size := (nbits + 7) / 8
+		if err := need(size); err != nil {
+			return sqltypes.NULL, pos, err
+		}
+		return sqltypes.MakeNumeric(strconv.AppendUint(nil, readUintBE(data[pos:pos+size]), 10)), pos + size, nil
+
+	case typeDate, typeNewDate:
+		if err := need(3); err != nil {
+			return sqltypes.NULL, pos, err
+		}
+		v := readUintLE(data[pos : pos+3])
+		s := fmt.Sprintf("%04d-%02d-%02d", v>>9, (v>>5)&15, v&31)
+		return sqltypes.MakeString([]byte(s)), pos + 3, nil
+
+	case typeTime:
+		if err := need(3); err != nil {
+			return sqltypes.NULL, pos, err
+		}
+		v := int64(readUintLE(data[pos:pos+3])<<40) >> 40
+		sign := ""
+		if v < 0 {
+			sign = "-"
+			v = -v
+		}
+		s := fmt.Sprintf("%s%02d:%02d:%02d", sign, v/10000, v%10000/100, v%100)
+		return sqltypes.MakeString([]byte(s)), pos + 3, nil
+
+	case typeDateTime:
+		if err := need(8); err != nil {
+			return sqltypes.NULL, pos, err
+		}
+		v := binary.LittleEndian.Uint64(data[pos : pos+8])
+		d, t := v/1000000, v%1000000
+		s := fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d", d/10000, d%10000/100, d%100, t/10000, t%10000/100, t%100)
+		return sqltypes.MakeString([]byte(s)), pos + 8, nil
+
+	case typeTimestamp:
+		if err := need(4); err != nil {
+			return sqltypes.NULL, pos, err
+		}
+		s := formatTimestamp(int64(binary.LittleEndian.Uint32(data[pos:pos+4])), "")
+		return sqltypes.MakeString([]byte(s)), pos + 4, nil
+
+	case typeTimestamp2:
+		fsp := int(metadata)
+		size := 4 + (fsp+1)/2
+		if err := need(size); err != nil {
+			return sqltypes.NULL, pos, err
+		}
+		sec := int64(binary.BigEndian.Uint32(data[pos : pos+4]))
+		s := formatTimestamp(sec, fraction(data[pos+4:pos+size], fsp))
+		return sqltypes.MakeString([]byte(s)), pos + size, nil
+
+	case typeDateTime2:
+		fsp := int(metadata)
+		size := 5 + (fsp+1)/2
+		if err := need(size); err != nil {
+			return sqltypes.NULL, pos, err
+		}
+		v := readUintBE(data[pos:pos+5]) - 0x8000000000
+		ymd, hms := v>>17, v&(1<<17-1)
+		ym := ymd >> 5
+		s := fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d%s", ym/13, ym%13, ymd&31, hms>>12, (hms>>6)&63, hms&63, fraction(data[pos+5:pos+size], fsp))
+		return sqltypes.MakeString([]byte(s)), pos + size, nil
+
+	case typeTime2:
+		fsp := int(metadata)
+		size := 3 + (fsp+1)/2
+		if err := need(size); err != nil {
+			return sqltypes.NULL, pos, err
+		}
+		s := formatTime2(data[pos:pos+size], fsp)
+		return sqltypes.MakeString([]byte(s)), pos + size, nil
+
+	case typeVarchar, typeVarString:
+		lenSize := 1
+		if metadata >= 256 {
+			lenSize = 2
+		}
+		return readLengthPrefixed(data, pos, lenSize)
+
+	case typeString, typeEnum, typeSet:
+		realType, length := byte(metadata>>8), int(metadata&0xff)
+		if typ == typeString && realType&0x30 != 0x30 {
+			// The length of CHAR columns over 255 bytes uses
+			// 2 bits of the real type byte.
+			length |= int((realType&0x30)^0x30) << 4
+			realType |= 0x30
+		}
+		if typ != typeString {
+			realType = typ
+		}
+		switch realType {
+		case typeEnum, typeSet:
+			// the index of the ENUM value, or the bitmap of the SET values
+			if err := need(length); err != nil {
+				return sqltypes.NULL, pos, err
+			}
+			return sqltypes.MakeNumeric(strconv.AppendUint(nil, readUintLE(data[pos:pos+length]), 10)), pos + length, nil
+		}
+		lenSize := 1
+		if length >= 256 {
+			lenSize = 2
+		}
+		return readLengthPrefixed(data, pos, lenSize)
+
+	case typeBlob, typeTinyBlob, typeMediumBlob, typeLongBlob, typeGeometry:
+		return readLengthPrefixed(data, pos, int(metadata))
+
+	case typeNull:
+		return sqltypes.NULL, pos, nil
+	}
+	return sqltypes.NULL, pos, fmt.Errorf("unsupported column type %v", typ)
+}
+
+// readLengthPrefixed reads a string prefixed by its length on lenSize bytes.
+func readLengthPrefixed(data []byte, pos, lenSize int) (sqltypes.Value, int, error) {
+	if lenSize < 1 || lenSize > 4 || pos+lenSize > len(data) {
+		return sqltypes.NULL, pos, fmt.Errorf("can't read string length of %v bytes", lenSize)
+	}
+	length := int(readUintLE(data[pos : pos+lenSize]))
+	pos += lenSize
+	if pos+length > len(data) {
+		return sqltypes.NULL, pos, fmt.Errorf("string of %v bytes is outside buffer", length)
+	}
+	value := make([]byte, length)
+	copy(value, data[pos:pos+length])
+	return sqltypes.MakeString(value), pos + length, nil
+}
+
+func readUintLE(data []byte) uint64 {
+	var value uint64
+	for i := len(data) - 1; i >= 0; i-- {
+		value = value<<8 | uint64(data[i])
+	}
+	return value
+}
+
+func readUintBE(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+// fraction returns the fractional seconds of a TIMESTAMP2, DATETIME2 or
+// TIME2 value with fsp digits, including the dot.
+func fraction(data []byte, fsp int) string {
+	if fsp == 0 {
+		return ""
+	}
+	v := readUintBE(data)
+	if fsp%2 == 1 {
+		// odd precisions are stored with one more digit
+		v /= 10
+	}
+	return fmt.Sprintf(".%0*d", fsp, v)
+}
+
+// formatTimestamp formats the seconds since the epoch of a TIMESTAMP
+// value in UTC, 0 being the zero timestamp. The statements that write
+// it have to run with the time_zone of the session set to UTC.
+func formatTimestamp(sec int64, frac string) string {
+	if sec == 0 {
+		return "0000-00-00 00:00:00" + frac
+	}
+	return time.Unix(sec, 0).UTC().Format("2006-01-02 15:04:05") + frac
+}
+
+// formatTime2 formats a TIME2 value. The value is stored as a big endian
+// signed number of 24 bits (+ the fractional part), offset so it sorts
+// as unsigned.
+func formatTime2(data []byte, fsp int) string {
+	var tmp int64
+	intPart := int64(readUintBE(data[:3])) - 0x800000
+	switch fsp {
+	case 0:
+		tmp = intPart << 24
+	case 1, 2:
+		frac := int64(data[3])
+		if intPart < 0 && frac != 0 {
+			intPart++
+			frac -= 0x100
+		}
+		tmp = intPart<<24 + frac*10000
+	case 3, 4:
+		frac := int64(binary.BigEndian.Uint16(data[3:5]))
+		if intPart < 0 && frac != 0 {
+			intPart++
+			frac -= 0x10000
+		}
+		tmp = intPart<<24 + frac*100
+	default:
+		tmp = int64(readUintBE(data[:6])) - 0x800000000000
+	}
+
+	sign := ""
+	if tmp < 0 {
+		sign = "-"
+		tmp = -tmp
+	}
+	hms, micro := tmp>>24, tmp&0xffffff
+	s := fmt.Sprintf("%s%02d:%02d:%02d", sign, (hms>>12)&0x3ff, (hms>>6)&63, hms&63)
+	if fsp > 0 {
+		s += fmt.Sprintf(".%0*d", fsp, micro/int64(math.Pow10(6-fsp)))
+	}
+	return s
+}
+
+// digitsToBytes is the number of bytes used by a DECIMAL for a
+// group of less than 9 digits.
+var digitsToBytes = []int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+
+// readDecimal reads a DECIMAL value. The digits are stored by groups
+// of 9 in big endian 4 bytes integers, the first and last groups being
+// possibly shorter. The sign is the first bit, and the negative values
+// have all their bits inverted.
+func readDecimal(data []byte, precision, scale int) (string, int, error) {
+	if precision < 1 || precision > 65 || scale > 30 || scale > precision {
+		return "", 0, fmt.Errorf("invalid decimal precision %v and scale %v", precision, scale)
+	}
+	intg := precision - scale
+	intg0, intg0x := intg/9, intg%9
+	frac0, frac0x := scale/9, scale%9
+	size := intg0*4 + digitsToBytes[intg0x] + frac0*4 + digitsToBytes[frac0x]
+	if size > len(data) {
+		return "", 0, fmt.Errorf("decimal of %v bytes is outside buffer", size)
+	}
+
+	buf := make([]byte, size)
+	copy(buf, data[:size])
+	positive := buf[0]&0x80 != 0
+	buf[0] ^= 0x80
+	if !positive {
+		for i := range buf {
+			buf[i] ^= 0xff
+		}
+	}
+
+	pos := 0
+	group := func(digits int) string {
+		n := digitsToBytes[digits]
+		if digits == 9 {
+			n = 4
+		}
+		v := readUintBE(buf[pos : pos+n])
+		pos += n
+		return fmt.Sprintf("%0*d", digits, v)
+	}
+
+	var digits bytes.Buffer
+	if intg0x > 0 {
+		digits.WriteString(group(intg0x))
+	}
+	for i := 0; i < intg0; i++ {
+		digits.WriteString(group(9))
+	}
+	intDigits := strings.TrimLeft(digits.String(), "0")
+	if intDigits == "" {
+		intDigits = "0"
+	}
+
+	var result bytes.Buffer
+	if !positive {
+		result.WriteByte('-')
+	}
+	result.WriteString(intDigits)
+	if scale > 0 {
+		result.WriteByte('.')
+		for i := 0; i < frac0; i++ {
+			result.WriteString(group(9))
+		}
+		if frac0x > 0 {
+			result.WriteString(group(frac0x))
+		}
+	}
+	return result.String(), size, nil
+}
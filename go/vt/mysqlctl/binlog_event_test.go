@@ -5,18 +5,32 @@
 package mysqlctl
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/youtube/vitess/go/sqltypes"
+
 	blproto "github.com/youtube/vitess/go/vt/binlog/proto"
 )
 
-// sample event data
+// sample event data. The rows events are for the same table, as written
+// by 5.1.63-google (v1 events, DATETIME) and by 5.6.20 (v2 events,
+// DATETIME(3) and TIMESTAMP).
 var (
-	garbageEvent      = []byte{92, 93, 211, 208, 16, 71, 139, 255, 83, 199, 198, 59, 148, 214, 109, 154, 122, 226, 39, 41}
-	googleRotateEvent = []byte{0x0, 0x0, 0x0, 0x0, 0x4, 0x88, 0xf3, 0x0, 0x0, 0x33, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x20, 0x0, 0x23, 0x3, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x76, 0x74, 0x2d, 0x30, 0x30, 0x30, 0x30, 0x30, 0x36, 0x32, 0x33, 0x34, 0x34, 0x2d, 0x62, 0x69, 0x6e, 0x2e, 0x30, 0x30, 0x30, 0x30, 0x30, 0x31}
-	googleFormatEvent = []byte{0x52, 0x52, 0xe9, 0x53, 0xf, 0x88, 0xf3, 0x0, 0x0, 0x66, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x4, 0x0, 0x35, 0x2e, 0x31, 0x2e, 0x36, 0x33, 0x2d, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2d, 0x6c, 0x6f, 0x67, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1b, 0x38, 0xd, 0x0, 0x8, 0x0, 0x12, 0x0, 0x4, 0x4, 0x4, 0x4, 0x12, 0x0, 0x0, 0x53, 0x0, 0x4, 0x1a, 0x8, 0x0, 0x0, 0x0, 0x8, 0x8, 0x8, 0x2}
-	googleQueryEvent  = []byte{0x53, 0x52, 0xe9, 0x53, 0x2, 0x88, 0xf3, 0x0, 0x0, 0xad, 0x0, 0x0, 0x0, 0x9a, 0x4, 0x0, 0x0, 0x0, 0x0, 0xb, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1b, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x40, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x8, 0x0, 0x8, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x20, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x20, 0x69, 0x66, 0x20, 0x6e, 0x6f, 0x74, 0x20, 0x65, 0x78, 0x69, 0x73, 0x74, 0x73, 0x20, 0x76, 0x74, 0x5f, 0x61, 0x20, 0x28, 0xa, 0x65, 0x69, 0x64, 0x20, 0x62, 0x69, 0x67, 0x69, 0x6e, 0x74, 0x2c, 0xa, 0x69, 0x64, 0x20, 0x69, 0x6e, 0x74, 0x2c, 0xa, 0x70, 0x72, 0x69, 0x6d, 0x61, 0x72, 0x79, 0x20, 0x6b, 0x65, 0x79, 0x28, 0x65, 0x69, 0x64, 0x2c, 0x20, 0x69, 0x64, 0x29, 0xa, 0x29, 0x20, 0x45, 0x6e, 0x67, 0x69, 0x6e, 0x65, 0x3d, 0x49, 0x6e, 0x6e, 0x6f, 0x44, 0x42}
-	googleXIDEvent    = []byte{0x53, 0x52, 0xe9, 0x53, 0x10, 0x88, 0xf3, 0x0, 0x0, 0x23, 0x0, 0x0, 0x0, 0x4e, 0xa, 0x0, 0x0, 0x0, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x78, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
+	garbageEvent           = []byte{92, 93, 211, 208, 16, 71, 139, 255, 83, 199, 198, 59, 148, 214, 109, 154, 122, 226, 39, 41}
+	googleRotateEvent      = []byte{0x0, 0x0, 0x0, 0x0, 0x4, 0x88, 0xf3, 0x0, 0x0, 0x33, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x20, 0x0, 0x23, 0x3, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x76, 0x74, 0x2d, 0x30, 0x30, 0x30, 0x30, 0x30, 0x36, 0x32, 0x33, 0x34, 0x34, 0x2d, 0x62, 0x69, 0x6e, 0x2e, 0x30, 0x30, 0x30, 0x30, 0x30, 0x31}
+	googleFormatEvent      = []byte{0x52, 0x52, 0xe9, 0x53, 0xf, 0x88, 0xf3, 0x0, 0x0, 0x66, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x4, 0x0, 0x35, 0x2e, 0x31, 0x2e, 0x36, 0x33, 0x2d, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2d, 0x6c, 0x6f, 0x67, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1b, 0x38, 0xd, 0x0, 0x8, 0x0, 0x12, 0x0, 0x4, 0x4, 0x4, 0x4, 0x12, 0x0, 0x0, 0x53, 0x0, 0x4, 0x1a, 0x8, 0x0, 0x0, 0x0, 0x8, 0x8, 0x8, 0x2}
+	googleQueryEvent       = []byte{0x53, 0x52, 0xe9, 0x53, 0x2, 0x88, 0xf3, 0x0, 0x0, 0xad, 0x0, 0x0, 0x0, 0x9a, 0x4, 0x0, 0x0, 0x0, 0x0, 0xb, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1b, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x40, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x8, 0x0, 0x8, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x20, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x20, 0x69, 0x66, 0x20, 0x6e, 0x6f, 0x74, 0x20, 0x65, 0x78, 0x69, 0x73, 0x74, 0x73, 0x20, 0x76, 0x74, 0x5f, 0x61, 0x20, 0x28, 0xa, 0x65, 0x69, 0x64, 0x20, 0x62, 0x69, 0x67, 0x69, 0x6e, 0x74, 0x2c, 0xa, 0x69, 0x64, 0x20, 0x69, 0x6e, 0x74, 0x2c, 0xa, 0x70, 0x72, 0x69, 0x6d, 0x61, 0x72, 0x79, 0x20, 0x6b, 0x65, 0x79, 0x28, 0x65, 0x69, 0x64, 0x2c, 0x20, 0x69, 0x64, 0x29, 0xa, 0x29, 0x20, 0x45, 0x6e, 0x67, 0x69, 0x6e, 0x65, 0x3d, 0x49, 0x6e, 0x6e, 0x6f, 0x44, 0x42}
+	googleXIDEvent         = []byte{0x53, 0x52, 0xe9, 0x53, 0x10, 0x88, 0xf3, 0x0, 0x0, 0x23, 0x0, 0x0, 0x0, 0x4e, 0xa, 0x0, 0x0, 0x0, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x78, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
+	googleTableMapEvent    = []byte{0x53, 0x52, 0xe9, 0x53, 0x13, 0x88, 0xf3, 0x0, 0x0, 0x46, 0x0, 0x0, 0x0, 0xdf, 0x4, 0x0, 0x0, 0x0, 0x0, 0xb, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x42, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x10, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x4, 0x76, 0x74, 0x5f, 0x61, 0x0, 0x4, 0x8, 0xf, 0xf6, 0xc, 0x4, 0x40, 0x0, 0xa, 0x2, 0xe}
+	googleWriteRowsEvent   = []byte{0x53, 0x52, 0xe9, 0x53, 0x17, 0x88, 0xf3, 0x0, 0x0, 0x4d, 0x0, 0x0, 0x0, 0x2c, 0x5, 0x0, 0x0, 0x0, 0x0, 0xb, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x42, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x4, 0xf, 0x0, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x61, 0x62, 0x63, 0x80, 0x0, 0x4, 0xd2, 0x38, 0x95, 0xee, 0xe4, 0x65, 0x51, 0x12, 0x0, 0x0, 0xa, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0xff, 0xfb, 0x2d, 0xc7}
+	googleUpdateRowsEvent  = []byte{0x53, 0x52, 0xe9, 0x53, 0x18, 0x88, 0xf3, 0x0, 0x0, 0x5a, 0x0, 0x0, 0x0, 0x86, 0x5, 0x0, 0x0, 0x0, 0x0, 0xb, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x42, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x4, 0xf, 0xf, 0x0, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x61, 0x62, 0x63, 0x80, 0x0, 0x4, 0xd2, 0x38, 0x95, 0xee, 0xe4, 0x65, 0x51, 0x12, 0x0, 0x0, 0x0, 0x3, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x61, 0x62, 0x63, 0x80, 0x0, 0x4, 0xd2, 0x38, 0x95, 0xee, 0xe4, 0x65, 0x51, 0x12, 0x0, 0x0}
+	googleDeleteRowsEvent  = []byte{0x53, 0x52, 0xe9, 0x53, 0x19, 0x88, 0xf3, 0x0, 0x0, 0x33, 0x0, 0x0, 0x0, 0xb9, 0x5, 0x0, 0x0, 0x0, 0x0, 0xb, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x42, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x4, 0xf, 0xa, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0xff, 0xfb, 0x2d, 0xc7}
+	mysql56TableMapEvent   = []byte{0x9f, 0x52, 0xe9, 0x53, 0x13, 0x01, 0x00, 0x00, 0x00, 0x41, 0x00, 0x00, 0x00, 0x72, 0x03, 0x00, 0x00, 0x00, 0x00, 0x42, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x10, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x00, 0x04, 0x76, 0x74, 0x5f, 0x61, 0x00, 0x05, 0x08, 0x0f, 0xf6, 0x12, 0x11, 0x06, 0x40, 0x00, 0x0a, 0x02, 0x03, 0x00, 0x0e}
+	mysql56WriteRowsEvent  = []byte{0x9f, 0x52, 0xe9, 0x53, 0x1e, 0x01, 0x00, 0x00, 0x00, 0x4e, 0x00, 0x00, 0x00, 0xc0, 0x03, 0x00, 0x00, 0x00, 0x00, 0x42, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x02, 0x00, 0x05, 0x1f, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x61, 0x62, 0x63, 0x80, 0x00, 0x04, 0xd2, 0x38, 0x99, 0x93, 0x96, 0xf7, 0xad, 0x04, 0xce, 0x53, 0xe8, 0xe1, 0xa5, 0x0a, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0xff, 0xfb, 0x2d, 0xc7, 0x53, 0xe8, 0xe1, 0xa5}
+	mysql56UpdateRowsEvent = []byte{0x9f, 0x52, 0xe9, 0x53, 0x1f, 0x01, 0x00, 0x00, 0x00, 0x5a, 0x00, 0x00, 0x00, 0x1a, 0x04, 0x00, 0x00, 0x00, 0x00, 0x42, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x02, 0x00, 0x05, 0x1f, 0x1f, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x61, 0x62, 0x63, 0x80, 0x00, 0x04, 0xd2, 0x38, 0x99, 0x93, 0x96, 0xf7, 0xad, 0x04, 0xce, 0x53, 0xe8, 0xe1, 0xa5, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x61, 0x62, 0x63, 0x80, 0x00, 0x04, 0xd2, 0x38, 0x99, 0x93, 0x96, 0xf7, 0xad, 0x04, 0xce, 0x53, 0xe8, 0xe1, 0xa5}
+	mysql56DeleteRowsEvent = []byte{0x9f, 0x52, 0xe9, 0x53, 0x20, 0x01, 0x00, 0x00, 0x00, 0x31, 0x00, 0x00, 0x00, 0x4b, 0x04, 0x00, 0x00, 0x00, 0x00, 0x42, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x02, 0x00, 0x05, 0x1f, 0x0a, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0xff, 0xfb, 0x2d, 0xc7, 0x53, 0xe8, 0xe1, 0xa5}
 )
 
 func TestBinlogEventEmptyBuf(t *testing.T) {
@@ -251,3 +265,283 @@ func TestBinlogEventQueryBadLength(t *testing.T) {
 		t.Errorf("wrong error, got %#v, want %#v", got, want)
 	}
 }
+
+func TestBinlogEventIsTableMap(t *testing.T) {
+	input := binlogEvent(googleTableMapEvent)
+	want := true
+	if got := input.IsTableMap(); got != want {
+		t.Errorf("%#v.IsTableMap() = %v, want %v", input, got, want)
+	}
+}
+
+func TestBinlogEventIsRows(t *testing.T) {
+	testcases := []struct {
+		input                 []byte
+		write, update, delete bool
+	}{
+		{googleWriteRowsEvent, true, false, false},
+		{googleUpdateRowsEvent, false, true, false},
+		{googleDeleteRowsEvent, false, false, true},
+		{mysql56WriteRowsEvent, true, false, false},
+		{mysql56UpdateRowsEvent, false, true, false},
+		{mysql56DeleteRowsEvent, false, false, true},
+		{googleQueryEvent, false, false, false},
+	}
+	for _, tc := range testcases {
+		input := binlogEvent(tc.input)
+		if got := input.IsWriteRows(); got != tc.write {
+			t.Errorf("%#v.IsWriteRows() = %v, want %v", input, got, tc.write)
+		}
+		if got := input.IsUpdateRows(); got != tc.update {
+			t.Errorf("%#v.IsUpdateRows() = %v, want %v", input, got, tc.update)
+		}
+		if got := input.IsDeleteRows(); got != tc.delete {
+			t.Errorf("%#v.IsDeleteRows() = %v, want %v", input, got, tc.delete)
+		}
+	}
+}
+
+func TestBinlogEventTableMap(t *testing.T) {
+	f, err := binlogEvent(googleFormatEvent).Format()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	input := binlogEvent(googleTableMapEvent)
+	want := &blproto.TableMap{
+		TableID:   0x42,
+		Database:  "vt_test_keyspace",
+		Name:      "vt_a",
+		Types:     []byte{typeLongLong, typeVarchar, typeNewDecimal, typeDateTime},
+		Metadata:  []uint16{0, 64, 10<<8 | 2, 0},
+		CanBeNull: []bool{false, true, true, true},
+	}
+	got, err := input.TableMap(f)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%#v.TableMap() = %#v, want %#v", input, got, want)
+	}
+}
+
+func TestBinlogEventTableMapMysql56(t *testing.T) {
+	input := binlogEvent(mysql56TableMapEvent)
+	want := &blproto.TableMap{
+		TableID:   0x42,
+		Database:  "vt_test_keyspace",
+		Name:      "vt_a",
+		Types:     []byte{typeLongLong, typeVarchar, typeNewDecimal, typeDateTime2, typeTimestamp2},
+		Metadata:  []uint16{0, 64, 10<<8 | 2, 3, 0},
+		CanBeNull: []bool{false, true, true, true, false},
+	}
+	got, err := input.TableMap(mysql56Format)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%#v.TableMap() = %#v, want %#v", input, got, want)
+	}
+}
+
+func TestBinlogEventTableMapBadLength(t *testing.T) {
+	f, err := binlogEvent(googleFormatEvent).Format()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	buf := make([]byte, len(googleTableMapEvent))
+	copy(buf, googleTableMapEvent)
+	buf[27+6+2] = 200 // mess up the database name length
+
+	input := binlogEvent(buf)
+	want := "can't read database name: name of length 200 is outside buffer"
+	_, err = input.TableMap(f)
+	if err == nil {
+		t.Errorf("expected error, got none")
+		return
+	}
+	if got := err.Error(); got != want {
+		t.Errorf("wrong error, got %#v, want %#v", got, want)
+	}
+}
+
+// imageStrings returns the values of a row image as strings.
+func imageStrings(values []sqltypes.Value) []string {
+	if values == nil {
+		return nil
+	}
+	result := make([]string, len(values))
+	for i, v := range values {
+		if v.IsNull() {
+			result[i] = "NULL"
+		} else {
+			result[i] = v.String()
+		}
+	}
+	return result
+}
+
+func TestBinlogEventRows(t *testing.T) {
+	f, err := binlogEvent(googleFormatEvent).Format()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	tm, err := binlogEvent(googleTableMapEvent).TableMap(f)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	all := []bool{true, true, true, true}
+	row1 := []string{"1", "abc", "1234.56", "2014-08-11 15:30:45"}
+	row2 := []string{"-2", "NULL", "-1234.56", "NULL"}
+	row3 := []string{"3", "abc", "1234.56", "2014-08-11 15:30:45"}
+	testRows(t, f, tm, []rowsTestCase{
+		{googleWriteRowsEvent, nil, all, [][]string{nil, nil}, [][]string{row1, row2}},
+		{googleUpdateRowsEvent, all, all, [][]string{row1}, [][]string{row3}},
+		{googleDeleteRowsEvent, all, nil, [][]string{row2}, [][]string{nil}},
+	})
+}
+
+func TestBinlogEventRowsMysql56(t *testing.T) {
+	tm, err := binlogEvent(mysql56TableMapEvent).TableMap(mysql56Format)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	all := []bool{true, true, true, true, true}
+	row1 := []string{"1", "abc", "1234.56", "2014-08-11 15:30:45.123", "2014-08-11 15:30:45"}
+	row2 := []string{"-2", "NULL", "-1234.56", "NULL", "2014-08-11 15:30:45"}
+	row3 := []string{"3", "abc", "1234.56", "2014-08-11 15:30:45.123", "2014-08-11 15:30:45"}
+	testRows(t, mysql56Format, tm, []rowsTestCase{
+		{mysql56WriteRowsEvent, nil, all, [][]string{nil, nil}, [][]string{row1, row2}},
+		{mysql56UpdateRowsEvent, all, all, [][]string{row1}, [][]string{row3}},
+		{mysql56DeleteRowsEvent, all, nil, [][]string{row2}, [][]string{nil}},
+	})
+}
+
+type rowsTestCase struct {
+	input                        []byte
+	identifyColumns, dataColumns []bool
+	identify, data               [][]string
+}
+
+func testRows(t *testing.T, f blproto.BinlogFormat, tm *blproto.TableMap, testcases []rowsTestCase) {
+	for _, tc := range testcases {
+		input := binlogEvent(tc.input)
+		got, err := input.Rows(f, tm)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(got.IdentifyColumns, tc.identifyColumns) || !reflect.DeepEqual(got.DataColumns, tc.dataColumns) {
+			t.Errorf("%#v.Rows() columns = %v, %v, want %v, %v", input, got.IdentifyColumns, got.DataColumns, tc.identifyColumns, tc.dataColumns)
+		}
+		if len(got.Rows) != len(tc.data) {
+			t.Errorf("%#v.Rows() returned %v rows, want %v", input, len(got.Rows), len(tc.data))
+			continue
+		}
+		for i, row := range got.Rows {
+			if identify := imageStrings(row.Identify); !reflect.DeepEqual(identify, tc.identify[i]) {
+				t.Errorf("%#v.Rows() row %v identify = %v, want %v", input, i, identify, tc.identify[i])
+			}
+			if data := imageStrings(row.Data); !reflect.DeepEqual(data, tc.data[i]) {
+				t.Errorf("%#v.Rows() row %v data = %v, want %v", input, i, data, tc.data[i])
+			}
+		}
+	}
+}
+
+func TestBinlogEventRowsUnsigned(t *testing.T) {
+	f, err := binlogEvent(googleFormatEvent).Format()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	tm, err := binlogEvent(googleTableMapEvent).TableMap(f)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	tm.Unsigned = []bool{true, false, false, false}
+
+	input := binlogEvent(googleDeleteRowsEvent)
+	got, err := input.Rows(f, tm)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	want := "18446744073709551614"
+	if id := got.Rows[0].Identify[0].String(); id != want {
+		t.Errorf("%#v.Rows() id = %v, want %v", input, id, want)
+	}
+}
+
+func TestBinlogEventRowsTruncated(t *testing.T) {
+	f, err := binlogEvent(googleFormatEvent).Format()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	tm, err := binlogEvent(googleTableMapEvent).TableMap(f)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	buf := make([]byte, len(googleDeleteRowsEvent)-1)
+	copy(buf, googleDeleteRowsEvent)
+	input := binlogEvent(buf)
+	want := "can't read column 2 of vt_test_keyspace.vt_a: decimal of 5 bytes is outside buffer"
+	_, err = input.Rows(f, tm)
+	if err == nil {
+		t.Errorf("expected error, got none")
+		return
+	}
+	if got := err.Error(); got != want {
+		t.Errorf("wrong error, got %#v, want %#v", got, want)
+	}
+}
+
+func TestReadCellTemporal(t *testing.T) {
+	testcases := []struct {
+		typ      byte
+		metadata uint16
+		data     []byte
+		want     string
+	}{
+		{typeDate, 0, []byte{0x0b, 0xbd, 0x0f}, "2014-08-11"},
+		{typeYear, 0, []byte{114}, "2014"},
+		{typeTime2, 0, []byte{0x80, 0xf7, 0xad}, "15:30:45"},
+		{typeTime2, 3, []byte{0x7f, 0xff, 0xff, 0xfc, 0x18}, "-00:00:00.100"},
+		{typeTimestamp2, 0, []byte{0x53, 0xe9, 0x52, 0x53}, "2014-08-11 23:31:31"},
+		{typeTimestamp, 0, []byte{0, 0, 0, 0}, "0000-00-00 00:00:00"},
+	}
+	for _, tc := range testcases {
+		got, _, err := readCell(tc.data, 0, tc.typ, tc.metadata, false)
+		if err != nil {
+			t.Errorf("readCell(%v, %v) unexpected error: %v", tc.typ, tc.data, err)
+			continue
+		}
+		if got.String() != tc.want {
+			t.Errorf("readCell(%v, %v) = %v, want %v", tc.typ, tc.data, got.String(), tc.want)
+		}
+	}
+}
+
+func TestReadCellBadDecimal(t *testing.T) {
+	// (precision, scale) metadata that MySQL never writes
+	for _, metadata := range []uint16{0, 0<<8 | 2, 10<<8 | 11, 66<<8 | 2} {
+		_, _, err := readCell([]byte{0x80, 0x00, 0x04, 0xd2, 0x38}, 0, typeNewDecimal, metadata, false)
+		if err == nil || !strings.HasPrefix(err.Error(), "invalid decimal precision") {
+			t.Errorf("readCell(DECIMAL, %#x) = %v, want an invalid decimal error", metadata, err)
+		}
+	}
+}
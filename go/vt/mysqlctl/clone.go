@@ -140,6 +140,62 @@ func (mysqld *Mysqld) FindVtDatabases() ([]string, error) {
 	return dbNames, nil
 }
 
+// databaseDir is the directory of a database in the mysql data directory.
+type databaseDir struct {
+	// name is the name of the entry in the data directory.
+	name string
+	// path is the actual directory, with the symlinks evaluated.
+	path string
+}
+
+// findDatabaseDirs returns the directories of mysqlDataDir that hold a
+// database: the ones with a db.opt file (that includes empty databases),
+// or with at least one .frm file.
+func findDatabaseDirs(mysqlDataDir string) ([]databaseDir, error) {
+	dataDirEntries, err := ioutil.ReadDir(mysqlDataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []databaseDir
+	for _, de := range dataDirEntries {
+		dbDirPath := path.Join(mysqlDataDir, de.Name())
+		// If this is not a directory, try to eval it as a syslink.
+		if !de.IsDir() {
+			dbDirPath, err = filepath.EvalSymlinks(dbDirPath)
+			if err != nil {
+				return nil, err
+			}
+			de, err = os.Stat(dbDirPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !de.IsDir() {
+			continue
+		}
+
+		// Copy anything that defines a db.opt file - that includes empty databases.
+		if _, err := os.Stat(path.Join(dbDirPath, "db.opt")); err == nil {
+			result = append(result, databaseDir{de.Name(), dbDirPath})
+			continue
+		}
+
+		// Look for at least one .frm file
+		dbDirEntries, err := ioutil.ReadDir(dbDirPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, dbEntry := range dbDirEntries {
+			if strings.HasSuffix(dbEntry.Name(), ".frm") {
+				result = append(result, databaseDir{de.Name(), dbDirPath})
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
 func (mysqld *Mysqld) createSnapshot(concurrency int, serverMode bool) ([]SnapshotFile, error) {
 	sources := make([]string, 0, 128)
 	destinations := make([]string, 0, 128)
@@ -158,44 +214,12 @@ func (mysqld *Mysqld) createSnapshot(concurrency int, serverMode bool) ([]Snapsh
 		{mysqld.config.InnodbLogGroupHomeDir, path.Join(mysqld.SnapshotDir, innodbLogSubdir)},
 	}
 
-	dataDirEntries, err := ioutil.ReadDir(mysqld.config.DataDir)
+	dbDirs, err := findDatabaseDirs(mysqld.config.DataDir)
 	if err != nil {
 		return nil, err
 	}
-
-	for _, de := range dataDirEntries {
-		dbDirPath := path.Join(mysqld.config.DataDir, de.Name())
-		// If this is not a directory, try to eval it as a syslink.
-		if !de.IsDir() {
-			dbDirPath, err = filepath.EvalSymlinks(dbDirPath)
-			if err != nil {
-				return nil, err
-			}
-			de, err = os.Stat(dbDirPath)
-			if err != nil {
-				return nil, err
-			}
-		}
-		if de.IsDir() {
-			// Copy anything that defines a db.opt file - that includes empty databases.
-			_, err := os.Stat(path.Join(dbDirPath, "db.opt"))
-			if err == nil {
-				dps = append(dps, snapPair{dbDirPath, path.Join(mysqld.SnapshotDir, dataDir, de.Name())})
-			} else {
-				// Look for at least one .frm file
-				dbDirEntries, err := ioutil.ReadDir(dbDirPath)
-				if err == nil {
-					for _, dbEntry := range dbDirEntries {
-						if strings.HasSuffix(dbEntry.Name(), ".frm") {
-							dps = append(dps, snapPair{dbDirPath, path.Join(mysqld.SnapshotDir, dataDir, de.Name())})
-							break
-						}
-					}
-				} else {
-					return nil, err
-				}
-			}
-		}
+	for _, dd := range dbDirs {
+		dps = append(dps, snapPair{dd.path, path.Join(mysqld.SnapshotDir, dataDir, dd.name)})
 	}
 
 	for _, dp := range dps {
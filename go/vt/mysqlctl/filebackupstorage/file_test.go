@@ -0,0 +1,192 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package filebackupstorage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// This file tests the file BackupStorage engine.
+
+// Note this is a very generic test for BackupStorage implementations,
+// we test the interface only. But making it a generic test library is
+// more cumbersome, we'll do that when we have an actual need for
+// another BackupStorage implementation.
+
+// setupFileBackupStorage creates a temporary directory, and
+// returns a FileBackupStorage based on it
+func setupFileBackupStorage(t *testing.T) *FileBackupStorage {
+	root, err := ioutil.TempDir("", "fbstest")
+	if err != nil {
+		t.Fatalf("os.TempDir failed: %v", err)
+	}
+	return NewFileBackupStorage(root)
+}
+
+// cleanupFileBackupStorage removes the entire directory
+func cleanupFileBackupStorage(fbs *FileBackupStorage) {
+	os.RemoveAll(fbs.root)
+}
+
+func TestListBackups(t *testing.T) {
+	fbs := setupFileBackupStorage(t)
+	defer cleanupFileBackupStorage(fbs)
+
+	// verify we have no entry now
+	bucket := "keyspace/shard"
+	bhs, err := fbs.ListBackups(bucket)
+	if err != nil {
+		t.Fatalf("ListBackups on empty fbs failed: %v", err)
+	}
+	if len(bhs) != 0 {
+		t.Fatalf("ListBackups on empty fbs returned results: %#v", bhs)
+	}
+
+	// add one empty backup
+	firstBackup := "2014-01-01.000000.cell-0000000001"
+	bh, err := fbs.StartBackup(bucket, firstBackup)
+	if err != nil {
+		t.Fatalf("fbs.StartBackup failed: %v", err)
+	}
+	if err := bh.EndBackup(); err != nil {
+		t.Fatalf("bh.EndBackup failed: %v", err)
+	}
+
+	// verify we have one entry now
+	bhs, err = fbs.ListBackups(bucket)
+	if err != nil {
+		t.Fatalf("ListBackups on empty fbs failed: %v", err)
+	}
+	if len(bhs) != 1 ||
+		bhs[0].Bucket() != bucket ||
+		bhs[0].Name() != firstBackup {
+		t.Fatalf("ListBackups with one backup returned wrong results: %#v", bhs)
+	}
+
+	// add another one, with earlier date
+	secondBackup := "2013-01-01.000000.cell-0000000001"
+	bh, err = fbs.StartBackup(bucket, secondBackup)
+	if err != nil {
+		t.Fatalf("fbs.StartBackup failed: %v", err)
+	}
+	if err := bh.EndBackup(); err != nil {
+		t.Fatalf("bh.EndBackup failed: %v", err)
+	}
+
+	// verify we have two sorted entries now
+	bhs, err = fbs.ListBackups(bucket)
+	if err != nil {
+		t.Fatalf("ListBackups on empty fbs failed: %v", err)
+	}
+	if len(bhs) != 2 ||
+		bhs[0].Name() != secondBackup ||
+		bhs[1].Name() != firstBackup {
+		t.Fatalf("ListBackups with two backups returned wrong results: %#v", bhs)
+	}
+
+	// a backup with the same name can't be started again
+	if _, err := fbs.StartBackup(bucket, firstBackup); err == nil {
+		t.Fatalf("fbs.StartBackup with an existing name didn't fail")
+	}
+
+	// remove a backup, back to one
+	if err := fbs.RemoveBackup(bucket, secondBackup); err != nil {
+		t.Fatalf("RemoveBackup failed: %v", err)
+	}
+	bhs, err = fbs.ListBackups(bucket)
+	if err != nil {
+		t.Fatalf("ListBackups after deletion failed: %v", err)
+	}
+	if len(bhs) != 1 ||
+		bhs[0].Name() != firstBackup {
+		t.Fatalf("ListBackups after deletion returned wrong results: %#v", bhs)
+	}
+
+	// add a backup but abort it, should stay at one
+	bh, err = fbs.StartBackup(bucket, secondBackup)
+	if err != nil {
+		t.Fatalf("fbs.StartBackup failed: %v", err)
+	}
+	if err := bh.AbortBackup(); err != nil {
+		t.Fatalf("bh.AbortBackup failed: %v", err)
+	}
+	bhs, err = fbs.ListBackups(bucket)
+	if err != nil {
+		t.Fatalf("ListBackups after abort failed: %v", err)
+	}
+	if len(bhs) != 1 ||
+		bhs[0].Name() != firstBackup {
+		t.Fatalf("ListBackups after abort returned wrong results: %#v", bhs)
+	}
+
+	// check we cannot change a backup we listed
+	if _, err := bhs[0].AddFile("test"); err == nil {
+		t.Fatalf("was able to AddFile to read-only backup")
+	}
+	if err := bhs[0].EndBackup(); err == nil {
+		t.Fatalf("was able to EndBackup a read-only backup")
+	}
+	if err := bhs[0].AbortBackup(); err == nil {
+		t.Fatalf("was able to AbortBackup a read-only backup")
+	}
+}
+
+func TestFileContents(t *testing.T) {
+	fbs := setupFileBackupStorage(t)
+	defer cleanupFileBackupStorage(fbs)
+
+	bucket := "keyspace/shard"
+	name := "2014-01-01.000000.cell-0000000001"
+	filename1 := "file1"
+	contents1 := "contents of the first file"
+
+	// start a backup, add a file
+	bh, err := fbs.StartBackup(bucket, name)
+	if err != nil {
+		t.Fatalf("fbs.StartBackup failed: %v", err)
+	}
+	wc, err := bh.AddFile(filename1)
+	if err != nil {
+		t.Fatalf("bh.AddFile failed: %v", err)
+	}
+	if _, err := wc.Write([]byte(contents1)); err != nil {
+		t.Fatalf("wc.Write failed: %v", err)
+	}
+	if err := wc.Close(); err != nil {
+		t.Fatalf("wc.Close failed: %v", err)
+	}
+
+	// test we can't read back on read-write backup
+	if _, err := bh.ReadFile(filename1); err == nil {
+		t.Fatalf("was able to ReadFile to read-write backup")
+	}
+
+	// and close
+	if err := bh.EndBackup(); err != nil {
+		t.Fatalf("bh.EndBackup failed: %v", err)
+	}
+
+	// re-read the file
+	bhs, err := fbs.ListBackups(bucket)
+	if err != nil || len(bhs) != 1 {
+		t.Fatalf("ListBackups returned wrong result: %v %v", err, bhs)
+	}
+	rc, err := bhs[0].ReadFile(filename1)
+	if err != nil {
+		t.Fatalf("bhs[0].ReadFile failed: %v", err)
+	}
+	buf, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll failed: %v", err)
+	}
+	if got := string(buf); got != contents1 {
+		t.Fatalf("read back wrong contents: got %#v, want %#v", got, contents1)
+	}
+	if err := rc.Close(); err != nil {
+		t.Fatalf("rc.Close failed: %v", err)
+	}
+}
@@ -6,6 +6,11 @@ package mysqlctl
 
 import (
 	"fmt"
+	"sync"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/vt/dbconnpool"
+	"github.com/youtube/vitess/go/vt/mysqlctl/proto"
 )
 
 // MysqlDaemon is the interface we use for abstracting Mysqld.
@@ -16,6 +21,23 @@ type MysqlDaemon interface {
 
 	// GetMysqlPort returns the current port mysql is listening on.
 	GetMysqlPort() (int, error)
+
+	// SetReadOnly sets the mysql read_only flag.
+	SetReadOnly(on bool) error
+
+	// PromoteSlave makes the slave a master, and returns the
+	// information its slaves need to restart on it.
+	PromoteSlave(setReadWrite bool, hookExtraEnv map[string]string) (*proto.ReplicationState, *proto.ReplicationPosition, int64, error)
+
+	// RestartSlave points the slave to the master in
+	// replicationState, and waits until it caught up.
+	RestartSlave(replicationState *proto.ReplicationState, waitPosition *proto.ReplicationPosition, timeCheck int64) error
+
+	// MasterStatus returns the current master position.
+	MasterStatus() (*proto.ReplicationPosition, error)
+
+	// GetDbaConnection returns a dba connection.
+	GetDbaConnection() (dbconnpool.PoolConnection, error)
 }
 
 // FakeMysqlDaemon implements MysqlDaemon and allows the user to fake
@@ -27,6 +49,37 @@ type FakeMysqlDaemon struct {
 
 	// will be returned by GetMysqlPort(). Set to -1 to return an error.
 	MysqlPort int
+
+	// ReadOnly is the current value of the read_only flag.
+	ReadOnly bool
+
+	// Replicating is true if the replication threads are running.
+	Replicating bool
+
+	// CurrentSlaveStatus is the slave position. It can be used by
+	// the fake tablet manager RPCs. Set to nil to return ErrNotSlave.
+	CurrentSlaveStatus *proto.ReplicationPosition
+
+	// PromoteSlaveResult is returned by PromoteSlave(). Set to nil
+	// to return an error.
+	PromoteSlaveResult *proto.ReplicationState
+
+	// CurrentMasterPosition is returned by MasterStatus(). Set to
+	// nil to return an error.
+	CurrentMasterPosition *proto.ReplicationPosition
+
+	// FetchSuperQuery runs the queries of the connections returned
+	// by GetDbaConnection(). Set to nil to make GetDbaConnection()
+	// return an error.
+	FetchSuperQuery func(query string) (*mproto.QueryResult, error)
+
+	// Schema is the schema of the database. It can be used by the
+	// fake tablet manager RPCs.
+	Schema *proto.SchemaDefinition
+
+	// mu protects lastConnectionId
+	mu               sync.Mutex
+	lastConnectionId int64
 }
 
 func (fmd *FakeMysqlDaemon) GetMasterAddr() (string, error) {
@@ -45,3 +98,79 @@ func (fmd *FakeMysqlDaemon) GetMysqlPort() (int, error) {
 	}
 	return fmd.MysqlPort, nil
 }
+
+func (fmd *FakeMysqlDaemon) SetReadOnly(on bool) error {
+	fmd.ReadOnly = on
+	return nil
+}
+
+func (fmd *FakeMysqlDaemon) PromoteSlave(setReadWrite bool, hookExtraEnv map[string]string) (*proto.ReplicationState, *proto.ReplicationPosition, int64, error) {
+	if fmd.PromoteSlaveResult == nil {
+		return nil, nil, 0, fmt.Errorf("FakeMysqlDaemon.PromoteSlave returns an error")
+	}
+	fmd.MasterAddr = ""
+	fmd.Replicating = false
+	fmd.CurrentSlaveStatus = nil
+	if setReadWrite {
+		fmd.ReadOnly = false
+	}
+	return fmd.PromoteSlaveResult, &fmd.PromoteSlaveResult.ReplicationPosition, 1, nil
+}
+
+func (fmd *FakeMysqlDaemon) RestartSlave(replicationState *proto.ReplicationState, waitPosition *proto.ReplicationPosition, timeCheck int64) error {
+	fmd.MasterAddr = replicationState.MasterAddr()
+	fmd.Replicating = true
+	return nil
+}
+
+func (fmd *FakeMysqlDaemon) MasterStatus() (*proto.ReplicationPosition, error) {
+	if fmd.CurrentMasterPosition == nil {
+		return nil, fmt.Errorf("FakeMysqlDaemon.MasterStatus returns an error")
+	}
+	rp := *fmd.CurrentMasterPosition
+	return &rp, nil
+}
+
+func (fmd *FakeMysqlDaemon) GetDbaConnection() (dbconnpool.PoolConnection, error) {
+	if fmd.FetchSuperQuery == nil {
+		return nil, fmt.Errorf("FakeMysqlDaemon.GetDbaConnection returns an error")
+	}
+	fmd.mu.Lock()
+	defer fmd.mu.Unlock()
+	fmd.lastConnectionId++
+	return &fakeDbaConnection{fmd: fmd, id: fmd.lastConnectionId}, nil
+}
+
+// fakeDbaConnection is a dbconnpool.PoolConnection that runs its
+// queries with FakeMysqlDaemon.FetchSuperQuery.
+type fakeDbaConnection struct {
+	fmd    *FakeMysqlDaemon
+	id     int64
+	closed bool
+}
+
+func (conn *fakeDbaConnection) ExecuteFetch(query string, maxrows int, wantfields bool) (*mproto.QueryResult, error) {
+	if conn.closed {
+		return nil, fmt.Errorf("connection %v is closed", conn.id)
+	}
+	return conn.fmd.FetchSuperQuery(query)
+}
+
+func (conn *fakeDbaConnection) ExecuteStreamFetch(query string, callback func(*mproto.QueryResult) error, streamBufferSize int) error {
+	return fmt.Errorf("fakeDbaConnection.ExecuteStreamFetch is not supported")
+}
+
+func (conn *fakeDbaConnection) Id() int64 {
+	return conn.id
+}
+
+func (conn *fakeDbaConnection) Close() {
+	conn.closed = true
+}
+
+func (conn *fakeDbaConnection) IsClosed() bool {
+	return conn.closed
+}
+
+func (conn *fakeDbaConnection) Recycle() {
+}
@@ -6,8 +6,10 @@ package mysqlctl
 
 import (
 	"os"
+	"time"
 
 	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/mysql"
 	blproto "github.com/youtube/vitess/go/vt/binlog/proto"
 	"github.com/youtube/vitess/go/vt/mysqlctl/proto"
 )
@@ -23,10 +25,24 @@ type MysqlFlavor interface {
 	// and the corresponding transaction group id.
 	MasterStatus(mysqld *Mysqld) (*proto.ReplicationPosition, error)
 
+	// SlaveStatusGTID returns the GTID of the last transaction executed by
+	// the slave, from the fields of 'SHOW SLAVE STATUS'.
+	SlaveStatusGTID(fields map[string]string) (proto.GTID, error)
+
 	// PromoteSlaveCommands returns the commands to run to change
 	// a slave into a master
 	PromoteSlaveCommands() []string
 
+	// StartReplicationCommands returns the commands to run to make
+	// the server replicate from the master in replState, starting at
+	// its ReplicationPosition.
+	StartReplicationCommands(params *mysql.ConnectionParams, replState *proto.ReplicationState) ([]string, error)
+
+	// WaitMasterPos waits until the slave has executed all the
+	// transactions up to the given position, for at most waitTimeout
+	// (0 means forever).
+	WaitMasterPos(mysqld *Mysqld, rp *proto.ReplicationPosition, waitTimeout time.Duration) error
+
 	// ParseGTID converts a string containing a GTID in the canonical format of
 	// this MySQL flavor into a proto.GTID interface value.
 	ParseGTID(string) (proto.GTID, error)
@@ -7,7 +7,9 @@ package mysqlctl
 import (
 	"encoding/binary"
 	"fmt"
+	"time"
 
+	"github.com/youtube/vitess/go/mysql"
 	blproto "github.com/youtube/vitess/go/vt/binlog/proto"
 	"github.com/youtube/vitess/go/vt/mysqlctl/proto"
 )
@@ -58,6 +60,11 @@ func (flavor *googleMysql51) MasterStatus(mysqld *Mysqld) (rp *proto.Replication
 	return
 }
 
+// SlaveStatusGTID implements MysqlFlavor.SlaveStatusGTID
+func (flavor *googleMysql51) SlaveStatusGTID(fields map[string]string) (proto.GTID, error) {
+	return flavor.ParseGTID(fields["Exec_Master_Group_ID"])
+}
+
 // PromoteSlaveCommands implements MysqlFlavor.PromoteSlaveCommands
 func (*googleMysql51) PromoteSlaveCommands() []string {
 	return []string{
@@ -67,6 +74,16 @@ func (*googleMysql51) PromoteSlaveCommands() []string {
 	}
 }
 
+// StartReplicationCommands implements MysqlFlavor.StartReplicationCommands
+func (*googleMysql51) StartReplicationCommands(params *mysql.ConnectionParams, replState *proto.ReplicationState) ([]string, error) {
+	return changeMasterCommands(params, replState, filePositionOptions(&replState.ReplicationPosition))
+}
+
+// WaitMasterPos implements MysqlFlavor.WaitMasterPos
+func (*googleMysql51) WaitMasterPos(mysqld *Mysqld, rp *proto.ReplicationPosition, waitTimeout time.Duration) error {
+	return waitMasterPosFile(mysqld, rp, waitTimeout)
+}
+
 // ParseGTID implements MysqlFlavor.ParseGTID().
 func (*googleMysql51) ParseGTID(s string) (proto.GTID, error) {
 	return proto.ParseGTID(googleMysqlFlavorID, s)
@@ -8,6 +8,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/youtube/vitess/go/mysql"
 	blproto "github.com/youtube/vitess/go/vt/binlog/proto"
 	proto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
 )
@@ -178,3 +179,40 @@ func TestGoogleBinlogEventGTID(t *testing.T) {
 		t.Errorf("%#v.GTID() = %#v, want %#v", input, got, want)
 	}
 }
+
+func TestGoogleStartReplicationCommands(t *testing.T) {
+	params := &mysql.ConnectionParams{
+		Uname: "username",
+		Pass:  "password",
+	}
+	replState := &proto.ReplicationState{
+		ReplicationPosition: proto.ReplicationPosition{
+			MasterLogFile:     "vt-0000000001-bin.000003",
+			MasterLogPosition: 456,
+		},
+		MasterHost:         "localhost",
+		MasterPort:         123,
+		MasterConnectRetry: 1234,
+	}
+	want := []string{
+		"STOP SLAVE",
+		"RESET SLAVE",
+		`CHANGE MASTER TO
+  MASTER_HOST = 'localhost',
+  MASTER_PORT = 123,
+  MASTER_USER = 'username',
+  MASTER_PASSWORD = 'password',
+  MASTER_CONNECT_RETRY = 1234,
+  MASTER_LOG_FILE = 'vt-0000000001-bin.000003',
+  MASTER_LOG_POS = 456`,
+		"START SLAVE",
+	}
+	got, err := (&googleMysql51{}).StartReplicationCommands(params, replState)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(&googleMysql51{}).StartReplicationCommands(%#v, %#v) = %#v, want %#v", params, replState, got, want)
+	}
+}
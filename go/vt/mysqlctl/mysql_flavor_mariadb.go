@@ -7,7 +7,9 @@ package mysqlctl
 import (
 	"encoding/binary"
 	"fmt"
+	"time"
 
+	"github.com/youtube/vitess/go/mysql"
 	blproto "github.com/youtube/vitess/go/vt/binlog/proto"
 	"github.com/youtube/vitess/go/vt/mysqlctl/proto"
 )
@@ -62,6 +64,11 @@ func (flavor *mariaDB10) MasterStatus(mysqld *Mysqld) (rp *proto.ReplicationPosi
 	return
 }
 
+// SlaveStatusGTID implements MysqlFlavor.SlaveStatusGTID
+func (flavor *mariaDB10) SlaveStatusGTID(fields map[string]string) (proto.GTID, error) {
+	return flavor.ParseGTID(fields["Exec_Master_Group_ID"])
+}
+
 // PromoteSlaveCommands implements MysqlFlavor.PromoteSlaveCommands
 func (*mariaDB10) PromoteSlaveCommands() []string {
 	return []string{
@@ -69,6 +76,16 @@ func (*mariaDB10) PromoteSlaveCommands() []string {
 	}
 }
 
+// StartReplicationCommands implements MysqlFlavor.StartReplicationCommands
+func (*mariaDB10) StartReplicationCommands(params *mysql.ConnectionParams, replState *proto.ReplicationState) ([]string, error) {
+	return changeMasterCommands(params, replState, filePositionOptions(&replState.ReplicationPosition))
+}
+
+// WaitMasterPos implements MysqlFlavor.WaitMasterPos
+func (*mariaDB10) WaitMasterPos(mysqld *Mysqld, rp *proto.ReplicationPosition, waitTimeout time.Duration) error {
+	return waitMasterPosFile(mysqld, rp, waitTimeout)
+}
+
 // ParseGTID implements MysqlFlavor.ParseGTID().
 func (*mariaDB10) ParseGTID(s string) (proto.GTID, error) {
 	return proto.ParseGTID(mariadbFlavorID, s)
@@ -0,0 +1,229 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/youtube/vitess/go/mysql"
+	blproto "github.com/youtube/vitess/go/vt/binlog/proto"
+	"github.com/youtube/vitess/go/vt/mysqlctl/proto"
+)
+
+// mysql56 is the implementation of MysqlFlavor for MySQL 5.6 with
+// gtid_mode=ON.
+type mysql56 struct {
+}
+
+const mysql56FlavorID = "MySQL56"
+
+// MasterStatus implements MysqlFlavor.MasterStatus
+func (flavor *mysql56) MasterStatus(mysqld *Mysqld) (rp *proto.ReplicationPosition, err error) {
+	// grab what we need from SHOW MASTER STATUS
+	qr, err := mysqld.fetchSuperQuery("SHOW MASTER STATUS")
+	if err != nil {
+		return
+	}
+	if len(qr.Rows) != 1 {
+		return nil, ErrNotMaster
+	}
+	if len(qr.Rows[0]) < 2 {
+		return nil, fmt.Errorf("unknown format for SHOW MASTER STATUS")
+	}
+	rp = &proto.ReplicationPosition{}
+	rp.MasterLogFile = qr.Rows[0][0].String()
+	utemp, err := qr.Rows[0][1].ParseUint64()
+	if err != nil {
+		return nil, err
+	}
+	rp.MasterLogPosition = uint(utemp)
+
+	// grab the GTID set of all the executed transactions
+	qr, err = mysqld.fetchSuperQuery("SELECT @@GLOBAL.gtid_executed")
+	if err != nil {
+		return
+	}
+	if len(qr.Rows) != 1 || len(qr.Rows[0]) < 1 {
+		return nil, fmt.Errorf("SELECT @@GLOBAL.gtid_executed returned no result")
+	}
+	rp.MasterLogGTIDField.Value, err = flavor.ParseGTID(qr.Rows[0][0].String())
+	if err != nil {
+		return nil, err
+	}
+
+	// On the master, the SQL position and IO position are at
+	// necessarily the same point.
+	rp.MasterLogFileIo = rp.MasterLogFile
+	rp.MasterLogPositionIo = rp.MasterLogPosition
+	return
+}
+
+// SlaveStatusGTID implements MysqlFlavor.SlaveStatusGTID
+func (flavor *mysql56) SlaveStatusGTID(fields map[string]string) (proto.GTID, error) {
+	return flavor.ParseGTID(fields["Executed_Gtid_Set"])
+}
+
+// PromoteSlaveCommands implements MysqlFlavor.PromoteSlaveCommands
+func (*mysql56) PromoteSlaveCommands() []string {
+	// RESET MASTER would clear @@gtid_executed, so the slaves
+	// couldn't find their position on the new master.
+	return []string{
+		"RESET SLAVE ALL",
+	}
+}
+
+// StartReplicationCommands implements MysqlFlavor.StartReplicationCommands
+func (*mysql56) StartReplicationCommands(params *mysql.ConnectionParams, replState *proto.ReplicationState) ([]string, error) {
+	// The slave sends its own @@gtid_executed to the master,
+	// the file and position are not needed.
+	return changeMasterCommands(params, replState, "MASTER_AUTO_POSITION = 1")
+}
+
+// WaitMasterPos implements MysqlFlavor.WaitMasterPos
+func (*mysql56) WaitMasterPos(mysqld *Mysqld, rp *proto.ReplicationPosition, waitTimeout time.Duration) error {
+	if rp.MasterLogGTIDField.Value == nil {
+		return waitMasterPosFile(mysqld, rp, waitTimeout)
+	}
+	timeToWait := 0
+	if waitTimeout > 0 {
+		timeToWait = int(waitTimeout / time.Second)
+		if timeToWait == 0 {
+			timeToWait = 1
+		}
+	}
+	cmd := fmt.Sprintf("SELECT WAIT_UNTIL_SQL_THREAD_AFTER_GTIDS('%v', %v)", rp.MasterLogGTIDField.Value, timeToWait)
+	qr, err := mysqld.fetchSuperQuery(cmd)
+	if err != nil {
+		return err
+	}
+	if len(qr.Rows) != 1 {
+		return fmt.Errorf("WaitMasterPos returned unexpected row count: %v", len(qr.Rows))
+	}
+	if qr.Rows[0][0].IsNull() {
+		return fmt.Errorf("WaitMasterPos failed: replication stopped")
+	} else if qr.Rows[0][0].String() == "-1" {
+		return fmt.Errorf("WaitMasterPos failed: timed out")
+	}
+	return nil
+}
+
+// ParseGTID implements MysqlFlavor.ParseGTID().
+func (*mysql56) ParseGTID(s string) (proto.GTID, error) {
+	return proto.ParseGTID(mysql56FlavorID, s)
+}
+
+// SendBinlogDumpCommand implements MysqlFlavor.SendBinlogDumpCommand().
+func (flavor *mysql56) SendBinlogDumpCommand(mysqld *Mysqld, conn *SlaveConnection, startPos proto.GTID) error {
+	const COM_BINLOG_DUMP_GTID = 0x1e
+
+	// The events are parsed without their checksum. Declaring that we know
+	// about checksums makes the master send them, so we have to make sure
+	// there are none.
+	qr, err := conn.ExecuteFetch("SELECT @@GLOBAL.binlog_checksum", 1, false)
+	if err != nil {
+		return fmt.Errorf("mysql56.SendBinlogDumpCommand: can't get binlog_checksum: %v", err)
+	}
+	if len(qr.Rows) != 1 || len(qr.Rows[0]) != 1 {
+		return fmt.Errorf("mysql56.SendBinlogDumpCommand: SELECT @@GLOBAL.binlog_checksum returned no result")
+	}
+	if checksum := qr.Rows[0][0].String(); !strings.EqualFold(checksum, "NONE") {
+		return fmt.Errorf("mysql56.SendBinlogDumpCommand: binlog_checksum is %v, only NONE is supported", checksum)
+	}
+	if _, err := conn.ExecuteFetch("SET @master_binlog_checksum = 'NONE'", 0, false); err != nil {
+		return fmt.Errorf("mysql56.SendBinlogDumpCommand: failed to set @master_binlog_checksum: %v", err)
+	}
+
+	var gtidSet proto.Mysql56GTIDSet
+	if startPos != nil {
+		var ok bool
+		if gtidSet, ok = startPos.(proto.Mysql56GTIDSet); !ok {
+			return fmt.Errorf("mysql56.SendBinlogDumpCommand: wrong GTID type: %#v", startPos)
+		}
+	}
+	buf := makeBinlogDumpGTIDCommand(0, conn.slaveID, gtidSet)
+	return conn.SendCommand(COM_BINLOG_DUMP_GTID, buf)
+}
+
+// makeBinlogDumpGTIDCommand builds a buffer containing the data for a MySQL
+// COM_BINLOG_DUMP_GTID command.
+func makeBinlogDumpGTIDCommand(flags uint16, serverID uint32, gtidSet proto.Mysql56GTIDSet) []byte {
+	// BINLOG_THROUGH_GTID tells the master to use the GTID set.
+	const BINLOG_THROUGH_GTID = 0x04
+
+	sidBlock := gtidSet.SIDBlock()
+	var buf bytes.Buffer
+	buf.Grow(2 + 4 + 4 + 8 + 4 + len(sidBlock))
+
+	// binlog_flags (2 bytes)
+	binary.Write(&buf, binary.LittleEndian, flags|BINLOG_THROUGH_GTID)
+	// server_id of slave (4 bytes)
+	binary.Write(&buf, binary.LittleEndian, serverID)
+	// binlog_name_info_size (4 bytes), and no binlog_name
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+	// binlog_pos (8 bytes)
+	binary.Write(&buf, binary.LittleEndian, uint64(4))
+	// data_size (4 bytes) and data
+	binary.Write(&buf, binary.LittleEndian, uint32(len(sidBlock)))
+	buf.Write(sidBlock)
+
+	return buf.Bytes()
+}
+
+// MakeBinlogEvent implements MysqlFlavor.MakeBinlogEvent().
+func (*mysql56) MakeBinlogEvent(buf []byte) blproto.BinlogEvent {
+	return NewMysql56BinlogEvent(buf)
+}
+
+// mysql56BinlogEvent wraps a raw packet buffer and provides methods to examine
+// it by implementing blproto.BinlogEvent. Some methods are pulled in from
+// binlogEvent.
+type mysql56BinlogEvent struct {
+	binlogEvent
+}
+
+func NewMysql56BinlogEvent(buf []byte) blproto.BinlogEvent {
+	return mysql56BinlogEvent{binlogEvent: binlogEvent(buf)}
+}
+
+// HasGTID implements BinlogEvent.HasGTID().
+func (ev mysql56BinlogEvent) HasGTID(f blproto.BinlogFormat) bool {
+	// MySQL 5.6 provides GTIDs in a separate event type GTID_LOG_EVENT.
+	return ev.IsGTID()
+}
+
+// IsGTID implements BinlogEvent.IsGTID().
+func (ev mysql56BinlogEvent) IsGTID() bool {
+	return ev.Type() == 33
+}
+
+// GTID implements BinlogEvent.GTID().
+//
+// Expected format (L = total length of event data):
+//   # bytes   field
+//   1         flags
+//   16        SID (server UUID)
+//   8         GNO (sequence number, signed int)
+//
+// The returned set only has this transaction (SID:GNO), use
+// proto.AddGTID to get the position after the event.
+func (ev mysql56BinlogEvent) GTID(f blproto.BinlogFormat) (proto.GTID, error) {
+	data := ev.Bytes()[f.HeaderLength:]
+	if len(data) < 1+16+8 {
+		return nil, fmt.Errorf("GTID_LOG_EVENT data is too short: %v bytes", len(data))
+	}
+
+	var sid proto.SID
+	copy(sid[:], data[1:1+16])
+	gno := binary.LittleEndian.Uint64(data[1+16 : 1+16+8])
+	return proto.NewMysql56GTID(sid, gno), nil
+}
+
+func init() {
+	mysqlFlavors[mysql56FlavorID] = &mysql56{}
+}
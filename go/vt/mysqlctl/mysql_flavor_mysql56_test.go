@@ -0,0 +1,172 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/youtube/vitess/go/mysql"
+	blproto "github.com/youtube/vitess/go/vt/binlog/proto"
+	proto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+)
+
+// mysql56GTIDEvent is a GTID_LOG_EVENT for the transaction
+// 00010203-0405-0607-0809-0a0b0c0d0e0f:1234.
+var mysql56GTIDEvent = []byte{
+	// header
+	0x9f, 0x52, 0xe9, 0x53, 0x21, 0x01, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00,
+	0xf1, 0x02, 0x00, 0x00, 0x00, 0x00,
+	// flags
+	0x01,
+	// SID
+	0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07,
+	0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+	// GNO
+	0xd2, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+var mysql56Format = blproto.BinlogFormat{
+	FormatVersion: 4,
+	ServerVersion: "5.6.20-log",
+	HeaderLength:  19,
+}
+
+func TestMysql56MakeBinlogEvent(t *testing.T) {
+	input := []byte{1, 2, 3}
+	want := mysql56BinlogEvent{binlogEvent: binlogEvent([]byte{1, 2, 3})}
+	if got := (&mysql56{}).MakeBinlogEvent(input); !reflect.DeepEqual(got, want) {
+		t.Errorf("(&mysql56{}).MakeBinlogEvent(%#v) = %#v, want %#v", input, got, want)
+	}
+}
+
+func TestMysql56BinlogEventIsGTID(t *testing.T) {
+	input := mysql56BinlogEvent{binlogEvent: binlogEvent(mysql56GTIDEvent)}
+	if !input.IsGTID() {
+		t.Errorf("%#v.IsGTID() = false, want true", input)
+	}
+	if !input.HasGTID(mysql56Format) {
+		t.Errorf("%#v.HasGTID() = false, want true", input)
+	}
+
+	input = mysql56BinlogEvent{binlogEvent: binlogEvent(googleFormatEvent)}
+	if input.IsGTID() {
+		t.Errorf("%#v.IsGTID() = true, want false", input)
+	}
+}
+
+func TestMysql56BinlogEventGTID(t *testing.T) {
+	input := mysql56BinlogEvent{binlogEvent: binlogEvent(mysql56GTIDEvent)}
+	want, _ := proto.ParseGTID(mysql56FlavorID, "00010203-0405-0607-0809-0a0b0c0d0e0f:1234")
+	got, err := input.GTID(mysql56Format)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got != want {
+		t.Errorf("%#v.GTID() = %v, want %v", input, got, want)
+	}
+}
+
+func TestMysql56BinlogEventGTIDTooShort(t *testing.T) {
+	input := mysql56BinlogEvent{binlogEvent: binlogEvent(mysql56GTIDEvent[:30])}
+	want := "GTID_LOG_EVENT data is too short: 11 bytes"
+	_, err := input.GTID(mysql56Format)
+	if err == nil {
+		t.Errorf("expected error, got none")
+		return
+	}
+	if got := err.Error(); got != want {
+		t.Errorf("wrong error, got %#v, want %#v", got, want)
+	}
+}
+
+func TestMysql56MakeBinlogDumpGTIDCommand(t *testing.T) {
+	gtidSet, _ := proto.ParseGTID(mysql56FlavorID, "00010203-0405-0607-0809-0a0b0c0d0e0f:1-5")
+	want := []byte{
+		// binlog_flags
+		0x04, 0x00,
+		// server_id
+		0x78, 0x56, 0x34, 0x12,
+		// binlog_name_info_size
+		0x00, 0x00, 0x00, 0x00,
+		// binlog_pos
+		0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// data_size
+		0x30, 0x00, 0x00, 0x00,
+		// n_sids
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// SID
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07,
+		0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+		// n_intervals
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// 1-5
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x06, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	got := makeBinlogDumpGTIDCommand(0, 0x12345678, gtidSet.(proto.Mysql56GTIDSet))
+	if !bytes.Equal(got, want) {
+		t.Errorf("makeBinlogDumpGTIDCommand() = %#v, want %#v", got, want)
+	}
+}
+
+func TestMysql56ParseGTID(t *testing.T) {
+	input := "00010203-0405-0607-0809-0a0b0c0d0e0f:1-5"
+	got, err := (&mysql56{}).ParseGTID(input)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got.Flavor() != mysql56FlavorID || got.String() != input {
+		t.Errorf("(&mysql56{}).ParseGTID(%v) = %#v", input, got)
+	}
+}
+
+func TestMysql56SlaveStatusGTID(t *testing.T) {
+	input := map[string]string{
+		"Exec_Master_Group_ID": "12345",
+		"Executed_Gtid_Set":    "00010203-0405-0607-0809-0a0b0c0d0e0f:1-5",
+	}
+	want, _ := proto.ParseGTID(mysql56FlavorID, "00010203-0405-0607-0809-0a0b0c0d0e0f:1-5")
+	got, err := (&mysql56{}).SlaveStatusGTID(input)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got != want {
+		t.Errorf("(&mysql56{}).SlaveStatusGTID(%v) = %v, want %v", input, got, want)
+	}
+}
+
+func TestMysql56StartReplicationCommands(t *testing.T) {
+	params := &mysql.ConnectionParams{
+		Uname: "username",
+		Pass:  "password",
+	}
+	replState := &proto.ReplicationState{
+		MasterHost:         "localhost",
+		MasterPort:         123,
+		MasterConnectRetry: 1234,
+	}
+	want := []string{
+		"STOP SLAVE",
+		"RESET SLAVE",
+		`CHANGE MASTER TO
+  MASTER_HOST = 'localhost',
+  MASTER_PORT = 123,
+  MASTER_USER = 'username',
+  MASTER_PASSWORD = 'password',
+  MASTER_CONNECT_RETRY = 1234,
+  MASTER_AUTO_POSITION = 1`,
+		"START SLAVE",
+	}
+	got, err := (&mysql56{}).StartReplicationCommands(params, replState)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(&mysql56{}).StartReplicationCommands(%#v, %#v) = %#v, want %#v", params, replState, got, want)
+	}
+}
@@ -7,7 +7,9 @@ package mysqlctl
 import (
 	"os"
 	"testing"
+	"time"
 
+	"github.com/youtube/vitess/go/mysql"
 	blproto "github.com/youtube/vitess/go/vt/binlog/proto"
 	"github.com/youtube/vitess/go/vt/mysqlctl/proto"
 )
@@ -17,8 +19,17 @@ type fakeMysqlFlavor struct{}
 func (fakeMysqlFlavor) MasterStatus(mysqld *Mysqld) (*proto.ReplicationPosition, error) {
 	return nil, nil
 }
+func (fakeMysqlFlavor) SlaveStatusGTID(fields map[string]string) (proto.GTID, error) {
+	return nil, nil
+}
 func (fakeMysqlFlavor) PromoteSlaveCommands() []string       { return nil }
 func (fakeMysqlFlavor) ParseGTID(string) (proto.GTID, error) { return nil, nil }
+func (fakeMysqlFlavor) StartReplicationCommands(params *mysql.ConnectionParams, replState *proto.ReplicationState) ([]string, error) {
+	return nil, nil
+}
+func (fakeMysqlFlavor) WaitMasterPos(mysqld *Mysqld, rp *proto.ReplicationPosition, waitTimeout time.Duration) error {
+	return nil
+}
 func (fakeMysqlFlavor) SendBinlogDumpCommand(mysqld *Mysqld, conn *SlaveConnection, startPos proto.GTID) error {
 	return nil
 }
@@ -456,6 +456,14 @@ func (mysqld *Mysqld) GetDbaConnection() (dbconnpool.PoolConnection, error) {
 	return mysqld.dbaPool.Get()
 }
 
+// DbaConnectionParams returns a copy of the dba connection parameters,
+// using dbName as the default database.
+func (mysqld *Mysqld) DbaConnectionParams(dbName string) *mysql.ConnectionParams {
+	params := *mysqld.dba
+	params.DbName = dbName
+	return &params
+}
+
 // Close will close this instance of Mysqld. It will wait for all dba
 // queries to be finished.
 func (mysqld *Mysqld) Close() {
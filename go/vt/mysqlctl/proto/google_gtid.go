@@ -53,6 +53,50 @@ func (gtid GoogleGTID) TryCompare(cmp GTID) (int, error) {
 	}
 }
 
+// Contains implements GTIDSet.Contains(). A group_id stands for all the
+// transactions up to it.
+func (gtid GoogleGTID) Contains(other GTIDSet) bool {
+	o, ok := other.(GoogleGTID)
+	return ok && gtid.GroupID >= o.GroupID
+}
+
+// AtLeast implements GTIDSet.AtLeast().
+func (gtid GoogleGTID) AtLeast(other GTIDSet) (bool, error) {
+	cmp, err := gtid.TryCompare(other)
+	if err != nil {
+		return false, err
+	}
+	return cmp >= 0, nil
+}
+
+// Union implements GTIDSet.Union().
+func (gtid GoogleGTID) Union(other GTIDSet) (GTIDSet, error) {
+	o, ok := other.(GoogleGTID)
+	if !ok {
+		return nil, fmt.Errorf("can't union GTID sets, wrong type: %#v.Union(%#v)", gtid, other)
+	}
+	if o.GroupID > gtid.GroupID {
+		return o, nil
+	}
+	return gtid, nil
+}
+
+// Subtract implements GTIDSet.Subtract(). The difference can only be
+// represented if it's empty, or if other is empty.
+func (gtid GoogleGTID) Subtract(other GTIDSet) (GTIDSet, error) {
+	o, ok := other.(GoogleGTID)
+	if !ok {
+		return nil, fmt.Errorf("can't subtract GTID sets, wrong type: %#v.Subtract(%#v)", gtid, other)
+	}
+	switch {
+	case o.GroupID >= gtid.GroupID:
+		return GoogleGTID{}, nil
+	case o.GroupID == 0:
+		return gtid, nil
+	}
+	return nil, fmt.Errorf("can't subtract GTID sets, Google MySQL group_ids %v to %v are not a GTID", o.GroupID+1, gtid.GroupID)
+}
+
 func init() {
 	gtidParsers[googleMysqlFlavorID] = parseGoogleGTID
 }
@@ -143,3 +143,76 @@ func TestGoogleGTIDNotEqual(t *testing.T) {
 		t.Errorf("(%#v == %#v) = %v, want %v", input1, input2, cmp, want)
 	}
 }
+
+func TestGoogleGTIDContains(t *testing.T) {
+	input := GoogleGTID{GroupID: 12345}
+	table := map[GTIDSet]bool{
+		GoogleGTID{GroupID: 0}:                         true,
+		GoogleGTID{GroupID: 12344}:                     true,
+		GoogleGTID{GroupID: 12345}:                     true,
+		GoogleGTID{GroupID: 12346}:                     false,
+		MariadbGTID{Domain: 1, Server: 2, Sequence: 3}: false,
+	}
+	for other, want := range table {
+		if got := input.Contains(other); got != want {
+			t.Errorf("%#v.Contains(%#v) = %v, want %v", input, other, got, want)
+		}
+	}
+}
+
+func TestGoogleGTIDAtLeast(t *testing.T) {
+	input := GoogleGTID{GroupID: 12345}
+	if got, err := input.AtLeast(GoogleGTID{GroupID: 12344}); err != nil || !got {
+		t.Errorf("%#v.AtLeast(12344) = %v, %v, want true, nil", input, got, err)
+	}
+	if got, err := input.AtLeast(GoogleGTID{GroupID: 12346}); err != nil || got {
+		t.Errorf("%#v.AtLeast(12346) = %v, %v, want false, nil", input, got, err)
+	}
+	if _, err := input.AtLeast(MariadbGTID{}); err == nil {
+		t.Errorf("expected error for wrong type")
+	}
+}
+
+func TestGoogleGTIDUnion(t *testing.T) {
+	input := GoogleGTID{GroupID: 12345}
+	want := GoogleGTID{GroupID: 12346}
+	got, err := input.Union(GoogleGTID{GroupID: 12346})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got != want {
+		t.Errorf("%#v.Union(12346) = %#v, want %#v", input, got, want)
+	}
+	if got, _ = input.Union(GoogleGTID{GroupID: 100}); got != input {
+		t.Errorf("%#v.Union(100) = %#v, want %#v", input, got, input)
+	}
+}
+
+func TestGoogleGTIDSubtract(t *testing.T) {
+	input := GoogleGTID{GroupID: 12345}
+	table := map[GoogleGTID]GTIDSet{
+		GoogleGTID{GroupID: 12346}: GoogleGTID{},
+		GoogleGTID{GroupID: 12345}: GoogleGTID{},
+		GoogleGTID{GroupID: 0}:     input,
+	}
+	for other, want := range table {
+		got, err := input.Subtract(other)
+		if err != nil {
+			t.Errorf("unexpected error for %#v.Subtract(%#v): %v", input, other, err)
+		}
+		if got != want {
+			t.Errorf("%#v.Subtract(%#v) = %#v, want %#v", input, other, got, want)
+		}
+	}
+
+	// group_ids 101 to 12345 can't be represented
+	want := "can't subtract GTID sets"
+	_, err := input.Subtract(GoogleGTID{GroupID: 100})
+	if err == nil {
+		t.Errorf("expected error for non representable difference")
+		return
+	}
+	if !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("wrong error message, got '%v', want '%v'", err, want)
+	}
+}
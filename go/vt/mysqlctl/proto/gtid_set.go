@@ -0,0 +1,74 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proto
+
+import (
+	"fmt"
+)
+
+// GTIDSet is a GTID seen as the set of all the transactions a server has
+// executed up to that point, rather than a single position in its binlogs.
+// This allows comparing the positions of servers that replicate from
+// several masters, or that have transactions of their own.
+//
+// Google MySQL group_ids and MariaDB GTIDs stand for all the transactions
+// up to the given one, so they can only represent sets that start at the
+// first transaction. Union and Subtract return an error if the result
+// can't be represented.
+type GTIDSet interface {
+	GTID
+
+	// Contains returns true if all the transactions of other are in the set.
+	// A set never contains a set of a different flavor.
+	Contains(other GTIDSet) bool
+
+	// AtLeast returns true if the set is at least at the position other,
+	// i.e. if it contains it. Unlike Contains, it returns an error when the
+	// two sets can't be compared, e.g. they don't have the same flavor or
+	// don't come from the same master, so the callers waiting for a
+	// position can fail instead of waiting forever.
+	AtLeast(other GTIDSet) (bool, error)
+
+	// Union returns the set of the transactions that are in the set
+	// or in other.
+	Union(other GTIDSet) (GTIDSet, error)
+
+	// Subtract returns the set of the transactions that are in the set
+	// and not in other. On a slave, the transactions that are not on its
+	// master are errant transactions.
+	Subtract(other GTIDSet) (GTIDSet, error)
+}
+
+// ToGTIDSet returns gtid as a GTIDSet, or an error if its flavor
+// doesn't implement GTIDSet.
+func ToGTIDSet(gtid GTID) (GTIDSet, error) {
+	if gtid == nil {
+		return nil, fmt.Errorf("no GTID")
+	}
+	set, ok := gtid.(GTIDSet)
+	if !ok {
+		return nil, fmt.Errorf("GTID %#v of flavor %v is not a GTIDSet", gtid, gtid.Flavor())
+	}
+	return set, nil
+}
+
+// AddGTID returns the position after a binlog event that has the GTID
+// gtid, if the position before the event was pos.
+//
+// Google MySQL group_ids and MariaDB GTIDs stand for all the
+// transactions up to them, so the new position is gtid. A MySQL 5.6
+// GTID is a single transaction, so it's added to pos: the
+// transactions of the other servers, and the gaps, are kept.
+func AddGTID(pos, gtid GTID) (GTID, error) {
+	event, ok := gtid.(Mysql56GTIDSet)
+	if !ok || pos == nil {
+		return gtid, nil
+	}
+	set, err := ToGTIDSet(pos)
+	if err != nil {
+		return nil, err
+	}
+	return set.Union(event)
+}
@@ -424,3 +424,21 @@ type fakeGTID struct {
 func (f fakeGTID) String() string             { return f.value }
 func (f fakeGTID) Flavor() string             { return f.flavor }
 func (fakeGTID) TryCompare(GTID) (int, error) { return 0, nil }
+
+func TestToGTIDSet(t *testing.T) {
+	input := GoogleGTID{GroupID: 1234}
+	got, err := ToGTIDSet(input)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got != input {
+		t.Errorf("ToGTIDSet(%#v) = %#v, want %#v", input, got, input)
+	}
+
+	if _, err := ToGTIDSet(nil); err == nil {
+		t.Errorf("expected error for nil GTID")
+	}
+	if _, err := ToGTIDSet(fakeGTID{}); err == nil {
+		t.Errorf("expected error for a GTID that is not a GTIDSet")
+	}
+}
@@ -86,6 +86,53 @@ func (gtid MariadbGTID) TryCompare(cmp GTID) (int, error) {
 	}
 }
 
+// Contains implements GTIDSet.Contains(). A MariaDB GTID stands for all the
+// transactions of its domain up to it.
+func (gtid MariadbGTID) Contains(other GTIDSet) bool {
+	o, ok := other.(MariadbGTID)
+	if !ok || o.Domain != gtid.Domain {
+		return false
+	}
+	return o.Sequence == 0 || (o.Server == gtid.Server && gtid.Sequence >= o.Sequence)
+}
+
+// AtLeast implements GTIDSet.AtLeast().
+func (gtid MariadbGTID) AtLeast(other GTIDSet) (bool, error) {
+	cmp, err := gtid.TryCompare(other)
+	if err != nil {
+		return false, err
+	}
+	return cmp >= 0, nil
+}
+
+// Union implements GTIDSet.Union().
+func (gtid MariadbGTID) Union(other GTIDSet) (GTIDSet, error) {
+	if _, err := gtid.TryCompare(other); err != nil {
+		return nil, fmt.Errorf("can't union GTID sets: %v", err)
+	}
+	if o := other.(MariadbGTID); o.Sequence > gtid.Sequence {
+		return o, nil
+	}
+	return gtid, nil
+}
+
+// Subtract implements GTIDSet.Subtract(). The difference can only be
+// represented if it's empty, or if other is empty. The empty set of a
+// domain has a Sequence of 0.
+func (gtid MariadbGTID) Subtract(other GTIDSet) (GTIDSet, error) {
+	if _, err := gtid.TryCompare(other); err != nil {
+		return nil, fmt.Errorf("can't subtract GTID sets: %v", err)
+	}
+	o := other.(MariadbGTID)
+	switch {
+	case o.Sequence >= gtid.Sequence:
+		return MariadbGTID{Domain: gtid.Domain, Server: gtid.Server}, nil
+	case o.Sequence == 0:
+		return gtid, nil
+	}
+	return nil, fmt.Errorf("can't subtract GTID sets, MariaDB sequence numbers %v to %v are not a GTID", o.Sequence+1, gtid.Sequence)
+}
+
 func init() {
 	gtidParsers[mariadbFlavorID] = parseMariadbGTID
 }
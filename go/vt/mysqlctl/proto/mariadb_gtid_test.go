@@ -210,3 +210,66 @@ func TestMariaGTIDNotEqual(t *testing.T) {
 		t.Errorf("(%#v == %#v) = %v, want %v", input1, input2, cmp, want)
 	}
 }
+
+func TestMariaGTIDContains(t *testing.T) {
+	input := MariadbGTID{Domain: 1, Server: 2, Sequence: 100}
+	table := map[GTIDSet]bool{
+		MariadbGTID{Domain: 1, Server: 2, Sequence: 99}:  true,
+		MariadbGTID{Domain: 1, Server: 2, Sequence: 100}: true,
+		MariadbGTID{Domain: 1, Server: 2, Sequence: 101}: false,
+		MariadbGTID{Domain: 1, Server: 3, Sequence: 0}:   true,
+		MariadbGTID{Domain: 1, Server: 3, Sequence: 99}:  false,
+		MariadbGTID{Domain: 2, Server: 2, Sequence: 99}:  false,
+		GoogleGTID{GroupID: 99}:                          false,
+	}
+	for other, want := range table {
+		if got := input.Contains(other); got != want {
+			t.Errorf("%#v.Contains(%#v) = %v, want %v", input, other, got, want)
+		}
+	}
+}
+
+func TestMariaGTIDAtLeast(t *testing.T) {
+	input := MariadbGTID{Domain: 1, Server: 2, Sequence: 100}
+	if got, err := input.AtLeast(MariadbGTID{Domain: 1, Server: 2, Sequence: 100}); err != nil || !got {
+		t.Errorf("%#v.AtLeast(1-2-100) = %v, %v, want true, nil", input, got, err)
+	}
+	if got, err := input.AtLeast(MariadbGTID{Domain: 1, Server: 2, Sequence: 101}); err != nil || got {
+		t.Errorf("%#v.AtLeast(1-2-101) = %v, %v, want false, nil", input, got, err)
+	}
+	if _, err := input.AtLeast(MariadbGTID{Domain: 2, Server: 2, Sequence: 1}); err == nil {
+		t.Errorf("expected error for different domain")
+	}
+}
+
+func TestMariaGTIDUnion(t *testing.T) {
+	input := MariadbGTID{Domain: 1, Server: 2, Sequence: 100}
+	want := MariadbGTID{Domain: 1, Server: 2, Sequence: 200}
+	got, err := input.Union(want)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got != want {
+		t.Errorf("%#v.Union(%#v) = %#v, want %#v", input, want, got, want)
+	}
+
+	if _, err := input.Union(MariadbGTID{Domain: 1, Server: 3, Sequence: 100}); err == nil {
+		t.Errorf("expected error for different server")
+	}
+}
+
+func TestMariaGTIDSubtract(t *testing.T) {
+	input := MariadbGTID{Domain: 1, Server: 2, Sequence: 100}
+	want := MariadbGTID{Domain: 1, Server: 2}
+	got, err := input.Subtract(MariadbGTID{Domain: 1, Server: 2, Sequence: 101})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got != want {
+		t.Errorf("%#v.Subtract(1-2-101) = %#v, want %#v", input, got, want)
+	}
+
+	if _, err := input.Subtract(MariadbGTID{Domain: 1, Server: 2, Sequence: 50}); err == nil {
+		t.Errorf("expected error for non representable difference")
+	}
+}
@@ -0,0 +1,356 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const mysql56FlavorID = "MySQL56"
+
+// SID is the 16 byte UUID of the server that generated a MySQL 5.6 GTID.
+type SID [16]byte
+
+// ParseSID parses a SID in the canonical UUID format
+// (3e11fa47-71ca-11e1-9e33-c80aa9429562).
+func ParseSID(s string) (sid SID, err error) {
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return sid, fmt.Errorf("invalid MySQL 5.6 SID (%v)", s)
+	}
+	b, err := hex.DecodeString(s[:8] + s[9:13] + s[14:18] + s[19:23] + s[24:])
+	if err != nil {
+		return sid, fmt.Errorf("invalid MySQL 5.6 SID (%v): %v", s, err)
+	}
+	copy(sid[:], b)
+	return sid, nil
+}
+
+// String returns the canonical UUID format of the SID.
+func (sid SID) String() string {
+	h := hex.EncodeToString(sid[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// interval is a range of transaction numbers, Start and End included.
+type interval struct {
+	Start, End uint64
+}
+
+// Mysql56GTIDSet is a MySQL 5.6 GTID set, like @@gtid_executed. It keeps
+// the canonical form of the set, so it can be compared with ==.
+//
+// MySQL 5.6 positions are always sets. A binlog event only has a single
+// GTID (SID:N), and the position after the event is the position before
+// it plus that GTID, see AddGTID. The set may have transactions of several
+// servers, and gaps.
+type Mysql56GTIDSet struct {
+	canonical string
+}
+
+// sidIntervals maps the SID of every server to the sorted and
+// disjoint intervals of transaction numbers of that server.
+type sidIntervals map[SID][]interval
+
+// parseMysql56GTIDSet is registered as a parser for ParseGTID().
+// The format is 'SID:1-5:7,SID2:1-100', where whitespace is ignored, so
+// the output of @@gtid_executed can be parsed directly.
+func parseMysql56GTIDSet(s string) (GTID, error) {
+	set, err := parseSidIntervals(s)
+	if err != nil {
+		return nil, err
+	}
+	return Mysql56GTIDSet{set.String()}, nil
+}
+
+func parseSidIntervals(s string) (sidIntervals, error) {
+	set := make(sidIntervals)
+	s = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, s)
+	if s == "" {
+		return set, nil
+	}
+
+	for _, sidBlock := range strings.Split(s, ",") {
+		parts := strings.Split(sidBlock, ":")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid MySQL 5.6 GTID set (%v): expecting SID:interval[:interval...]", s)
+		}
+		sid, err := ParseSID(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range parts[1:] {
+			iv, err := parseInterval(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid MySQL 5.6 GTID set (%v): %v", s, err)
+			}
+			set.add(sid, iv)
+		}
+	}
+	return set, nil
+}
+
+// parseInterval parses 'N' or 'N-M'.
+func parseInterval(s string) (interval, error) {
+	parts := strings.SplitN(s, "-", 2)
+	start, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil || start == 0 {
+		return interval{}, fmt.Errorf("invalid interval (%v)", s)
+	}
+	end := start
+	if len(parts) == 2 {
+		if end, err = strconv.ParseUint(parts[1], 10, 64); err != nil || end < start {
+			return interval{}, fmt.Errorf("invalid interval (%v)", s)
+		}
+	}
+	return interval{start, end}, nil
+}
+
+// add adds an interval to the set, merging it with the
+// intervals it overlaps or touches.
+func (set sidIntervals) add(sid SID, iv interval) {
+	var merged []interval
+	for _, cur := range set[sid] {
+		switch {
+		case cur.End+1 < iv.Start || iv.End+1 < cur.Start:
+			merged = append(merged, cur)
+		default:
+			if cur.Start < iv.Start {
+				iv.Start = cur.Start
+			}
+			if cur.End > iv.End {
+				iv.End = cur.End
+			}
+		}
+	}
+	merged = append(merged, iv)
+	sort.Sort(intervalList(merged))
+	set[sid] = merged
+}
+
+type intervalList []interval
+
+func (l intervalList) Len() int           { return len(l) }
+func (l intervalList) Less(i, j int) bool { return l[i].Start < l[j].Start }
+func (l intervalList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+type sidList []SID
+
+func (l sidList) Len() int           { return len(l) }
+func (l sidList) Less(i, j int) bool { return bytes.Compare(l[i][:], l[j][:]) < 0 }
+func (l sidList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// sids returns the SIDs of the set, sorted.
+func (set sidIntervals) sids() []SID {
+	sids := make([]SID, 0, len(set))
+	for sid := range set {
+		sids = append(sids, sid)
+	}
+	sort.Sort(sidList(sids))
+	return sids
+}
+
+func (set sidIntervals) String() string {
+	var buf bytes.Buffer
+	for i, sid := range set.sids() {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(sid.String())
+		for _, iv := range set[sid] {
+			if iv.Start == iv.End {
+				fmt.Fprintf(&buf, ":%d", iv.Start)
+			} else {
+				fmt.Fprintf(&buf, ":%d-%d", iv.Start, iv.End)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// String implements GTID.String().
+func (set Mysql56GTIDSet) String() string {
+	return set.canonical
+}
+
+// intervals returns the parsed set.
+func (set Mysql56GTIDSet) intervals() sidIntervals {
+	intervals, err := parseSidIntervals(set.canonical)
+	if err != nil {
+		// canonical was generated by sidIntervals.String()
+		panic(err)
+	}
+	return intervals
+}
+
+// Flavor implements GTID.Flavor().
+func (set Mysql56GTIDSet) Flavor() string {
+	return mysql56FlavorID
+}
+
+// TryCompare implements GTID.TryCompare(). A set is greater than another
+// one if it contains all its transactions, and more. Two sets that both
+// have transactions the other one doesn't have can't be compared.
+func (set Mysql56GTIDSet) TryCompare(cmp GTID) (int, error) {
+	other, ok := cmp.(Mysql56GTIDSet)
+	if !ok {
+		return 0, fmt.Errorf("can't compare GTID, wrong type: %#v.TryCompare(%#v)",
+			set, cmp)
+	}
+
+	mine, others := set.intervals(), other.intervals()
+	contains, contained := mine.contains(others), others.contains(mine)
+	switch {
+	case contains && contained:
+		return 0, nil
+	case contains:
+		return 1, nil
+	case contained:
+		return -1, nil
+	}
+	return 0, fmt.Errorf("can't compare GTID, MySQL 5.6 GTID sets have diverged: %v, %v", set, other)
+}
+
+// Contains implements GTIDSet.Contains().
+func (set Mysql56GTIDSet) Contains(other GTIDSet) bool {
+	o, ok := other.(Mysql56GTIDSet)
+	return ok && set.intervals().contains(o.intervals())
+}
+
+// AtLeast implements GTIDSet.AtLeast(). MySQL 5.6 sets can always be
+// compared: a slave that doesn't contain a set yet may still get it.
+func (set Mysql56GTIDSet) AtLeast(other GTIDSet) (bool, error) {
+	o, ok := other.(Mysql56GTIDSet)
+	if !ok {
+		return false, fmt.Errorf("can't compare GTID, wrong type: %#v.AtLeast(%#v)", set, other)
+	}
+	return set.intervals().contains(o.intervals()), nil
+}
+
+// Union implements GTIDSet.Union().
+func (set Mysql56GTIDSet) Union(other GTIDSet) (GTIDSet, error) {
+	o, ok := other.(Mysql56GTIDSet)
+	if !ok {
+		return nil, fmt.Errorf("can't union GTID sets, wrong type: %#v.Union(%#v)", set, other)
+	}
+	union := set.intervals()
+	for sid, intervals := range o.intervals() {
+		for _, iv := range intervals {
+			union.add(sid, iv)
+		}
+	}
+	return Mysql56GTIDSet{union.String()}, nil
+}
+
+// Subtract implements GTIDSet.Subtract().
+func (set Mysql56GTIDSet) Subtract(other GTIDSet) (GTIDSet, error) {
+	o, ok := other.(Mysql56GTIDSet)
+	if !ok {
+		return nil, fmt.Errorf("can't subtract GTID sets, wrong type: %#v.Subtract(%#v)", set, other)
+	}
+	diff := set.intervals()
+	for sid, intervals := range o.intervals() {
+		for _, iv := range intervals {
+			diff.remove(sid, iv)
+		}
+	}
+	return Mysql56GTIDSet{diff.String()}, nil
+}
+
+// remove removes an interval from the set, splitting the
+// intervals it is in the middle of.
+func (set sidIntervals) remove(sid SID, iv interval) {
+	var remaining []interval
+	for _, cur := range set[sid] {
+		if cur.End < iv.Start || iv.End < cur.Start {
+			remaining = append(remaining, cur)
+			continue
+		}
+		if cur.Start < iv.Start {
+			remaining = append(remaining, interval{cur.Start, iv.Start - 1})
+		}
+		if cur.End > iv.End {
+			remaining = append(remaining, interval{iv.End + 1, cur.End})
+		}
+	}
+	if len(remaining) == 0 {
+		delete(set, sid)
+		return
+	}
+	set[sid] = remaining
+}
+
+// contains returns true if all the transactions of other are in set.
+func (set sidIntervals) contains(other sidIntervals) bool {
+	for sid, intervals := range other {
+		mine := set[sid]
+		for _, iv := range intervals {
+			found := false
+			for _, cur := range mine {
+				if cur.Start <= iv.Start && iv.End <= cur.End {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SIDBlock returns the binary encoding of the set, as used by the
+// COM_BINLOG_DUMP_GTID command:
+//   8         number of SIDs
+//   for each SID:
+//     16      SID
+//     8       number of intervals
+//     for each interval:
+//       8     start
+//       8     end (excluded)
+func (set Mysql56GTIDSet) SIDBlock() []byte {
+	return set.intervals().sidBlock()
+}
+
+func (set sidIntervals) sidBlock() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint64(len(set)))
+	for _, sid := range set.sids() {
+		buf.Write(sid[:])
+		binary.Write(&buf, binary.LittleEndian, uint64(len(set[sid])))
+		for _, iv := range set[sid] {
+			binary.Write(&buf, binary.LittleEndian, iv.Start)
+			binary.Write(&buf, binary.LittleEndian, iv.End+1)
+		}
+	}
+	return buf.Bytes()
+}
+
+// NewMysql56GTIDSet returns the set of the transactions of the server
+// up to the given one: SID:1-sequence.
+func NewMysql56GTIDSet(sid SID, sequence uint64) Mysql56GTIDSet {
+	return Mysql56GTIDSet{sidIntervals{sid: []interval{{1, sequence}}}.String()}
+}
+
+// NewMysql56GTID returns the set with only the given transaction:
+// SID:sequence. It's the GTID of a binlog event.
+func NewMysql56GTID(sid SID, sequence uint64) Mysql56GTIDSet {
+	return Mysql56GTIDSet{sidIntervals{sid: []interval{{sequence, sequence}}}.String()}
+}
+
+func init() {
+	gtidParsers[mysql56FlavorID] = parseMysql56GTIDSet
+}
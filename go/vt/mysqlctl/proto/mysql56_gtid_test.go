@@ -0,0 +1,302 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proto
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseSID(t *testing.T) {
+	input := "00010203-0405-0607-0809-0a0b0c0d0e0f"
+	want := SID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+	got, err := ParseSID(input)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got != want {
+		t.Errorf("ParseSID(%v) = %v, want %v", input, got, want)
+	}
+	if got.String() != input {
+		t.Errorf("%#v.String() = %v, want %v", got, got.String(), input)
+	}
+}
+
+func TestParseInvalidSID(t *testing.T) {
+	for _, input := range []string{
+		"00010203-0405-0607-0809-0a0b0c0d0e0",
+		"00010203-0405-0607-0809+0a0b0c0d0e0f",
+		"00010203-0405-0607-0809-0a0b0c0d0e0x",
+	} {
+		if _, err := ParseSID(input); err == nil {
+			t.Errorf("expected error for invalid input (%v)", input)
+		}
+	}
+}
+
+func TestParseMysql56GTIDSet(t *testing.T) {
+	table := map[string]string{
+		// empty set
+		"": "",
+		// single transaction
+		"00010203-0405-0607-0809-0a0b0c0d0e0f:5": "00010203-0405-0607-0809-0a0b0c0d0e0f:5",
+		// whitespace, like in the output of @@gtid_executed
+		"00010203-0405-0607-0809-0a0b0c0d0e0f:1-5,\n 00010203-0405-0607-0809-0a0b0c0d0e0e:7": "00010203-0405-0607-0809-0a0b0c0d0e0e:7,00010203-0405-0607-0809-0a0b0c0d0e0f:1-5",
+		// intervals are sorted and merged
+		"00010203-0405-0607-0809-0a0b0c0d0e0f:10-20:1-5:6:7-9:30": "00010203-0405-0607-0809-0a0b0c0d0e0f:1-20:30",
+		// the same SID twice
+		"00010203-0405-0607-0809-0a0b0c0d0e0f:1-5,00010203-0405-0607-0809-0a0b0c0d0e0f:3-8": "00010203-0405-0607-0809-0a0b0c0d0e0f:1-8",
+	}
+	for input, want := range table {
+		got, err := parseMysql56GTIDSet(input)
+		if err != nil {
+			t.Errorf("unexpected error for %#v: %v", input, err)
+			continue
+		}
+		if got.String() != want {
+			t.Errorf("parseMysql56GTIDSet(%#v) = %v, want %v", input, got, want)
+		}
+	}
+}
+
+func TestParseInvalidMysql56GTIDSet(t *testing.T) {
+	for _, input := range []string{
+		"00010203-0405-0607-0809-0a0b0c0d0e0f",
+		"00010203-0405-0607-0809-0a0b0c0d0e0f:0",
+		"00010203-0405-0607-0809-0a0b0c0d0e0f:5-3",
+		"00010203-0405-0607-0809-0a0b0c0d0e0f:1-x",
+		"00010203-0405-0607-0809:1-5",
+	} {
+		_, err := parseMysql56GTIDSet(input)
+		if err == nil {
+			t.Errorf("expected error for invalid input (%v)", input)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), "invalid MySQL 5.6") {
+			t.Errorf("wrong error message for %v: %v", input, err)
+		}
+	}
+}
+
+func TestMysql56GTIDSetEqual(t *testing.T) {
+	a, _ := parseMysql56GTIDSet("00010203-0405-0607-0809-0a0b0c0d0e0f:1-3:4-5")
+	b, _ := parseMysql56GTIDSet("00010203-0405-0607-0809-0a0b0c0d0e0f:1-5")
+	if a != b {
+		t.Errorf("%#v != %#v, want ==", a, b)
+	}
+}
+
+func TestMysql56GTIDSetTryCompare(t *testing.T) {
+	table := []struct {
+		a, b string
+		want int
+	}{
+		{"00010203-0405-0607-0809-0a0b0c0d0e0f:1-5", "00010203-0405-0607-0809-0a0b0c0d0e0f:1-5", 0},
+		{"00010203-0405-0607-0809-0a0b0c0d0e0f:1-6", "00010203-0405-0607-0809-0a0b0c0d0e0f:1-5", 1},
+		{"00010203-0405-0607-0809-0a0b0c0d0e0f:1-5", "00010203-0405-0607-0809-0a0b0c0d0e0f:1-6", -1},
+		{"00010203-0405-0607-0809-0a0b0c0d0e0f:1-5,00010203-0405-0607-0809-0a0b0c0d0e0e:1", "00010203-0405-0607-0809-0a0b0c0d0e0f:2-3", 1},
+		{"", "00010203-0405-0607-0809-0a0b0c0d0e0f:2-3", -1},
+	}
+	for _, tt := range table {
+		a, _ := parseMysql56GTIDSet(tt.a)
+		b, _ := parseMysql56GTIDSet(tt.b)
+		got, err := a.TryCompare(b)
+		if err != nil {
+			t.Errorf("unexpected error for %v.TryCompare(%v): %v", a, b, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%v.TryCompare(%v) = %v, want %v", a, b, got, tt.want)
+		}
+	}
+}
+
+func TestMysql56GTIDSetTryCompareDiverged(t *testing.T) {
+	a, _ := parseMysql56GTIDSet("00010203-0405-0607-0809-0a0b0c0d0e0f:1-5")
+	b, _ := parseMysql56GTIDSet("00010203-0405-0607-0809-0a0b0c0d0e0e:1-5")
+	want := "can't compare GTID, MySQL 5.6 GTID sets have diverged"
+	_, err := a.TryCompare(b)
+	if err == nil {
+		t.Errorf("expected error for %v.TryCompare(%v)", a, b)
+		return
+	}
+	if !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("wrong error message, got '%v', want '%v'", err, want)
+	}
+}
+
+func TestMysql56GTIDSetTryCompareWrongType(t *testing.T) {
+	a, _ := parseMysql56GTIDSet("00010203-0405-0607-0809-0a0b0c0d0e0f:1-5")
+	b := MariadbGTID{Domain: 1, Server: 2, Sequence: 3}
+	want := "can't compare GTID, wrong type"
+	_, err := a.TryCompare(b)
+	if err == nil {
+		t.Errorf("expected error for %v.TryCompare(%v)", a, b)
+		return
+	}
+	if !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("wrong error message, got '%v', want '%v'", err, want)
+	}
+}
+
+func TestNewMysql56GTIDSet(t *testing.T) {
+	sid := SID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	want := "00010203-0405-0607-0809-0a0b0c0d0e0f:1-1234"
+	if got := NewMysql56GTIDSet(sid, 1234).String(); got != want {
+		t.Errorf("NewMysql56GTIDSet(%v, 1234) = %v, want %v", sid, got, want)
+	}
+}
+
+func TestMysql56GTIDSetSIDBlock(t *testing.T) {
+	input, _ := parseMysql56GTIDSet("00010203-0405-0607-0809-0a0b0c0d0e0f:1-5:7")
+	want := []byte{
+		// n_sids
+		1, 0, 0, 0, 0, 0, 0, 0,
+		// sid
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		// n_intervals
+		2, 0, 0, 0, 0, 0, 0, 0,
+		// 1-5
+		1, 0, 0, 0, 0, 0, 0, 0,
+		6, 0, 0, 0, 0, 0, 0, 0,
+		// 7
+		7, 0, 0, 0, 0, 0, 0, 0,
+		8, 0, 0, 0, 0, 0, 0, 0,
+	}
+	if got := input.(Mysql56GTIDSet).SIDBlock(); !bytes.Equal(got, want) {
+		t.Errorf("%v.SIDBlock() = %#v, want %#v", input, got, want)
+	}
+}
+
+func TestMysql56GTIDSetSIDBlockEmpty(t *testing.T) {
+	want := []byte{0, 0, 0, 0, 0, 0, 0, 0}
+	if got := (Mysql56GTIDSet{}).SIDBlock(); !bytes.Equal(got, want) {
+		t.Errorf("Mysql56GTIDSet{}.SIDBlock() = %#v, want %#v", got, want)
+	}
+}
+
+func TestMysql56GTIDSetContains(t *testing.T) {
+	input, _ := parseMysql56GTIDSet("00010203-0405-0607-0809-0a0b0c0d0e0f:1-5:10-20,00010203-0405-0607-0809-0a0b0c0d0e0e:1-3")
+	table := map[string]bool{
+		"": true,
+		"00010203-0405-0607-0809-0a0b0c0d0e0f:1-5":                                            true,
+		"00010203-0405-0607-0809-0a0b0c0d0e0f:2:12-15,00010203-0405-0607-0809-0a0b0c0d0e0e:3": true,
+		"00010203-0405-0607-0809-0a0b0c0d0e0f:1-6":                                            false,
+		"00010203-0405-0607-0809-0a0b0c0d0e0f:4-11":                                           false,
+		"00010203-0405-0607-0809-0a0b0c0d0e0d:1":                                              false,
+	}
+	for s, want := range table {
+		other, _ := parseMysql56GTIDSet(s)
+		if got := input.(GTIDSet).Contains(other.(GTIDSet)); got != want {
+			t.Errorf("%v.Contains(%v) = %v, want %v", input, other, got, want)
+		}
+	}
+	if input.(GTIDSet).Contains(GoogleGTID{GroupID: 1}) {
+		t.Errorf("%v.Contains(GoogleGTID) = true, want false", input)
+	}
+}
+
+func TestMysql56GTIDSetAtLeast(t *testing.T) {
+	input, _ := parseMysql56GTIDSet("00010203-0405-0607-0809-0a0b0c0d0e0f:1-5")
+	other, _ := parseMysql56GTIDSet("00010203-0405-0607-0809-0a0b0c0d0e0e:1-5")
+
+	// diverged sets are not an error, the slave may catch up
+	if got, err := input.(GTIDSet).AtLeast(other.(GTIDSet)); err != nil || got {
+		t.Errorf("%v.AtLeast(%v) = %v, %v, want false, nil", input, other, got, err)
+	}
+	if _, err := input.(GTIDSet).AtLeast(GoogleGTID{GroupID: 1}); err == nil {
+		t.Errorf("expected error for wrong type")
+	}
+}
+
+func TestMysql56GTIDSetUnion(t *testing.T) {
+	table := []struct {
+		a, b, want string
+	}{
+		{"", "00010203-0405-0607-0809-0a0b0c0d0e0f:1-5", "00010203-0405-0607-0809-0a0b0c0d0e0f:1-5"},
+		{"00010203-0405-0607-0809-0a0b0c0d0e0f:1-5", "00010203-0405-0607-0809-0a0b0c0d0e0f:6-8:10", "00010203-0405-0607-0809-0a0b0c0d0e0f:1-8:10"},
+		{"00010203-0405-0607-0809-0a0b0c0d0e0f:1-5", "00010203-0405-0607-0809-0a0b0c0d0e0e:1-5", "00010203-0405-0607-0809-0a0b0c0d0e0e:1-5,00010203-0405-0607-0809-0a0b0c0d0e0f:1-5"},
+	}
+	for _, tt := range table {
+		a, _ := parseMysql56GTIDSet(tt.a)
+		b, _ := parseMysql56GTIDSet(tt.b)
+		got, err := a.(GTIDSet).Union(b.(GTIDSet))
+		if err != nil {
+			t.Errorf("unexpected error for %v.Union(%v): %v", a, b, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("%v.Union(%v) = %v, want %v", a, b, got, tt.want)
+		}
+	}
+}
+
+func TestMysql56GTIDSetSubtract(t *testing.T) {
+	table := []struct {
+		a, b, want string
+	}{
+		{"00010203-0405-0607-0809-0a0b0c0d0e0f:1-5", "", "00010203-0405-0607-0809-0a0b0c0d0e0f:1-5"},
+		{"00010203-0405-0607-0809-0a0b0c0d0e0f:1-5", "00010203-0405-0607-0809-0a0b0c0d0e0f:1-10", ""},
+		{"00010203-0405-0607-0809-0a0b0c0d0e0f:1-10", "00010203-0405-0607-0809-0a0b0c0d0e0f:3-4:8", "00010203-0405-0607-0809-0a0b0c0d0e0f:1-2:5-7:9-10"},
+		{"00010203-0405-0607-0809-0a0b0c0d0e0f:1-5,00010203-0405-0607-0809-0a0b0c0d0e0e:1-3", "00010203-0405-0607-0809-0a0b0c0d0e0f:1-5", "00010203-0405-0607-0809-0a0b0c0d0e0e:1-3"},
+	}
+	for _, tt := range table {
+		a, _ := parseMysql56GTIDSet(tt.a)
+		b, _ := parseMysql56GTIDSet(tt.b)
+		got, err := a.(GTIDSet).Subtract(b.(GTIDSet))
+		if err != nil {
+			t.Errorf("unexpected error for %v.Subtract(%v): %v", a, b, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("%v.Subtract(%v) = %v, want %v", a, b, got, tt.want)
+		}
+	}
+}
+
+func TestNewMysql56GTID(t *testing.T) {
+	sid := SID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	want := "00010203-0405-0607-0809-0a0b0c0d0e0f:1234"
+	if got := NewMysql56GTID(sid, 1234).String(); got != want {
+		t.Errorf("NewMysql56GTID(%v, 1234) = %v, want %v", sid, got, want)
+	}
+}
+
+func TestAddGTID(t *testing.T) {
+	sid1 := SID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	sid2 := SID{16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31}
+	pos := MustParseGTID(mysql56FlavorID, sid1.String()+":1-5:8-10,"+sid2.String()+":1-3")
+
+	// the other server's transactions and the gaps are kept
+	testCases := []struct {
+		gtid GTID
+		want string
+	}{
+		{NewMysql56GTID(sid1, 11), sid1.String() + ":1-5:8-11," + sid2.String() + ":1-3"},
+		{NewMysql56GTID(sid2, 7), sid1.String() + ":1-5:8-11," + sid2.String() + ":1-3:7"},
+		{NewMysql56GTID(sid1, 6), sid1.String() + ":1-6:8-11," + sid2.String() + ":1-3:7"},
+	}
+	for _, tc := range testCases {
+		var err error
+		if pos, err = AddGTID(pos, tc.gtid); err != nil {
+			t.Fatalf("AddGTID(%v) failed: %v", tc.gtid, err)
+		}
+		if got := pos.String(); got != tc.want {
+			t.Errorf("AddGTID(%v) = %v, want %v", tc.gtid, got, tc.want)
+		}
+	}
+
+	// without a starting position, the GTID is the position
+	if got, err := AddGTID(nil, NewMysql56GTID(sid1, 11)); err != nil || got != NewMysql56GTID(sid1, 11) {
+		t.Errorf("AddGTID(nil) = %v, %v", got, err)
+	}
+
+	// the other flavors' GTIDs replace the position
+	if got, err := AddGTID(GoogleGTID{GroupID: 10}, GoogleGTID{GroupID: 12}); err != nil || got != (GoogleGTID{GroupID: 12}) {
+		t.Errorf("AddGTID(GoogleGTID) = %v, %v", got, err)
+	}
+}
@@ -43,6 +43,13 @@ type ReplicationPosition struct {
 	// replication. If equal to InvalidLagSeconds, it means replication
 	// is not running.
 	SecondsBehindMaster uint
+
+	// SemiSyncAcked is set on a slave that acknowledges the
+	// transactions it receives to a semi-synchronous master
+	// (Rpl_semi_sync_slave_status from 'show status'). Such a
+	// master doesn't commit a transaction before one of these
+	// slaves received it.
+	SemiSyncAcked bool
 }
 
 func (rp *ReplicationPosition) MapKey() string {
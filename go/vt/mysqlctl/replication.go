@@ -21,6 +21,7 @@ import (
 	"time"
 
 	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/mysql"
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/vt/binlog/binlogplayer"
 	blproto "github.com/youtube/vitess/go/vt/binlog/proto"
@@ -38,10 +39,8 @@ var changeMasterCmd = `CHANGE MASTER TO
   MASTER_PORT = {{.ReplicationState.MasterPort}},
   MASTER_USER = '{{.MasterUser}}',
   MASTER_PASSWORD = '{{.MasterPassword}}',
-  MASTER_LOG_FILE = '{{.ReplicationState.ReplicationPosition.MasterLogFile}}',
-  MASTER_LOG_POS = {{.ReplicationState.ReplicationPosition.MasterLogPosition}},
-  MASTER_CONNECT_RETRY = {{.ReplicationState.MasterConnectRetry}}
-`
+  MASTER_CONNECT_RETRY = {{.ReplicationState.MasterConnectRetry}},
+  {{.Position}}`
 
 var masterPasswordStart = "  MASTER_PASSWORD = '"
 var masterPasswordEnd = "',\n"
@@ -50,17 +49,28 @@ type newMasterData struct {
 	ReplicationState *proto.ReplicationState
 	MasterUser       string
 	MasterPassword   string
+	Position         string
 }
 
+// StartReplicationCommands returns the commands to start replicating from
+// the master in replState. The way the position is given depends on the flavor.
 func StartReplicationCommands(mysqld *Mysqld, replState *proto.ReplicationState) ([]string, error) {
 	params, err := dbconfigs.MysqlParams(mysqld.replParams)
 	if err != nil {
 		return nil, err
 	}
+	return mysqld.flavor.StartReplicationCommands(&params, replState)
+}
+
+// changeMasterCommands returns the commands to replicate from the master
+// in replState, position being the options of CHANGE MASTER TO that set
+// the replication position.
+func changeMasterCommands(params *mysql.ConnectionParams, replState *proto.ReplicationState, position string) ([]string, error) {
 	nmd := &newMasterData{
 		ReplicationState: replState,
 		MasterUser:       params.Uname,
 		MasterPassword:   params.Pass,
+		Position:         position,
 	}
 	cmc, err := fillStringTemplate(changeMasterCmd, nmd)
 	if err != nil {
@@ -89,6 +99,12 @@ func StartReplicationCommands(mysqld *Mysqld, replState *proto.ReplicationState)
 		"START SLAVE"}, nil
 }
 
+// filePositionOptions returns the options of CHANGE MASTER TO
+// for the file and position of rp.
+func filePositionOptions(rp *proto.ReplicationPosition) string {
+	return fmt.Sprintf("MASTER_LOG_FILE = '%v',\n  MASTER_LOG_POS = %v", rp.MasterLogFile, rp.MasterLogPosition)
+}
+
 func fillStringTemplate(tmpl string, vars interface{}) (string, error) {
 	myTemplate := template.Must(template.New("").Parse(tmpl))
 	data := new(bytes.Buffer)
@@ -145,6 +161,21 @@ func (mysqld *Mysqld) StopSlave(hookExtraEnv map[string]string) error {
 	return mysqld.ExecuteSuperQuery("STOP SLAVE")
 }
 
+// ApplyRelayLogs starts the SQL thread only, so the slave applies
+// the relay logs it already received without fetching anything new
+// from its master, until it reaches rp. It then stops the SQL thread.
+func (mysqld *Mysqld) ApplyRelayLogs(rp *proto.ReplicationPosition, waitTimeout time.Duration) error {
+	cmd := fmt.Sprintf("START SLAVE SQL_THREAD UNTIL MASTER_LOG_FILE = '%v', MASTER_LOG_POS = %v", rp.MasterLogFile, rp.MasterLogPosition)
+	if err := mysqld.ExecuteSuperQuery(cmd); err != nil {
+		return err
+	}
+	err := mysqld.WaitMasterPos(rp, waitTimeout)
+	if stopErr := mysqld.ExecuteSuperQuery("STOP SLAVE SQL_THREAD"); err == nil {
+		err = stopErr
+	}
+	return err
+}
+
 func (mysqld *Mysqld) GetMasterAddr() (string, error) {
 	slaveStatus, err := mysqld.slaveStatus()
 	if err != nil {
@@ -193,6 +224,89 @@ func (mysqld *Mysqld) SetReadOnly(on bool) error {
 	return mysqld.ExecuteSuperQuery(query)
 }
 
+// SetSemiSyncEnabled enables or disables the master and slave sides of
+// semi-synchronous replication. The semi-sync plugins are installed
+// the first time they are enabled. Nothing is done for a side that
+// is already in the right state. When the slave side changes, the
+// slave IO thread is restarted, so it reconnects with the new setting.
+func (mysqld *Mysqld) SetSemiSyncEnabled(master, slave bool) error {
+	enabled, err := mysqld.semiSyncEnabled()
+	if err != nil {
+		return err
+	}
+
+	cmds := []string{}
+	masterEnabled, masterInstalled := enabled["rpl_semi_sync_master_enabled"]
+	if master != masterEnabled {
+		if master && !masterInstalled {
+			cmds = append(cmds, "INSTALL PLUGIN rpl_semi_sync_master SONAME 'semisync_master.so'")
+		}
+		cmds = append(cmds, fmt.Sprintf("SET GLOBAL rpl_semi_sync_master_enabled = %v", onOff(master)))
+	}
+	slaveEnabled, slaveInstalled := enabled["rpl_semi_sync_slave_enabled"]
+	if slave != slaveEnabled {
+		if slave && !slaveInstalled {
+			cmds = append(cmds, "INSTALL PLUGIN rpl_semi_sync_slave SONAME 'semisync_slave.so'")
+		}
+		cmds = append(cmds, fmt.Sprintf("SET GLOBAL rpl_semi_sync_slave_enabled = %v", onOff(slave)))
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+	if err := mysqld.ExecuteSuperQueryList(cmds); err != nil {
+		return err
+	}
+
+	if slave != slaveEnabled {
+		fields, err := mysqld.slaveStatus()
+		if err == ErrNotSlave {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fields["Slave_IO_Running"] == "Yes" {
+			return mysqld.ExecuteSuperQueryList([]string{"STOP SLAVE IO_THREAD", "START SLAVE IO_THREAD"})
+		}
+	}
+	return nil
+}
+
+// semiSyncEnabled returns the rpl_semi_sync_*_enabled variables. A
+// variable is missing if its plugin is not installed.
+func (mysqld *Mysqld) semiSyncEnabled() (map[string]bool, error) {
+	qr, err := mysqld.fetchSuperQuery("SHOW VARIABLES LIKE 'rpl_semi_sync_%_enabled'")
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool, len(qr.Rows))
+	for _, row := range qr.Rows {
+		result[row[0].String()] = row[1].String() == "ON"
+	}
+	return result, nil
+}
+
+// semiSyncSlaveStatus returns true if the slave IO thread is
+// acknowledging the transactions it receives to a semi-sync master.
+func (mysqld *Mysqld) semiSyncSlaveStatus() (bool, error) {
+	qr, err := mysqld.fetchSuperQuery("SHOW STATUS LIKE 'Rpl_semi_sync_slave_status'")
+	if err != nil {
+		return false, err
+	}
+	if len(qr.Rows) != 1 {
+		// the plugin is not installed
+		return false, nil
+	}
+	return qr.Rows[0][1].String() == "ON", nil
+}
+
+func onOff(on bool) string {
+	if on {
+		return "ON"
+	}
+	return "OFF"
+}
+
 var (
 	ErrNotSlave  = errors.New("no slave status")
 	ErrNotMaster = errors.New("no master status")
@@ -207,12 +321,21 @@ func (mysqld *Mysqld) slaveStatus() (map[string]string, error) {
 		return nil, ErrNotSlave
 	}
 
+	// The columns depend on the flavor and version, so use their names
+	// if we have them.
+	names := showSlaveStatusColumnNames
+	if len(qr.Fields) == len(qr.Rows[0]) {
+		names = make([]string, len(qr.Fields))
+		for i, field := range qr.Fields {
+			names[i] = field.Name
+		}
+	}
 	rowMap := make(map[string]string)
 	for i, column := range qr.Rows[0] {
-		if i >= len(showSlaveStatusColumnNames) {
+		if i >= len(names) {
 			break
 		}
-		rowMap[showSlaveStatusColumnNames[i]] = column.String()
+		rowMap[names[i]] = column.String()
 	}
 	return rowMap, nil
 }
@@ -253,9 +376,56 @@ func (mysqld *Mysqld) ReparentPosition(slavePosition *proto.ReplicationPosition)
 	waitPosition = new(proto.ReplicationPosition)
 	waitPosition.MasterLogFile = file
 	waitPosition.MasterLogPosition = pos
+
+	err = mysqld.checkErrantTransactions(slavePosition)
 	return
 }
 
+// checkErrantTransactions makes sure a slave doesn't have transactions
+// that the master doesn't have, as it would diverge from the master once
+// reparented to it. It's only possible if both have a GTID.
+func (mysqld *Mysqld) checkErrantTransactions(slavePosition *proto.ReplicationPosition) error {
+	if slavePosition.MasterLogGTIDField.Value == nil {
+		return nil
+	}
+	masterPosition, err := mysqld.MasterStatus()
+	if err != nil {
+		return err
+	}
+	if masterPosition.MasterLogGTIDField.Value == nil {
+		return nil
+	}
+	slaveSet, err := proto.ToGTIDSet(slavePosition.MasterLogGTIDField.Value)
+	if err != nil {
+		return err
+	}
+	masterSet, err := proto.ToGTIDSet(masterPosition.MasterLogGTIDField.Value)
+	if err != nil {
+		return err
+	}
+	if masterSet.Contains(slaveSet) {
+		return nil
+	}
+	errant, err := slaveSet.Subtract(masterSet)
+	if err != nil {
+		return fmt.Errorf("slave position %v is not on master position %v", slaveSet, masterSet)
+	}
+	return fmt.Errorf("slave has errant transactions that are not on the master: %v", errant)
+}
+
+// gtidAtLeast returns true if gtid contains all the transactions of target.
+func gtidAtLeast(gtid, target proto.GTID) (bool, error) {
+	set, err := proto.ToGTIDSet(gtid)
+	if err != nil {
+		return false, err
+	}
+	targetSet, err := proto.ToGTIDSet(target)
+	if err != nil {
+		return false, err
+	}
+	return set.AtLeast(targetSet)
+}
+
 func parseReplicationPosition(rpos string) (filename string, pos uint, err error) {
 	parts := strings.Split(rpos, ":")
 	if len(parts) != 2 {
@@ -270,7 +440,14 @@ func parseReplicationPosition(rpos string) (filename string, pos uint, err error
 	return
 }
 
+// WaitMasterPos waits for the slave to reach the position, for at most
+// waitTimeout (0 means forever).
 func (mysqld *Mysqld) WaitMasterPos(rp *proto.ReplicationPosition, waitTimeout time.Duration) error {
+	return mysqld.flavor.WaitMasterPos(mysqld, rp, waitTimeout)
+}
+
+// waitMasterPosFile waits for the slave to reach the file and position of rp.
+func waitMasterPosFile(mysqld *Mysqld, rp *proto.ReplicationPosition, waitTimeout time.Duration) error {
 	var timeToWait int
 	if waitTimeout > 0 {
 		timeToWait = int(waitTimeout / time.Second)
@@ -295,6 +472,8 @@ func (mysqld *Mysqld) WaitMasterPos(rp *proto.ReplicationPosition, waitTimeout t
 	return nil
 }
 
+// WaitForMinimumReplicationPosition waits until the slave has executed
+// all the transactions of targetGTID, for at most waitTimeout.
 func (mysqld *Mysqld) WaitForMinimumReplicationPosition(targetGTID proto.GTID, waitTimeout time.Duration) (err error) {
 	// TODO(enisoc): Use MySQL "wait for gtid" commands instead of comparing GTIDs.
 	for remaining := waitTimeout; remaining > 0; remaining -= time.Second {
@@ -303,11 +482,11 @@ func (mysqld *Mysqld) WaitForMinimumReplicationPosition(targetGTID proto.GTID, w
 			return err
 		}
 
-		cmp, err := pos.MasterLogGTIDField.Value.TryCompare(targetGTID)
+		atLeast, err := gtidAtLeast(pos.MasterLogGTIDField.Value, targetGTID)
 		if err != nil {
 			return err
 		}
-		if cmp >= 0 { // pos.MasterLogGTIDField.Value >= targetGTID
+		if atLeast {
 			return nil
 		}
 
@@ -330,7 +509,7 @@ func (mysqld *Mysqld) SlaveStatus() (*proto.ReplicationPosition, error) {
 	pos.MasterLogPosition = uint(temp)
 	temp, _ = strconv.ParseUint(fields["Read_Master_Log_Pos"], 10, 0)
 	pos.MasterLogPositionIo = uint(temp)
-	pos.MasterLogGTIDField.Value, _ = mysqld.flavor.ParseGTID(fields["Exec_Master_Group_ID"])
+	pos.MasterLogGTIDField.Value, _ = mysqld.flavor.SlaveStatusGTID(fields)
 
 	if fields["Slave_IO_Running"] == "Yes" && fields["Slave_SQL_Running"] == "Yes" {
 		temp, _ = strconv.ParseUint(fields["Seconds_Behind_Master"], 10, 0)
@@ -339,6 +518,9 @@ func (mysqld *Mysqld) SlaveStatus() (*proto.ReplicationPosition, error) {
 		// replications isn't running - report it as invalid since it won't resolve itself.
 		pos.SecondsBehindMaster = proto.InvalidLagSeconds
 	}
+	if pos.SemiSyncAcked, err = mysqld.semiSyncSlaveStatus(); err != nil {
+		return nil, err
+	}
 	return pos, nil
 }
 
@@ -656,6 +838,16 @@ func (mysqld *Mysqld) WaitBlpPos(bp *blproto.BlpPosition, waitTimeout time.Durat
 		if gtid == bp.GTIDField.Value {
 			return nil
 		}
+		if gtid != nil && bp.GTIDField.Value != nil {
+			// The player may have gone past the position already.
+			atLeast, err := gtidAtLeast(gtid, bp.GTIDField.Value)
+			if err != nil {
+				return fmt.Errorf("WaitBlpPos(%v) can't compare positions: %v", bp.Uid, err)
+			}
+			if atLeast {
+				return nil
+			}
+		}
 
 		log.Infof("Sleeping 1 second waiting for binlog replication(%v) to catch up: %v != %v", bp.Uid, gtid, bp.GTIDField)
 		time.Sleep(1 * time.Second)
@@ -28,10 +28,11 @@ const (
 )
 
 type TableColumn struct {
-	Name     string
-	Category int
-	IsAuto   bool
-	Default  sqltypes.Value
+	Name       string
+	Category   int
+	IsAuto     bool
+	IsUnsigned bool
+	Default    sqltypes.Value
 }
 
 type Table struct {
@@ -60,6 +61,7 @@ func (ta *Table) AddColumn(name string, columnType string, defval sqltypes.Value
 	} else {
 		ta.Columns[index].Category = CAT_OTHER
 	}
+	ta.Columns[index].IsUnsigned = strings.Contains(columnType, "unsigned")
 	if extra == "auto_increment" {
 		ta.Columns[index].IsAuto = true
 		// Ignore default value, if any
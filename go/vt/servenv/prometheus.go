@@ -0,0 +1,38 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package servenv
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/stats/prometheus"
+)
+
+var (
+	prometheusPath = flag.String("prometheus_path", "/metrics", "http path to export the stats variables in the Prometheus text format, empty to disable")
+)
+
+func init() {
+	onInit(func() {
+		if *prometheusPath == "" {
+			return
+		}
+		exporter := prometheus.NewExporter()
+		stats.Register(exporter.NewVarHook)
+		http.Handle(*prometheusPath, exporter)
+		// the variables that are not exported because of a metric
+		// name collision are listed in /debug/vars
+		stats.PublishJSONFunc("PrometheusCollisions", func() string {
+			data, err := json.Marshal(exporter.Collisions())
+			if err != nil {
+				return "[]"
+			}
+			return string(data)
+		})
+	})
+}
@@ -0,0 +1,36 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package servenv
+
+import (
+	"flag"
+	"net/http"
+
+	"github.com/youtube/vitess/go/acl"
+	"github.com/youtube/vitess/go/trace"
+)
+
+var (
+	traceSamplingRate  = flag.Float64("trace_sampling_rate", 0, "fraction of the requests to trace, between 0 and 1")
+	traceCollectorSize = flag.Int("trace_collector_size", 100, "number of recent traces displayed on /debug/tracez, 0 to disable")
+)
+
+func init() {
+	onInit(func() {
+		trace.SetSamplingRate(*traceSamplingRate)
+		if *traceCollectorSize <= 0 {
+			return
+		}
+		collector := trace.NewCollector(*traceCollectorSize)
+		trace.RegisterExporter(collector)
+		http.HandleFunc("/debug/tracez", func(w http.ResponseWriter, r *http.Request) {
+			if err := acl.CheckAccessHTTP(r, acl.DEBUGGING); err != nil {
+				acl.SendError(w, err)
+				return
+			}
+			collector.ServeHTTP(w, r)
+		})
+	})
+}
@@ -64,6 +64,10 @@ const (
 	// StartSlave will start MySQL replication.
 	TABLET_ACTION_START_SLAVE = "StartSlave"
 
+	// ApplyRelayLogs will apply the relay logs up to a position,
+	// without starting the MySQL replication IO thread.
+	TABLET_ACTION_APPLY_RELAY_LOGS = "ApplyRelayLogs"
+
 	TABLET_ACTION_BREAK_SLAVES        = "BreakSlaves"
 	TABLET_ACTION_MASTER_POSITION     = "MasterPosition"
 	TABLET_ACTION_REPARENT_POSITION   = "ReparentPosition"
@@ -83,12 +87,16 @@ const (
 	TABLET_ACTION_EXECUTE_HOOK        = "ExecuteHook"
 	TABLET_ACTION_GET_SLAVES          = "GetSlaves"
 
-	TABLET_ACTION_SNAPSHOT            = "Snapshot"
-	TABLET_ACTION_SNAPSHOT_SOURCE_END = "SnapshotSourceEnd"
-	TABLET_ACTION_RESERVE_FOR_RESTORE = "ReserveForRestore"
-	TABLET_ACTION_RESTORE             = "Restore"
-	TABLET_ACTION_MULTI_SNAPSHOT      = "MultiSnapshot"
-	TABLET_ACTION_MULTI_RESTORE       = "MultiRestore"
+	TABLET_ACTION_SNAPSHOT                  = "Snapshot"
+	TABLET_ACTION_SNAPSHOT_SOURCE_END       = "SnapshotSourceEnd"
+	TABLET_ACTION_RESERVE_FOR_RESTORE       = "ReserveForRestore"
+	TABLET_ACTION_RESTORE                   = "Restore"
+	TABLET_ACTION_MULTI_SNAPSHOT            = "MultiSnapshot"
+	TABLET_ACTION_MULTI_RESTORE             = "MultiRestore"
+	TABLET_ACTION_BACKUP                    = "Backup"
+	TABLET_ACTION_RESTORE_FROM_BACKUP       = "RestoreFromBackup"
+	TABLET_ACTION_POINT_IN_TIME_RECOVERY    = "PointInTimeRecovery"
+	TABLET_ACTION_ONLINE_SCHEMA_CHANGE_SYNC = "OnlineSchemaChangeSync"
 
 	//
 	// Shard actions - involve all tablets in a shard.
@@ -97,6 +105,8 @@ const (
 
 	SHARD_ACTION_REPARENT              = "ReparentShard"
 	SHARD_ACTION_EXTERNALLY_REPARENTED = "ShardExternallyReparented"
+	// Promote the most advanced slave when the master is dead
+	SHARD_ACTION_EMERGENCY_REPARENT = "EmergencyReparentShard"
 	// Recompute derived shard-wise data
 	SHARD_ACTION_REBUILD = "RebuildShard"
 	// Generic read lock for inexpensive shard-wide actions.
@@ -215,11 +225,23 @@ func ActionNodeFromJson(data, path string) (*ActionNode, error) {
 		node.Reply = &MultiSnapshotReply{}
 	case TABLET_ACTION_MULTI_RESTORE:
 		node.Args = &MultiRestoreArgs{}
+	case TABLET_ACTION_BACKUP:
+		node.Args = &BackupArgs{}
+	case TABLET_ACTION_RESTORE_FROM_BACKUP:
+		node.Args = &RestoreFromBackupArgs{}
+	case TABLET_ACTION_POINT_IN_TIME_RECOVERY:
+		node.Args = &PointInTimeRecoveryArgs{}
+		node.Reply = &PointInTimeRecoveryReply{}
+	case TABLET_ACTION_ONLINE_SCHEMA_CHANGE_SYNC:
+		node.Args = &OnlineSchemaChangeSyncArgs{}
+		node.Reply = &OnlineSchemaChangeSyncReply{}
 
 	case SHARD_ACTION_REPARENT:
 		node.Args = &topo.TabletAlias{}
 	case SHARD_ACTION_EXTERNALLY_REPARENTED:
 		node.Args = &topo.TabletAlias{}
+	case SHARD_ACTION_EMERGENCY_REPARENT:
+		node.Args = &EmergencyReparentShardArgs{}
 	case SHARD_ACTION_REBUILD:
 	case SHARD_ACTION_CHECK:
 	case SHARD_ACTION_APPLY_SCHEMA:
@@ -247,7 +269,7 @@ func ActionNodeFromJson(data, path string) (*ActionNode, error) {
 		TABLET_ACTION_SLAVE_POSITION, TABLET_ACTION_WAIT_SLAVE_POSITION,
 		TABLET_ACTION_MASTER_POSITION, TABLET_ACTION_STOP_SLAVE,
 		TABLET_ACTION_STOP_SLAVE_MINIMUM, TABLET_ACTION_START_SLAVE,
-		TABLET_ACTION_GET_SLAVES, TABLET_ACTION_WAIT_BLP_POSITION,
+		TABLET_ACTION_APPLY_RELAY_LOGS, TABLET_ACTION_GET_SLAVES, TABLET_ACTION_WAIT_BLP_POSITION,
 		TABLET_ACTION_STOP_BLP, TABLET_ACTION_START_BLP,
 		TABLET_ACTION_RUN_BLP_UNTIL:
 		return nil, fmt.Errorf("rpc-only action: %v", node.Action)
@@ -6,6 +6,7 @@ package actionnode
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/youtube/vitess/go/vt/key"
 	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
@@ -97,6 +98,65 @@ type RestoreArgs struct {
 	DontWaitForSlaveStart bool
 }
 
+type BackupArgs struct {
+	Concurrency int
+}
+
+type RestoreFromBackupArgs struct {
+	Keyspace              string
+	Shard                 string
+	Concurrency           int
+	DontWaitForSlaveStart bool
+}
+
+// PointInTimeRecoveryArgs describes the recovery target: StopAtGTID if
+// set, or else the last transaction at or before StopAtTimestamp (in
+// seconds since the epoch). The binlogs are streamed from
+// SourceTabletAlias. SourceGTID is the position of the source when the
+// recovery started, if StopAtTimestamp had passed by then: the replay
+// is over once it reaches it.
+type PointInTimeRecoveryArgs struct {
+	Keyspace          string
+	Shard             string
+	SourceTabletAlias topo.TabletAlias
+	Concurrency       int
+	StopAtGTID        myproto.GTIDField
+	StopAtTimestamp   int64
+	SourceGTID        myproto.GTIDField
+	WaitTime          time.Duration
+}
+
+type PointInTimeRecoveryReply struct {
+	ReplicationPosition myproto.ReplicationPosition
+}
+
+// OnlineSchemaChangeComment prefixes the statements an online schema
+// change runs on the shadow table, so they can be told apart from
+// the application writes when reading the binlogs.
+const OnlineSchemaChangeComment = "/* online_schema_change */ "
+
+// OnlineSchemaChangeSyncArgs describes a pass of an online schema
+// change on a master: the rows of Table changed since StartGTID are
+// copied again into ShadowTable. If Cutover is set, the writes to
+// Table are blocked during the pass, and at the end ShadowTable is
+// renamed to Table, and Table to OldTable.
+type OnlineSchemaChangeSyncArgs struct {
+	Table       string
+	ShadowTable string
+	OldTable    string
+	Columns     []string
+	StartGTID   myproto.GTIDField
+	Cutover     bool
+	WaitTime    time.Duration
+}
+
+// OnlineSchemaChangeSyncReply has the position the pass synced up
+// to, and how many rows it copied again.
+type OnlineSchemaChangeSyncReply struct {
+	GTIDField myproto.GTIDField
+	RowCount  int
+}
+
 // shard action node structures
 
 type ApplySchemaShardArgs struct {
@@ -105,6 +165,10 @@ type ApplySchemaShardArgs struct {
 	Simple            bool
 }
 
+type EmergencyReparentShardArgs struct {
+	PreferredCell string
+}
+
 type SetShardServedTypesArgs struct {
 	ServedTypes []topo.TabletType
 }
@@ -140,6 +204,15 @@ func ShardExternallyReparented(tabletAlias topo.TabletAlias) *ActionNode {
 	}).SetGuid()
 }
 
+func EmergencyReparentShard(preferredCell string) *ActionNode {
+	return (&ActionNode{
+		Action: SHARD_ACTION_EMERGENCY_REPARENT,
+		Args: &EmergencyReparentShardArgs{
+			PreferredCell: preferredCell,
+		},
+	}).SetGuid()
+}
+
 func RebuildShard() *ActionNode {
 	return (&ActionNode{
 		Action: SHARD_ACTION_REBUILD,
@@ -11,8 +11,10 @@ remotely on a tablet. These actions can be executed as:
 package actor
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -25,12 +27,18 @@ import (
 	"time"
 
 	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/sqltypes"
 	"github.com/youtube/vitess/go/tb"
+	"github.com/youtube/vitess/go/vt/binlog"
+	"github.com/youtube/vitess/go/vt/binlog/binlogplayer"
+	blproto "github.com/youtube/vitess/go/vt/binlog/proto"
 	"github.com/youtube/vitess/go/vt/concurrency"
+	"github.com/youtube/vitess/go/vt/dbconnpool"
 	"github.com/youtube/vitess/go/vt/hook"
 	"github.com/youtube/vitess/go/vt/key"
 	"github.com/youtube/vitess/go/vt/mysqlctl"
 	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/sqlparser"
 	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
 	"github.com/youtube/vitess/go/vt/tabletmanager/initiator"
 	"github.com/youtube/vitess/go/vt/topo"
@@ -178,6 +186,14 @@ func (ta *TabletActor) dispatchAction(actionNode *actionnode.ActionNode) (err er
 		err = ta.multiSnapshot(actionNode)
 	case actionnode.TABLET_ACTION_MULTI_RESTORE:
 		err = ta.multiRestore(actionNode)
+	case actionnode.TABLET_ACTION_BACKUP:
+		err = ta.backup(actionNode)
+	case actionnode.TABLET_ACTION_RESTORE_FROM_BACKUP:
+		err = ta.restoreFromBackup(actionNode)
+	case actionnode.TABLET_ACTION_POINT_IN_TIME_RECOVERY:
+		err = ta.pointInTimeRecovery(actionNode)
+	case actionnode.TABLET_ACTION_ONLINE_SCHEMA_CHANGE_SYNC:
+		err = ta.onlineSchemaChangeSync(actionNode)
 	case actionnode.TABLET_ACTION_PING:
 		// Just an end-to-end verification that we got the message.
 		err = nil
@@ -224,6 +240,7 @@ func (ta *TabletActor) dispatchAction(actionNode *actionnode.ActionNode) (err er
 		actionnode.TABLET_ACTION_STOP_SLAVE,
 		actionnode.TABLET_ACTION_STOP_SLAVE_MINIMUM,
 		actionnode.TABLET_ACTION_START_SLAVE,
+		actionnode.TABLET_ACTION_APPLY_RELAY_LOGS,
 		actionnode.TABLET_ACTION_GET_SLAVES,
 		actionnode.TABLET_ACTION_WAIT_BLP_POSITION,
 		actionnode.TABLET_ACTION_STOP_BLP,
@@ -263,7 +280,7 @@ func (ta *TabletActor) sleep(actionNode *actionnode.ActionNode) error {
 }
 
 func (ta *TabletActor) setReadOnly(rdonly bool) error {
-	err := ta.mysqld.SetReadOnly(rdonly)
+	err := ta.mysqlDaemon.SetReadOnly(rdonly)
 	if err != nil {
 		return err
 	}
@@ -310,7 +327,7 @@ func (ta *TabletActor) promoteSlave(actionNode *actionnode.ActionNode) error {
 
 	// Perform the action.
 	rsd := &actionnode.RestartSlaveData{Parent: tablet.Alias, Force: (tablet.Parent.Uid == topo.NO_TABLET)}
-	rsd.ReplicationState, rsd.WaitPosition, rsd.TimePromoted, err = ta.mysqld.PromoteSlave(false, ta.hookExtraEnv())
+	rsd.ReplicationState, rsd.WaitPosition, rsd.TimePromoted, err = ta.mysqlDaemon.PromoteSlave(false, ta.hookExtraEnv())
 	if err != nil {
 		return err
 	}
@@ -403,7 +420,7 @@ func (ta *TabletActor) restartSlave(actionNode *actionnode.ActionNode) error {
 		if tablet.Type == topo.TYPE_LAG {
 			tablet.Type = topo.TYPE_LAG_ORPHAN
 		} else {
-			err = ta.mysqld.RestartSlave(rsd.ReplicationState, rsd.WaitPosition, rsd.TimePromoted)
+			err = ta.mysqlDaemon.RestartSlave(rsd.ReplicationState, rsd.WaitPosition, rsd.TimePromoted)
 			if err != nil {
 				return err
 			}
@@ -415,7 +432,7 @@ func (ta *TabletActor) restartSlave(actionNode *actionnode.ActionNode) error {
 			return err
 		}
 	} else if rsd.Force {
-		err = ta.mysqld.RestartSlave(rsd.ReplicationState, rsd.WaitPosition, rsd.TimePromoted)
+		err = ta.mysqlDaemon.RestartSlave(rsd.ReplicationState, rsd.WaitPosition, rsd.TimePromoted)
 		if err != nil {
 			return err
 		}
@@ -728,6 +745,529 @@ func (ta *TabletActor) restore(actionNode *actionnode.ActionNode) error {
 	return topotools.ChangeType(ta.ts, ta.tabletAlias, topo.TYPE_SPARE, nil, true)
 }
 
+// Operate on a backup tablet. Shutdown mysqld, store the data files
+// in the backup storage, and restart mysqld.
+func (ta *TabletActor) backup(actionNode *actionnode.ActionNode) error {
+	args := actionNode.Args.(*actionnode.BackupArgs)
+
+	tablet, err := ta.ts.GetTablet(ta.tabletAlias)
+	if err != nil {
+		return err
+	}
+
+	if tablet.Type != topo.TYPE_BACKUP {
+		return fmt.Errorf("expected backup type, not %v: %v", tablet.Type, ta.tabletAlias)
+	}
+
+	bucket := mysqlctl.BackupBucket(tablet.Keyspace, tablet.Shard)
+	name := fmt.Sprintf("%v.%v", time.Now().UTC().Format("2006-01-02.150405"), tablet.Alias)
+	return ta.mysqld.Backup(bucket, name, args.Concurrency, ta.hookExtraEnv())
+}
+
+// Operate on an idle tablet.
+// Find the shard master, restore the latest backup of the shard,
+// restart mysqld and replication.
+// Put tablet into the replication graph as a spare.
+func (ta *TabletActor) restoreFromBackup(actionNode *actionnode.ActionNode) error {
+	args := actionNode.Args.(*actionnode.RestoreFromBackupArgs)
+
+	// read our current tablet, verify its state
+	tablet, err := ta.ts.GetTablet(ta.tabletAlias)
+	if err != nil {
+		return err
+	}
+	if tablet.Type != topo.TYPE_IDLE {
+		return fmt.Errorf("expected idle type, not %v: %v", tablet.Type, ta.tabletAlias)
+	}
+
+	// read the shard master, verify its state
+	si, err := ta.ts.GetShard(args.Keyspace, args.Shard)
+	if err != nil {
+		return err
+	}
+	if si.MasterAlias.IsZero() {
+		return fmt.Errorf("no master in shard %v/%v", args.Keyspace, args.Shard)
+	}
+	masterTablet, err := ta.ts.GetTablet(si.MasterAlias)
+	if err != nil {
+		return err
+	}
+	if masterTablet.Type != topo.TYPE_MASTER {
+		return fmt.Errorf("restore expected master parent: %v %v", masterTablet.Type, si.MasterAlias)
+	}
+
+	if err := ta.changeTypeToRestore(tablet, masterTablet, masterTablet.Alias, masterTablet.KeyRange); err != nil {
+		return err
+	}
+
+	// do the work
+	bucket := mysqlctl.BackupBucket(args.Keyspace, args.Shard)
+	if err := ta.mysqld.RestoreFromBackup(bucket, args.Concurrency, masterTablet.MysqlIpAddr(), args.DontWaitForSlaveStart, ta.hookExtraEnv()); err != nil {
+		log.Errorf("RestoreFromBackup failed (%v), scrapping", err)
+		if err := topotools.Scrap(ta.ts, ta.tabletAlias, false); err != nil {
+			log.Errorf("Failed to Scrap after failed RestoreFromBackup: %v", err)
+		}
+
+		return err
+	}
+
+	// change to TYPE_SPARE, we're done!
+	return topotools.ChangeType(ta.ts, ta.tabletAlias, topo.TYPE_SPARE, nil, true)
+}
+
+// Operate on an idle tablet.
+// Restore the latest backup of the shard taken before the recovery
+// target, and replay the binlogs of the source tablet on top of it up
+// to the target. The tablet stays in the restore type: it is neither
+// replicating nor serving, so the recovered data can be inspected and
+// copied back.
+func (ta *TabletActor) pointInTimeRecovery(actionNode *actionnode.ActionNode) error {
+	args := actionNode.Args.(*actionnode.PointInTimeRecoveryArgs)
+
+	// read our current tablet, verify its state
+	tablet, err := ta.ts.GetTablet(ta.tabletAlias)
+	if err != nil {
+		return err
+	}
+	if tablet.Type != topo.TYPE_IDLE {
+		return fmt.Errorf("expected idle type, not %v: %v", tablet.Type, ta.tabletAlias)
+	}
+
+	// read the shard and the source tablet
+	si, err := ta.ts.GetShard(args.Keyspace, args.Shard)
+	if err != nil {
+		return err
+	}
+	ki, err := ta.ts.GetKeyspace(args.Keyspace)
+	if err != nil {
+		return err
+	}
+	sourceTablet, err := ta.ts.GetTablet(args.SourceTabletAlias)
+	if err != nil {
+		return err
+	}
+	if sourceTablet.Keyspace != args.Keyspace || sourceTablet.Shard != args.Shard {
+		return fmt.Errorf("source tablet %v is not in shard %v/%v", args.SourceTabletAlias, args.Keyspace, args.Shard)
+	}
+
+	if err := ta.changeTypeToRestore(tablet, sourceTablet, si.MasterAlias, sourceTablet.KeyRange); err != nil {
+		return err
+	}
+
+	// restore the backup
+	bucket := mysqlctl.BackupBucket(args.Keyspace, args.Shard)
+	pos, err := ta.mysqld.RestoreFromBackupForRecovery(bucket, args.Concurrency, args.StopAtGTID.Value, args.StopAtTimestamp, ta.hookExtraEnv())
+	if err != nil {
+		log.Errorf("RestoreFromBackupForRecovery failed (%v), scrapping", err)
+		if err := topotools.Scrap(ta.ts, ta.tabletAlias, false); err != nil {
+			log.Errorf("Failed to Scrap after failed RestoreFromBackupForRecovery: %v", err)
+		}
+		return err
+	}
+
+	// and replay the binlogs
+	gtid, err := ta.replayBinlogs(tablet, ki, sourceTablet, pos.MasterLogGTIDField.Value, args)
+	if err != nil {
+		return err
+	}
+	reply := &actionnode.PointInTimeRecoveryReply{}
+	reply.ReplicationPosition.MasterLogGTIDField.Value = gtid
+	actionNode.Reply = reply
+	return nil
+}
+
+// replayBinlogs applies the binlogs of sourceTablet from startGTID up
+// to the recovery target, and returns the GTID of the last applied
+// transaction.
+func (ta *TabletActor) replayBinlogs(tablet *topo.TabletInfo, ki *topo.KeyspaceInfo, sourceTablet *topo.TabletInfo, startGTID myproto.GTID, args *actionnode.PointInTimeRecoveryArgs) (myproto.GTID, error) {
+	vtClient := binlogplayer.NewDbClient(ta.mysqld.DbaConnectionParams(tablet.DbName()))
+	if err := vtClient.Connect(); err != nil {
+		return nil, fmt.Errorf("can't connect to database: %v", err)
+	}
+	defer vtClient.Close()
+
+	// the player saves its position in the blp_checkpoint table,
+	// under a reserved uid
+	uid := binlogplayer.BLP_POINT_IN_TIME_RECOVERY_UID
+	queries := binlogplayer.CreateBlpCheckpoint()
+	queries = append(queries,
+		fmt.Sprintf("DELETE FROM _vt.blp_checkpoint WHERE source_shard_uid=%v", uid),
+		binlogplayer.PopulateBlpCheckpoint(uid, startGTID, time.Now().Unix(), ""))
+	if err := ta.mysqld.ExecuteSuperQueryList(queries); err != nil {
+		return nil, err
+	}
+	startPosition, _, err := binlogplayer.ReadStartPosition(vtClient, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	// sharded keyspaces replay the shard key range, the others
+	// replay all the tables
+	stats := binlogplayer.NewBinlogPlayerStats()
+	var player *binlogplayer.BinlogPlayer
+	if ki.ShardingColumnName != "" {
+		player = binlogplayer.NewBinlogPlayerKeyRange(vtClient, sourceTablet.Addr(), ki.ShardingColumnType, sourceTablet.KeyRange, ki.ShardingColumnName, startPosition, args.StopAtGTID.Value, stats)
+	} else {
+		tables, err := ta.mysqld.ResolveTables(tablet.DbName(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve table names: %v", err)
+		}
+		player = binlogplayer.NewBinlogPlayerTables(vtClient, sourceTablet.Addr(), tables, startPosition, args.StopAtGTID.Value, stats)
+	}
+	if args.StopAtGTID.Value == nil {
+		player.StopAtTimestamp(args.StopAtTimestamp, args.SourceGTID.Value)
+	}
+
+	// the player stops when it reaches the target, or when interrupted
+	interrupted := make(chan struct{})
+	timer := time.AfterFunc(args.WaitTime, func() { close(interrupted) })
+	err = player.ApplyBinlogEvents(interrupted)
+	if !timer.Stop() {
+		return nil, fmt.Errorf("recovery target not reached after %v", args.WaitTime)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	gtid := stats.GetLastGTID()
+	if gtid == nil {
+		gtid = startGTID
+	}
+	log.Infof("point in time recovery reached %v", gtid)
+	return gtid, nil
+}
+
+// onlineSchemaChangeSync copies again into the shadow table of an
+// online schema change the rows of the table that changed since the
+// start position, and swaps the tables if asked to.
+func (ta *TabletActor) onlineSchemaChangeSync(actionNode *actionnode.ActionNode) error {
+	args := actionNode.Args.(*actionnode.OnlineSchemaChangeSyncArgs)
+
+	tablet, err := ta.ts.GetTablet(ta.tabletAlias)
+	if err != nil {
+		return err
+	}
+	if tablet.Type != topo.TYPE_MASTER {
+		return fmt.Errorf("expected master type, not %v: %v", tablet.Type, ta.tabletAlias)
+	}
+	if args.StartGTID.Value == nil {
+		return fmt.Errorf("OnlineSchemaChangeSync needs a start position")
+	}
+
+	conn, err := ta.mysqlDaemon.GetDbaConnection()
+	if err != nil {
+		return err
+	}
+	defer conn.Recycle()
+
+	reply := &actionnode.OnlineSchemaChangeSyncReply{}
+	if args.Cutover {
+		err = ta.onlineSchemaChangeCutover(conn, tablet.DbName(), args, reply)
+	} else {
+		var pos *myproto.ReplicationPosition
+		if pos, err = ta.mysqlDaemon.MasterStatus(); err == nil {
+			reply.GTIDField = pos.MasterLogGTIDField
+			reply.RowCount, err = ta.resyncShadowTable(conn, tablet.DbName(), args, pos.MasterLogGTIDField.Value)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	actionNode.Reply = reply
+	return nil
+}
+
+// onlineSchemaChangeCutover swaps the shadow table with the original
+// table. The tables are locked while the last changes are synced, and
+// a RENAME TABLE from another connection waits on the lock. A sentry
+// table with the name of the old table is dropped just before the
+// tables are unlocked: if the lock is released any other way, for
+// instance because this process died, the RENAME fails on it.
+func (ta *TabletActor) onlineSchemaChangeCutover(conn dbconnpool.PoolConnection, dbName string, args *actionnode.OnlineSchemaChangeSyncArgs, reply *actionnode.OnlineSchemaChangeSyncReply) error {
+	if _, err := conn.ExecuteFetch(fmt.Sprintf("CREATE TABLE %v.%v (id INT)", dbName, args.OldTable), 0, false); err != nil {
+		return fmt.Errorf("cannot create sentry table: %v", err)
+	}
+	if _, err := conn.ExecuteFetch(fmt.Sprintf("LOCK TABLES %v.%v WRITE, %v.%v WRITE, %v.%v WRITE", dbName, args.Table, dbName, args.ShadowTable, dbName, args.OldTable), 0, false); err != nil {
+		ta.dropSentryTable(conn, dbName, args.OldTable)
+		return fmt.Errorf("cannot lock tables: %v", err)
+	}
+
+	// no write can happen to the table any more, so the current
+	// position is the last one to sync
+	pos, err := ta.mysqlDaemon.MasterStatus()
+	if err != nil {
+		ta.unlockTablesWithSentry(conn, dbName, args.OldTable, nil)
+		return err
+	}
+	reply.GTIDField = pos.MasterLogGTIDField
+
+	renameConn, err := ta.mysqlDaemon.GetDbaConnection()
+	if err != nil {
+		ta.unlockTablesWithSentry(conn, dbName, args.OldTable, nil)
+		return err
+	}
+	defer renameConn.Recycle()
+	renameErr := make(chan error, 1)
+	go func() {
+		_, err := renameConn.ExecuteFetch(fmt.Sprintf("RENAME TABLE %v.%v TO %v.%v, %v.%v TO %v.%v", dbName, args.Table, dbName, args.OldTable, dbName, args.ShadowTable, dbName, args.Table), 0, false)
+		renameErr <- err
+	}()
+	if err := ta.waitForBlockedRename(renameConn.Id(), renameErr); err != nil {
+		ta.unlockTablesWithSentry(conn, dbName, args.OldTable, renameErr)
+		return err
+	}
+
+	reply.RowCount, err = ta.resyncShadowTable(conn, dbName, args, pos.MasterLogGTIDField.Value)
+	if err != nil {
+		ta.unlockTablesWithSentry(conn, dbName, args.OldTable, renameErr)
+		return err
+	}
+
+	// let the rename go through
+	if _, err := conn.ExecuteFetch(fmt.Sprintf("DROP TABLE %v.%v", dbName, args.OldTable), 0, false); err != nil {
+		ta.unlockTablesWithSentry(conn, dbName, args.OldTable, renameErr)
+		return fmt.Errorf("cannot drop sentry table: %v", err)
+	}
+	if _, err := conn.ExecuteFetch("UNLOCK TABLES", 0, false); err != nil {
+		log.Errorf("UNLOCK TABLES failed, the connection will be closed: %v", err)
+		conn.Close()
+	}
+	if err := <-renameErr; err != nil {
+		return fmt.Errorf("cannot rename tables: %v", err)
+	}
+	log.Infof("online schema change of %v.%v done at %v", dbName, args.Table, pos.MasterLogGTIDField)
+	return nil
+}
+
+// waitForBlockedRename waits until the RENAME TABLE of the cutover
+// is waiting on the lock of the tables.
+func (ta *TabletActor) waitForBlockedRename(connectionId int64, renameErr chan error) error {
+	conn, err := ta.mysqlDaemon.GetDbaConnection()
+	if err != nil {
+		return err
+	}
+	defer conn.Recycle()
+
+	query := fmt.Sprintf("SELECT COUNT(*) FROM information_schema.processlist WHERE id=%v AND info LIKE 'RENAME TABLE %%'", connectionId)
+	for i := 0; i < 100; i++ {
+		select {
+		case err := <-renameErr:
+			renameErr <- err
+			return fmt.Errorf("rename didn't wait on the lock: %v", err)
+		default:
+		}
+		qr, err := conn.ExecuteFetch(query, 1, false)
+		if err != nil {
+			return err
+		}
+		if len(qr.Rows) == 1 && qr.Rows[0][0].String() == "1" {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("rename is not waiting on the lock")
+}
+
+// unlockTablesWithSentry aborts a cutover: the tables are unlocked
+// with the sentry table still there, so a pending rename fails, and
+// the sentry is dropped after that.
+func (ta *TabletActor) unlockTablesWithSentry(conn dbconnpool.PoolConnection, dbName, sentryTable string, renameErr chan error) {
+	if _, err := conn.ExecuteFetch("UNLOCK TABLES", 0, false); err != nil {
+		// closing the connection releases the lock too
+		log.Errorf("UNLOCK TABLES failed, the connection will be closed: %v", err)
+		conn.Close()
+		if conn, err = ta.mysqlDaemon.GetDbaConnection(); err != nil {
+			log.Errorf("cannot drop sentry table %v.%v, it has to be dropped by hand: %v", dbName, sentryTable, err)
+			return
+		}
+		defer conn.Recycle()
+	}
+	if renameErr != nil {
+		if err := <-renameErr; err == nil {
+			// the sentry should have prevented that
+			log.Errorf("tables were renamed on aborted cutover")
+			return
+		}
+	}
+	ta.dropSentryTable(conn, dbName, sentryTable)
+}
+
+func (ta *TabletActor) dropSentryTable(conn dbconnpool.PoolConnection, dbName, sentryTable string) {
+	if _, err := conn.ExecuteFetch(fmt.Sprintf("DROP TABLE %v.%v", dbName, sentryTable), 0, false); err != nil {
+		log.Errorf("cannot drop sentry table %v.%v, it has to be dropped by hand: %v", dbName, sentryTable, err)
+	}
+}
+
+// onlineSchemaChangeBatchSize is the number of rows copied again into
+// the shadow table by each statement.
+const onlineSchemaChangeBatchSize = 100
+
+// resyncShadowTable copies again into the shadow table the rows of the
+// table changed between the start position and target. It returns
+// the number of rows copied.
+func (ta *TabletActor) resyncShadowTable(conn dbconnpool.PoolConnection, dbName string, args *actionnode.OnlineSchemaChangeSyncArgs, target myproto.GTID) (int, error) {
+	conditions, err := ta.changedRows(dbName, args, target)
+	if err != nil {
+		return 0, err
+	}
+	columns := strings.Join(args.Columns, ", ")
+	for i := 0; i < len(conditions); i += onlineSchemaChangeBatchSize {
+		end := i + onlineSchemaChangeBatchSize
+		if end > len(conditions) {
+			end = len(conditions)
+		}
+		where := strings.Join(conditions[i:end], " OR ")
+		queries := []string{
+			fmt.Sprintf("%vDELETE FROM %v.%v WHERE %v", actionnode.OnlineSchemaChangeComment, dbName, args.ShadowTable, where),
+			fmt.Sprintf("%vINSERT INTO %v.%v (%v) SELECT %v FROM %v.%v WHERE %v", actionnode.OnlineSchemaChangeComment, dbName, args.ShadowTable, columns, columns, dbName, args.Table, where),
+		}
+		for _, query := range queries {
+			if _, err := conn.ExecuteFetch(query, 0, false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(conditions), nil
+}
+
+// changedRows streams the binlogs from the start position up to
+// target, and returns a condition on the primary key of every row of
+// the table that changed. Statements on the table that don't say
+// which rows they change make it fail.
+func (ta *TabletActor) changedRows(dbName string, args *actionnode.OnlineSchemaChangeSyncArgs, target myproto.GTID) ([]string, error) {
+	targetSet, err := myproto.ToGTIDSet(target)
+	if err != nil {
+		return nil, err
+	}
+	startSet, err := myproto.ToGTIDSet(args.StartGTID.Value)
+	if err != nil {
+		return nil, err
+	}
+	reached, err := startSet.AtLeast(targetSet)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compare position %v with start position %v: %v", target, args.StartGTID.Value, err)
+	}
+	if reached {
+		return nil, nil
+	}
+
+	filter := newChangedRowsFilter(args.Table, targetSet)
+	evs := binlog.NewEventStreamer(dbName, ta.mysqld)
+	timer := time.AfterFunc(args.WaitTime, evs.Stop)
+	defer timer.Stop()
+	err = evs.Stream(args.StartGTID.Value, filter.sendEvent)
+	if filter.reached {
+		return filter.conditions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("position %v not reached after %v", target, args.WaitTime)
+}
+
+// changedRowsFilter collects the primary key conditions of the rows
+// of a table changed by the streamed events, until the target
+// position is reached.
+type changedRowsFilter struct {
+	table      string
+	target     myproto.GTIDSet
+	conditions []string
+	seen       map[string]bool
+	reached    bool
+}
+
+func newChangedRowsFilter(table string, target myproto.GTIDSet) *changedRowsFilter {
+	return &changedRowsFilter{
+		table:  table,
+		target: target,
+		seen:   make(map[string]bool),
+	}
+}
+
+// sendEvent is the callback of the event streamer. It returns io.EOF
+// once the target is reached, and an error if the positions of the
+// events can't be compared with it.
+func (f *changedRowsFilter) sendEvent(event *blproto.StreamEvent) error {
+	switch event.Category {
+	case "DML":
+		if event.TableName != f.table {
+			return nil
+		}
+		for _, pkValues := range event.PKValues {
+			condition, err := pkCondition(event.PKColNames, pkValues)
+			if err != nil {
+				return err
+			}
+			if !f.seen[condition] {
+				f.seen[condition] = true
+				f.conditions = append(f.conditions, condition)
+			}
+		}
+	case "DDL", "ERR":
+		if !strings.HasPrefix(event.Sql, actionnode.OnlineSchemaChangeComment) && referencesTable(event.Sql, f.table) {
+			return fmt.Errorf("cannot sync statement on table %v: %v", f.table, event.Sql)
+		}
+	case "POS":
+		pos, err := myproto.ToGTIDSet(event.GTIDField.Value)
+		if err != nil {
+			return err
+		}
+		reached, err := pos.AtLeast(f.target)
+		if err != nil {
+			return fmt.Errorf("cannot compare position %v with target %v: %v", pos, f.target, err)
+		}
+		if reached {
+			f.reached = true
+			return io.EOF
+		}
+	}
+	return nil
+}
+
+// pkCondition returns the condition matching the primary key values
+// of a row.
+func pkCondition(pkColNames []string, pkValues []interface{}) (string, error) {
+	if len(pkColNames) != len(pkValues) {
+		return "", fmt.Errorf("got %v primary key values for columns %v", len(pkValues), pkColNames)
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteString("(")
+	for i, pkColName := range pkColNames {
+		if i > 0 {
+			buf.WriteString(" AND ")
+		}
+		value, err := sqltypes.BuildValue(pkValues[i])
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(pkColName)
+		buf.WriteString("=")
+		value.EncodeSql(buf)
+	}
+	buf.WriteString(")")
+	return buf.String(), nil
+}
+
+// referencesTable returns true if the table name is an identifier of
+// the statement. Statements that can't be tokenized are assumed to
+// reference it if they contain its name.
+func referencesTable(sql, table string) bool {
+	tokenizer := sqlparser.NewStringTokenizer(sql)
+	for {
+		typ, val := tokenizer.Scan()
+		switch typ {
+		case 0:
+			return false
+		case sqlparser.LEX_ERROR:
+			return strings.Contains(sql, table)
+		case sqlparser.ID:
+			if string(val) == table {
+				return true
+			}
+		}
+	}
+}
+
 func (ta *TabletActor) multiSnapshot(actionNode *actionnode.ActionNode) error {
 	args := actionNode.Args.(*actionnode.MultiSnapshotArgs)
 
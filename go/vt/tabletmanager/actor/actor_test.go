@@ -0,0 +1,363 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package actor
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	blproto "github.com/youtube/vitess/go/vt/binlog/proto"
+	"github.com/youtube/vitess/go/vt/mysqlctl"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+func TestPkCondition(t *testing.T) {
+	got, err := pkCondition([]string{"id", "name"}, []interface{}{int64(12), "a'b"})
+	if err != nil {
+		t.Fatalf("pkCondition failed: %v", err)
+	}
+	if want := "(id=12 AND name='a\\'b')"; got != want {
+		t.Errorf("pkCondition = %v, want %v", got, want)
+	}
+
+	if _, err := pkCondition([]string{"id", "name"}, []interface{}{int64(12)}); err == nil {
+		t.Errorf("pkCondition should fail with a missing value")
+	}
+	if _, err := pkCondition([]string{"id"}, []interface{}{struct{}{}}); err == nil {
+		t.Errorf("pkCondition should fail with an unsupported value")
+	}
+}
+
+func TestReferencesTable(t *testing.T) {
+	testcases := []struct {
+		sql  string
+		want bool
+	}{
+		{"ALTER TABLE users ADD COLUMN c INT", true},
+		{"alter table `users` add column c int", true},
+		{"RENAME TABLE orders TO users2", false},
+		{"ALTER TABLE _users_osc ADD COLUMN c INT", false},
+		{"INSERT INTO orders VALUES ('users')", false},
+		{"ALTER TABLE users COMMENT 'unterminated", true},
+		{"ALTER TABLE orders COMMENT 'unterminated", false},
+	}
+	for _, tc := range testcases {
+		if got := referencesTable(tc.sql, "users"); got != tc.want {
+			t.Errorf("referencesTable(%q) = %v, want %v", tc.sql, got, tc.want)
+		}
+	}
+}
+
+func TestChangedRowsFilter(t *testing.T) {
+	dml := func(table string, ids ...int64) *blproto.StreamEvent {
+		event := &blproto.StreamEvent{Category: "DML", TableName: table, PKColNames: []string{"id"}}
+		for _, id := range ids {
+			event.PKValues = append(event.PKValues, []interface{}{id})
+		}
+		return event
+	}
+	pos := func(groupID uint64) *blproto.StreamEvent {
+		return &blproto.StreamEvent{Category: "POS", GTIDField: myproto.GTIDField{Value: myproto.GoogleGTID{GroupID: groupID}}}
+	}
+
+	filter := newChangedRowsFilter("t", myproto.GoogleGTID{GroupID: 12})
+	events := []*blproto.StreamEvent{
+		dml("t", 1, 2),
+		dml("t2", 3),
+		&blproto.StreamEvent{Category: "DDL", Sql: "ALTER TABLE t2 ADD COLUMN c INT"},
+		&blproto.StreamEvent{Category: "DDL", Sql: actionnode.OnlineSchemaChangeComment + "ALTER TABLE t ADD COLUMN c INT"},
+		pos(11),
+		dml("t", 2, 4),
+	}
+	for _, event := range events {
+		if err := filter.sendEvent(event); err != nil {
+			t.Fatalf("sendEvent(%#v) failed: %v", event, err)
+		}
+	}
+	if filter.reached {
+		t.Errorf("target reached too early")
+	}
+	if err := filter.sendEvent(pos(12)); err != io.EOF {
+		t.Errorf("sendEvent on the target position returned %v, want io.EOF", err)
+	}
+	if !filter.reached {
+		t.Errorf("target not reached")
+	}
+	want := []string{"(id=1)", "(id=2)", "(id=4)"}
+	if !reflect.DeepEqual(filter.conditions, want) {
+		t.Errorf("conditions = %v, want %v", filter.conditions, want)
+	}
+
+	// statements on the table that don't say which rows they change
+	for _, event := range []*blproto.StreamEvent{
+		&blproto.StreamEvent{Category: "DDL", Sql: "ALTER TABLE t ADD COLUMN c INT"},
+		&blproto.StreamEvent{Category: "ERR", Sql: "UPDATE t SET c=1 WHERE c=2 LIMIT 1"},
+	} {
+		filter := newChangedRowsFilter("t", myproto.GoogleGTID{GroupID: 12})
+		if err := filter.sendEvent(event); err == nil {
+			t.Errorf("sendEvent(%#v) should have failed", event)
+		}
+	}
+
+	// a position of another flavor can't be compared with the target
+	filter = newChangedRowsFilter("t", myproto.GoogleGTID{GroupID: 12})
+	event := &blproto.StreamEvent{Category: "POS", GTIDField: myproto.GTIDField{Value: myproto.MariadbGTID{Domain: 0, Server: 1, Sequence: 12}}}
+	if err := filter.sendEvent(event); err == nil || err == io.EOF {
+		t.Errorf("sendEvent(%#v) returned %v, want an error", event, err)
+	}
+}
+
+func TestChangedRowsFilterMysql56(t *testing.T) {
+	const sid1 = "00010203-0405-0607-0809-0a0b0c0d0e0f"
+	const sid2 = "00010203-0405-0607-0809-0a0b0c0d0e10"
+	set := func(s string) myproto.GTIDSet {
+		return myproto.MustParseGTID("MySQL56", s).(myproto.GTIDSet)
+	}
+	pos := func(s string) *blproto.StreamEvent {
+		return &blproto.StreamEvent{Category: "POS", GTIDField: myproto.GTIDField{Value: set(s)}}
+	}
+
+	// the positions have transactions of another server the target
+	// doesn't have, they are reached once they contain it
+	filter := newChangedRowsFilter("t", set(sid1+":1-5"))
+	if err := filter.sendEvent(pos(sid1 + ":1-4," + sid2 + ":1-3")); err != nil {
+		t.Fatalf("sendEvent before the target failed: %v", err)
+	}
+	if filter.reached {
+		t.Errorf("target reached too early")
+	}
+	if err := filter.sendEvent(pos(sid1 + ":1-5," + sid2 + ":1-3")); err != io.EOF {
+		t.Errorf("sendEvent on the target position returned %v, want io.EOF", err)
+	}
+	if !filter.reached {
+		t.Errorf("target not reached")
+	}
+}
+
+func TestChangedRows(t *testing.T) {
+	// nothing to stream when the start position is at the target
+	ta := NewTabletActor(nil, nil, nil, topo.TabletAlias{Cell: "cell1", Uid: 1})
+	args := &actionnode.OnlineSchemaChangeSyncArgs{
+		Table:     "t",
+		StartGTID: myproto.GTIDField{Value: myproto.GoogleGTID{GroupID: 12}},
+		WaitTime:  time.Second,
+	}
+	for _, target := range []myproto.GTID{myproto.GoogleGTID{GroupID: 11}, myproto.GoogleGTID{GroupID: 12}} {
+		conditions, err := ta.changedRows("vt_db", args, target)
+		if err != nil || conditions != nil {
+			t.Errorf("changedRows(%v) = (%v, %v), want nothing", target, conditions, err)
+		}
+	}
+
+	// a target of another flavor can't be compared
+	if _, err := ta.changedRows("vt_db", args, myproto.MariadbGTID{Domain: 0, Server: 1, Sequence: 13}); err == nil {
+		t.Errorf("changedRows with a MariaDB target should fail")
+	}
+}
+
+// fakeCutoverMysql runs the queries of the cutover like MySQL would:
+// a RENAME TABLE issued while the tables are locked waits for UNLOCK
+// TABLES, and fails if the sentry table exists.
+type fakeCutoverMysql struct {
+	mu            sync.Mutex
+	queries       []string
+	failQuery     string
+	locked        bool
+	unlocked      chan struct{}
+	sentry        bool
+	renamePending bool
+	renamed       bool
+}
+
+func newFakeCutoverMysql(failQuery string) *fakeCutoverMysql {
+	return &fakeCutoverMysql{
+		failQuery: failQuery,
+		unlocked:  make(chan struct{}),
+	}
+}
+
+func (m *fakeCutoverMysql) fetch(query string) (*mproto.QueryResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries = append(m.queries, query)
+	if m.failQuery != "" && strings.HasPrefix(query, m.failQuery) {
+		return nil, fmt.Errorf("%v failed", query)
+	}
+	switch {
+	case strings.HasPrefix(query, "CREATE TABLE vt_db._t_old"):
+		m.sentry = true
+	case strings.HasPrefix(query, "DROP TABLE vt_db._t_old"):
+		m.sentry = false
+	case strings.HasPrefix(query, "LOCK TABLES"):
+		m.locked = true
+	case query == "UNLOCK TABLES":
+		if m.locked {
+			m.locked = false
+			close(m.unlocked)
+		}
+	case strings.HasPrefix(query, "SELECT COUNT(*) FROM information_schema.processlist"):
+		count := "0"
+		if m.renamePending {
+			count = "1"
+		}
+		return &mproto.QueryResult{Rows: [][]sqltypes.Value{{sqltypes.MakeString([]byte(count))}}}, nil
+	case strings.HasPrefix(query, "RENAME TABLE"):
+		if m.locked {
+			m.renamePending = true
+			m.mu.Unlock()
+			<-m.unlocked
+			m.mu.Lock()
+			m.renamePending = false
+		}
+		if m.sentry {
+			return nil, fmt.Errorf("table _t_old already exists")
+		}
+		m.renamed = true
+	}
+	return &mproto.QueryResult{}, nil
+}
+
+// statements returns the queries that were run, without the polls of
+// the processlist.
+func (m *fakeCutoverMysql) statements() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []string
+	for _, query := range m.queries {
+		if !strings.HasPrefix(query, "SELECT COUNT(*) FROM information_schema.processlist") {
+			result = append(result, query)
+		}
+	}
+	return result
+}
+
+func runCutover(m *fakeCutoverMysql, masterPosition *myproto.ReplicationPosition) (*actionnode.OnlineSchemaChangeSyncReply, error) {
+	fmd := &mysqlctl.FakeMysqlDaemon{
+		CurrentMasterPosition: masterPosition,
+		FetchSuperQuery:       m.fetch,
+	}
+	ta := NewTabletActor(nil, fmd, nil, topo.TabletAlias{Cell: "cell1", Uid: 1})
+	conn, err := fmd.GetDbaConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Recycle()
+
+	args := &actionnode.OnlineSchemaChangeSyncArgs{
+		Table:       "t",
+		ShadowTable: "_t_osc",
+		OldTable:    "_t_old",
+		Columns:     []string{"id", "c"},
+		StartGTID:   myproto.GTIDField{Value: myproto.GoogleGTID{GroupID: 12}},
+		Cutover:     true,
+		WaitTime:    time.Second,
+	}
+	reply := &actionnode.OnlineSchemaChangeSyncReply{}
+	err = ta.onlineSchemaChangeCutover(conn, "vt_db", args, reply)
+	return reply, err
+}
+
+func TestOnlineSchemaChangeCutover(t *testing.T) {
+	m := newFakeCutoverMysql("")
+	reply, err := runCutover(m, &myproto.ReplicationPosition{
+		MasterLogGTIDField: myproto.GTIDField{Value: myproto.GoogleGTID{GroupID: 12}},
+	})
+	if err != nil {
+		t.Fatalf("onlineSchemaChangeCutover failed: %v", err)
+	}
+	if reply.GTIDField.Value != (myproto.GoogleGTID{GroupID: 12}) || reply.RowCount != 0 {
+		t.Errorf("unexpected reply: %#v", reply)
+	}
+	want := []string{
+		"CREATE TABLE vt_db._t_old (id INT)",
+		"LOCK TABLES vt_db.t WRITE, vt_db._t_osc WRITE, vt_db._t_old WRITE",
+		"RENAME TABLE vt_db.t TO vt_db._t_old, vt_db._t_osc TO vt_db.t",
+		"DROP TABLE vt_db._t_old",
+		"UNLOCK TABLES",
+	}
+	if got := m.statements(); !reflect.DeepEqual(got, want) {
+		t.Errorf("cutover ran:\n%v\nwant:\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if !m.renamed {
+		t.Errorf("tables were not renamed")
+	}
+}
+
+func TestOnlineSchemaChangeCutoverAbort(t *testing.T) {
+	masterPosition := &myproto.ReplicationPosition{
+		MasterLogGTIDField: myproto.GTIDField{Value: myproto.GoogleGTID{GroupID: 12}},
+	}
+	testcases := []struct {
+		name           string
+		failQuery      string
+		masterPosition *myproto.ReplicationPosition
+		want           []string
+	}{
+		{
+			name:           "lock fails",
+			failQuery:      "LOCK TABLES",
+			masterPosition: masterPosition,
+			want: []string{
+				"CREATE TABLE vt_db._t_old (id INT)",
+				"LOCK TABLES vt_db.t WRITE, vt_db._t_osc WRITE, vt_db._t_old WRITE",
+				"DROP TABLE vt_db._t_old",
+			},
+		},
+		{
+			name:           "no master position",
+			masterPosition: nil,
+			want: []string{
+				"CREATE TABLE vt_db._t_old (id INT)",
+				"LOCK TABLES vt_db.t WRITE, vt_db._t_osc WRITE, vt_db._t_old WRITE",
+				"UNLOCK TABLES",
+				"DROP TABLE vt_db._t_old",
+			},
+		},
+		{
+			// the rename is pending, or not issued yet: it has
+			// to fail on the sentry in both cases
+			name:           "processlist fails",
+			failQuery:      "SELECT COUNT(*) FROM information_schema.processlist",
+			masterPosition: masterPosition,
+			want: []string{
+				"CREATE TABLE vt_db._t_old (id INT)",
+				"LOCK TABLES vt_db.t WRITE, vt_db._t_osc WRITE, vt_db._t_old WRITE",
+				"UNLOCK TABLES",
+				"DROP TABLE vt_db._t_old",
+			},
+		},
+	}
+	for _, tc := range testcases {
+		m := newFakeCutoverMysql(tc.failQuery)
+		if _, err := runCutover(m, tc.masterPosition); err == nil {
+			t.Errorf("%v: onlineSchemaChangeCutover should have failed", tc.name)
+			continue
+		}
+		if m.renamed {
+			t.Errorf("%v: tables were renamed", tc.name)
+		}
+		if m.sentry {
+			t.Errorf("%v: sentry table was not dropped", tc.name)
+		}
+		var got []string
+		for _, query := range m.statements() {
+			if !strings.HasPrefix(query, "RENAME TABLE") {
+				got = append(got, query)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: cutover ran:\n%v\nwant:\n%v", tc.name, strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+		}
+	}
+}
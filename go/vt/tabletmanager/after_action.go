@@ -54,13 +54,18 @@ func (agent *ActionAgent) allowQueries(tablet *topo.Tablet) error {
 		return err
 	}
 
-	return tabletserver.AllowQueries(&agent.DBConfigs.App, agent.SchemaOverrides, qrs, agent.Mysqld, false)
+	return tabletserver.AllowQueries(&agent.DBConfigs.App, agent.SchemaOverrides, qrs, agent.Mysqld, false, tablet.Type == topo.TYPE_MASTER)
 }
 
 // createQueryRules computes the query rules that match the tablet record
 func (agent *ActionAgent) createQueryRules(tablet *topo.Tablet) (qrs *tabletserver.QueryRules, err error) {
 	qrs = tabletserver.LoadCustomRules()
 
+	// Query rules from the topo server, for the whole shard
+	if topoQrs := agent.topoQueryRules(); topoQrs != nil {
+		qrs.Append(topoQrs)
+	}
+
 	// Keyrange rules
 	if tablet.KeyRange.IsPartial() {
 		log.Infof("Restricting to keyrange: %v", tablet.KeyRange)
@@ -113,6 +118,8 @@ func (agent *ActionAgent) disallowQueries() {
 // changeCallback is run after every action that might
 // have changed something in the tablet record.
 func (agent *ActionAgent) changeCallback(oldTablet, newTablet topo.Tablet) {
+	// watch the query rules of the right shard before using them
+	agent.checkQueryRulesWatch(&newTablet)
 
 	allowQuery := true
 	var shardInfo *topo.ShardInfo
@@ -158,8 +165,15 @@ func (agent *ActionAgent) changeCallback(oldTablet, newTablet topo.Tablet) {
 			!reflect.DeepEqual(newTablet.BlacklistedTables, oldTablet.BlacklistedTables) {
 			agent.disallowQueries()
 		}
+		// A master prepares the transactions of its redo log
+		// when its query service starts. If mysql was still
+		// read-only then, it's done once it is read-write.
 		if err := agent.allowQueries(&newTablet); err != nil {
 			log.Errorf("Cannot start query service: %v", err)
+		} else if newTablet.Type == topo.TYPE_MASTER {
+			if err := tabletserver.PrepareFromRedoLog(); err != nil {
+				log.Warningf("Cannot prepare the transactions of the redo log: %v", err)
+			}
 		}
 
 		// Disable before enabling to force existing streams to stop.
@@ -182,4 +196,7 @@ func (agent *ActionAgent) changeCallback(oldTablet, newTablet topo.Tablet) {
 	} else {
 		agent.BinlogPlayerMap.StopAllPlayersAndReset()
 	}
+
+	// and turn semi-sync replication on or off for our new type
+	agent.updateSemiSync(&newTablet)
 }
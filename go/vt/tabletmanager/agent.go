@@ -97,6 +97,16 @@ type ActionAgent struct {
 	mutex       sync.Mutex
 	changeItems chan tabletChangeItem
 	_tablet     *topo.TabletInfo
+
+	// queryRulesMutex protects queryRulesWatch.
+	queryRulesMutex sync.Mutex
+	queryRulesWatch *queryRulesWatch
+
+	// semiSyncMutex protects the semi-sync replication we last set
+	// (nil if unknown), and when the master last looked for slaves.
+	semiSyncMutex         sync.Mutex
+	semiSyncSet           *semiSyncState
+	semiSyncSlavesChecked time.Time
 }
 
 func loadSchemaOverrides(overridesFile string) []tabletserver.SchemaOverride {
@@ -395,6 +405,7 @@ func (agent *ActionAgent) Start(mysqlPort, vtPort, vtsPort int) error {
 
 func (agent *ActionAgent) Stop() {
 	close(agent.done)
+	agent.stopQueryRulesWatch()
 	agent.BinlogPlayerMap.StopAllPlayersAndReset()
 	agent.Mysqld.Close()
 }
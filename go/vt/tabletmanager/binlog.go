@@ -39,10 +39,11 @@ type BinlogPlayerController struct {
 	mysqld   *mysqlctl.Mysqld
 
 	// Information about us (set at construction, immutable)
-	cell           string
-	keyspaceIdType key.KeyspaceIdType
-	keyRange       key.KeyRange
-	dbName         string
+	cell             string
+	keyspaceIdType   key.KeyspaceIdType
+	keyspaceIdColumn string
+	keyRange         key.KeyRange
+	dbName           string
 
 	// Information about the source (set at construction, immutable)
 	sourceShard topo.SourceShard
@@ -71,13 +72,14 @@ type BinlogPlayerController struct {
 	lastError error
 }
 
-func newBinlogPlayerController(ts topo.Server, dbConfig *mysql.ConnectionParams, mysqld *mysqlctl.Mysqld, cell string, keyspaceIdType key.KeyspaceIdType, keyRange key.KeyRange, sourceShard topo.SourceShard, dbName string) *BinlogPlayerController {
+func newBinlogPlayerController(ts topo.Server, dbConfig *mysql.ConnectionParams, mysqld *mysqlctl.Mysqld, cell string, keyspaceIdType key.KeyspaceIdType, keyspaceIdColumn string, keyRange key.KeyRange, sourceShard topo.SourceShard, dbName string) *BinlogPlayerController {
 	blc := &BinlogPlayerController{
 		ts:                ts,
 		dbConfig:          dbConfig,
 		mysqld:            mysqld,
 		cell:              cell,
 		keyspaceIdType:    keyspaceIdType,
+		keyspaceIdColumn:  keyspaceIdColumn,
 		keyRange:          keyRange,
 		dbName:            dbName,
 		sourceShard:       sourceShard,
@@ -279,7 +281,7 @@ func (bpc *BinlogPlayerController) Iteration() (err error) {
 			return fmt.Errorf("Source shard %v doesn't overlap destination shard %v", bpc.sourceShard.KeyRange, bpc.keyRange)
 		}
 
-		player := binlogplayer.NewBinlogPlayerKeyRange(vtClient, addr, bpc.keyspaceIdType, overlap, startPosition, bpc.stopAtGTID, bpc.binlogPlayerStats)
+		player := binlogplayer.NewBinlogPlayerKeyRange(vtClient, addr, bpc.keyspaceIdType, overlap, bpc.keyspaceIdColumn, startPosition, bpc.stopAtGTID, bpc.binlogPlayerStats)
 		return player.ApplyBinlogEvents(bpc.interrupted)
 	}
 }
@@ -386,14 +388,14 @@ func (blm *BinlogPlayerMap) size() int64 {
 }
 
 // addPlayer adds a new player to the map. It assumes we have the lock.
-func (blm *BinlogPlayerMap) addPlayer(cell string, keyspaceIdType key.KeyspaceIdType, keyRange key.KeyRange, sourceShard topo.SourceShard, dbName string) {
+func (blm *BinlogPlayerMap) addPlayer(cell string, keyspaceIdType key.KeyspaceIdType, keyspaceIdColumn string, keyRange key.KeyRange, sourceShard topo.SourceShard, dbName string) {
 	bpc, ok := blm.players[sourceShard.Uid]
 	if ok {
 		log.Infof("Already playing logs for %v", sourceShard)
 		return
 	}
 
-	bpc = newBinlogPlayerController(blm.ts, blm.dbConfig, blm.mysqld, cell, keyspaceIdType, keyRange, sourceShard, dbName)
+	bpc = newBinlogPlayerController(blm.ts, blm.dbConfig, blm.mysqld, cell, keyspaceIdType, keyspaceIdColumn, keyRange, sourceShard, dbName)
 	blm.players[sourceShard.Uid] = bpc
 	if blm.state == BPM_STATE_RUNNING {
 		bpc.Start()
@@ -445,7 +447,7 @@ func (blm *BinlogPlayerMap) RefreshMap(tablet topo.Tablet, keyspaceInfo *topo.Ke
 
 	// for each source, add it if not there, and delete from toRemove
 	for _, sourceShard := range shardInfo.SourceShards {
-		blm.addPlayer(tablet.Alias.Cell, keyspaceInfo.ShardingColumnType, tablet.KeyRange, sourceShard, tablet.DbName())
+		blm.addPlayer(tablet.Alias.Cell, keyspaceInfo.ShardingColumnType, keyspaceInfo.ShardingColumnName, tablet.KeyRange, sourceShard, tablet.DbName())
 		delete(toRemove, sourceShard.Uid)
 	}
 	hasPlayers := len(shardInfo.SourceShards) > 0
@@ -47,6 +47,11 @@ type StopSlaveMinimumArgs struct {
 	WaitTime  time.Duration
 }
 
+type ApplyRelayLogsArgs struct {
+	ReplicationPosition myproto.ReplicationPosition
+	WaitTimeout         time.Duration
+}
+
 type GetSlavesReply struct {
 	Addrs []string
 }
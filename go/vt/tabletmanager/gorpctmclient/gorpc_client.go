@@ -166,6 +166,17 @@ func (client *GoRpcTabletManagerConn) StartSlave(tablet *topo.TabletInfo, waitTi
 	return client.rpcCallTablet(tablet, actionnode.TABLET_ACTION_START_SLAVE, "", &noOutput, waitTime)
 }
 
+func (client *GoRpcTabletManagerConn) ApplyRelayLogs(tablet *topo.TabletInfo, replicationPosition *myproto.ReplicationPosition, waitTime time.Duration) (*myproto.ReplicationPosition, error) {
+	var rp myproto.ReplicationPosition
+	if err := client.rpcCallTablet(tablet, actionnode.TABLET_ACTION_APPLY_RELAY_LOGS, &gorpcproto.ApplyRelayLogsArgs{
+		ReplicationPosition: *replicationPosition,
+		WaitTimeout:         waitTime,
+	}, &rp, waitTime); err != nil {
+		return nil, err
+	}
+	return &rp, nil
+}
+
 func (client *GoRpcTabletManagerConn) GetSlaves(tablet *topo.TabletInfo, waitTime time.Duration) ([]string, error) {
 	var sl gorpcproto.GetSlavesReply
 	if err := client.rpcCallTablet(tablet, actionnode.TABLET_ACTION_GET_SLAVES, "", &sl, waitTime); err != nil {
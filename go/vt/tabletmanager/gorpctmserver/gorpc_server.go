@@ -163,6 +163,19 @@ func (tm *TabletManager) StartSlave(context *rpcproto.Context, args *rpc.UnusedR
 	})
 }
 
+func (tm *TabletManager) ApplyRelayLogs(context *rpcproto.Context, args *gorpcproto.ApplyRelayLogsArgs, reply *myproto.ReplicationPosition) error {
+	return tm.agent.RpcWrapLock(context.RemoteAddr, actionnode.TABLET_ACTION_APPLY_RELAY_LOGS, args, reply, func() error {
+		if err := tm.agent.Mysqld.ApplyRelayLogs(&args.ReplicationPosition, args.WaitTimeout); err != nil {
+			return err
+		}
+		position, err := tm.agent.Mysqld.SlaveStatus()
+		if err == nil {
+			*reply = *position
+		}
+		return err
+	})
+}
+
 func (tm *TabletManager) GetSlaves(context *rpcproto.Context, args *rpc.UnusedRequest, reply *gorpcproto.GetSlavesReply) error {
 	return tm.agent.RpcWrap(context.RemoteAddr, actionnode.TABLET_ACTION_GET_SLAVES, args, reply, func() error {
 		var err error
@@ -108,6 +108,11 @@ func (agent *ActionAgent) runHealthCheck(targetTabletType topo.TabletType, lockT
 	}
 	health, err := health.Run(typeForHealthCheck)
 
+	// A master enables semi-sync replication once it has slaves
+	if tablet.Type == topo.TYPE_MASTER {
+		agent.checkSemiSyncSlaves(tablet.Tablet)
+	}
+
 	// Figure out if we should be running QueryService. If we should,
 	// and we aren't, and we're otherwise healthy, try to start it.
 	if err == nil && topo.IsRunningQueryService(targetTabletType) && agent.BinlogPlayerMap.size() == 0 {
@@ -191,6 +191,10 @@ func (ai *ActionInitiator) StartSlave(tabletAlias topo.TabletAlias, waitTime tim
 	return ai.rpc.StartSlave(tablet, waitTime)
 }
 
+func (ai *ActionInitiator) ApplyRelayLogs(tablet *topo.TabletInfo, replicationPosition *myproto.ReplicationPosition, waitTime time.Duration) (*myproto.ReplicationPosition, error) {
+	return ai.rpc.ApplyRelayLogs(tablet, replicationPosition, waitTime)
+}
+
 func (ai *ActionInitiator) WaitBlpPosition(tabletAlias topo.TabletAlias, blpPosition blproto.BlpPosition, waitTime time.Duration) error {
 	tablet, err := ai.ts.GetTablet(tabletAlias)
 	if err != nil {
@@ -235,6 +239,22 @@ func (ai *ActionInitiator) Restore(dstTabletAlias topo.TabletAlias, args *action
 	return ai.writeTabletAction(dstTabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_RESTORE, Args: args})
 }
 
+func (ai *ActionInitiator) Backup(tabletAlias topo.TabletAlias, args *actionnode.BackupArgs) (actionPath string, err error) {
+	return ai.writeTabletAction(tabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_BACKUP, Args: args})
+}
+
+func (ai *ActionInitiator) RestoreFromBackup(dstTabletAlias topo.TabletAlias, args *actionnode.RestoreFromBackupArgs) (actionPath string, err error) {
+	return ai.writeTabletAction(dstTabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_RESTORE_FROM_BACKUP, Args: args})
+}
+
+func (ai *ActionInitiator) PointInTimeRecovery(dstTabletAlias topo.TabletAlias, args *actionnode.PointInTimeRecoveryArgs) (actionPath string, err error) {
+	return ai.writeTabletAction(dstTabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_POINT_IN_TIME_RECOVERY, Args: args})
+}
+
+func (ai *ActionInitiator) OnlineSchemaChangeSync(tabletAlias topo.TabletAlias, args *actionnode.OnlineSchemaChangeSyncArgs) (actionPath string, err error) {
+	return ai.writeTabletAction(tabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_ONLINE_SCHEMA_CHANGE_SYNC, Args: args})
+}
+
 func (ai *ActionInitiator) Scrap(tabletAlias topo.TabletAlias) (actionPath string, err error) {
 	return ai.writeTabletAction(tabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_SCRAP})
 }
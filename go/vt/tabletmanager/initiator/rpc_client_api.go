@@ -71,6 +71,11 @@ type TabletManagerConn interface {
 	// StartSlave starts the mysql replication
 	StartSlave(tablet *topo.TabletInfo, waitTime time.Duration) error
 
+	// ApplyRelayLogs makes the slave apply its relay logs up to the
+	// provided position, without starting the mysql replication IO
+	// thread, and returns its new position
+	ApplyRelayLogs(tablet *topo.TabletInfo, replicationPosition *myproto.ReplicationPosition, waitTime time.Duration) (*myproto.ReplicationPosition, error)
+
 	// GetSlaves returns the addresses of the slaves
 	GetSlaves(tablet *topo.TabletInfo, waitTime time.Duration) ([]string, error)
 
@@ -0,0 +1,158 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletmanager
+
+// This file handles the query rules stored in the topo server for
+// the shard of the tablet. They are applied on top of the
+// -customrules file, and updated live when they change.
+
+import (
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/tabletserver"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// queryRulesWatchRetryDelay is how long we wait before trying again
+// to watch the topo query rules.
+var queryRulesWatchRetryDelay = 5 * time.Second
+
+// queryRulesWatch is the state of the watch on the topo query rules.
+type queryRulesWatch struct {
+	keyspace     string
+	shard        string
+	stopWatching chan struct{}
+
+	// rules is the last valid version of the topo query rules,
+	// nil if there are none.
+	rules *tabletserver.QueryRules
+}
+
+// parseTopoQueryRules returns the QueryRules for the JSON value of the
+// topo query rules. An empty value means no rules.
+func parseTopoQueryRules(data string) (*tabletserver.QueryRules, error) {
+	if data == "" {
+		return nil, nil
+	}
+	qrs := tabletserver.NewQueryRules()
+	if err := qrs.UnmarshalJSON([]byte(data)); err != nil {
+		return nil, err
+	}
+	return qrs, nil
+}
+
+// checkQueryRulesWatch makes sure we watch the topo query rules of
+// the shard the tablet belongs to. The current rules are read right
+// away, so they are there when the query service starts, even if
+// the watch can't be started yet.
+func (agent *ActionAgent) checkQueryRulesWatch(tablet *topo.Tablet) {
+	agent.queryRulesMutex.Lock()
+	defer agent.queryRulesMutex.Unlock()
+
+	w := agent.queryRulesWatch
+	if w != nil && w.keyspace == tablet.Keyspace && w.shard == tablet.Shard {
+		return
+	}
+	if w != nil {
+		close(w.stopWatching)
+		agent.queryRulesWatch = nil
+	}
+	if tablet.Keyspace == "" || tablet.Shard == "" {
+		return
+	}
+
+	w = &queryRulesWatch{
+		keyspace:     tablet.Keyspace,
+		shard:        tablet.Shard,
+		stopWatching: make(chan struct{}),
+	}
+	data, err := agent.TopoServer.GetQueryRules(tablet.Keyspace, tablet.Shard)
+	switch err {
+	case nil:
+		if w.rules, err = parseTopoQueryRules(data); err != nil {
+			log.Errorf("Invalid query rules for %v/%v, ignoring them: %v", tablet.Keyspace, tablet.Shard, err)
+		}
+	case topo.ErrNoNode:
+		// no rules
+	default:
+		log.Errorf("Cannot read query rules for %v/%v: %v", tablet.Keyspace, tablet.Shard, err)
+	}
+
+	agent.queryRulesWatch = w
+	go agent.queryRulesLoop(w)
+}
+
+// queryRulesLoop watches the topo query rules, and applies them
+// every time they change, until the watch is stopped. If the watch
+// fails to start, it is tried again after queryRulesWatchRetryDelay.
+func (agent *ActionAgent) queryRulesLoop(w *queryRulesWatch) {
+	for {
+		notifications, err := agent.TopoServer.WatchQueryRules(w.keyspace, w.shard, w.stopWatching)
+		if err == nil {
+			agent.applyQueryRules(w, notifications)
+			return
+		}
+		log.Errorf("Cannot watch query rules for %v/%v, retrying in %v: %v", w.keyspace, w.shard, queryRulesWatchRetryDelay, err)
+		select {
+		case <-w.stopWatching:
+			return
+		case <-time.After(queryRulesWatchRetryDelay):
+		}
+	}
+}
+
+// applyQueryRules applies the topo query rules of the notifications.
+func (agent *ActionAgent) applyQueryRules(w *queryRulesWatch, notifications <-chan string) {
+	for data := range notifications {
+		qrs, err := parseTopoQueryRules(data)
+		if err != nil {
+			// keep the previous version, it was valid
+			log.Errorf("Invalid query rules for %v/%v, ignoring them: %v", w.keyspace, w.shard, err)
+			continue
+		}
+
+		agent.queryRulesMutex.Lock()
+		if agent.queryRulesWatch != w {
+			// the watch was stopped in the meantime
+			agent.queryRulesMutex.Unlock()
+			return
+		}
+		w.rules = qrs
+		agent.queryRulesMutex.Unlock()
+
+		if tabletserver.SqlQueryRpcService.GetState() != "SERVING" {
+			// the rules will be used when the service starts
+			continue
+		}
+		qrs, err = agent.createQueryRules(agent.Tablet().Tablet)
+		if err != nil {
+			log.Errorf("Cannot compute query rules: %v", err)
+			continue
+		}
+		log.Infof("Applying new query rules for %v/%v", w.keyspace, w.shard)
+		tabletserver.SetQueryRules(qrs)
+	}
+}
+
+// topoQueryRules returns the current topo query rules, or nil.
+func (agent *ActionAgent) topoQueryRules() *tabletserver.QueryRules {
+	agent.queryRulesMutex.Lock()
+	defer agent.queryRulesMutex.Unlock()
+	if agent.queryRulesWatch == nil {
+		return nil
+	}
+	return agent.queryRulesWatch.rules
+}
+
+// stopQueryRulesWatch stops watching the topo query rules.
+func (agent *ActionAgent) stopQueryRulesWatch() {
+	agent.queryRulesMutex.Lock()
+	defer agent.queryRulesMutex.Unlock()
+	if agent.queryRulesWatch != nil {
+		close(agent.queryRulesWatch.stopWatching)
+		agent.queryRulesWatch = nil
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletmanager
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/memorytopo"
+	"github.com/youtube/vitess/go/vt/tabletserver"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// failingWatchServer fails the first calls to WatchQueryRules.
+type failingWatchServer struct {
+	topo.Server
+
+	mu       sync.Mutex
+	failures int
+}
+
+func (s *failingWatchServer) WatchQueryRules(keyspace, shard string, stopWatching chan struct{}) (<-chan string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return nil, errors.New("cannot watch")
+	}
+	return s.Server.WatchQueryRules(keyspace, shard, stopWatching)
+}
+
+func TestQueryRulesWatchRetry(t *testing.T) {
+	tabletserver.RegisterQueryService()
+	ts := memorytopo.NewTestServer(t, []string{"cell1"})
+	defer ts.Close()
+	if err := ts.CreateKeyspace("test_keyspace", &topo.Keyspace{}); err != nil {
+		t.Fatalf("CreateKeyspace failed: %v", err)
+	}
+	if err := topo.CreateShard(ts, "test_keyspace", "0"); err != nil {
+		t.Fatalf("CreateShard failed: %v", err)
+	}
+	if err := ts.SaveQueryRules("test_keyspace", "0", `[{"Name": "r1"}]`); err != nil {
+		t.Fatalf("SaveQueryRules failed: %v", err)
+	}
+
+	defer func(delay time.Duration) {
+		queryRulesWatchRetryDelay = delay
+	}(queryRulesWatchRetryDelay)
+	queryRulesWatchRetryDelay = 10 * time.Millisecond
+	fts := &failingWatchServer{Server: ts, failures: 2}
+	agent := &ActionAgent{TopoServer: fts}
+	defer agent.stopQueryRulesWatch()
+
+	// the rules read before the watch are used while it fails
+	agent.checkQueryRulesWatch(&topo.Tablet{Keyspace: "test_keyspace", Shard: "0"})
+	if qrs := agent.topoQueryRules(); qrs == nil || qrs.Find("r1") == nil {
+		t.Errorf("topo query rules: %v, want r1", qrs)
+	}
+
+	// the watch is tried again, and gets the new rules
+	if err := ts.SaveQueryRules("test_keyspace", "0", `[{"Name": "r2"}]`); err != nil {
+		t.Fatalf("SaveQueryRules failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if qrs := agent.topoQueryRules(); qrs != nil && qrs.Find("r2") != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the new query rules were not applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	fts.mu.Lock()
+	defer fts.mu.Unlock()
+	if fts.failures != 0 {
+		t.Errorf("%v failures left", fts.failures)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletmanager
+
+// This file handles semi-synchronous replication. When enabled, a
+// master with replica or rdonly slaves turns on the master side,
+// so it doesn't commit a transaction before one of them received it.
+// The replica and rdonly slaves turn on the slave side, so they
+// acknowledge the transactions they receive.
+
+import (
+	"flag"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+var (
+	enableSemiSync            = flag.Bool("enable_semi_sync", false, "enable semi-synchronous replication between the master and its replica and rdonly slaves (needs the MySQL semi-sync plugins)")
+	semiSyncSlavesCheckPeriod = flag.Duration("semi_sync_slaves_check_period", 5*time.Minute, "how often a semi-sync master checks if slaves were added to its shard")
+)
+
+// semiSyncState is the semi-sync replication we last set.
+type semiSyncState struct {
+	master, slave bool
+}
+
+// isSemiSyncSlaveType returns true for the slaves that acknowledge
+// the transactions of a semi-sync master: the ones that can become
+// master in a reparent.
+func isSemiSyncSlaveType(tabletType topo.TabletType) bool {
+	return tabletType == topo.TYPE_REPLICA || tabletType == topo.TYPE_RDONLY
+}
+
+// updateSemiSync enables or disables both sides of semi-synchronous
+// replication, according to the tablet type. It is called after the
+// tablet record changes.
+func (agent *ActionAgent) updateSemiSync(tablet *topo.Tablet) {
+	if !*enableSemiSync {
+		return
+	}
+	agent.semiSyncMutex.Lock()
+	defer agent.semiSyncMutex.Unlock()
+	agent.setSemiSyncLocked(tablet, true)
+}
+
+// checkSemiSyncSlaves is called by the master health check, as slaves
+// may have been added since the master turned semi-sync off. Looking
+// for them reads the whole shard, so it only runs every
+// semi_sync_slaves_check_period, and only changes MySQL if the
+// result differs from what we last set.
+func (agent *ActionAgent) checkSemiSyncSlaves(tablet *topo.Tablet) {
+	if !*enableSemiSync {
+		return
+	}
+	agent.semiSyncMutex.Lock()
+	defer agent.semiSyncMutex.Unlock()
+	if time.Now().Sub(agent.semiSyncSlavesChecked) < *semiSyncSlavesCheckPeriod {
+		return
+	}
+	agent.setSemiSyncLocked(tablet, false)
+}
+
+// setSemiSyncLocked sets semi-sync replication for the tablet. If
+// force is false, MySQL is left alone when the state didn't change.
+// agent.semiSyncMutex must be held.
+func (agent *ActionAgent) setSemiSyncLocked(tablet *topo.Tablet, force bool) {
+	state := semiSyncState{slave: isSemiSyncSlaveType(tablet.Type)}
+	if tablet.Type == topo.TYPE_MASTER {
+		var err error
+		state.master, err = agent.hasSemiSyncSlaves(tablet)
+		if err != nil {
+			log.Warningf("Cannot find the slaves of %v, not changing semi-sync replication: %v", tablet.Alias, err)
+			return
+		}
+		agent.semiSyncSlavesChecked = time.Now()
+	}
+	if !force && agent.semiSyncSet != nil && *agent.semiSyncSet == state {
+		return
+	}
+	if err := agent.Mysqld.SetSemiSyncEnabled(state.master, state.slave); err != nil {
+		log.Errorf("Cannot set semi-sync replication (master=%v, slave=%v): %v", state.master, state.slave, err)
+		agent.semiSyncSet = nil
+		return
+	}
+	agent.semiSyncSet = &state
+}
+
+// hasSemiSyncSlaves returns true if the shard of the tablet has
+// replica or rdonly tablets. Without them, a semi-sync master would
+// wait for an acknowledgment on every commit until it times out.
+func (agent *ActionAgent) hasSemiSyncSlaves(tablet *topo.Tablet) (bool, error) {
+	tabletMap, err := topo.GetTabletMapForShard(agent.TopoServer, tablet.Keyspace, tablet.Shard)
+	switch err {
+	case nil, topo.ErrPartialResult:
+		// use the tablets we found
+	default:
+		return false, err
+	}
+	for alias, ti := range tabletMap {
+		if alias != tablet.Alias && isSemiSyncSlaveType(ti.Type) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
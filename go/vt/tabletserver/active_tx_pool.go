@@ -34,6 +34,8 @@ var (
 	BEGIN    = "begin"
 	COMMIT   = "commit"
 	ROLLBACK = "rollback"
+
+	BEGIN_CONSISTENT_SNAPSHOT = "start transaction with consistent snapshot"
 )
 
 const (
@@ -99,8 +101,18 @@ func (axp *ActiveTxPool) TransactionKiller() {
 }
 
 func (axp *ActiveTxPool) SafeBegin(conn dbconnpool.PoolConnection) (transactionId int64, err error) {
+	return axp.safeBegin(conn, BEGIN)
+}
+
+// SafeBeginConsistentSnapshot is like SafeBegin, but the transaction
+// reads from a snapshot taken when it starts.
+func (axp *ActiveTxPool) SafeBeginConsistentSnapshot(conn dbconnpool.PoolConnection) (transactionId int64, err error) {
+	return axp.safeBegin(conn, BEGIN_CONSISTENT_SNAPSHOT)
+}
+
+func (axp *ActiveTxPool) safeBegin(conn dbconnpool.PoolConnection, beginSql string) (transactionId int64, err error) {
 	defer handleError(&err, nil)
-	if _, err := conn.ExecuteFetch(BEGIN, 1, false); err != nil {
+	if _, err := conn.ExecuteFetch(beginSql, 1, false); err != nil {
 		panic(NewTabletErrorSql(FAIL, err))
 	}
 	transactionId = axp.lastId.Add(1)
@@ -167,6 +179,14 @@ type TxConnection struct {
 	dirtyTables   map[string]DirtyKeys
 	Queries       []string
 	Conclusion    string
+
+	// redoStatements are the final DMLs sent to mysql. They are
+	// written to the redo log if the transaction gets prepared.
+	redoStatements []string
+
+	// unreplayable is the first DML of the transaction that calls a
+	// per-row function, which makes it impossible to prepare.
+	unreplayable string
 }
 
 func newTxConnection(conn dbconnpool.PoolConnection, transactionId int64, pool *ActiveTxPool) *TxConnection {
@@ -201,6 +221,12 @@ func (txc *TxConnection) RecordQuery(query string) {
 	txc.Queries = append(txc.Queries, query)
 }
 
+// RecordRedo keeps a DML that was executed in the transaction,
+// so it can be replayed from the redo log.
+func (txc *TxConnection) RecordRedo(sql string) {
+	txc.redoStatements = append(txc.redoStatements, sql)
+}
+
 func (txc *TxConnection) discard(conclusion string) {
 	txc.Conclusion = conclusion
 	txc.EndTime = time.Now()
@@ -7,7 +7,7 @@ import (
 )
 
 func TestConsolidator(t *testing.T) {
-	qe := NewQueryEngine(qsConfig)
+	qe := testQueryEngine()
 	sql := "select * from SomeTable"
 
 	orig, added := qe.consolidator.Create(sql)
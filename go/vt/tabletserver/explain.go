@@ -0,0 +1,111 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/schema"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/tabletserver/planbuilder"
+	"github.com/youtube/vitess/go/vt/tabletserver/proto"
+)
+
+// Explain describes how Execute would run the query, without running it.
+// Selects are described as outside of a transaction, and DMLs as inside
+// one, since that's the only way they can be executed.
+func (qe *QueryEngine) Explain(logStats *SQLQueryStats, query *proto.Query) *proto.QueryExplanation {
+	if query.BindVariables == nil {
+		query.BindVariables = make(map[string]interface{})
+	}
+	logStats.BindVariables = query.BindVariables
+	stripTrailing(query)
+	plan := qe.schemaInfo.GetPlan(logStats, query.Sql)
+	logStats.PlanType = plan.PlanId.String()
+	logStats.OriginalSql = query.Sql
+
+	explanation := &proto.QueryExplanation{
+		PlanId:    plan.PlanId.String(),
+		Reason:    plan.Reason.String(),
+		TableName: plan.TableName,
+		IndexUsed: plan.IndexUsed,
+	}
+	for _, qr := range plan.Rules.rules {
+		if action := qr.getAction(logStats.RemoteAddr(), logStats.Username(), query.BindVariables); action != QR_CONTINUE {
+			explanation.Rules = append(explanation.Rules, fmt.Sprintf("%s: %v", qr.Name, action))
+		}
+	}
+
+	cached := plan.TableInfo != nil && plan.TableInfo.CacheType != schema.CACHE_NONE
+	bindVars := query.BindVariables
+	switch plan.PlanId {
+	case planbuilder.PLAN_PASS_SELECT, planbuilder.PLAN_PASS_DML:
+		explanation.Queries = qe.explainQuery(explanation.Queries, plan.FullQuery, bindVars, nil, nil)
+	case planbuilder.PLAN_PK_EQUAL:
+		pkRows, err := buildValueList(plan.TableInfo, plan.PKValues, bindVars)
+		if err != nil {
+			panic(err)
+		}
+		explanation.Queries = qe.explainQuery(explanation.Queries, plan.OuterQuery, bindVars, pkRows[0], nil)
+	case planbuilder.PLAN_PK_IN:
+		pkRows, err := buildINValueList(plan.TableInfo, plan.PKValues, bindVars)
+		if err != nil {
+			panic(err)
+		}
+		listVars := make([]sqltypes.Value, 0, len(pkRows))
+		for _, pkRow := range pkRows {
+			listVars = append(listVars, pkRow[0])
+		}
+		explanation.Queries = qe.explainQuery(explanation.Queries, plan.OuterQuery, bindVars, listVars, nil)
+	case planbuilder.PLAN_SELECT_SUBQUERY, planbuilder.PLAN_DML_SUBQUERY, planbuilder.PLAN_INSERT_SUBQUERY:
+		// The outer query depends on the rows of the subquery.
+		explanation.Queries = qe.explainQuery(explanation.Queries, plan.Subquery, bindVars, nil, nil)
+		explanation.Queries = append(explanation.Queries, plan.OuterQuery.Query)
+	case planbuilder.PLAN_INSERT_PK, planbuilder.PLAN_DML_PK:
+		pkRows, err := buildValueList(plan.TableInfo, plan.PKValues, bindVars)
+		if err != nil {
+			panic(err)
+		}
+		secondaryList, err := buildSecondaryList(plan.TableInfo, pkRows, plan.SecondaryPKValues, bindVars)
+		if err != nil {
+			panic(err)
+		}
+		bsc := buildStreamComment(plan.TableInfo, pkRows, secondaryList)
+		explanation.Queries = qe.explainQuery(explanation.Queries, plan.OuterQuery, bindVars, nil, bsc)
+	case planbuilder.PLAN_SET:
+		// The vt_ variables are handled by vttablet.
+		if !strings.HasPrefix(plan.SetKey, "vt_") {
+			explanation.Queries = qe.explainQuery(explanation.Queries, plan.FullQuery, bindVars, nil, nil)
+		}
+	case planbuilder.PLAN_DDL:
+		explanation.Queries = append(explanation.Queries, query.Sql)
+	}
+
+	if cached {
+		switch {
+		case plan.PlanId == planbuilder.PLAN_PK_EQUAL, plan.PlanId == planbuilder.PLAN_PK_IN, plan.PlanId == planbuilder.PLAN_SELECT_SUBQUERY:
+			explanation.Rowcache = "read"
+		case !plan.PlanId.IsSelect() && plan.PlanId != planbuilder.PLAN_SET && plan.PlanId != planbuilder.PLAN_DDL:
+			explanation.Rowcache = "invalidate"
+		}
+	}
+	return explanation
+}
+
+// explainQuery appends the final sql of parsedQuery to queries, or its
+// template if the bind variables don't allow to generate it.
+func (qe *QueryEngine) explainQuery(queries []string, parsedQuery *sqlparser.ParsedQuery, bindVars map[string]interface{}, listVars []sqltypes.Value, buildStreamComment []byte) []string {
+	bindVars[MAX_RESULT_NAME] = qe.maxResultSize.Get() + 1
+	sql, err := parsedQuery.GenerateQuery(bindVars, listVars)
+	if err != nil {
+		return append(queries, parsedQuery.Query)
+	}
+	if buildStreamComment != nil {
+		sql = append(sql, buildStreamComment...)
+	}
+	return append(queries, string(restoreTrailing(sql, bindVars)))
+}
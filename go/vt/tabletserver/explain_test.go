@@ -0,0 +1,110 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/youtube/vitess/go/cache"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/context"
+	"github.com/youtube/vitess/go/vt/dbconnpool"
+	"github.com/youtube/vitess/go/vt/schema"
+	"github.com/youtube/vitess/go/vt/tabletserver/planbuilder"
+	"github.com/youtube/vitess/go/vt/tabletserver/proto"
+)
+
+// newExplainQueryEngine returns a QueryEngine that knows the cached
+// table a(id, name), and answers the field queries with db.
+func newExplainQueryEngine(db *fakeTwoPCDB) *QueryEngine {
+	qe := newTwoPCQueryEngine(db)
+	table := schema.NewTable("a")
+	table.AddColumn("id", "bigint", sqltypes.Value{}, "")
+	table.AddColumn("name", "varbinary(10)", sqltypes.Value{}, "")
+	table.AddIndex("PRIMARY").AddColumn("id", 1)
+	table.PKColumns = []int{0}
+	table.CacheType = schema.CACHE_RW
+	qe.schemaInfo = &SchemaInfo{
+		tables:   map[string]*TableInfo{"a": &TableInfo{Table: table}},
+		queries:  cache.NewLRUCache(10),
+		rules:    NewQueryRules(),
+		connPool: dbconnpool.NewConnectionPool("", 1, 0),
+	}
+	qe.schemaInfo.connPool.Open(db.connect)
+	return qe
+}
+
+func TestExplain(t *testing.T) {
+	db := newFakeTwoPCDB()
+	qe := newExplainQueryEngine(db)
+	logStats := newSqlQueryStats("TestExplain", &context.DummyContext{})
+	qr := NewQueryRules()
+	rule := NewQueryRule("no deletes", "r1", QR_FAIL)
+	rule.AddPlanCond(planbuilder.PLAN_DML_PK)
+	rule.SetQueryCond("delete.*")
+	qr.Add(rule)
+	qe.schemaInfo.SetRules(qr)
+
+	testCases := []struct {
+		sql  string
+		want *proto.QueryExplanation
+	}{{
+		sql: "select * from a where id = :id",
+		want: &proto.QueryExplanation{
+			PlanId:    "PK_EQUAL",
+			Reason:    "DEFAULT",
+			TableName: "a",
+			Rowcache:  "read",
+			Queries:   []string{"select id, name from a where id = 3"},
+		},
+	}, {
+		sql: "update a set name = 'x' where id = 1",
+		want: &proto.QueryExplanation{
+			PlanId:    "DML_PK",
+			Reason:    "DEFAULT",
+			TableName: "a",
+			Rowcache:  "invalidate",
+			Queries:   []string{"update a set name = 'x' where id = 1 /* _stream a (id ) (1 ); */"},
+		},
+	}, {
+		sql: "delete from a where id = 1",
+		want: &proto.QueryExplanation{
+			PlanId:    "DML_PK",
+			Reason:    "DEFAULT",
+			TableName: "a",
+			Rowcache:  "invalidate",
+			Rules:     []string{"r1: FAIL"},
+			Queries:   []string{"delete from a where id = 1 /* _stream a (id ) (1 ); */"},
+		},
+	}}
+	for _, tc := range testCases {
+		got := qe.Explain(logStats, &proto.Query{Sql: tc.sql, BindVariables: map[string]interface{}{"id": 3}})
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Explain(%v):\n%#v\nwant:\n%#v", tc.sql, got, tc.want)
+		}
+	}
+
+	// nothing was sent to mysql but the field queries
+	for _, query := range db.takeQueries() {
+		if !strings.HasSuffix(query, "where 1 != 1") {
+			t.Errorf("Explain ran %v", query)
+		}
+	}
+}
+
+func TestExplainErrors(t *testing.T) {
+	db := newFakeTwoPCDB()
+	qe := newExplainQueryEngine(db)
+	logStats := newSqlQueryStats("TestExplain", &context.DummyContext{})
+
+	expectTabletError(t, "unknown table", "not found in schema", func() {
+		qe.Explain(logStats, &proto.Query{Sql: "select * from b where id = 1"})
+	})
+	expectTabletError(t, "missing bind variable", "Missing bind var :id", func() {
+		qe.Explain(logStats, &proto.Query{Sql: "select * from a where id = :id"})
+	})
+}
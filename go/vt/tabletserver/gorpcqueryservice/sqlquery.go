@@ -24,6 +24,10 @@ func (sq *SqlQuery) Begin(ctx *rpcproto.Context, session *proto.Session, txInfo
 	return sq.server.Begin(ctx, session, txInfo)
 }
 
+func (sq *SqlQuery) BeginConsistentSnapshot(ctx *rpcproto.Context, session *proto.Session, txInfo *proto.TransactionInfo) error {
+	return sq.server.BeginConsistentSnapshot(ctx, session, txInfo)
+}
+
 func (sq *SqlQuery) Commit(ctx *rpcproto.Context, session *proto.Session, noOutput *string) error {
 	return sq.server.Commit(ctx, session)
 }
@@ -32,10 +36,46 @@ func (sq *SqlQuery) Rollback(ctx *rpcproto.Context, session *proto.Session, noOu
 	return sq.server.Rollback(ctx, session)
 }
 
+func (sq *SqlQuery) Prepare(ctx *rpcproto.Context, req *proto.TwoPCRequest, noOutput *string) error {
+	return sq.server.Prepare(ctx, req)
+}
+
+func (sq *SqlQuery) CommitPrepared(ctx *rpcproto.Context, req *proto.TwoPCRequest, noOutput *string) error {
+	return sq.server.CommitPrepared(ctx, req)
+}
+
+func (sq *SqlQuery) RollbackPrepared(ctx *rpcproto.Context, req *proto.TwoPCRequest, noOutput *string) error {
+	return sq.server.RollbackPrepared(ctx, req)
+}
+
+func (sq *SqlQuery) CreateTransaction(ctx *rpcproto.Context, req *proto.TwoPCRequest, noOutput *string) error {
+	return sq.server.CreateTransaction(ctx, req)
+}
+
+func (sq *SqlQuery) StartCommit(ctx *rpcproto.Context, req *proto.TwoPCRequest, noOutput *string) error {
+	return sq.server.StartCommit(ctx, req)
+}
+
+func (sq *SqlQuery) SetRollback(ctx *rpcproto.Context, req *proto.TwoPCRequest, noOutput *string) error {
+	return sq.server.SetRollback(ctx, req)
+}
+
+func (sq *SqlQuery) ConcludeTransaction(ctx *rpcproto.Context, req *proto.TwoPCRequest, noOutput *string) error {
+	return sq.server.ConcludeTransaction(ctx, req)
+}
+
+func (sq *SqlQuery) UnresolvedTransactions(ctx *rpcproto.Context, req *proto.UnresolvedTransactionsRequest, reply *proto.DistributedTransactionList) error {
+	return sq.server.UnresolvedTransactions(ctx, req, reply)
+}
+
 func (sq *SqlQuery) Execute(ctx *rpcproto.Context, query *proto.Query, reply *mproto.QueryResult) error {
 	return sq.server.Execute(ctx, query, reply)
 }
 
+func (sq *SqlQuery) ExplainQuery(ctx *rpcproto.Context, query *proto.Query, reply *proto.QueryExplanation) error {
+	return sq.server.ExplainQuery(ctx, query, reply)
+}
+
 func (sq *SqlQuery) StreamExecute(ctx *rpcproto.Context, query *proto.Query, sendReply func(reply interface{}) error) error {
 	return sq.server.StreamExecute(ctx, query, func(reply *mproto.QueryResult) error {
 		return sendReply(reply)
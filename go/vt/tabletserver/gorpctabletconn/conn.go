@@ -15,6 +15,7 @@ import (
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/rpcplus"
 	"github.com/youtube/vitess/go/rpcwrap/bsonrpc"
+	"github.com/youtube/vitess/go/trace"
 	"github.com/youtube/vitess/go/vt/context"
 	"github.com/youtube/vitess/go/vt/rpc"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
@@ -87,7 +88,7 @@ func (conn *TabletBson) Execute(context context.Context, query string, bindVars
 		SessionId:     conn.sessionID,
 	}
 	qr := new(mproto.QueryResult)
-	if err := conn.rpcClient.Call("SqlQuery.Execute", req, qr); err != nil {
+	if err := conn.rpcClient.CallWithSpan(callSpan(context), "SqlQuery.Execute", req, qr); err != nil {
 		return nil, tabletError(err)
 	}
 	return qr, nil
@@ -107,7 +108,7 @@ func (conn *TabletBson) ExecuteBatch(context context.Context, queries []tproto.B
 		SessionId:     conn.sessionID,
 	}
 	qrs := new(tproto.QueryResultList)
-	if err := conn.rpcClient.Call("SqlQuery.ExecuteBatch", req, qrs); err != nil {
+	if err := conn.rpcClient.CallWithSpan(callSpan(context), "SqlQuery.ExecuteBatch", req, qrs); err != nil {
 		return nil, tabletError(err)
 	}
 	return qrs, nil
@@ -130,10 +131,30 @@ func (conn *TabletBson) StreamExecute(context context.Context, query string, bin
 		SessionId:     conn.sessionID,
 	}
 	sr := make(chan *mproto.QueryResult, 10)
-	c := conn.rpcClient.StreamGo("SqlQuery.StreamExecute", req, sr)
+	c := conn.rpcClient.StreamGoWithSpan(callSpan(context), "SqlQuery.StreamExecute", req, sr)
 	return sr, func() error { return tabletError(c.Error) }
 }
 
+// ExplainQuery describes how VTTablet would execute the query.
+func (conn *TabletBson) ExplainQuery(context context.Context, query string, bindVars map[string]interface{}) (*tproto.QueryExplanation, error) {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return nil, tabletconn.CONN_CLOSED
+	}
+
+	req := &tproto.Query{
+		Sql:           query,
+		BindVariables: bindVars,
+		SessionId:     conn.sessionID,
+	}
+	explanation := new(tproto.QueryExplanation)
+	if err := conn.rpcClient.CallWithSpan(callSpan(context), "SqlQuery.ExplainQuery", req, explanation); err != nil {
+		return nil, tabletError(err)
+	}
+	return explanation, nil
+}
+
 // Begin starts a transaction.
 func (conn *TabletBson) Begin(context context.Context) (transactionID int64, err error) {
 	conn.mu.RLock()
@@ -146,7 +167,24 @@ func (conn *TabletBson) Begin(context context.Context) (transactionID int64, err
 		SessionId: conn.sessionID,
 	}
 	var txInfo tproto.TransactionInfo
-	err = conn.rpcClient.Call("SqlQuery.Begin", req, &txInfo)
+	err = conn.rpcClient.CallWithSpan(callSpan(context), "SqlQuery.Begin", req, &txInfo)
+	return txInfo.TransactionId, tabletError(err)
+}
+
+// BeginConsistentSnapshot starts a transaction that reads from
+// a consistent snapshot.
+func (conn *TabletBson) BeginConsistentSnapshot(context context.Context) (transactionID int64, err error) {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return 0, tabletconn.CONN_CLOSED
+	}
+
+	req := &tproto.Session{
+		SessionId: conn.sessionID,
+	}
+	var txInfo tproto.TransactionInfo
+	err = conn.rpcClient.CallWithSpan(callSpan(context), "SqlQuery.BeginConsistentSnapshot", req, &txInfo)
 	return txInfo.TransactionId, tabletError(err)
 }
 
@@ -163,7 +201,7 @@ func (conn *TabletBson) Commit(context context.Context, transactionID int64) err
 		TransactionId: transactionID,
 	}
 	var noOutput rpc.UnusedResponse
-	return tabletError(conn.rpcClient.Call("SqlQuery.Commit", req, &noOutput))
+	return tabletError(conn.rpcClient.CallWithSpan(callSpan(context), "SqlQuery.Commit", req, &noOutput))
 }
 
 // Rollback rolls back the ongoing transaction.
@@ -179,7 +217,81 @@ func (conn *TabletBson) Rollback(context context.Context, transactionID int64) e
 		TransactionId: transactionID,
 	}
 	var noOutput rpc.UnusedResponse
-	return tabletError(conn.rpcClient.Call("SqlQuery.Rollback", req, &noOutput))
+	return tabletError(conn.rpcClient.CallWithSpan(callSpan(context), "SqlQuery.Rollback", req, &noOutput))
+}
+
+// Prepare prepares the transaction for the distributed transaction dtid.
+func (conn *TabletBson) Prepare(context context.Context, transactionID int64, dtid string) error {
+	return conn.twoPCCall(context, "SqlQuery.Prepare", &tproto.TwoPCRequest{TransactionId: transactionID, Dtid: dtid})
+}
+
+// CommitPrepared commits the transaction prepared for dtid.
+func (conn *TabletBson) CommitPrepared(context context.Context, dtid string) error {
+	return conn.twoPCCall(context, "SqlQuery.CommitPrepared", &tproto.TwoPCRequest{Dtid: dtid})
+}
+
+// RollbackPrepared rolls back the transaction prepared for dtid.
+func (conn *TabletBson) RollbackPrepared(context context.Context, dtid string, transactionID int64) error {
+	return conn.twoPCCall(context, "SqlQuery.RollbackPrepared", &tproto.TwoPCRequest{TransactionId: transactionID, Dtid: dtid})
+}
+
+// CreateTransaction records dtid in the coordinator log.
+func (conn *TabletBson) CreateTransaction(context context.Context, dtid string, participants []tproto.TxParticipant) error {
+	return conn.twoPCCall(context, "SqlQuery.CreateTransaction", &tproto.TwoPCRequest{Dtid: dtid, Participants: participants})
+}
+
+// StartCommit records the commit decision for dtid and commits the transaction.
+func (conn *TabletBson) StartCommit(context context.Context, transactionID int64, dtid string) error {
+	return conn.twoPCCall(context, "SqlQuery.StartCommit", &tproto.TwoPCRequest{TransactionId: transactionID, Dtid: dtid})
+}
+
+// SetRollback records the rollback decision for dtid and rolls back the transaction.
+func (conn *TabletBson) SetRollback(context context.Context, dtid string, transactionID int64) error {
+	return conn.twoPCCall(context, "SqlQuery.SetRollback", &tproto.TwoPCRequest{TransactionId: transactionID, Dtid: dtid})
+}
+
+// ConcludeTransaction removes dtid from the coordinator log.
+func (conn *TabletBson) ConcludeTransaction(context context.Context, dtid string) error {
+	return conn.twoPCCall(context, "SqlQuery.ConcludeTransaction", &tproto.TwoPCRequest{Dtid: dtid})
+}
+
+// UnresolvedTransactions returns the distributed transactions
+// older than abandonAge.
+func (conn *TabletBson) UnresolvedTransactions(context context.Context, abandonAge time.Duration) ([]tproto.DistributedTransaction, error) {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return nil, tabletconn.CONN_CLOSED
+	}
+
+	req := &tproto.UnresolvedTransactionsRequest{
+		SessionId:  conn.sessionID,
+		AbandonAge: int64(abandonAge / time.Second),
+	}
+	reply := new(tproto.DistributedTransactionList)
+	if err := conn.rpcClient.CallWithSpan(callSpan(context), "SqlQuery.UnresolvedTransactions", req, reply); err != nil {
+		return nil, tabletError(err)
+	}
+	return reply.Transactions, nil
+}
+
+// twoPCCall sends a two-phase commit request for the session.
+func (conn *TabletBson) twoPCCall(ctx context.Context, method string, req *tproto.TwoPCRequest) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.CONN_CLOSED
+	}
+
+	req.SessionId = conn.sessionID
+	var noOutput rpc.UnusedResponse
+	return tabletError(conn.rpcClient.CallWithSpan(callSpan(ctx), method, req, &noOutput))
+}
+
+// callSpan returns the trace span to send with a call, so the
+// vttablet side of the call is part of the trace.
+func callSpan(ctx context.Context) *trace.Span {
+	return context.Span(ctx)
 }
 
 // Close closes underlying bsonrpc.
@@ -217,6 +329,8 @@ func tabletError(err error) error {
 			code = tabletconn.ERR_TX_POOL_FULL
 		case strings.HasPrefix(errStr, "not_in_tx"):
 			code = tabletconn.ERR_NOT_IN_TX
+		case strings.HasPrefix(errStr, "throttled"):
+			code = tabletconn.ERR_THROTTLED
 		default:
 			code = tabletconn.ERR_NORMAL
 		}
@@ -86,3 +86,75 @@ type DmlType struct {
 type DDLInvalidate struct {
 	DDL string
 }
+
+// TxParticipant is a shard taking part in a distributed transaction.
+type TxParticipant struct {
+	Keyspace string
+	Shard    string
+}
+
+// TwoPCRequest is the request for the two-phase commit calls.
+// Dtid identifies the distributed transaction. Participants is
+// only used by CreateTransaction.
+type TwoPCRequest struct {
+	SessionId     int64
+	TransactionId int64
+	Dtid          string
+	Participants  []TxParticipant
+}
+
+// UnresolvedTransactionsRequest asks for the distributed transactions
+// of the coordinator log that are older than AbandonAge (in seconds).
+type UnresolvedTransactionsRequest struct {
+	SessionId  int64
+	AbandonAge int64
+}
+
+// States of a distributed transaction in the coordinator log.
+const (
+	DT_PREPARE  = "PREPARE"
+	DT_COMMIT   = "COMMIT"
+	DT_ROLLBACK = "ROLLBACK"
+)
+
+// DistributedTransaction is a distributed transaction
+// as recorded in the coordinator log.
+type DistributedTransaction struct {
+	Dtid string
+	// State is DT_PREPARE, DT_COMMIT or DT_ROLLBACK.
+	State string
+	// TimeCreated is in nanoseconds since the epoch.
+	TimeCreated  int64
+	Participants []TxParticipant
+}
+
+// DistributedTransactionList is the reply of UnresolvedTransactions.
+type DistributedTransactionList struct {
+	Transactions []DistributedTransaction
+}
+
+// QueryExplanation describes how vttablet executes a query,
+// without executing it. It is the reply of ExplainQuery.
+type QueryExplanation struct {
+	// PlanId and Reason are the names of the planbuilder
+	// PlanType and ReasonType of the query.
+	PlanId    string
+	Reason    string
+	TableName string
+
+	// Rowcache describes how the rowcache is used:
+	// "" (not used), "read" or "invalidate".
+	Rowcache string
+
+	// IndexUsed is the index a subquery plan reads.
+	IndexUsed string
+
+	// Rules lists the query rules that fire for the caller,
+	// as "<name>: <action>".
+	Rules []string
+
+	// Queries lists the SQL statements sent to MySQL, in order.
+	// The ones that depend on the result of a previous
+	// statement are templates.
+	Queries []string
+}
@@ -5,9 +5,11 @@
 package tabletserver
 
 import (
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/youtube/vitess/go/vt/key"
 	"github.com/youtube/vitess/go/vt/tabletserver/planbuilder"
@@ -48,6 +50,27 @@ func TestQueryRules(t *testing.T) {
 	}
 }
 
+func TestAppend(t *testing.T) {
+	qrs := NewQueryRules()
+	qr1 := NewQueryRule("rule 1", "r1", QR_FAIL)
+	qrs.Add(qr1)
+
+	otherqrs := NewQueryRules()
+	qr2 := NewQueryRule("rule 2", "r2", QR_FAIL)
+	otherqrs.Add(qr2)
+
+	qrs.Append(otherqrs)
+	if l := len(qrs.rules); l != 2 {
+		t.Errorf("want 2, got %d", l)
+	}
+	if qrs.rules[0] != qr1 {
+		t.Errorf("want:\n%#v\ngot:\n%#v", qr1, qrs.rules[0])
+	}
+	if qrf := qrs.Find("r2"); qrf == nil || qrf == qr2 {
+		t.Errorf("want a copy of r2, got %#v", qrf)
+	}
+}
+
 // TestCopy tests for deep copy
 func TestCopy(t *testing.T) {
 	qrs := NewQueryRules()
@@ -772,6 +795,44 @@ var invalidjsons = []InvalidJSONCase{
 	{`[{"BindVarConds": [{"Name": "a", "OnAbsent": true, "OnMismatch": true, "Operator": "NOMATCH", "Value": "["}]}]`, "processing [: error parsing regexp: missing closing ]: `[$`"},
 	{`[{"Action": 1 }]`, "want string for Action"},
 	{`[{"Action": "foo" }]`, "invalid Action foo"},
+	{`[{"Action": "THROTTLE" }]`, "Throttle missing for THROTTLE action"},
+	{`[{"Throttle": 1 }]`, "want json object for Throttle"},
+	{`[{"Throttle": {"MaxQPS": "1"} }]`, "want number for MaxQPS"},
+	{`[{"Throttle": {"KeyBy": "User"} }]`, "want list for KeyBy"},
+	{`[{"Throttle": {"KeyBy": ["Host"]} }]`, "invalid throttle key Host"},
+	{`[{"Throttle": {"Burst": 1} }]`, "unrecognized tag Burst in Throttle"},
+}
+
+func TestThrottleJSON(t *testing.T) {
+	qrs := NewQueryRules()
+	err := qrs.UnmarshalJSON([]byte(`[{
+		"Name": "t1",
+		"User": "batch.*",
+		"Action": "THROTTLE",
+		"Throttle": {"MaxQPS": 10, "MaxConcurrency": 2, "QueueTimeout": 0.5, "KeyBy": ["User", "Table"]}
+	}]`))
+	if err != nil {
+		t.Fatalf("UnmarshalJSON failed: %v", err)
+	}
+	qr := qrs.Find("t1")
+	if qr.act != QR_THROTTLE {
+		t.Errorf("want QR_THROTTLE, got %v", qr.act)
+	}
+	want := ThrottleConfig{MaxQPS: 10, MaxConcurrency: 2, QueueTimeout: 500 * time.Millisecond, KeyBy: []string{"User", "Table"}}
+	if !reflect.DeepEqual(*qr.throttle, want) {
+		t.Errorf("want %+v, got %+v", want, *qr.throttle)
+	}
+
+	// throttle rules don't stop the query
+	if action, _ := qrs.getAction("", "batch1", nil); action != QR_CONTINUE {
+		t.Errorf("want continue, got %v", action)
+	}
+	if rules := qrs.getThrottleRules("", "batch1", nil); len(rules) != 1 || rules[0] != qr {
+		t.Errorf("want [t1], got %v", rules)
+	}
+	if rules := qrs.getThrottleRules("", "web", nil); rules != nil {
+		t.Errorf("want nil, got %v", rules)
+	}
 }
 
 func TestInvalidJSON(t *testing.T) {
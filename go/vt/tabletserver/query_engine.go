@@ -6,6 +6,7 @@ package tabletserver
 
 import (
 	"fmt"
+	"strconv"
 	"time"
 
 	log "github.com/golang/glog"
@@ -62,9 +63,11 @@ type QueryEngine struct {
 	activeTxPool *ActiveTxPool
 	activePool   *ActivePool
 	consolidator *Consolidator
+	throttler    *Throttler
 	invalidator  *RowcacheInvalidator
 	streamQList  *QueryList
 	connKiller   *ConnectionKiller
+	twoPC        *TwoPC
 
 	// Vars
 	spotCheckFreq    sync2.AtomicInt64
@@ -72,6 +75,7 @@ type QueryEngine struct {
 	maxResultSize    sync2.AtomicInt64
 	streamBufferSize sync2.AtomicInt64
 	strictTableAcl   bool
+	atomicCommit     bool
 
 	// loggers
 	accessCheckerLogger *logutil.ThrottledLogger
@@ -120,6 +124,28 @@ func getOrPanic(pool *dbconnpool.ConnectionPool) dbconnpool.PoolConnection {
 	panic(NewTabletErrorSql(FATAL, err))
 }
 
+// getConn gets a connection from pool for a query: the wait is added
+// to logStats, and traced by a span named name.
+func getConn(logStats *SQLQueryStats, pool *dbconnpool.ConnectionPool, name string) (dbconnpool.PoolConnection, error) {
+	span := logStats.startSpan(name)
+	defer span.Finish()
+	waitingForConnectionStart := time.Now()
+	conn, err := pool.Get()
+	logStats.WaitingForConnection += time.Now().Sub(waitingForConnectionStart)
+	return conn, err
+}
+
+// getConnOrPanic is getOrPanic for a query: the wait is added to
+// logStats, and traced by a span named name.
+func getConnOrPanic(logStats *SQLQueryStats, pool *dbconnpool.ConnectionPool, name string) dbconnpool.PoolConnection {
+	span := logStats.startSpan(name)
+	defer span.Finish()
+	waitingForConnectionStart := time.Now()
+	conn := getOrPanic(pool)
+	logStats.WaitingForConnection += time.Now().Sub(waitingForConnectionStart)
+	return conn
+}
+
 // NewQueryEngine creates a new QueryEngine.
 // This is a singleton class.
 // You must call this only once.
@@ -140,8 +166,10 @@ func NewQueryEngine(config Config) *QueryEngine {
 	qe.connKiller = NewConnectionKiller(1, time.Duration(config.IdleTimeout*1e9))
 	qe.activePool = NewActivePool("ActivePool", time.Duration(config.QueryTimeout*1e9), qe.connKiller)
 	qe.consolidator = NewConsolidator()
+	qe.throttler = NewThrottler("Throttler")
 	qe.invalidator = NewRowcacheInvalidator(qe)
 	qe.streamQList = NewQueryList(qe.connKiller)
+	qe.twoPC = NewTwoPC(qe)
 
 	// Vars
 	qe.spotCheckFreq = sync2.AtomicInt64(config.SpotCheckRatio * SPOT_CHECK_MULTIPLIER)
@@ -149,6 +177,7 @@ func NewQueryEngine(config Config) *QueryEngine {
 		qe.strictMode.Set(1)
 	}
 	qe.strictTableAcl = config.StrictTableAcl
+	qe.atomicCommit = config.AtomicCommit
 	qe.maxResultSize = sync2.AtomicInt64(config.MaxResultSize)
 	qe.streamBufferSize = sync2.AtomicInt64(config.StreamBufferSize)
 
@@ -227,6 +256,7 @@ func (qe *QueryEngine) WaitForTxEmpty() {
 // before calling Close.
 func (qe *QueryEngine) Close() {
 	// Close in reverse order of Open.
+	qe.twoPC.Close()
 	qe.activePool.Close()
 	qe.connKiller.Close()
 	qe.activeTxPool.Close()
@@ -241,7 +271,20 @@ func (qe *QueryEngine) Close() {
 // Begin begins a transaction.
 func (qe *QueryEngine) Begin(logStats *SQLQueryStats) int64 {
 	defer queryStats.Record("BEGIN", time.Now())
+	return qe.begin(qe.activeTxPool.SafeBegin)
+}
+
+// BeginConsistentSnapshot starts a new transaction that reads from
+// a consistent snapshot of the database.
+func (qe *QueryEngine) BeginConsistentSnapshot(logStats *SQLQueryStats) int64 {
+	defer queryStats.Record("BEGIN_CONSISTENT_SNAPSHOT", time.Now())
+	return qe.begin(qe.activeTxPool.SafeBeginConsistentSnapshot)
+}
 
+func (qe *QueryEngine) begin(safeBegin func(dbconnpool.PoolConnection) (int64, error)) int64 {
+	if qe.twoPC.RedoLogPending() {
+		panic(NewTabletError(RETRY, "the transactions prepared on the previous master are not restored yet"))
+	}
 	conn, err := qe.txPool.TryGet()
 	if err == dbconnpool.CONN_POOL_CLOSED_ERR {
 		panic(connPoolClosedErr)
@@ -252,7 +295,7 @@ func (qe *QueryEngine) Begin(logStats *SQLQueryStats) int64 {
 	if conn == nil {
 		panic(NewTabletError(TX_POOL_FULL, "Transaction pool connection limit exceeded"))
 	}
-	transactionID, err := qe.activeTxPool.SafeBegin(conn)
+	transactionID, err := safeBegin(conn)
 	if err != nil {
 		conn.Recycle()
 		panic(err)
@@ -325,6 +368,11 @@ func (qe *QueryEngine) Execute(logStats *SQLQueryStats, query *proto.Query) (rep
 
 	qe.checkTableAcl(basePlan.TableName, basePlan.PlanId, basePlan.Authorized, logStats.context.GetUsername())
 
+	// Wait for the limits of the throttle rules
+	if throttleRules := basePlan.Rules.getThrottleRules(logStats.RemoteAddr(), logStats.Username(), query.BindVariables); throttleRules != nil {
+		defer qe.throttler.Throttle(throttleRules, logStats.Username(), basePlan.TableName, basePlan.PlanId)()
+	}
+
 	if basePlan.PlanId == planbuilder.PLAN_DDL {
 		return qe.execDDL(logStats, query.Sql)
 	}
@@ -349,7 +397,11 @@ func (qe *QueryEngine) Execute(logStats *SQLQueryStats, query *proto.Query) (rep
 			if qe.strictMode.Get() != 0 {
 				panic(NewTabletError(FAIL, "DML too complex"))
 			}
-			reply = qe.directFetch(logStats, conn, plan.FullQuery, plan.BindVars, nil, nil)
+			reply = qe.dmlFetch(logStats, conn, plan.FullQuery, plan.BindVars, nil, nil)
+			// the statement may have generated ids we can't see
+			if qe.atomicCommit && reply.InsertId != 0 && conn.unreplayable == "" {
+				conn.unreplayable = plan.Query
+			}
 		case planbuilder.PLAN_INSERT_PK:
 			reply = qe.execInsertPK(logStats, conn, plan, invalidator)
 		case planbuilder.PLAN_INSERT_SUBQUERY:
@@ -375,9 +427,7 @@ func (qe *QueryEngine) Execute(logStats *SQLQueryStats, query *proto.Query) (rep
 		case planbuilder.PLAN_SELECT_SUBQUERY:
 			reply = qe.execSubquery(logStats, plan)
 		case planbuilder.PLAN_SET:
-			waitingForConnectionStart := time.Now()
-			conn := getOrPanic(qe.connPool)
-			logStats.WaitingForConnection += time.Now().Sub(waitingForConnectionStart)
+			conn := getConnOrPanic(logStats, qe.connPool, "ConnPool.Get")
 			defer conn.Recycle()
 			reply = qe.execSet(logStats, conn, plan)
 		default:
@@ -412,11 +462,25 @@ func (qe *QueryEngine) StreamExecute(logStats *SQLQueryStats, query *proto.Query
 	authorized := tableacl.Authorized(plan.TableName, plan.PlanId.MinRole())
 	qe.checkTableAcl(plan.TableName, plan.PlanId, authorized, logStats.context.GetUsername())
 
+	// Wait for the limits of the throttle rules, for as long as
+	// the stream lasts
+	rules := qe.schemaInfo.GetStreamRules(query.Sql, plan)
+	if throttleRules := rules.getThrottleRules(logStats.RemoteAddr(), logStats.Username(), query.BindVariables); throttleRules != nil {
+		defer qe.throttler.Throttle(throttleRules, logStats.Username(), plan.TableName, plan.PlanId)()
+	}
+
 	// does the real work: first get a connection
-	waitingForConnectionStart := time.Now()
-	conn := getOrPanic(qe.streamConnPool)
-	logStats.WaitingForConnection += time.Now().Sub(waitingForConnectionStart)
-	defer conn.Recycle()
+	var conn dbconnpool.PoolConnection
+	if query.TransactionId != 0 {
+		// stream from the transaction, to read from its snapshot
+		txc := qe.activeTxPool.Get(query.TransactionId)
+		defer txc.Recycle()
+		txc.RecordQuery(query.Sql)
+		conn = txc
+	} else {
+		conn = getConnOrPanic(logStats, qe.streamConnPool, "StreamConnPool.Get")
+		defer conn.Recycle()
+	}
 
 	qd := NewQueryDetail(query, logStats.context, conn.Id())
 	qe.streamQList.Add(qd)
@@ -494,7 +558,7 @@ func (qe *QueryEngine) execDDL(logStats *SQLQueryStats, ddl string) *mproto.Quer
 	// Stolen from Execute
 	conn = qe.activeTxPool.Get(txid)
 	defer conn.Recycle()
-	result, err := qe.executeSql(logStats, conn, ddl, false)
+	result, err := qe.executeSql(logStats, conn, ddl, ddl, false)
 	if err != nil {
 		panic(NewTabletErrorSql(FAIL, err))
 	}
@@ -534,7 +598,7 @@ func (qe *QueryEngine) fetchOne(logStats *SQLQueryStats, plan *compiledPlan, pk
 	tableInfo := plan.TableInfo
 	keys := make([]string, 1)
 	keys[0] = buildKey(pk)
-	rcresults := tableInfo.Cache.Get(keys)
+	rcresults := qe.cacheGet(logStats, tableInfo, keys)
 	rcresult := rcresults[keys[0]]
 	if rcresult.Row != nil {
 		if qe.mustVerify() {
@@ -557,6 +621,15 @@ func (qe *QueryEngine) fetchOne(logStats *SQLQueryStats, plan *compiledPlan, pk
 	return row
 }
 
+// cacheGet reads keys from the rowcache of tableInfo.
+func (qe *QueryEngine) cacheGet(logStats *SQLQueryStats, tableInfo *TableInfo, keys []string) map[string]RCResult {
+	span := logStats.startSpan("RowCache.Get")
+	defer span.Finish()
+	span.Annotate("table", tableInfo.Name)
+	span.Annotate("keys", strconv.Itoa(len(keys)))
+	return tableInfo.Cache.Get(keys)
+}
+
 func (qe *QueryEngine) execPKIN(logStats *SQLQueryStats, plan *compiledPlan) (result *mproto.QueryResult) {
 	pkRows, err := buildINValueList(plan.TableInfo, plan.PKValues, plan.BindVars)
 	if err != nil {
@@ -584,7 +657,7 @@ func (qe *QueryEngine) fetchMulti(logStats *SQLQueryStats, plan *compiledPlan, p
 	for i, pk := range pkRows {
 		keys[i] = buildKey(pk)
 	}
-	rcresults := tableInfo.Cache.Get(keys)
+	rcresults := qe.cacheGet(logStats, tableInfo, keys)
 
 	result.Fields = plan.Fields
 	rows := make([][]sqltypes.Value, 0, len(pkRows))
@@ -680,9 +753,7 @@ func (qe *QueryEngine) execSelect(logStats *SQLQueryStats, plan *compiledPlan) (
 		result.Fields = plan.Fields
 		return
 	}
-	waitingForConnectionStart := time.Now()
-	conn := getOrPanic(qe.connPool)
-	logStats.WaitingForConnection += time.Now().Sub(waitingForConnectionStart)
+	conn := getConnOrPanic(logStats, qe.connPool, "ConnPool.Get")
 	defer conn.Recycle()
 	result = qe.fullFetch(logStats, conn, plan.FullQuery, plan.BindVars, nil, nil)
 	return
@@ -724,7 +795,10 @@ func (qe *QueryEngine) execInsertPKRows(logStats *SQLQueryStats, conn dbconnpool
 		panic(err)
 	}
 	bsc := buildStreamComment(plan.TableInfo, pkRows, secondaryList)
-	result = qe.directFetch(logStats, conn, plan.OuterQuery, plan.BindVars, nil, bsc)
+	if txc, ok := conn.(*TxConnection); ok && qe.atomicCommit && txc.unreplayable == "" && generatesIds(plan.TableInfo, pkRows) {
+		txc.unreplayable = plan.Query
+	}
+	result = qe.dmlFetch(logStats, conn, plan.OuterQuery, plan.BindVars, nil, bsc)
 	return result
 }
 
@@ -739,7 +813,7 @@ func (qe *QueryEngine) execDMLPK(logStats *SQLQueryStats, conn dbconnpool.PoolCo
 	}
 
 	bsc := buildStreamComment(plan.TableInfo, pkRows, secondaryList)
-	result = qe.directFetch(logStats, conn, plan.OuterQuery, plan.BindVars, nil, bsc)
+	result = qe.dmlFetch(logStats, conn, plan.OuterQuery, plan.BindVars, nil, bsc)
 	if invalidator != nil {
 		for _, pk := range pkRows {
 			key := buildKey(pk)
@@ -769,7 +843,7 @@ func (qe *QueryEngine) execDMLPKRows(logStats *SQLQueryStats, conn dbconnpool.Po
 		}
 
 		bsc := buildStreamComment(plan.TableInfo, singleRow, secondaryList)
-		rowsAffected += qe.directFetch(logStats, conn, plan.OuterQuery, plan.BindVars, pkRow, bsc).RowsAffected
+		rowsAffected += qe.dmlFetch(logStats, conn, plan.OuterQuery, plan.BindVars, pkRow, bsc).RowsAffected
 		if invalidator != nil {
 			key := buildKey(pkRow)
 			invalidator.Delete(key)
@@ -848,18 +922,16 @@ func (qe *QueryEngine) qFetch(logStats *SQLQueryStats, parsedQuery *sqlparser.Pa
 	q, ok := qe.consolidator.Create(string(sql))
 	if ok {
 		defer q.Broadcast()
-		waitingForConnectionStart := time.Now()
-		conn, err := qe.connPool.Get()
-		logStats.WaitingForConnection += time.Now().Sub(waitingForConnectionStart)
+		conn, err := getConn(logStats, qe.connPool, "ConnPool.Get")
 		if err != nil {
 			q.Err = NewTabletErrorSql(FATAL, err)
 		} else {
 			defer conn.Recycle()
-			q.Result, q.Err = qe.executeSql(logStats, conn, sql, false)
+			q.Result, q.Err = qe.executeSql(logStats, conn, parsedQuery.Query, sql, false)
 		}
 	} else {
 		logStats.QuerySources |= QUERY_SOURCE_CONSOLIDATOR
-		q.Wait()
+		qe.waitConsolidated(logStats, q)
 	}
 	if q.Err != nil {
 		panic(q.Err)
@@ -867,19 +939,55 @@ func (qe *QueryEngine) qFetch(logStats *SQLQueryStats, parsedQuery *sqlparser.Pa
 	return q.Result
 }
 
+// waitConsolidated waits for the result of the identical query q is
+// consolidated with.
+func (qe *QueryEngine) waitConsolidated(logStats *SQLQueryStats, q *Result) {
+	span := logStats.startSpan("Consolidator.Wait")
+	defer span.Finish()
+	q.Wait()
+}
+
 func (qe *QueryEngine) directFetch(logStats *SQLQueryStats, conn dbconnpool.PoolConnection, parsedQuery *sqlparser.ParsedQuery, bindVars map[string]interface{}, listVars []sqltypes.Value, buildStreamComment []byte) (result *mproto.QueryResult) {
 	sql := qe.generateFinalSql(parsedQuery, bindVars, listVars, buildStreamComment)
-	result, err := qe.executeSql(logStats, conn, sql, false)
+	result, err := qe.executeSql(logStats, conn, parsedQuery.Query, sql, false)
 	if err != nil {
 		panic(err)
 	}
 	return result
 }
 
+// dmlFetch is directFetch for DMLs. If conn is a transaction and
+// atomic commit is enabled, the time functions of the statement are
+// replaced by their value, and the final sql is recorded for the
+// redo log.
+func (qe *QueryEngine) dmlFetch(logStats *SQLQueryStats, conn dbconnpool.PoolConnection, parsedQuery *sqlparser.ParsedQuery, bindVars map[string]interface{}, listVars []sqltypes.Value, buildStreamComment []byte) (result *mproto.QueryResult) {
+	sql := qe.generateFinalSql(parsedQuery, bindVars, listVars, buildStreamComment)
+	txc, inTransaction := conn.(*TxConnection)
+	inTransaction = inTransaction && qe.atomicCommit
+	if inTransaction {
+		var perRow string
+		var err error
+		if sql, perRow, err = bindTimeFuncs(txc, sql); err != nil {
+			panic(NewTabletErrorSql(FAIL, err))
+		}
+		if perRow != "" && txc.unreplayable == "" {
+			txc.unreplayable = sql
+		}
+	}
+	result, err := qe.executeSql(logStats, conn, parsedQuery.Query, sql, false)
+	if err != nil {
+		panic(err)
+	}
+	if inTransaction {
+		txc.RecordRedo(sql)
+	}
+	return result
+}
+
 // fullFetch also fetches field info
 func (qe *QueryEngine) fullFetch(logStats *SQLQueryStats, conn dbconnpool.PoolConnection, parsedQuery *sqlparser.ParsedQuery, bindVars map[string]interface{}, listVars []sqltypes.Value, buildStreamComment []byte) (result *mproto.QueryResult) {
 	sql := qe.generateFinalSql(parsedQuery, bindVars, listVars, buildStreamComment)
-	result, err := qe.executeSql(logStats, conn, sql, true)
+	result, err := qe.executeSql(logStats, conn, parsedQuery.Query, sql, true)
 	if err != nil {
 		panic(err)
 	}
@@ -888,7 +996,7 @@ func (qe *QueryEngine) fullFetch(logStats *SQLQueryStats, conn dbconnpool.PoolCo
 
 func (qe *QueryEngine) fullStreamFetch(logStats *SQLQueryStats, conn dbconnpool.PoolConnection, parsedQuery *sqlparser.ParsedQuery, bindVars map[string]interface{}, listVars []sqltypes.Value, buildStreamComment []byte, callback func(*mproto.QueryResult) error) {
 	sql := qe.generateFinalSql(parsedQuery, bindVars, listVars, buildStreamComment)
-	qe.executeStreamSql(logStats, conn, sql, callback)
+	qe.executeStreamSql(logStats, conn, parsedQuery.Query, sql, callback)
 }
 
 func (qe *QueryEngine) generateFinalSql(parsedQuery *sqlparser.ParsedQuery, bindVars map[string]interface{}, listVars []sqltypes.Value, buildStreamComment []byte) string {
@@ -905,7 +1013,9 @@ func (qe *QueryEngine) generateFinalSql(parsedQuery *sqlparser.ParsedQuery, bind
 	return hack.String(sql)
 }
 
-func (qe *QueryEngine) executeSql(logStats *SQLQueryStats, conn dbconnpool.PoolConnection, sql string, wantfields bool) (*mproto.QueryResult, error) {
+// executeSql sends sql to mysql. query is its normalized form, with
+// the bind variables not substituted, for the trace span.
+func (qe *QueryEngine) executeSql(logStats *SQLQueryStats, conn dbconnpool.PoolConnection, query, sql string, wantfields bool) (*mproto.QueryResult, error) {
 	connid := conn.Id()
 	qe.activePool.Put(connid)
 	defer qe.activePool.Remove(connid)
@@ -917,6 +1027,9 @@ func (qe *QueryEngine) executeSql(logStats *SQLQueryStats, conn dbconnpool.PoolC
 	// NOTE(szopa): I am not doing this measurement inside
 	// conn.ExecuteFetch because that would require changing the
 	// PoolConnection interface. Same applies to executeStreamSql.
+	span := logStats.startSpan("MySQL.Execute")
+	defer span.Finish()
+	span.Annotate("query", query)
 	fetchStart := time.Now()
 	result, err := conn.ExecuteFetch(sql, int(qe.maxResultSize.Get()), wantfields)
 	logStats.MysqlResponseTime += time.Now().Sub(fetchStart)
@@ -927,10 +1040,14 @@ func (qe *QueryEngine) executeSql(logStats *SQLQueryStats, conn dbconnpool.PoolC
 	return result, nil
 }
 
-func (qe *QueryEngine) executeStreamSql(logStats *SQLQueryStats, conn dbconnpool.PoolConnection, sql string, callback func(*mproto.QueryResult) error) {
+// executeStreamSql is executeSql for streaming queries.
+func (qe *QueryEngine) executeStreamSql(logStats *SQLQueryStats, conn dbconnpool.PoolConnection, query, sql string, callback func(*mproto.QueryResult) error) {
 	logStats.QuerySources |= QUERY_SOURCE_MYSQL
 	logStats.NumberOfQueries++
 	logStats.AddRewrittenSql(sql)
+	span := logStats.startSpan("MySQL.StreamExecute")
+	defer span.Finish()
+	span.Annotate("query", query)
 	fetchStart := time.Now()
 	err := conn.ExecuteStreamFetch(sql, callback, int(qe.streamBufferSize.Get()))
 	logStats.MysqlResponseTime += time.Now().Sub(fetchStart)
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/youtube/vitess/go/vt/key"
 	"github.com/youtube/vitess/go/vt/tabletserver/planbuilder"
@@ -45,6 +46,14 @@ func (qrs *QueryRules) Add(qr *QueryRule) {
 	qrs.rules = append(qrs.rules, qr)
 }
 
+// Append merges the rules from another QueryRules into the receiver.
+// The rules are copied, and appended after the existing ones.
+func (qrs *QueryRules) Append(otherqrs *QueryRules) {
+	for _, qr := range otherqrs.rules {
+		qrs.rules = append(qrs.rules, qr.Copy())
+	}
+}
+
 // Find finds the first occurrence of a QueryRule by matching
 // the Name field. It returns nil if the rule was not found.
 func (qrs *QueryRules) Find(name string) (qr *QueryRule) {
@@ -100,15 +109,27 @@ func (qrs *QueryRules) filterByPlan(query string, planid planbuilder.PlanType, t
 	return &QueryRules{newrules}
 }
 
+// getAction returns the action of the first rule that fires. THROTTLE
+// rules are skipped, they are returned by getThrottleRules.
 func (qrs *QueryRules) getAction(ip, user string, bindVars map[string]interface{}) (action Action, desc string) {
 	for _, qr := range qrs.rules {
-		if act := qr.getAction(ip, user, bindVars); act != QR_CONTINUE {
+		if act := qr.getAction(ip, user, bindVars); act != QR_CONTINUE && act != QR_THROTTLE {
 			return act, qr.Description
 		}
 	}
 	return QR_CONTINUE, ""
 }
 
+// getThrottleRules returns all the THROTTLE rules that fire.
+func (qrs *QueryRules) getThrottleRules(ip, user string, bindVars map[string]interface{}) (throttleRules []*QueryRule) {
+	for _, qr := range qrs.rules {
+		if qr.getAction(ip, user, bindVars) == QR_THROTTLE {
+			throttleRules = append(throttleRules, qr)
+		}
+	}
+	return throttleRules
+}
+
 //-----------------------------------------------
 
 // QueryRule represents one rule (conditions-action).
@@ -139,6 +160,9 @@ type QueryRule struct {
 
 	// Action to be performed on trigger
 	act Action
+
+	// Limits applied by the QR_THROTTLE action
+	throttle *ThrottleConfig
 }
 
 // NewQueryRule creates a new QueryRule.
@@ -157,6 +181,12 @@ func (qr *QueryRule) Copy() (newqr *QueryRule) {
 		query:       qr.query,
 		act:         qr.act,
 	}
+	if qr.throttle != nil {
+		throttle := *qr.throttle
+		throttle.KeyBy = make([]string, len(qr.throttle.KeyBy))
+		copy(throttle.KeyBy, qr.throttle.KeyBy)
+		newqr.throttle = &throttle
+	}
 	if qr.plans != nil {
 		newqr.plans = make([]planbuilder.PlanType, len(qr.plans))
 		copy(newqr.plans, qr.plans)
@@ -206,6 +236,22 @@ func (qr *QueryRule) SetQueryCond(pattern string) (err error) {
 	return
 }
 
+// SetThrottle sets the limits applied by the QR_THROTTLE action.
+func (qr *QueryRule) SetThrottle(config ThrottleConfig) error {
+	for _, k := range config.KeyBy {
+		switch k {
+		case THROTTLE_BY_USER, THROTTLE_BY_TABLE, THROTTLE_BY_PLAN:
+		default:
+			return NewTabletError(FAIL, "invalid throttle key %s", k)
+		}
+	}
+	if config.MaxQPS < 0 || config.MaxConcurrency < 0 || config.QueueTimeout < 0 {
+		return NewTabletError(FAIL, "negative throttle limit: %+v", config)
+	}
+	qr.throttle = &config
+	return nil
+}
+
 // makeExact forces a full string match for the regex instead of substring
 func makeExact(pattern string) string {
 	return fmt.Sprintf("^%s$", pattern)
@@ -361,6 +407,45 @@ const (
 	QR_CONTINUE = Action(iota)
 	QR_FAIL
 	QR_FAIL_RETRY
+	QR_THROTTLE
+)
+
+var actionNames = map[Action]string{
+	QR_CONTINUE:   "CONTINUE",
+	QR_FAIL:       "FAIL",
+	QR_FAIL_RETRY: "FAIL_RETRY",
+	QR_THROTTLE:   "THROTTLE",
+}
+
+func (act Action) String() string {
+	return actionNames[act]
+}
+
+// ThrottleConfig contains the limits applied by a QR_THROTTLE rule.
+// Queries over the limits wait up to QueueTimeout, and then fail
+// with a THROTTLED error.
+type ThrottleConfig struct {
+	// MaxQPS is the maximum rate of queries, 0 means no limit.
+	MaxQPS float64
+
+	// MaxConcurrency is the maximum number of queries executing
+	// at the same time, 0 means no limit.
+	MaxConcurrency int
+
+	// QueueTimeout is how long an over-limit query can wait.
+	QueueTimeout time.Duration
+
+	// KeyBy lists what the limits are applied to, out of
+	// THROTTLE_BY_USER, THROTTLE_BY_TABLE and THROTTLE_BY_PLAN.
+	// If it's empty, all the queries the rule fires on share the
+	// same limits.
+	KeyBy []string
+}
+
+const (
+	THROTTLE_BY_USER  = "User"
+	THROTTLE_BY_TABLE = "Table"
+	THROTTLE_BY_PLAN  = "Plan"
 )
 
 // BindVarCond represents a bind var condition.
@@ -699,6 +784,8 @@ func buildQueryRule(ruleInfo map[string]interface{}) (qr *QueryRule, err error)
 			if !ok {
 				return nil, NewTabletError(FAIL, "want list for %s", k)
 			}
+		case "Throttle":
+			// parsed below
 		default:
 			return nil, NewTabletError(FAIL, "unrecognized tag %s", k)
 		}
@@ -760,14 +847,67 @@ func buildQueryRule(ruleInfo map[string]interface{}) (qr *QueryRule, err error)
 				qr.act = QR_FAIL
 			case "FAIL_RETRY":
 				qr.act = QR_FAIL_RETRY
+			case "THROTTLE":
+				qr.act = QR_THROTTLE
 			default:
 				return nil, NewTabletError(FAIL, "invalid Action %s", sv)
 			}
+		case "Throttle":
+			config, err := buildThrottleConfig(v)
+			if err != nil {
+				return nil, err
+			}
+			if err = qr.SetThrottle(config); err != nil {
+				return nil, err
+			}
 		}
 	}
+	if qr.act == QR_THROTTLE && qr.throttle == nil {
+		return nil, NewTabletError(FAIL, "Throttle missing for THROTTLE action")
+	}
 	return qr, nil
 }
 
+func buildThrottleConfig(v interface{}) (config ThrottleConfig, err error) {
+	info, ok := v.(map[string]interface{})
+	if !ok {
+		return config, NewTabletError(FAIL, "want json object for Throttle")
+	}
+	for k, v := range info {
+		switch k {
+		case "MaxQPS", "MaxConcurrency", "QueueTimeout":
+			f, ok := v.(float64)
+			if !ok {
+				return config, NewTabletError(FAIL, "want number for %s", k)
+			}
+			switch k {
+			case "MaxQPS":
+				config.MaxQPS = f
+			case "MaxConcurrency":
+				config.MaxConcurrency = int(f)
+			case "QueueTimeout":
+				// in seconds, like the other timeouts
+				config.QueueTimeout = time.Duration(f * 1e9)
+			}
+		case "KeyBy":
+			lv, ok := v.([]interface{})
+			if !ok {
+				return config, NewTabletError(FAIL, "want list for KeyBy")
+			}
+			for _, key := range lv {
+				sv, ok := key.(string)
+				if !ok {
+					return config, NewTabletError(FAIL, "want string for KeyBy")
+				}
+				config.KeyBy = append(config.KeyBy, sv)
+			}
+		default:
+			return config, NewTabletError(FAIL, "unrecognized tag %s in Throttle", k)
+		}
+	}
+	return config, nil
+}
+
 func buildBindVarCondition(bvc interface{}) (name string, onAbsent, onMismatch bool, op Operator, value interface{}, err error) {
 	bvcinfo, ok := bvc.(map[string]interface{})
 	if !ok {
@@ -42,6 +42,7 @@ func init() {
 	flag.Float64Var(&qsConfig.SpotCheckRatio, "queryserver-config-spot-check-ratio", DefaultQsConfig.SpotCheckRatio, "query server rowcache spot check frequency")
 	flag.BoolVar(&qsConfig.StrictMode, "queryserver-config-strict-mode", DefaultQsConfig.StrictMode, "allow only predictable DMLs and enforces MySQL's STRICT_TRANS_TABLES")
 	flag.BoolVar(&qsConfig.StrictTableAcl, "queryserver-config-strict-table-acl", DefaultQsConfig.StrictTableAcl, "only allow queries that pass table acl checks")
+	flag.BoolVar(&qsConfig.AtomicCommit, "atomic_commit", DefaultQsConfig.AtomicCommit, "keep the redo log needed by the two-phase commit of vtgate, set it when vtgate runs with -atomic_commit")
 	flag.StringVar(&qsConfig.RowCache.Binary, "rowcache-bin", DefaultQsConfig.RowCache.Binary, "rowcache binary file")
 	flag.IntVar(&qsConfig.RowCache.Memory, "rowcache-memory", DefaultQsConfig.RowCache.Memory, "rowcache max memory usage in MB")
 	flag.StringVar(&qsConfig.RowCache.Socket, "rowcache-socket", DefaultQsConfig.RowCache.Socket, "rowcache socket path to listen on")
@@ -104,6 +105,7 @@ type Config struct {
 	SpotCheckRatio     float64
 	StrictMode         bool
 	StrictTableAcl     bool
+	AtomicCommit       bool
 }
 
 // DefaultQSConfig is the default value for the query service config.
@@ -129,6 +131,7 @@ var DefaultQsConfig = Config{
 	SpotCheckRatio:     0,
 	StrictMode:         true,
 	StrictTableAcl:     false,
+	AtomicCommit:       false,
 }
 
 var qsConfig Config
@@ -155,8 +158,10 @@ func RegisterQueryService() {
 
 // AllowQueries can take an indefinite amount of time to return because
 // it keeps retrying until it obtains a valid connection to the database.
-func AllowQueries(dbconfig *dbconfigs.DBConfig, schemaOverrides []SchemaOverride, qrs *QueryRules, mysqld *mysqlctl.Mysqld, waitForMysql bool) error {
-	return SqlQueryRpcService.allowQueries(dbconfig, schemaOverrides, qrs, mysqld, waitForMysql)
+// A master prepares again the distributed transactions of its redo log
+// before it serves queries.
+func AllowQueries(dbconfig *dbconfigs.DBConfig, schemaOverrides []SchemaOverride, qrs *QueryRules, mysqld *mysqlctl.Mysqld, waitForMysql, isMaster bool) error {
+	return SqlQueryRpcService.allowQueries(dbconfig, schemaOverrides, qrs, mysqld, waitForMysql, isMaster)
 }
 
 // DisallowQueries can take a long time to return (not indefinite) because
@@ -167,6 +172,14 @@ func DisallowQueries() {
 	SqlQueryRpcService.disallowQueries()
 }
 
+// PrepareFromRedoLog prepares again the distributed transactions of
+// the redo log, if mysql was read-only when the query service of the
+// master started. If the query service is not running, nothing will
+// happen.
+func PrepareFromRedoLog() error {
+	return SqlQueryRpcService.prepareFromRedoLog()
+}
+
 // Reload the schema. If the query service is not running, nothing will happen
 func ReloadSchema() {
 	defer logError()
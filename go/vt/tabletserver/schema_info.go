@@ -367,6 +367,14 @@ func (si *SchemaInfo) GetStreamPlan(sql string) *planbuilder.ExecPlan {
 	return plan
 }
 
+// GetStreamRules returns the query rules for a streaming query, like
+// the Rules of the plans returned by GetPlan.
+func (si *SchemaInfo) GetStreamRules(sql string, plan *planbuilder.ExecPlan) *QueryRules {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	return si.rules.filterByPlan(sql, plan.PlanId, plan.TableName)
+}
+
 func (si *SchemaInfo) SetRules(qrs *QueryRules) {
 	si.mu.Lock()
 	defer si.mu.Unlock()
@@ -110,8 +110,10 @@ func (sq *SqlQuery) setState(state int64) {
 // While allowQuery is running, the state is set to INITIALIZING.
 // If waitForMysql is set to true, allowQueries will not return
 // until it's able to connect to mysql.
+// If isMaster is set to true, the transactions of the redo log are
+// prepared again before the state is SERVING.
 // No other operations are allowed when allowQueries is running.
-func (sq *SqlQuery) allowQueries(dbconfig *dbconfigs.DBConfig, schemaOverrides []SchemaOverride, qrs *QueryRules, mysqld *mysqlctl.Mysqld, waitForMysql bool) (err error) {
+func (sq *SqlQuery) allowQueries(dbconfig *dbconfigs.DBConfig, schemaOverrides []SchemaOverride, qrs *QueryRules, mysqld *mysqlctl.Mysqld, waitForMysql, isMaster bool) (err error) {
 	sq.mu.Lock()
 	defer sq.mu.Unlock()
 	if sq.state.Get() != NOT_SERVING {
@@ -150,6 +152,13 @@ func (sq *SqlQuery) allowQueries(dbconfig *dbconfigs.DBConfig, schemaOverrides [
 	}()
 
 	sq.qe.Open(dbconfig, schemaOverrides, qrs, mysqld)
+	if isMaster {
+		// the transactions prepared on the old master are in our
+		// redo log, they have to be prepared before new ones start
+		if err := sq.qe.twoPC.PrepareFromRedoLog(); err != nil {
+			log.Warningf("%v", err)
+		}
+	}
 	sq.dbconfig = dbconfig
 	sq.mysqld = mysqld
 	sq.sessionId = Rand()
@@ -199,6 +208,18 @@ func (sq *SqlQuery) disallowQueries() {
 	sq.dbconfig = &dbconfigs.DBConfig{}
 }
 
+// prepareFromRedoLog replays the redo log if the query service is
+// running, and it couldn't be replayed when it started.
+func (sq *SqlQuery) prepareFromRedoLog() (err error) {
+	defer handleError(&err, nil)
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	if sq.state.Get() != SERVING || !sq.qe.twoPC.RedoLogPending() {
+		return nil
+	}
+	return sq.qe.twoPC.PrepareFromRedoLog()
+}
+
 // GetSessionId returns a sessionInfo response if the state is SERVING.
 func (sq *SqlQuery) GetSessionId(sessionParams *proto.SessionParams, sessionInfo *proto.SessionInfo) error {
 	// We perform a lockless read of state because we don't care if it changes
@@ -221,6 +242,20 @@ func (sq *SqlQuery) GetSessionId(sessionParams *proto.SessionParams, sessionInfo
 func (sq *SqlQuery) Begin(context context.Context, session *proto.Session, txInfo *proto.TransactionInfo) (err error) {
 	logStats := newSqlQueryStats("Begin", context)
 	logStats.OriginalSql = "begin"
+	return sq.begin(logStats, session, txInfo, sq.qe.Begin)
+}
+
+// BeginConsistentSnapshot starts a new transaction with
+// START TRANSACTION WITH CONSISTENT SNAPSHOT. The queries of the
+// transaction, including the streaming ones, all see the database
+// as it was when the transaction started.
+func (sq *SqlQuery) BeginConsistentSnapshot(context context.Context, session *proto.Session, txInfo *proto.TransactionInfo) (err error) {
+	logStats := newSqlQueryStats("BeginConsistentSnapshot", context)
+	logStats.OriginalSql = "start transaction with consistent snapshot"
+	return sq.begin(logStats, session, txInfo, sq.qe.BeginConsistentSnapshot)
+}
+
+func (sq *SqlQuery) begin(logStats *SQLQueryStats, session *proto.Session, txInfo *proto.TransactionInfo, qeBegin func(*SQLQueryStats) int64) (err error) {
 	sq.mu.RLock()
 	defer sq.mu.RUnlock()
 	defer handleError(&err, logStats)
@@ -232,7 +267,7 @@ func (sq *SqlQuery) Begin(context context.Context, session *proto.Session, txInf
 		return NewTabletError(RETRY, "Invalid session Id %v", session.SessionId)
 	}
 
-	txInfo.TransactionId = sq.qe.Begin(logStats)
+	txInfo.TransactionId = qeBegin(logStats)
 	logStats.TransactionID = txInfo.TransactionId
 	return nil
 }
@@ -297,6 +332,128 @@ func (sq *SqlQuery) Rollback(context context.Context, session *proto.Session) (e
 	return nil
 }
 
+// Prepare prepares the specified transaction for the
+// distributed transaction Dtid.
+func (sq *SqlQuery) Prepare(context context.Context, req *proto.TwoPCRequest) (err error) {
+	logStats := newSqlQueryStats("Prepare", context)
+	logStats.OriginalSql = "prepare"
+	logStats.TransactionID = req.TransactionId
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+
+	sq.qe.Prepare(logStats, req.TransactionId, req.Dtid)
+	return nil
+}
+
+// CommitPrepared commits the transaction prepared for Dtid.
+func (sq *SqlQuery) CommitPrepared(context context.Context, req *proto.TwoPCRequest) (err error) {
+	logStats := newSqlQueryStats("CommitPrepared", context)
+	logStats.OriginalSql = "commit prepared"
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+
+	sq.qe.CommitPrepared(logStats, req.Dtid)
+	return nil
+}
+
+// RollbackPrepared rolls back the transaction prepared for Dtid,
+// or the specified transaction if it was not prepared.
+func (sq *SqlQuery) RollbackPrepared(context context.Context, req *proto.TwoPCRequest) (err error) {
+	logStats := newSqlQueryStats("RollbackPrepared", context)
+	logStats.OriginalSql = "rollback prepared"
+	logStats.TransactionID = req.TransactionId
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+
+	sq.qe.RollbackPrepared(logStats, req.Dtid, req.TransactionId)
+	return nil
+}
+
+// CreateTransaction records the distributed transaction Dtid
+// in the coordinator log.
+func (sq *SqlQuery) CreateTransaction(context context.Context, req *proto.TwoPCRequest) (err error) {
+	logStats := newSqlQueryStats("CreateTransaction", context)
+	logStats.OriginalSql = "create transaction"
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+
+	sq.qe.CreateTransaction(logStats, req.Dtid, req.Participants)
+	return nil
+}
+
+// StartCommit records the commit decision for Dtid and commits
+// the specified transaction.
+func (sq *SqlQuery) StartCommit(context context.Context, req *proto.TwoPCRequest) (err error) {
+	logStats := newSqlQueryStats("StartCommit", context)
+	logStats.OriginalSql = "start commit"
+	logStats.TransactionID = req.TransactionId
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+
+	sq.qe.StartCommit(logStats, req.TransactionId, req.Dtid)
+	return nil
+}
+
+// SetRollback records the rollback decision for Dtid and rolls
+// back the specified transaction.
+func (sq *SqlQuery) SetRollback(context context.Context, req *proto.TwoPCRequest) (err error) {
+	logStats := newSqlQueryStats("SetRollback", context)
+	logStats.OriginalSql = "set rollback"
+	logStats.TransactionID = req.TransactionId
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+
+	sq.qe.SetRollback(logStats, req.Dtid, req.TransactionId)
+	return nil
+}
+
+// ConcludeTransaction removes Dtid from the coordinator log.
+func (sq *SqlQuery) ConcludeTransaction(context context.Context, req *proto.TwoPCRequest) (err error) {
+	logStats := newSqlQueryStats("ConcludeTransaction", context)
+	logStats.OriginalSql = "conclude transaction"
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+
+	sq.qe.ConcludeTransaction(logStats, req.Dtid)
+	return nil
+}
+
+// UnresolvedTransactions returns the distributed transactions of the
+// coordinator log that are older than the abandon age.
+func (sq *SqlQuery) UnresolvedTransactions(context context.Context, req *proto.UnresolvedTransactionsRequest, reply *proto.DistributedTransactionList) (err error) {
+	logStats := newSqlQueryStats("UnresolvedTransactions", context)
+	logStats.OriginalSql = "unresolved transactions"
+	if err = sq.startRequest(req.SessionId, false); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+
+	reply.Transactions = sq.qe.UnresolvedTransactions(logStats, time.Duration(req.AbandonAge)*time.Second)
+	return nil
+}
+
 // handleExecError handles panics during query execution and sets
 // the supplied error return value.
 func handleExecError(query *proto.Query, err *error, logStats *SQLQueryStats) {
@@ -340,17 +497,29 @@ func (sq *SqlQuery) Execute(context context.Context, query *proto.Query, reply *
 	return nil
 }
 
+// ExplainQuery describes how the query would be executed, without
+// executing it.
+func (sq *SqlQuery) ExplainQuery(context context.Context, query *proto.Query, reply *proto.QueryExplanation) (err error) {
+	logStats := newSqlQueryStats("ExplainQuery", context)
+	if err = sq.startRequest(query.SessionId, false); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleExecError(query, &err, logStats)
+
+	*reply = *sq.qe.Explain(logStats, query)
+	return nil
+}
+
 // StreamExecute executes the query and streams the result.
 // The first QueryResult will have Fields set (and Rows nil).
 // The subsequent QueryResult will have Rows set (and Fields nil).
+// If TransactionId is set, the query is streamed from the transaction,
+// which is used to read from a consistent snapshot.
 func (sq *SqlQuery) StreamExecute(context context.Context, query *proto.Query, sendReply func(*mproto.QueryResult) error) (err error) {
-	// check cases we don't handle yet
-	if query.TransactionId != 0 {
-		return NewTabletError(FAIL, "Transactions not supported with streaming")
-	}
-
 	logStats := newSqlQueryStats("StreamExecute", context)
-	if err = sq.startRequest(query.SessionId, false); err != nil {
+	allowShutdown := (query.TransactionId != 0)
+	if err = sq.startRequest(query.SessionId, allowShutdown); err != nil {
 		return err
 	}
 	defer sq.endRequest()
@@ -362,6 +531,8 @@ func (sq *SqlQuery) StreamExecute(context context.Context, query *proto.Query, s
 // ExecuteBatch executes a group of queries and returns their results as a list.
 // ExecuteBatch can be called for an existing transaction, or it can also begin
 // its own transaction, in which case it's expected to commit it also.
+// Every query goes through Execute, so the query rules, and the
+// throttling, apply to each one of them.
 func (sq *SqlQuery) ExecuteBatch(context context.Context, queryList *proto.QueryList, reply *proto.QueryResultList) (err error) {
 	if len(queryList.Queries) == 0 {
 		return NewTabletError(FAIL, "Empty query list")
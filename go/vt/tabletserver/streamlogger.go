@@ -15,6 +15,7 @@ import (
 	log "github.com/golang/glog"
 	"github.com/youtube/vitess/go/sqltypes"
 	"github.com/youtube/vitess/go/streamlog"
+	"github.com/youtube/vitess/go/trace"
 	"github.com/youtube/vitess/go/vt/context"
 )
 
@@ -145,6 +146,12 @@ func (stats *SQLQueryStats) FmtQuerySources() string {
 	return strings.Join(sources[:n], ",")
 }
 
+// startSpan starts the trace span of a step of the query. It is nil
+// if the query is not traced.
+func (log *SQLQueryStats) startSpan(name string) *trace.Span {
+	return context.Span(log.context).NewChild(name)
+}
+
 func (log *SQLQueryStats) RemoteAddr() string {
 	return log.context.GetRemoteAddr()
 }
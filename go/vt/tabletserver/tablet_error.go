@@ -19,6 +19,7 @@ const (
 	FATAL
 	TX_POOL_FULL
 	NOT_IN_TX
+	THROTTLED
 )
 
 type TabletError struct {
@@ -68,6 +69,8 @@ func (te *TabletError) Error() string {
 		format = "tx_pool_full: %s"
 	case NOT_IN_TX:
 		format = "not_in_tx: %s"
+	case THROTTLED:
+		format = "throttled: %s"
 	}
 	return fmt.Sprintf(format, te.Message)
 }
@@ -82,6 +85,8 @@ func (te *TabletError) RecordStats() {
 		errorStats.Add("TxPoolFull", 1)
 	case NOT_IN_TX:
 		errorStats.Add("NotInTx", 1)
+	case THROTTLED:
+		errorStats.Add("Throttled", 1)
 	default:
 		switch te.SqlError {
 		case mysql.DUP_ENTRY:
@@ -21,6 +21,7 @@ const (
 	ERR_FATAL
 	ERR_TX_POOL_FULL
 	ERR_NOT_IN_TX
+	ERR_THROTTLED
 )
 
 const (
@@ -70,11 +71,30 @@ type TabletConn interface {
 	// be called after finishing the iteration over the channel to see if there were other errors.
 	StreamExecute(context context.Context, query string, bindVars map[string]interface{}, transactionId int64) (<-chan *mproto.QueryResult, ErrFunc)
 
+	// ExplainQuery describes how vttablet would execute the query.
+	ExplainQuery(context context.Context, query string, bindVars map[string]interface{}) (*tproto.QueryExplanation, error)
+
 	// Transaction support
 	Begin(context context.Context) (transactionId int64, err error)
 	Commit(context context.Context, transactionId int64) error
 	Rollback(context context.Context, transactionId int64) error
 
+	// BeginConsistentSnapshot starts a transaction with a consistent
+	// snapshot. StreamExecute can then read from the snapshot.
+	BeginConsistentSnapshot(context context.Context) (transactionId int64, err error)
+
+	// Two-phase commit support. The participants of a distributed
+	// transaction are prepared, then committed or rolled back. The
+	// coordinator records the transaction and the commit decision.
+	Prepare(context context.Context, transactionId int64, dtid string) error
+	CommitPrepared(context context.Context, dtid string) error
+	RollbackPrepared(context context.Context, dtid string, transactionId int64) error
+	CreateTransaction(context context.Context, dtid string, participants []tproto.TxParticipant) error
+	StartCommit(context context.Context, transactionId int64, dtid string) error
+	SetRollback(context context.Context, dtid string, transactionId int64) error
+	ConcludeTransaction(context context.Context, dtid string) error
+	UnresolvedTransactions(context context.Context, abandonAge time.Duration) ([]tproto.DistributedTransaction, error)
+
 	// Close must be called for releasing resources.
 	Close()
 
@@ -0,0 +1,198 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/tabletserver/planbuilder"
+)
+
+// Throttler applies the limits of the THROTTLE query rules. The
+// limits are tracked per rule and per throttle key, so a rule keyed
+// by user gives every user its own rate and concurrency budget.
+// The configuration comes from the rules every time, so changing
+// the rules changes the limits right away.
+type Throttler struct {
+	mu      sync.Mutex
+	buckets map[string]*throttleBucket
+	sweepAt int
+
+	waits      *stats.Timings
+	rejections *stats.Counters
+}
+
+// throttleBucket tracks the queries of one throttle key.
+type throttleBucket struct {
+	// config is the configuration of the last query.
+	config   *ThrottleConfig
+	inFlight int
+	waiting  int
+
+	// tokens is the rate budget, refilled at MaxQPS per second.
+	tokens     float64
+	lastRefill time.Time
+
+	// released is closed and replaced every time a query is done,
+	// to wake up the waiting queries.
+	released chan struct{}
+}
+
+// NewThrottler creates a new Throttler.
+func NewThrottler(name string) *Throttler {
+	th := &Throttler{buckets: make(map[string]*throttleBucket), sweepAt: minThrottleSweep}
+	if name != "" {
+		th.waits = stats.NewTimings(name + "Waits")
+		th.rejections = stats.NewCounters(name + "Rejections")
+	} else {
+		th.waits = stats.NewTimings("")
+		th.rejections = stats.NewCounters("")
+	}
+	return th
+}
+
+// Throttle waits until the query fits in the limits of all the rules,
+// and returns the function to call once the query is done. It panics
+// with a THROTTLED error if the query is still over a limit after the
+// QueueTimeout of the rule.
+func (th *Throttler) Throttle(rules []*QueryRule, user, table string, plan planbuilder.PlanType) (done func()) {
+	var keys []string
+	release := func() {
+		for _, key := range keys {
+			th.release(key)
+		}
+	}
+	for _, qr := range rules {
+		key := throttleKey(qr, user, table, plan)
+		if !th.acquire(key, qr.throttle) {
+			release()
+			th.rejections.Add(qr.Name, 1)
+			panic(NewTabletError(THROTTLED, "Query throttled due to rule: %s", qr.Description))
+		}
+		keys = append(keys, key)
+	}
+	return release
+}
+
+// throttleKey returns the key of the bucket for the query.
+func throttleKey(qr *QueryRule, user, table string, plan planbuilder.PlanType) string {
+	parts := []string{qr.Name}
+	for _, k := range qr.throttle.KeyBy {
+		switch k {
+		case THROTTLE_BY_USER:
+			parts = append(parts, user)
+		case THROTTLE_BY_TABLE:
+			parts = append(parts, table)
+		case THROTTLE_BY_PLAN:
+			parts = append(parts, plan.String())
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// minThrottleSweep is the number of buckets that triggers the first
+// removal of the idle ones.
+const minThrottleSweep = 64
+
+func burst(config *ThrottleConfig) float64 {
+	return math.Max(1, config.MaxQPS)
+}
+
+func (b *throttleBucket) refill(config *ThrottleConfig, now time.Time) {
+	if config.MaxQPS == 0 {
+		return
+	}
+	b.tokens = math.Min(burst(config), b.tokens+now.Sub(b.lastRefill).Seconds()*config.MaxQPS)
+	b.lastRefill = now
+}
+
+// acquire waits for the bucket of key to be within the limits, and
+// returns false if it's still over them after QueueTimeout.
+func (th *Throttler) acquire(key string, config *ThrottleConfig) bool {
+	start := time.Now()
+	deadline := start.Add(config.QueueTimeout)
+
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	b, ok := th.buckets[key]
+	if !ok {
+		if len(th.buckets) >= th.sweepAt {
+			th.sweep(start)
+		}
+		b = &throttleBucket{tokens: burst(config), lastRefill: start, released: make(chan struct{})}
+		th.buckets[key] = b
+	}
+	b.config = config
+	for {
+		now := time.Now()
+		b.refill(config, now)
+		if (config.MaxConcurrency == 0 || b.inFlight < config.MaxConcurrency) && (config.MaxQPS == 0 || b.tokens >= 1) {
+			if config.MaxQPS != 0 {
+				b.tokens--
+			}
+			b.inFlight++
+			if now != start {
+				th.waits.Add(key, now.Sub(start))
+			}
+			return true
+		}
+
+		wait := deadline.Sub(now)
+		if wait <= 0 {
+			return false
+		}
+		if config.MaxQPS != 0 && b.tokens < 1 {
+			if next := time.Duration((1 - b.tokens) / config.MaxQPS * 1e9); next < wait {
+				wait = next
+			}
+		}
+
+		released := b.released
+		b.waiting++
+		th.mu.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-released:
+		case <-timer.C:
+		}
+		timer.Stop()
+		th.mu.Lock()
+		b.waiting--
+	}
+}
+
+func (th *Throttler) release(key string) {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	b, ok := th.buckets[key]
+	if !ok {
+		return
+	}
+	b.inFlight--
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// sweep removes the buckets that are back to their initial state,
+// so keys that are not used any more don't accumulate.
+func (th *Throttler) sweep(now time.Time) {
+	for key, b := range th.buckets {
+		if b.inFlight != 0 || b.waiting != 0 {
+			continue
+		}
+		b.refill(b.config, now)
+		if b.config.MaxQPS == 0 || b.tokens >= burst(b.config) {
+			delete(th.buckets, key)
+		}
+	}
+	th.sweepAt = 2 * len(th.buckets)
+	if th.sweepAt < minThrottleSweep {
+		th.sweepAt = minThrottleSweep
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/tabletserver/planbuilder"
+)
+
+func newThrottleRule(name string, config ThrottleConfig) *QueryRule {
+	qr := NewQueryRule(name, name, QR_THROTTLE)
+	if err := qr.SetThrottle(config); err != nil {
+		panic(err)
+	}
+	return qr
+}
+
+// throttle returns the done function, or nil if the query was throttled.
+func throttle(th *Throttler, rules []*QueryRule, user, table string) (done func()) {
+	defer func() {
+		if x := recover(); x != nil {
+			terr, ok := x.(*TabletError)
+			if !ok || terr.ErrorType != THROTTLED {
+				panic(x)
+			}
+			done = nil
+		}
+	}()
+	return th.Throttle(rules, user, table, planbuilder.PLAN_PASS_SELECT)
+}
+
+func TestThrottlerConcurrency(t *testing.T) {
+	th := NewThrottler("")
+	rules := []*QueryRule{newThrottleRule("c1", ThrottleConfig{MaxConcurrency: 2, KeyBy: []string{THROTTLE_BY_USER}})}
+
+	done1 := throttle(th, rules, "user1", "t")
+	done2 := throttle(th, rules, "user1", "t")
+	if done1 == nil || done2 == nil {
+		t.Fatalf("first two queries were throttled")
+	}
+	if throttle(th, rules, "user1", "t") != nil {
+		t.Errorf("third query was not throttled")
+	}
+	if th.rejections.Counts()["c1"] != 1 {
+		t.Errorf("want 1 rejection, got %v", th.rejections.Counts())
+	}
+
+	// other users have their own limit
+	done3 := throttle(th, rules, "user2", "t")
+	if done3 == nil {
+		t.Errorf("query from another user was throttled")
+	} else {
+		done3()
+	}
+
+	done1()
+	done3 = throttle(th, rules, "user1", "t")
+	if done3 == nil {
+		t.Errorf("query was throttled after release")
+	} else {
+		done3()
+	}
+	done2()
+}
+
+func TestThrottlerQueue(t *testing.T) {
+	th := NewThrottler("")
+	rules := []*QueryRule{newThrottleRule("q1", ThrottleConfig{MaxConcurrency: 1, QueueTimeout: 10 * time.Second})}
+
+	done1 := throttle(th, rules, "user1", "t")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		done1()
+	}()
+	start := time.Now()
+	done2 := throttle(th, rules, "user1", "t")
+	if done2 == nil {
+		t.Fatalf("queued query was throttled")
+	}
+	if d := time.Now().Sub(start); d < 10*time.Millisecond || d > 5*time.Second {
+		t.Errorf("queued query waited %v", d)
+	}
+	done2()
+}
+
+func TestThrottlerRate(t *testing.T) {
+	th := NewThrottler("")
+	rules := []*QueryRule{newThrottleRule("r1", ThrottleConfig{MaxQPS: 2, KeyBy: []string{THROTTLE_BY_TABLE}})}
+
+	// the burst is one second of queries
+	for i := 0; i < 2; i++ {
+		done := throttle(th, rules, "user1", "t")
+		if done == nil {
+			t.Fatalf("query %v was throttled", i)
+		}
+		done()
+	}
+	if throttle(th, rules, "user1", "t") != nil {
+		t.Errorf("query over the rate was not throttled")
+	}
+	if done := throttle(th, rules, "user1", "other"); done == nil {
+		t.Errorf("query on another table was throttled")
+	} else {
+		done()
+	}
+
+	// the bucket is refilled
+	time.Sleep(600 * time.Millisecond)
+	if done := throttle(th, rules, "user1", "t"); done == nil {
+		t.Errorf("query was throttled after refill")
+	} else {
+		done()
+	}
+}
+
+func TestThrottlerMultipleRules(t *testing.T) {
+	th := NewThrottler("")
+	r1 := newThrottleRule("m1", ThrottleConfig{MaxConcurrency: 2})
+	r2 := newThrottleRule("m2", ThrottleConfig{MaxConcurrency: 1})
+
+	done1 := throttle(th, []*QueryRule{r1}, "user1", "t")
+	done2 := throttle(th, []*QueryRule{r2}, "user1", "t")
+	if done1 == nil || done2 == nil {
+		t.Fatalf("query was throttled")
+	}
+
+	// over m2, the m1 slot is given back
+	if throttle(th, []*QueryRule{r1, r2}, "user1", "t") != nil {
+		t.Errorf("query over m2 was not throttled")
+	}
+	if done := throttle(th, []*QueryRule{r1}, "user1", "t"); done == nil {
+		t.Errorf("m1 slot was not released")
+	} else {
+		done()
+	}
+	done1()
+	done2()
+}
+
+func TestThrottlerSweep(t *testing.T) {
+	th := NewThrottler("")
+	rules := []*QueryRule{newThrottleRule("s1", ThrottleConfig{MaxConcurrency: 1, KeyBy: []string{THROTTLE_BY_USER}})}
+	held := throttle(th, rules, "held", "t")
+	for i := 0; i < 2*minThrottleSweep; i++ {
+		throttle(th, rules, fmt.Sprintf("user%v", i), "t")()
+	}
+	if len(th.buckets) > minThrottleSweep {
+		t.Errorf("idle buckets were not removed: %v", len(th.buckets))
+	}
+	if _, ok := th.buckets["s1/held"]; !ok {
+		t.Errorf("busy bucket was removed")
+	}
+	held()
+}
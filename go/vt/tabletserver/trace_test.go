@@ -0,0 +1,60 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/youtube/vitess/go/trace"
+	"github.com/youtube/vitess/go/vt/context"
+	"github.com/youtube/vitess/go/vt/dbconnpool"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+)
+
+func TestTraceSpans(t *testing.T) {
+	collector := trace.NewCollector(10)
+	trace.RegisterExporter(collector)
+	db := newFakeTwoPCDB()
+	qe := newTwoPCQueryEngine(db)
+	root := trace.StartRemoteSpan(1, 0, "TestTraceSpans")
+	logStats := newSqlQueryStats("TestTraceSpans", context.WithSpan(&context.DummyContext{}, root))
+
+	buf := sqlparser.NewTrackedBuffer(nil)
+	buf.Myprintf("select a from t where b = %a", "b")
+	conn := getOrPanic(qe.connPool)
+	qe.directFetch(logStats, conn, buf.ParsedQuery(), map[string]interface{}{"b": "secret"}, nil, nil)
+	conn.Recycle()
+	// the span of a call that panics is finished too
+	expectTabletError(t, "closed pool", "closed", func() {
+		getConnOrPanic(logStats, dbconnpool.NewConnectionPool("", 1, 0), "ConnPool.Get")
+	})
+	root.Finish()
+
+	traces := collector.Traces()
+	if len(traces) != 1 {
+		t.Fatalf("got %v traces, want 1", len(traces))
+	}
+	var names []string
+	for _, span := range traces[0] {
+		names = append(names, span.Name)
+		if span.Name != "MySQL.Execute" {
+			continue
+		}
+		query := span.Annotations()["query"]
+		if query != "select a from t where b = :b" {
+			t.Errorf("query annotation: got %q, want the normalized query", query)
+		}
+		for _, value := range span.Annotations() {
+			if strings.Contains(value, "secret") {
+				t.Errorf("annotation %q has the value of a bind variable", value)
+			}
+		}
+	}
+	want := "TestTraceSpans,MySQL.Execute,ConnPool.Get"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("got spans %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,619 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/dbconnpool"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/tabletserver/proto"
+)
+
+// The redo log (redo_state, redo_statement) keeps the statements of
+// the transactions prepared on this tablet. The coordinator log
+// (dt_state, dt_participant) keeps the distributed transactions this
+// tablet is the coordinator of.
+var createTwoPCTables = []string{
+	"CREATE DATABASE IF NOT EXISTS _vt",
+	`CREATE TABLE IF NOT EXISTS _vt.redo_state (
+  dtid VARBINARY(512) NOT NULL,
+  time_created BIGINT NOT NULL,
+  PRIMARY KEY (dtid)) ENGINE=InnoDB`,
+	`CREATE TABLE IF NOT EXISTS _vt.redo_statement (
+  dtid VARBINARY(512) NOT NULL,
+  id BIGINT NOT NULL,
+  statement MEDIUMBLOB NOT NULL,
+  PRIMARY KEY (dtid, id)) ENGINE=InnoDB`,
+	`CREATE TABLE IF NOT EXISTS _vt.dt_state (
+  dtid VARBINARY(512) NOT NULL,
+  state VARBINARY(16) NOT NULL,
+  time_created BIGINT NOT NULL,
+  PRIMARY KEY (dtid)) ENGINE=InnoDB`,
+	`CREATE TABLE IF NOT EXISTS _vt.dt_participant (
+  dtid VARBINARY(512) NOT NULL,
+  id BIGINT NOT NULL,
+  keyspace VARCHAR(256) NOT NULL,
+  shard VARCHAR(256) NOT NULL,
+  PRIMARY KEY (dtid, id)) ENGINE=InnoDB`,
+}
+
+// TwoPC keeps track of the transactions prepared on this tablet,
+// and manages the redo and coordinator logs.
+// Prepared transactions are not in the ActiveTxPool anymore: they
+// cannot receive queries, and the transaction killer ignores them.
+type TwoPC struct {
+	qe *QueryEngine
+
+	mu       sync.Mutex
+	prepared map[string]*TxConnection
+	// redoLogPending is set while the redo log of a master could
+	// not be read yet.
+	redoLogPending bool
+}
+
+// NewTwoPC creates a TwoPC for the QueryEngine.
+func NewTwoPC(qe *QueryEngine) *TwoPC {
+	return &TwoPC{
+		qe:       qe,
+		prepared: make(map[string]*TxConnection),
+	}
+}
+
+// PrepareFromRedoLog creates the _vt tables, and prepares again the
+// transactions of the redo log that are not prepared in memory. The
+// redo log of a slave is maintained by replication, so this is only
+// done when the tablet is master, and only with atomic commit.
+// While mysql is read-only, it returns an error, and new transactions
+// are refused until it is called again: the agent does it when the
+// master is made read-write, like at the end of a reparent.
+func (tpc *TwoPC) PrepareFromRedoLog() error {
+	if !tpc.qe.atomicCommit {
+		return nil
+	}
+	conn := getOrPanic(tpc.qe.connPool)
+	defer conn.Recycle()
+	qr, err := conn.ExecuteFetch("select @@global.read_only", 1, false)
+	if err != nil {
+		panic(NewTabletErrorSql(FAIL, err))
+	}
+	if len(qr.Rows) == 1 && qr.Rows[0][0].String() != "0" {
+		tpc.setRedoLogPending(true)
+		return fmt.Errorf("mysql is read-only, the redo log will be read once it is read-write")
+	}
+	for _, sql := range createTwoPCTables {
+		if _, err := conn.ExecuteFetch(sql, 0, false); err != nil {
+			panic(NewTabletErrorSql(FAIL, err))
+		}
+	}
+
+	qr, err = conn.ExecuteFetch("select dtid from _vt.redo_state", int(tpc.qe.maxResultSize.Get()), false)
+	if err != nil {
+		panic(NewTabletErrorSql(FAIL, err))
+	}
+	count := 0
+	for _, row := range qr.Rows {
+		dtid := row[0].String()
+		if tpc.isPrepared(dtid) {
+			continue
+		}
+		txc, err := tpc.replay(dtid)
+		if err != nil {
+			// CommitPrepared will try again.
+			log.Errorf("cannot prepare %v from the redo log: %v", dtid, err)
+			internalErrors.Add("TwoPCReplay", 1)
+			continue
+		}
+		if txc != nil {
+			tpc.add(dtid, txc)
+			count++
+		}
+	}
+	if count != 0 {
+		log.Infof("prepared %d transactions from the redo log", count)
+	}
+	tpc.setRedoLogPending(false)
+	return nil
+}
+
+// RedoLogPending returns true if the redo log of the master could not
+// be read yet.
+func (tpc *TwoPC) RedoLogPending() bool {
+	tpc.mu.Lock()
+	defer tpc.mu.Unlock()
+	return tpc.redoLogPending
+}
+
+func (tpc *TwoPC) setRedoLogPending(pending bool) {
+	tpc.mu.Lock()
+	defer tpc.mu.Unlock()
+	tpc.redoLogPending = pending
+}
+
+// Close closes the connections of the prepared transactions, mysql
+// rolls them back. They stay in the redo log.
+func (tpc *TwoPC) Close() {
+	tpc.mu.Lock()
+	defer tpc.mu.Unlock()
+	tpc.redoLogPending = false
+	for dtid, txc := range tpc.prepared {
+		log.Warningf("closing prepared transaction %v for shutdown", dtid)
+		txc.Close()
+		txc.discard(TX_CLOSE)
+	}
+	tpc.prepared = make(map[string]*TxConnection)
+}
+
+// add moves a transaction from the ActiveTxPool to the prepared ones.
+func (tpc *TwoPC) add(dtid string, txc *TxConnection) {
+	tpc.mu.Lock()
+	defer tpc.mu.Unlock()
+	if _, ok := tpc.prepared[dtid]; ok {
+		panic(NewTabletError(FAIL, "transaction %v is already prepared", dtid))
+	}
+	txc.pool.pool.Unregister(txc.TransactionID)
+	tpc.prepared[dtid] = txc
+}
+
+// isPrepared returns true if dtid is prepared in memory.
+func (tpc *TwoPC) isPrepared(dtid string) bool {
+	tpc.mu.Lock()
+	defer tpc.mu.Unlock()
+	_, ok := tpc.prepared[dtid]
+	return ok
+}
+
+// take returns the prepared transaction and forgets it,
+// or nil if dtid is not prepared in memory.
+func (tpc *TwoPC) take(dtid string) *TxConnection {
+	tpc.mu.Lock()
+	defer tpc.mu.Unlock()
+	txc, ok := tpc.prepared[dtid]
+	if !ok {
+		return nil
+	}
+	delete(tpc.prepared, dtid)
+	return txc
+}
+
+// replay starts a new transaction with the statements of the redo log
+// for dtid. It returns nil if dtid is not in the redo log. The redo
+// log is read in the transaction, with a lock: a concurrent replay of
+// dtid waits until this one is resolved, and then doesn't find it.
+func (tpc *TwoPC) replay(dtid string) (txc *TxConnection, err error) {
+	defer handleError(&err, nil)
+	txConn := getOrPanic(tpc.qe.txPool)
+	transactionID, err := tpc.qe.activeTxPool.SafeBegin(txConn)
+	if err != nil {
+		txConn.Recycle()
+		return nil, err
+	}
+	txc = tpc.qe.activeTxPool.Get(transactionID)
+	rollback := func() {
+		txc.Recycle()
+		tpc.qe.activeTxPool.Rollback(transactionID)
+	}
+
+	qr, err := txc.ExecuteFetch(buildTwoPCQuery("select dtid from _vt.redo_state where dtid = %v for update", dtid), 1, false)
+	if err != nil {
+		rollback()
+		return nil, NewTabletErrorSql(FAIL, err)
+	}
+	if len(qr.Rows) == 0 {
+		rollback()
+		return nil, nil
+	}
+	qr, err = txc.ExecuteFetch(buildTwoPCQuery("select statement from _vt.redo_statement where dtid = %v order by id", dtid), int(tpc.qe.maxResultSize.Get()), false)
+	if err != nil {
+		rollback()
+		return nil, NewTabletErrorSql(FAIL, err)
+	}
+	for _, row := range qr.Rows {
+		sql := row[0].String()
+		txc.RecordQuery(sql)
+		txc.RecordRedo(sql)
+		if _, err := txc.ExecuteFetch(sql, int(tpc.qe.maxResultSize.Get()), false); err != nil {
+			rollback()
+			return nil, NewTabletErrorSql(FAIL, err)
+		}
+	}
+	return txc, nil
+}
+
+// saveRedo writes the statements of the transaction to the redo log.
+func (tpc *TwoPC) saveRedo(dtid string, statements []string) {
+	sqls := []string{
+		buildTwoPCQuery("insert into _vt.redo_state(dtid, time_created) values (%v, %v)", dtid, time.Now().UnixNano()),
+	}
+	if len(statements) != 0 {
+		values := make([]string, len(statements))
+		for i, statement := range statements {
+			values[i] = buildTwoPCQuery("(%v, %v, %v)", dtid, int64(i), statement)
+		}
+		sqls = append(sqls, "insert into _vt.redo_statement(dtid, id, statement) values "+strings.Join(values, ", "))
+	}
+	tpc.execInTransaction(sqls)
+}
+
+// deleteRedoStatements returns the statements that remove dtid
+// from the redo log.
+func (tpc *TwoPC) deleteRedoStatements(dtid string) []string {
+	return []string{
+		buildTwoPCQuery("delete from _vt.redo_state where dtid = %v", dtid),
+		buildTwoPCQuery("delete from _vt.redo_statement where dtid = %v", dtid),
+	}
+}
+
+// execInTransaction executes the statements in a transaction
+// on a connection of the pool.
+func (tpc *TwoPC) execInTransaction(sqls []string) []*mproto.QueryResult {
+	conn := getOrPanic(tpc.qe.connPool)
+	defer conn.Recycle()
+	if _, err := conn.ExecuteFetch(BEGIN, 1, false); err != nil {
+		panic(NewTabletErrorSql(FAIL, err))
+	}
+	results := make([]*mproto.QueryResult, len(sqls))
+	for i, sql := range sqls {
+		qr, err := conn.ExecuteFetch(sql, int(tpc.qe.maxResultSize.Get()), false)
+		if err != nil {
+			rollbackConn(conn)
+			panic(NewTabletErrorSql(FAIL, err))
+		}
+		results[i] = qr
+	}
+	if _, err := conn.ExecuteFetch(COMMIT, 1, false); err != nil {
+		conn.Close()
+		panic(NewTabletErrorSql(FAIL, err))
+	}
+	return results
+}
+
+// execStatement executes an autocommitted statement on a connection
+// of the pool.
+func (tpc *TwoPC) execStatement(sql string) *mproto.QueryResult {
+	conn := getOrPanic(tpc.qe.connPool)
+	defer conn.Recycle()
+	qr, err := conn.ExecuteFetch(sql, int(tpc.qe.maxResultSize.Get()), false)
+	if err != nil {
+		panic(NewTabletErrorSql(FAIL, err))
+	}
+	return qr
+}
+
+func rollbackConn(conn dbconnpool.PoolConnection) {
+	if _, err := conn.ExecuteFetch(ROLLBACK, 1, false); err != nil {
+		conn.Close()
+	}
+}
+
+// buildTwoPCQuery formats a query on the two-phase commit tables.
+// The args are encoded as sql values.
+func buildTwoPCQuery(format string, args ...interface{}) string {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		buf := bytes.NewBuffer(nil)
+		if err := sqlparser.EncodeValue(buf, arg); err != nil {
+			panic(NewTabletError(FAIL, "%v", err))
+		}
+		values[i] = buf.String()
+	}
+	return fmt.Sprintf(format, values...)
+}
+
+// timeFuncs are the functions that return the time of the statement
+// when called without arguments. The DMLs of a transaction are
+// executed with their value instead, so they do the same when they
+// are replayed from the redo log. The ones that are true can also be
+// called without parentheses.
+var timeFuncs = map[string]bool{
+	"now":               false,
+	"current_timestamp": true,
+	"localtime":         true,
+	"localtimestamp":    true,
+	"sysdate":           false,
+	"curdate":           false,
+	"current_date":      true,
+	"curtime":           false,
+	"current_time":      true,
+	"utc_date":          true,
+	"utc_time":          true,
+	"utc_timestamp":     true,
+	"unix_timestamp":    false,
+}
+
+// perRowFuncs return a different value for every row when called
+// without arguments, so they can't be replaced by a value. A
+// transaction that uses them can't be prepared.
+var perRowFuncs = map[string]bool{
+	"rand":       true,
+	"uuid":       true,
+	"uuid_short": true,
+}
+
+// funcCall is the position of a function call without arguments
+// in a statement.
+type funcCall struct {
+	name       string
+	start, end int
+}
+
+// findFuncCalls returns the function calls without arguments of sql,
+// and the time functions called without parentheses.
+func findFuncCalls(sql string) []funcCall {
+	var calls []funcCall
+	var call funcCall
+	// state is the number of tokens of the call matched so far
+	state := 0
+	// endName ends a call that may not have parentheses
+	endName := func() {
+		if state == 1 && timeFuncs[call.name] {
+			calls = append(calls, call)
+		}
+	}
+	tokenizer := sqlparser.NewStringTokenizer(sql)
+	for {
+		typ, val := tokenizer.Scan()
+		// the tokenizer is one character past the token
+		end := tokenizer.Position - 1
+		switch {
+		case typ == 0 || typ == sqlparser.LEX_ERROR:
+			endName()
+			return calls
+		case typ == sqlparser.COMMENT:
+			continue
+		case typ == sqlparser.ID && end >= len(val) && sql[end-len(val):end] == string(val):
+			endName()
+			call = funcCall{name: strings.ToLower(string(val)), start: end - len(val), end: end}
+			state = 1
+		case typ == '(' && state == 1:
+			state = 2
+		case typ == ')' && state == 2:
+			call.end = end
+			calls = append(calls, call)
+			state = 0
+		default:
+			endName()
+			state = 0
+		}
+	}
+}
+
+// bindTimeFuncs replaces the calls to the time functions in sql by
+// their current value, computed on conn. It also returns the name of
+// the first per-row function sql calls, if any.
+func bindTimeFuncs(conn dbconnpool.PoolConnection, sql string) (string, string, error) {
+	perRow := ""
+	var calls []funcCall
+	for _, call := range findFuncCalls(sql) {
+		_, isTimeFunc := timeFuncs[call.name]
+		switch {
+		case isTimeFunc:
+			calls = append(calls, call)
+		case perRowFuncs[call.name] && perRow == "":
+			perRow = call.name
+		}
+	}
+	if len(calls) == 0 {
+		return sql, perRow, nil
+	}
+
+	exprs := make([]string, len(calls))
+	for i, call := range calls {
+		exprs[i] = sql[call.start:call.end]
+	}
+	qr, err := conn.ExecuteFetch("select "+strings.Join(exprs, ", "), 1, false)
+	if err != nil {
+		return "", "", err
+	}
+	if len(qr.Rows) != 1 || len(qr.Rows[0]) != len(calls) {
+		return "", "", fmt.Errorf("unexpected result for the values of %v", strings.Join(exprs, ", "))
+	}
+	buf := bytes.NewBuffer(nil)
+	last := 0
+	for i, call := range calls {
+		buf.WriteString(sql[last:call.start])
+		qr.Rows[0][i].EncodeSql(buf)
+		last = call.end
+	}
+	buf.WriteString(sql[last:])
+	return buf.String(), perRow, nil
+}
+
+// generatesIds returns true if one of the rows leaves the value of
+// the auto-increment column of the primary key to mysql: the ids
+// would be generated again when the statement is replayed, and may
+// not be the same.
+func generatesIds(tableInfo *TableInfo, pkRows [][]sqltypes.Value) bool {
+	for i, columnIndex := range tableInfo.PKColumns {
+		if !tableInfo.Columns[columnIndex].IsAuto {
+			continue
+		}
+		for _, pkRow := range pkRows {
+			if pkRow[i].IsNull() || pkRow[i].String() == "0" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Prepare writes the statements of the transaction to the redo log,
+// and keeps it open until CommitPrepared or RollbackPrepared are called
+// for dtid.
+func (qe *QueryEngine) Prepare(logStats *SQLQueryStats, transactionID int64, dtid string) {
+	defer queryStats.Record("PREPARE", time.Now())
+	txc := qe.activeTxPool.Get(transactionID)
+	prepared := false
+	defer func() {
+		if !prepared {
+			txc.Recycle()
+		}
+	}()
+	if !qe.atomicCommit {
+		panic(NewTabletError(FAIL, "cannot prepare %v: atomic commit is not enabled on this tablet", dtid))
+	}
+	if txc.unreplayable != "" {
+		panic(NewTabletError(FAIL, "cannot prepare %v, this statement would do something else when replayed: %v", dtid, txc.unreplayable))
+	}
+	qe.twoPC.saveRedo(dtid, txc.redoStatements)
+	qe.twoPC.add(dtid, txc)
+	prepared = true
+}
+
+// CommitPrepared commits a prepared transaction, and removes it from
+// the redo log in the same transaction. If the transaction is only in
+// the redo log, it is replayed first. It does nothing if dtid was
+// already committed or rolled back.
+func (qe *QueryEngine) CommitPrepared(logStats *SQLQueryStats, dtid string) {
+	defer queryStats.Record("COMMIT_PREPARED", time.Now())
+	txc := qe.twoPC.take(dtid)
+	if txc == nil {
+		var err error
+		if txc, err = qe.twoPC.replay(dtid); err != nil {
+			panic(err)
+		}
+		if txc == nil {
+			return
+		}
+	}
+	defer txc.discard(TX_COMMIT)
+	qe.activeTxPool.txStats.Add("Completed", time.Now().Sub(txc.StartTime))
+	for _, sql := range qe.twoPC.deleteRedoStatements(dtid) {
+		if _, err := txc.ExecuteFetch(sql, 1, false); err != nil {
+			txc.Close()
+			panic(NewTabletErrorSql(FAIL, err))
+		}
+	}
+	if _, err := txc.ExecuteFetch(COMMIT, 1, false); err != nil {
+		txc.Close()
+		panic(NewTabletErrorSql(FAIL, err))
+	}
+	qe.invalidateRows(logStats, txc.dirtyTables)
+}
+
+// RollbackPrepared removes dtid from the redo log, and rolls back
+// the prepared transaction. If the transaction was not prepared yet,
+// it is rolled back using transactionID, if set.
+func (qe *QueryEngine) RollbackPrepared(logStats *SQLQueryStats, dtid string, transactionID int64) {
+	defer queryStats.Record("ROLLBACK_PREPARED", time.Now())
+	qe.twoPC.execInTransaction(qe.twoPC.deleteRedoStatements(dtid))
+	txc := qe.twoPC.take(dtid)
+	if txc == nil {
+		if transactionID != 0 {
+			qe.activeTxPool.Rollback(transactionID)
+		}
+		return
+	}
+	defer txc.discard(TX_ROLLBACK)
+	qe.activeTxPool.txStats.Add("Aborted", time.Now().Sub(txc.StartTime))
+	if _, err := txc.ExecuteFetch(ROLLBACK, 1, false); err != nil {
+		txc.Close()
+		panic(NewTabletErrorSql(FAIL, err))
+	}
+}
+
+// CreateTransaction records a new distributed transaction in the
+// coordinator log, in the PREPARE state.
+func (qe *QueryEngine) CreateTransaction(logStats *SQLQueryStats, dtid string, participants []proto.TxParticipant) {
+	defer queryStats.Record("CREATE_TRANSACTION", time.Now())
+	sqls := []string{
+		buildTwoPCQuery("insert into _vt.dt_state(dtid, state, time_created) values (%v, %v, %v)", dtid, proto.DT_PREPARE, time.Now().UnixNano()),
+	}
+	if len(participants) != 0 {
+		values := make([]string, len(participants))
+		for i, participant := range participants {
+			values[i] = buildTwoPCQuery("(%v, %v, %v, %v)", dtid, int64(i), participant.Keyspace, participant.Shard)
+		}
+		sqls = append(sqls, "insert into _vt.dt_participant(dtid, id, keyspace, shard) values "+strings.Join(values, ", "))
+	}
+	qe.twoPC.execInTransaction(sqls)
+}
+
+// StartCommit moves dtid to the COMMIT state as part of the
+// transaction, and commits the transaction. This is the commit
+// decision of the distributed transaction. It fails if dtid is
+// not in the PREPARE state anymore.
+func (qe *QueryEngine) StartCommit(logStats *SQLQueryStats, transactionID int64, dtid string) {
+	defer queryStats.Record("START_COMMIT", time.Now())
+	txc := qe.activeTxPool.Get(transactionID)
+	sql := buildTwoPCQuery("update _vt.dt_state set state = %v where dtid = %v and state = %v", proto.DT_COMMIT, dtid, proto.DT_PREPARE)
+	txc.RecordQuery(sql)
+	qr, err := txc.ExecuteFetch(sql, 1, false)
+	txc.Recycle()
+	if err != nil {
+		panic(NewTabletErrorSql(FAIL, err))
+	}
+	if qr.RowsAffected != 1 {
+		qe.activeTxPool.Rollback(transactionID)
+		panic(NewTabletError(FAIL, "cannot commit %v: it is not in the %v state", dtid, proto.DT_PREPARE))
+	}
+	qe.Commit(logStats, transactionID)
+}
+
+// SetRollback moves dtid to the ROLLBACK state, and rolls back
+// transactionID if it is set. It fails if dtid is committing.
+func (qe *QueryEngine) SetRollback(logStats *SQLQueryStats, dtid string, transactionID int64) {
+	defer queryStats.Record("SET_ROLLBACK", time.Now())
+	if transactionID != 0 {
+		defer qe.activeTxPool.Rollback(transactionID)
+	}
+	qr := qe.twoPC.execStatement(buildTwoPCQuery("update _vt.dt_state set state = %v where dtid = %v and state = %v", proto.DT_ROLLBACK, dtid, proto.DT_PREPARE))
+	if qr.RowsAffected != 0 {
+		return
+	}
+	qr = qe.twoPC.execStatement(buildTwoPCQuery("select state from _vt.dt_state where dtid = %v", dtid))
+	if len(qr.Rows) == 1 && qr.Rows[0][0].String() == proto.DT_COMMIT {
+		panic(NewTabletError(FAIL, "cannot roll back %v: it is committing", dtid))
+	}
+}
+
+// ConcludeTransaction removes dtid from the coordinator log.
+func (qe *QueryEngine) ConcludeTransaction(logStats *SQLQueryStats, dtid string) {
+	defer queryStats.Record("CONCLUDE_TRANSACTION", time.Now())
+	qe.twoPC.execInTransaction([]string{
+		buildTwoPCQuery("delete from _vt.dt_state where dtid = %v", dtid),
+		buildTwoPCQuery("delete from _vt.dt_participant where dtid = %v", dtid),
+	})
+}
+
+// UnresolvedTransactions returns the distributed transactions of the
+// coordinator log that were created more than abandonAge ago.
+func (qe *QueryEngine) UnresolvedTransactions(logStats *SQLQueryStats, abandonAge time.Duration) []proto.DistributedTransaction {
+	defer queryStats.Record("UNRESOLVED_TRANSACTIONS", time.Now())
+	results := qe.twoPC.execInTransaction([]string{
+		buildTwoPCQuery("select dtid, state, time_created from _vt.dt_state where time_created < %v order by dtid", time.Now().Add(-abandonAge).UnixNano()),
+		"select dtid, keyspace, shard from _vt.dt_participant order by dtid, id",
+	})
+	participants := make(map[string][]proto.TxParticipant)
+	for _, row := range results[1].Rows {
+		dtid := row[0].String()
+		participants[dtid] = append(participants[dtid], proto.TxParticipant{
+			Keyspace: row[1].String(),
+			Shard:    row[2].String(),
+		})
+	}
+	transactions := make([]proto.DistributedTransaction, 0, len(results[0].Rows))
+	for _, row := range results[0].Rows {
+		timeCreated, err := row[2].ParseInt64()
+		if err != nil {
+			panic(NewTabletError(FAIL, "invalid time_created for %v: %v", row[0].String(), err))
+		}
+		dtid := row[0].String()
+		transactions = append(transactions, proto.DistributedTransaction{
+			Dtid:         dtid,
+			State:        row[1].String(),
+			TimeCreated:  timeCreated,
+			Participants: participants[dtid],
+		})
+	}
+	return transactions
+}
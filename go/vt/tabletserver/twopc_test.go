@@ -0,0 +1,571 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/pools"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/timer"
+	"github.com/youtube/vitess/go/vt/context"
+	"github.com/youtube/vitess/go/vt/dbconnpool"
+	"github.com/youtube/vitess/go/vt/schema"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/tabletserver/planbuilder"
+	"github.com/youtube/vitess/go/vt/tabletserver/proto"
+)
+
+func TestBuildTwoPCQuery(t *testing.T) {
+	testCases := []struct {
+		format string
+		args   []interface{}
+		want   string
+	}{
+		{"delete from _vt.redo_state where dtid = %v", []interface{}{"ks:0:1"}, "delete from _vt.redo_state where dtid = 'ks:0:1'"},
+		{"(%v, %v, %v)", []interface{}{"ks:0:1", int64(2), "update a set b = 'x' where c = 1"}, "('ks:0:1', 2, 'update a set b = \\'x\\' where c = 1')"},
+		{"select dtid from _vt.dt_state where time_created < %v", []interface{}{int64(-5)}, "select dtid from _vt.dt_state where time_created < -5"},
+	}
+	for _, tc := range testCases {
+		if got := buildTwoPCQuery(tc.format, tc.args...); got != tc.want {
+			t.Errorf("buildTwoPCQuery(%v): got %v, want %v", tc.format, got, tc.want)
+		}
+	}
+}
+
+// fakeTwoPCDB records the queries of all its connections, and
+// answers them with the results set for them, or an empty result.
+type fakeTwoPCDB struct {
+	mu      sync.Mutex
+	queries []string
+	results map[string]*mproto.QueryResult
+	lastId  int64
+}
+
+func newFakeTwoPCDB() *fakeTwoPCDB {
+	return &fakeTwoPCDB{results: make(map[string]*mproto.QueryResult)}
+}
+
+func (db *fakeTwoPCDB) setResult(query string, values ...string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	qr := &mproto.QueryResult{}
+	for _, v := range values {
+		qr.Rows = append(qr.Rows, []sqltypes.Value{sqltypes.MakeString([]byte(v))})
+	}
+	qr.RowsAffected = uint64(len(values))
+	db.results[query] = qr
+}
+
+func (db *fakeTwoPCDB) fetch(query string) (*mproto.QueryResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.queries = append(db.queries, query)
+	if qr, ok := db.results[query]; ok {
+		return qr, nil
+	}
+	return &mproto.QueryResult{}, nil
+}
+
+// takeQueries returns the queries run since the last call. The
+// current time in the redo and coordinator logs is replaced by "T".
+func (db *fakeTwoPCDB) takeQueries() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var result []string
+	for _, query := range db.queries {
+		if strings.HasPrefix(query, "insert into _vt.redo_state(") {
+			query = query[:strings.LastIndex(query, ", ")] + ", T)"
+		}
+		result = append(result, query)
+	}
+	db.queries = nil
+	return result
+}
+
+func (db *fakeTwoPCDB) connect(cp *dbconnpool.ConnectionPool) (dbconnpool.PoolConnection, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.lastId++
+	return &fakeTwoPCConn{db: db, pool: cp, id: db.lastId}, nil
+}
+
+type fakeTwoPCConn struct {
+	db     *fakeTwoPCDB
+	pool   *dbconnpool.ConnectionPool
+	id     int64
+	closed bool
+}
+
+func (conn *fakeTwoPCConn) ExecuteFetch(query string, maxrows int, wantfields bool) (*mproto.QueryResult, error) {
+	if conn.closed {
+		return nil, fmt.Errorf("connection %v is closed", conn.id)
+	}
+	return conn.db.fetch(query)
+}
+
+func (conn *fakeTwoPCConn) ExecuteStreamFetch(query string, callback func(*mproto.QueryResult) error, streamBufferSize int) error {
+	return fmt.Errorf("not supported")
+}
+
+func (conn *fakeTwoPCConn) Id() int64 {
+	return conn.id
+}
+
+func (conn *fakeTwoPCConn) Close() {
+	conn.closed = true
+}
+
+func (conn *fakeTwoPCConn) IsClosed() bool {
+	return conn.closed
+}
+
+func (conn *fakeTwoPCConn) Recycle() {
+	if conn.closed {
+		conn.pool.Put(nil)
+	} else {
+		conn.pool.Put(conn)
+	}
+}
+
+var (
+	testQueryEngineOnce sync.Once
+	testQueryEngineMain *QueryEngine
+)
+
+// testQueryEngine returns the QueryEngine of the tests. NewQueryEngine
+// publishes its stats, it can only be called once.
+func testQueryEngine() *QueryEngine {
+	testQueryEngineOnce.Do(func() {
+		testQueryEngineMain = NewQueryEngine(qsConfig)
+	})
+	return testQueryEngineMain
+}
+
+// newTwoPCQueryEngine returns a QueryEngine with the pools used by
+// the two-phase commit, opened on db.
+func newTwoPCQueryEngine(db *fakeTwoPCDB) *QueryEngine {
+	base := testQueryEngine()
+	qe := &QueryEngine{
+		schemaInfo: base.schemaInfo,
+		activePool: base.activePool,
+		connPool:   dbconnpool.NewConnectionPool("", 2, 0),
+		txPool:     dbconnpool.NewConnectionPool("", 2, 0),
+		activeTxPool: &ActiveTxPool{
+			pool:    pools.NewNumbered(),
+			lastId:  sync2.AtomicInt64(1000),
+			timeout: sync2.AtomicDuration(time.Minute),
+			ticks:   timer.NewTimer(time.Minute),
+			txStats: base.activeTxPool.txStats,
+		},
+		maxResultSize: sync2.AtomicInt64(10000),
+		atomicCommit:  true,
+	}
+	qe.twoPC = NewTwoPC(qe)
+	qe.connPool.Open(db.connect)
+	qe.txPool.Open(db.connect)
+	return qe
+}
+
+// begin starts a transaction that ran the DMLs.
+func begin(t *testing.T, qe *QueryEngine, dmls ...string) int64 {
+	transactionID, err := qe.activeTxPool.SafeBegin(getOrPanic(qe.txPool))
+	if err != nil {
+		t.Fatalf("SafeBegin failed: %v", err)
+	}
+	txc := qe.activeTxPool.Get(transactionID)
+	for _, dml := range dmls {
+		txc.RecordRedo(dml)
+	}
+	txc.Recycle()
+	return transactionID
+}
+
+// expectTabletError runs f, and checks it panics with a TabletError
+// that contains want.
+func expectTabletError(t *testing.T, name string, want string, f func()) {
+	defer func() {
+		x := recover()
+		terr, ok := x.(*TabletError)
+		if !ok {
+			t.Errorf("%v: got %v, want a TabletError", name, x)
+			return
+		}
+		if !strings.Contains(terr.Error(), want) {
+			t.Errorf("%v: got %v, want %v", name, terr, want)
+		}
+	}()
+	f()
+}
+
+func checkQueries(t *testing.T, name string, db *fakeTwoPCDB, want []string) {
+	if got := db.takeQueries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("%v ran:\n%v\nwant:\n%v", name, strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPrepareCommitPrepared(t *testing.T) {
+	db := newFakeTwoPCDB()
+	qe := newTwoPCQueryEngine(db)
+	logStats := newSqlQueryStats("TestPrepare", &context.DummyContext{})
+
+	transactionID := begin(t, qe, "update a set b = 1 where c = 2", "delete from a where c = 3")
+	qe.Prepare(logStats, transactionID, "ks:0:1")
+	checkQueries(t, "Prepare", db, []string{
+		"begin",
+		"begin",
+		"insert into _vt.redo_state(dtid, time_created) values ('ks:0:1', T)",
+		"insert into _vt.redo_statement(dtid, id, statement) values ('ks:0:1', 0, 'update a set b = 1 where c = 2'), ('ks:0:1', 1, 'delete from a where c = 3')",
+		"commit",
+	})
+	if !qe.twoPC.isPrepared("ks:0:1") {
+		t.Errorf("ks:0:1 is not prepared")
+	}
+	// the prepared transaction cannot receive queries anymore
+	expectTabletError(t, "Get", "not found", func() {
+		qe.activeTxPool.Get(transactionID)
+	})
+	expectTabletError(t, "Prepare again", "already prepared", func() {
+		qe.Prepare(logStats, begin(t, qe), "ks:0:1")
+	})
+	db.takeQueries()
+
+	qe.CommitPrepared(logStats, "ks:0:1")
+	checkQueries(t, "CommitPrepared", db, []string{
+		"delete from _vt.redo_state where dtid = 'ks:0:1'",
+		"delete from _vt.redo_statement where dtid = 'ks:0:1'",
+		"commit",
+	})
+	if qe.twoPC.isPrepared("ks:0:1") {
+		t.Errorf("ks:0:1 is still prepared")
+	}
+}
+
+func TestPrepareUnreplayable(t *testing.T) {
+	db := newFakeTwoPCDB()
+	qe := newTwoPCQueryEngine(db)
+	logStats := newSqlQueryStats("TestPrepare", &context.DummyContext{})
+
+	db.setResult("select now()", "2014-09-12 10:00:00")
+	transactionID := begin(t, qe)
+	txc := qe.activeTxPool.Get(transactionID)
+	qe.dmlFetch(logStats, txc, &sqlparser.ParsedQuery{Query: "update a set b = now() where c = 2"}, map[string]interface{}{}, nil, nil)
+	qe.dmlFetch(logStats, txc, &sqlparser.ParsedQuery{Query: "insert into a values (rand())"}, map[string]interface{}{}, nil, nil)
+	txc.Recycle()
+	checkQueries(t, "dmlFetch", db, []string{
+		"begin",
+		"select now()",
+		"update a set b = '2014-09-12 10:00:00' where c = 2",
+		"insert into a values (rand())",
+	})
+	if want := []string{"update a set b = '2014-09-12 10:00:00' where c = 2", "insert into a values (rand())"}; !reflect.DeepEqual(txc.redoStatements, want) {
+		t.Errorf("redo statements: %v, want %v", txc.redoStatements, want)
+	}
+
+	expectTabletError(t, "Prepare", "insert into a values (rand())", func() {
+		qe.Prepare(logStats, transactionID, "ks:0:1")
+	})
+	if qe.twoPC.isPrepared("ks:0:1") {
+		t.Errorf("ks:0:1 should not be prepared")
+	}
+	// the transaction is still there, and can be rolled back
+	qe.activeTxPool.Rollback(transactionID)
+	db.takeQueries()
+
+	// without atomic commit, DMLs are executed as they are
+	qe.atomicCommit = false
+	transactionID = begin(t, qe)
+	txc = qe.activeTxPool.Get(transactionID)
+	qe.dmlFetch(logStats, txc, &sqlparser.ParsedQuery{Query: "update a set b = now() where c = 2"}, map[string]interface{}{}, nil, nil)
+	txc.Recycle()
+	checkQueries(t, "dmlFetch without atomic commit", db, []string{
+		"begin",
+		"update a set b = now() where c = 2",
+	})
+	if len(txc.redoStatements) != 0 {
+		t.Errorf("redo statements: %v, want none", txc.redoStatements)
+	}
+	expectTabletError(t, "Prepare without atomic commit", "atomic commit is not enabled", func() {
+		qe.Prepare(logStats, transactionID, "ks:0:2")
+	})
+	qe.activeTxPool.Rollback(transactionID)
+}
+
+func TestPrepareGeneratedIds(t *testing.T) {
+	db := newFakeTwoPCDB()
+	qe := newTwoPCQueryEngine(db)
+	logStats := newSqlQueryStats("TestPrepare", &context.DummyContext{})
+	tableInfo := &TableInfo{Table: &schema.Table{
+		Name:      "a",
+		Columns:   []schema.TableColumn{{Name: "id", Category: schema.CAT_NUMBER, IsAuto: true}, {Name: "b"}},
+		Indexes:   []*schema.Index{{Name: "PRIMARY", Columns: []string{"id"}}},
+		PKColumns: []int{0},
+	}}
+	plan := func(sql string) *compiledPlan {
+		return &compiledPlan{
+			Query: sql,
+			ExecPlan: &ExecPlan{
+				ExecPlan:  &planbuilder.ExecPlan{OuterQuery: &sqlparser.ParsedQuery{Query: sql}},
+				TableInfo: tableInfo,
+			},
+			BindVars: map[string]interface{}{},
+		}
+	}
+
+	testCases := []struct {
+		sql          string
+		id           sqltypes.Value
+		unreplayable bool
+	}{
+		{"insert into a(id, b) values (5, 1)", sqltypes.MakeNumeric([]byte("5")), false},
+		{"insert into a(b) values (1)", sqltypes.Value{}, true},
+		{"insert into a(id, b) values (0, 1)", sqltypes.MakeNumeric([]byte("0")), true},
+	}
+	for _, tc := range testCases {
+		transactionID := begin(t, qe)
+		txc := qe.activeTxPool.Get(transactionID)
+		qe.execInsertPKRows(logStats, txc, plan(tc.sql), [][]sqltypes.Value{{tc.id}}, nil)
+		txc.Recycle()
+		if got := txc.unreplayable != ""; got != tc.unreplayable {
+			t.Errorf("%v: unreplayable = %v, want %v", tc.sql, got, tc.unreplayable)
+		}
+		if tc.unreplayable {
+			expectTabletError(t, "Prepare", tc.sql, func() {
+				qe.Prepare(logStats, transactionID, "ks:0:1")
+			})
+		}
+		qe.activeTxPool.Rollback(transactionID)
+	}
+}
+
+func TestCommitPreparedFromRedoLog(t *testing.T) {
+	db := newFakeTwoPCDB()
+	qe := newTwoPCQueryEngine(db)
+	logStats := newSqlQueryStats("TestCommitPrepared", &context.DummyContext{})
+
+	db.setResult("select dtid from _vt.redo_state where dtid = 'ks:0:1' for update", "ks:0:1")
+	db.setResult("select statement from _vt.redo_statement where dtid = 'ks:0:1' order by id", "update a set b = 1 where c = 2")
+	qe.CommitPrepared(logStats, "ks:0:1")
+	checkQueries(t, "CommitPrepared", db, []string{
+		"begin",
+		"select dtid from _vt.redo_state where dtid = 'ks:0:1' for update",
+		"select statement from _vt.redo_statement where dtid = 'ks:0:1' order by id",
+		"update a set b = 1 where c = 2",
+		"delete from _vt.redo_state where dtid = 'ks:0:1'",
+		"delete from _vt.redo_statement where dtid = 'ks:0:1'",
+		"commit",
+	})
+
+	// already resolved: nothing is committed
+	qe.CommitPrepared(logStats, "ks:0:2")
+	checkQueries(t, "CommitPrepared of a resolved transaction", db, []string{
+		"begin",
+		"select dtid from _vt.redo_state where dtid = 'ks:0:2' for update",
+		"rollback",
+	})
+	if size := qe.activeTxPool.pool.Size(); size != 0 {
+		t.Errorf("%v transactions left", size)
+	}
+}
+
+func TestRollbackPrepared(t *testing.T) {
+	db := newFakeTwoPCDB()
+	qe := newTwoPCQueryEngine(db)
+	logStats := newSqlQueryStats("TestRollbackPrepared", &context.DummyContext{})
+
+	qe.Prepare(logStats, begin(t, qe, "update a set b = 1 where c = 2"), "ks:0:1")
+	db.takeQueries()
+	qe.RollbackPrepared(logStats, "ks:0:1", 0)
+	checkQueries(t, "RollbackPrepared", db, []string{
+		"begin",
+		"delete from _vt.redo_state where dtid = 'ks:0:1'",
+		"delete from _vt.redo_statement where dtid = 'ks:0:1'",
+		"commit",
+		"rollback",
+	})
+	if qe.twoPC.isPrepared("ks:0:1") {
+		t.Errorf("ks:0:1 is still prepared")
+	}
+
+	// not prepared yet: the transaction is rolled back
+	transactionID := begin(t, qe)
+	db.takeQueries()
+	qe.RollbackPrepared(logStats, "ks:0:2", transactionID)
+	checkQueries(t, "RollbackPrepared of an unprepared transaction", db, []string{
+		"begin",
+		"delete from _vt.redo_state where dtid = 'ks:0:2'",
+		"delete from _vt.redo_statement where dtid = 'ks:0:2'",
+		"commit",
+		"rollback",
+	})
+	if size := qe.activeTxPool.pool.Size(); size != 0 {
+		t.Errorf("%v transactions left", size)
+	}
+}
+
+func TestPrepareFromRedoLog(t *testing.T) {
+	db := newFakeTwoPCDB()
+	qe := newTwoPCQueryEngine(db)
+	logStats := newSqlQueryStats("TestPrepareFromRedoLog", &context.DummyContext{})
+
+	// the redo log of a read-only master is read later, no
+	// transaction can start until then
+	db.setResult("select @@global.read_only", "1")
+	if err := qe.twoPC.PrepareFromRedoLog(); err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("PrepareFromRedoLog on a read-only master: got %v", err)
+	}
+	checkQueries(t, "PrepareFromRedoLog on a read-only master", db, []string{"select @@global.read_only"})
+	expectTabletError(t, "Begin", "not restored yet", func() {
+		qe.Begin(logStats)
+	})
+
+	// ks:0:1 is prepared in memory, ks:0:2 is only in the redo log
+	qe.Prepare(logStats, begin(t, qe, "update a set b = 1 where c = 2"), "ks:0:1")
+	db.takeQueries()
+	db.setResult("select @@global.read_only", "0")
+	db.setResult("select dtid from _vt.redo_state", "ks:0:1", "ks:0:2")
+	db.setResult("select dtid from _vt.redo_state where dtid = 'ks:0:2' for update", "ks:0:2")
+	db.setResult("select statement from _vt.redo_statement where dtid = 'ks:0:2' order by id", "update a set b = 2 where c = 3")
+	if err := qe.twoPC.PrepareFromRedoLog(); err != nil {
+		t.Fatalf("PrepareFromRedoLog failed: %v", err)
+	}
+	if qe.twoPC.RedoLogPending() {
+		t.Errorf("the redo log is still pending")
+	}
+	want := []string{"select @@global.read_only"}
+	want = append(want, createTwoPCTables...)
+	want = append(want,
+		"select dtid from _vt.redo_state",
+		"begin",
+		"select dtid from _vt.redo_state where dtid = 'ks:0:2' for update",
+		"select statement from _vt.redo_statement where dtid = 'ks:0:2' order by id",
+		"update a set b = 2 where c = 3",
+	)
+	checkQueries(t, "PrepareFromRedoLog", db, want)
+	for _, dtid := range []string{"ks:0:1", "ks:0:2"} {
+		if !qe.twoPC.isPrepared(dtid) {
+			t.Errorf("%v is not prepared", dtid)
+		}
+	}
+	if size := qe.activeTxPool.pool.Size(); size != 0 {
+		t.Errorf("%v transactions left in the ActiveTxPool", size)
+	}
+
+	// the replayed transaction can be committed
+	qe.CommitPrepared(logStats, "ks:0:2")
+	checkQueries(t, "CommitPrepared", db, []string{
+		"delete from _vt.redo_state where dtid = 'ks:0:2'",
+		"delete from _vt.redo_statement where dtid = 'ks:0:2'",
+		"commit",
+	})
+}
+
+func TestStartCommit(t *testing.T) {
+	db := newFakeTwoPCDB()
+	qe := newTwoPCQueryEngine(db)
+	logStats := newSqlQueryStats("TestStartCommit", &context.DummyContext{})
+	update := "update _vt.dt_state set state = 'COMMIT' where dtid = 'ks:0:1' and state = 'PREPARE'"
+
+	db.setResult(update, "")
+	qe.StartCommit(logStats, begin(t, qe), "ks:0:1")
+	checkQueries(t, "StartCommit", db, []string{"begin", update, "commit"})
+
+	// the transaction was rolled back by the resolver
+	db.setResult(update)
+	expectTabletError(t, "StartCommit", "not in the PREPARE state", func() {
+		qe.StartCommit(logStats, begin(t, qe), "ks:0:1")
+	})
+	checkQueries(t, "failed StartCommit", db, []string{"begin", update, "rollback"})
+	if size := qe.activeTxPool.pool.Size(); size != 0 {
+		t.Errorf("%v transactions left", size)
+	}
+}
+
+func TestSetRollback(t *testing.T) {
+	db := newFakeTwoPCDB()
+	qe := newTwoPCQueryEngine(db)
+	logStats := newSqlQueryStats("TestSetRollback", &context.DummyContext{})
+	update := "update _vt.dt_state set state = 'ROLLBACK' where dtid = 'ks:0:1' and state = 'PREPARE'"
+	selectState := "select state from _vt.dt_state where dtid = 'ks:0:1'"
+
+	db.setResult(update, "")
+	qe.SetRollback(logStats, "ks:0:1", begin(t, qe))
+	checkQueries(t, "SetRollback", db, []string{"begin", update, "rollback"})
+
+	// already rolled back
+	db.setResult(update)
+	db.setResult(selectState, proto.DT_ROLLBACK)
+	qe.SetRollback(logStats, "ks:0:1", 0)
+	checkQueries(t, "SetRollback again", db, []string{update, selectState})
+
+	// committing: the transaction is still rolled back
+	db.setResult(selectState, proto.DT_COMMIT)
+	expectTabletError(t, "SetRollback", "committing", func() {
+		qe.SetRollback(logStats, "ks:0:1", begin(t, qe))
+	})
+	checkQueries(t, "failed SetRollback", db, []string{"begin", update, selectState, "rollback"})
+	if size := qe.activeTxPool.pool.Size(); size != 0 {
+		t.Errorf("%v transactions left", size)
+	}
+}
+
+func TestFindFuncCalls(t *testing.T) {
+	testCases := []struct {
+		sql  string
+		want []string
+	}{
+		{"update a set b = now() where c = 2", []string{"now()"}},
+		{"insert into a values (NOW( ), Rand(), unix_timestamp())", []string{"NOW( )", "Rand()", "unix_timestamp()"}},
+		{"insert into a values (current_timestamp, utc_date)", []string{"current_timestamp", "utc_date"}},
+		{"update a set b = 1 where c = current_date", []string{"current_date"}},
+		{"update a set b = 1 where c = unix_timestamp(d)", nil},
+		{"update a set `now` = 1, b = now /* now() */ where c = 'now()'", nil},
+	}
+	for _, tc := range testCases {
+		var got []string
+		for _, call := range findFuncCalls(tc.sql) {
+			got = append(got, tc.sql[call.start:call.end])
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("findFuncCalls(%v): got %v, want %v", tc.sql, got, tc.want)
+		}
+	}
+}
+
+func TestBindTimeFuncs(t *testing.T) {
+	db := newFakeTwoPCDB()
+	db.setResult("select now()", "2014-09-12 10:00:00")
+	conn := &fakeTwoPCConn{db: db}
+	db.results["select NOW( ), current_date"] = &mproto.QueryResult{
+		Rows: [][]sqltypes.Value{{
+			sqltypes.MakeString([]byte("2014-09-12 10:00:00")),
+			sqltypes.MakeString([]byte("2014-09-12")),
+		}},
+	}
+	testCases := []struct {
+		sql, want, perRow string
+	}{
+		{"update a set b = 1 where c = 2", "update a set b = 1 where c = 2", ""},
+		{"update a set b = now() where c = 2", "update a set b = '2014-09-12 10:00:00' where c = 2", ""},
+		{"insert into a values (NOW( ), current_date, uuid())", "insert into a values ('2014-09-12 10:00:00', '2014-09-12', uuid())", "uuid"},
+	}
+	for _, tc := range testCases {
+		got, perRow, err := bindTimeFuncs(conn, tc.sql)
+		if err != nil {
+			t.Errorf("bindTimeFuncs(%v) failed: %v", tc.sql, err)
+			continue
+		}
+		if got != tc.want || perRow != tc.perRow {
+			t.Errorf("bindTimeFuncs(%v): got %v, %v, want %v, %v", tc.sql, got, perRow, tc.want, tc.perRow)
+		}
+	}
+}
@@ -138,6 +138,26 @@ func (tee *Tee) DeleteKeyspaceShards(keyspace string) error {
 	return nil
 }
 
+//
+// VSchema management, global.
+//
+
+func (tee *Tee) SaveVSchema(vschema string) error {
+	if err := tee.primary.SaveVSchema(vschema); err != nil {
+		return err
+	}
+
+	if err := tee.secondary.SaveVSchema(vschema); err != nil {
+		// not critical enough to fail
+		log.Warningf("secondary.SaveVSchema() failed: %v", err)
+	}
+	return nil
+}
+
+func (tee *Tee) GetVSchema() (string, error) {
+	return tee.readFrom.GetVSchema()
+}
+
 //
 // Shard management, global.
 //
@@ -207,6 +227,30 @@ func (tee *Tee) DeleteShard(keyspace, shard string) error {
 	return err
 }
 
+//
+// Query rules management, global.
+//
+
+func (tee *Tee) SaveQueryRules(keyspace, shard, rules string) error {
+	if err := tee.primary.SaveQueryRules(keyspace, shard, rules); err != nil {
+		return err
+	}
+
+	if err := tee.secondary.SaveQueryRules(keyspace, shard, rules); err != nil {
+		// not critical enough to fail
+		log.Warningf("secondary.SaveQueryRules(%v, %v) failed: %v", keyspace, shard, err)
+	}
+	return nil
+}
+
+func (tee *Tee) GetQueryRules(keyspace, shard string) (string, error) {
+	return tee.readFrom.GetQueryRules(keyspace, shard)
+}
+
+func (tee *Tee) WatchQueryRules(keyspace, shard string, stopWatching chan struct{}) (<-chan string, error) {
+	return tee.readFrom.WatchQueryRules(keyspace, shard, stopWatching)
+}
+
 //
 // Tablet management, per cell.
 //
@@ -515,6 +559,14 @@ func (tee *Tee) UpdateTabletEndpoint(cell, keyspace, shard string, tabletType to
 	return nil
 }
 
+func (tee *Tee) WatchEndPoints(cell, keyspace, shard string, tabletType topo.TabletType, stopWatching chan struct{}) (<-chan *topo.EndPoints, error) {
+	return tee.readFrom.WatchEndPoints(cell, keyspace, shard, tabletType, stopWatching)
+}
+
+func (tee *Tee) WatchSrvKeyspace(cell, keyspace string, stopWatching chan struct{}) (<-chan *topo.SrvKeyspace, error) {
+	return tee.readFrom.WatchSrvKeyspace(cell, keyspace, stopWatching)
+}
+
 //
 // Keyspace and Shard locks for actions, global.
 //
@@ -613,6 +665,20 @@ func (tee *Tee) UnlockShardForAction(keyspace, shard, lockPath, results string)
 	return perr
 }
 
+// LockShardForElection only uses lockFirst: the election lock
+// doesn't protect any data, so one server is enough.
+func (tee *Tee) LockShardForElection(keyspace, shard, contents string, timeout time.Duration, interrupted chan struct{}) (string, error) {
+	return tee.lockFirst.LockShardForElection(keyspace, shard, contents, timeout, interrupted)
+}
+
+func (tee *Tee) UnlockShardForElection(keyspace, shard, lockPath string) error {
+	return tee.lockFirst.UnlockShardForElection(keyspace, shard, lockPath)
+}
+
+func (tee *Tee) GetShardElectionLeader(keyspace, shard string) (string, error) {
+	return tee.lockFirst.GetShardElectionLeader(keyspace, shard)
+}
+
 //
 // Remote Tablet Actions, local cell.
 // We just send these actions through the primary topo.Server.
@@ -90,6 +90,19 @@ type Server interface {
 	// Use with caution.
 	DeleteKeyspaceShards(keyspace string) error
 
+	//
+	// VSchema management, global.
+	//
+
+	// SaveVSchema saves the VSchema, a JSON document describing
+	// how the tables of the keyspaces are sharded. It is used by
+	// vtgate to route SQL queries.
+	SaveVSchema(vschema string) error
+
+	// GetVSchema returns the VSchema saved by SaveVSchema.
+	// Can return ErrNoNode if it was never saved.
+	GetVSchema() (string, error)
+
 	//
 	// Shard management, global.
 	//
@@ -131,6 +144,32 @@ type Server interface {
 	// Can return ErrNoNode if the shard doesn't exist.
 	DeleteShard(keyspace, shard string) error
 
+	//
+	// Query rules management, global.
+	//
+
+	// SaveQueryRules saves the query rules of a shard, a JSON
+	// document in the format of the tabletserver custom rules.
+	// The tablets of the shard apply them on top of their
+	// -customrules file. There are no keyspace-wide rules: the
+	// shards created later don't have any.
+	// Can return ErrNoNode if the shard doesn't exist.
+	SaveQueryRules(keyspace, shard, rules string) error
+
+	// GetQueryRules returns the query rules saved by SaveQueryRules.
+	// Can return ErrNoNode if they were never saved.
+	GetQueryRules(keyspace, shard string) (string, error)
+
+	// WatchQueryRules returns a channel that receives
+	// notifications every time the query rules of the shard
+	// change. The first notification has the current value, and
+	// is sent right away. An empty value means no rules were
+	// saved. Errors while watching are retried by the
+	// implementation.
+	// Close stopWatching to stop watching, the returned channel
+	// will then be closed.
+	WatchQueryRules(keyspace, shard string, stopWatching chan struct{}) (<-chan string, error)
+
 	//
 	// Tablet management, per cell.
 	//
@@ -250,6 +289,22 @@ type Server interface {
 	// If the node doesn't exist, it is not updated, this is not an error.
 	UpdateTabletEndpoint(cell, keyspace, shard string, tabletType TabletType, addr *EndPoint) error
 
+	// WatchEndPoints returns a channel that receives notifications
+	// every time the EndPoints for the given cell, keyspace, shard,
+	// tabletType change. The first notification has the current
+	// value, and is sent right away. A nil value means the
+	// EndPoints object doesn't exist. Errors while watching are
+	// retried by the implementation.
+	// Close stopWatching to stop watching, the returned channel
+	// will then be closed.
+	WatchEndPoints(cell, keyspace, shard string, tabletType TabletType, stopWatching chan struct{}) (<-chan *EndPoints, error)
+
+	// WatchSrvKeyspace returns a channel that receives
+	// notifications every time the SrvKeyspace for the given
+	// cell, keyspace changes. It works the same way as
+	// WatchEndPoints.
+	WatchSrvKeyspace(cell, keyspace string, stopWatching chan struct{}) (<-chan *SrvKeyspace, error)
+
 	//
 	// Keyspace and Shard locks for actions, global.
 	//
@@ -276,6 +331,24 @@ type Server interface {
 	// UnlockShardForAction unlocks a shard.
 	UnlockShardForAction(keyspace, shard, lockPath, results string) error
 
+	// LockShardForElection takes the election lock of a shard. It
+	// is used by processes that need a single leader per shard,
+	// like vtjanitor. It is independent from the action lock, so
+	// it can be held for a long time without blocking actions on
+	// the shard. The lock is released if its holder dies.
+	// contents should identify the holder, it is returned by
+	// GetShardElectionLeader. It returns the lock path.
+	// Can return ErrNoNode, ErrTimeout or ErrInterrupted
+	LockShardForElection(keyspace, shard, contents string, timeout time.Duration, interrupted chan struct{}) (string, error)
+
+	// UnlockShardForElection releases the election lock of a shard.
+	UnlockShardForElection(keyspace, shard, lockPath string) error
+
+	// GetShardElectionLeader returns the contents of the current
+	// holder of the election lock of a shard.
+	// Can return ErrNoNode if nobody holds the lock.
+	GetShardElectionLeader(keyspace, shard string) (string, error)
+
 	//
 	// Remote Tablet Actions, local cell.
 	//
@@ -343,6 +416,21 @@ type Server interface {
 	UnblockTabletAction(actionPath string) error
 }
 
+// watchChecker is implemented by the Server that may not support
+// the Watch methods.
+type watchChecker interface {
+	CanWatch() bool
+}
+
+// CanWatch returns true if ts supports the Watch methods. A
+// zookeeper Server talking to zkocc doesn't.
+func CanWatch(ts Server) bool {
+	if wc, ok := ts.(watchChecker); ok {
+		return wc.CanWatch()
+	}
+	return true
+}
+
 // Registry for Server implementations.
 var serverImpls map[string]Server = make(map[string]Server)
 
@@ -96,6 +96,85 @@ func CheckShardLock(t *testing.T, ts topo.Server) {
 	}
 }
 
+func CheckShardElection(t *testing.T, ts topo.Server) {
+	if err := ts.CreateKeyspace("test_keyspace", &topo.Keyspace{}); err != nil {
+		t.Fatalf("CreateKeyspace: %v", err)
+	}
+	if err := topo.CreateShard(ts, "test_keyspace", "10-20"); err != nil {
+		t.Fatalf("CreateShard: %v", err)
+	}
+
+	// nobody is the leader yet
+	if _, err := ts.GetShardElectionLeader("test_keyspace", "10-20"); err != topo.ErrNoNode {
+		t.Errorf("GetShardElectionLeader(no leader): %v", err)
+	}
+
+	interrupted := make(chan struct{}, 1)
+	lockPath, err := ts.LockShardForElection("test_keyspace", "10-20", "candidate1", 5*time.Second, interrupted)
+	if err != nil {
+		t.Fatalf("LockShardForElection: %v", err)
+	}
+	if leader, err := ts.GetShardElectionLeader("test_keyspace", "10-20"); err != nil || leader != "candidate1" {
+		t.Errorf("GetShardElectionLeader: %v %v", leader, err)
+	}
+
+	// the election lock doesn't prevent actions on the shard
+	actionLockPath, err := ts.LockShardForAction("test_keyspace", "10-20", "fake-content", 5*time.Second, interrupted)
+	if err != nil {
+		t.Fatalf("LockShardForAction: %v", err)
+	}
+	if err := ts.UnlockShardForAction("test_keyspace", "10-20", actionLockPath, "fake-results"); err != nil {
+		t.Errorf("UnlockShardForAction(): %v", err)
+	}
+
+	// test we can't take the lock again
+	if _, err := ts.LockShardForElection("test_keyspace", "10-20", "candidate2", time.Second/2, interrupted); err != topo.ErrTimeout {
+		t.Errorf("LockShardForElection(again): %v", err)
+	}
+
+	// a second candidate waits until the leader goes away
+	done := make(chan error, 1)
+	go func() {
+		secondLockPath, err := ts.LockShardForElection("test_keyspace", "10-20", "candidate2", 5*time.Second, interrupted)
+		if err == nil {
+			if leader, lerr := ts.GetShardElectionLeader("test_keyspace", "10-20"); lerr != nil || leader != "candidate2" {
+				t.Errorf("GetShardElectionLeader(candidate2): %v %v", leader, lerr)
+			}
+			err = ts.UnlockShardForElection("test_keyspace", "10-20", secondLockPath)
+		}
+		done <- err
+	}()
+	time.Sleep(time.Second / 2)
+	if err := ts.UnlockShardForElection("test_keyspace", "10-20", lockPath); err != nil {
+		t.Errorf("UnlockShardForElection(): %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("second candidate: %v", err)
+	}
+
+	// test we can interrupt taking the lock
+	lockPath, err = ts.LockShardForElection("test_keyspace", "10-20", "candidate1", 5*time.Second, interrupted)
+	if err != nil {
+		t.Fatalf("LockShardForElection: %v", err)
+	}
+	go func() {
+		time.Sleep(time.Second / 2)
+		close(interrupted)
+	}()
+	if _, err := ts.LockShardForElection("test_keyspace", "10-20", "candidate2", 5*time.Second, interrupted); err != topo.ErrInterrupted {
+		t.Errorf("LockShardForElection(interrupted): %v", err)
+	}
+	if err := ts.UnlockShardForElection("test_keyspace", "10-20", lockPath); err != nil {
+		t.Errorf("UnlockShardForElection(): %v", err)
+	}
+
+	// test we can't lock a non-existing shard
+	interrupted = make(chan struct{}, 1)
+	if _, err := ts.LockShardForElection("test_keyspace", "20-30", "candidate1", 5*time.Second, interrupted); err == nil {
+		t.Fatalf("LockShardForElection(test_keyspace/20-30) worked for non-existing shard")
+	}
+}
+
 func CheckSrvShardLock(t *testing.T, ts topo.Server) {
 	// make sure we can create the lock even if no directory exists
 	interrupted := make(chan struct{}, 1)
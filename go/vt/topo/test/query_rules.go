@@ -0,0 +1,111 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// CheckQueryRules makes sure the query rules management and
+// WatchQueryRules work as expected
+func CheckQueryRules(t *testing.T, ts topo.Server) {
+	keyspace := "test_keyspace"
+	shard := "-10"
+
+	if err := ts.SaveQueryRules(keyspace, shard, "[]"); err != topo.ErrNoNode {
+		t.Errorf("SaveQueryRules(no keyspace) is not ErrNoNode: %v", err)
+	}
+	if err := ts.CreateKeyspace(keyspace, &topo.Keyspace{}); err != nil {
+		t.Fatalf("CreateKeyspace: %v", err)
+	}
+	if err := ts.SaveQueryRules(keyspace, shard, "[]"); err != topo.ErrNoNode {
+		t.Errorf("SaveQueryRules(no shard) is not ErrNoNode: %v", err)
+	}
+	if _, err := ts.GetQueryRules(keyspace, shard); err != topo.ErrNoNode {
+		t.Errorf("GetQueryRules(no shard) is not ErrNoNode: %v", err)
+	}
+	if err := topo.CreateShard(ts, keyspace, shard); err != nil {
+		t.Fatalf("CreateShard: %v", err)
+	}
+	if _, err := ts.GetQueryRules(keyspace, shard); err != topo.ErrNoNode {
+		t.Errorf("GetQueryRules(empty) is not ErrNoNode: %v", err)
+	}
+
+	// start watching, we should get a notification with no rules
+	stopWatching := make(chan struct{})
+	notifications, err := ts.WatchQueryRules(keyspace, shard, stopWatching)
+	if err != nil {
+		t.Fatalf("WatchQueryRules failed: %v", err)
+	}
+	waitForQueryRules(t, notifications, "initial", func(rules string) bool {
+		if rules != "" {
+			t.Fatalf("first value is wrong: %v", rules)
+		}
+		return true
+	})
+
+	rules := `[{"Name":"r1","Query":"select.*"}]`
+	if err := ts.SaveQueryRules(keyspace, shard, rules); err != nil {
+		t.Fatalf("SaveQueryRules: %v", err)
+	}
+	if got, err := ts.GetQueryRules(keyspace, shard); err != nil || got != rules {
+		t.Errorf("GetQueryRules: want %v, got %v %v", rules, got, err)
+	}
+	waitForQueryRules(t, notifications, "save", func(got string) bool {
+		return got == rules
+	})
+
+	rules = `[{"Name":"r2","Query":"delete.*"}]`
+	if err := ts.SaveQueryRules(keyspace, shard, rules); err != nil {
+		t.Fatalf("SaveQueryRules(again): %v", err)
+	}
+	if got, err := ts.GetQueryRules(keyspace, shard); err != nil || got != rules {
+		t.Errorf("GetQueryRules(again): want %v, got %v %v", rules, got, err)
+	}
+	waitForQueryRules(t, notifications, "save again", func(got string) bool {
+		return got == rules
+	})
+
+	// stop watching, the channel should be closed
+	close(stopWatching)
+	timeout := time.After(10 * time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-notifications:
+			closed = !ok
+		case <-timeout:
+			t.Fatalf("WatchQueryRules: channel not closed after stopWatching")
+		}
+	}
+
+	// the rules go away with the shard
+	if err := ts.DeleteShard(keyspace, shard); err != nil {
+		t.Fatalf("DeleteShard: %v", err)
+	}
+	if err := ts.SaveQueryRules(keyspace, shard, rules); err != topo.ErrNoNode {
+		t.Errorf("SaveQueryRules(deleted shard) is not ErrNoNode: %v", err)
+	}
+	if _, err := ts.GetQueryRules(keyspace, shard); err != topo.ErrNoNode {
+		t.Errorf("GetQueryRules(deleted shard) is not ErrNoNode: %v", err)
+	}
+}
+
+// waitForQueryRules reads notifications until check returns true,
+// and fails the test if it takes too long.
+func waitForQueryRules(t *testing.T, notifications <-chan string, name string, check func(string) bool) {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case rules, ok := <-notifications:
+			if !ok {
+				t.Fatalf("WatchQueryRules(%v): channel closed", name)
+			}
+			if check(rules) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("WatchQueryRules(%v): timed out", name)
+		}
+	}
+}
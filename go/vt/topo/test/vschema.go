@@ -0,0 +1,29 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+func CheckVSchema(t *testing.T, ts topo.Server) {
+	if _, err := ts.GetVSchema(); err != topo.ErrNoNode {
+		t.Errorf("GetVSchema(empty) is not ErrNoNode: %v", err)
+	}
+
+	vschema := `{"Keyspaces":{"test_keyspace":{"Sharded":true}}}`
+	if err := ts.SaveVSchema(vschema); err != nil {
+		t.Fatalf("SaveVSchema: %v", err)
+	}
+	if got, err := ts.GetVSchema(); err != nil || got != vschema {
+		t.Errorf("GetVSchema: want %v, got %v %v", vschema, got, err)
+	}
+
+	vschema = `{"Keyspaces":{"test_keyspace":{"Sharded":false}}}`
+	if err := ts.SaveVSchema(vschema); err != nil {
+		t.Fatalf("SaveVSchema(again): %v", err)
+	}
+	if got, err := ts.GetVSchema(); err != nil || got != vschema {
+		t.Errorf("GetVSchema(again): want %v, got %v %v", vschema, got, err)
+	}
+}
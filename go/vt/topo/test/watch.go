@@ -0,0 +1,182 @@
+// package test contains utilities to test topo.Server
+// implementations. If you are testing your implementation, you will
+// want to call CheckAll in your test method. For an example, look at
+// the tests in github.com/youtube/vitess/go/vt/zktopo.
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// waitForEndPoints reads notifications until check returns true, and
+// fails the test if it takes too long. Implementations are allowed to
+// send extra notifications, so the ones that don't match are skipped.
+func waitForEndPoints(t *testing.T, notifications <-chan *topo.EndPoints, name string, check func(*topo.EndPoints) bool) {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case ep, ok := <-notifications:
+			if !ok {
+				t.Fatalf("WatchEndPoints(%v): channel closed", name)
+			}
+			if check(ep) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("WatchEndPoints(%v): timed out", name)
+		}
+	}
+}
+
+// CheckWatchEndPoints makes sure WatchEndPoints works as expected
+func CheckWatchEndPoints(t *testing.T, ts topo.Server) {
+	cell := getLocalCell(t, ts)
+	keyspace := "test_keyspace"
+	shard := "-10"
+	tabletType := topo.TYPE_MASTER
+
+	// start watching, we should get a notification with nil
+	stopWatching := make(chan struct{})
+	notifications, err := ts.WatchEndPoints(cell, keyspace, shard, tabletType, stopWatching)
+	if err != nil {
+		t.Fatalf("WatchEndPoints failed: %v", err)
+	}
+	waitForEndPoints(t, notifications, "initial", func(ep *topo.EndPoints) bool {
+		if ep != nil {
+			t.Fatalf("first value is wrong: %v", ep)
+		}
+		return true
+	})
+
+	// update the endpoints, should get a notification
+	endPoints := topo.EndPoints{
+		Entries: []topo.EndPoint{
+			topo.EndPoint{
+				Uid:          1,
+				Host:         "host1",
+				NamedPortMap: map[string]int{"_vt": 1234},
+			},
+		},
+	}
+	if err := ts.UpdateEndPoints(cell, keyspace, shard, tabletType, &endPoints); err != nil {
+		t.Fatalf("UpdateEndPoints failed: %v", err)
+	}
+	waitForEndPoints(t, notifications, "update", func(ep *topo.EndPoints) bool {
+		return ep != nil && len(ep.Entries) == 1 && ep.Entries[0].Uid == 1 && ep.Entries[0].NamedPortMap["_vt"] == 1234
+	})
+
+	// update a single endpoint, should get a notification
+	if err := ts.UpdateTabletEndpoint(cell, keyspace, shard, tabletType, &topo.EndPoint{Uid: 2, Host: "host2"}); err != nil {
+		t.Fatalf("UpdateTabletEndpoint failed: %v", err)
+	}
+	waitForEndPoints(t, notifications, "update tablet", func(ep *topo.EndPoints) bool {
+		return ep != nil && len(ep.Entries) == 2
+	})
+
+	// delete the endpoints, should get a notification with nil
+	if err := ts.DeleteEndPoints(cell, keyspace, shard, tabletType); err != nil {
+		t.Fatalf("DeleteEndPoints failed: %v", err)
+	}
+	waitForEndPoints(t, notifications, "delete", func(ep *topo.EndPoints) bool {
+		return ep == nil
+	})
+
+	// re-create the endpoints, should get a notification
+	if err := ts.UpdateEndPoints(cell, keyspace, shard, tabletType, &endPoints); err != nil {
+		t.Fatalf("UpdateEndPoints failed: %v", err)
+	}
+	waitForEndPoints(t, notifications, "re-create", func(ep *topo.EndPoints) bool {
+		return ep != nil && len(ep.Entries) == 1
+	})
+
+	// stop watching, the channel should be closed
+	close(stopWatching)
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case _, ok := <-notifications:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("WatchEndPoints: channel not closed after stopWatching")
+		}
+	}
+}
+
+// waitForSrvKeyspace reads notifications until check returns true,
+// and fails the test if it takes too long. Implementations are
+// allowed to send extra notifications, so the ones that don't match
+// are skipped.
+func waitForSrvKeyspace(t *testing.T, notifications <-chan *topo.SrvKeyspace, name string, check func(*topo.SrvKeyspace) bool) {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case sk, ok := <-notifications:
+			if !ok {
+				t.Fatalf("WatchSrvKeyspace(%v): channel closed", name)
+			}
+			if check(sk) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("WatchSrvKeyspace(%v): timed out", name)
+		}
+	}
+}
+
+// CheckWatchSrvKeyspace makes sure WatchSrvKeyspace works as expected
+func CheckWatchSrvKeyspace(t *testing.T, ts topo.Server) {
+	cell := getLocalCell(t, ts)
+	keyspace := "test_keyspace"
+
+	// start watching, we should get a notification with nil
+	stopWatching := make(chan struct{})
+	notifications, err := ts.WatchSrvKeyspace(cell, keyspace, stopWatching)
+	if err != nil {
+		t.Fatalf("WatchSrvKeyspace failed: %v", err)
+	}
+	waitForSrvKeyspace(t, notifications, "initial", func(sk *topo.SrvKeyspace) bool {
+		if sk != nil {
+			t.Fatalf("first value is wrong: %v", sk)
+		}
+		return true
+	})
+
+	// update the SrvKeyspace, should get a notification
+	srvKeyspace := topo.SrvKeyspace{
+		ShardingColumnName: "video_id",
+	}
+	if err := ts.UpdateSrvKeyspace(cell, keyspace, &srvKeyspace); err != nil {
+		t.Fatalf("UpdateSrvKeyspace failed: %v", err)
+	}
+	waitForSrvKeyspace(t, notifications, "update", func(sk *topo.SrvKeyspace) bool {
+		return sk != nil && sk.ShardingColumnName == "video_id"
+	})
+
+	// update it again, should get a notification
+	srvKeyspace.ShardingColumnName = "user_id"
+	if err := ts.UpdateSrvKeyspace(cell, keyspace, &srvKeyspace); err != nil {
+		t.Fatalf("UpdateSrvKeyspace failed: %v", err)
+	}
+	waitForSrvKeyspace(t, notifications, "second update", func(sk *topo.SrvKeyspace) bool {
+		return sk != nil && sk.ShardingColumnName == "user_id"
+	})
+
+	// stop watching, the channel should be closed
+	close(stopWatching)
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case _, ok := <-notifications:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("WatchSrvKeyspace: channel not closed after stopWatching")
+		}
+	}
+}
@@ -17,10 +17,18 @@ type VTGate struct {
 	server *vtgate.VTGate
 }
 
+func (vtg *VTGate) ExecuteSQL(ctx *rpcproto.Context, query *proto.Query, reply *proto.QueryResult) error {
+	return vtg.server.ExecuteSQL(ctx, query, reply)
+}
+
 func (vtg *VTGate) ExecuteShard(ctx *rpcproto.Context, query *proto.QueryShard, reply *proto.QueryResult) error {
 	return vtg.server.ExecuteShard(ctx, query, reply)
 }
 
+func (vtg *VTGate) ExplainShard(ctx *rpcproto.Context, query *proto.QueryShard, reply *proto.QueryExplanationResult) error {
+	return vtg.server.ExplainShard(ctx, query, reply)
+}
+
 func (vtg *VTGate) ExecuteKeyspaceIds(ctx *rpcproto.Context, query *proto.KeyspaceIdQuery, reply *proto.QueryResult) error {
 	return vtg.server.ExecuteKeyspaceIds(ctx, query, reply)
 }
@@ -53,6 +61,12 @@ func (vtg *VTGate) StreamExecuteKeyRanges(ctx *rpcproto.Context, query *proto.Ke
 	})
 }
 
+func (vtg *VTGate) StreamExecuteKeyRangesSnapshot(ctx *rpcproto.Context, query *proto.KeyRangeQuery, sendReply func(interface{}) error) error {
+	return vtg.server.StreamExecuteKeyRangesSnapshot(ctx, query, func(value *proto.QueryResult) error {
+		return sendReply(value)
+	})
+}
+
 func (vtg *VTGate) StreamExecuteKeyspaceIds(ctx *rpcproto.Context, query *proto.KeyspaceIdQuery, sendReply func(interface{}) error) error {
 	return vtg.server.StreamExecuteKeyspaceIds(ctx, query, func(value *proto.QueryResult) error {
 		return sendReply(value)
@@ -0,0 +1,779 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+)
+
+// mergePlan describes how to merge the results of a select sent to
+// multiple shards, so they look like the result of the select on a
+// single database.
+type mergePlan struct {
+	// shardSql is the query to send to the shards. The LIMIT
+	// clause is changed to return enough rows for the merge.
+	shardSql string
+
+	selectExprs sqlparser.SelectExprs
+	orderBy     sqlparser.OrderBy
+
+	// grouped is set if the rows of all the shards have to be
+	// grouped, by the groupBy columns, computing the aggregates.
+	grouped    bool
+	groupBy    []sqlparser.ValExpr
+	aggregates []aggregateColumn
+
+	// limit is -1 if there is no LIMIT clause.
+	limit  int64
+	offset int64
+
+	// hidden is the number of ORDER BY and GROUP BY columns added
+	// at the end of the select list of the shards. They are
+	// removed from the merged result.
+	hidden int
+}
+
+// aggregateColumn is a column of the select list that has to be
+// re-aggregated over the results of the shards.
+type aggregateColumn struct {
+	index    int
+	function string
+}
+
+// buildMergePlan returns the plan to merge the results of the query,
+// or nil if the results can just be concatenated.
+func buildMergePlan(sql string, bindVars map[string]interface{}) (*mergePlan, error) {
+	statement, err := sqlparser.Parse(sql)
+	if err != nil {
+		// the shards will report the error if there is one
+		return nil, nil
+	}
+	sel, ok := statement.(*sqlparser.Select)
+	if !ok {
+		return nil, nil
+	}
+
+	plan := &mergePlan{
+		selectExprs: sel.SelectExprs,
+		orderBy:     sel.OrderBy,
+		groupBy:     sel.GroupBy,
+		limit:       -1,
+	}
+	star := false
+	for i, expr := range sel.SelectExprs {
+		nse, ok := expr.(*sqlparser.NonStarExpr)
+		if !ok {
+			star = true
+			continue
+		}
+		fexpr, ok := nse.Expr.(*sqlparser.FuncExpr)
+		if !ok {
+			continue
+		}
+		name := strings.ToLower(string(fexpr.Name))
+		switch name {
+		case "count", "sum", "min", "max":
+		case "avg", "group_concat", "std", "stddev", "variance", "bit_and", "bit_or", "bit_xor":
+			return nil, fmt.Errorf("cannot merge %v across shards", sqlparser.String(fexpr))
+		default:
+			continue
+		}
+		if fexpr.Distinct && name != "min" && name != "max" {
+			return nil, fmt.Errorf("cannot merge %v across shards", sqlparser.String(fexpr))
+		}
+		if star {
+			return nil, fmt.Errorf("cannot merge %v across shards after a *", sqlparser.String(fexpr))
+		}
+		plan.aggregates = append(plan.aggregates, aggregateColumn{index: i, function: name})
+	}
+	if sel.Distinct != "" && len(plan.groupBy) == 0 && len(plan.aggregates) == 0 {
+		// distinct rows are the groups of all the columns
+		plan.grouped = true
+	}
+	if len(plan.groupBy) != 0 || len(plan.aggregates) != 0 {
+		plan.grouped = true
+		if sel.Having != nil {
+			return nil, fmt.Errorf("cannot merge a HAVING clause across shards")
+		}
+	}
+
+	if sel.Limit != nil {
+		if plan.limit, err = limitValue(sel.Limit.Rowcount, bindVars); err != nil {
+			return nil, err
+		}
+		if sel.Limit.Offset != nil {
+			if plan.offset, err = limitValue(sel.Limit.Offset, bindVars); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !plan.grouped && len(plan.orderBy) == 0 && plan.limit == -1 {
+		return nil, nil
+	}
+
+	// The rows are merged on the ORDER BY and GROUP BY columns,
+	// the shards have to return them.
+	var mergeExprs []sqlparser.ValExpr
+	for _, order := range plan.orderBy {
+		mergeExprs = append(mergeExprs, order.Expr)
+	}
+	mergeExprs = append(mergeExprs, plan.groupBy...)
+	for _, expr := range mergeExprs {
+		if isSelected(sel.SelectExprs, expr) {
+			continue
+		}
+		if sel.Distinct != "" {
+			return nil, fmt.Errorf("cannot merge %v across shards: it is not in the DISTINCT select list", sqlparser.String(expr))
+		}
+		sel.SelectExprs = append(sel.SelectExprs, &sqlparser.NonStarExpr{Expr: expr})
+		plan.hidden++
+	}
+	plan.selectExprs = sel.SelectExprs
+
+	// The shards have to return all the groups, and all the rows
+	// before the offset.
+	switch {
+	case plan.grouped:
+		sel.Limit = nil
+	case sel.Limit != nil:
+		sel.Limit = &sqlparser.Limit{Rowcount: sqlparser.NumVal(strconv.FormatInt(plan.offset+plan.limit, 10))}
+	}
+	plan.shardSql = sqlparser.String(sel)
+	return plan, nil
+}
+
+// isSelected returns true if an ORDER BY or GROUP BY expression refers
+// to a column of the select list, or may do so.
+func isSelected(selectExprs sqlparser.SelectExprs, expr sqlparser.ValExpr) bool {
+	switch expr.(type) {
+	case sqlparser.NumVal, *sqlparser.NullVal:
+		return true
+	}
+	col, isColName := expr.(*sqlparser.ColName)
+	for _, selectExpr := range selectExprs {
+		nse, ok := selectExpr.(*sqlparser.NonStarExpr)
+		if !ok {
+			// the * may select it
+			return true
+		}
+		if isColName {
+			if strings.EqualFold(string(nse.As), string(col.Name)) {
+				return true
+			}
+			if selected, ok := nse.Expr.(*sqlparser.ColName); ok && len(nse.As) == 0 && strings.EqualFold(string(selected.Name), string(col.Name)) {
+				return true
+			}
+		}
+		if sqlparser.String(nse.Expr) == sqlparser.String(expr) {
+			return true
+		}
+	}
+	return false
+}
+
+func limitValue(node sqlparser.ValExpr, bindVars map[string]interface{}) (int64, error) {
+	var value interface{}
+	switch node := node.(type) {
+	case sqlparser.NumVal:
+		value = string(node)
+	case sqlparser.ValArg:
+		v, err := findBindValue(node, bindVars)
+		if err != nil {
+			return 0, err
+		}
+		value = v
+	default:
+		return 0, fmt.Errorf("unexpected limit %v", sqlparser.String(node))
+	}
+	var result int64
+	switch v := value.(type) {
+	case int:
+		result = int64(v)
+	case int32:
+		result = int64(v)
+	case int64:
+		result = v
+	case uint32:
+		result = int64(v)
+	case uint64:
+		result = int64(v)
+	case string:
+		var err error
+		if result, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return 0, fmt.Errorf("invalid limit %v: %v", v, err)
+		}
+	default:
+		return 0, fmt.Errorf("unexpected type %T for limit", value)
+	}
+	if result < 0 {
+		return 0, fmt.Errorf("invalid limit %v", result)
+	}
+	return result, nil
+}
+
+// orderByColumn is a resolved ORDER BY expression.
+type orderByColumn struct {
+	index int
+	typ   int64
+	desc  bool
+}
+
+// merge merges the results of the shards.
+func (plan *mergePlan) merge(results []*mproto.QueryResult) (*mproto.QueryResult, error) {
+	qr := new(mproto.QueryResult)
+	for _, result := range results {
+		if result.Fields != nil {
+			qr.Fields = result.Fields
+			break
+		}
+	}
+	if qr.Fields == nil {
+		return qr, nil
+	}
+
+	orderBy, err := plan.resolveOrderBy(qr.Fields)
+	if err != nil {
+		return nil, err
+	}
+	less := lessFunc(orderBy)
+
+	// end is how many rows we need before applying the offset
+	end := -1
+	if plan.limit != -1 {
+		end = int(plan.offset + plan.limit)
+	}
+
+	if plan.grouped {
+		rows, err := plan.group(results, qr.Fields)
+		if err != nil {
+			return nil, err
+		}
+		if len(orderBy) != 0 {
+			sort.Stable(rowSorter{rows, less})
+		}
+		qr.Rows = rows
+	} else if len(orderBy) != 0 {
+		qr.Rows = mergeSorted(results, less, end)
+	} else {
+		for _, result := range results {
+			qr.Rows = append(qr.Rows, result.Rows...)
+		}
+	}
+
+	if plan.limit != -1 {
+		switch {
+		case int(plan.offset) >= len(qr.Rows):
+			qr.Rows = nil
+		case end < len(qr.Rows):
+			qr.Rows = qr.Rows[plan.offset:end]
+		default:
+			qr.Rows = qr.Rows[plan.offset:]
+		}
+	}
+	if plan.hidden != 0 {
+		qr.Fields = plan.visibleFields(qr.Fields)
+		for i, row := range qr.Rows {
+			qr.Rows[i] = plan.visibleRow(row)
+		}
+	}
+	qr.RowsAffected = uint64(len(qr.Rows))
+	return qr, nil
+}
+
+// resolveOrderBy returns the columns the merged rows are sorted by.
+func (plan *mergePlan) resolveOrderBy(fields []mproto.Field) ([]orderByColumn, error) {
+	// like mysql, GROUP BY sorts the groups if there is no ORDER BY
+	orderExprs := plan.orderBy
+	if len(orderExprs) == 0 {
+		for _, expr := range plan.groupBy {
+			orderExprs = append(orderExprs, &sqlparser.Order{Expr: expr, Direction: sqlparser.AST_ASC})
+		}
+	}
+	orderBy := make([]orderByColumn, 0, len(orderExprs))
+	for _, order := range orderExprs {
+		if _, ok := order.Expr.(*sqlparser.NullVal); ok {
+			// ORDER BY NULL
+			continue
+		}
+		index, err := plan.resolveColumn(order.Expr, fields)
+		if err != nil {
+			return nil, fmt.Errorf("cannot merge ORDER BY across shards: %v", err)
+		}
+		orderBy = append(orderBy, orderByColumn{
+			index: index,
+			typ:   fields[index].Type,
+			desc:  order.Direction == sqlparser.AST_DESC,
+		})
+	}
+	return orderBy, nil
+}
+
+// lessFunc returns the function that sorts rows by the columns.
+func lessFunc(orderBy []orderByColumn) func(a, b []sqltypes.Value) bool {
+	return func(a, b []sqltypes.Value) bool {
+		for _, col := range orderBy {
+			cmp := compareValues(col.typ, a[col.index], b[col.index])
+			if cmp == 0 {
+				continue
+			}
+			if col.desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return false
+	}
+}
+
+// visibleFields returns the fields without the hidden columns.
+func (plan *mergePlan) visibleFields(fields []mproto.Field) []mproto.Field {
+	if plan.hidden == 0 || len(fields) < plan.hidden {
+		return fields
+	}
+	return fields[:len(fields)-plan.hidden]
+}
+
+// visibleRow returns the row without the hidden columns.
+func (plan *mergePlan) visibleRow(row []sqltypes.Value) []sqltypes.Value {
+	if plan.hidden == 0 || len(row) < plan.hidden {
+		return row
+	}
+	return row[:len(row)-plan.hidden]
+}
+
+// resolveColumn returns the index of the column of the result an
+// ORDER BY or GROUP BY expression refers to.
+func (plan *mergePlan) resolveColumn(expr sqlparser.ValExpr, fields []mproto.Field) (int, error) {
+	if num, ok := expr.(sqlparser.NumVal); ok {
+		position, err := strconv.Atoi(string(num))
+		if err != nil || position < 1 || position > len(fields) {
+			return 0, fmt.Errorf("invalid column position %s", num)
+		}
+		return position - 1, nil
+	}
+
+	name := sqlparser.String(expr)
+	if col, ok := expr.(*sqlparser.ColName); ok {
+		name = string(col.Name)
+	}
+	for i, field := range fields {
+		if strings.EqualFold(field.Name, name) {
+			return i, nil
+		}
+	}
+
+	// the column may be selected with an alias
+	for i, selectExpr := range plan.selectExprs {
+		nse, ok := selectExpr.(*sqlparser.NonStarExpr)
+		if !ok {
+			break
+		}
+		if sqlparser.String(nse.Expr) == sqlparser.String(expr) && i < len(fields) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%v is not in the select list", sqlparser.String(expr))
+}
+
+// group groups the rows of all the results, and re-aggregates the
+// aggregate columns of each group. Groups are returned in the order
+// they are first seen.
+func (plan *mergePlan) group(results []*mproto.QueryResult, fields []mproto.Field) ([][]sqltypes.Value, error) {
+	var keyColumns []int
+	if len(plan.groupBy) != 0 {
+		keyColumns = make([]int, len(plan.groupBy))
+		for i, expr := range plan.groupBy {
+			index, err := plan.resolveColumn(expr, fields)
+			if err != nil {
+				return nil, fmt.Errorf("cannot merge GROUP BY across shards: %v", err)
+			}
+			keyColumns[i] = index
+		}
+	} else if len(plan.aggregates) == 0 {
+		// distinct
+		keyColumns = make([]int, len(fields))
+		for i := range fields {
+			keyColumns[i] = i
+		}
+	}
+	for _, agg := range plan.aggregates {
+		if agg.index >= len(fields) {
+			return nil, fmt.Errorf("cannot merge %v across shards: no such column", agg.function)
+		}
+	}
+
+	var groups [][]sqltypes.Value
+	groupIndexes := make(map[string]int)
+	key := new(bytes.Buffer)
+	for _, result := range results {
+		for _, row := range result.Rows {
+			key.Reset()
+			for _, index := range keyColumns {
+				if row[index].IsNull() {
+					key.WriteString("N")
+					continue
+				}
+				raw := row[index].Raw()
+				if isText(fields[index].Type) {
+					// the values that are equal in the
+					// collation are in the same group
+					raw = collationKey(raw)
+				}
+				fmt.Fprintf(key, "%d:", len(raw))
+				key.Write(raw)
+			}
+			i, ok := groupIndexes[key.String()]
+			if !ok {
+				groupIndexes[key.String()] = len(groups)
+				groups = append(groups, append([]sqltypes.Value(nil), row...))
+				continue
+			}
+			for _, agg := range plan.aggregates {
+				merged, err := aggregate(agg.function, fields[agg.index].Type, groups[i][agg.index], row[agg.index])
+				if err != nil {
+					return nil, err
+				}
+				groups[i][agg.index] = merged
+			}
+		}
+	}
+	return groups, nil
+}
+
+// aggregate combines two partial results of an aggregate function.
+func aggregate(function string, typ int64, a, b sqltypes.Value) (sqltypes.Value, error) {
+	if a.IsNull() {
+		return b, nil
+	}
+	if b.IsNull() {
+		return a, nil
+	}
+	switch function {
+	case "count", "sum":
+		return addValues(a, b)
+	case "min":
+		if compareValues(typ, b, a) < 0 {
+			return b, nil
+		}
+		return a, nil
+	case "max":
+		if compareValues(typ, b, a) > 0 {
+			return b, nil
+		}
+		return a, nil
+	}
+	return sqltypes.NULL, fmt.Errorf("unexpected aggregate function %v", function)
+}
+
+// addValues adds two numbers, as integers if they both are.
+func addValues(a, b sqltypes.Value) (sqltypes.Value, error) {
+	ia, erra := strconv.ParseInt(a.String(), 10, 64)
+	ib, errb := strconv.ParseInt(b.String(), 10, 64)
+	if erra == nil && errb == nil {
+		sum := ia + ib
+		// on overflow, use a float
+		if (sum > ia) == (ib > 0) {
+			return sqltypes.MakeNumeric([]byte(strconv.FormatInt(sum, 10))), nil
+		}
+	}
+	fa, err := strconv.ParseFloat(a.String(), 64)
+	if err != nil {
+		return sqltypes.NULL, fmt.Errorf("cannot add %v: %v", a, err)
+	}
+	fb, err := strconv.ParseFloat(b.String(), 64)
+	if err != nil {
+		return sqltypes.NULL, fmt.Errorf("cannot add %v: %v", b, err)
+	}
+	return sqltypes.MakeFractional([]byte(strconv.FormatFloat(fa+fb, 'f', -1, 64))), nil
+}
+
+// compareValues compares two values of a column of the given mysql
+// type. NULL is smaller than any other value, like in mysql. Text is
+// compared like the default case-insensitive collations do.
+func compareValues(typ int64, a, b sqltypes.Value) int {
+	switch {
+	case a.IsNull() && b.IsNull():
+		return 0
+	case a.IsNull():
+		return -1
+	case b.IsNull():
+		return 1
+	}
+
+	switch typ {
+	case mproto.VT_TINY, mproto.VT_SHORT, mproto.VT_LONG, mproto.VT_LONGLONG, mproto.VT_INT24, mproto.VT_YEAR:
+		ia, erra := strconv.ParseInt(a.String(), 10, 64)
+		ib, errb := strconv.ParseInt(b.String(), 10, 64)
+		if erra == nil && errb == nil {
+			return compareInt64(ia, ib)
+		}
+		// unsigned values that don't fit in an int64
+		ua, erra := strconv.ParseUint(a.String(), 10, 64)
+		ub, errb := strconv.ParseUint(b.String(), 10, 64)
+		if erra == nil && errb == nil {
+			switch {
+			case ua < ub:
+				return -1
+			case ua > ub:
+				return 1
+			}
+			return 0
+		}
+		if erra == nil {
+			// b is negative
+			return 1
+		}
+		if errb == nil {
+			return -1
+		}
+	case mproto.VT_FLOAT, mproto.VT_DOUBLE, mproto.VT_DECIMAL, mproto.VT_NEWDECIMAL:
+		fa, erra := strconv.ParseFloat(a.String(), 64)
+		fb, errb := strconv.ParseFloat(b.String(), 64)
+		if erra == nil && errb == nil {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	if isText(typ) {
+		return bytes.Compare(collationKey(a.Raw()), collationKey(b.Raw()))
+	}
+	return bytes.Compare(a.Raw(), b.Raw())
+}
+
+// isText returns true for the types of the text columns. The binary
+// strings have the same types, they are compared like text too.
+func isText(typ int64) bool {
+	switch typ {
+	case mproto.VT_VARCHAR, mproto.VT_VAR_STRING, mproto.VT_STRING,
+		mproto.VT_TINY_BLOB, mproto.VT_MEDIUM_BLOB, mproto.VT_LONG_BLOB, mproto.VT_BLOB:
+		return true
+	}
+	return false
+}
+
+// latin1Weights are the letters the characters from U+00C0 to U+00FF
+// sort as in utf8_general_ci.
+var latin1Weights = []rune("AAAAAAÆCEEEEIIIIÐNOOOOO×ØUUUUYÞSAAAAAAÆCEEEEIIIIÐNOOOOO÷ØUUUUYÞY")
+
+// collationKey returns the key that sorts utf8 text like
+// utf8_general_ci: the case and the accents of the latin letters
+// are ignored, and so are the trailing spaces.
+func collationKey(text []byte) []byte {
+	text = bytes.TrimRight(text, " ")
+	key := make([]byte, 0, len(text))
+	buf := make([]byte, utf8.UTFMax)
+	for len(text) != 0 {
+		r, size := utf8.DecodeRune(text)
+		text = text[size:]
+		if r >= 0xC0 && r <= 0xFF {
+			r = latin1Weights[r-0xC0]
+		} else {
+			r = unicode.ToUpper(r)
+		}
+		n := utf8.EncodeRune(buf, r)
+		key = append(key, buf[:n]...)
+	}
+	return key
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// rowSorter sorts rows with a less function.
+type rowSorter struct {
+	rows [][]sqltypes.Value
+	less func(a, b []sqltypes.Value) bool
+}
+
+func (rs rowSorter) Len() int           { return len(rs.rows) }
+func (rs rowSorter) Swap(i, j int)      { rs.rows[i], rs.rows[j] = rs.rows[j], rs.rows[i] }
+func (rs rowSorter) Less(i, j int) bool { return rs.less(rs.rows[i], rs.rows[j]) }
+
+// mergeCursor is the position in the rows of one shard.
+type mergeCursor struct {
+	rows  [][]sqltypes.Value
+	pos   int
+	shard int
+
+	// next returns the next result of a streaming shard, or nil
+	// at the end of the stream. It is nil if rows are all the
+	// rows of the shard.
+	next func() *mproto.QueryResult
+}
+
+// fill reads the next results of the stream until the cursor is on a
+// row. It returns false at the end of the rows.
+func (c *mergeCursor) fill() bool {
+	for c.pos == len(c.rows) {
+		if c.next == nil {
+			return false
+		}
+		qr := c.next()
+		if qr == nil {
+			return false
+		}
+		c.rows, c.pos = qr.Rows, 0
+	}
+	return true
+}
+
+// mergeHeap is a heap of the cursors on the results of the shards,
+// the cursor with the smallest row first.
+type mergeHeap struct {
+	cursors []*mergeCursor
+	less    func(a, b []sqltypes.Value) bool
+}
+
+func (mh *mergeHeap) Len() int      { return len(mh.cursors) }
+func (mh *mergeHeap) Swap(i, j int) { mh.cursors[i], mh.cursors[j] = mh.cursors[j], mh.cursors[i] }
+
+func (mh *mergeHeap) Less(i, j int) bool {
+	a, b := mh.cursors[i], mh.cursors[j]
+	if mh.less(a.rows[a.pos], b.rows[b.pos]) {
+		return true
+	}
+	if mh.less(b.rows[b.pos], a.rows[a.pos]) {
+		return false
+	}
+	// keep the merge stable
+	return a.shard < b.shard
+}
+
+func (mh *mergeHeap) Push(x interface{}) {
+	mh.cursors = append(mh.cursors, x.(*mergeCursor))
+}
+
+func (mh *mergeHeap) Pop() interface{} {
+	n := len(mh.cursors)
+	c := mh.cursors[n-1]
+	mh.cursors = mh.cursors[:n-1]
+	return c
+}
+
+// mergeSorted does a k-way merge of the rows of the results, which
+// are each sorted already. It stops after count rows, unless count
+// is -1.
+func mergeSorted(results []*mproto.QueryResult, less func(a, b []sqltypes.Value) bool, count int) [][]sqltypes.Value {
+	mh := &mergeHeap{less: less}
+	total := 0
+	for i, result := range results {
+		if len(result.Rows) != 0 {
+			mh.cursors = append(mh.cursors, &mergeCursor{rows: result.Rows, shard: i})
+			total += len(result.Rows)
+		}
+	}
+	if count == -1 || count > total {
+		count = total
+	}
+	heap.Init(mh)
+
+	rows := make([][]sqltypes.Value, 0, count)
+	for len(rows) < count {
+		c := mh.cursors[0]
+		rows = append(rows, c.rows[c.pos])
+		c.pos++
+		if c.pos == len(c.rows) {
+			heap.Pop(mh)
+		} else {
+			heap.Fix(mh, 0)
+		}
+	}
+	return rows
+}
+
+// mergeReplyRows is the number of rows of the replies of a merged
+// stream.
+const mergeReplyRows = 1000
+
+// mergeStreams does a k-way merge of the streams of the shards, which
+// are each sorted already, and sends the merged rows with sendReply.
+// The first reply has the fields. The plan must not be grouped.
+func (plan *mergePlan) mergeStreams(streams []func() *mproto.QueryResult, sendReply func(reply *mproto.QueryResult) error) error {
+	var fields []mproto.Field
+	mh := &mergeHeap{}
+	for i, next := range streams {
+		// the first result of a stream has the fields
+		qr := next()
+		if qr == nil {
+			continue
+		}
+		if fields == nil {
+			fields = qr.Fields
+		}
+		c := &mergeCursor{rows: qr.Rows, shard: i, next: next}
+		if c.fill() {
+			mh.cursors = append(mh.cursors, c)
+		}
+	}
+	if fields == nil {
+		return nil
+	}
+	orderBy, err := plan.resolveOrderBy(fields)
+	if err != nil {
+		return err
+	}
+	mh.less = lessFunc(orderBy)
+	heap.Init(mh)
+	if err := sendReply(&mproto.QueryResult{Fields: plan.visibleFields(fields)}); err != nil {
+		return err
+	}
+
+	skip := plan.offset
+	remaining := plan.limit
+	reply := &mproto.QueryResult{}
+	for mh.Len() != 0 && remaining != 0 {
+		c := mh.cursors[0]
+		if skip > 0 {
+			skip--
+		} else {
+			reply.Rows = append(reply.Rows, plan.visibleRow(c.rows[c.pos]))
+			if remaining > 0 {
+				remaining--
+			}
+		}
+		c.pos++
+		if c.fill() {
+			heap.Fix(mh, 0)
+		} else {
+			heap.Pop(mh)
+		}
+		if len(reply.Rows) == mergeReplyRows {
+			reply.RowsAffected = uint64(len(reply.Rows))
+			if err := sendReply(reply); err != nil {
+				return err
+			}
+			reply = &mproto.QueryResult{}
+		}
+	}
+	if len(reply.Rows) != 0 {
+		reply.RowsAffected = uint64(len(reply.Rows))
+		return sendReply(reply)
+	}
+	return nil
+}
@@ -0,0 +1,442 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/context"
+)
+
+func TestBuildMergePlan(t *testing.T) {
+	testCases := []struct {
+		sql      string
+		bindVars map[string]interface{}
+		shardSql string
+	}{
+		{"select a from t", nil, ""},
+		{"insert into t(a) values (1)", nil, ""},
+		{"not a query", nil, ""},
+		{"select a from t order by a", nil, "select a from t order by a asc"},
+		{"select a from t order by a limit 5", nil, "select a from t order by a asc limit 5"},
+		{"select a from t order by a limit 5, 10", nil, "select a from t order by a asc limit 15"},
+		{"select a from t limit :offset, :count", map[string]interface{}{"offset": 2, "count": int64(3)}, "select a from t limit 5"},
+		{"select count(*), sum(b) from t limit 1", nil, "select count(*), sum(b) from t"},
+		{"select a, max(b) from t group by a", nil, "select a, max(b) from t group by a"},
+		{"select distinct a from t", nil, "select distinct a from t"},
+		{"select a from t order by b", nil, "select a, b from t order by b asc"},
+		{"select a as b from t order by b", nil, "select a as b from t order by b asc"},
+		{"select t.a from t order by a desc, a + 1", nil, "select t.a, a+1 from t order by a desc, a+1 asc"},
+		{"select * from t order by b", nil, "select * from t order by b asc"},
+		{"select count(*) from t group by a", nil, "select count(*), a from t group by a"},
+	}
+	for _, tc := range testCases {
+		plan, err := buildMergePlan(tc.sql, tc.bindVars)
+		if err != nil {
+			t.Errorf("buildMergePlan(%v) failed: %v", tc.sql, err)
+			continue
+		}
+		if tc.shardSql == "" {
+			if plan != nil {
+				t.Errorf("buildMergePlan(%v): got %+v, want nil", tc.sql, plan)
+			}
+			continue
+		}
+		if plan == nil || plan.shardSql != tc.shardSql {
+			t.Errorf("buildMergePlan(%v): got %+v, want shard sql %v", tc.sql, plan, tc.shardSql)
+		}
+	}
+
+	errorCases := []struct {
+		sql string
+		err string
+	}{
+		{"select avg(a) from t", "cannot merge avg(a)"},
+		{"select count(distinct a) from t", "cannot merge count(distinct a)"},
+		{"select *, count(*) from t", "after a *"},
+		{"select a, count(*) from t group by a having count(*) > 1", "HAVING"},
+		{"select a from t limit :count", "No bind variable for :count"},
+		{"select distinct a from t order by b", "b across shards: it is not in the DISTINCT select list"},
+	}
+	for _, tc := range errorCases {
+		if _, err := buildMergePlan(tc.sql, nil); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("buildMergePlan(%v): got %v, want %v", tc.sql, err, tc.err)
+		}
+	}
+}
+
+func makeResult(fields []mproto.Field, rows ...[]string) *mproto.QueryResult {
+	qr := &mproto.QueryResult{Fields: fields, RowsAffected: uint64(len(rows))}
+	for _, row := range rows {
+		values := make([]sqltypes.Value, len(row))
+		for i, v := range row {
+			if v != "NULL" {
+				values[i] = sqltypes.MakeString([]byte(v))
+			}
+		}
+		qr.Rows = append(qr.Rows, values)
+	}
+	return qr
+}
+
+func resultRows(qr *mproto.QueryResult) [][]string {
+	rows := make([][]string, len(qr.Rows))
+	for i, row := range qr.Rows {
+		rows[i] = make([]string, len(row))
+		for j, v := range row {
+			if v.IsNull() {
+				rows[i][j] = "NULL"
+			} else {
+				rows[i][j] = v.String()
+			}
+		}
+	}
+	return rows
+}
+
+func TestMerge(t *testing.T) {
+	// each shard returns its rows sorted by the ORDER BY clause
+	fields := []mproto.Field{{Name: "a", Type: mproto.VT_LONG}, {Name: "b", Type: mproto.VT_VAR_STRING}}
+	shard1 := makeResult(fields, []string{"1", "x"}, []string{"3", "y"}, []string{"10", "x"})
+	shard2 := makeResult(fields, []string{"2", "y"}, []string{"9", "z"})
+	shard3 := makeResult(fields, []string{"NULL", "x"})
+	desc1 := makeResult(fields, []string{"10", "x"}, []string{"3", "y"}, []string{"1", "x"})
+	desc2 := makeResult(fields, []string{"9", "z"}, []string{"2", "y"})
+	byB1 := makeResult(fields, []string{"10", "x"}, []string{"1", "x"}, []string{"3", "y"})
+	byB2 := makeResult(fields, []string{"2", "y"}, []string{"9", "z"})
+
+	testCases := []struct {
+		sql     string
+		results []*mproto.QueryResult
+		want    [][]string
+	}{
+		{"select a, b from t order by a", []*mproto.QueryResult{shard1, shard2, shard3}, [][]string{{"NULL", "x"}, {"1", "x"}, {"2", "y"}, {"3", "y"}, {"9", "z"}, {"10", "x"}}},
+		{"select a, b from t order by a desc limit 3", []*mproto.QueryResult{desc1, desc2, shard3}, [][]string{{"10", "x"}, {"9", "z"}, {"3", "y"}}},
+		{"select a, b from t order by 2, a desc limit 1, 2", []*mproto.QueryResult{byB1, byB2, shard3}, [][]string{{"1", "x"}, {"NULL", "x"}}},
+		{"select a, b from t order by a limit 10, 5", []*mproto.QueryResult{shard1, shard2, shard3}, [][]string{}},
+		{"select a, b from t order by a limit 4, 5", []*mproto.QueryResult{shard1, shard2, shard3}, [][]string{{"9", "z"}, {"10", "x"}}},
+	}
+	for _, tc := range testCases {
+		plan, err := buildMergePlan(tc.sql, nil)
+		if err != nil {
+			t.Fatalf("buildMergePlan(%v) failed: %v", tc.sql, err)
+		}
+		qr, err := plan.merge(tc.results)
+		if err != nil {
+			t.Errorf("merge(%v) failed: %v", tc.sql, err)
+			continue
+		}
+		if got := resultRows(qr); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("merge(%v): got %v, want %v", tc.sql, got, tc.want)
+		}
+		if qr.RowsAffected != uint64(len(tc.want)) {
+			t.Errorf("merge(%v): got RowsAffected %v, want %v", tc.sql, qr.RowsAffected, len(tc.want))
+		}
+	}
+
+	// order by a column that is not selected: the shards return
+	// it, and it is removed after the merge
+	plan, err := buildMergePlan("select b from t order by a", nil)
+	if err != nil {
+		t.Fatalf("buildMergePlan failed: %v", err)
+	}
+	byA1 := makeResult([]mproto.Field{fields[1], fields[0]}, []string{"x", "1"}, []string{"y", "3"})
+	byA2 := makeResult([]mproto.Field{fields[1], fields[0]}, []string{"y", "2"})
+	qr, err := plan.merge([]*mproto.QueryResult{byA1, byA2})
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if want := fields[1:]; !reflect.DeepEqual(qr.Fields, want) {
+		t.Errorf("merge: got fields %v, want %v", qr.Fields, want)
+	}
+	if got, want := resultRows(qr), [][]string{{"x"}, {"y"}, {"y"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("merge: got %v, want %v", got, want)
+	}
+
+	// text is sorted like the case-insensitive collations do
+	textFields := []mproto.Field{{Name: "b", Type: mproto.VT_VAR_STRING}}
+	plan, err = buildMergePlan("select b from t order by b", nil)
+	if err != nil {
+		t.Fatalf("buildMergePlan failed: %v", err)
+	}
+	qr, err = plan.merge([]*mproto.QueryResult{
+		makeResult(textFields, []string{"a"}, []string{"B"}, []string{"é"}),
+		makeResult(textFields, []string{"A"}, []string{"c"}),
+	})
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if got, want := resultRows(qr), [][]string{{"a"}, {"A"}, {"B"}, {"c"}, {"é"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("merge: got %v, want %v", got, want)
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	testCases := []struct {
+		typ  int64
+		a, b string
+		want int
+	}{
+		{mproto.VT_LONG, "9", "10", -1},
+		{mproto.VT_LONGLONG, "18446744073709551615", "-1", 1},
+		{mproto.VT_DOUBLE, "1.5", "1.25", 1},
+		{mproto.VT_VAR_STRING, "a", "B", -1},
+		{mproto.VT_VAR_STRING, "abc", "ABC  ", 0},
+		{mproto.VT_STRING, "Élan", "elan", 0},
+		{mproto.VT_BLOB, "straße", "STRASSE", -1},
+		{mproto.VT_DATETIME, "2014-01-02 00:00:00", "2014-01-10 00:00:00", -1},
+		{mproto.VT_VAR_STRING, "NULL", "a", -1},
+	}
+	value := func(v string) sqltypes.Value {
+		if v == "NULL" {
+			return sqltypes.NULL
+		}
+		return sqltypes.MakeString([]byte(v))
+	}
+	for _, tc := range testCases {
+		if got := compareValues(tc.typ, value(tc.a), value(tc.b)); got != tc.want {
+			t.Errorf("compareValues(%v, %v, %v): got %v, want %v", tc.typ, tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestMergeAggregates(t *testing.T) {
+	aggFields := []mproto.Field{{Name: "count(*)", Type: mproto.VT_LONGLONG}, {Name: "sum(b)", Type: mproto.VT_NEWDECIMAL}, {Name: "min(c)", Type: mproto.VT_LONG}, {Name: "max(d)", Type: mproto.VT_VAR_STRING}}
+	groupFields := []mproto.Field{{Name: "a", Type: mproto.VT_VAR_STRING}, {Name: "count(*)", Type: mproto.VT_LONGLONG}, {Name: "max(b)", Type: mproto.VT_LONG}}
+	testCases := []struct {
+		sql     string
+		results []*mproto.QueryResult
+		want    [][]string
+	}{{
+		"select count(*), sum(b), min(c), max(d) from t",
+		[]*mproto.QueryResult{
+			makeResult(aggFields, []string{"2", "5", "10", "b"}),
+			makeResult(aggFields, []string{"0", "NULL", "NULL", "NULL"}),
+			makeResult(aggFields, []string{"3", "2.5", "9", "a"}),
+		},
+		[][]string{{"5", "7.5", "9", "b"}},
+	}, {
+		"select a, count(*), max(b) from t group by a",
+		[]*mproto.QueryResult{
+			makeResult(groupFields, []string{"x", "1", "5"}, []string{"z", "2", "1"}),
+			makeResult(groupFields, []string{"y", "4", "3"}, []string{"z", "1", "10"}),
+		},
+		[][]string{{"x", "1", "5"}, {"y", "4", "3"}, {"z", "3", "10"}},
+	}, {
+		"select a, count(*), max(b) from t group by a order by 2 desc limit 2",
+		[]*mproto.QueryResult{
+			makeResult(groupFields, []string{"x", "1", "5"}, []string{"z", "2", "1"}),
+			makeResult(groupFields, []string{"y", "4", "3"}, []string{"z", "1", "10"}),
+		},
+		[][]string{{"y", "4", "3"}, {"z", "3", "10"}},
+	}, {
+		"select distinct a from t",
+		[]*mproto.QueryResult{
+			makeResult([]mproto.Field{{Name: "a", Type: mproto.VT_LONG}}, []string{"1"}, []string{"2"}),
+			makeResult([]mproto.Field{{Name: "a", Type: mproto.VT_LONG}}, []string{"2"}, []string{"3"}),
+		},
+		[][]string{{"1"}, {"2"}, {"3"}},
+	}, {
+		"select distinct a from t",
+		[]*mproto.QueryResult{
+			makeResult([]mproto.Field{{Name: "a", Type: mproto.VT_VAR_STRING}}, []string{"x"}, []string{"Y "}),
+			makeResult([]mproto.Field{{Name: "a", Type: mproto.VT_VAR_STRING}}, []string{"X"}, []string{"y"}),
+		},
+		[][]string{{"x"}, {"Y "}},
+	}, {
+		"select count(*) from t group by a",
+		[]*mproto.QueryResult{
+			makeResult([]mproto.Field{{Name: "count(*)", Type: mproto.VT_LONGLONG}, {Name: "a", Type: mproto.VT_LONG}}, []string{"2", "1"}, []string{"1", "2"}),
+			makeResult([]mproto.Field{{Name: "count(*)", Type: mproto.VT_LONGLONG}, {Name: "a", Type: mproto.VT_LONG}}, []string{"4", "1"}),
+		},
+		[][]string{{"6"}, {"1"}},
+	}}
+	for _, tc := range testCases {
+		plan, err := buildMergePlan(tc.sql, nil)
+		if err != nil {
+			t.Fatalf("buildMergePlan(%v) failed: %v", tc.sql, err)
+		}
+		qr, err := plan.merge(tc.results)
+		if err != nil {
+			t.Errorf("merge(%v) failed: %v", tc.sql, err)
+			continue
+		}
+		if got := resultRows(qr); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("merge(%v): got %v, want %v", tc.sql, got, tc.want)
+		}
+	}
+}
+
+func TestScatterConnExecuteMerge(t *testing.T) {
+	s := createSandbox("TestScatterConnExecuteMerge")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+
+	// each shard returns singleRowResult, the count is added up
+	qr, err := stc.Execute(&context.DummyContext{}, "select count(*), max(value) from t", nil, "TestScatterConnExecuteMerge", []string{"0", "1"}, "", nil)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if got, want := resultRows(qr), [][]string{{"2", "foo"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// the limit applies to the merged result
+	qr, err = stc.Execute(&context.DummyContext{}, "select id, value from t order by id limit 1", nil, "TestScatterConnExecuteMerge", []string{"0", "1"}, "", nil)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if len(qr.Rows) != 1 || qr.RowsAffected != 1 {
+		t.Errorf("got %+v, want one row", qr)
+	}
+}
+
+// streamOf returns a stream of the results.
+func streamOf(results ...*mproto.QueryResult) func() *mproto.QueryResult {
+	return func() *mproto.QueryResult {
+		if len(results) == 0 {
+			return nil
+		}
+		qr := results[0]
+		results = results[1:]
+		return qr
+	}
+}
+
+func TestMergeStreams(t *testing.T) {
+	fields := []mproto.Field{{Name: "a", Type: mproto.VT_LONG}, {Name: "b", Type: mproto.VT_VAR_STRING}}
+	testCases := []struct {
+		sql     string
+		streams []func() *mproto.QueryResult
+		fields  []mproto.Field
+		want    [][]string
+	}{{
+		"select a, b from t order by a",
+		[]func() *mproto.QueryResult{
+			// the fields come first, the rows in several results
+			streamOf(makeResult(fields), makeResult(nil, []string{"1", "x"}), makeResult(nil), makeResult(nil, []string{"5", "y"}, []string{"7", "z"})),
+			streamOf(makeResult(fields, []string{"2", "y"}), makeResult(nil, []string{"6", "x"})),
+			streamOf(),
+			streamOf(makeResult(fields)),
+		},
+		fields,
+		[][]string{{"1", "x"}, {"2", "y"}, {"5", "y"}, {"6", "x"}, {"7", "z"}},
+	}, {
+		"select a from t order by b desc limit 1, 2",
+		[]func() *mproto.QueryResult{
+			streamOf(makeResult(fields, []string{"1", "z"}), makeResult(nil, []string{"5", "x"})),
+			streamOf(makeResult(fields, []string{"2", "y"}, []string{"6", "w"})),
+		},
+		fields[:1],
+		[][]string{{"2"}, {"5"}},
+	}, {
+		"select a, b from t limit 3",
+		[]func() *mproto.QueryResult{
+			streamOf(makeResult(fields, []string{"1", "x"}), makeResult(nil, []string{"5", "y"})),
+			streamOf(makeResult(fields, []string{"2", "y"}, []string{"6", "x"})),
+		},
+		fields,
+		[][]string{{"1", "x"}, {"5", "y"}, {"2", "y"}},
+	}}
+	for _, tc := range testCases {
+		plan, err := buildMergePlan(tc.sql, nil)
+		if err != nil {
+			t.Fatalf("buildMergePlan(%v) failed: %v", tc.sql, err)
+		}
+		var replies []*mproto.QueryResult
+		err = plan.mergeStreams(tc.streams, func(reply *mproto.QueryResult) error {
+			replies = append(replies, reply)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("mergeStreams(%v) failed: %v", tc.sql, err)
+			continue
+		}
+		if len(replies) != 2 {
+			t.Errorf("mergeStreams(%v): got %v replies, want the fields and the rows", tc.sql, len(replies))
+			continue
+		}
+		if !reflect.DeepEqual(replies[0].Fields, tc.fields) || len(replies[0].Rows) != 0 {
+			t.Errorf("mergeStreams(%v): got first reply %+v, want fields %v", tc.sql, replies[0], tc.fields)
+		}
+		if got := resultRows(replies[1]); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("mergeStreams(%v): got %v, want %v", tc.sql, got, tc.want)
+		}
+	}
+
+	// a send error stops the merge
+	plan, err := buildMergePlan("select a, b from t order by a", nil)
+	if err != nil {
+		t.Fatalf("buildMergePlan failed: %v", err)
+	}
+	err = plan.mergeStreams([]func() *mproto.QueryResult{streamOf(makeResult(fields, []string{"1", "x"}))}, func(*mproto.QueryResult) error {
+		return fmt.Errorf("send error")
+	})
+	if err == nil || err.Error() != "send error" {
+		t.Errorf("mergeStreams: got %v, want send error", err)
+	}
+}
+
+func TestScatterConnStreamExecuteMerge(t *testing.T) {
+	s := createSandbox("TestScatterConnStreamExecuteMerge")
+	fields := []mproto.Field{{Name: "id", Type: mproto.VT_LONG}, {Name: "value", Type: mproto.VT_VAR_STRING}}
+	sbc0 := &sandboxConn{streamResults: []*mproto.QueryResult{
+		makeResult(fields, []string{"1", "a"}),
+		makeResult(nil, []string{"4", "b"}),
+	}}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{streamResults: []*mproto.QueryResult{
+		makeResult(fields, []string{"2", "a"}, []string{"3", "c"}),
+	}}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+
+	testCases := []struct {
+		sql  string
+		want [][]string
+	}{
+		{"select id, value from t order by id", [][]string{{"1", "a"}, {"2", "a"}, {"3", "c"}, {"4", "b"}}},
+		{"select id, value from t order by id limit 2", [][]string{{"1", "a"}, {"2", "a"}}},
+		{"select max(id), value from t group by value", [][]string{{"2", "a"}, {"4", "b"}, {"3", "c"}}},
+	}
+	for _, tc := range testCases {
+		qr := new(mproto.QueryResult)
+		err := stc.StreamExecute(&context.DummyContext{}, tc.sql, nil, "TestScatterConnStreamExecuteMerge", []string{"0", "1"}, "", nil, func(r *mproto.QueryResult) error {
+			if r.Fields != nil {
+				qr.Fields = r.Fields
+			}
+			qr.Rows = append(qr.Rows, r.Rows...)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("StreamExecute(%v) failed: %v", tc.sql, err)
+			continue
+		}
+		if qr.Fields == nil {
+			t.Errorf("StreamExecute(%v): no fields", tc.sql)
+		}
+		if got := resultRows(qr); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("StreamExecute(%v): got %v, want %v", tc.sql, got, tc.want)
+		}
+	}
+
+	// a shard error is returned after the merge
+	sbc1.mustFailServer = 1
+	err := stc.StreamExecute(&context.DummyContext{}, "select id, value from t order by id limit 1", nil, "TestScatterConnStreamExecuteMerge", []string{"0", "1"}, "", nil, func(*mproto.QueryResult) error {
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "error: err") {
+		t.Errorf("StreamExecute: got %v, want the shard error", err)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package planbuilder holds the VSchema vtgate routes SQL queries
+// with. It describes how the tables of each keyspace are sharded.
+package planbuilder
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+const (
+	// HashIdentity uses the value of the sharding column as the
+	// keyspace_id: numbers are encoded as 8 bytes big endian,
+	// strings are used as is.
+	HashIdentity = "identity"
+
+	// HashMD5 uses the first 8 bytes of the MD5 of the identity
+	// keyspace_id, so rows are spread evenly over the shards.
+	HashMD5 = "md5"
+)
+
+// VSchema describes how the tables of the keyspaces are sharded. It
+// is stored as JSON in the topology, see topo.Server.SaveVSchema.
+type VSchema struct {
+	Keyspaces map[string]*Keyspace
+}
+
+// Keyspace describes the tables of a keyspace. All the queries for
+// an unsharded keyspace go to its only shard, so its tables don't
+// need to be listed.
+type Keyspace struct {
+	Sharded bool
+	Tables  map[string]*Table
+}
+
+// Table describes how the rows of a table are sharded.
+type Table struct {
+	// ShardingColumn is the column the keyspace_id of a row is
+	// computed from.
+	ShardingColumn string
+
+	// Hash is the function used to compute the keyspace_id from
+	// the value of ShardingColumn, HashIdentity or HashMD5.
+	Hash string
+
+	// Lookups are the other columns that can be used to route
+	// queries, through a lookup table.
+	Lookups []*Lookup
+}
+
+// Lookup describes a lookup table, that maps the values of a column
+// of a sharded table to the values of its sharding column. Lookup
+// tables live in unsharded keyspaces, and are maintained by the
+// application.
+type Lookup struct {
+	// Column is the column of the sharded table.
+	Column string
+
+	// Keyspace and Table are where the lookup table is.
+	Keyspace string
+	Table    string
+
+	// From and To are the columns of the lookup table that hold
+	// the values of Column and of the sharding column.
+	From string
+	To   string
+}
+
+// NewVSchema parses and validates a JSON VSchema.
+func NewVSchema(data []byte) (*VSchema, error) {
+	vschema := &VSchema{}
+	if err := json.Unmarshal(data, vschema); err != nil {
+		return nil, fmt.Errorf("cannot parse vschema: %v", err)
+	}
+	if err := vschema.validate(); err != nil {
+		return nil, err
+	}
+	return vschema, nil
+}
+
+// LoadVSchema reads the VSchema from the topology. If there is none,
+// it returns an empty VSchema.
+func LoadVSchema(ts topo.Server) (*VSchema, error) {
+	data, err := ts.GetVSchema()
+	switch err {
+	case nil:
+		return NewVSchema([]byte(data))
+	case topo.ErrNoNode:
+		return &VSchema{Keyspaces: make(map[string]*Keyspace)}, nil
+	}
+	return nil, err
+}
+
+func (vschema *VSchema) validate() error {
+	if vschema.Keyspaces == nil {
+		vschema.Keyspaces = make(map[string]*Keyspace)
+	}
+	for ksName, ks := range vschema.Keyspaces {
+		if ks == nil {
+			return fmt.Errorf("keyspace %v has no definition", ksName)
+		}
+		if !ks.Sharded {
+			continue
+		}
+		for tableName, table := range ks.Tables {
+			if table == nil || table.ShardingColumn == "" {
+				return fmt.Errorf("table %v.%v has no sharding column", ksName, tableName)
+			}
+			if table.Hash != HashIdentity && table.Hash != HashMD5 {
+				return fmt.Errorf("table %v.%v has unknown hash %q", ksName, tableName, table.Hash)
+			}
+			for _, lookup := range table.Lookups {
+				if lookup == nil || lookup.Column == "" || lookup.Table == "" || lookup.From == "" || lookup.To == "" {
+					return fmt.Errorf("table %v.%v has an incomplete lookup: %+v", ksName, tableName, lookup)
+				}
+				lks, ok := vschema.Keyspaces[lookup.Keyspace]
+				if !ok || lks == nil || lks.Sharded {
+					return fmt.Errorf("lookup for %v.%v.%v has to be in an unsharded keyspace of the vschema, not %q", ksName, tableName, lookup.Column, lookup.Keyspace)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// KeyspaceId returns the keyspace_id of a row that has the given
+// value in its sharding column. The value can be an integer, a string,
+// a []byte or a sqltypes.Value.
+func (table *Table) KeyspaceId(value interface{}) (key.KeyspaceId, error) {
+	encoded, err := encodeValue(value)
+	if err != nil {
+		return "", err
+	}
+	switch table.Hash {
+	case HashIdentity:
+		return key.KeyspaceId(encoded), nil
+	case HashMD5:
+		sum := md5.Sum([]byte(encoded))
+		return key.KeyspaceId(sum[:8]), nil
+	}
+	return "", fmt.Errorf("unknown hash %q", table.Hash)
+}
+
+// encodeValue returns the identity keyspace_id for a value, using the
+// same encoding as key.EncodeValue.
+func encodeValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case int:
+		return key.Uint64Key(v).String(), nil
+	case int32:
+		return key.Uint64Key(v).String(), nil
+	case uint32:
+		return key.Uint64Key(v).String(), nil
+	case int64:
+		return key.Uint64Key(v).String(), nil
+	case uint64:
+		return key.Uint64Key(v).String(), nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case sqltypes.Value:
+		if v.IsNull() {
+			return "", fmt.Errorf("cannot compute the keyspace_id of NULL")
+		}
+		if v.IsNumeric() {
+			if u, err := v.ParseUint64(); err == nil {
+				return key.Uint64Key(u).String(), nil
+			}
+			i, err := v.ParseInt64()
+			if err != nil {
+				return "", err
+			}
+			return key.Uint64Key(i).String(), nil
+		}
+		return string(v.Raw()), nil
+	}
+	return "", fmt.Errorf("unexpected type %T for a sharding column value", value)
+}
@@ -0,0 +1,82 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/key"
+)
+
+const testVSchema = `{
+  "Keyspaces": {
+    "user": {
+      "Sharded": true,
+      "Tables": {
+        "user": {
+          "ShardingColumn": "id",
+          "Hash": "md5",
+          "Lookups": [{"Column": "name", "Keyspace": "lookup", "Table": "name_user_idx", "From": "name", "To": "user_id"}]
+        },
+        "music": {"ShardingColumn": "user_id", "Hash": "identity"}
+      }
+    },
+    "lookup": {}
+  }
+}`
+
+func TestNewVSchema(t *testing.T) {
+	vschema, err := NewVSchema([]byte(testVSchema))
+	if err != nil {
+		t.Fatalf("NewVSchema failed: %v", err)
+	}
+	if lookup := vschema.Keyspaces["user"].Tables["user"].Lookups[0]; lookup.To != "user_id" {
+		t.Errorf("unexpected lookup: %+v", lookup)
+	}
+
+	badCases := []struct {
+		vschema string
+		err     string
+	}{
+		{`{`, "cannot parse vschema"},
+		{`{"Keyspaces": {"ks": {"Sharded": true, "Tables": {"t": {"Hash": "md5"}}}}}`, "has no sharding column"},
+		{`{"Keyspaces": {"ks": {"Sharded": true, "Tables": {"t": {"ShardingColumn": "id", "Hash": "crc"}}}}}`, "unknown hash"},
+		{`{"Keyspaces": {"ks": {"Sharded": true, "Tables": {"t": {"ShardingColumn": "id", "Hash": "md5", "Lookups": [{"Column": "c"}]}}}}}`, "incomplete lookup"},
+		{`{"Keyspaces": {"ks": {"Sharded": true, "Tables": {"t": {"ShardingColumn": "id", "Hash": "md5", "Lookups": [{"Column": "c", "Keyspace": "ks", "Table": "l", "From": "c", "To": "id"}]}}}}}`, "unsharded keyspace"},
+	}
+	for _, tc := range badCases {
+		if _, err := NewVSchema([]byte(tc.vschema)); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("NewVSchema(%v): got %v, want %v", tc.vschema, err, tc.err)
+		}
+	}
+}
+
+func TestKeyspaceId(t *testing.T) {
+	identity := &Table{ShardingColumn: "id", Hash: HashIdentity}
+	want := key.Uint64Key(5).KeyspaceId()
+	for _, value := range []interface{}{5, int64(5), uint64(5), sqltypes.MakeNumeric([]byte("5"))} {
+		got, err := identity.KeyspaceId(value)
+		if err != nil || got != want {
+			t.Errorf("KeyspaceId(%#v): got %v %v, want %v", value, got, err, want)
+		}
+	}
+	if got, err := identity.KeyspaceId("abc"); err != nil || got != key.KeyspaceId("abc") {
+		t.Errorf("KeyspaceId(abc): got %v %v", got, err)
+	}
+	if _, err := identity.KeyspaceId(sqltypes.NULL); err == nil {
+		t.Errorf("KeyspaceId(NULL) worked")
+	}
+
+	md5 := &Table{ShardingColumn: "id", Hash: HashMD5}
+	got, err := md5.KeyspaceId(5)
+	if err != nil || len(got) != 8 || got == want {
+		t.Errorf("md5 KeyspaceId(5): got %v %v", got, err)
+	}
+	if again, _ := md5.KeyspaceId(int64(5)); again != got {
+		t.Errorf("md5 KeyspaceId is not stable: %v != %v", again, got)
+	}
+}
@@ -37,6 +37,16 @@ func (shardSession *ShardSession) String() string {
 	return fmt.Sprintf("Keyspace: %v, Shard: %v, TabletType: %v, TransactionId: %v", shardSession.Keyspace, shardSession.Shard, shardSession.TabletType, shardSession.TransactionId)
 }
 
+// Query represents a query request for a keyspace.
+// vtgate finds the shards to send it to using its VSchema.
+type Query struct {
+	Sql           string
+	BindVariables map[string]interface{}
+	Keyspace      string
+	TabletType    topo.TabletType
+	Session       *Session
+}
+
 // QueryShard represents a query request for the
 // specified list of shards.
 type QueryShard struct {
@@ -120,3 +130,9 @@ type QueryResultList struct {
 	Session *Session
 	Error   string
 }
+
+// QueryExplanationResult is the reply of ExplainShard.
+type QueryExplanationResult struct {
+	Explanation *tproto.QueryExplanation
+	Error       string
+}
@@ -91,6 +91,71 @@ func TestSession(t *testing.T) {
 	}
 }
 
+type reflectQuery struct {
+	Sql           string
+	BindVariables map[string]interface{}
+	Keyspace      string
+	TabletType    topo.TabletType
+	Session       *Session
+}
+
+type extraQuery struct {
+	Extra         int
+	Sql           string
+	BindVariables map[string]interface{}
+	Keyspace      string
+	TabletType    topo.TabletType
+	Session       *Session
+}
+
+func TestQuery(t *testing.T) {
+	reflected, err := bson.Marshal(&reflectQuery{
+		Sql:           "query",
+		BindVariables: map[string]interface{}{"val": int64(1)},
+		Keyspace:      "keyspace",
+		TabletType:    topo.TabletType("replica"),
+		Session:       &commonSession,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	want := string(reflected)
+
+	custom := Query{
+		Sql:           "query",
+		BindVariables: map[string]interface{}{"val": int64(1)},
+		Keyspace:      "keyspace",
+		TabletType:    topo.TabletType("replica"),
+		Session:       &commonSession,
+	}
+	encoded, err := bson.Marshal(&custom)
+	if err != nil {
+		t.Error(err)
+	}
+	got := string(encoded)
+	if want != got {
+		t.Errorf("want\n%+v, got\n%+v", want, got)
+	}
+
+	var unmarshalled Query
+	err = bson.Unmarshal(encoded, &unmarshalled)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(custom, unmarshalled) {
+		t.Errorf("want \n%+v, got \n%+v", custom, unmarshalled)
+	}
+
+	extra, err := bson.Marshal(&extraQuery{})
+	if err != nil {
+		t.Error(err)
+	}
+	err = bson.Unmarshal(extra, &unmarshalled)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 type reflectQueryShard struct {
 	Sql           string
 	BindVariables map[string]interface{}
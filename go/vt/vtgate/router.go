@@ -7,9 +7,17 @@ package vtgate
 import (
 	"fmt"
 	"strconv"
+	"strings"
+	"sync"
 
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/vt/context"
 	"github.com/youtube/vitess/go/vt/key"
 	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
 )
 
 const (
@@ -21,8 +29,15 @@ const (
 
 type RoutingPlan struct {
 	criteria sqlparser.SQLNode
+
+	// insertIndex is the position of the sharding column in the
+	// rows of an insert.
+	insertIndex int
 }
 
+// entityIdColumn is the sharding column GetShardList routes with.
+const entityIdColumn = "entity_id"
+
 func GetShardList(sql string, bindVariables map[string]interface{}, tabletKeys []key.KeyspaceId) (shardlist []int, err error) {
 	plan, err := buildPlan(sql)
 	if err != nil {
@@ -36,7 +51,7 @@ func buildPlan(sql string) (plan *RoutingPlan, err error) {
 	if err != nil {
 		return nil, err
 	}
-	return getRoutingPlan(statement)
+	return getRoutingPlan(statement, entityIdColumn)
 }
 
 func shardListFromPlan(plan *RoutingPlan, bindVariables map[string]interface{}, tabletKeys []key.KeyspaceId) (shardList []int, err error) {
@@ -46,7 +61,7 @@ func shardListFromPlan(plan *RoutingPlan, bindVariables map[string]interface{},
 
 	switch criteria := plan.criteria.(type) {
 	case sqlparser.Values:
-		index, err := findInsertShard(criteria, bindVariables, tabletKeys)
+		index, err := findInsertShard(criteria, plan.insertIndex, bindVariables, tabletKeys)
 		if err != nil {
 			return nil, err
 		}
@@ -93,13 +108,21 @@ func shardListFromPlan(plan *RoutingPlan, bindVariables map[string]interface{},
 	return makeList(0, len(tabletKeys)), nil
 }
 
-func getRoutingPlan(statement sqlparser.Statement) (plan *RoutingPlan, err error) {
+// getRoutingPlan finds the condition on the sharding column the
+// statement can be routed with. For an insert, it checks the rows
+// have a value for the sharding column: if the insert doesn't list its
+// columns, the sharding column has to be the first one.
+func getRoutingPlan(statement sqlparser.Statement, column string) (plan *RoutingPlan, err error) {
 	plan = &RoutingPlan{}
 	if ins, ok := statement.(*sqlparser.Insert); ok {
 		if sel, ok := ins.Rows.(sqlparser.SelectStatement); ok {
-			return getRoutingPlan(sel)
+			return getRoutingPlan(sel, column)
+		}
+		plan.insertIndex, err = insertColumnIndex(ins.Columns, column)
+		if err != nil {
+			return nil, err
 		}
-		plan.criteria, err = routingAnalyzeValues(ins.Rows.(sqlparser.Values))
+		plan.criteria, err = routingAnalyzeValues(ins.Rows.(sqlparser.Values), plan.insertIndex)
 		if err != nil {
 			return nil, err
 		}
@@ -115,17 +138,36 @@ func getRoutingPlan(statement sqlparser.Statement) (plan *RoutingPlan, err error
 		where = stmt.Where
 	}
 	if where != nil {
-		plan.criteria = routingAnalyzeBoolean(where.Expr)
+		plan.criteria = routingAnalyzeBoolean(where.Expr, column)
 	}
 	return plan, nil
 }
 
-func routingAnalyzeValues(vals sqlparser.Values) (sqlparser.Values, error) {
-	// Analyze first value of every item in the list
+func insertColumnIndex(columns sqlparser.Columns, column string) (int, error) {
+	if len(columns) == 0 {
+		return 0, nil
+	}
+	for i, c := range columns {
+		nse, ok := c.(*sqlparser.NonStarExpr)
+		if !ok {
+			continue
+		}
+		if col, ok := nse.Expr.(*sqlparser.ColName); ok && routingAnalyzeValue(col, column) == EID_NODE {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("insert has to list column %v", column)
+}
+
+func routingAnalyzeValues(vals sqlparser.Values, index int) (sqlparser.Values, error) {
+	// Analyze the sharding column value of every item in the list
 	for i := 0; i < len(vals); i++ {
 		switch tuple := vals[i].(type) {
 		case sqlparser.ValTuple:
-			result := routingAnalyzeValue(tuple[0])
+			if index >= len(tuple) {
+				return nil, fmt.Errorf("insert is too complex")
+			}
+			result := routingAnalyzeValue(tuple[index], "")
 			if result != VALUE_NODE {
 				return nil, fmt.Errorf("insert is too complex")
 			}
@@ -136,11 +178,25 @@ func routingAnalyzeValues(vals sqlparser.Values) (sqlparser.Values, error) {
 	return vals, nil
 }
 
-func routingAnalyzeBoolean(node sqlparser.BoolExpr) sqlparser.BoolExpr {
+// reversedOperators are the operators of the comparisons whose
+// operands are swapped.
+var reversedOperators = map[string]string{
+	"=":   "=",
+	"<=>": "<=>",
+	"<":   ">",
+	">":   "<",
+	"<=":  ">=",
+	">=":  "<=",
+}
+
+// routingAnalyzeBoolean returns the condition on the column the
+// query can be routed with, if any. The returned comparisons always
+// have the column on their left.
+func routingAnalyzeBoolean(node sqlparser.BoolExpr, column string) sqlparser.BoolExpr {
 	switch node := node.(type) {
 	case *sqlparser.AndExpr:
-		left := routingAnalyzeBoolean(node.Left)
-		right := routingAnalyzeBoolean(node.Right)
+		left := routingAnalyzeBoolean(node.Left, column)
+		right := routingAnalyzeBoolean(node.Right, column)
 		if left != nil && right != nil {
 			return nil
 		} else if left != nil {
@@ -149,18 +205,21 @@ func routingAnalyzeBoolean(node sqlparser.BoolExpr) sqlparser.BoolExpr {
 			return right
 		}
 	case *sqlparser.ParenBoolExpr:
-		return routingAnalyzeBoolean(node.Expr)
+		return routingAnalyzeBoolean(node.Expr, column)
 	case *sqlparser.ComparisonExpr:
 		switch {
 		case sqlparser.StringIn(node.Operator, "=", "<", ">", "<=", ">=", "<=>"):
-			left := routingAnalyzeValue(node.Left)
-			right := routingAnalyzeValue(node.Right)
-			if (left == EID_NODE && right == VALUE_NODE) || (left == VALUE_NODE && right == EID_NODE) {
+			left := routingAnalyzeValue(node.Left, column)
+			right := routingAnalyzeValue(node.Right, column)
+			if left == EID_NODE && right == VALUE_NODE {
 				return node
 			}
+			if left == VALUE_NODE && right == EID_NODE {
+				return &sqlparser.ComparisonExpr{Operator: reversedOperators[node.Operator], Left: node.Right, Right: node.Left}
+			}
 		case node.Operator == "in":
-			left := routingAnalyzeValue(node.Left)
-			right := routingAnalyzeValue(node.Right)
+			left := routingAnalyzeValue(node.Left, column)
+			right := routingAnalyzeValue(node.Right, column)
 			if left == EID_NODE && right == LIST_NODE {
 				return node
 			}
@@ -169,9 +228,9 @@ func routingAnalyzeBoolean(node sqlparser.BoolExpr) sqlparser.BoolExpr {
 		if node.Operator != "between" {
 			return nil
 		}
-		left := routingAnalyzeValue(node.Left)
-		from := routingAnalyzeValue(node.From)
-		to := routingAnalyzeValue(node.To)
+		left := routingAnalyzeValue(node.Left, column)
+		from := routingAnalyzeValue(node.From, column)
+		to := routingAnalyzeValue(node.To, column)
 		if left == EID_NODE && from == VALUE_NODE && to == VALUE_NODE {
 			return node
 		}
@@ -179,15 +238,15 @@ func routingAnalyzeBoolean(node sqlparser.BoolExpr) sqlparser.BoolExpr {
 	return nil
 }
 
-func routingAnalyzeValue(valExpr sqlparser.ValExpr) int {
+func routingAnalyzeValue(valExpr sqlparser.ValExpr, column string) int {
 	switch node := valExpr.(type) {
 	case *sqlparser.ColName:
-		if string(node.Name) == "entity_id" {
+		if strings.EqualFold(string(node.Name), column) {
 			return EID_NODE
 		}
 	case sqlparser.ValTuple:
 		for _, n := range node {
-			if routingAnalyzeValue(n) != VALUE_NODE {
+			if routingAnalyzeValue(n, column) != VALUE_NODE {
 				return OTHER_NODE
 			}
 		}
@@ -219,11 +278,11 @@ func findShardList(valExpr sqlparser.ValExpr, bindVariables map[string]interface
 	return shardlist, nil
 }
 
-func findInsertShard(vals sqlparser.Values, bindVariables map[string]interface{}, tabletKeys []key.KeyspaceId) (int, error) {
+func findInsertShard(vals sqlparser.Values, insertIndex int, bindVariables map[string]interface{}, tabletKeys []key.KeyspaceId) (int, error) {
 	index := -1
 	for i := 0; i < len(vals); i++ {
-		first_value_expression := vals[i].(sqlparser.ValTuple)[0]
-		newIndex, err := findShard(first_value_expression, bindVariables, tabletKeys)
+		value_expression := vals[i].(sqlparser.ValTuple)[insertIndex]
+		newIndex, err := findShard(value_expression, bindVariables, tabletKeys)
 		if err != nil {
 			return -1, err
 		}
@@ -288,3 +347,384 @@ func makeList(start, end int) []int {
 	}
 	return list
 }
+
+// Router routes SQL queries to the right shards, using the sharding
+// metadata of the VSchema.
+type Router struct {
+	serv     SrvTopoServer
+	cell     string
+	resolver *Resolver
+
+	// mu protects vschema, it can be replaced while queries run.
+	mu      sync.Mutex
+	vschema *planbuilder.VSchema
+}
+
+// NewRouter creates a new Router.
+func NewRouter(serv SrvTopoServer, cell string, vschema *planbuilder.VSchema, resolver *Resolver) *Router {
+	return &Router{
+		serv:     serv,
+		cell:     cell,
+		vschema:  vschema,
+		resolver: resolver,
+	}
+}
+
+// SetVSchema replaces the VSchema, the queries that are already
+// running keep using the previous one.
+func (rtr *Router) SetVSchema(vschema *planbuilder.VSchema) {
+	rtr.mu.Lock()
+	defer rtr.mu.Unlock()
+	rtr.vschema = vschema
+}
+
+func (rtr *Router) getVSchema() *planbuilder.VSchema {
+	rtr.mu.Lock()
+	defer rtr.mu.Unlock()
+	return rtr.vschema
+}
+
+// Execute routes a non-streaming query.
+func (rtr *Router) Execute(context context.Context, query *proto.Query) (*mproto.QueryResult, error) {
+	route, err := buildRoute(query.Sql, query.Keyspace, rtr.getVSchema())
+	if err != nil {
+		return nil, fmt.Errorf("cannot route query: %s: %v", query.Sql, err)
+	}
+	if route.insert != nil {
+		return rtr.execInsert(context, route, query)
+	}
+	return rtr.resolver.Execute(
+		context,
+		query.Sql,
+		query.BindVariables,
+		query.Keyspace,
+		query.TabletType,
+		query.Session,
+		func(keyspace string) (string, []string, error) {
+			return rtr.mapToShards(context, route, keyspace, query)
+		},
+	)
+}
+
+// route says how the Router sends a query to the shards of its
+// keyspace.
+type route struct {
+	// table is the sharded table of the query, it is nil for the
+	// unsharded keyspaces.
+	table *planbuilder.Table
+
+	// plan is the routing plan on the sharding column of table, or
+	// on the lookup column if lookup is set.
+	plan   *RoutingPlan
+	lookup *planbuilder.Lookup
+
+	// insert is set for the inserts into a sharded table.
+	insert *sqlparser.Insert
+}
+
+// buildRoute returns the route of the query in the keyspace. The
+// queries of a sharded keyspace are routed with the conditions on the
+// sharding column, or on a lookup column, of their table. Selects
+// without such a condition go to all the shards, the other queries
+// are refused.
+func buildRoute(sql, keyspace string, vschema *planbuilder.VSchema) (*route, error) {
+	ks, ok := vschema.Keyspaces[keyspace]
+	if !ok {
+		return nil, fmt.Errorf("keyspace %v is not in the vschema", keyspace)
+	}
+	statement, err := sqlparser.Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	if !ks.Sharded {
+		return &route{}, nil
+	}
+
+	var name string
+	var insert *sqlparser.Insert
+	isSelect := false
+	switch stmt := statement.(type) {
+	case *sqlparser.Select:
+		if len(stmt.From) != 1 {
+			return nil, fmt.Errorf("select from multiple tables is not supported on sharded keyspaces")
+		}
+		if ate, ok := stmt.From[0].(*sqlparser.AliasedTableExpr); ok {
+			name = sqlparser.GetTableName(ate.Expr)
+		}
+		isSelect = true
+	case *sqlparser.Update:
+		name = tableName(stmt.Table)
+	case *sqlparser.Delete:
+		name = tableName(stmt.Table)
+	case *sqlparser.Insert:
+		if _, ok := stmt.Rows.(sqlparser.Values); !ok {
+			return nil, fmt.Errorf("insert with select is not supported on sharded keyspaces")
+		}
+		name = tableName(stmt.Table)
+		insert = stmt
+	default:
+		return nil, fmt.Errorf("statement is not supported on sharded keyspaces")
+	}
+	if name == "" {
+		return nil, fmt.Errorf("complex table expression is not supported on sharded keyspaces")
+	}
+	table, ok := ks.Tables[name]
+	if !ok {
+		return nil, fmt.Errorf("table %v is not in the vschema of keyspace %v", name, keyspace)
+	}
+	if upd, ok := statement.(*sqlparser.Update); ok {
+		for _, expr := range upd.Exprs {
+			if routingAnalyzeValue(expr.Name, table.ShardingColumn) == EID_NODE {
+				return nil, fmt.Errorf("cannot update sharding column %v", table.ShardingColumn)
+			}
+		}
+	}
+
+	plan, err := getRoutingPlan(statement, table.ShardingColumn)
+	if err != nil {
+		return nil, err
+	}
+	if insert != nil {
+		return &route{table: table, plan: plan, insert: insert}, nil
+	}
+	if equalityValues(plan) != nil {
+		return &route{table: table, plan: plan}, nil
+	}
+	for _, lookup := range table.Lookups {
+		lookupPlan, err := getRoutingPlan(statement, lookup.Column)
+		if err != nil {
+			return nil, err
+		}
+		if equalityValues(lookupPlan) != nil {
+			return &route{table: table, plan: lookupPlan, lookup: lookup}, nil
+		}
+	}
+	if !isSelect {
+		return nil, fmt.Errorf("query has no condition on sharding column %v", table.ShardingColumn)
+	}
+	return &route{table: table, plan: &RoutingPlan{}}, nil
+}
+
+func tableName(node *sqlparser.TableName) string {
+	if node == nil || node.Qualifier != nil {
+		return ""
+	}
+	return string(node.Name)
+}
+
+// equalityValues returns the values of the equality or IN condition
+// of the plan. It returns nil for the other plans, they cannot be
+// mapped to keyspace ids.
+func equalityValues(plan *RoutingPlan) []sqlparser.ValExpr {
+	criteria, ok := plan.criteria.(*sqlparser.ComparisonExpr)
+	if !ok {
+		return nil
+	}
+	switch criteria.Operator {
+	case "=", "<=>":
+		return []sqlparser.ValExpr{criteria.Right}
+	case "in":
+		return []sqlparser.ValExpr(criteria.Right.(sqlparser.ValTuple))
+	}
+	return nil
+}
+
+// mapToShards returns the shards the route sends the query to.
+func (rtr *Router) mapToShards(context context.Context, route *route, keyspace string, query *proto.Query) (string, []string, error) {
+	if route.table == nil {
+		return rtr.allShards(keyspace, query.TabletType)
+	}
+	valExprs := equalityValues(route.plan)
+	if valExprs == nil {
+		return rtr.allShards(keyspace, query.TabletType)
+	}
+
+	values, err := resolveValues(valExprs, query.BindVariables)
+	if err != nil {
+		return "", nil, err
+	}
+	if route.lookup != nil {
+		values, err = rtr.lookup(context, route.lookup, values, query.TabletType, query.Session)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(values) == 0 {
+			return "", nil, fmt.Errorf("no %v found in %v.%v", route.lookup.To, route.lookup.Keyspace, route.lookup.Table)
+		}
+	}
+	keyspaceIds := make([]key.KeyspaceId, len(values))
+	for i, value := range values {
+		if keyspaceIds[i], err = route.table.KeyspaceId(value); err != nil {
+			return "", nil, err
+		}
+	}
+	return mapKeyspaceIdsToShards(rtr.serv, rtr.cell, keyspace, query.TabletType, keyspaceIds)
+}
+
+// execInsert sends the rows of an insert into a sharded table to their
+// shards, each shard gets an insert with only its rows. Like
+// Resolver.Execute, it maps the rows again on a retryable error, and
+// retries if the shards have changed.
+func (rtr *Router) execInsert(context context.Context, route *route, query *proto.Query) (*mproto.QueryResult, error) {
+	rows := route.plan.criteria.(sqlparser.Values)
+	valExprs := make([]sqlparser.ValExpr, len(rows))
+	for i, row := range rows {
+		valExprs[i] = row.(sqlparser.ValTuple)[route.plan.insertIndex]
+	}
+	values, err := resolveValues(valExprs, query.BindVariables)
+	if err != nil {
+		return nil, err
+	}
+	keyspaceIds := make([]key.KeyspaceId, len(values))
+	for i, value := range values {
+		if keyspaceIds[i], err = route.table.KeyspaceId(value); err != nil {
+			return nil, err
+		}
+	}
+
+	keyspace, shards, sqls, err := rtr.mapInsertRows(route.insert, rows, keyspaceIds, query.Keyspace, query.TabletType)
+	if err != nil {
+		return nil, err
+	}
+	// all the inserts use the bind variables of the query
+	bindVars := make(map[string]map[string]interface{})
+	for _, shard := range shards {
+		bindVars[shard] = query.BindVariables
+	}
+	for {
+		qr, err := rtr.resolver.scatterConn.ExecuteEntityIds(
+			context,
+			shards,
+			sqls,
+			bindVars,
+			keyspace,
+			query.TabletType,
+			NewSafeSession(query.Session))
+		if connError, ok := err.(*ShardConnError); ok && connError.Code == tabletconn.ERR_RETRY {
+			newKeyspace, newShards, newSqls, err := rtr.mapInsertRows(route.insert, rows, keyspaceIds, keyspace, query.TabletType)
+			if err != nil {
+				return nil, err
+			}
+			// retry if resharding happened
+			if newKeyspace != keyspace || !StrsEquals(newShards, shards) {
+				keyspace, shards, sqls = newKeyspace, newShards, newSqls
+				for _, shard := range shards {
+					bindVars[shard] = query.BindVariables
+				}
+				continue
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return qr, nil
+	}
+}
+
+// mapInsertRows groups the rows of the insert by shard, and returns
+// the insert of each shard.
+func (rtr *Router) mapInsertRows(insert *sqlparser.Insert, rows sqlparser.Values, keyspaceIds []key.KeyspaceId, keyspace string, tabletType topo.TabletType) (string, []string, map[string]string, error) {
+	keyspace, allShards, err := getKeyspaceShards(rtr.serv, rtr.cell, keyspace, tabletType)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	shardRows := make(map[string]sqlparser.Values)
+	for i, keyspaceId := range keyspaceIds {
+		shard, err := getShardForKeyspaceId(allShards, keyspaceId)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		shardRows[shard] = append(shardRows[shard], rows[i])
+	}
+	shards := make([]string, 0, len(shardRows))
+	sqls := make(map[string]string, len(shardRows))
+	for shard, rows := range shardRows {
+		shardInsert := *insert
+		shardInsert.Rows = rows
+		shards = append(shards, shard)
+		sqls[shard] = sqlparser.String(&shardInsert)
+	}
+	return keyspace, shards, sqls, nil
+}
+
+// lookup maps the values of a lookup column to the values of the
+// sharding column, using the lookup table. The lookup query runs in
+// the transaction of session, if any, so it sees its changes.
+func (rtr *Router) lookup(context context.Context, lookup *planbuilder.Lookup, values []interface{}, tabletType topo.TabletType, session *proto.Session) ([]interface{}, error) {
+	bindVars := make(map[string]interface{}, len(values))
+	args := make([]string, len(values))
+	for i, value := range values {
+		name := fmt.Sprintf("v%d", i)
+		bindVars[name] = value
+		args[i] = ":" + name
+	}
+	sql := fmt.Sprintf("select %s from %s where %s in (%s)", lookup.To, lookup.Table, lookup.From, strings.Join(args, ", "))
+
+	qr, err := rtr.resolver.Execute(
+		context,
+		sql,
+		bindVars,
+		lookup.Keyspace,
+		tabletType,
+		session,
+		func(keyspace string) (string, []string, error) {
+			return rtr.allShards(keyspace, tabletType)
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("lookup in %v.%v failed: %v", lookup.Keyspace, lookup.Table, err)
+	}
+	result := make([]interface{}, 0, len(qr.Rows))
+	for _, row := range qr.Rows {
+		if len(row) == 0 {
+			continue
+		}
+		result = append(result, row[0])
+	}
+	return result, nil
+}
+
+// allShards returns all the shards of the keyspace.
+func (rtr *Router) allShards(keyspace string, tabletType topo.TabletType) (string, []string, error) {
+	keyspace, allShards, err := getKeyspaceShards(rtr.serv, rtr.cell, keyspace, tabletType)
+	if err != nil {
+		return "", nil, err
+	}
+	shards := make([]string, len(allShards))
+	for i, srvShard := range allShards {
+		shards[i] = srvShard.ShardName()
+	}
+	return keyspace, shards, nil
+}
+
+// resolveValues converts the values of a plan to go values, using
+// the bind variables.
+func resolveValues(valExprs []sqlparser.ValExpr, bindVariables map[string]interface{}) ([]interface{}, error) {
+	values := make([]interface{}, len(valExprs))
+	for i, valExpr := range valExprs {
+		switch node := valExpr.(type) {
+		case sqlparser.StrVal:
+			values[i] = []byte(node)
+		case sqlparser.NumVal:
+			val, err := strconv.ParseInt(string(node), 10, 64)
+			if err != nil {
+				uval, uerr := strconv.ParseUint(string(node), 10, 64)
+				if uerr != nil {
+					return nil, err
+				}
+				values[i] = uval
+				continue
+			}
+			values[i] = val
+		case sqlparser.ValArg:
+			value, err := findBindValue(node, bindVariables)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		default:
+			return nil, fmt.Errorf("unexpected value %s", sqlparser.String(valExpr))
+		}
+	}
+	return values, nil
+}
@@ -9,12 +9,19 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"reflect"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/youtube/vitess/go/testfiles"
+	"github.com/youtube/vitess/go/vt/context"
 	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
 )
 
 func TestRouting(t *testing.T) {
@@ -103,3 +110,274 @@ func iterateFiles(pattern string) (testCaseIterator chan testCase) {
 	}()
 	return testCaseIterator
 }
+
+func TestRouterExecute(t *testing.T) {
+	vschema, err := planbuilder.NewVSchema([]byte(`{
+  "Keyspaces": {
+    "TestRouter": {
+      "Sharded": true,
+      "Tables": {
+        "user": {
+          "ShardingColumn": "id",
+          "Hash": "identity",
+          "Lookups": [{"Column": "name", "Keyspace": "` + TEST_UNSHARDED + `", "Table": "name_user_idx", "From": "name", "To": "user_id"}]
+        }
+      }
+    },
+    "` + TEST_UNSHARDED + `": {}
+  }
+}`))
+	if err != nil {
+		t.Fatalf("NewVSchema failed: %v", err)
+	}
+	s := createSandbox("TestRouter")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("-20", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("40-60", sbc1)
+	sbcOthers := make([]*sandboxConn, 0, 6)
+	for _, shard := range []string{"20-40", "60-80", "80-A0", "A0-C0", "C0-E0", "E0-"} {
+		sbc := &sandboxConn{}
+		s.MapTestConn(shard, sbc)
+		sbcOthers = append(sbcOthers, sbc)
+	}
+	lookup := createSandbox(TEST_UNSHARDED)
+	sbcLookup := &sandboxConn{}
+	lookup.MapTestConn("0", sbcLookup)
+
+	serv := new(sandboxTopo)
+	router := NewRouter(serv, "aa", vschema, NewResolver(serv, "", "aa", 1*time.Millisecond, 0, 1*time.Millisecond))
+	execute := func(sql string, bindVars map[string]interface{}) error {
+		_, err := router.Execute(&context.DummyContext{}, &proto.Query{
+			Sql:           sql,
+			BindVariables: bindVars,
+			Keyspace:      "TestRouter",
+			TabletType:    topo.TYPE_MASTER,
+		})
+		return err
+	}
+	counts := func() []int64 {
+		result := []int64{sbc0.ExecCount.Get(), sbc1.ExecCount.Get(), sbcLookup.ExecCount.Get()}
+		for _, sbc := range sbcOthers {
+			result = append(result, sbc.ExecCount.Get())
+		}
+		return result
+	}
+
+	// 0x5000000000000000 is in shard 40-60
+	testCases := []struct {
+		sql      string
+		bindVars map[string]interface{}
+		counts   []int64
+	}{
+		{"select * from user where id = 1", nil, []int64{1, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{"select * from user where id = :id", map[string]interface{}{"id": 5764607523034234880}, []int64{1, 1, 0, 0, 0, 0, 0, 0, 0}},
+		{"update user set a = 2 where id in (1, 5764607523034234880)", nil, []int64{2, 2, 0, 0, 0, 0, 0, 0, 0}},
+		// the lookup returns 1
+		{"delete from user where name = 'foo'", nil, []int64{3, 2, 1, 0, 0, 0, 0, 0, 0}},
+		{"insert into user(id, a) values (1, 2), (2, 3)", nil, []int64{4, 2, 1, 0, 0, 0, 0, 0, 0}},
+		{"select * from user", nil, []int64{5, 3, 1, 1, 1, 1, 1, 1, 1}},
+		// the rows of a multi-shard insert are split by shard
+		{"insert into user(a, id) values (1, 1), (2, 5764607523034234880), (3, :id)", map[string]interface{}{"id": 2}, []int64{6, 4, 1, 1, 1, 1, 1, 1, 1}},
+	}
+	for _, tc := range testCases {
+		if err := execute(tc.sql, tc.bindVars); err != nil {
+			t.Errorf("Execute(%v) failed: %v", tc.sql, err)
+		}
+		if got := counts(); !reflect.DeepEqual(got, tc.counts) {
+			t.Errorf("Execute(%v): got counts %v, want %v", tc.sql, got, tc.counts)
+		}
+	}
+	if got, want := sbc0.LastQuery.Get(), "insert into user(a, id) values (1, 1), (3, :id)"; got != want {
+		t.Errorf("insert in shard -20: got %v, want %v", got, want)
+	}
+	if got, want := sbc1.LastQuery.Get(), "insert into user(a, id) values (2, 5764607523034234880)"; got != want {
+		t.Errorf("insert in shard 40-60: got %v, want %v", got, want)
+	}
+
+	errorCases := []struct {
+		sql      string
+		bindVars map[string]interface{}
+		err      string
+	}{
+		{"delete from user", nil, "no condition on sharding column"},
+		{"select * from user where id = :id", nil, "No bind variable for :id"},
+		{"insert into user(id) values (null)", nil, "insert is too complex"},
+	}
+	for _, tc := range errorCases {
+		if err := execute(tc.sql, tc.bindVars); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Execute(%v): got %v, want %v", tc.sql, err, tc.err)
+		}
+	}
+}
+
+func TestBuildRoute(t *testing.T) {
+	vschema, err := planbuilder.NewVSchema([]byte(`{
+  "Keyspaces": {
+    "user": {
+      "Sharded": true,
+      "Tables": {
+        "user": {
+          "ShardingColumn": "id",
+          "Hash": "md5",
+          "Lookups": [{"Column": "name", "Keyspace": "lookup", "Table": "name_user_idx", "From": "name", "To": "user_id"}]
+        },
+        "music": {"ShardingColumn": "user_id", "Hash": "identity"}
+      }
+    },
+    "lookup": {}
+  }
+}`))
+	if err != nil {
+		t.Fatalf("NewVSchema failed: %v", err)
+	}
+	testCases := []struct {
+		keyspace string
+		sql      string
+		lookup   bool
+		// values are the values the query is routed with,
+		// "scatter" for the selects sent to all the shards.
+		values string
+		err    string
+	}{
+		{"lookup", "select * from name_user_idx", false, "", ""},
+		{"user", "select * from user where id = 1", false, "1", ""},
+		{"user", "select * from user where 1 = id and a = 2", false, "1", ""},
+		{"user", "select * from user where a = 2 and (ID = :id)", false, ":id", ""},
+		{"user", "select * from user where id in (1, 'a', :b)", false, "1,'a',:b", ""},
+		{"user", "select * from user where name = 'foo'", true, "'foo'", ""},
+		{"user", "select * from user where id > 1", false, "scatter", ""},
+		{"user", "select * from user where id = 1 or id = 2", false, "scatter", ""},
+		{"user", "select * from user", false, "scatter", ""},
+		{"user", "update user set a = 1 where id = 1", false, "1", ""},
+		{"user", "update user set id = 2 where id = 1", false, "", "cannot update sharding column"},
+		{"user", "update user set a = 1", false, "", "no condition on sharding column"},
+		{"user", "delete from music where user_id in (1, 2)", false, "1,2", ""},
+		{"user", "delete from music where user_id < 2", false, "", "no condition on sharding column"},
+		{"user", "delete from music", false, "", "no condition on sharding column"},
+		{"user", "insert into music(a, user_id) values (1, 2), (3, :uid)", false, "2,:uid", ""},
+		{"user", "insert into music(a) values (1)", false, "", "insert has to list column user_id"},
+		{"user", "insert into music(a, user_id) values (1, 1+1)", false, "", "insert is too complex"},
+		{"user", "insert into music(user_id) select id from user", false, "", "insert with select"},
+		{"user", "select * from user, music", false, "", "multiple tables"},
+		{"user", "select * from unknown", false, "", "not in the vschema"},
+		{"user", "set autocommit=1", false, "", "not supported"},
+		{"user", "select * from", false, "", "syntax error"},
+		{"unknown", "select * from user", false, "", "not in the vschema"},
+	}
+	for _, tc := range testCases {
+		route, err := buildRoute(tc.sql, tc.keyspace, vschema)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("buildRoute(%v): got %v, want %v", tc.sql, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("buildRoute(%v) failed: %v", tc.sql, err)
+			continue
+		}
+		if (route.lookup != nil) != tc.lookup {
+			t.Errorf("buildRoute(%v): got lookup %v, want %v", tc.sql, route.lookup, tc.lookup)
+		}
+		var values []string
+		switch {
+		case route.table == nil:
+		case route.insert != nil:
+			for _, row := range route.plan.criteria.(sqlparser.Values) {
+				values = append(values, sqlparser.String(row.(sqlparser.ValTuple)[route.plan.insertIndex]))
+			}
+		case equalityValues(route.plan) == nil:
+			values = []string{"scatter"}
+		default:
+			for _, v := range equalityValues(route.plan) {
+				values = append(values, sqlparser.String(v))
+			}
+		}
+		if got := strings.Join(values, ","); got != tc.values {
+			t.Errorf("buildRoute(%v): got values %v, want %v", tc.sql, got, tc.values)
+		}
+	}
+}
+
+func TestRouterLookupInTransaction(t *testing.T) {
+	vschema, err := planbuilder.NewVSchema([]byte(`{
+  "Keyspaces": {
+    "TestRouterTx": {
+      "Sharded": true,
+      "Tables": {
+        "user": {
+          "ShardingColumn": "id",
+          "Hash": "identity",
+          "Lookups": [{"Column": "name", "Keyspace": "` + TEST_UNSHARDED + `", "Table": "name_user_idx", "From": "name", "To": "user_id"}]
+        }
+      }
+    },
+    "` + TEST_UNSHARDED + `": {}
+  }
+}`))
+	if err != nil {
+		t.Fatalf("NewVSchema failed: %v", err)
+	}
+	s := createSandbox("TestRouterTx")
+	// the lookup returns 1, in shard -20
+	s.MapTestConn("-20", &sandboxConn{})
+	lookup := createSandbox(TEST_UNSHARDED)
+	sbcLookup := &sandboxConn{}
+	lookup.MapTestConn("0", sbcLookup)
+
+	serv := new(sandboxTopo)
+	router := NewRouter(serv, "aa", vschema, NewResolver(serv, "", "aa", 1*time.Millisecond, 0, 1*time.Millisecond))
+	session := &proto.Session{InTransaction: true}
+	if _, err := router.Execute(&context.DummyContext{}, &proto.Query{
+		Sql:        "update user set a = 2 where name = 'foo'",
+		Keyspace:   "TestRouterTx",
+		TabletType: topo.TYPE_MASTER,
+		Session:    session,
+	}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	// the lookup is part of the transaction
+	if got := sbcLookup.BeginCount.Get(); got != 1 {
+		t.Errorf("lookup BeginCount: got %v, want 1", got)
+	}
+	found := false
+	for _, shardSession := range session.ShardSessions {
+		if shardSession.Keyspace == TEST_UNSHARDED {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("lookup shard is not in the session: %+v", session)
+	}
+}
+
+func TestRouterSetVSchema(t *testing.T) {
+	s := createSandbox(TEST_UNSHARDED)
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+
+	serv := new(sandboxTopo)
+	router := NewRouter(serv, "aa", &planbuilder.VSchema{}, NewResolver(serv, "", "aa", 1*time.Millisecond, 0, 1*time.Millisecond))
+	query := &proto.Query{
+		Sql:        "select * from user",
+		Keyspace:   TEST_UNSHARDED,
+		TabletType: topo.TYPE_MASTER,
+	}
+	if _, err := router.Execute(&context.DummyContext{}, query); err == nil || !strings.Contains(err.Error(), "not in the vschema") {
+		t.Errorf("Execute with an empty vschema: got %v, want not in the vschema", err)
+	}
+
+	vschema, err := planbuilder.NewVSchema([]byte(`{"Keyspaces": {"` + TEST_UNSHARDED + `": {}}}`))
+	if err != nil {
+		t.Fatalf("NewVSchema failed: %v", err)
+	}
+	router.SetVSchema(vschema)
+	if _, err := router.Execute(&context.DummyContext{}, query); err != nil {
+		t.Errorf("Execute failed: %v", err)
+	}
+	if got := sbc.ExecCount.Get(); got != 1 {
+		t.Errorf("ExecCount: got %v, want 1", got)
+	}
+}
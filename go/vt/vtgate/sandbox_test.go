@@ -176,6 +176,9 @@ func createShardedSrvKeyspace(shardSpec, servedFromKeyspace string) (*topo.SrvKe
 			topo.TYPE_MASTER: &topo.KeyspacePartition{
 				Shards: shards,
 			},
+			topo.TYPE_RDONLY: &topo.KeyspacePartition{
+				Shards: shards,
+			},
 		},
 		TabletTypes: allTabletTypes,
 	}
@@ -296,8 +299,13 @@ type sandboxConn struct {
 	mustFailConn   int
 	mustFailTxPool int
 	mustFailNotTx  int
+	mustThrottle   int
 	mustDelay      time.Duration
 
+	// streamResults are returned by StreamExecute, instead of
+	// singleRowResult.
+	streamResults []*mproto.QueryResult
+
 	// These Count vars report how often the corresponding
 	// functions were called.
 	ExecCount     sync2.AtomicInt64
@@ -306,8 +314,20 @@ type sandboxConn struct {
 	RollbackCount sync2.AtomicInt64
 	CloseCount    sync2.AtomicInt64
 
+	// Two-phase commit calls.
+	PrepareCount             sync2.AtomicInt64
+	CommitPreparedCount      sync2.AtomicInt64
+	RollbackPreparedCount    sync2.AtomicInt64
+	CreateTransactionCount   sync2.AtomicInt64
+	StartCommitCount         sync2.AtomicInt64
+	SetRollbackCount         sync2.AtomicInt64
+	ConcludeTransactionCount sync2.AtomicInt64
+
 	// transaction id generator
 	TransactionId sync2.AtomicInt64
+
+	// LastQuery is the query of the last Execute call.
+	LastQuery sync2.AtomicString
 }
 
 func (sbc *sandboxConn) getError() error {
@@ -335,11 +355,16 @@ func (sbc *sandboxConn) getError() error {
 		sbc.mustFailNotTx--
 		return &tabletconn.ServerError{Code: tabletconn.ERR_NOT_IN_TX, Err: "not_in_tx: err"}
 	}
+	if sbc.mustThrottle > 0 {
+		sbc.mustThrottle--
+		return &tabletconn.ServerError{Code: tabletconn.ERR_THROTTLED, Err: "throttled: err"}
+	}
 	return nil
 }
 
 func (sbc *sandboxConn) Execute(context context.Context, query string, bindVars map[string]interface{}, transactionID int64) (*mproto.QueryResult, error) {
 	sbc.ExecCount.Add(1)
+	sbc.LastQuery.Set(query)
 	if sbc.mustDelay != 0 {
 		time.Sleep(sbc.mustDelay)
 	}
@@ -370,13 +395,27 @@ func (sbc *sandboxConn) StreamExecute(context context.Context, query string, bin
 	if sbc.mustDelay != 0 {
 		time.Sleep(sbc.mustDelay)
 	}
-	ch := make(chan *mproto.QueryResult, 1)
-	ch <- singleRowResult
+	results := sbc.streamResults
+	if results == nil {
+		results = []*mproto.QueryResult{singleRowResult}
+	}
+	ch := make(chan *mproto.QueryResult, len(results))
+	for _, qr := range results {
+		ch <- qr
+	}
 	close(ch)
 	err := sbc.getError()
 	return ch, func() error { return err }
 }
 
+func (sbc *sandboxConn) ExplainQuery(context context.Context, query string, bindVars map[string]interface{}) (*tproto.QueryExplanation, error) {
+	sbc.ExecCount.Add(1)
+	if err := sbc.getError(); err != nil {
+		return nil, err
+	}
+	return &tproto.QueryExplanation{PlanId: "PASS_SELECT", Reason: "DEFAULT", Queries: []string{query}}, nil
+}
+
 func (sbc *sandboxConn) Begin(context context.Context) (int64, error) {
 	sbc.ExecCount.Add(1)
 	sbc.BeginCount.Add(1)
@@ -390,6 +429,19 @@ func (sbc *sandboxConn) Begin(context context.Context) (int64, error) {
 	return sbc.TransactionId.Add(1), nil
 }
 
+func (sbc *sandboxConn) BeginConsistentSnapshot(context context.Context) (int64, error) {
+	sbc.ExecCount.Add(1)
+	sbc.BeginCount.Add(1)
+	if sbc.mustDelay != 0 {
+		time.Sleep(sbc.mustDelay)
+	}
+	err := sbc.getError()
+	if err != nil {
+		return 0, err
+	}
+	return sbc.TransactionId.Add(1), nil
+}
+
 func (sbc *sandboxConn) Commit(context context.Context, transactionID int64) error {
 	sbc.ExecCount.Add(1)
 	sbc.CommitCount.Add(1)
@@ -408,6 +460,45 @@ func (sbc *sandboxConn) Rollback(context context.Context, transactionID int64) e
 	return sbc.getError()
 }
 
+func (sbc *sandboxConn) Prepare(context context.Context, transactionID int64, dtid string) error {
+	sbc.PrepareCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) CommitPrepared(context context.Context, dtid string) error {
+	sbc.CommitPreparedCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) RollbackPrepared(context context.Context, dtid string, transactionID int64) error {
+	sbc.RollbackPreparedCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) CreateTransaction(context context.Context, dtid string, participants []tproto.TxParticipant) error {
+	sbc.CreateTransactionCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) StartCommit(context context.Context, transactionID int64, dtid string) error {
+	sbc.StartCommitCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) SetRollback(context context.Context, dtid string, transactionID int64) error {
+	sbc.SetRollbackCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) ConcludeTransaction(context context.Context, dtid string) error {
+	sbc.ConcludeTransactionCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) UnresolvedTransactions(context context.Context, abandonAge time.Duration) ([]tproto.DistributedTransaction, error) {
+	return nil, sbc.getError()
+}
+
 // Close does not change ExecCount
 func (sbc *sandboxConn) Close() {
 	sbc.CloseCount.Add(1)
@@ -5,14 +5,18 @@
 package vtgate
 
 import (
+	"flag"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	log "github.com/golang/glog"
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/trace"
 	"github.com/youtube/vitess/go/vt/concurrency"
 	"github.com/youtube/vitess/go/vt/context"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
@@ -23,6 +27,8 @@ import (
 
 var idGen sync2.AtomicInt64
 
+var atomicCommit = flag.Bool("atomic_commit", false, "use two-phase commit for transactions that span more than one shard, the tablets need -atomic_commit too")
+
 // ScatterConn is used for executing queries across
 // multiple ShardConn connections.
 type ScatterConn struct {
@@ -68,6 +74,21 @@ func (stc *ScatterConn) Execute(
 	tabletType topo.TabletType,
 	session *SafeSession,
 ) (*mproto.QueryResult, error) {
+	span, context := startSpan(context, "Execute", keyspace, shards)
+	defer span.Finish()
+
+	// the results of a select sent to multiple shards may need merging
+	var plan *mergePlan
+	if len(unique(shards)) > 1 {
+		var err error
+		if plan, err = buildMergePlan(query, bindVars); err != nil {
+			return nil, err
+		}
+		if plan != nil {
+			query = plan.shardSql
+		}
+	}
+
 	results, allErrors := stc.multiGo(
 		context,
 		"Execute",
@@ -84,6 +105,17 @@ func (stc *ScatterConn) Execute(
 			return nil
 		})
 
+	if plan != nil {
+		var innerqrs []*mproto.QueryResult
+		for innerqr := range results {
+			innerqrs = append(innerqrs, innerqr.(*mproto.QueryResult))
+		}
+		if allErrors.HasErrors() {
+			return nil, allErrors.AggrError(stc.aggregateErrors)
+		}
+		return plan.merge(innerqrs)
+	}
+
 	qr := new(mproto.QueryResult)
 	for innerqr := range results {
 		innerqr := innerqr.(*mproto.QueryResult)
@@ -104,6 +136,9 @@ func (stc *ScatterConn) ExecuteEntityIds(
 	tabletType topo.TabletType,
 	session *SafeSession,
 ) (*mproto.QueryResult, error) {
+	span, context := startSpan(context, "ExecuteEntityIds", keyspace, shards)
+	defer span.Finish()
+
 	results, allErrors := stc.multiGo(
 		context,
 		"ExecuteEntityIds",
@@ -143,6 +178,9 @@ func (stc *ScatterConn) ExecuteBatch(
 	tabletType topo.TabletType,
 	session *SafeSession,
 ) (qrs *tproto.QueryResultList, err error) {
+	span, context := startSpan(context, "ExecuteBatch", keyspace, shards)
+	defer span.Finish()
+
 	results, allErrors := stc.multiGo(
 		context,
 		"ExecuteBatch",
@@ -184,6 +222,20 @@ func (stc *ScatterConn) StreamExecute(
 	session *SafeSession,
 	sendReply func(reply *mproto.QueryResult) error,
 ) error {
+	span, context := startSpan(context, "StreamExecute", keyspace, shards)
+	defer span.Finish()
+
+	// the results of a select sent to multiple shards may need merging
+	if len(unique(shards)) > 1 {
+		plan, err := buildMergePlan(query, bindVars)
+		if err != nil {
+			return err
+		}
+		if plan != nil {
+			return stc.streamExecuteMerged(context, plan, bindVars, keyspace, shards, tabletType, session, sendReply)
+		}
+	}
+
 	results, allErrors := stc.multiGo(
 		context,
 		"StreamExecute",
@@ -214,11 +266,124 @@ func (stc *ScatterConn) StreamExecute(
 	return allErrors.AggrError(stc.aggregateErrors)
 }
 
+// streamExecuteMerged is StreamExecute for a select whose results
+// have to be merged. The rows of the shards are merged as they are
+// streamed, unless they have to be grouped.
+func (stc *ScatterConn) streamExecuteMerged(
+	context context.Context,
+	plan *mergePlan,
+	bindVars map[string]interface{},
+	keyspace string,
+	shards []string,
+	tabletType topo.TabletType,
+	session *SafeSession,
+	sendReply func(reply *mproto.QueryResult) error,
+) error {
+	// The results of each shard go to their own channel, so the
+	// merge reads them at its own pace. quit is closed when the
+	// merge doesn't need more results.
+	shardStreams := make(map[string]chan *mproto.QueryResult)
+	var shardNames []string
+	for shard := range unique(shards) {
+		shardStreams[shard] = make(chan *mproto.QueryResult)
+		shardNames = append(shardNames, shard)
+	}
+	sort.Strings(shardNames)
+	quit := make(chan struct{})
+	done, allErrors := stc.multiGo(
+		context,
+		"StreamExecute",
+		keyspace,
+		shards,
+		tabletType,
+		session,
+		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
+			stream := shardStreams[sdc.shard]
+			defer close(stream)
+			sr, errFunc := sdc.StreamExecute(context, plan.shardSql, bindVars, transactionId)
+			if sr != nil {
+				for qr := range sr {
+					select {
+					case stream <- qr:
+					case <-quit:
+						// We still need to finish pumping
+					}
+				}
+			}
+			return errFunc()
+		})
+
+	// The stream of a shard whose action was not run is never
+	// closed: the merge stops reading it when all the actions are
+	// done, and nothing is sent on done.
+	streams := make([]func() *mproto.QueryResult, len(shardNames))
+	for i, shard := range shardNames {
+		stream := shardStreams[shard]
+		streams[i] = func() *mproto.QueryResult {
+			select {
+			case qr, ok := <-stream:
+				if ok {
+					return qr
+				}
+			case <-done:
+			}
+			return nil
+		}
+	}
+
+	var err error
+	if plan.grouped {
+		results := make([]*mproto.QueryResult, len(streams))
+		for i, next := range streams {
+			results[i] = new(mproto.QueryResult)
+			for qr := next(); qr != nil; qr = next() {
+				if results[i].Fields == nil {
+					results[i].Fields = qr.Fields
+				}
+				results[i].Rows = append(results[i].Rows, qr.Rows...)
+			}
+		}
+		var qr *mproto.QueryResult
+		if qr, err = plan.merge(results); err == nil && !allErrors.HasErrors() {
+			err = sendReply(qr)
+		}
+	} else {
+		err = plan.mergeStreams(streams, sendReply)
+	}
+	close(quit)
+	for _ = range done {
+	}
+	if err != nil {
+		allErrors.RecordError(err)
+	}
+	return allErrors.AggrError(stc.aggregateErrors)
+}
+
+// ExplainQuery describes how the vttablet of the shard would execute
+// the query. The retry rules are the same as Execute.
+func (stc *ScatterConn) ExplainQuery(
+	context context.Context,
+	query string,
+	bindVars map[string]interface{},
+	keyspace string,
+	shard string,
+	tabletType topo.TabletType,
+) (*tproto.QueryExplanation, error) {
+	return stc.getConnection(context, keyspace, shard, tabletType).ExplainQuery(context, query, bindVars)
+}
+
 // Commit commits the current transaction. There are no retries on this operation.
 func (stc *ScatterConn) Commit(context context.Context, session *SafeSession) (err error) {
+	span, context := startSpan(context, "Commit", "", nil)
+	defer span.Finish()
 	if !session.InTransaction() {
 		return fmt.Errorf("cannot commit: not in transaction")
 	}
+	if *atomicCommit && len(session.ShardSessions) > 1 {
+		err = stc.commit2PC(context, session.ShardSessions)
+		session.Reset()
+		return err
+	}
 	committing := true
 	for _, shardSession := range session.ShardSessions {
 		sdc := stc.getConnection(context, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
@@ -234,8 +399,95 @@ func (stc *ScatterConn) Commit(context context.Context, session *SafeSession) (e
 	return err
 }
 
+// commit2PC commits the transaction with two-phase commit. The first
+// shard session coordinates the distributed transaction: it records
+// the participants, then the commit decision as part of its own
+// commit. Participants that are left prepared after a failure are
+// resolved later using the coordinator log.
+func (stc *ScatterConn) commit2PC(context context.Context, shardSessions []*proto.ShardSession) error {
+	mmSession := shardSessions[0]
+	participants := shardSessions[1:]
+	dtid := fmt.Sprintf("%v:%v:%v", mmSession.Keyspace, mmSession.Shard, mmSession.TransactionId)
+	mm := stc.getConnection(context, mmSession.Keyspace, mmSession.Shard, mmSession.TabletType)
+
+	txParticipants := make([]tproto.TxParticipant, len(participants))
+	for i, shardSession := range participants {
+		txParticipants[i] = tproto.TxParticipant{Keyspace: shardSession.Keyspace, Shard: shardSession.Shard}
+	}
+	if err := mm.CreateTransaction(context, dtid, txParticipants); err != nil {
+		// Nothing is prepared yet, so the transactions can be
+		// rolled back. If the coordinator log was written anyway,
+		// the resolver finds no prepared participant.
+		rerr := stc.runOnShardSessions(context, shardSessions, func(sdc *ShardConn, shardSession *proto.ShardSession) error {
+			return sdc.Rollback(context, shardSession.TransactionId)
+		})
+		if rerr != nil {
+			return fmt.Errorf("%v, and the rollback failed: %v", err, rerr)
+		}
+		return err
+	}
+
+	err := stc.runOnShardSessions(context, participants, func(sdc *ShardConn, shardSession *proto.ShardSession) error {
+		return sdc.Prepare(context, shardSession.TransactionId, dtid)
+	})
+	rollbackID := mmSession.TransactionId
+	if err == nil {
+		err = mm.StartCommit(context, mmSession.TransactionId, dtid)
+		if err == nil {
+			stc.resolve2PC(context, mm, participants, dtid, true)
+			return nil
+		}
+		// StartCommit ends the coordinator transaction. The commit
+		// decision may have been recorded: SetRollback fails if so.
+		rollbackID = 0
+	}
+	if rerr := mm.SetRollback(context, dtid, rollbackID); rerr != nil {
+		log.Warningf("cannot roll back distributed transaction %v, leaving it to the resolver: %v", dtid, rerr)
+		return err
+	}
+	stc.resolve2PC(context, mm, participants, dtid, false)
+	return err
+}
+
+// resolve2PC commits or rolls back the prepared participants of dtid,
+// and removes it from the coordinator log if they all succeeded.
+func (stc *ScatterConn) resolve2PC(context context.Context, mm *ShardConn, participants []*proto.ShardSession, dtid string, commit bool) {
+	err := stc.runOnShardSessions(context, participants, func(sdc *ShardConn, shardSession *proto.ShardSession) error {
+		if commit {
+			return sdc.CommitPrepared(context, dtid)
+		}
+		return sdc.RollbackPrepared(context, dtid, shardSession.TransactionId)
+	})
+	if err != nil {
+		log.Warningf("cannot resolve distributed transaction %v, leaving it to the resolver: %v", dtid, err)
+		return
+	}
+	if err := mm.ConcludeTransaction(context, dtid); err != nil {
+		log.Warningf("cannot conclude distributed transaction %v: %v", dtid, err)
+	}
+}
+
+// runOnShardSessions runs action on the ShardConn of every shard
+// session in parallel.
+func (stc *ScatterConn) runOnShardSessions(context context.Context, shardSessions []*proto.ShardSession, action func(*ShardConn, *proto.ShardSession) error) error {
+	var wg sync.WaitGroup
+	allErrors := new(concurrency.AllErrorRecorder)
+	for _, shardSession := range shardSessions {
+		wg.Add(1)
+		go func(shardSession *proto.ShardSession) {
+			defer wg.Done()
+			sdc := stc.getConnection(context, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+			allErrors.RecordError(action(sdc, shardSession))
+		}(shardSession)
+	}
+	wg.Wait()
+	return allErrors.AggrError(stc.aggregateErrors)
+}
+
 // Rollback rolls back the current transaction. There are no retries on this operation.
 func (stc *ScatterConn) Rollback(context context.Context, session *SafeSession) (err error) {
+	span, context := startSpan(context, "Rollback", "", nil)
+	defer span.Finish()
 	for _, shardSession := range session.ShardSessions {
 		sdc := stc.getConnection(context, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
 		go sdc.Rollback(context, shardSession.TransactionId)
@@ -406,6 +658,17 @@ func (stc *ScatterConn) updateSession(
 	return transactionId, nil
 }
 
+// startSpan starts the span of a ScatterConn call, annotated with
+// the keyspace and shards it goes to, if any.
+func startSpan(ctx context.Context, name, keyspace string, shards []string) (*trace.Span, context.Context) {
+	span, ctx := context.StartSpan(ctx, "ScatterConn."+name)
+	if keyspace != "" {
+		span.Annotate("keyspace", keyspace)
+		span.Annotate("shards", strings.Join(shards, ","))
+	}
+	return span, ctx
+}
+
 func appendResult(qr, innerqr *mproto.QueryResult) {
 	if innerqr.RowsAffected == 0 && len(innerqr.Fields) == 0 {
 		return
@@ -12,6 +12,7 @@ import (
 
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/trace"
 	"github.com/youtube/vitess/go/vt/context"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
@@ -240,6 +241,104 @@ func TestScatterConnRollback(t *testing.T) {
 	*/
 }
 
+func TestScatterConnCommit2PC(t *testing.T) {
+	*atomicCommit = true
+	defer func() { *atomicCommit = false }()
+	s := createSandbox("TestScatterConnCommit2PC")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+
+	session := NewSafeSession(&proto.Session{InTransaction: true})
+	stc.Execute(&context.DummyContext{}, "query1", nil, "TestScatterConnCommit2PC", []string{"0"}, "", session)
+	stc.Execute(&context.DummyContext{}, "query1", nil, "TestScatterConnCommit2PC", []string{"1"}, "", session)
+	if err := stc.Commit(&context.DummyContext{}, session); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	// shard 0 coordinates, shard 1 is prepared then committed
+	counts := []struct {
+		name      string
+		got, want int64
+	}{
+		{"sbc0.CreateTransaction", sbc0.CreateTransactionCount.Get(), 1},
+		{"sbc0.StartCommit", sbc0.StartCommitCount.Get(), 1},
+		{"sbc0.ConcludeTransaction", sbc0.ConcludeTransactionCount.Get(), 1},
+		{"sbc0.Commit", sbc0.CommitCount.Get(), 0},
+		{"sbc1.Prepare", sbc1.PrepareCount.Get(), 1},
+		{"sbc1.CommitPrepared", sbc1.CommitPreparedCount.Get(), 1},
+		{"sbc1.Commit", sbc1.CommitCount.Get(), 0},
+	}
+	for _, c := range counts {
+		if c.got != c.want {
+			t.Errorf("%v: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if session.InTransaction() {
+		t.Errorf("session is still in a transaction")
+	}
+
+	// a failed prepare rolls back the distributed transaction
+	session = NewSafeSession(&proto.Session{InTransaction: true})
+	stc.Execute(&context.DummyContext{}, "query1", nil, "TestScatterConnCommit2PC", []string{"0"}, "", session)
+	stc.Execute(&context.DummyContext{}, "query1", nil, "TestScatterConnCommit2PC", []string{"1"}, "", session)
+	sbc1.mustFailServer = 1
+	if err := stc.Commit(&context.DummyContext{}, session); err == nil {
+		t.Errorf("Commit worked with a failed prepare")
+	}
+	counts = []struct {
+		name      string
+		got, want int64
+	}{
+		{"sbc0.StartCommit", sbc0.StartCommitCount.Get(), 1},
+		{"sbc0.SetRollback", sbc0.SetRollbackCount.Get(), 1},
+		{"sbc0.ConcludeTransaction", sbc0.ConcludeTransactionCount.Get(), 2},
+		{"sbc1.Prepare", sbc1.PrepareCount.Get(), 2},
+		{"sbc1.RollbackPrepared", sbc1.RollbackPreparedCount.Get(), 1},
+		{"sbc1.CommitPrepared", sbc1.CommitPreparedCount.Get(), 1},
+	}
+	for _, c := range counts {
+		if c.got != c.want {
+			t.Errorf("%v: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	// a failed CreateTransaction rolls back all the transactions
+	session = NewSafeSession(&proto.Session{InTransaction: true})
+	stc.Execute(&context.DummyContext{}, "query1", nil, "TestScatterConnCommit2PC", []string{"0"}, "", session)
+	stc.Execute(&context.DummyContext{}, "query1", nil, "TestScatterConnCommit2PC", []string{"1"}, "", session)
+	sbc0.mustFailServer = 1
+	if err := stc.Commit(&context.DummyContext{}, session); err == nil {
+		t.Errorf("Commit worked with a failed CreateTransaction")
+	}
+	counts = []struct {
+		name      string
+		got, want int64
+	}{
+		{"sbc0.CreateTransaction", sbc0.CreateTransactionCount.Get(), 3},
+		{"sbc0.Rollback", sbc0.RollbackCount.Get(), 1},
+		{"sbc0.StartCommit", sbc0.StartCommitCount.Get(), 1},
+		{"sbc1.Prepare", sbc1.PrepareCount.Get(), 2},
+		{"sbc1.Rollback", sbc1.RollbackCount.Get(), 1},
+	}
+	for _, c := range counts {
+		if c.got != c.want {
+			t.Errorf("%v: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	// a single shard transaction does not use two-phase commit
+	session = NewSafeSession(&proto.Session{InTransaction: true})
+	stc.Execute(&context.DummyContext{}, "query1", nil, "TestScatterConnCommit2PC", []string{"0"}, "", session)
+	if err := stc.Commit(&context.DummyContext{}, session); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if sbc0.CommitCount.Get() != 1 || sbc0.CreateTransactionCount.Get() != 3 {
+		t.Errorf("got %v commits and %v transactions created, want 1 and 3", sbc0.CommitCount.Get(), sbc0.CreateTransactionCount.Get())
+	}
+}
+
 func TestScatterConnClose(t *testing.T) {
 	s := createSandbox("TestScatterConnClose")
 	sbc := &sandboxConn{}
@@ -256,6 +355,40 @@ func TestScatterConnClose(t *testing.T) {
 	*/
 }
 
+func TestScatterConnTrace(t *testing.T) {
+	collector := trace.NewCollector(1)
+	trace.RegisterExporter(collector)
+	s := createSandbox("TestScatterConnTrace")
+	s.MapTestConn("0", &sandboxConn{})
+	s.MapTestConn("1", &sandboxConn{})
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+
+	root := &trace.Span{TraceID: 1, SpanID: 2}
+	ctx := context.WithSpan(&context.DummyContext{}, root)
+	if _, err := stc.Execute(ctx, "query", nil, "TestScatterConnTrace", []string{"0", "1"}, "", nil); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	traces := collector.Traces()
+	if len(traces) != 1 || len(traces[0]) != 3 {
+		t.Fatalf("want 1 trace with 3 spans, got %v", traces)
+	}
+	scatter := traces[0][0]
+	if scatter.Name != "ScatterConn.Execute" || scatter.TraceID != 1 || scatter.ParentID != 2 {
+		t.Errorf("unexpected ScatterConn span: %v", scatter)
+	}
+	shards := make(map[string]bool)
+	for _, span := range traces[0][1:] {
+		if span.Name != "ShardConn.Execute" || span.ParentID != scatter.SpanID {
+			t.Errorf("unexpected ShardConn span: %v", span)
+		}
+		shards[span.Annotations()["shard"]] = true
+	}
+	if !shards["0"] || !shards["1"] {
+		t.Errorf("want spans for shards 0 and 1, got %v", shards)
+	}
+}
+
 func TestAppendResult(t *testing.T) {
 	qr := new(mproto.QueryResult)
 	innerqr1 := &mproto.QueryResult{
@@ -6,10 +6,12 @@ package vtgate
 
 import (
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
 	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/trace"
 	"github.com/youtube/vitess/go/vt/context"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
@@ -75,6 +77,8 @@ func (e *ShardConnError) Error() string {
 // it retries retryCount times before failing. It does not retry if the connection is in
 // the middle of a transaction.
 func (sdc *ShardConn) Execute(ctx context.Context, query string, bindVars map[string]interface{}, transactionID int64) (qr *mproto.QueryResult, err error) {
+	span, ctx := sdc.startSpan(ctx, "Execute")
+	defer span.Finish()
 	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
 		var innerErr error
 		qr, innerErr = conn.Execute(ctx, query, bindVars, transactionID)
@@ -85,6 +89,8 @@ func (sdc *ShardConn) Execute(ctx context.Context, query string, bindVars map[st
 
 // ExecuteBatch executes a group of queries. The retry rules are the same as Execute.
 func (sdc *ShardConn) ExecuteBatch(ctx context.Context, queries []tproto.BoundQuery, transactionID int64) (qrs *tproto.QueryResultList, err error) {
+	span, ctx := sdc.startSpan(ctx, "ExecuteBatch")
+	defer span.Finish()
 	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
 		var innerErr error
 		qrs, innerErr = conn.ExecuteBatch(ctx, queries, transactionID)
@@ -95,6 +101,7 @@ func (sdc *ShardConn) ExecuteBatch(ctx context.Context, queries []tproto.BoundQu
 
 // StreamExecute executes a streaming query on vttablet. The retry rules are the same as Execute.
 func (sdc *ShardConn) StreamExecute(ctx context.Context, query string, bindVars map[string]interface{}, transactionID int64) (<-chan *mproto.QueryResult, tabletconn.ErrFunc) {
+	span, ctx := sdc.startSpan(ctx, "StreamExecute")
 	var usedConn tabletconn.TabletConn
 	var erFunc tabletconn.ErrFunc
 	var results <-chan *mproto.QueryResult
@@ -104,14 +111,34 @@ func (sdc *ShardConn) StreamExecute(ctx context.Context, query string, bindVars
 		return erFunc()
 	}, transactionID, true)
 	if err != nil {
+		defer span.Finish()
 		return results, func() error { return err }
 	}
 	inTransaction := (transactionID != 0)
-	return results, func() error { return sdc.WrapError(erFunc(), usedConn.EndPoint(), inTransaction) }
+	return results, func() error {
+		// the stream is over when the caller checks its error
+		defer span.Finish()
+		return sdc.WrapError(erFunc(), usedConn.EndPoint(), inTransaction)
+	}
+}
+
+// ExplainQuery describes how vttablet would execute the query.
+// The retry rules are the same as Execute.
+func (sdc *ShardConn) ExplainQuery(ctx context.Context, query string, bindVars map[string]interface{}) (explanation *tproto.QueryExplanation, err error) {
+	span, ctx := sdc.startSpan(ctx, "ExplainQuery")
+	defer span.Finish()
+	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		var innerErr error
+		explanation, innerErr = conn.ExplainQuery(ctx, query, bindVars)
+		return innerErr
+	}, 0, false)
+	return explanation, err
 }
 
 // Begin begins a transaction. The retry rules are the same as Execute.
 func (sdc *ShardConn) Begin(ctx context.Context) (transactionID int64, err error) {
+	span, ctx := sdc.startSpan(ctx, "Begin")
+	defer span.Finish()
 	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
 		var innerErr error
 		transactionID, innerErr = conn.Begin(ctx)
@@ -122,6 +149,8 @@ func (sdc *ShardConn) Begin(ctx context.Context) (transactionID int64, err error
 
 // Commit commits the current transaction. The retry rules are the same as Execute.
 func (sdc *ShardConn) Commit(ctx context.Context, transactionID int64) (err error) {
+	span, ctx := sdc.startSpan(ctx, "Commit")
+	defer span.Finish()
 	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
 		return conn.Commit(ctx, transactionID)
 	}, transactionID, false)
@@ -129,11 +158,84 @@ func (sdc *ShardConn) Commit(ctx context.Context, transactionID int64) (err erro
 
 // Rollback rolls back the current transaction. The retry rules are the same as Execute.
 func (sdc *ShardConn) Rollback(ctx context.Context, transactionID int64) (err error) {
+	span, ctx := sdc.startSpan(ctx, "Rollback")
+	defer span.Finish()
 	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
 		return conn.Rollback(ctx, transactionID)
 	}, transactionID, false)
 }
 
+// Prepare prepares the transaction for the distributed transaction dtid.
+// The retry rules are the same as Execute.
+func (sdc *ShardConn) Prepare(ctx context.Context, transactionID int64, dtid string) (err error) {
+	span, ctx := sdc.startSpan(ctx, "Prepare")
+	defer span.Finish()
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.Prepare(ctx, transactionID, dtid)
+	}, transactionID, false)
+}
+
+// CommitPrepared commits the transaction prepared for dtid. It is
+// idempotent, so it is retried like a query outside of a transaction.
+func (sdc *ShardConn) CommitPrepared(ctx context.Context, dtid string) (err error) {
+	span, ctx := sdc.startSpan(ctx, "CommitPrepared")
+	defer span.Finish()
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.CommitPrepared(ctx, dtid)
+	}, 0, false)
+}
+
+// RollbackPrepared rolls back the transaction prepared for dtid, or
+// transactionID if it was not prepared. The retry rules are the same
+// as Execute.
+func (sdc *ShardConn) RollbackPrepared(ctx context.Context, dtid string, transactionID int64) (err error) {
+	span, ctx := sdc.startSpan(ctx, "RollbackPrepared")
+	defer span.Finish()
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.RollbackPrepared(ctx, dtid, transactionID)
+	}, transactionID, false)
+}
+
+// CreateTransaction records dtid and its participants in the
+// coordinator log. The retry rules are the same as Execute.
+func (sdc *ShardConn) CreateTransaction(ctx context.Context, dtid string, participants []tproto.TxParticipant) (err error) {
+	span, ctx := sdc.startSpan(ctx, "CreateTransaction")
+	defer span.Finish()
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.CreateTransaction(ctx, dtid, participants)
+	}, 0, false)
+}
+
+// StartCommit records the commit decision for dtid and commits
+// transactionID. The retry rules are the same as Execute.
+func (sdc *ShardConn) StartCommit(ctx context.Context, transactionID int64, dtid string) (err error) {
+	span, ctx := sdc.startSpan(ctx, "StartCommit")
+	defer span.Finish()
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.StartCommit(ctx, transactionID, dtid)
+	}, transactionID, false)
+}
+
+// SetRollback records the rollback decision for dtid and rolls back
+// transactionID if it is set. The retry rules are the same as Execute.
+func (sdc *ShardConn) SetRollback(ctx context.Context, dtid string, transactionID int64) (err error) {
+	span, ctx := sdc.startSpan(ctx, "SetRollback")
+	defer span.Finish()
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.SetRollback(ctx, dtid, transactionID)
+	}, transactionID, false)
+}
+
+// ConcludeTransaction removes dtid from the coordinator log.
+// The retry rules are the same as Execute.
+func (sdc *ShardConn) ConcludeTransaction(ctx context.Context, dtid string) (err error) {
+	span, ctx := sdc.startSpan(ctx, "ConcludeTransaction")
+	defer span.Finish()
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.ConcludeTransaction(ctx, dtid)
+	}, 0, false)
+}
+
 // Close closes the underlying TabletConn. ShardConn can be
 // reused after this because it opens connections on demand.
 func (sdc *ShardConn) Close() {
@@ -160,12 +262,14 @@ func (sdc *ShardConn) withRetry(ctx context.Context, action func(conn tabletconn
 	// execute the action at least once even without retrying
 	for i := 0; i < sdc.retryCount+1; i++ {
 		conn, endPoint, err, retry = sdc.getConn(ctx)
+		context.Span(ctx).Annotate("attempts", strconv.Itoa(i+1))
 		if err != nil {
 			if retry {
 				continue
 			}
 			return sdc.WrapError(err, endPoint, inTransaction)
 		}
+		context.Span(ctx).Annotate("tablet", fmt.Sprintf("%v:%v", endPoint.Host, endPoint.Uid))
 		// no timeout for streaming query
 		if isStreaming {
 			err = action(conn)
@@ -192,6 +296,16 @@ func (sdc *ShardConn) withRetry(ctx context.Context, action func(conn tabletconn
 	return sdc.WrapError(err, endPoint, inTransaction)
 }
 
+// startSpan starts the span of a call to the shard, and returns the
+// context to make the call with.
+func (sdc *ShardConn) startSpan(ctx context.Context, name string) (*trace.Span, context.Context) {
+	span, ctx := context.StartSpan(ctx, "ShardConn."+name)
+	span.Annotate("keyspace", sdc.keyspace)
+	span.Annotate("shard", sdc.shard)
+	span.Annotate("tablet_type", string(sdc.tabletType))
+	return span, ctx
+}
+
 // getConn reuses an existing connection if possible. Otherwise
 // it returns a connection which it will save for future reuse.
 // If it returns an error,  retry will tell you if getConn can be retried.
@@ -217,7 +331,8 @@ func (sdc *ShardConn) getConn(ctx context.Context) (conn tabletconn.TabletConn,
 
 // canRetry determines whether a query can be retried or not.
 // OperationalErrors like retry/fatal cause a reconnect and retry if query is not in a txn.
-// TxPoolFull causes a retry and all other errors are non-retry.
+// TxPoolFull causes a retry, Throttled causes a retry if query is not in a txn,
+// and all other errors are non-retry.
 func (sdc *ShardConn) canRetry(err error, transactionID int64, conn tabletconn.TabletConn) bool {
 	if err == nil {
 		return false
@@ -228,6 +343,15 @@ func (sdc *ShardConn) canRetry(err error, transactionID int64, conn tabletconn.T
 			// Retry without reconnecting.
 			time.Sleep(sdc.retryDelay)
 			return true
+		case tabletconn.ERR_THROTTLED:
+			// Back off and retry without reconnecting. In a
+			// transaction, a batch may have run some of its
+			// queries already, so it can't be replayed.
+			if transactionID != 0 {
+				return false
+			}
+			time.Sleep(sdc.retryDelay)
+			return true
 		case tabletconn.ERR_RETRY, tabletconn.ERR_FATAL:
 			// No-op: treat these errors as operational by breaking out of this switch
 		default:
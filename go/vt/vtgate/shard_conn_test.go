@@ -43,6 +43,14 @@ func TestShardConnExecuteBatch(t *testing.T) {
 	})
 }
 
+func TestShardConnExplainQuery(t *testing.T) {
+	testShardConnGeneric(t, "TestShardConnExplainQuery", func() error {
+		sdc := NewShardConn(&context.DummyContext{}, new(sandboxTopo), "aa", "TestShardConnExplainQuery", "0", "", 1*time.Millisecond, 3, 1*time.Millisecond)
+		_, err := sdc.ExplainQuery(nil, "query", nil)
+		return err
+	})
+}
+
 func TestShardConnExecuteStream(t *testing.T) {
 	testShardConnGeneric(t, "TestShardConnExecuteStream", func() error {
 		sdc := NewShardConn(&context.DummyContext{}, new(sandboxTopo), "aa", "TestShardConnExecuteStream", "0", "", 1*time.Millisecond, 3, 1*time.Millisecond)
@@ -261,3 +269,40 @@ func TestShardConnBeginOther(t *testing.T) {
 		t.Errorf("want 2, got %v", sbc.ExecCount)
 	}
 }
+
+func TestShardConnThrottled(t *testing.T) {
+	s := createSandbox("TestShardConnThrottled")
+	sbc := &sandboxConn{mustThrottle: 1}
+	s.MapTestConn("0", sbc)
+	sdc := NewShardConn(&context.DummyContext{}, new(sandboxTopo), "aa", "TestShardConnThrottled", "0", "", 10*time.Millisecond, 3, 1*time.Millisecond)
+	startTime := time.Now()
+	_, err := sdc.Execute(nil, "query", nil, 0)
+	// If the query is throttled, Execute should back off and retry.
+	if time.Now().Sub(startTime) < (10 * time.Millisecond) {
+		t.Errorf("want >10ms, got %v", time.Now().Sub(startTime))
+	}
+	if err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	// There should have been no redial.
+	if s.DialCounter != 1 {
+		t.Errorf("want 1, got %v", s.DialCounter)
+	}
+	if sbc.ExecCount != 2 {
+		t.Errorf("want 2, got %v", sbc.ExecCount)
+	}
+
+	// Should not retry if we're in transaction
+	s.Reset()
+	sbc = &sandboxConn{mustThrottle: 1}
+	s.MapTestConn("0", sbc)
+	sdc = NewShardConn(&context.DummyContext{}, new(sandboxTopo), "aa", "TestShardConnThrottled", "0", "", 10*time.Millisecond, 3, 1*time.Millisecond)
+	_, err = sdc.Execute(nil, "query", nil, 1)
+	want := "throttled: err, shard, host: TestShardConnThrottled.0., {Uid:0 Host:0 NamedPortMap:map[vt:1] Health:map[]}"
+	if err == nil || err.Error() != want {
+		t.Errorf("want %s, got %v", want, err)
+	}
+	if sbc.ExecCount != 1 {
+		t.Errorf("want 1, got %v", sbc.ExecCount)
+	}
+}
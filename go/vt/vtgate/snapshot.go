@@ -0,0 +1,354 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	blproto "github.com/youtube/vitess/go/vt/binlog/proto"
+	"github.com/youtube/vitess/go/vt/concurrency"
+	"github.com/youtube/vitess/go/vt/context"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/tabletmanager/initiator"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+// snapshotWaitTime is how long the replication actions on the
+// tablets can take.
+const snapshotWaitTime = 30 * time.Second
+
+// SnapshotTabletManager is what the SnapshotReader needs from the
+// topology and the tablet managers to align the replication of the
+// rdonly tablets.
+type SnapshotTabletManager interface {
+	// GetShard returns the shard record, with its master and its
+	// filtered replication sources.
+	GetShard(keyspace, shard string) (*topo.ShardInfo, error)
+
+	// MasterPosition returns the current position of a master.
+	MasterPosition(tabletAlias topo.TabletAlias, waitTime time.Duration) (*myproto.ReplicationPosition, error)
+
+	// StopSlaveMinimum stops the replication of a slave once it
+	// reached at least the given position.
+	StopSlaveMinimum(tabletAlias topo.TabletAlias, gtid myproto.GTID, waitTime time.Duration) (*myproto.ReplicationPosition, error)
+
+	// WaitBlpPosition waits until the filtered replication of a
+	// master reached at least the given position.
+	WaitBlpPosition(tabletAlias topo.TabletAlias, blpPosition blproto.BlpPosition, waitTime time.Duration) error
+
+	// StartSlave restarts the replication of a slave.
+	StartSlave(tabletAlias topo.TabletAlias, waitTime time.Duration) error
+}
+
+// initiatorTabletManager implements SnapshotTabletManager with an
+// ActionInitiator.
+type initiatorTabletManager struct {
+	*initiator.ActionInitiator
+	ts topo.Server
+}
+
+// NewSnapshotTabletManager returns a SnapshotTabletManager that talks
+// to the tablets found in the given topology server.
+func NewSnapshotTabletManager(ts topo.Server, tabletManagerProtocol string) SnapshotTabletManager {
+	return &initiatorTabletManager{
+		ActionInitiator: initiator.NewActionInitiator(ts, tabletManagerProtocol),
+		ts:              ts,
+	}
+}
+
+func (itm *initiatorTabletManager) GetShard(keyspace, shard string) (*topo.ShardInfo, error) {
+	return itm.ts.GetShard(keyspace, shard)
+}
+
+func (itm *initiatorTabletManager) MasterPosition(tabletAlias topo.TabletAlias, waitTime time.Duration) (*myproto.ReplicationPosition, error) {
+	ti, err := itm.ts.GetTablet(tabletAlias)
+	if err != nil {
+		return nil, err
+	}
+	return itm.ActionInitiator.MasterPosition(ti, waitTime)
+}
+
+// shardSnapshot is the snapshot transaction of one shard.
+type shardSnapshot struct {
+	shard     string
+	endPoint  topo.EndPoint
+	alias     topo.TabletAlias
+	shardInfo *topo.ShardInfo
+
+	// set when the replication of the tablet is stopped, to the
+	// position it stopped at
+	stopped  bool
+	position myproto.GTIDField
+
+	conn          tabletconn.TabletConn
+	transactionId int64
+}
+
+// SnapshotReader streams the results of a query from consistent
+// snapshots of the shards of a keyspace. One rdonly tablet is picked
+// per shard, and its replication is stopped after the position its
+// master had when the snapshot started. A snapshot transaction is
+// then started on each of them, and their replication restarted. The
+// query is streamed from the snapshots, and the transactions are
+// rolled back at the end of the stream.
+//
+// The shards don't share a replication position, so there is no
+// single cross-shard position the snapshots correspond to: each one
+// is consistent within its shard, and contains at least what its
+// master committed before the snapshot started. A transaction that
+// commits on several shards meanwhile can be in some of the
+// snapshots only. The exception is filtered replication between
+// shards of the snapshot: a destination shard is stopped after it
+// replicated everything its sources' snapshots contain.
+type SnapshotReader struct {
+	serv    SrvTopoServer
+	tm      SnapshotTabletManager
+	cell    string
+	timeout time.Duration
+}
+
+// NewSnapshotReader creates a SnapshotReader. timeout is the
+// connection timeout to the tablets.
+func NewSnapshotReader(serv SrvTopoServer, tm SnapshotTabletManager, cell string, timeout time.Duration) *SnapshotReader {
+	return &SnapshotReader{
+		serv:    serv,
+		tm:      tm,
+		cell:    cell,
+		timeout: timeout,
+	}
+}
+
+// StreamExecuteKeyRanges streams the query from consistent snapshots
+// of the shards covering the KeyRanges. The TabletType has to be rdonly.
+func (sr *SnapshotReader) StreamExecuteKeyRanges(context context.Context, query *proto.KeyRangeQuery, sendReply func(*mproto.QueryResult) error) error {
+	if query.TabletType != topo.TYPE_RDONLY {
+		return fmt.Errorf("consistent snapshots can only be read from %v tablets, not %v", topo.TYPE_RDONLY, query.TabletType)
+	}
+	if query.Session != nil && query.Session.InTransaction {
+		return fmt.Errorf("consistent snapshots cannot be read in a transaction")
+	}
+	keyspace, shards, err := mapKeyRangesToShards(sr.serv, sr.cell, query.Keyspace, query.TabletType, query.KeyRanges)
+	if err != nil {
+		return err
+	}
+	// the shards are stopped in order, so it doesn't change from
+	// one snapshot to the next
+	sort.Strings(shards)
+
+	snapshots := make([]*shardSnapshot, 0, len(shards))
+	seen := make(map[string]bool, len(shards))
+	for _, shard := range shards {
+		if seen[shard] {
+			continue
+		}
+		seen[shard] = true
+		endPoints, err := sr.serv.GetEndPoints(context, sr.cell, keyspace, shard, topo.TYPE_RDONLY)
+		if err != nil {
+			return fmt.Errorf("cannot get the %v tablets of %v/%v: %v", topo.TYPE_RDONLY, keyspace, shard, err)
+		}
+		if len(endPoints.Entries) == 0 {
+			return fmt.Errorf("no %v tablet in %v/%v", topo.TYPE_RDONLY, keyspace, shard)
+		}
+		endPoint := endPoints.Entries[rand.Intn(len(endPoints.Entries))]
+		snapshots = append(snapshots, &shardSnapshot{
+			shard:    shard,
+			endPoint: endPoint,
+			alias:    topo.TabletAlias{Cell: sr.cell, Uid: endPoint.Uid},
+		})
+	}
+
+	defer sr.release(context, snapshots)
+	if err := sr.begin(context, keyspace, snapshots); err != nil {
+		return err
+	}
+	return sr.stream(context, query, snapshots, sendReply)
+}
+
+// begin stops the replication of the tablets at a common position,
+// starts the snapshot transactions, and restarts the replication.
+func (sr *SnapshotReader) begin(context context.Context, keyspace string, snapshots []*shardSnapshot) error {
+	defer sr.startReplication(snapshots)
+	if err := sr.stopReplication(keyspace, snapshots); err != nil {
+		return err
+	}
+
+	allErrors := new(concurrency.AllErrorRecorder)
+	var wg sync.WaitGroup
+	for _, ss := range snapshots {
+		wg.Add(1)
+		go func(ss *shardSnapshot) {
+			defer wg.Done()
+			conn, err := tabletconn.GetDialer()(context, ss.endPoint, keyspace, ss.shard, sr.timeout)
+			if err != nil {
+				allErrors.RecordError(fmt.Errorf("cannot connect to %v: %v", ss.alias, err))
+				return
+			}
+			ss.conn = conn
+			ss.transactionId, err = conn.BeginConsistentSnapshot(context)
+			if err != nil {
+				allErrors.RecordError(fmt.Errorf("cannot begin snapshot on %v: %v", ss.alias, err))
+			}
+		}(ss)
+	}
+	wg.Wait()
+	return allErrors.Error()
+}
+
+// stopReplication stops the replication of the tablets after the
+// current position of their master. A shard with filtered replication
+// from other shards of the snapshot is stopped after them, once its
+// master has replicated at least up to where they stopped.
+func (sr *SnapshotReader) stopReplication(keyspace string, snapshots []*shardSnapshot) error {
+	byShard := make(map[string]*shardSnapshot, len(snapshots))
+	for _, ss := range snapshots {
+		si, err := sr.tm.GetShard(keyspace, ss.shard)
+		if err != nil {
+			return fmt.Errorf("cannot read shard %v/%v: %v", keyspace, ss.shard, err)
+		}
+		if si.MasterAlias.IsZero() {
+			return fmt.Errorf("shard %v/%v has no master", keyspace, ss.shard)
+		}
+		ss.shardInfo = si
+		byShard[ss.shard] = ss
+	}
+
+	remaining := snapshots
+	for len(remaining) > 0 {
+		var ready, waiting []*shardSnapshot
+		for _, ss := range remaining {
+			if sourcesStopped(keyspace, ss, byShard) {
+				ready = append(ready, ss)
+			} else {
+				waiting = append(waiting, ss)
+			}
+		}
+		if len(ready) == 0 {
+			return fmt.Errorf("filtered replication loop between the shards of %v", keyspace)
+		}
+
+		// read all the master positions first, so the tablets
+		// stop as close in time as possible
+		positions := make([]myproto.GTID, len(ready))
+		for i, ss := range ready {
+			for _, source := range ss.shardInfo.SourceShards {
+				sourceSnapshot, ok := byShard[source.Shard]
+				if source.Keyspace != keyspace || !ok {
+					continue
+				}
+				blpPosition := blproto.BlpPosition{Uid: source.Uid, GTIDField: sourceSnapshot.position}
+				if err := sr.tm.WaitBlpPosition(ss.shardInfo.MasterAlias, blpPosition, snapshotWaitTime); err != nil {
+					return fmt.Errorf("WaitBlpPosition for %v at %v failed: %v", ss.shardInfo.MasterAlias, blpPosition, err)
+				}
+			}
+			masterPos, err := sr.tm.MasterPosition(ss.shardInfo.MasterAlias, snapshotWaitTime)
+			if err != nil {
+				return fmt.Errorf("MasterPosition for %v failed: %v", ss.shardInfo.MasterAlias, err)
+			}
+			positions[i] = masterPos.MasterLogGTIDField.Value
+		}
+		for i, ss := range ready {
+			stoppedAt, err := sr.tm.StopSlaveMinimum(ss.alias, positions[i], snapshotWaitTime)
+			if err != nil {
+				return fmt.Errorf("StopSlaveMinimum for %v at %v failed: %v", ss.alias, positions[i], err)
+			}
+			ss.stopped = true
+			ss.position = stoppedAt.MasterLogGTIDField
+		}
+		remaining = waiting
+	}
+	return nil
+}
+
+// sourcesStopped returns true if all the sources of the shard that
+// are part of the snapshot have their replication stopped.
+func sourcesStopped(keyspace string, ss *shardSnapshot, byShard map[string]*shardSnapshot) bool {
+	for _, source := range ss.shardInfo.SourceShards {
+		if source.Keyspace != keyspace {
+			continue
+		}
+		if sourceSnapshot, ok := byShard[source.Shard]; ok && !sourceSnapshot.stopped {
+			return false
+		}
+	}
+	return true
+}
+
+// startReplication restarts the replication of the tablets that
+// were stopped. Errors are only logged, the snapshots stay usable.
+func (sr *SnapshotReader) startReplication(snapshots []*shardSnapshot) {
+	for _, ss := range snapshots {
+		if !ss.stopped {
+			continue
+		}
+		if err := sr.tm.StartSlave(ss.alias, snapshotWaitTime); err != nil {
+			log.Errorf("StartSlave for %v failed, its replication needs to be restarted: %v", ss.alias, err)
+			continue
+		}
+		ss.stopped = false
+	}
+}
+
+// stream runs the query in all the snapshot transactions in parallel.
+func (sr *SnapshotReader) stream(context context.Context, query *proto.KeyRangeQuery, snapshots []*shardSnapshot, sendReply func(*mproto.QueryResult) error) error {
+	allErrors := new(concurrency.AllErrorRecorder)
+	results := make(chan *mproto.QueryResult, len(snapshots))
+	var wg sync.WaitGroup
+	for _, ss := range snapshots {
+		wg.Add(1)
+		go func(ss *shardSnapshot) {
+			defer wg.Done()
+			qrs, errFunc := ss.conn.StreamExecute(context, query.Sql, query.BindVariables, ss.transactionId)
+			if qrs != nil {
+				for qr := range qrs {
+					results <- qr
+				}
+			}
+			if err := errFunc(); err != nil {
+				allErrors.RecordError(fmt.Errorf("streaming from %v failed: %v", ss.alias, err))
+			}
+		}(ss)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var replyErr error
+	for qr := range results {
+		// We still need to finish pumping
+		if replyErr != nil {
+			continue
+		}
+		replyErr = sendReply(qr)
+	}
+	if replyErr != nil {
+		allErrors.RecordError(replyErr)
+	}
+	return allErrors.Error()
+}
+
+// release rolls back the snapshot transactions and closes the
+// connections to the tablets.
+func (sr *SnapshotReader) release(context context.Context, snapshots []*shardSnapshot) {
+	for _, ss := range snapshots {
+		if ss.conn == nil {
+			continue
+		}
+		if ss.transactionId != 0 {
+			if err := ss.conn.Rollback(context, ss.transactionId); err != nil {
+				log.Warningf("Rollback of the snapshot on %v failed: %v", ss.alias, err)
+			}
+		}
+		ss.conn.Close()
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	blproto "github.com/youtube/vitess/go/vt/binlog/proto"
+	"github.com/youtube/vitess/go/vt/context"
+	"github.com/youtube/vitess/go/vt/key"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+// This file uses the sandbox_test framework.
+
+// fakeSnapshotTabletManager records the replication actions. The
+// masters are at position 10+uid, and the slaves stop one past the
+// position they're asked for.
+type fakeSnapshotTabletManager struct {
+	shards        map[string]*topo.Shard
+	mustFailStart bool
+	actions       []string
+}
+
+func (ftm *fakeSnapshotTabletManager) GetShard(keyspace, shard string) (*topo.ShardInfo, error) {
+	value, ok := ftm.shards[shard]
+	if !ok {
+		return nil, fmt.Errorf("no shard %v", shard)
+	}
+	return topo.NewShardInfo(keyspace, shard, value), nil
+}
+
+func (ftm *fakeSnapshotTabletManager) MasterPosition(tabletAlias topo.TabletAlias, waitTime time.Duration) (*myproto.ReplicationPosition, error) {
+	ftm.actions = append(ftm.actions, fmt.Sprintf("MasterPosition %v", tabletAlias.Uid))
+	return &myproto.ReplicationPosition{
+		MasterLogGTIDField: myproto.GTIDField{Value: myproto.GoogleGTID{GroupID: 10 + uint64(tabletAlias.Uid)}},
+	}, nil
+}
+
+func (ftm *fakeSnapshotTabletManager) StopSlaveMinimum(tabletAlias topo.TabletAlias, gtid myproto.GTID, waitTime time.Duration) (*myproto.ReplicationPosition, error) {
+	ftm.actions = append(ftm.actions, fmt.Sprintf("StopSlaveMinimum %v %v", tabletAlias.Uid, gtid))
+	return &myproto.ReplicationPosition{
+		MasterLogGTIDField: myproto.GTIDField{Value: myproto.GoogleGTID{GroupID: gtid.(myproto.GoogleGTID).GroupID + 1}},
+	}, nil
+}
+
+func (ftm *fakeSnapshotTabletManager) WaitBlpPosition(tabletAlias topo.TabletAlias, blpPosition blproto.BlpPosition, waitTime time.Duration) error {
+	ftm.actions = append(ftm.actions, fmt.Sprintf("WaitBlpPosition %v %v %v", tabletAlias.Uid, blpPosition.Uid, blpPosition.GTIDField))
+	return nil
+}
+
+func (ftm *fakeSnapshotTabletManager) StartSlave(tabletAlias topo.TabletAlias, waitTime time.Duration) error {
+	ftm.actions = append(ftm.actions, fmt.Sprintf("StartSlave %v", tabletAlias.Uid))
+	if ftm.mustFailStart {
+		return fmt.Errorf("start slave failed")
+	}
+	return nil
+}
+
+func TestSnapshotReaderStreamExecuteKeyRanges(t *testing.T) {
+	s := createSandbox("TestSnapshotReaderStreamExecuteKeyRanges")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("-20", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("20-40", sbc1)
+
+	// 20-40 replicates from -20, so it's stopped after it
+	ftm := &fakeSnapshotTabletManager{
+		shards: map[string]*topo.Shard{
+			"-20": &topo.Shard{MasterAlias: topo.TabletAlias{Cell: "aa", Uid: 100}},
+			"20-40": &topo.Shard{
+				MasterAlias: topo.TabletAlias{Cell: "aa", Uid: 101},
+				SourceShards: []topo.SourceShard{
+					{Uid: 1, Keyspace: "TestSnapshotReaderStreamExecuteKeyRanges", Shard: "-20"},
+				},
+			},
+		},
+	}
+	sr := NewSnapshotReader(new(sandboxTopo), ftm, "aa", 1*time.Millisecond)
+	kr, err := key.ParseKeyRangeParts("", "40")
+	if err != nil {
+		t.Fatalf("ParseKeyRangeParts failed: %v", err)
+	}
+	query := &proto.KeyRangeQuery{
+		Sql:        "query",
+		Keyspace:   "TestSnapshotReaderStreamExecuteKeyRanges",
+		KeyRanges:  []key.KeyRange{kr},
+		TabletType: topo.TYPE_RDONLY,
+	}
+	var qrs []*mproto.QueryResult
+	err = sr.StreamExecuteKeyRanges(&context.DummyContext{}, query, func(qr *mproto.QueryResult) error {
+		qrs = append(qrs, qr)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamExecuteKeyRanges failed: %v", err)
+	}
+	if len(qrs) != 2 {
+		t.Errorf("want 2 results, got %v", len(qrs))
+	}
+
+	wantActions := []string{
+		"MasterPosition 100",
+		"StopSlaveMinimum 0 110",
+		"WaitBlpPosition 101 1 111",
+		"MasterPosition 101",
+		"StopSlaveMinimum 1 111",
+		"StartSlave 0",
+		"StartSlave 1",
+	}
+	if !reflect.DeepEqual(wantActions, ftm.actions) {
+		t.Errorf("want actions\n%v, got\n%v", wantActions, ftm.actions)
+	}
+	for _, sbc := range []*sandboxConn{sbc0, sbc1} {
+		if sbc.BeginCount.Get() != 1 || sbc.RollbackCount.Get() != 1 || sbc.CloseCount.Get() != 1 {
+			t.Errorf("want 1 begin, rollback and close, got %v, %v and %v", sbc.BeginCount.Get(), sbc.RollbackCount.Get(), sbc.CloseCount.Get())
+		}
+	}
+}
+
+func TestSnapshotReaderErrors(t *testing.T) {
+	s := createSandbox("TestSnapshotReaderErrors")
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+	s.ShardSpec = "-"
+	ftm := &fakeSnapshotTabletManager{
+		shards: map[string]*topo.Shard{
+			"0": &topo.Shard{MasterAlias: topo.TabletAlias{Cell: "aa", Uid: 100}},
+		},
+	}
+	sr := NewSnapshotReader(new(sandboxTopo), ftm, "aa", 1*time.Millisecond)
+	query := &proto.KeyRangeQuery{
+		Sql:        "query",
+		Keyspace:   "TestSnapshotReaderErrors",
+		KeyRanges:  []key.KeyRange{key.KeyRange{}},
+		TabletType: topo.TYPE_REPLICA,
+	}
+	noReply := func(qr *mproto.QueryResult) error { return nil }
+
+	// only rdonly tablets can be used
+	err := sr.StreamExecuteKeyRanges(&context.DummyContext{}, query, noReply)
+	want := "consistent snapshots can only be read from rdonly tablets, not replica"
+	if err == nil || err.Error() != want {
+		t.Errorf("want %v, got %v", want, err)
+	}
+
+	// the replication is restarted, and the connection released,
+	// when the snapshot can't be started
+	query.TabletType = topo.TYPE_RDONLY
+	sbc.mustFailServer = 1
+	err = sr.StreamExecuteKeyRanges(&context.DummyContext{}, query, noReply)
+	want = "cannot begin snapshot on aa-0000000000: error: err"
+	if err == nil || err.Error() != want {
+		t.Errorf("want %v, got %v", want, err)
+	}
+	if len(ftm.actions) != 3 || ftm.actions[2] != "StartSlave 0" {
+		t.Errorf("want StartSlave as last action, got %v", ftm.actions)
+	}
+	if sbc.RollbackCount.Get() != 0 || sbc.CloseCount.Get() != 1 {
+		t.Errorf("want no rollback and 1 close, got %v and %v", sbc.RollbackCount.Get(), sbc.CloseCount.Get())
+	}
+
+	// a failed StartSlave doesn't fail the stream
+	ftm.actions = nil
+	ftm.mustFailStart = true
+	err = sr.StreamExecuteKeyRanges(&context.DummyContext{}, query, noReply)
+	if err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+
+	// missing shard record
+	delete(ftm.shards, "0")
+	err = sr.StreamExecuteKeyRanges(&context.DummyContext{}, query, noReply)
+	if err == nil || !strings.Contains(err.Error(), "cannot read shard") {
+		t.Errorf("want cannot read shard error, got %v", err)
+	}
+}
@@ -23,6 +23,7 @@ import (
 var (
 	srvTopoCacheTTL    = flag.Duration("srv_topo_cache_ttl", 1*time.Second, "how long to use cached entries for topology")
 	enableRemoteMaster = flag.Bool("enable_remote_master", false, "enable remote master access")
+	srvTopoWatch       = flag.Bool("srv_topo_watch", true, "watch the topology for SrvKeyspace and EndPoints changes, instead of only re-reading them after srv_topo_cache_ttl")
 )
 
 const (
@@ -47,10 +48,15 @@ type SrvTopoServer interface {
 // on a topo.Server that uses a cache for two purposes:
 // - limit the QPS to the underlying topo.Server
 // - return the last known value of the data if there is an error
+//
+// If the topo.Server supports it, SrvKeyspace and EndPoints entries
+// are also watched: they are updated as soon as they change in the
+// topology, and don't expire while the watch is working.
 type ResilientSrvTopoServer struct {
 	topoServer         topo.Server
 	cacheTTL           time.Duration
 	enableRemoteMaster bool
+	enableWatch        bool
 	counts             *stats.Counters
 
 	// mutex protects the cache map itself, not the individual
@@ -86,6 +92,9 @@ type srvKeyspaceEntry struct {
 	value            *topo.SrvKeyspace
 	lastError        error
 	lastErrorContext context.Context
+
+	// watched is true while value is kept up to date by a watch.
+	watched bool
 }
 
 type endPointsEntry struct {
@@ -107,6 +116,9 @@ type endPointsEntry struct {
 	originalValue    *topo.EndPoints
 	lastError        error
 	lastErrorContext context.Context
+
+	// watched is true while value is kept up to date by a watch.
+	watched bool
 }
 
 // filterUnhealthyServers removes the unhealthy servers from the list,
@@ -139,11 +151,19 @@ func filterUnhealthyServers(endPoints *topo.EndPoints) *topo.EndPoints {
 
 // NewResilientSrvTopoServer creates a new ResilientSrvTopoServer
 // based on the provided SrvTopoServer.
+// If base can't watch, like the zkocc one, the SrvKeyspace and EndPoints
+// changes are only seen after srv_topo_cache_ttl.
 func NewResilientSrvTopoServer(base topo.Server, counterName string) *ResilientSrvTopoServer {
+	enableWatch := *srvTopoWatch
+	if enableWatch && !topo.CanWatch(base) {
+		log.Warningf("the topology server can't watch (zkocc?), SrvKeyspace and EndPoints changes will only be seen after srv_topo_cache_ttl (%v)", *srvTopoCacheTTL)
+		enableWatch = false
+	}
 	return &ResilientSrvTopoServer{
 		topoServer:         base,
 		cacheTTL:           *srvTopoCacheTTL,
 		enableRemoteMaster: *enableRemoteMaster,
+		enableWatch:        enableWatch,
 		counts:             stats.NewCounters(counterName),
 
 		srvKeyspaceNamesCache: make(map[string]*srvKeyspaceNamesEntry),
@@ -216,6 +236,9 @@ func (server *ResilientSrvTopoServer) GetSrvKeyspace(context context.Context, ce
 		server.srvKeyspaceCache[key] = entry
 	}
 	server.mutex.Unlock()
+	if !ok && server.enableWatch {
+		server.watchSrvKeyspace(entry)
+	}
 
 	// Lock the entry, and do everything holding the lock.  This
 	// means two concurrent requests will only issue one
@@ -223,8 +246,8 @@ func (server *ResilientSrvTopoServer) GetSrvKeyspace(context context.Context, ce
 	entry.mutex.Lock()
 	defer entry.mutex.Unlock()
 
-	// If the entry is fresh enough, return it
-	if time.Now().Sub(entry.insertionTime) < server.cacheTTL {
+	// If the entry is watched or fresh enough, return it
+	if entry.watched || time.Now().Sub(entry.insertionTime) < server.cacheTTL {
 		return entry.value, entry.lastError
 	}
 
@@ -267,6 +290,9 @@ func (server *ResilientSrvTopoServer) GetEndPoints(context context.Context, cell
 		server.endPointsCache[key] = entry
 	}
 	server.mutex.Unlock()
+	if !ok && server.enableWatch {
+		server.watchEndPoints(entry)
+	}
 
 	// Lock the entry, and do everything holding the lock.  This
 	// means two concurrent requests will only issue one
@@ -274,8 +300,8 @@ func (server *ResilientSrvTopoServer) GetEndPoints(context context.Context, cell
 	entry.mutex.Lock()
 	defer entry.mutex.Unlock()
 
-	// If the entry is fresh enough, return it
-	if time.Now().Sub(entry.insertionTime) < server.cacheTTL {
+	// If the entry is watched or fresh enough, return it
+	if entry.watched || time.Now().Sub(entry.insertionTime) < server.cacheTTL {
 		return entry.value, entry.lastError
 	}
 
@@ -317,6 +343,80 @@ func (server *ResilientSrvTopoServer) GetEndPoints(context context.Context, cell
 	return entry.value, err
 }
 
+// watchSrvKeyspace starts watching the SrvKeyspace for entry, and
+// keeps entry up to date with it. If the topo.Server can't watch,
+// entry is only refreshed after cacheTTL.
+func (server *ResilientSrvTopoServer) watchSrvKeyspace(entry *srvKeyspaceEntry) {
+	// The cache lives as long as the process, so we never stop watching.
+	notifications, err := server.topoServer.WatchSrvKeyspace(entry.cell, entry.keyspace, nil)
+	if err != nil {
+		log.Infof("WatchSrvKeyspace(%v, %v) failed, will use cache TTL: %v", entry.cell, entry.keyspace, err)
+		return
+	}
+	go func() {
+		for value := range notifications {
+			entry.mutex.Lock()
+			if value == nil {
+				// The SrvKeyspace doesn't exist, let the next
+				// call read it and handle the error.
+				entry.watched = false
+				if !entry.insertionTime.IsZero() {
+					entry.insertionTime = time.Now().Add(-server.cacheTTL)
+				}
+			} else {
+				entry.watched = true
+				entry.insertionTime = time.Now()
+				entry.value = value
+				entry.lastError = nil
+				entry.lastErrorContext = nil
+			}
+			entry.mutex.Unlock()
+		}
+
+		entry.mutex.Lock()
+		entry.watched = false
+		entry.mutex.Unlock()
+	}()
+}
+
+// watchEndPoints starts watching the EndPoints for entry, and keeps
+// entry up to date with it. If the topo.Server can't watch, entry is
+// only refreshed after cacheTTL.
+func (server *ResilientSrvTopoServer) watchEndPoints(entry *endPointsEntry) {
+	// The cache lives as long as the process, so we never stop watching.
+	notifications, err := server.topoServer.WatchEndPoints(entry.cell, entry.keyspace, entry.shard, entry.tabletType, nil)
+	if err != nil {
+		log.Infof("WatchEndPoints(%v, %v, %v, %v) failed, will use cache TTL: %v", entry.cell, entry.keyspace, entry.shard, entry.tabletType, err)
+		return
+	}
+	go func() {
+		for value := range notifications {
+			entry.mutex.Lock()
+			if value == nil {
+				// The EndPoints don't exist in this cell, let
+				// the next call read them and handle the error
+				// (or find a remote master).
+				entry.watched = false
+				if !entry.insertionTime.IsZero() {
+					entry.insertionTime = time.Now().Add(-server.cacheTTL)
+				}
+			} else {
+				entry.watched = true
+				entry.insertionTime = time.Now()
+				entry.originalValue = value
+				entry.value = filterUnhealthyServers(value)
+				entry.lastError = nil
+				entry.lastErrorContext = nil
+			}
+			entry.mutex.Unlock()
+		}
+
+		entry.mutex.Lock()
+		entry.watched = false
+		entry.mutex.Unlock()
+	}()
+}
+
 // EndpointCount returns how many endpoints we have per keyspace/shard/dbtype.
 func (server *ResilientSrvTopoServer) EndpointCount() map[string]int64 {
 	result := make(map[string]int64)
@@ -12,7 +12,9 @@ import (
 
 	"github.com/youtube/vitess/go/vt/context"
 	"github.com/youtube/vitess/go/vt/health"
+	"github.com/youtube/vitess/go/vt/memorytopo"
 	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/zktopo"
 )
 
 func TestFilterUnhealthy(t *testing.T) {
@@ -217,6 +219,8 @@ func (ft *fakeTopo) UpdateKeyspace(ki *topo.KeyspaceInfo) error
 func (ft *fakeTopo) GetKeyspace(keyspace string) (*topo.KeyspaceInfo, error)     { return nil, nil }
 func (ft *fakeTopo) GetKeyspaces() ([]string, error)                             { return nil, nil }
 func (ft *fakeTopo) DeleteKeyspaceShards(keyspace string) error                  { return nil }
+func (ft *fakeTopo) SaveVSchema(vschema string) error                            { return nil }
+func (ft *fakeTopo) GetVSchema() (string, error)                                 { return "", topo.ErrNoNode }
 func (ft *fakeTopo) CreateShard(keyspace, shard string, value *topo.Shard) error { return nil }
 func (ft *fakeTopo) UpdateShard(si *topo.ShardInfo) error                        { return nil }
 func (ft *fakeTopo) ValidateShard(keyspace, shard string) error                  { return nil }
@@ -226,6 +230,15 @@ func (ft *fakeTopo) GetShardCritical(keyspace, shard string) (si *topo.ShardInfo
 }
 func (ft *fakeTopo) GetShardNames(keyspace string) ([]string, error) { return nil, nil }
 func (ft *fakeTopo) DeleteShard(keyspace, shard string) error        { return nil }
+func (ft *fakeTopo) SaveQueryRules(keyspace, shard, rules string) error {
+	return nil
+}
+func (ft *fakeTopo) GetQueryRules(keyspace, shard string) (string, error) {
+	return "", topo.ErrNoNode
+}
+func (ft *fakeTopo) WatchQueryRules(keyspace, shard string, stopWatching chan struct{}) (<-chan string, error) {
+	return nil, nil
+}
 func (ft *fakeTopo) CreateTablet(tablet *topo.Tablet) error          { return nil }
 func (ft *fakeTopo) UpdateTablet(tablet *topo.TabletInfo, existingVersion int64) (newVersion int64, err error) {
 	return 0, nil
@@ -270,6 +283,12 @@ func (ft *fakeTopo) UpdateSrvKeyspace(cell, keyspace string, srvKeyspace *topo.S
 func (ft *fakeTopo) UpdateTabletEndpoint(cell, keyspace, shard string, tabletType topo.TabletType, addr *topo.EndPoint) error {
 	return nil
 }
+func (ft *fakeTopo) WatchEndPoints(cell, keyspace, shard string, tabletType topo.TabletType, stopWatching chan struct{}) (<-chan *topo.EndPoints, error) {
+	return nil, fmt.Errorf("not supported")
+}
+func (ft *fakeTopo) WatchSrvKeyspace(cell, keyspace string, stopWatching chan struct{}) (<-chan *topo.SrvKeyspace, error) {
+	return nil, fmt.Errorf("not supported")
+}
 func (ft *fakeTopo) LockKeyspaceForAction(keyspace, contents string, timeout time.Duration, interrupted chan struct{}) (string, error) {
 	return "", nil
 }
@@ -278,6 +297,13 @@ func (ft *fakeTopo) LockShardForAction(keyspace, shard, contents string, timeout
 	return "", nil
 }
 func (ft *fakeTopo) UnlockShardForAction(keyspace, shard, lockPath, results string) error { return nil }
+func (ft *fakeTopo) LockShardForElection(keyspace, shard, contents string, timeout time.Duration, interrupted chan struct{}) (string, error) {
+	return "", nil
+}
+func (ft *fakeTopo) UnlockShardForElection(keyspace, shard, lockPath string) error { return nil }
+func (ft *fakeTopo) GetShardElectionLeader(keyspace, shard string) (string, error) {
+	return "", nil
+}
 func (ft *fakeTopo) WriteTabletAction(tabletAlias topo.TabletAlias, contents string) (string, error) {
 	return "", nil
 }
@@ -441,3 +467,116 @@ func TestCachedErrors(t *testing.T) {
 		t.Fatalf("GetSrvKeyspace was not called again: %v times", ft.callCount)
 	}
 }
+
+// TestWatch will test we pick up changes right away when the
+// topo.Server supports watches, even with a long cache TTL.
+func TestWatch(t *testing.T) {
+	ts := memorytopo.NewTestServer(t, []string{"cell1"})
+	rsts := NewResilientSrvTopoServer(ts, "TestWatch")
+	rsts.cacheTTL = time.Hour
+	rsts.enableWatch = true
+
+	if err := ts.UpdateSrvKeyspace("cell1", "test_ks", &topo.SrvKeyspace{ShardingColumnName: "col1"}); err != nil {
+		t.Fatalf("UpdateSrvKeyspace failed: %v", err)
+	}
+	if err := ts.UpdateEndPoints("cell1", "test_ks", "0", topo.TYPE_REPLICA, &topo.EndPoints{Entries: []topo.EndPoint{topo.EndPoint{Uid: 1}}}); err != nil {
+		t.Fatalf("UpdateEndPoints failed: %v", err)
+	}
+
+	// populate the cache
+	if ks, err := rsts.GetSrvKeyspace(&context.DummyContext{}, "cell1", "test_ks"); err != nil || ks.ShardingColumnName != "col1" {
+		t.Fatalf("GetSrvKeyspace got unexpected result: %v %v", ks, err)
+	}
+	if ep, err := rsts.GetEndPoints(&context.DummyContext{}, "cell1", "test_ks", "0", topo.TYPE_REPLICA); err != nil || len(ep.Entries) != 1 {
+		t.Fatalf("GetEndPoints got unexpected result: %v %v", ep, err)
+	}
+
+	// change the values, they should be picked up quickly
+	if err := ts.UpdateSrvKeyspace("cell1", "test_ks", &topo.SrvKeyspace{ShardingColumnName: "col2"}); err != nil {
+		t.Fatalf("UpdateSrvKeyspace failed: %v", err)
+	}
+	if err := ts.UpdateEndPoints("cell1", "test_ks", "0", topo.TYPE_REPLICA, &topo.EndPoints{Entries: []topo.EndPoint{topo.EndPoint{Uid: 1}, topo.EndPoint{Uid: 2}}}); err != nil {
+		t.Fatalf("UpdateEndPoints failed: %v", err)
+	}
+	timeout := time.Now().Add(time.Second)
+	for {
+		ks, ksErr := rsts.GetSrvKeyspace(&context.DummyContext{}, "cell1", "test_ks")
+		ep, epErr := rsts.GetEndPoints(&context.DummyContext{}, "cell1", "test_ks", "0", topo.TYPE_REPLICA)
+		if ksErr == nil && ks.ShardingColumnName == "col2" && epErr == nil && len(ep.Entries) == 2 {
+			break
+		}
+		if time.Now().After(timeout) {
+			t.Fatalf("changes were not picked up: %v %v %v %v", ks, ksErr, ep, epErr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// delete the endpoints, the entry should expire and return an error
+	if err := ts.DeleteEndPoints("cell1", "test_ks", "0", topo.TYPE_REPLICA); err != nil {
+		t.Fatalf("DeleteEndPoints failed: %v", err)
+	}
+	timeout = time.Now().Add(time.Second)
+	for {
+		// the cached value is returned on error, but the
+		// count shows the underlying server was asked again
+		rsts.GetEndPoints(&context.DummyContext{}, "cell1", "test_ks", "0", topo.TYPE_REPLICA)
+		if rsts.counts.Counts()[cachedCategory] > 0 {
+			break
+		}
+		if time.Now().After(timeout) {
+			t.Fatalf("deletion was not picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestWatchNotSupported will test we fall back to the cache TTL when
+// the topo.Server can't watch, like zkocc.
+func TestWatchNotSupported(t *testing.T) {
+	ts := zktopo.NewTestServerWithoutWatches(t, []string{"cell1"})
+	rsts := NewResilientSrvTopoServer(ts, "TestWatchNotSupported")
+	if rsts.enableWatch {
+		t.Errorf("NewResilientSrvTopoServer enabled the watches on a topo.Server that can't watch")
+	}
+
+	// the watches fail if they're started anyway
+	rsts.cacheTTL = time.Hour
+	rsts.enableWatch = true
+
+	if err := ts.UpdateSrvKeyspace("cell1", "test_ks", &topo.SrvKeyspace{ShardingColumnName: "col1"}); err != nil {
+		t.Fatalf("UpdateSrvKeyspace failed: %v", err)
+	}
+	if err := ts.UpdateEndPoints("cell1", "test_ks", "0", topo.TYPE_REPLICA, &topo.EndPoints{Entries: []topo.EndPoint{topo.EndPoint{Uid: 1}}}); err != nil {
+		t.Fatalf("UpdateEndPoints failed: %v", err)
+	}
+
+	// populate the cache, the entries are not watched
+	if ks, err := rsts.GetSrvKeyspace(&context.DummyContext{}, "cell1", "test_ks"); err != nil || ks.ShardingColumnName != "col1" {
+		t.Fatalf("GetSrvKeyspace got unexpected result: %v %v", ks, err)
+	}
+	if ep, err := rsts.GetEndPoints(&context.DummyContext{}, "cell1", "test_ks", "0", topo.TYPE_REPLICA); err != nil || len(ep.Entries) != 1 {
+		t.Fatalf("GetEndPoints got unexpected result: %v %v", ep, err)
+	}
+	for key, entry := range rsts.srvKeyspaceCache {
+		if entry.watched {
+			t.Errorf("SrvKeyspace entry %v should not be watched", key)
+		}
+	}
+	for key, entry := range rsts.endPointsCache {
+		if entry.watched {
+			t.Errorf("EndPoints entry %v should not be watched", key)
+		}
+	}
+
+	// change the values, they're picked up after the cache TTL
+	if err := ts.UpdateSrvKeyspace("cell1", "test_ks", &topo.SrvKeyspace{ShardingColumnName: "col2"}); err != nil {
+		t.Fatalf("UpdateSrvKeyspace failed: %v", err)
+	}
+	if ks, err := rsts.GetSrvKeyspace(&context.DummyContext{}, "cell1", "test_ks"); err != nil || ks.ShardingColumnName != "col1" {
+		t.Fatalf("GetSrvKeyspace should use the cache: %v %v", ks, err)
+	}
+	rsts.cacheTTL = 0
+	if ks, err := rsts.GetSrvKeyspace(&context.DummyContext{}, "cell1", "test_ks"); err != nil || ks.ShardingColumnName != "col2" {
+		t.Fatalf("GetSrvKeyspace got unexpected result: %v %v", ks, err)
+	}
+}
@@ -7,14 +7,17 @@
 package vtgate
 
 import (
+	"fmt"
 	"strings"
 	"time"
 
 	log "github.com/golang/glog"
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/trace"
 	"github.com/youtube/vitess/go/vt/context"
 	"github.com/youtube/vitess/go/vt/logutil"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
 )
 
@@ -36,11 +39,14 @@ var (
 // can be created.
 type VTGate struct {
 	resolver   *Resolver
+	router     *Router
+	snapshots  *SnapshotReader
 	timings    *stats.MultiTimings
 	errors     *stats.MultiCounters
 	infoErrors *stats.Counters
 
 	// the throttled loggers for all errors, one per API entry
+	logExecuteSQL               *logutil.ThrottledLogger
 	logExecuteShard             *logutil.ThrottledLogger
 	logExecuteKeyspaceIds       *logutil.ThrottledLogger
 	logExecuteKeyRanges         *logutil.ThrottledLogger
@@ -50,6 +56,13 @@ type VTGate struct {
 	logStreamExecuteKeyspaceIds *logutil.ThrottledLogger
 	logStreamExecuteKeyRanges   *logutil.ThrottledLogger
 	logStreamExecuteShard       *logutil.ThrottledLogger
+	logStreamExecuteSnapshot    *logutil.ThrottledLogger
+}
+
+// SetVSchema replaces the VSchema used to route the ExecuteSQL
+// queries.
+func (vtg *VTGate) SetVSchema(vschema *planbuilder.VSchema) {
+	vtg.router.SetVSchema(vschema)
 }
 
 // registration mechanism
@@ -57,16 +70,25 @@ type RegisterVTGate func(*VTGate)
 
 var RegisterVTGates []RegisterVTGate
 
-func Init(serv SrvTopoServer, cell string, retryDelay time.Duration, retryCount int, timeout time.Duration) {
+// Init creates the RpcVTGate. snapshotTM is used to align the
+// replication of the rdonly tablets for the snapshot reads, which
+// are disabled if it is nil.
+func Init(serv SrvTopoServer, snapshotTM SnapshotTabletManager, vschema *planbuilder.VSchema, cell string, retryDelay time.Duration, retryCount int, timeout time.Duration) {
 	if RpcVTGate != nil {
 		log.Fatalf("VTGate already initialized")
 	}
+	if vschema == nil {
+		vschema = &planbuilder.VSchema{Keyspaces: make(map[string]*planbuilder.Keyspace)}
+	}
+	resolver := NewResolver(serv, "VttabletCall", cell, retryDelay, retryCount, timeout)
 	RpcVTGate = &VTGate{
-		resolver:   NewResolver(serv, "VttabletCall", cell, retryDelay, retryCount, timeout),
+		resolver:   resolver,
+		router:     NewRouter(serv, cell, vschema, resolver),
 		timings:    stats.NewMultiTimings("VtgateApi", []string{"Operation", "Keyspace", "DbType"}),
 		errors:     stats.NewMultiCounters("VtgateApiErrorCounts", []string{"Operation", "Keyspace", "DbType"}),
 		infoErrors: stats.NewCounters("VtgateInfoErrorCounts"),
 
+		logExecuteSQL:               logutil.NewThrottledLogger("ExecuteSQL", 5*time.Second),
 		logExecuteShard:             logutil.NewThrottledLogger("ExecuteShard", 5*time.Second),
 		logExecuteKeyspaceIds:       logutil.NewThrottledLogger("ExecuteKeyspaceIds", 5*time.Second),
 		logExecuteKeyRanges:         logutil.NewThrottledLogger("ExecuteKeyRanges", 5*time.Second),
@@ -76,6 +98,10 @@ func Init(serv SrvTopoServer, cell string, retryDelay time.Duration, retryCount
 		logStreamExecuteKeyspaceIds: logutil.NewThrottledLogger("StreamExecuteKeyspaceIds", 5*time.Second),
 		logStreamExecuteKeyRanges:   logutil.NewThrottledLogger("StreamExecuteKeyRanges", 5*time.Second),
 		logStreamExecuteShard:       logutil.NewThrottledLogger("StreamExecuteShard", 5*time.Second),
+		logStreamExecuteSnapshot:    logutil.NewThrottledLogger("StreamExecuteKeyRangesSnapshot", 5*time.Second),
+	}
+	if snapshotTM != nil {
+		RpcVTGate.snapshots = NewSnapshotReader(serv, snapshotTM, cell, timeout)
 	}
 	QPSByOperation = stats.NewRates("QPSByOperation", stats.CounterForDimension(RpcVTGate.timings, "Operation"), 15, 1*time.Minute)
 	QPSByKeyspace = stats.NewRates("QPSByKeyspace", stats.CounterForDimension(RpcVTGate.timings, "Keyspace"), 15, 1*time.Minute)
@@ -90,8 +116,35 @@ func Init(serv SrvTopoServer, cell string, retryDelay time.Duration, retryCount
 	}
 }
 
+// ExecuteSQL executes a non-streaming query, routed to the right
+// shards using the VSchema.
+func (vtg *VTGate) ExecuteSQL(context context.Context, query *proto.Query, reply *proto.QueryResult) error {
+	span, context := startTrace(context, "VTGate.ExecuteSQL")
+	defer span.Finish()
+	startTime := time.Now()
+	statsKey := []string{"ExecuteSQL", query.Keyspace, string(query.TabletType)}
+	defer vtg.timings.Record(statsKey, startTime)
+
+	qr, err := vtg.router.Execute(context, query)
+	if err == nil {
+		reply.Result = qr
+	} else {
+		reply.Error = err.Error()
+		if strings.Contains(reply.Error, errDupKey) {
+			vtg.infoErrors.Add("DupKey", 1)
+		} else {
+			vtg.errors.Add(statsKey, 1)
+			vtg.logExecuteSQL.Errorf("%v, query: %+v", err, query)
+		}
+	}
+	reply.Session = query.Session
+	return nil
+}
+
 // ExecuteShard executes a non-streaming query on the specified shards.
 func (vtg *VTGate) ExecuteShard(context context.Context, query *proto.QueryShard, reply *proto.QueryResult) error {
+	span, context := startTrace(context, "VTGate.ExecuteShard")
+	defer span.Finish()
 	startTime := time.Now()
 	statsKey := []string{"ExecuteShard", query.Keyspace, string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -124,6 +177,8 @@ func (vtg *VTGate) ExecuteShard(context context.Context, query *proto.QueryShard
 
 // ExecuteKeyspaceIds executes a non-streaming query based on the specified keyspace ids.
 func (vtg *VTGate) ExecuteKeyspaceIds(context context.Context, query *proto.KeyspaceIdQuery, reply *proto.QueryResult) error {
+	span, context := startTrace(context, "VTGate.ExecuteKeyspaceIds")
+	defer span.Finish()
 	startTime := time.Now()
 	statsKey := []string{"ExecuteKeyspaceIds", query.Keyspace, string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -146,6 +201,8 @@ func (vtg *VTGate) ExecuteKeyspaceIds(context context.Context, query *proto.Keys
 
 // ExecuteKeyRanges executes a non-streaming query based on the specified keyranges.
 func (vtg *VTGate) ExecuteKeyRanges(context context.Context, query *proto.KeyRangeQuery, reply *proto.QueryResult) error {
+	span, context := startTrace(context, "VTGate.ExecuteKeyRanges")
+	defer span.Finish()
 	startTime := time.Now()
 	statsKey := []string{"ExecuteKeyRanges", query.Keyspace, string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -168,6 +225,8 @@ func (vtg *VTGate) ExecuteKeyRanges(context context.Context, query *proto.KeyRan
 
 // ExecuteEntityIds excutes a non-streaming query based on given KeyspaceId map.
 func (vtg *VTGate) ExecuteEntityIds(context context.Context, query *proto.EntityIdsQuery, reply *proto.QueryResult) error {
+	span, context := startTrace(context, "VTGate.ExecuteEntityIds")
+	defer span.Finish()
 	startTime := time.Now()
 	statsKey := []string{"ExecuteEntityIds", query.Keyspace, string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -190,6 +249,8 @@ func (vtg *VTGate) ExecuteEntityIds(context context.Context, query *proto.Entity
 
 // ExecuteBatchShard executes a group of queries on the specified shards.
 func (vtg *VTGate) ExecuteBatchShard(context context.Context, batchQuery *proto.BatchQueryShard, reply *proto.QueryResultList) error {
+	span, context := startTrace(context, "VTGate.ExecuteBatchShard")
+	defer span.Finish()
 	startTime := time.Now()
 	statsKey := []string{"ExecuteBatchShard", batchQuery.Keyspace, string(batchQuery.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -221,6 +282,8 @@ func (vtg *VTGate) ExecuteBatchShard(context context.Context, batchQuery *proto.
 
 // ExecuteBatchKeyspaceIds executes a group of queries based on the specified keyspace ids.
 func (vtg *VTGate) ExecuteBatchKeyspaceIds(context context.Context, query *proto.KeyspaceIdBatchQuery, reply *proto.QueryResultList) error {
+	span, context := startTrace(context, "VTGate.ExecuteBatchKeyspaceIds")
+	defer span.Finish()
 	startTime := time.Now()
 	statsKey := []string{"ExecuteBatchKeyspaceIds", query.Keyspace, string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -250,6 +313,8 @@ func (vtg *VTGate) ExecuteBatchKeyspaceIds(context context.Context, query *proto
 // response which is needed for checkpointing.
 // The api supports supplying multiple KeyspaceIds to make it future proof.
 func (vtg *VTGate) StreamExecuteKeyspaceIds(context context.Context, query *proto.KeyspaceIdQuery, sendReply func(*proto.QueryResult) error) error {
+	span, context := startTrace(context, "VTGate.StreamExecuteKeyspaceIds")
+	defer span.Finish()
 	startTime := time.Now()
 	statsKey := []string{"StreamExecuteKeyspaceIds", query.Keyspace, string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -282,6 +347,8 @@ func (vtg *VTGate) StreamExecuteKeyspaceIds(context context.Context, query *prot
 // response which is needed for checkpointing.
 // The api supports supplying multiple keyranges to make it future proof.
 func (vtg *VTGate) StreamExecuteKeyRanges(context context.Context, query *proto.KeyRangeQuery, sendReply func(*proto.QueryResult) error) error {
+	span, context := startTrace(context, "VTGate.StreamExecuteKeyRanges")
+	defer span.Finish()
 	startTime := time.Now()
 	statsKey := []string{"StreamExecuteKeyRanges", query.Keyspace, string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -308,8 +375,39 @@ func (vtg *VTGate) StreamExecuteKeyRanges(context context.Context, query *proto.
 	return err
 }
 
+// StreamExecuteKeyRangesSnapshot executes a streaming query on the
+// specified KeyRanges, reading from consistent snapshots of the
+// rdonly tablets of the shards. See SnapshotReader.
+func (vtg *VTGate) StreamExecuteKeyRangesSnapshot(context context.Context, query *proto.KeyRangeQuery, sendReply func(*proto.QueryResult) error) error {
+	span, context := startTrace(context, "VTGate.StreamExecuteKeyRangesSnapshot")
+	defer span.Finish()
+	startTime := time.Now()
+	statsKey := []string{"StreamExecuteKeyRangesSnapshot", query.Keyspace, string(query.TabletType)}
+	defer vtg.timings.Record(statsKey, startTime)
+
+	if vtg.snapshots == nil {
+		return fmt.Errorf("snapshot reads are not enabled")
+	}
+	err := vtg.snapshots.StreamExecuteKeyRanges(
+		context,
+		query,
+		func(mreply *mproto.QueryResult) error {
+			reply := new(proto.QueryResult)
+			reply.Result = mreply
+			return sendReply(reply)
+		})
+
+	if err != nil {
+		vtg.errors.Add(statsKey, 1)
+		vtg.logStreamExecuteSnapshot.Errorf("%v, query: %+v", err, query)
+	}
+	return err
+}
+
 // StreamExecuteShard executes a streaming query on the specified shards.
 func (vtg *VTGate) StreamExecuteShard(context context.Context, query *proto.QueryShard, sendReply func(*proto.QueryResult) error) error {
+	span, context := startTrace(context, "VTGate.StreamExecuteShard")
+	defer span.Finish()
 	startTime := time.Now()
 	statsKey := []string{"StreamExecuteShard", query.Keyspace, string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -343,6 +441,37 @@ func (vtg *VTGate) StreamExecuteShard(context context.Context, query *proto.Quer
 	return err
 }
 
+// ExplainShard describes how the vttablet of the shard would execute
+// the query. Exactly one shard must be specified.
+func (vtg *VTGate) ExplainShard(context context.Context, query *proto.QueryShard, reply *proto.QueryExplanationResult) error {
+	span, context := startTrace(context, "VTGate.ExplainShard")
+	defer span.Finish()
+	startTime := time.Now()
+	statsKey := []string{"ExplainShard", query.Keyspace, string(query.TabletType)}
+	defer vtg.timings.Record(statsKey, startTime)
+
+	if len(query.Shards) != 1 {
+		reply.Error = fmt.Sprintf("ExplainShard needs exactly one shard, got %v", query.Shards)
+		vtg.errors.Add(statsKey, 1)
+		return nil
+	}
+	explanation, err := vtg.resolver.scatterConn.ExplainQuery(
+		context,
+		query.Sql,
+		query.BindVariables,
+		query.Keyspace,
+		query.Shards[0],
+		query.TabletType,
+	)
+	if err == nil {
+		reply.Explanation = explanation
+	} else {
+		reply.Error = err.Error()
+		vtg.errors.Add(statsKey, 1)
+	}
+	return nil
+}
+
 // Begin begins a transaction. It has to be concluded by a Commit or Rollback.
 func (vtg *VTGate) Begin(context context.Context, outSession *proto.Session) error {
 	outSession.InTransaction = true
@@ -351,10 +480,28 @@ func (vtg *VTGate) Begin(context context.Context, outSession *proto.Session) err
 
 // Commit commits a transaction.
 func (vtg *VTGate) Commit(context context.Context, inSession *proto.Session) error {
+	span, context := startTrace(context, "VTGate.Commit")
+	defer span.Finish()
 	return vtg.resolver.Commit(context, inSession)
 }
 
 // Rollback rolls back a transaction.
 func (vtg *VTGate) Rollback(context context.Context, inSession *proto.Session) error {
+	span, context := startTrace(context, "VTGate.Rollback")
+	defer span.Finish()
 	return vtg.resolver.Rollback(context, inSession)
 }
+
+// startTrace starts a new trace for a call, if it is sampled and the
+// client doesn't already trace it. It returns the root span, or nil,
+// and the context to use for the call.
+func startTrace(ctx context.Context, name string) (*trace.Span, context.Context) {
+	if context.Span(ctx) != nil {
+		return nil, ctx
+	}
+	span := trace.StartTrace(name)
+	if span == nil {
+		return nil, ctx
+	}
+	return span, context.WithSpan(ctx, span)
+}
@@ -19,7 +19,24 @@ import (
 // This file uses the sandbox_test framework.
 
 func init() {
-	Init(new(sandboxTopo), "aa", 1*time.Second, 10, 1*time.Millisecond)
+	Init(new(sandboxTopo), nil, nil, "aa", 1*time.Second, 10, 1*time.Millisecond)
+}
+
+func TestVTGateExecuteSQL(t *testing.T) {
+	q := proto.Query{
+		Sql:        "select * from user",
+		Keyspace:   "TestVTGateExecuteSQL",
+		TabletType: topo.TYPE_MASTER,
+	}
+	qr := new(proto.QueryResult)
+	err := RpcVTGate.ExecuteSQL(&context.DummyContext{}, &q, qr)
+	if err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	want := "cannot route query: select * from user: keyspace TestVTGateExecuteSQL is not in the vschema"
+	if qr.Error != want {
+		t.Errorf("want %v, got %v", want, qr.Error)
+	}
 }
 
 func TestVTGateExecuteShard(t *testing.T) {
@@ -81,6 +98,36 @@ func TestVTGateExecuteShard(t *testing.T) {
 	*/
 }
 
+func TestVTGateExplainShard(t *testing.T) {
+	sandbox := createSandbox("TestVTGateExplainShard")
+	sbc := &sandboxConn{}
+	sandbox.MapTestConn("0", sbc)
+	q := proto.QueryShard{
+		Sql:      "query",
+		Keyspace: "TestVTGateExplainShard",
+		Shards:   []string{"0"},
+	}
+	reply := new(proto.QueryExplanationResult)
+	err := RpcVTGate.ExplainShard(&context.DummyContext{}, &q, reply)
+	if err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	want := &proto.QueryExplanationResult{
+		Explanation: &tproto.QueryExplanation{PlanId: "PASS_SELECT", Reason: "DEFAULT", Queries: []string{"query"}},
+	}
+	if !reflect.DeepEqual(want, reply) {
+		t.Errorf("want \n%+v, got \n%+v", want, reply)
+	}
+
+	q.Shards = []string{"0", "1"}
+	reply = new(proto.QueryExplanationResult)
+	RpcVTGate.ExplainShard(&context.DummyContext{}, &q, reply)
+	wantErr := "ExplainShard needs exactly one shard, got [0 1]"
+	if reply.Error != wantErr {
+		t.Errorf("want %v, got %v", wantErr, reply.Error)
+	}
+}
+
 func TestVTGateExecuteKeyspaceIds(t *testing.T) {
 	s := createSandbox("TestVTGateExecuteKeyspaceIds")
 	sbc1 := &sandboxConn{}
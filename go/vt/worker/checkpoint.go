@@ -0,0 +1,395 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/sqltypes"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/wrangler"
+)
+
+// cloneCheckpoint persists the progress of a clone job in the _vt
+// database of the destination tablets, so a job that was interrupted
+// can be resumed without copying again the chunks that are done.
+//
+// The _vt.worker_job table has a single row describing the job: the
+// worker that runs it, the source it copies from, the tablet it reads
+// and the replication position of that tablet when the job was
+// started. The
+// _vt.worker_checkpoint table has one row per chunk of each table,
+// with the chunk boundaries and a 'done' flag. The _vt.worker_step
+// table has one row per setup step of the job that was completed.
+type cloneCheckpoint struct {
+	wr             *wrangler.Wrangler
+	worker         string
+	source         string
+	tablets        []*topo.TabletInfo
+	disableBinlogs bool
+
+	// sourceTablet is the tablet the job reads, and gtid its
+	// position when the job was started
+	sourceTablet topo.TabletAlias
+	gtid         myproto.GTID
+
+	// mu protects chunks, done and steps
+	mu     sync.Mutex
+	chunks map[string][]string
+	done   map[string]map[int]bool
+	steps  map[string]bool
+}
+
+// newCloneCheckpoint returns a cloneCheckpoint for the given worker
+// and source, stored on all the provided destination tablets.
+func newCloneCheckpoint(wr *wrangler.Wrangler, worker, source string, tablets []*topo.TabletInfo, disableBinlogs bool) *cloneCheckpoint {
+	return &cloneCheckpoint{
+		wr:             wr,
+		worker:         worker,
+		source:         source,
+		tablets:        tablets,
+		disableBinlogs: disableBinlogs,
+		chunks:         make(map[string][]string),
+		done:           make(map[string]map[int]bool),
+		steps:          make(map[string]bool),
+	}
+}
+
+// createCheckpointTables returns the statements required to create
+// the _vt.worker_job, _vt.worker_checkpoint and _vt.worker_step tables.
+func createCheckpointTables() []string {
+	return []string{
+		"CREATE DATABASE IF NOT EXISTS _vt",
+		`CREATE TABLE IF NOT EXISTS _vt.worker_job (
+  id INT(10) UNSIGNED NOT NULL,
+  worker VARBINARY(250) NOT NULL,
+  source VARBINARY(250) NOT NULL,
+  source_tablet VARBINARY(250) NOT NULL,
+  gtid VARCHAR(250) NOT NULL,
+  time_created BIGINT UNSIGNED NOT NULL,
+  PRIMARY KEY (id)) ENGINE=InnoDB`,
+		`CREATE TABLE IF NOT EXISTS _vt.worker_checkpoint (
+  table_name VARBINARY(250) NOT NULL,
+  chunk_index INT(10) UNSIGNED NOT NULL,
+  chunk_start VARBINARY(250) NOT NULL,
+  chunk_end VARBINARY(250) NOT NULL,
+  done TINYINT(1) UNSIGNED NOT NULL,
+  PRIMARY KEY (table_name, chunk_index)) ENGINE=InnoDB`,
+		`CREATE TABLE IF NOT EXISTS _vt.worker_step (
+  name VARBINARY(250) NOT NULL,
+  PRIMARY KEY (name)) ENGINE=InnoDB`}
+}
+
+// encodeString returns the SQL literal for a string.
+func encodeString(s string) string {
+	buf := new(bytes.Buffer)
+	sqltypes.MakeString([]byte(s)).EncodeSql(buf)
+	return buf.String()
+}
+
+// runOnAllTablets runs the commands in parallel on all the tablets
+// the checkpoint is stored on.
+func (cc *cloneCheckpoint) runOnAllTablets(commands []string, abort chan struct{}) error {
+	wg := sync.WaitGroup{}
+	mu := sync.Mutex{}
+	var firstError error
+	for _, ti := range cc.tablets {
+		wg.Add(1)
+		go func(ti *topo.TabletInfo) {
+			defer wg.Done()
+			if err := runSqlCommands(cc.wr, ti, commands, abort, cc.disableBinlogs); err != nil {
+				mu.Lock()
+				if firstError == nil {
+					firstError = fmt.Errorf("commands failed on tablet %v: %v", ti.Alias, err)
+				}
+				mu.Unlock()
+			}
+		}(ti)
+	}
+	wg.Wait()
+	return firstError
+}
+
+// start initializes the checkpoint for a new job, discarding any
+// previous one.
+func (cc *cloneCheckpoint) start(gtid myproto.GTID, sourceTablet topo.TabletAlias, abort chan struct{}) error {
+	cc.sourceTablet = sourceTablet
+	cc.gtid = gtid
+	commands := createCheckpointTables()
+	commands = append(commands,
+		"DELETE FROM _vt.worker_checkpoint",
+		"DELETE FROM _vt.worker_step",
+		"DELETE FROM _vt.worker_job",
+		fmt.Sprintf("INSERT INTO _vt.worker_job (id, worker, source, source_tablet, gtid, time_created) VALUES (0, %v, %v, %v, %v, %v)",
+			encodeString(cc.worker), encodeString(cc.source), encodeString(sourceTablet.String()), encodeString(myproto.EncodeGTID(gtid)), time.Now().Unix()))
+	return cc.runOnAllTablets(commands, abort)
+}
+
+// load reads the checkpoint of a previous job from all the tablets.
+// The job has to be of the same kind and from the same source.
+// A chunk or a step is only considered done if it is done on all the
+// tablets.
+func (cc *cloneCheckpoint) load() error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	for i, ti := range cc.tablets {
+		qr, err := cc.wr.ActionInitiator().ExecuteFetch(ti, "SELECT worker, source, source_tablet, gtid FROM _vt.worker_job WHERE id=0", 1, false, false, 30*time.Second)
+		if err != nil {
+			return fmt.Errorf("cannot read job checkpoint on tablet %v: %v", ti.Alias, err)
+		}
+		if len(qr.Rows) != 1 {
+			return fmt.Errorf("no job checkpoint on tablet %v", ti.Alias)
+		}
+		worker := qr.Rows[0][0].String()
+		source := qr.Rows[0][1].String()
+		if worker != cc.worker || source != cc.source {
+			return fmt.Errorf("job checkpoint on tablet %v is for %v from %v, not %v from %v", ti.Alias, worker, source, cc.worker, cc.source)
+		}
+		sourceTablet, err := topo.ParseTabletAliasString(qr.Rows[0][2].String())
+		if err != nil {
+			return fmt.Errorf("cannot decode job checkpoint source tablet on tablet %v: %v", ti.Alias, err)
+		}
+		gtid, err := myproto.DecodeGTID(qr.Rows[0][3].String())
+		if err != nil {
+			return fmt.Errorf("cannot decode job checkpoint position on tablet %v: %v", ti.Alias, err)
+		}
+		if i == 0 {
+			cc.sourceTablet = sourceTablet
+			cc.gtid = gtid
+		} else if sourceTablet != cc.sourceTablet || myproto.EncodeGTID(gtid) != myproto.EncodeGTID(cc.gtid) {
+			return fmt.Errorf("job checkpoint on tablet %v is from %v at %v, but from %v at %v on tablet %v", ti.Alias, sourceTablet, gtid, cc.sourceTablet, cc.gtid, cc.tablets[0].Alias)
+		}
+
+		qr, err = cc.wr.ActionInitiator().ExecuteFetch(ti, "SELECT table_name, chunk_index, chunk_start, chunk_end, done FROM _vt.worker_checkpoint ORDER BY table_name, chunk_index", 100000, false, false, 30*time.Second)
+		if err != nil {
+			return fmt.Errorf("cannot read chunk checkpoints on tablet %v: %v", ti.Alias, err)
+		}
+		chunks := make(map[string][]string)
+		done := make(map[string]map[int]bool)
+		for _, row := range qr.Rows {
+			table := row[0].String()
+			chunkIndex, err := strconv.Atoi(row[1].String())
+			if err != nil {
+				return fmt.Errorf("invalid chunk index %v for table %v on tablet %v: %v", row[1], table, ti.Alias, err)
+			}
+			if c := chunks[table]; chunkIndex > 0 && len(c) > 1 && c[len(c)-1] == "" {
+				// the last chunk ends with "", this row was
+				// left by a previous chunking of the table
+				continue
+			}
+			if chunkIndex == 0 {
+				chunks[table] = []string{row[2].String()}
+				done[table] = make(map[int]bool)
+			} else if c := chunks[table]; chunkIndex != len(c)-1 || row[2].String() != c[len(c)-1] {
+				return fmt.Errorf("inconsistent chunk %v for table %v on tablet %v", chunkIndex, table, ti.Alias)
+			}
+			chunks[table] = append(chunks[table], row[3].String())
+			if row[4].String() == "1" {
+				done[table][chunkIndex] = true
+			}
+		}
+
+		qr, err = cc.wr.ActionInitiator().ExecuteFetch(ti, "SELECT name FROM _vt.worker_step", 10000, false, false, 30*time.Second)
+		if err != nil {
+			return fmt.Errorf("cannot read step checkpoints on tablet %v: %v", ti.Alias, err)
+		}
+		steps := make(map[string]bool)
+		for _, row := range qr.Rows {
+			steps[row[0].String()] = true
+		}
+
+		if i == 0 {
+			cc.chunks = chunks
+			cc.done = done
+			cc.steps = steps
+			continue
+		}
+		for step := range cc.steps {
+			if !steps[step] {
+				delete(cc.steps, step)
+			}
+		}
+		for table, c := range cc.chunks {
+			other, ok := chunks[table]
+			if !ok {
+				// the chunks of this table were not recorded
+				// on all tablets, so none of its data was copied.
+				delete(cc.chunks, table)
+				delete(cc.done, table)
+				continue
+			}
+			if !stringListsEqual(c, other) {
+				return fmt.Errorf("chunks for table %v on tablet %v are different from tablet %v", table, ti.Alias, cc.tablets[0].Alias)
+			}
+			for chunkIndex := range cc.done[table] {
+				if !done[table][chunkIndex] {
+					delete(cc.done[table], chunkIndex)
+				}
+			}
+		}
+	}
+	log.Infof("Loaded job checkpoint for %v from %v (tablet %v) at position %v", cc.worker, cc.source, cc.sourceTablet, cc.gtid)
+	return nil
+}
+
+// checkPosition makes sure the source is still at the position the
+// job was started at, for the jobs that copy from a stopped source:
+// the chunks that are done were copied at that position, and the
+// filtered replication of the destinations starts from it, so the
+// source cannot have moved.
+func (cc *cloneCheckpoint) checkPosition(gtid myproto.GTID) error {
+	if cc.gtid == nil || gtid == nil {
+		return fmt.Errorf("cannot compare source position %v with job position %v", gtid, cc.gtid)
+	}
+	if myproto.EncodeGTID(gtid) != myproto.EncodeGTID(cc.gtid) {
+		return fmt.Errorf("source position %v is not job position %v, the job has to be started again", gtid, cc.gtid)
+	}
+	return nil
+}
+
+// checkReplayPosition makes sure the changes since the position the
+// job was started at can be replayed from the current position of the
+// source, for the jobs that sync the changes after the copy: it has
+// to be in the same replication stream, and not before it.
+func (cc *cloneCheckpoint) checkReplayPosition(gtid myproto.GTID) error {
+	pos, err := myproto.ToGTIDSet(gtid)
+	if err != nil {
+		return fmt.Errorf("invalid source position %v: %v", gtid, err)
+	}
+	start, err := myproto.ToGTIDSet(cc.gtid)
+	if err != nil {
+		return fmt.Errorf("invalid job position %v: %v", cc.gtid, err)
+	}
+	atLeast, err := pos.AtLeast(start)
+	if err != nil {
+		return fmt.Errorf("source position %v is not comparable with job position %v: %v", gtid, cc.gtid, err)
+	}
+	if !atLeast {
+		return fmt.Errorf("source position %v is before job position %v", gtid, cc.gtid)
+	}
+	return nil
+}
+
+// tableChunks returns the chunks recorded for a table, if any.
+func (cc *cloneCheckpoint) tableChunks(table string) ([]string, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	chunks, ok := cc.chunks[table]
+	return chunks, ok
+}
+
+// recordChunks saves the chunks of a table, none of them done yet.
+// All the chunks are written in one statement, so a table has either
+// all its chunks recorded on a tablet, or none. The statement replaces
+// the rows of a previous chunking of the table, and load ignores the
+// ones after the last chunk.
+func (cc *cloneCheckpoint) recordChunks(table string, chunks []string, abort chan struct{}) error {
+	values := make([]string, 0, len(chunks)-1)
+	for chunkIndex := 0; chunkIndex < len(chunks)-1; chunkIndex++ {
+		values = append(values, fmt.Sprintf("(%v, %v, %v, %v, 0)", encodeString(table), chunkIndex, encodeString(chunks[chunkIndex]), encodeString(chunks[chunkIndex+1])))
+	}
+	command := "INSERT INTO _vt.worker_checkpoint (table_name, chunk_index, chunk_start, chunk_end, done) VALUES " + strings.Join(values, ", ") +
+		" ON DUPLICATE KEY UPDATE chunk_start=VALUES(chunk_start), chunk_end=VALUES(chunk_end), done=0"
+	if err := cc.runOnAllTablets([]string{command}, abort); err != nil {
+		return err
+	}
+
+	cc.mu.Lock()
+	cc.chunks[table] = chunks
+	cc.done[table] = make(map[int]bool)
+	cc.mu.Unlock()
+	return nil
+}
+
+// isDone returns true if the chunk was already copied.
+func (cc *cloneCheckpoint) isDone(table string, chunkIndex int) bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.done[table][chunkIndex]
+}
+
+// markDone records that the chunk was copied to all destinations.
+func (cc *cloneCheckpoint) markDone(table string, chunkIndex int, abort chan struct{}) error {
+	command := fmt.Sprintf("UPDATE _vt.worker_checkpoint SET done=1 WHERE table_name=%v AND chunk_index=%v", encodeString(table), chunkIndex)
+	if err := cc.runOnAllTablets([]string{command}, abort); err != nil {
+		return err
+	}
+
+	cc.mu.Lock()
+	cc.done[table][chunkIndex] = true
+	cc.mu.Unlock()
+	return nil
+}
+
+// isStepDone returns true if the setup step was completed.
+func (cc *cloneCheckpoint) isStepDone(step string) bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.steps[step]
+}
+
+// markStepDone records that the setup step was completed on all the
+// tablets, so it is not run again when the job is resumed.
+func (cc *cloneCheckpoint) markStepDone(step string, abort chan struct{}) error {
+	command := "INSERT IGNORE INTO _vt.worker_step (name) VALUES (" + encodeString(step) + ")"
+	if err := cc.runOnAllTablets([]string{command}, abort); err != nil {
+		return err
+	}
+
+	cc.mu.Lock()
+	cc.steps[step] = true
+	cc.mu.Unlock()
+	return nil
+}
+
+// insertCmd is an insert statement sent to a destination writer.
+// The writer calls done() once the statement has been executed, so
+// the reader of the chunk knows when all its rows are in.
+type insertCmd struct {
+	sql   string
+	chunk *sync.WaitGroup
+}
+
+func (ic insertCmd) done() {
+	if ic.chunk != nil {
+		ic.chunk.Done()
+	}
+}
+
+// waitForChunk waits until all the insert commands of a chunk are
+// executed. It returns false if the copy was aborted first.
+func waitForChunk(chunk *sync.WaitGroup, abort chan struct{}) bool {
+	allDone := make(chan struct{})
+	go func() {
+		chunk.Wait()
+		close(allDone)
+	}()
+	select {
+	case <-allDone:
+		return true
+	case <-abort:
+		return false
+	}
+}
+
+func stringListsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
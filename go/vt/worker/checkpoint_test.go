@@ -0,0 +1,32 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"testing"
+
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+)
+
+func TestCheckPosition(t *testing.T) {
+	cc := &cloneCheckpoint{gtid: myproto.GoogleGTID{GroupID: 12}}
+	table := []struct {
+		gtid   myproto.GTID
+		clone  bool
+		replay bool
+	}{
+		{myproto.GoogleGTID{GroupID: 10}, false, false},
+		{myproto.GoogleGTID{GroupID: 12}, true, true},
+		{myproto.GoogleGTID{GroupID: 14}, false, true},
+	}
+	for _, tc := range table {
+		if err := cc.checkPosition(tc.gtid); (err == nil) != tc.clone {
+			t.Errorf("checkPosition(%v) = %v, want ok=%v", tc.gtid, err, tc.clone)
+		}
+		if err := cc.checkReplayPosition(tc.gtid); (err == nil) != tc.replay {
+			t.Errorf("checkReplayPosition(%v) = %v, want ok=%v", tc.gtid, err, tc.replay)
+		}
+	}
+}
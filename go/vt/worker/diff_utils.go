@@ -113,45 +113,44 @@ func TableScan(ts topo.Server, tabletAlias topo.TabletAlias, tableDefinition *my
 // Primary Key. The returned columns are ordered with the Primary Key
 // columns in front.
 func TableScanByKeyRange(ts topo.Server, tabletAlias topo.TabletAlias, tableDefinition *myproto.TableDefinition, keyRange key.KeyRange, keyspaceIdType key.KeyspaceIdType) (*QueryResultReader, error) {
+	clauses, err := keyRangeClauses("keyspace_id", keyRange, keyspaceIdType)
+	if err != nil {
+		return nil, err
+	}
 	where := ""
+	if len(clauses) > 0 {
+		where = "WHERE " + strings.Join(clauses, " AND ") + " "
+	}
+
+	sql := fmt.Sprintf("SELECT %v FROM %v %vORDER BY (%v)", strings.Join(orderedColumns(tableDefinition), ", "), tableDefinition.Name, where, strings.Join(tableDefinition.PrimaryKeyColumns, ", "))
+	log.Infof("SQL query for %v/%v: %v", tabletAlias, tableDefinition.Name, sql)
+	return NewQueryResultReaderForTablet(ts, tabletAlias, sql)
+}
+
+// keyRangeClauses returns the conditions on the keyspace id column
+// that restrict a query to the rows in the KeyRange. There are none
+// for the full KeyRange.
+func keyRangeClauses(column string, keyRange key.KeyRange, keyspaceIdType key.KeyspaceIdType) ([]string, error) {
+	clauses := make([]string, 0, 2)
 	switch keyspaceIdType {
 	case key.KIT_UINT64:
 		if keyRange.Start != key.MinKey {
-			if keyRange.End != key.MaxKey {
-				// have start & end
-				where = fmt.Sprintf("WHERE keyspace_id >= %v AND keyspace_id < %v ", uint64FromKeyspaceId(keyRange.Start), uint64FromKeyspaceId(keyRange.End))
-			} else {
-				// have start only
-				where = fmt.Sprintf("WHERE keyspace_id >= %v ", uint64FromKeyspaceId(keyRange.Start))
-			}
-		} else {
-			if keyRange.End != key.MaxKey {
-				// have end only
-				where = fmt.Sprintf("WHERE keyspace_id < %v ", uint64FromKeyspaceId(keyRange.End))
-			}
+			clauses = append(clauses, fmt.Sprintf("%v >= %v", column, uint64FromKeyspaceId(keyRange.Start)))
+		}
+		if keyRange.End != key.MaxKey {
+			clauses = append(clauses, fmt.Sprintf("%v < %v", column, uint64FromKeyspaceId(keyRange.End)))
 		}
 	case key.KIT_BYTES:
 		if keyRange.Start != key.MinKey {
-			if keyRange.End != key.MaxKey {
-				// have start & end
-				where = fmt.Sprintf("WHERE HEX(keyspace_id) >= '%v' AND HEX(keyspace_id) < '%v' ", keyRange.Start.Hex(), keyRange.End.Hex())
-			} else {
-				// have start only
-				where = fmt.Sprintf("WHERE HEX(keyspace_id) >= '%v' ", keyRange.Start.Hex())
-			}
-		} else {
-			if keyRange.End != key.MaxKey {
-				// have end only
-				where = fmt.Sprintf("WHERE HEX(keyspace_id) < '%v' ", keyRange.End.Hex())
-			}
+			clauses = append(clauses, fmt.Sprintf("HEX(%v) >= '%v'", column, keyRange.Start.Hex()))
+		}
+		if keyRange.End != key.MaxKey {
+			clauses = append(clauses, fmt.Sprintf("HEX(%v) < '%v'", column, keyRange.End.Hex()))
 		}
 	default:
 		return nil, fmt.Errorf("Unsupported KeyspaceIdType: %v", keyspaceIdType)
 	}
-
-	sql := fmt.Sprintf("SELECT %v FROM %v %vORDER BY (%v)", strings.Join(orderedColumns(tableDefinition), ", "), tableDefinition.Name, where, strings.Join(tableDefinition.PrimaryKeyColumns, ", "))
-	log.Infof("SQL query for %v/%v: %v", tabletAlias, tableDefinition.Name, sql)
-	return NewQueryResultReaderForTablet(ts, tabletAlias, sql)
+	return clauses, nil
 }
 
 func (qrr *QueryResultReader) Error() error {
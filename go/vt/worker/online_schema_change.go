@@ -0,0 +1,518 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/wrangler"
+)
+
+const (
+	// all the states for the worker
+	stateOSCNotStarted = "not started"
+	stateOSCDone       = "done"
+	stateOSCError      = "error"
+
+	stateOSCInit    = "creating the shadow table"
+	stateOSCCopy    = "copying the rows to the shadow table"
+	stateOSCSync    = "syncing the changed rows"
+	stateOSCCutover = "swapping the tables"
+	stateOSCCleanUp = "cleaning up"
+)
+
+// onlineSchemaChangeCutoverRowCount is the number of rows changed
+// during a sync pass below which the tables are swapped: the last
+// pass runs with the table locked, so it has to be short.
+const onlineSchemaChangeCutoverRowCount = 1000
+
+// onlineSchemaChangeShadowTableStep is the checkpoint step of the
+// shadow table creation.
+const onlineSchemaChangeShadowTableStep = "shadow_table"
+
+// OnlineSchemaChangeWorker alters a table of a keyspace without
+// blocking its writes. On the master of each shard in turn, a shadow
+// table is created with the new schema, and the rows are copied into
+// it in chunks. The rows changed in the meantime are found in the
+// binlogs and copied again, and once there are few of them left, the
+// table is locked for the last sync and swapped with the shadow
+// table. The original table is kept as _<table>_old.
+//
+// The copy is throttled on the replication lag of the slaves, and the
+// copied chunks are checkpointed on the master, in the same tables as
+// the clone jobs, so the job can be resumed.
+type OnlineSchemaChangeWorker struct {
+	wr                   *wrangler.Wrangler
+	keyspace             string
+	shards               []string
+	table                string
+	alter                string
+	chunkCount           int
+	minTableSizeForSplit uint64
+	maxReplicationLag    time.Duration
+	resume               bool
+	cleaner              *wrangler.Cleaner
+
+	// all subsequent fields are protected by the mutex
+	mu    sync.Mutex
+	state string
+
+	// populated if state == stateOSCError
+	err error
+
+	// progress of the job
+	shardsDone  []string
+	shard       string
+	chunksDone  int
+	chunksTotal int
+	rowsSynced  int
+	throttled   string
+}
+
+// NewOnlineSchemaChangeWorker returns a new OnlineSchemaChangeWorker
+// object, that runs "ALTER TABLE <table> <alter>" on the given shards
+// of the keyspace, or all of them if none is given. If resume is set,
+// the worker continues the job previously started on each master,
+// using its checkpoint.
+func NewOnlineSchemaChangeWorker(wr *wrangler.Wrangler, keyspace string, shards []string, table, alter string, chunkCount int, minTableSizeForSplit uint64, maxReplicationLag time.Duration, resume bool) Worker {
+	return &OnlineSchemaChangeWorker{
+		wr:                   wr,
+		keyspace:             keyspace,
+		shards:               shards,
+		table:                table,
+		alter:                alter,
+		chunkCount:           chunkCount,
+		minTableSizeForSplit: minTableSizeForSplit,
+		maxReplicationLag:    maxReplicationLag,
+		resume:               resume,
+		cleaner:              &wrangler.Cleaner{},
+
+		state: stateOSCNotStarted,
+	}
+}
+
+func (oscw *OnlineSchemaChangeWorker) setState(state string) {
+	oscw.mu.Lock()
+	oscw.state = state
+	oscw.mu.Unlock()
+}
+
+func (oscw *OnlineSchemaChangeWorker) recordError(err error) {
+	oscw.mu.Lock()
+	oscw.state = stateOSCError
+	oscw.err = err
+	oscw.mu.Unlock()
+}
+
+func (oscw *OnlineSchemaChangeWorker) setThrottled(throttled string) {
+	oscw.mu.Lock()
+	oscw.throttled = throttled
+	oscw.mu.Unlock()
+}
+
+// progress returns the progress on the current shard, for display.
+// It must be called with the mutex held.
+func (oscw *OnlineSchemaChangeWorker) progress() string {
+	result := ""
+	switch oscw.state {
+	case stateOSCCopy:
+		result = fmt.Sprintf("%v/%v chunks copied on shard %v", oscw.chunksDone, oscw.chunksTotal, oscw.shard)
+	case stateOSCSync:
+		result = fmt.Sprintf("%v rows synced by the last pass on shard %v", oscw.rowsSynced, oscw.shard)
+	default:
+		result = "working on shard " + oscw.shard
+	}
+	if oscw.throttled != "" {
+		result += ", throttled: " + oscw.throttled
+	}
+	return result
+}
+
+// StatusAsHTML implements the Worker interface
+func (oscw *OnlineSchemaChangeWorker) StatusAsHTML() template.HTML {
+	oscw.mu.Lock()
+	defer oscw.mu.Unlock()
+	result := "<b>Working on:</b> " + oscw.keyspace + "." + oscw.table + "</br>\n"
+	result += "<b>Change:</b> ALTER TABLE " + oscw.table + " " + oscw.alter + "</br>\n"
+	result += "<b>State:</b> " + oscw.state + "</br>\n"
+	switch oscw.state {
+	case stateOSCError:
+		result += "<b>Error</b>: " + oscw.err.Error() + "</br>\n"
+	case stateOSCInit, stateOSCCopy, stateOSCSync, stateOSCCutover:
+		result += "<b>Running</b>: " + oscw.progress() + "</br>\n"
+	case stateOSCDone:
+		result += "<b>Success</b>: the original table is kept as " + oldTableName(oscw.table) + "</br>\n"
+	}
+	if len(oscw.shardsDone) > 0 {
+		result += "<b>Shards done:</b> " + strings.Join(oscw.shardsDone, ", ") + "</br>\n"
+	}
+
+	return template.HTML(result)
+}
+
+// StatusAsText implements the Worker interface
+func (oscw *OnlineSchemaChangeWorker) StatusAsText() string {
+	oscw.mu.Lock()
+	defer oscw.mu.Unlock()
+	result := "Working on: " + oscw.keyspace + "." + oscw.table + "\n"
+	result += "Change: ALTER TABLE " + oscw.table + " " + oscw.alter + "\n"
+	result += "State: " + oscw.state + "\n"
+	switch oscw.state {
+	case stateOSCError:
+		result += "Error: " + oscw.err.Error() + "\n"
+	case stateOSCInit, stateOSCCopy, stateOSCSync, stateOSCCutover:
+		result += "Running: " + oscw.progress() + "\n"
+	case stateOSCDone:
+		result += "Success: the original table is kept as " + oldTableName(oscw.table) + "\n"
+	}
+	if len(oscw.shardsDone) > 0 {
+		result += "Shards done: " + strings.Join(oscw.shardsDone, ", ") + "\n"
+	}
+	return result
+}
+
+func (oscw *OnlineSchemaChangeWorker) CheckInterrupted() bool {
+	select {
+	case <-interrupted:
+		oscw.recordError(topo.ErrInterrupted)
+		return true
+	default:
+	}
+	return false
+}
+
+// Run implements the Worker interface
+func (oscw *OnlineSchemaChangeWorker) Run() {
+	err := oscw.run()
+
+	oscw.setState(stateOSCCleanUp)
+	cerr := oscw.cleaner.CleanUp(oscw.wr)
+	if cerr != nil {
+		if err != nil {
+			log.Errorf("CleanUp failed in addition to job error: %v", cerr)
+		} else {
+			err = cerr
+		}
+	}
+	if err != nil {
+		oscw.recordError(err)
+		return
+	}
+	oscw.setState(stateOSCDone)
+}
+
+func (oscw *OnlineSchemaChangeWorker) Error() error {
+	return oscw.err
+}
+
+// shadowTableName returns the name of the table with the new schema.
+func shadowTableName(table string) string {
+	return "_" + table + "_osc"
+}
+
+// oldTableName returns the name the original table is renamed to.
+func oldTableName(table string) string {
+	return "_" + table + "_old"
+}
+
+func (oscw *OnlineSchemaChangeWorker) run() error {
+	shards := oscw.shards
+	if len(shards) == 0 {
+		var err error
+		shards, err = oscw.wr.TopoServer().GetShardNames(oscw.keyspace)
+		if err != nil {
+			return fmt.Errorf("cannot read shard names for keyspace %v: %v", oscw.keyspace, err)
+		}
+	}
+
+	// the shards are done one at a time, so a bad change can be
+	// stopped before it reaches all of them
+	for _, shard := range shards {
+		if oscw.CheckInterrupted() {
+			return topo.ErrInterrupted
+		}
+		if err := oscw.changeShard(shard); err != nil {
+			return fmt.Errorf("shard %v/%v failed: %v", oscw.keyspace, shard, err)
+		}
+		oscw.mu.Lock()
+		oscw.shardsDone = append(oscw.shardsDone, shard)
+		oscw.mu.Unlock()
+	}
+	return nil
+}
+
+// getTableDefinition returns the definition of a table on a tablet,
+// or nil if it doesn't exist.
+func (oscw *OnlineSchemaChangeWorker) getTableDefinition(tabletAlias topo.TabletAlias, table string) (*myproto.TableDefinition, error) {
+	sd, err := oscw.wr.GetSchema(tabletAlias, []string{"^" + regexp.QuoteMeta(table) + "$"}, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get schema of table %v from tablet %v: %v", table, tabletAlias, err)
+	}
+	if len(sd.TableDefinitions) == 0 {
+		return nil, nil
+	}
+	return &sd.TableDefinitions[0], nil
+}
+
+// changeShard runs the schema change on the master of a shard.
+func (oscw *OnlineSchemaChangeWorker) changeShard(shard string) error {
+	oscw.mu.Lock()
+	oscw.state = stateOSCInit
+	oscw.shard = shard
+	oscw.chunksDone = 0
+	oscw.chunksTotal = 0
+	oscw.rowsSynced = 0
+	oscw.mu.Unlock()
+
+	si, err := oscw.wr.TopoServer().GetShard(oscw.keyspace, shard)
+	if err != nil {
+		return fmt.Errorf("cannot read shard: %v", err)
+	}
+	if si.MasterAlias.IsZero() {
+		return fmt.Errorf("shard has no master")
+	}
+	master, err := oscw.wr.TopoServer().GetTablet(si.MasterAlias)
+	if err != nil {
+		return fmt.Errorf("cannot read master tablet %v: %v", si.MasterAlias, err)
+	}
+
+	// check the tables
+	shadowTable := shadowTableName(oscw.table)
+	oldTable := oldTableName(oscw.table)
+	td, err := oscw.getTableDefinition(master.Alias, oscw.table)
+	if err != nil {
+		return err
+	}
+	if td == nil {
+		return fmt.Errorf("no table %v on master %v", oscw.table, master.Alias)
+	}
+	if len(td.PrimaryKeyColumns) == 0 {
+		return fmt.Errorf("table %v has no primary key", oscw.table)
+	}
+	if otd, err := oscw.getTableDefinition(master.Alias, oldTable); err != nil {
+		return err
+	} else if otd != nil {
+		return fmt.Errorf("table %v already exists on master %v, it has to be dropped first", oldTable, master.Alias)
+	}
+
+	// The job checkpoint is stored on the master only, and is not
+	// replicated. It records the position when the job started, the
+	// setup steps that are done, and the chunks that are already
+	// copied. All the changes after that position are synced after
+	// the copy, so a resumed job replays them from there.
+	checkpoint := newCloneCheckpoint(oscw.wr, "OnlineSchemaChange", oscw.keyspace+"/"+shard+"/"+oscw.table, []*topo.TabletInfo{master}, true)
+	pos, err := oscw.wr.ActionInitiator().MasterPosition(master, 30*time.Second)
+	if err != nil {
+		return fmt.Errorf("cannot get position of master %v: %v", master.Alias, err)
+	}
+	if oscw.resume {
+		if err := checkpoint.load(); err != nil {
+			return fmt.Errorf("cannot resume: %v", err)
+		}
+		if err := checkpoint.checkReplayPosition(pos.MasterLogGTIDField.Value); err != nil {
+			return fmt.Errorf("cannot resume on master %v: %v", master.Alias, err)
+		}
+		log.Infof("Resuming schema change of %v on master %v", oscw.table, master.Alias)
+	} else {
+		if err := checkpoint.start(pos.MasterLogGTIDField.Value, master.Alias, nil); err != nil {
+			return fmt.Errorf("cannot start job checkpoint: %v", err)
+		}
+	}
+
+	// create the shadow table, with binlogs enabled so the slaves
+	// have it too
+	if !checkpoint.isStepDone(onlineSchemaChangeShadowTableStep) {
+		commands := []string{
+			actionnode.OnlineSchemaChangeComment + "DROP TABLE IF EXISTS {{.DatabaseName}}." + shadowTable,
+			actionnode.OnlineSchemaChangeComment + "CREATE TABLE {{.DatabaseName}}." + shadowTable + " LIKE {{.DatabaseName}}." + oscw.table,
+			actionnode.OnlineSchemaChangeComment + "ALTER TABLE {{.DatabaseName}}." + shadowTable + " " + oscw.alter,
+		}
+		if err := runSqlCommands(oscw.wr, master, commands, nil, false); err != nil {
+			return fmt.Errorf("cannot create shadow table: %v", err)
+		}
+		if err := checkpoint.markStepDone(onlineSchemaChangeShadowTableStep, nil); err != nil {
+			return fmt.Errorf("cannot checkpoint shadow table creation: %v", err)
+		}
+	}
+
+	// the columns that are in both tables are copied, the primary
+	// key has to be in the shadow table to sync the changed rows
+	std, err := oscw.getTableDefinition(master.Alias, shadowTable)
+	if err != nil {
+		return err
+	}
+	if std == nil {
+		return fmt.Errorf("no shadow table %v on master %v", shadowTable, master.Alias)
+	}
+	var columns []string
+	for _, column := range td.Columns {
+		if stringInList(column, std.Columns) {
+			columns = append(columns, column)
+		}
+	}
+	for _, column := range td.PrimaryKeyColumns {
+		if !stringInList(column, std.Columns) {
+			return fmt.Errorf("primary key column %v is not in the new schema", column)
+		}
+	}
+
+	if err := oscw.copy(master, td, shadowTable, columns, checkpoint); err != nil {
+		return err
+	}
+
+	// sync the rows changed since the job started, until there are
+	// few enough of them to swap the tables
+	oscw.setState(stateOSCSync)
+	args := &actionnode.OnlineSchemaChangeSyncArgs{
+		Table:       oscw.table,
+		ShadowTable: shadowTable,
+		OldTable:    oldTable,
+		Columns:     columns,
+		StartGTID:   myproto.GTIDField{Value: checkpoint.gtid},
+	}
+	for {
+		if oscw.CheckInterrupted() {
+			return topo.ErrInterrupted
+		}
+		if err := oscw.waitForReplication(shard); err != nil {
+			return err
+		}
+		reply, err := oscw.wr.OnlineSchemaChangeSync(master.Alias, args)
+		if err != nil {
+			return fmt.Errorf("cannot sync shadow table: %v", err)
+		}
+		log.Infof("Synced %v rows of %v on master %v up to %v", reply.RowCount, oscw.table, master.Alias, reply.GTIDField)
+		oscw.mu.Lock()
+		oscw.rowsSynced = reply.RowCount
+		oscw.mu.Unlock()
+		args.StartGTID = reply.GTIDField
+		if reply.RowCount < onlineSchemaChangeCutoverRowCount {
+			break
+		}
+	}
+
+	// and swap the tables
+	oscw.setState(stateOSCCutover)
+	args.Cutover = true
+	if _, err := oscw.wr.OnlineSchemaChangeSync(master.Alias, args); err != nil {
+		return fmt.Errorf("cannot swap tables: %v", err)
+	}
+
+	// the tablets have to reload their schema
+	aliases, err := topo.FindAllTabletAliasesInShard(oscw.wr.TopoServer(), oscw.keyspace, shard)
+	if err != nil {
+		return fmt.Errorf("cannot find tablets to reload the schema on: %v", err)
+	}
+	for _, alias := range aliases {
+		if err := oscw.wr.ReloadSchema(alias); err != nil {
+			log.Warningf("Cannot reload schema on tablet %v: %v", alias, err)
+		}
+	}
+	return nil
+}
+
+// copy copies the rows of the table into the shadow table, one chunk
+// at a time. A chunk that was partially copied is copied again.
+func (oscw *OnlineSchemaChangeWorker) copy(master *topo.TabletInfo, td *myproto.TableDefinition, shadowTable string, columns []string, checkpoint *cloneCheckpoint) error {
+	oscw.setState(stateOSCCopy)
+
+	chunks, ok := checkpoint.tableChunks(td.Name)
+	if !ok {
+		var err error
+		chunks, err = findChunks(oscw.wr, master, td, oscw.minTableSizeForSplit, oscw.chunkCount)
+		if err != nil {
+			return err
+		}
+		if err := checkpoint.recordChunks(td.Name, chunks, nil); err != nil {
+			return fmt.Errorf("cannot record chunks for table %v: %v", td.Name, err)
+		}
+	}
+	oscw.mu.Lock()
+	oscw.chunksTotal = len(chunks) - 1
+	oscw.mu.Unlock()
+
+	columnList := strings.Join(columns, ", ")
+	for chunkIndex := 0; chunkIndex < len(chunks)-1; chunkIndex++ {
+		if oscw.CheckInterrupted() {
+			return topo.ErrInterrupted
+		}
+		if !checkpoint.isDone(td.Name, chunkIndex) {
+			if err := oscw.waitForReplication(master.Shard); err != nil {
+				return err
+			}
+			where := buildWhereFromChunks(td, chunks, chunkIndex)
+			commands := []string{
+				actionnode.OnlineSchemaChangeComment + "DELETE FROM {{.DatabaseName}}." + shadowTable + where,
+				actionnode.OnlineSchemaChangeComment + "INSERT INTO {{.DatabaseName}}." + shadowTable + " (" + columnList + ") SELECT " + columnList + " FROM {{.DatabaseName}}." + td.Name + where,
+			}
+			if err := runSqlCommands(oscw.wr, master, commands, nil, false); err != nil {
+				return fmt.Errorf("cannot copy chunk %v of table %v: %v", chunkIndex, td.Name, err)
+			}
+			if err := checkpoint.markDone(td.Name, chunkIndex, nil); err != nil {
+				return fmt.Errorf("cannot checkpoint chunk %v of table %v: %v", chunkIndex, td.Name, err)
+			}
+		}
+		oscw.mu.Lock()
+		oscw.chunksDone++
+		oscw.mu.Unlock()
+	}
+	return nil
+}
+
+// waitForReplication waits until the replication lag of all the
+// slaves of the shard is below maxReplicationLag. Slaves that are not
+// replicating are ignored.
+func (oscw *OnlineSchemaChangeWorker) waitForReplication(shard string) error {
+	for {
+		aliases, err := topo.FindAllTabletAliasesInShard(oscw.wr.TopoServer(), oscw.keyspace, shard)
+		if err != nil {
+			return fmt.Errorf("cannot find tablets to check replication lag: %v", err)
+		}
+		tablets, err := topo.GetTabletMap(oscw.wr.TopoServer(), aliases)
+		if err != nil {
+			return fmt.Errorf("cannot read tablets to check replication lag: %v", err)
+		}
+
+		throttled := ""
+		for alias, ti := range tablets {
+			if !topo.IsSlaveType(ti.Type) {
+				continue
+			}
+			pos, err := oscw.wr.ActionInitiator().SlavePosition(ti, 30*time.Second)
+			if err != nil {
+				throttled = fmt.Sprintf("cannot get position of slave %v: %v", alias, err)
+				break
+			}
+			if pos.SecondsBehindMaster == myproto.InvalidLagSeconds {
+				continue
+			}
+			if lag := time.Duration(pos.SecondsBehindMaster) * time.Second; lag > oscw.maxReplicationLag {
+				throttled = fmt.Sprintf("slave %v is %v behind", alias, lag)
+				break
+			}
+		}
+		oscw.setThrottled(throttled)
+		if throttled == "" {
+			return nil
+		}
+
+		log.Infof("Throttling schema change: %v", throttled)
+		select {
+		case <-interrupted:
+			oscw.recordError(topo.ErrInterrupted)
+			return topo.ErrInterrupted
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/memorytopo"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/wrangler"
+	"github.com/youtube/vitess/go/vt/wrangler/testlib"
+)
+
+// fakeOSCMaster is the mysql of the master. It records the
+// statements, answers the queries of the worker, and makes the
+// RENAME TABLE of the cutover wait for UNLOCK TABLES.
+type fakeOSCMaster struct {
+	mu            sync.Mutex
+	queries       []string
+	job           [][]sqltypes.Value
+	checkpoint    [][]sqltypes.Value
+	steps         [][]sqltypes.Value
+	locked        bool
+	unlocked      chan struct{}
+	renamePending bool
+}
+
+func newFakeOSCMaster() *fakeOSCMaster {
+	return &fakeOSCMaster{unlocked: make(chan struct{})}
+}
+
+func stringRow(values ...string) []sqltypes.Value {
+	row := make([]sqltypes.Value, len(values))
+	for i, v := range values {
+		row[i] = sqltypes.MakeString([]byte(v))
+	}
+	return row
+}
+
+func (m *fakeOSCMaster) fetch(query string) (*mproto.QueryResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries = append(m.queries, query)
+	switch {
+	case query == "SELECT MIN(id), MAX(id) FROM vt_test_keyspace.t":
+		return &mproto.QueryResult{
+			Fields: []mproto.Field{{Name: "MIN(id)", Type: mproto.VT_LONGLONG}, {Name: "MAX(id)", Type: mproto.VT_LONGLONG}},
+			Rows:   [][]sqltypes.Value{stringRow("10", "40")},
+		}, nil
+	case strings.HasPrefix(query, "SELECT worker, source, source_tablet, gtid FROM _vt.worker_job"):
+		return &mproto.QueryResult{Rows: m.job}, nil
+	case strings.HasPrefix(query, "SELECT table_name, chunk_index, chunk_start, chunk_end, done FROM _vt.worker_checkpoint"):
+		return &mproto.QueryResult{Rows: m.checkpoint}, nil
+	case strings.HasPrefix(query, "SELECT name FROM _vt.worker_step"):
+		return &mproto.QueryResult{Rows: m.steps}, nil
+	case strings.HasPrefix(query, "SELECT COUNT(*) FROM information_schema.processlist"):
+		if m.renamePending {
+			return &mproto.QueryResult{Rows: [][]sqltypes.Value{stringRow("1")}}, nil
+		}
+		return &mproto.QueryResult{Rows: [][]sqltypes.Value{stringRow("0")}}, nil
+	case strings.HasPrefix(query, "LOCK TABLES"):
+		m.locked = true
+	case query == "UNLOCK TABLES":
+		if m.locked {
+			m.locked = false
+			close(m.unlocked)
+		}
+	case strings.HasPrefix(query, "RENAME TABLE") && m.locked:
+		m.renamePending = true
+		m.mu.Unlock()
+		<-m.unlocked
+		m.mu.Lock()
+		m.renamePending = false
+	}
+	return &mproto.QueryResult{}, nil
+}
+
+// statements returns the statements that changed the tables, and
+// marked the chunks done.
+func (m *fakeOSCMaster) statements() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []string
+	for _, query := range m.queries {
+		if strings.HasPrefix(query, "SELECT") {
+			continue
+		}
+		if strings.Contains(query, " _vt") && !strings.HasPrefix(query, "UPDATE _vt.worker_checkpoint") {
+			continue
+		}
+		result = append(result, query)
+	}
+	return result
+}
+
+var shadowTableStatements = []string{
+	actionnode.OnlineSchemaChangeComment + "DROP TABLE IF EXISTS vt_test_keyspace._t_osc",
+	actionnode.OnlineSchemaChangeComment + "CREATE TABLE vt_test_keyspace._t_osc LIKE vt_test_keyspace.t",
+	actionnode.OnlineSchemaChangeComment + "ALTER TABLE vt_test_keyspace._t_osc ADD COLUMN c INT",
+}
+
+func copyStatements(where string, chunkIndex string) []string {
+	return []string{
+		actionnode.OnlineSchemaChangeComment + "DELETE FROM vt_test_keyspace._t_osc" + where,
+		actionnode.OnlineSchemaChangeComment + "INSERT INTO vt_test_keyspace._t_osc (id, msg) SELECT id, msg FROM vt_test_keyspace.t" + where,
+		"UPDATE _vt.worker_checkpoint SET done=1 WHERE table_name='t' AND chunk_index=" + chunkIndex,
+	}
+}
+
+var cutoverStatements = []string{
+	"CREATE TABLE vt_test_keyspace._t_old (id INT)",
+	"LOCK TABLES vt_test_keyspace.t WRITE, vt_test_keyspace._t_osc WRITE, vt_test_keyspace._t_old WRITE",
+	"RENAME TABLE vt_test_keyspace.t TO vt_test_keyspace._t_old, vt_test_keyspace._t_osc TO vt_test_keyspace.t",
+	"DROP TABLE vt_test_keyspace._t_old",
+	"UNLOCK TABLES",
+}
+
+// runOnlineSchemaChange runs the worker on a shard with a master
+// backed by m, and a replica. It returns the worker error.
+func runOnlineSchemaChange(t *testing.T, m *fakeOSCMaster, resume bool, masterGTID myproto.GTID) error {
+	flag.Set("tablet_manager_protocol", testlib.FakeTabletManagerProtocol)
+	ts := memorytopo.NewTestServer(t, []string{"cell1"})
+	wr := wrangler.New(ts, time.Minute, time.Second)
+	wr.UseRPCs = false
+
+	master := testlib.NewFakeTablet(t, wr, "cell1", 0, topo.TYPE_MASTER)
+	replica := testlib.NewFakeTablet(t, wr, "cell1", 1, topo.TYPE_REPLICA,
+		testlib.TabletParent(master.Tablet.Alias))
+
+	master.FakeMysqlDaemon.FetchSuperQuery = m.fetch
+	master.FakeMysqlDaemon.CurrentMasterPosition = &myproto.ReplicationPosition{
+		MasterLogGTIDField: myproto.GTIDField{Value: masterGTID},
+	}
+	master.FakeMysqlDaemon.Schema = &myproto.SchemaDefinition{
+		TableDefinitions: []myproto.TableDefinition{
+			{
+				Name:              "t",
+				Columns:           []string{"id", "msg"},
+				PrimaryKeyColumns: []string{"id"},
+				Type:              myproto.TABLE_BASE_TABLE,
+				DataLength:        1000000,
+			},
+			{
+				Name:              "_t_osc",
+				Columns:           []string{"id", "msg", "c"},
+				PrimaryKeyColumns: []string{"id"},
+				Type:              myproto.TABLE_BASE_TABLE,
+			},
+		},
+	}
+	master.StartActionLoop(t, wr)
+	defer master.StopActionLoop(t)
+
+	replica.FakeMysqlDaemon.CurrentSlaveStatus = &myproto.ReplicationPosition{
+		MasterLogGTIDField: myproto.GTIDField{Value: myproto.GoogleGTID{GroupID: 12}},
+	}
+	replica.StartActionLoop(t, wr)
+	defer replica.StopActionLoop(t)
+
+	oscw := NewOnlineSchemaChangeWorker(wr, "test_keyspace", nil, "t", "ADD COLUMN c INT", 3, 1000, time.Second, resume).(*OnlineSchemaChangeWorker)
+	oscw.Run()
+	if oscw.state != stateOSCDone {
+		return fmt.Errorf("worker ended in state %v: %v", oscw.state, oscw.Error())
+	}
+	return nil
+}
+
+func TestOnlineSchemaChange(t *testing.T) {
+	m := newFakeOSCMaster()
+	if err := runOnlineSchemaChange(t, m, false, myproto.GoogleGTID{GroupID: 12}); err != nil {
+		t.Fatal(err)
+	}
+
+	var want []string
+	want = append(want, shadowTableStatements...)
+	want = append(want, copyStatements(" WHERE id<20", "0")...)
+	want = append(want, copyStatements(" WHERE id>=20 AND id<30", "1")...)
+	want = append(want, copyStatements(" WHERE id>=30", "2")...)
+	want = append(want, cutoverStatements...)
+	if got := m.statements(); !reflect.DeepEqual(got, want) {
+		t.Errorf("online schema change ran:\n%v\nwant:\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestOnlineSchemaChangeResume(t *testing.T) {
+	m := newFakeOSCMaster()
+	m.job = [][]sqltypes.Value{
+		stringRow("OnlineSchemaChange", "test_keyspace/0/t", "cell1-0000000000", myproto.EncodeGTID(myproto.GoogleGTID{GroupID: 12})),
+	}
+	m.checkpoint = [][]sqltypes.Value{
+		stringRow("t", "0", "", "20", "1"),
+		stringRow("t", "1", "20", "30", "0"),
+		stringRow("t", "2", "30", "", "0"),
+	}
+	m.steps = [][]sqltypes.Value{stringRow("shadow_table")}
+	if err := runOnlineSchemaChange(t, m, true, myproto.GoogleGTID{GroupID: 12}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the shadow table and the first chunk are not done again
+	var want []string
+	want = append(want, copyStatements(" WHERE id>=20 AND id<30", "1")...)
+	want = append(want, copyStatements(" WHERE id>=30", "2")...)
+	want = append(want, cutoverStatements...)
+	if got := m.statements(); !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed online schema change ran:\n%v\nwant:\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestOnlineSchemaChangeResumeSetup(t *testing.T) {
+	// the job was interrupted before the shadow table was created
+	m := newFakeOSCMaster()
+	m.job = [][]sqltypes.Value{
+		stringRow("OnlineSchemaChange", "test_keyspace/0/t", "cell1-0000000000", myproto.EncodeGTID(myproto.GoogleGTID{GroupID: 12})),
+	}
+	if err := runOnlineSchemaChange(t, m, true, myproto.GoogleGTID{GroupID: 12}); err != nil {
+		t.Fatal(err)
+	}
+
+	var want []string
+	want = append(want, shadowTableStatements...)
+	want = append(want, copyStatements(" WHERE id<20", "0")...)
+	want = append(want, copyStatements(" WHERE id>=20 AND id<30", "1")...)
+	want = append(want, copyStatements(" WHERE id>=30", "2")...)
+	want = append(want, cutoverStatements...)
+	if got := m.statements(); !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed online schema change ran:\n%v\nwant:\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestOnlineSchemaChangeResumeBeforeJob(t *testing.T) {
+	m := newFakeOSCMaster()
+	m.job = [][]sqltypes.Value{
+		stringRow("OnlineSchemaChange", "test_keyspace/0/t", "cell1-0000000000", myproto.EncodeGTID(myproto.GoogleGTID{GroupID: 12})),
+	}
+	m.steps = [][]sqltypes.Value{stringRow("shadow_table")}
+	err := runOnlineSchemaChange(t, m, true, myproto.GoogleGTID{GroupID: 10})
+	if err == nil || !strings.Contains(err.Error(), "is before job position") {
+		t.Errorf("resume with the master before the job position: got %v, want a position error", err)
+	}
+}
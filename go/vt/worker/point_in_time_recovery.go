@@ -0,0 +1,240 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"fmt"
+	"html/template"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/binlog/binlogplayer"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/wrangler"
+)
+
+// This file contains the code to recover a shard into a scratch
+// tablet, as it was at a given GTID or time.
+
+type pitrWorkerState string
+
+const (
+	// all the states for the worker
+	PITRNotStarted pitrWorkerState = "not started"
+	PITRDone       pitrWorkerState = "done"
+	PITRError      pitrWorkerState = "error"
+	PITRRecovering pitrWorkerState = "restoring the backup and replaying the binlogs"
+	PITRCleanUp    pitrWorkerState = "cleaning up"
+)
+
+func (state pitrWorkerState) String() string {
+	return string(state)
+}
+
+// PointInTimeRecoveryWorker restores a shard into an idle tablet, as
+// it was at a given GTID or timestamp. While the binlogs are replayed,
+// it reports the position reached by the tablet.
+type PointInTimeRecoveryWorker struct {
+	wr                *wrangler.Wrangler
+	keyspace          string
+	shard             string
+	dstTabletAlias    topo.TabletAlias
+	sourceTabletAlias topo.TabletAlias
+	concurrency       int
+	stopAtGTID        myproto.GTID
+	stopAtTimestamp   int64
+	cleaner           *wrangler.Cleaner
+
+	// all subsequent fields are protected by the mutex
+	mu    sync.Mutex
+	state pitrWorkerState
+
+	// populated if state == PITRError
+	err error
+
+	// populated during PITRRecovering, from the blp_checkpoint
+	// table of the destination tablet
+	lastGTID      string
+	lastTimestamp int64
+
+	// populated if state == PITRDone
+	reached *myproto.ReplicationPosition
+}
+
+// NewPointInTimeRecoveryWorker returns a new PointInTimeRecoveryWorker
+// object. The recovery target is stopAtGTID if not nil, stopAtTimestamp
+// otherwise.
+func NewPointInTimeRecoveryWorker(wr *wrangler.Wrangler, keyspace, shard string, dstTabletAlias, sourceTabletAlias topo.TabletAlias, concurrency int, stopAtGTID myproto.GTID, stopAtTimestamp int64) Worker {
+	return &PointInTimeRecoveryWorker{
+		wr:                wr,
+		keyspace:          keyspace,
+		shard:             shard,
+		dstTabletAlias:    dstTabletAlias,
+		sourceTabletAlias: sourceTabletAlias,
+		concurrency:       concurrency,
+		stopAtGTID:        stopAtGTID,
+		stopAtTimestamp:   stopAtTimestamp,
+		cleaner:           new(wrangler.Cleaner),
+		state:             PITRNotStarted,
+	}
+}
+
+func (pitrw *PointInTimeRecoveryWorker) setState(state pitrWorkerState) {
+	pitrw.mu.Lock()
+	pitrw.state = state
+	pitrw.mu.Unlock()
+}
+
+func (pitrw *PointInTimeRecoveryWorker) recordError(err error) {
+	pitrw.mu.Lock()
+	defer pitrw.mu.Unlock()
+
+	pitrw.state = PITRError
+	pitrw.err = err
+}
+
+func (pitrw *PointInTimeRecoveryWorker) target() string {
+	if pitrw.stopAtGTID != nil {
+		return "GTID " + myproto.EncodeGTID(pitrw.stopAtGTID)
+	}
+	return "time " + time.Unix(pitrw.stopAtTimestamp, 0).UTC().Format(time.RFC3339)
+}
+
+func (pitrw *PointInTimeRecoveryWorker) progress() string {
+	if pitrw.lastGTID == "" {
+		return "no transaction replayed yet"
+	}
+	result := "replayed up to " + pitrw.lastGTID
+	if pitrw.lastTimestamp != 0 {
+		result += " (" + time.Unix(pitrw.lastTimestamp, 0).UTC().Format(time.RFC3339) + ")"
+	}
+	return result
+}
+
+func (pitrw *PointInTimeRecoveryWorker) StatusAsHTML() template.HTML {
+	pitrw.mu.Lock()
+	defer pitrw.mu.Unlock()
+
+	result := "<b>Working on:</b> " + pitrw.keyspace + "/" + pitrw.shard + " into " + pitrw.dstTabletAlias.String() + "</br>\n"
+	result += "<b>Target:</b> " + pitrw.target() + "</br>\n"
+	result += "<b>State:</b> " + pitrw.state.String() + "</br>\n"
+	switch pitrw.state {
+	case PITRError:
+		result += "<b>Error</b>: " + pitrw.err.Error() + "</br>\n"
+	case PITRRecovering:
+		result += "<b>Running</b>: " + pitrw.progress() + "</br>\n"
+	case PITRDone:
+		result += "<b>Success</b>: recovered up to " + pitrw.reached.MasterLogGTIDField.String() + "</br>\n"
+	}
+
+	return template.HTML(result)
+}
+
+func (pitrw *PointInTimeRecoveryWorker) StatusAsText() string {
+	pitrw.mu.Lock()
+	defer pitrw.mu.Unlock()
+
+	result := "Working on: " + pitrw.keyspace + "/" + pitrw.shard + " into " + pitrw.dstTabletAlias.String() + "\n"
+	result += "Target: " + pitrw.target() + "\n"
+	result += "State: " + pitrw.state.String() + "\n"
+	switch pitrw.state {
+	case PITRError:
+		result += "Error: " + pitrw.err.Error() + "\n"
+	case PITRRecovering:
+		result += "Running: " + pitrw.progress() + "\n"
+	case PITRDone:
+		result += "Success: recovered up to " + pitrw.reached.MasterLogGTIDField.String() + "\n"
+	}
+	return result
+}
+
+func (pitrw *PointInTimeRecoveryWorker) CheckInterrupted() bool {
+	select {
+	case <-interrupted:
+		pitrw.recordError(topo.ErrInterrupted)
+		return true
+	default:
+	}
+	return false
+}
+
+// Run is mostly a wrapper to run the cleanup at the end.
+func (pitrw *PointInTimeRecoveryWorker) Run() {
+	err := pitrw.run()
+
+	pitrw.setState(PITRCleanUp)
+	cerr := pitrw.cleaner.CleanUp(pitrw.wr)
+	if cerr != nil {
+		if err != nil {
+			log.Errorf("CleanUp failed in addition to job error: %v", cerr)
+		} else {
+			err = cerr
+		}
+	}
+	if err != nil {
+		pitrw.recordError(err)
+		return
+	}
+	pitrw.setState(PITRDone)
+}
+
+func (pitrw *PointInTimeRecoveryWorker) Error() error {
+	return pitrw.err
+}
+
+func (pitrw *PointInTimeRecoveryWorker) run() error {
+	if pitrw.CheckInterrupted() {
+		return topo.ErrInterrupted
+	}
+	pitrw.setState(PITRRecovering)
+
+	// the recovery is a single tablet action, we poll the
+	// destination tablet for progress while it runs
+	done := make(chan struct{})
+	var reached *myproto.ReplicationPosition
+	var err error
+	go func() {
+		reached, err = pitrw.wr.PointInTimeRecovery(pitrw.dstTabletAlias, pitrw.keyspace, pitrw.shard, pitrw.sourceTabletAlias, pitrw.concurrency, pitrw.stopAtGTID, pitrw.stopAtTimestamp)
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			if err != nil {
+				return err
+			}
+			pitrw.mu.Lock()
+			pitrw.reached = reached
+			pitrw.mu.Unlock()
+			return nil
+		case <-time.After(5 * time.Second):
+			pitrw.updateProgress()
+		}
+	}
+}
+
+// updateProgress reads the position of the binlog player from the
+// destination tablet. It fails while the backup is being restored.
+func (pitrw *PointInTimeRecoveryWorker) updateProgress() {
+	ti, err := pitrw.wr.TopoServer().GetTablet(pitrw.dstTabletAlias)
+	if err != nil {
+		return
+	}
+	qr, err := pitrw.wr.ActionInitiator().ExecuteFetch(ti, fmt.Sprintf("SELECT gtid, transaction_timestamp FROM _vt.blp_checkpoint WHERE source_shard_uid=%v", binlogplayer.BLP_POINT_IN_TIME_RECOVERY_UID), 1, false, false, 30*time.Second)
+	if err != nil || len(qr.Rows) != 1 {
+		return
+	}
+	timestamp, err := qr.Rows[0][1].ParseInt64()
+	if err != nil {
+		return
+	}
+
+	pitrw.mu.Lock()
+	pitrw.lastGTID = qr.Rows[0][0].String()
+	pitrw.lastTimestamp = timestamp
+	pitrw.mu.Unlock()
+}
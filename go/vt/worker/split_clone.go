@@ -0,0 +1,745 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/vt/binlog/binlogplayer"
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/mysqlctl"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/wrangler"
+)
+
+const (
+	// all the states for the worker
+	stateSCNotSarted = "not started"
+	stateSCDone      = "done"
+	stateSCError     = "error"
+
+	stateSCInit        = "initializing"
+	stateSCFindTargets = "finding target instances"
+	stateSCCopy        = "copying the data"
+	stateSCCleanUp     = "cleaning up"
+)
+
+// SplitCloneWorker will clone the data from a source shard to the
+// destination shards of a horizontal split. Each row is sent to the
+// destination shard that has its keyspace_id.
+type SplitCloneWorker struct {
+	wr                     *wrangler.Wrangler
+	cell                   string
+	keyspace               string
+	shard                  string
+	excludeTables          []string
+	strategy               string
+	sourceReaderCount      int
+	minTableSizeForSplit   uint64
+	destinationWriterCount int
+	resume                 bool
+	cleaner                *wrangler.Cleaner
+
+	// all subsequent fields are protected by the mutex
+	mu    sync.Mutex
+	state string
+
+	// populated if state == stateSCError
+	err error
+
+	// populated during stateSCInit, read-only after that
+	keyspaceInfo      *topo.KeyspaceInfo
+	sourceShard       *topo.ShardInfo
+	destinationShards []*topo.ShardInfo
+
+	// populated during stateSCFindTargets, read-only after that
+	sourceAlias              topo.TabletAlias
+	sourceTablet             *topo.TabletInfo
+	destinationAliases       [][]topo.TabletAlias
+	destinationTablets       []map[topo.TabletAlias]*topo.TabletInfo
+	destinationMasterAliases []topo.TabletAlias
+	checkpoint               *cloneCheckpoint
+
+	// populated during stateSCCopy
+	tableStatus []tableStatus
+	startTime   time.Time
+
+	// resumable is set once the job checkpoint is started or
+	// loaded: if the job fails after that, the source is left
+	// stopped at the job position so the job can be resumed.
+	resumable bool
+}
+
+// NewSplitCloneWorker returns a new SplitCloneWorker object.
+// If resume is set, the worker continues the job that was previously
+// started on the destination masters, using its checkpoint.
+func NewSplitCloneWorker(wr *wrangler.Wrangler, cell, keyspace, shard string, excludeTables []string, strategy string, sourceReaderCount int, minTableSizeForSplit uint64, destinationWriterCount int, resume bool) Worker {
+	return &SplitCloneWorker{
+		wr:                     wr,
+		cell:                   cell,
+		keyspace:               keyspace,
+		shard:                  shard,
+		excludeTables:          excludeTables,
+		strategy:               strategy,
+		sourceReaderCount:      sourceReaderCount,
+		minTableSizeForSplit:   minTableSizeForSplit,
+		destinationWriterCount: destinationWriterCount,
+		resume:                 resume,
+		cleaner:                &wrangler.Cleaner{},
+
+		state: stateSCNotSarted,
+	}
+}
+
+func (scw *SplitCloneWorker) setState(state string) {
+	scw.mu.Lock()
+	scw.state = state
+	scw.mu.Unlock()
+}
+
+func (scw *SplitCloneWorker) recordError(err error) {
+	scw.mu.Lock()
+	scw.state = stateSCError
+	scw.err = err
+	scw.mu.Unlock()
+}
+
+// destinationShardNames returns the names of the destination shards,
+// for display. It must be called with the mutex held.
+func (scw *SplitCloneWorker) destinationShardNames() string {
+	names := make([]string, len(scw.destinationShards))
+	for i, si := range scw.destinationShards {
+		names[i] = si.ShardName()
+	}
+	return strings.Join(names, ", ")
+}
+
+// StatusAsHTML implements the Worker interface
+func (scw *SplitCloneWorker) StatusAsHTML() template.HTML {
+	scw.mu.Lock()
+	defer scw.mu.Unlock()
+	result := "<b>Working on:</b> " + scw.keyspace + "/" + scw.shard + "</br>\n"
+	result += "<b>State:</b> " + scw.state + "</br>\n"
+	switch scw.state {
+	case stateSCError:
+		result += "<b>Error</b>: " + scw.err.Error() + "</br>\n"
+	case stateSCCopy:
+		result += "<b>Running</b>:</br>\n"
+		result += "<b>Copying from</b>: " + scw.sourceAlias.String() + "</br>\n"
+		result += "<b>Copying to</b>: " + scw.destinationShardNames() + "</br>\n"
+		statuses, eta := formatTableStatuses(scw.tableStatus, scw.startTime)
+		result += "<b>ETA</b>: " + eta.String() + "</br>\n"
+		result += strings.Join(statuses, "</br>\n")
+	case stateSCDone:
+		result += "<b>Success</b>:</br>\n"
+		statuses, _ := formatTableStatuses(scw.tableStatus, scw.startTime)
+		result += strings.Join(statuses, "</br>\n")
+	}
+
+	return template.HTML(result)
+}
+
+// StatusAsText implements the Worker interface
+func (scw *SplitCloneWorker) StatusAsText() string {
+	scw.mu.Lock()
+	defer scw.mu.Unlock()
+	result := "Working on: " + scw.keyspace + "/" + scw.shard + "\n"
+	result += "State: " + scw.state + "\n"
+	switch scw.state {
+	case stateSCError:
+		result += "Error: " + scw.err.Error() + "\n"
+	case stateSCCopy:
+		result += "Running:\n"
+		result += "Copying from: " + scw.sourceAlias.String() + "\n"
+		result += "Copying to: " + scw.destinationShardNames() + "\n"
+		statuses, eta := formatTableStatuses(scw.tableStatus, scw.startTime)
+		result += "ETA: " + eta.String() + "\n"
+		result += strings.Join(statuses, "\n")
+	case stateSCDone:
+		result += "Success:\n"
+		statuses, _ := formatTableStatuses(scw.tableStatus, scw.startTime)
+		result += strings.Join(statuses, "\n")
+	}
+	return result
+}
+
+func (scw *SplitCloneWorker) CheckInterrupted() bool {
+	select {
+	case <-interrupted:
+		scw.recordError(topo.ErrInterrupted)
+		return true
+	default:
+	}
+	return false
+}
+
+// Run implements the Worker interface
+func (scw *SplitCloneWorker) Run() {
+	err := scw.run()
+
+	scw.setState(stateSCCleanUp)
+	cerr := scw.cleaner.CleanUp(scw.wr)
+	if cerr != nil {
+		if err != nil {
+			log.Errorf("CleanUp failed in addition to job error: %v", cerr)
+		} else {
+			err = cerr
+		}
+	}
+	if err != nil {
+		scw.recordError(err)
+		return
+	}
+	scw.setState(stateSCDone)
+}
+
+func (scw *SplitCloneWorker) Error() error {
+	return scw.err
+}
+
+func (scw *SplitCloneWorker) run() error {
+	// first state: read what we need to do
+	if err := scw.init(); err != nil {
+		return fmt.Errorf("init() failed: %v", err)
+	}
+	if scw.CheckInterrupted() {
+		return topo.ErrInterrupted
+	}
+
+	// second state: find targets
+	if err := scw.findTargets(); err != nil {
+		return fmt.Errorf("findTargets() failed: %v", err)
+	}
+	if scw.CheckInterrupted() {
+		return topo.ErrInterrupted
+	}
+
+	// third state: copy data
+	if err := scw.copy(); err != nil {
+		if scw.resumable {
+			keepCheckerStopped(scw.cleaner, scw.sourceAlias)
+		}
+		return fmt.Errorf("copy() failed: %v", err)
+	}
+	if scw.CheckInterrupted() {
+		return topo.ErrInterrupted
+	}
+
+	return nil
+}
+
+// init phase:
+// - read the keyspace, make sure it is sharded
+// - read the source shard
+// - find the destination shards, inside the source shard KeyRange
+func (scw *SplitCloneWorker) init() error {
+	scw.setState(stateSCInit)
+
+	var err error
+	scw.keyspaceInfo, err = scw.wr.TopoServer().GetKeyspace(scw.keyspace)
+	if err != nil {
+		return fmt.Errorf("cannot read keyspace %v: %v", scw.keyspace, err)
+	}
+	if scw.keyspaceInfo.ShardingColumnName == "" {
+		return fmt.Errorf("keyspace %v has no ShardingColumnName", scw.keyspace)
+	}
+	if scw.keyspaceInfo.ShardingColumnType != key.KIT_UINT64 && scw.keyspaceInfo.ShardingColumnType != key.KIT_BYTES {
+		return fmt.Errorf("keyspace %v has an unsupported ShardingColumnType: %v", scw.keyspace, scw.keyspaceInfo.ShardingColumnType)
+	}
+
+	scw.sourceShard, err = scw.wr.TopoServer().GetShard(scw.keyspace, scw.shard)
+	if err != nil {
+		return fmt.Errorf("cannot read shard %v/%v: %v", scw.keyspace, scw.shard, err)
+	}
+
+	shardNames, err := scw.wr.TopoServer().GetShardNames(scw.keyspace)
+	if err != nil {
+		return fmt.Errorf("cannot read shard names for keyspace %v: %v", scw.keyspace, err)
+	}
+	for _, shardName := range shardNames {
+		if shardName == scw.shard {
+			continue
+		}
+		si, err := scw.wr.TopoServer().GetShard(scw.keyspace, shardName)
+		if err != nil {
+			return fmt.Errorf("cannot read shard %v/%v: %v", scw.keyspace, shardName, err)
+		}
+		overlap, err := key.KeyRangesOverlap(scw.sourceShard.KeyRange, si.KeyRange)
+		if err != nil || overlap != si.KeyRange {
+			// not inside the source shard
+			continue
+		}
+		scw.destinationShards = append(scw.destinationShards, si)
+	}
+	if len(scw.destinationShards) == 0 {
+		return fmt.Errorf("shard %v/%v has no destination shard inside its KeyRange", scw.keyspace, scw.shard)
+	}
+	for _, si := range scw.destinationShards {
+		if len(si.SourceShards) > 0 {
+			return fmt.Errorf("destination shard %v/%v already has SourceShards", si.Keyspace(), si.ShardName())
+		}
+	}
+
+	return nil
+}
+
+// findTargets phase:
+// - get the aliases of all the targets, and their masters
+// - find one rdonly in the source shard, or reuse the job's one if resuming
+// - mark it as 'checker' pointing back to us
+func (scw *SplitCloneWorker) findTargets() error {
+	scw.setState(stateSCFindTargets)
+
+	// find all the targets in the destination shards
+	var err error
+	scw.destinationAliases = make([][]topo.TabletAlias, len(scw.destinationShards))
+	scw.destinationTablets = make([]map[topo.TabletAlias]*topo.TabletInfo, len(scw.destinationShards))
+	scw.destinationMasterAliases = make([]topo.TabletAlias, len(scw.destinationShards))
+	for shardIndex, si := range scw.destinationShards {
+		scw.destinationAliases[shardIndex], err = topo.FindAllTabletAliasesInShard(scw.wr.TopoServer(), si.Keyspace(), si.ShardName())
+		if err != nil {
+			return fmt.Errorf("cannot find all target tablets in %v/%v: %v", si.Keyspace(), si.ShardName(), err)
+		}
+		log.Infof("Found %v target aliases in shard %v/%v", len(scw.destinationAliases[shardIndex]), si.Keyspace(), si.ShardName())
+
+		// get the TabletInfo for all targets
+		scw.destinationTablets[shardIndex], err = topo.GetTabletMap(scw.wr.TopoServer(), scw.destinationAliases[shardIndex])
+		if err != nil {
+			return fmt.Errorf("cannot read all target tablets in %v/%v: %v", si.Keyspace(), si.ShardName(), err)
+		}
+
+		// find and validate the master
+		for tabletAlias, ti := range scw.destinationTablets[shardIndex] {
+			if ti.Type == topo.TYPE_MASTER {
+				if scw.destinationMasterAliases[shardIndex].IsZero() {
+					scw.destinationMasterAliases[shardIndex] = tabletAlias
+				} else {
+					return fmt.Errorf("multiple masters in destination shard %v/%v: %v and %v at least", si.Keyspace(), si.ShardName(), scw.destinationMasterAliases[shardIndex], tabletAlias)
+				}
+			}
+		}
+		if scw.destinationMasterAliases[shardIndex].IsZero() {
+			return fmt.Errorf("no master in destination shard %v/%v", si.Keyspace(), si.ShardName())
+		}
+	}
+
+	// The job checkpoint is stored on all destination masters. It
+	// records the source tablet and its position when the job
+	// started, and the chunks that are already copied.
+	destinationMasters := make([]*topo.TabletInfo, len(scw.destinationShards))
+	for shardIndex := range scw.destinationShards {
+		destinationMasters[shardIndex] = scw.destinationTablets[shardIndex][scw.destinationMasterAliases[shardIndex]]
+	}
+	scw.checkpoint = newCloneCheckpoint(scw.wr, "SplitClone", scw.keyspace+"/"+scw.shard, destinationMasters, false)
+
+	if scw.resume {
+		// use the source the job was started with, that was
+		// left stopped at the job position
+		if err := scw.checkpoint.load(); err != nil {
+			return fmt.Errorf("cannot resume: %v", err)
+		}
+		scw.sourceAlias = scw.checkpoint.sourceTablet
+		scw.sourceTablet, err = resumeChecker(scw.wr, scw.cleaner, scw.sourceAlias)
+		if err != nil {
+			return fmt.Errorf("cannot resume from source %v: %v", scw.sourceAlias, err)
+		}
+		log.Infof("Using tablet %v as the source", scw.sourceAlias)
+		return nil
+	}
+
+	// find an appropriate endpoint in the source shard
+	scw.sourceAlias, err = findChecker(scw.wr, scw.cleaner, scw.cell, scw.keyspace, scw.shard)
+	if err != nil {
+		return fmt.Errorf("cannot find checker for %v/%v/%v: %v", scw.cell, scw.keyspace, scw.shard, err)
+	}
+	log.Infof("Using tablet %v as the source", scw.sourceAlias)
+
+	// get the tablet info for it
+	scw.sourceTablet, err = scw.wr.TopoServer().GetTablet(scw.sourceAlias)
+	if err != nil {
+		return fmt.Errorf("cannot read tablet %v: %v", scw.sourceAlias, err)
+	}
+	return nil
+}
+
+// copyChunk streams the rows of a chunk of a table that are in the
+// KeyRange from the source, and sends them to the insertChannel of the
+// destination shard. It returns nil without waiting for the inserts,
+// or if the copy is aborted.
+func (scw *SplitCloneWorker) copyChunk(td *myproto.TableDefinition, tableIndex int, chunks []string, chunkIndex int, keyRange key.KeyRange, insertChannel chan insertCmd, chunkWaitGroup *sync.WaitGroup, abort chan struct{}) error {
+	selectSQL, err := buildSQLFromChunksByKeyRange(td, chunks, chunkIndex, scw.keyspaceInfo.ShardingColumnName, keyRange, scw.keyspaceInfo.ShardingColumnType)
+	if err != nil {
+		return fmt.Errorf("table %v: %v", td.Name, err)
+	}
+	qrr, err := NewQueryResultReaderForTablet(scw.wr.TopoServer(), scw.sourceAlias, selectSQL)
+	if err != nil {
+		return fmt.Errorf("NewQueryResultReaderForTablet failed: %v", err)
+	}
+	defer qrr.Close()
+
+	baseCmd := td.Name + "(" + strings.Join(td.Columns, ", ") + ") VALUES "
+	for {
+		select {
+		case r, ok := <-qrr.Output:
+			if !ok {
+				if err := qrr.Error(); err != nil {
+					return fmt.Errorf("QueryResultReader failed: %v", err)
+				}
+				return nil
+			}
+			if len(r.Rows) == 0 {
+				continue
+			}
+			cmd := insertCmd{baseCmd + makeValueString(qrr.Fields, r), chunkWaitGroup}
+			chunkWaitGroup.Add(1)
+			select {
+			case insertChannel <- cmd:
+			case <-abort:
+				return nil
+			}
+			scw.tableStatus[tableIndex].addCopiedRows(len(r.Rows))
+		case <-abort:
+			return nil
+		}
+	}
+}
+
+// copy phase:
+// - get schema on the source, filter tables
+// - stop replication on the source, and get its position
+// - create tables on all destination masters, or check the source position if resuming
+// - copy each destination shard's rows with a key range scan, checkpointing each chunk
+// - set up SourceShards and blp_checkpoint for filtered replication
+func (scw *SplitCloneWorker) copy() error {
+	scw.setState(stateSCCopy)
+
+	// get source schema
+	sourceSchemaDefinition, err := scw.wr.GetSchema(scw.sourceAlias, nil, scw.excludeTables, true)
+	if err != nil {
+		return fmt.Errorf("cannot get schema from source %v: %v", scw.sourceAlias, err)
+	}
+	if len(sourceSchemaDefinition.TableDefinitions) == 0 {
+		return fmt.Errorf("no tables matching the table filter in tablet %v", scw.sourceAlias)
+	}
+	for _, td := range sourceSchemaDefinition.TableDefinitions {
+		if td.Type == myproto.TABLE_BASE_TABLE && !stringInList(scw.keyspaceInfo.ShardingColumnName, td.Columns) {
+			return fmt.Errorf("table %v doesn't have a %v column", td.Name, scw.keyspaceInfo.ShardingColumnName)
+		}
+	}
+	log.Infof("Source tablet has %v tables to copy", len(sourceSchemaDefinition.TableDefinitions))
+	scw.mu.Lock()
+	scw.tableStatus = make([]tableStatus, len(sourceSchemaDefinition.TableDefinitions))
+	for i, td := range sourceSchemaDefinition.TableDefinitions {
+		scw.tableStatus[i].name = td.Name
+		scw.tableStatus[i].rowCount = td.RowCount
+	}
+	scw.startTime = time.Now()
+	scw.mu.Unlock()
+
+	// Create all the commands to create the destination schema:
+	// - createDbCmds will create the database and the tables
+	// - createViewCmds will create the views
+	// - alterTablesCmds will modify the tables at the end if needed
+	// (all need template substitution for {{.DatabaseName}})
+	createDbCmds := make([]string, 0, len(sourceSchemaDefinition.TableDefinitions)+1)
+	createDbCmds = append(createDbCmds, sourceSchemaDefinition.DatabaseSchema)
+	createViewCmds := make([]string, 0, 16)
+	alterTablesCmds := make([]string, 0, 16)
+	for i, td := range sourceSchemaDefinition.TableDefinitions {
+		scw.tableStatus[i].mu.Lock()
+		if td.Type == myproto.TABLE_BASE_TABLE {
+			create, alter, err := mysqlctl.MakeSplitCreateTableSql(td.Schema, "{{.DatabaseName}}", td.Name, scw.strategy)
+			if err != nil {
+				scw.tableStatus[i].mu.Unlock()
+				return fmt.Errorf("MakeSplitCreateTableSql(%v) returned: %v", td.Name, err)
+			}
+			createDbCmds = append(createDbCmds, create)
+			if alter != "" {
+				alterTablesCmds = append(alterTablesCmds, alter)
+			}
+			scw.tableStatus[i].state = "before table creation"
+			scw.tableStatus[i].rowCount = td.RowCount
+		} else {
+			createViewCmds = append(createViewCmds, td.Schema)
+			scw.tableStatus[i].state = "before view creation"
+			scw.tableStatus[i].rowCount = 0
+		}
+		scw.tableStatus[i].mu.Unlock()
+	}
+
+	// The data goes through the destination masters, with binlogs
+	// enabled, so the other tablets in the destination shards get it
+	// through replication.
+	//
+	// mu protects the abort channel for closing, and firstError
+	mu := sync.Mutex{}
+	abort := make(chan struct{})
+	var firstError error
+
+	processError := func(format string, args ...interface{}) {
+		log.Errorf(format, args...)
+		mu.Lock()
+		if firstError == nil {
+			firstError = fmt.Errorf(format, args...)
+			close(abort)
+		}
+		mu.Unlock()
+	}
+
+	checkpoint := scw.checkpoint
+	destinationMasters := checkpoint.tablets
+	if scw.resume {
+		// The schema is already there, and the checkpoint is
+		// loaded. Make sure the source didn't move.
+		scw.resumable = true
+		sourcePos, err := scw.wr.ActionInitiator().SlavePosition(scw.sourceTablet, 30*time.Second)
+		if err != nil {
+			return fmt.Errorf("cannot get position of source %v: %v", scw.sourceAlias, err)
+		}
+		if err := checkpoint.checkPosition(sourcePos.MasterLogGTIDField.Value); err != nil {
+			scw.resumable = false
+			return fmt.Errorf("cannot resume from source %v: %v", scw.sourceAlias, err)
+		}
+		log.Infof("Resuming copy from source %v", scw.sourceAlias)
+	} else {
+		// The source doesn't replicate during the copy, so all
+		// the data is at the same position, where filtered
+		// replication starts.
+		sourcePos, err := stopChecker(scw.wr, scw.cleaner, scw.sourceTablet)
+		if err != nil {
+			return err
+		}
+
+		// create the schema on all destination masters (in parallel)
+		destinationWaitGroup := sync.WaitGroup{}
+		for _, ti := range destinationMasters {
+			destinationWaitGroup.Add(1)
+			go func(ti *topo.TabletInfo) {
+				defer destinationWaitGroup.Done()
+				log.Infof("Creating tables on tablet %v", ti.Alias)
+				if err := runSqlCommands(scw.wr, ti, createDbCmds, abort, false); err != nil {
+					processError("createDbCmds failed: %v", err)
+					return
+				}
+				if len(createViewCmds) > 0 {
+					log.Infof("Creating views on tablet %v", ti.Alias)
+					if err := runSqlCommands(scw.wr, ti, createViewCmds, abort, false); err != nil {
+						processError("createViewCmds failed: %v", err)
+						return
+					}
+				}
+			}(ti)
+		}
+		destinationWaitGroup.Wait()
+		if firstError != nil {
+			return firstError
+		}
+		if err := checkpoint.start(sourcePos.MasterLogGTIDField.Value, scw.sourceAlias, abort); err != nil {
+			return fmt.Errorf("cannot start job checkpoint: %v", err)
+		}
+		scw.resumable = true
+	}
+
+	// For each destination master (in parallel), setup the channels
+	// to send SQL data chunks.
+	insertChannels := make([]chan insertCmd, len(scw.destinationShards))
+	destinationWaitGroup := sync.WaitGroup{}
+	for shardIndex := range scw.destinationShards {
+		// we create one channel per destination shard.  It
+		// is sized to have a buffer of a maximum of
+		// destinationWriterCount * 2 items, to hopefully
+		// always have data. We then have
+		// destinationWriterCount go routines reading from it.
+		insertChannels[shardIndex] = make(chan insertCmd, scw.destinationWriterCount*2)
+
+		for j := 0; j < scw.destinationWriterCount; j++ {
+			destinationWaitGroup.Add(1)
+			go func(ti *topo.TabletInfo, insertChannel chan insertCmd) {
+				defer destinationWaitGroup.Done()
+				for {
+					select {
+					case cmd, ok := <-insertChannel:
+						if !ok {
+							return
+						}
+						_, err := scw.wr.ActionInitiator().ExecuteFetch(ti, "INSERT INTO `"+ti.DbName()+"`."+cmd.sql, 0, false, false, 30*time.Second)
+						cmd.done()
+						if err != nil {
+							processError("ExecuteFetch failed: %v", err)
+							return
+						}
+					case <-abort:
+						return
+					}
+				}
+			}(destinationMasters[shardIndex], insertChannels[shardIndex])
+		}
+	}
+
+	// Now for each table, read data chunks and send them to the
+	// right insertChannels
+	sourceWaitGroup := sync.WaitGroup{}
+	sema := sync2.NewSemaphore(scw.sourceReaderCount, 0)
+	for tableIndex, td := range sourceSchemaDefinition.TableDefinitions {
+		if td.Type == myproto.TABLE_VIEW {
+			scw.tableStatus[tableIndex].setState("view created")
+			continue
+		}
+
+		scw.tableStatus[tableIndex].setState("before copy")
+		chunks, resumed := checkpoint.tableChunks(td.Name)
+		if !resumed {
+			chunks, err = findChunks(scw.wr, scw.sourceTablet, &td, scw.minTableSizeForSplit, scw.sourceReaderCount)
+			if err != nil {
+				return err
+			}
+			if err := checkpoint.recordChunks(td.Name, chunks, abort); err != nil {
+				return err
+			}
+		}
+
+		for chunkIndex := 0; chunkIndex < len(chunks)-1; chunkIndex++ {
+			if checkpoint.isDone(td.Name, chunkIndex) {
+				log.Infof("Skipping chunk %v of table %v, already copied", chunkIndex, td.Name)
+				continue
+			}
+
+			sourceWaitGroup.Add(1)
+			go func(td myproto.TableDefinition, tableIndex, chunkIndex int) {
+				defer sourceWaitGroup.Done()
+
+				sema.Acquire()
+				defer sema.Release()
+
+				// a previous run may have copied part of the chunk
+				if resumed {
+					if err := checkpoint.runOnAllTablets([]string{buildDeleteFromChunks(&td, chunks, chunkIndex)}, abort); err != nil {
+						processError("cannot clean up chunk %v of table %v: %v", chunkIndex, td.Name, err)
+						return
+					}
+				}
+
+				scw.tableStatus[tableIndex].setState("started the copy")
+
+				// read the rows of each destination shard with a
+				// key range scan, and send them to its master
+				chunkWaitGroup := sync.WaitGroup{}
+				for shardIndex, si := range scw.destinationShards {
+					if err := scw.copyChunk(&td, tableIndex, chunks, chunkIndex, si.KeyRange, insertChannels[shardIndex], &chunkWaitGroup, abort); err != nil {
+						processError("%v", err)
+						return
+					}
+				}
+
+				// wait for all the rows to be inserted, and save
+				// the progress
+				if !waitForChunk(&chunkWaitGroup, abort) {
+					return
+				}
+				if err := checkpoint.markDone(td.Name, chunkIndex, abort); err != nil {
+					processError("cannot checkpoint chunk %v of table %v: %v", chunkIndex, td.Name, err)
+				}
+			}(td, tableIndex, chunkIndex)
+		}
+	}
+	sourceWaitGroup.Wait()
+
+	for _, c := range insertChannels {
+		close(c)
+	}
+	destinationWaitGroup.Wait()
+	if firstError != nil {
+		return firstError
+	}
+
+	// do the post-copy alters if any
+	if len(alterTablesCmds) > 0 {
+		for shardIndex := range scw.destinationShards {
+			destinationWaitGroup.Add(1)
+			go func(ti *topo.TabletInfo) {
+				defer destinationWaitGroup.Done()
+				log.Infof("Altering tables on tablet %v", ti.Alias)
+				if err := runSqlCommands(scw.wr, ti, alterTablesCmds, abort, false); err != nil {
+					processError("alterTablesCmds failed on tablet %v: %v", ti.Alias, err)
+				}
+			}(scw.destinationTablets[shardIndex][scw.destinationMasterAliases[shardIndex]])
+		}
+		destinationWaitGroup.Wait()
+		if firstError != nil {
+			return firstError
+		}
+	}
+
+	// then create and populate the blp_checkpoint table
+	if strings.Index(scw.strategy, "populateBlpCheckpoint") != -1 {
+		queries := make([]string, 0, 4)
+		queries = append(queries, binlogplayer.CreateBlpCheckpoint()...)
+		if scw.resume {
+			// a previous run may have populated it already
+			queries = append(queries, "DELETE FROM _vt.blp_checkpoint WHERE source_shard_uid=0")
+		}
+		flags := ""
+		if strings.Index(scw.strategy, "dontStartBinlogPlayer") != -1 {
+			flags = binlogplayer.BLP_FLAG_DONT_START
+		}
+		queries = append(queries, binlogplayer.PopulateBlpCheckpoint(0, checkpoint.gtid, time.Now().Unix(), flags))
+		for shardIndex := range scw.destinationShards {
+			destinationWaitGroup.Add(1)
+			go func(ti *topo.TabletInfo) {
+				defer destinationWaitGroup.Done()
+				log.Infof("Making and populating blp_checkpoint table on tablet %v", ti.Alias)
+				if err := runSqlCommands(scw.wr, ti, queries, abort, false); err != nil {
+					processError("blp_checkpoint queries failed on tablet %v: %v", ti.Alias, err)
+				}
+			}(scw.destinationTablets[shardIndex][scw.destinationMasterAliases[shardIndex]])
+		}
+		destinationWaitGroup.Wait()
+		if firstError != nil {
+			return firstError
+		}
+	}
+
+	// Now we're done with data copy, update the shards' source info.
+	for _, si := range scw.destinationShards {
+		log.Infof("Setting SourceShard on shard %v/%v", si.Keyspace(), si.ShardName())
+		if err := scw.wr.SetSourceShards(si.Keyspace(), si.ShardName(), []topo.TabletAlias{scw.sourceAlias}, nil); err != nil {
+			return fmt.Errorf("Failed to set source shards: %v", err)
+		}
+	}
+
+	// And force a schema reload on all destination tablets.
+	// The master tablets will end up starting filtered replication
+	// at this point.
+	for shardIndex := range scw.destinationShards {
+		for _, tabletAlias := range scw.destinationAliases[shardIndex] {
+			destinationWaitGroup.Add(1)
+			go func(ti *topo.TabletInfo) {
+				defer destinationWaitGroup.Done()
+				log.Infof("Reloading schema on tablet %v", ti.Alias)
+				if err := scw.wr.ActionInitiator().ReloadSchema(ti, 30*time.Second); err != nil {
+					processError("ReloadSchema failed on tablet %v: %v", ti.Alias, err)
+				}
+			}(scw.destinationTablets[shardIndex][tabletAlias])
+		}
+	}
+	destinationWaitGroup.Wait()
+	return firstError
+}
+
+func stringInList(value string, list []string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
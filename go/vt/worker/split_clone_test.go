@@ -0,0 +1,506 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	rpc "github.com/youtube/vitess/go/rpcplus"
+	"github.com/youtube/vitess/go/rpcwrap"
+	"github.com/youtube/vitess/go/rpcwrap/bsonrpc"
+	rpcproto "github.com/youtube/vitess/go/rpcwrap/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/memorytopo"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/wrangler"
+	"github.com/youtube/vitess/go/vt/wrangler/testlib"
+)
+
+// cloneRow is a row of the table 't' of the source.
+type cloneRow struct {
+	id         int
+	msg        string
+	keyspaceId uint64
+}
+
+var cloneRows = []cloneRow{
+	{10, "a", 0x1000000000000000},
+	{15, "b", 0x9000000000000000},
+	{20, "c", 0x2000000000000000},
+	{30, "d", 0xa000000000000000},
+	{35, "e", 0x3000000000000000},
+	{40, "f", 0xb000000000000000},
+}
+
+var (
+	cloneIdStart  = regexp.MustCompile(`id>=(\d+)`)
+	cloneIdEnd    = regexp.MustCompile(`id<(\d+)`)
+	cloneKeyStart = regexp.MustCompile(`keyspace_id >= 0x([0-9a-f]+)`)
+	cloneKeyEnd   = regexp.MustCompile(`keyspace_id < 0x([0-9a-f]+)`)
+)
+
+// fakeCloneSource answers the streaming queries of the clone workers
+// on the source rdonly, for the rows in cloneRows. It only knows the
+// conditions built by the workers on 'id' and 'keyspace_id'.
+type fakeCloneSource struct {
+	mu      sync.Mutex
+	queries []string
+
+	// replicating tells if the source replicates, the queries
+	// are rejected if it does
+	replicating func() bool
+}
+
+func (s *fakeCloneSource) GetSessionId(sessionParams *tproto.SessionParams, sessionInfo *tproto.SessionInfo) error {
+	sessionInfo.SessionId = 1
+	return nil
+}
+
+func (s *fakeCloneSource) StreamExecute(ctx *rpcproto.Context, query *tproto.Query, sendReply func(reply interface{}) error) error {
+	s.mu.Lock()
+	s.queries = append(s.queries, query.Sql)
+	s.mu.Unlock()
+	if s.replicating() {
+		return fmt.Errorf("source is replicating during the copy")
+	}
+
+	if err := sendReply(&mproto.QueryResult{
+		Fields: []mproto.Field{
+			{Name: "id", Type: mproto.VT_LONGLONG},
+			{Name: "msg", Type: mproto.VT_VAR_STRING},
+			{Name: "keyspace_id", Type: mproto.VT_LONGLONG},
+		},
+	}); err != nil {
+		return err
+	}
+	qr := &mproto.QueryResult{}
+	for _, row := range cloneRows {
+		if matchesBound(cloneIdStart, query.Sql, uint64(row.id), 10, true) &&
+			matchesBound(cloneIdEnd, query.Sql, uint64(row.id), 10, false) &&
+			matchesBound(cloneKeyStart, query.Sql, row.keyspaceId, 16, true) &&
+			matchesBound(cloneKeyEnd, query.Sql, row.keyspaceId, 16, false) {
+			qr.Rows = append(qr.Rows, stringRow(strconv.Itoa(row.id), row.msg, strconv.FormatUint(row.keyspaceId, 10)))
+		}
+	}
+	return sendReply(qr)
+}
+
+// matchesBound returns true if the value is in the bound of the query
+// found by re, if any.
+func matchesBound(re *regexp.Regexp, sql string, value uint64, base int, start bool) bool {
+	m := re.FindStringSubmatch(sql)
+	if m == nil {
+		return true
+	}
+	bound, err := strconv.ParseUint(m[1], base, 64)
+	if err != nil {
+		panic(err)
+	}
+	if start {
+		return value >= bound
+	}
+	return value < bound
+}
+
+// sortedQueries returns the queries the source received.
+func (s *fakeCloneSource) sortedQueries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := append([]string(nil), s.queries...)
+	sort.Strings(result)
+	return result
+}
+
+// reset forgets the queries the source received.
+func (s *fakeCloneSource) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = nil
+}
+
+// serve starts a bson rpc server for the source, and returns the
+// option that points the tablet to it.
+func (s *fakeCloneSource) serve(t *testing.T) (testlib.TabletOption, func()) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("SqlQuery", s); err != nil {
+		t.Fatalf("RegisterName failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(rpcwrap.GetRpcPath("bson", false), func(w http.ResponseWriter, req *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack failed: %v", err)
+			return
+		}
+		io.WriteString(conn, "HTTP/1.0 200 Connected to Go RPC\n\n")
+		server.ServeCodec(bsonrpc.NewServerCodec(conn))
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	go http.Serve(listener, mux)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	return func(tablet *topo.Tablet) {
+		tablet.IPAddr = "127.0.0.1"
+		tablet.Portmap["vt"] = port
+	}, func() { listener.Close() }
+}
+
+var (
+	cloneJobInsert        = regexp.MustCompile(`^INSERT INTO _vt.worker_job \(id, worker, source, source_tablet, gtid, time_created\) VALUES \(0, '([^']*)', '([^']*)', '([^']*)', '([^']*)', `)
+	cloneCheckpointValues = regexp.MustCompile(`\('([^']*)', (\d+), '([^']*)', '([^']*)', 0\)`)
+	cloneCheckpointDone   = regexp.MustCompile(`^UPDATE _vt.worker_checkpoint SET done=1 WHERE table_name='([^']*)' AND chunk_index=(\d+)$`)
+)
+
+// fakeCloneDestination is the mysql of a destination master. It
+// records the statements it runs, and keeps the job checkpoint.
+type fakeCloneDestination struct {
+	mu         sync.Mutex
+	queries    []string
+	job        [][]sqltypes.Value
+	checkpoint map[int][]sqltypes.Value
+
+	// failInsert makes the inserts that contain it fail
+	failInsert string
+}
+
+func (d *fakeCloneDestination) fetch(query string) (*mproto.QueryResult, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queries = append(d.queries, query)
+	switch {
+	case d.failInsert != "" && strings.HasPrefix(query, "INSERT INTO `vt_test_keyspace`") && strings.Contains(query, d.failInsert):
+		return nil, fmt.Errorf("insert failed")
+	case query == "DELETE FROM _vt.worker_job":
+		d.job = nil
+	case query == "DELETE FROM _vt.worker_checkpoint":
+		d.checkpoint = nil
+	case strings.HasPrefix(query, "INSERT INTO _vt.worker_job"):
+		m := cloneJobInsert.FindStringSubmatch(query)
+		if m == nil {
+			return nil, fmt.Errorf("unexpected job insert: %v", query)
+		}
+		d.job = [][]sqltypes.Value{stringRow(m[1], m[2], m[3], m[4])}
+	case strings.HasPrefix(query, "INSERT INTO _vt.worker_checkpoint"):
+		if d.checkpoint == nil {
+			d.checkpoint = make(map[int][]sqltypes.Value)
+		}
+		for _, m := range cloneCheckpointValues.FindAllStringSubmatch(query, -1) {
+			chunkIndex, _ := strconv.Atoi(m[2])
+			d.checkpoint[chunkIndex] = stringRow(m[1], m[2], m[3], m[4], "0")
+		}
+	case strings.HasPrefix(query, "UPDATE _vt.worker_checkpoint"):
+		m := cloneCheckpointDone.FindStringSubmatch(query)
+		if m == nil {
+			return nil, fmt.Errorf("unexpected checkpoint update: %v", query)
+		}
+		chunkIndex, _ := strconv.Atoi(m[2])
+		d.checkpoint[chunkIndex][4] = sqltypes.MakeString([]byte("1"))
+	case strings.HasPrefix(query, "SELECT worker, source, source_tablet, gtid FROM _vt.worker_job"):
+		return &mproto.QueryResult{Rows: d.job}, nil
+	case strings.HasPrefix(query, "SELECT table_name, chunk_index, chunk_start, chunk_end, done FROM _vt.worker_checkpoint"):
+		qr := &mproto.QueryResult{}
+		for chunkIndex := 0; chunkIndex < len(d.checkpoint); chunkIndex++ {
+			qr.Rows = append(qr.Rows, d.checkpoint[chunkIndex])
+		}
+		return qr, nil
+	}
+	return &mproto.QueryResult{}, nil
+}
+
+// doneChunks returns the chunks of the table 't' that are done.
+func (d *fakeCloneDestination) doneChunks() map[int]bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result := make(map[int]bool)
+	for chunkIndex, row := range d.checkpoint {
+		if row[4].String() == "1" {
+			result[chunkIndex] = true
+		}
+	}
+	return result
+}
+
+// reset forgets the statements that were run.
+func (d *fakeCloneDestination) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queries = nil
+	d.failInsert = ""
+}
+
+// statements returns the statements that match one of the prefixes,
+// sorted.
+func (d *fakeCloneDestination) statements(prefixes ...string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var result []string
+	for _, query := range d.queries {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(query, prefix) {
+				result = append(result, query)
+				break
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// cloneTest is a sharded keyspace with a source shard '0', with a
+// master and a rdonly, and two destination shards '-80' and '80-', with a master.
+type cloneTest struct {
+	wr           *wrangler.Wrangler
+	ts           topo.Server
+	source       *testlib.FakeTablet
+	sourceServer *fakeCloneSource
+	destinations []*fakeCloneDestination
+	stop         []func()
+}
+
+func newCloneTest(t *testing.T) *cloneTest {
+	flag.Set("tablet_manager_protocol", testlib.FakeTabletManagerProtocol)
+	ts := memorytopo.NewTestServer(t, []string{"cell1"})
+	wr := wrangler.New(ts, time.Minute, time.Second)
+	wr.UseRPCs = false
+	if err := ts.CreateKeyspace("test_keyspace", &topo.Keyspace{
+		ShardingColumnName: "keyspace_id",
+		ShardingColumnType: key.KIT_UINT64,
+	}); err != nil {
+		t.Fatalf("CreateKeyspace failed: %v", err)
+	}
+	ct := &cloneTest{wr: wr, ts: ts}
+
+	ct.sourceServer = &fakeCloneSource{}
+	option, stop := ct.sourceServer.serve(t)
+	ct.stop = append(ct.stop, stop)
+	sourceMaster := testlib.NewFakeTablet(t, wr, "cell1", 0, topo.TYPE_MASTER)
+	source := testlib.NewFakeTablet(t, wr, "cell1", 1, topo.TYPE_RDONLY,
+		testlib.TabletParent(sourceMaster.Tablet.Alias), option)
+	ct.sourceServer.replicating = func() bool {
+		return source.FakeMysqlDaemon.Replicating
+	}
+	source.FakeMysqlDaemon.Replicating = true
+	source.FakeMysqlDaemon.CurrentSlaveStatus = &myproto.ReplicationPosition{
+		MasterLogGTIDField: myproto.GTIDField{Value: myproto.GoogleGTID{GroupID: 12}},
+	}
+	source.FakeMysqlDaemon.FetchSuperQuery = func(query string) (*mproto.QueryResult, error) {
+		if query != "SELECT MIN(id), MAX(id) FROM vt_test_keyspace.t" {
+			return nil, fmt.Errorf("unexpected query on source: %v", query)
+		}
+		return &mproto.QueryResult{
+			Fields: []mproto.Field{{Name: "MIN(id)", Type: mproto.VT_LONGLONG}, {Name: "MAX(id)", Type: mproto.VT_LONGLONG}},
+			Rows:   [][]sqltypes.Value{stringRow("10", "40")},
+		}, nil
+	}
+	source.FakeMysqlDaemon.Schema = &myproto.SchemaDefinition{
+		DatabaseSchema: "CREATE DATABASE `{{.DatabaseName}}`",
+		TableDefinitions: []myproto.TableDefinition{
+			{
+				Name:              "t",
+				Schema:            "CREATE TABLE `t` (\n  `id` bigint(20) NOT NULL,\n  `msg` varchar(64),\n  `keyspace_id` bigint(20) unsigned NOT NULL,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB",
+				Columns:           []string{"id", "msg", "keyspace_id"},
+				PrimaryKeyColumns: []string{"id"},
+				Type:              myproto.TABLE_BASE_TABLE,
+				DataLength:        1000000,
+				RowCount:          uint64(len(cloneRows)),
+			},
+		},
+	}
+	source.StartActionLoop(t, wr)
+	ct.stop = append(ct.stop, func() { source.StopActionLoop(t) })
+	ct.source = source
+
+	for i, shard := range []string{"-80", "80-"} {
+		d := &fakeCloneDestination{}
+		master := testlib.NewFakeTablet(t, wr, "cell1", uint32(10*(i+1)), topo.TYPE_MASTER,
+			testlib.TabletKeyspaceShard(t, "test_keyspace", shard))
+		master.FakeMysqlDaemon.FetchSuperQuery = d.fetch
+		master.StartActionLoop(t, wr)
+		ct.stop = append(ct.stop, func() { master.StopActionLoop(t) })
+		ct.destinations = append(ct.destinations, d)
+	}
+
+	if err := wr.RebuildKeyspaceGraph("test_keyspace", nil, nil); err != nil {
+		t.Fatalf("RebuildKeyspaceGraph failed: %v", err)
+	}
+	return ct
+}
+
+func (ct *cloneTest) close() {
+	for i := len(ct.stop) - 1; i >= 0; i-- {
+		ct.stop[i]()
+	}
+	ct.ts.Close()
+}
+
+// run runs a SplitClone of the source shard, and returns its error.
+func (ct *cloneTest) run(resume bool) error {
+	scw := NewSplitCloneWorker(ct.wr, "cell1", "test_keyspace", "0", nil, "populateBlpCheckpoint", 2, 1, 1, resume).(*SplitCloneWorker)
+	scw.Run()
+	if scw.state != stateSCDone {
+		return fmt.Errorf("worker ended in state %v: %v", scw.state, scw.Error())
+	}
+	return nil
+}
+
+// checkSource checks the replication and the type of the source.
+func (ct *cloneTest) checkSource(t *testing.T, replicating bool, tabletType topo.TabletType) {
+	if ct.source.FakeMysqlDaemon.Replicating != replicating {
+		t.Errorf("source replicating = %v, want %v", ct.source.FakeMysqlDaemon.Replicating, replicating)
+	}
+	ti, err := ct.ts.GetTablet(ct.source.Tablet.Alias)
+	if err != nil {
+		t.Fatalf("GetTablet failed: %v", err)
+	}
+	if ti.Type != tabletType {
+		t.Errorf("source is %v, want %v", ti.Type, tabletType)
+	}
+}
+
+func TestSplitClone(t *testing.T) {
+	ct := newCloneTest(t)
+	defer ct.close()
+
+	if err := ct.run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// each destination shard is read with a key range scan
+	wantQueries := []string{
+		"SELECT id, msg, keyspace_id FROM t WHERE id<25 AND keyspace_id < 0x8000000000000000 ORDER BY id",
+		"SELECT id, msg, keyspace_id FROM t WHERE id<25 AND keyspace_id >= 0x8000000000000000 ORDER BY id",
+		"SELECT id, msg, keyspace_id FROM t WHERE id>=25 AND keyspace_id < 0x8000000000000000 ORDER BY id",
+		"SELECT id, msg, keyspace_id FROM t WHERE id>=25 AND keyspace_id >= 0x8000000000000000 ORDER BY id",
+	}
+	if got := ct.sourceServer.sortedQueries(); !reflect.DeepEqual(got, wantQueries) {
+		t.Errorf("source queries:\n%v\nwant:\n%v", strings.Join(got, "\n"), strings.Join(wantQueries, "\n"))
+	}
+
+	wantInserts := [][]string{
+		{
+			"INSERT INTO `vt_test_keyspace`.t(id, msg, keyspace_id) VALUES (10,'a',1152921504606846976),(20,'c',2305843009213693952)",
+			"INSERT INTO `vt_test_keyspace`.t(id, msg, keyspace_id) VALUES (35,'e',3458764513820540928)",
+		},
+		{
+			"INSERT INTO `vt_test_keyspace`.t(id, msg, keyspace_id) VALUES (15,'b',10376293541461622784)",
+			"INSERT INTO `vt_test_keyspace`.t(id, msg, keyspace_id) VALUES (30,'d',11529215046068469760),(40,'f',12682136550675316736)",
+		},
+	}
+	for i, d := range ct.destinations {
+		if got := d.statements("INSERT INTO `vt_test_keyspace`"); !reflect.DeepEqual(got, wantInserts[i]) {
+			t.Errorf("destination %v inserts:\n%v\nwant:\n%v", i, strings.Join(got, "\n"), strings.Join(wantInserts[i], "\n"))
+		}
+
+		// filtered replication starts where the source was
+		// stopped
+		want := []string{"INSERT INTO _vt.blp_checkpoint (source_shard_uid, gtid, time_updated, transaction_timestamp, flags) VALUES (0, '" + myproto.EncodeGTID(myproto.GoogleGTID{GroupID: 12}) + "', "}
+		got := d.statements("INSERT INTO _vt.blp_checkpoint")
+		if len(got) != 1 || !strings.HasPrefix(got[0], want[0]) {
+			t.Errorf("destination %v blp_checkpoint: %v, want %v...", i, got, want)
+		}
+	}
+
+	// the source replicates again, and catches up as a spare
+	ct.checkSource(t, true, topo.TYPE_SPARE)
+	for _, shard := range []string{"-80", "80-"} {
+		si, err := ct.ts.GetShard("test_keyspace", shard)
+		if err != nil {
+			t.Fatalf("GetShard failed: %v", err)
+		}
+		if len(si.SourceShards) != 1 || si.SourceShards[0].Shard != "0" {
+			t.Errorf("shard %v has SourceShards %v, want shard 0", shard, si.SourceShards)
+		}
+	}
+}
+
+func TestSplitCloneResume(t *testing.T) {
+	ct := newCloneTest(t)
+	defer ct.close()
+
+	// the copy of the second chunk fails on the second destination
+	ct.destinations[1].failInsert = "(30,"
+	if err := ct.run(false); err == nil {
+		t.Fatalf("first run succeeded")
+	}
+
+	// the source is left stopped at the job position
+	ct.checkSource(t, false, topo.TYPE_CHECKER)
+	done := ct.destinations[0].doneChunks()
+	for chunkIndex := range done {
+		if !ct.destinations[1].doneChunks()[chunkIndex] {
+			delete(done, chunkIndex)
+		}
+	}
+	if done[1] {
+		t.Fatalf("the failed chunk is done")
+	}
+
+	// a row left by a previous chunking of the table is ignored
+	for _, d := range ct.destinations {
+		d.checkpoint[2] = stringRow("t", "2", "50", "60", "0")
+		d.reset()
+	}
+	ct.sourceServer.reset()
+
+	if err := ct.run(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the chunks that were not done are cleaned up and copied
+	var wantQueries, wantDeletes []string
+	for chunkIndex, where := range []string{"id<25", "id>=25"} {
+		if done[chunkIndex] {
+			continue
+		}
+		wantQueries = append(wantQueries,
+			"SELECT id, msg, keyspace_id FROM t WHERE "+where+" AND keyspace_id < 0x8000000000000000 ORDER BY id",
+			"SELECT id, msg, keyspace_id FROM t WHERE "+where+" AND keyspace_id >= 0x8000000000000000 ORDER BY id")
+		wantDeletes = append(wantDeletes, "DELETE FROM `vt_test_keyspace`.t WHERE "+where)
+	}
+	sort.Strings(wantQueries)
+	if got := ct.sourceServer.sortedQueries(); !reflect.DeepEqual(got, wantQueries) {
+		t.Errorf("source queries:\n%v\nwant:\n%v", strings.Join(got, "\n"), strings.Join(wantQueries, "\n"))
+	}
+	for i, d := range ct.destinations {
+		if got := d.statements("DELETE FROM `vt_test_keyspace`"); !reflect.DeepEqual(got, wantDeletes) {
+			t.Errorf("destination %v deletes: %v, want %v", i, got, wantDeletes)
+		}
+		if got := d.doneChunks(); !reflect.DeepEqual(got, map[int]bool{0: true, 1: true}) {
+			t.Errorf("destination %v done chunks: %v", i, got)
+		}
+
+		// filtered replication starts at the job position
+		want := []string{
+			"DELETE FROM _vt.blp_checkpoint WHERE source_shard_uid=0",
+			"INSERT INTO _vt.blp_checkpoint (source_shard_uid, gtid, time_updated, transaction_timestamp, flags) VALUES (0, '" + myproto.EncodeGTID(myproto.GoogleGTID{GroupID: 12}) + "', ",
+		}
+		got := d.statements("DELETE FROM _vt.blp_checkpoint", "INSERT INTO _vt.blp_checkpoint")
+		if len(got) != 2 || got[0] != want[0] || !strings.HasPrefix(got[1], want[1]) {
+			t.Errorf("destination %v blp_checkpoint: %v, want %v...", i, got, want)
+		}
+	}
+
+	// the source replicates again once the job is done
+	ct.checkSource(t, true, topo.TYPE_SPARE)
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	log "github.com/golang/glog"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
 	"github.com/youtube/vitess/go/vt/servenv"
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/wrangler"
@@ -61,3 +62,78 @@ func findChecker(wr *wrangler.Wrangler, cleaner *wrangler.Cleaner, cell, keyspac
 	wrangler.RecordChangeSlaveTypeAction(cleaner, tabletAlias, topo.TYPE_RDONLY)
 	return tabletAlias, nil
 }
+
+// stopChecker stops replication on a checker, so its data doesn't
+// change while it is copied, and returns its position. It changes the
+// clean-up actions to restart replication and take the tablet back to
+// 'spare', as it has to catch up before serving again.
+func stopChecker(wr *wrangler.Wrangler, cleaner *wrangler.Cleaner, ti *topo.TabletInfo) (*myproto.ReplicationPosition, error) {
+	log.Infof("Stopping replication on checker %v", ti.Alias)
+	if err := wr.ActionInitiator().StopSlave(ti, 30*time.Second); err != nil {
+		return nil, fmt.Errorf("cannot stop replication on %v: %v", ti.Alias, err)
+	}
+	wrangler.RecordStartSlaveAction(cleaner, ti.Alias, 30*time.Second)
+	action, err := wrangler.FindChangeSlaveTypeActionByTarget(cleaner, ti.Alias)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find ChangeSlaveType action for %v: %v", ti.Alias, err)
+	}
+	action.TabletType = topo.TYPE_SPARE
+
+	pos, err := wr.ActionInitiator().SlavePosition(ti, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get position of %v: %v", ti.Alias, err)
+	}
+	return pos, nil
+}
+
+// keepCheckerStopped removes the clean-up actions that restart
+// replication on a checker and take it back to serving, when a job
+// copying from it fails. The tablet stays a 'checker' at the position
+// of the job, so the job can be resumed.
+func keepCheckerStopped(cleaner *wrangler.Cleaner, tabletAlias topo.TabletAlias) {
+	if err := cleaner.RemoveActionByName(wrangler.StartSlaveActionName, tabletAlias.String()); err != nil {
+		return
+	}
+	if err := cleaner.RemoveActionByName(wrangler.ChangeSlaveTypeActionName, tabletAlias.String()); err != nil {
+		log.Warningf("Cannot remove ChangeSlaveType action for %v: %v", tabletAlias, err)
+	}
+	log.Warningf("Leaving tablet %v as a checker with replication stopped, to resume the job. To abandon it instead, start replication on the tablet and change its type back to rdonly.", tabletAlias)
+}
+
+// resumeChecker takes over the checker a failed job left with
+// replication stopped, to resume the job:
+// - make sure it is still a checker
+// - tag it with our worker process
+// - make sure replication is stopped
+// The clean-up actions restart replication and take it back to 'spare'.
+func resumeChecker(wr *wrangler.Wrangler, cleaner *wrangler.Cleaner, tabletAlias topo.TabletAlias) (*topo.TabletInfo, error) {
+	ti, err := wr.TopoServer().GetTablet(tabletAlias)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read tablet %v: %v", tabletAlias, err)
+	}
+	if ti.Type != topo.TYPE_CHECKER {
+		return nil, fmt.Errorf("tablet %v is %v, not the checker of the job", tabletAlias, ti.Type)
+	}
+
+	ourURL := servenv.ListeningURL.String()
+	log.Infof("Adding tag[worker]=%v to tablet %v", ourURL, tabletAlias)
+	if err := wr.TopoServer().UpdateTabletFields(tabletAlias, func(tablet *topo.Tablet) error {
+		if tablet.Tags == nil {
+			tablet.Tags = make(map[string]string)
+		}
+		tablet.Tags["worker"] = ourURL
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	wrangler.RecordTabletTagAction(cleaner, tabletAlias, "worker", "")
+
+	log.Infof("Stopping replication on checker %v", tabletAlias)
+	if err := wr.ActionInitiator().StopSlave(ti, 30*time.Second); err != nil {
+		return nil, fmt.Errorf("cannot stop replication on %v: %v", tabletAlias, err)
+	}
+
+	wrangler.RecordChangeSlaveTypeAction(cleaner, tabletAlias, topo.TYPE_SPARE)
+	wrangler.RecordStartSlaveAction(cleaner, tabletAlias, 30*time.Second)
+	return ti, nil
+}
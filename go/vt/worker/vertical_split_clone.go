@@ -5,18 +5,13 @@
 package worker
 
 import (
-	"bytes"
 	"fmt"
 	"html/template"
-	"strconv"
 	"strings"
 	"sync"
-	ttemplate "text/template"
 	"time"
 
 	log "github.com/golang/glog"
-	mproto "github.com/youtube/vitess/go/mysql/proto"
-	"github.com/youtube/vitess/go/sqltypes"
 	"github.com/youtube/vitess/go/sync2"
 	"github.com/youtube/vitess/go/vt/binlog/binlogplayer"
 	"github.com/youtube/vitess/go/vt/mysqlctl"
@@ -37,31 +32,6 @@ const (
 	stateVSCCleanUp     = "cleaning up"
 )
 
-type tableStatus struct {
-	name     string
-	rowCount uint64
-
-	// all subsequent fields are protected by the mutex
-	mu         sync.Mutex
-	state      string
-	copiedRows uint64
-}
-
-func (ts *tableStatus) setState(state string) {
-	ts.mu.Lock()
-	ts.state = state
-	ts.mu.Unlock()
-}
-
-func (ts *tableStatus) addCopiedRows(copiedRows int) {
-	ts.mu.Lock()
-	ts.copiedRows += uint64(copiedRows)
-	if ts.copiedRows == ts.rowCount {
-		ts.state = "finished the copy"
-	}
-	ts.mu.Unlock()
-}
-
 // VerticalSplitCloneWorker will clone the data from a source keyspace/shard
 // to a destination keyspace/shard.
 type VerticalSplitCloneWorker struct {
@@ -74,6 +44,7 @@ type VerticalSplitCloneWorker struct {
 	sourceReaderCount      int
 	minTableSizeForSplit   uint64
 	destinationWriterCount int
+	resume                 bool
 	cleaner                *wrangler.Cleaner
 
 	// all subsequent fields are protected by the mutex
@@ -92,14 +63,22 @@ type VerticalSplitCloneWorker struct {
 	destinationAliases     []topo.TabletAlias
 	destinationTablets     map[topo.TabletAlias]*topo.TabletInfo
 	destinationMasterAlias topo.TabletAlias
+	checkpoint             *cloneCheckpoint
 
 	// populated during stateVSCCopy
 	tableStatus []tableStatus
 	startTime   time.Time
+
+	// resumable is set once the job checkpoint is started or
+	// loaded: if the job fails after that, the source is left
+	// stopped at the job position so the job can be resumed.
+	resumable bool
 }
 
 // NewVerticalSplitCloneWorker returns a new VerticalSplitCloneWorker object.
-func NewVerticalSplitCloneWorker(wr *wrangler.Wrangler, cell, destinationKeyspace, destinationShard string, tables []string, strategy string, sourceReaderCount int, minTableSizeForSplit uint64, destinationWriterCount int) Worker {
+// If resume is set, the worker continues the job that was previously
+// started on the destination, using its checkpoint.
+func NewVerticalSplitCloneWorker(wr *wrangler.Wrangler, cell, destinationKeyspace, destinationShard string, tables []string, strategy string, sourceReaderCount int, minTableSizeForSplit uint64, destinationWriterCount int, resume bool) Worker {
 	return &VerticalSplitCloneWorker{
 		wr:                     wr,
 		cell:                   cell,
@@ -110,6 +89,7 @@ func NewVerticalSplitCloneWorker(wr *wrangler.Wrangler, cell, destinationKeyspac
 		sourceReaderCount:      sourceReaderCount,
 		minTableSizeForSplit:   minTableSizeForSplit,
 		destinationWriterCount: destinationWriterCount,
+		resume:                 resume,
 		cleaner:                &wrangler.Cleaner{},
 
 		state: stateVSCNotSarted,
@@ -129,29 +109,6 @@ func (vscw *VerticalSplitCloneWorker) recordError(err error) {
 	vscw.mu.Unlock()
 }
 
-func (vscw *VerticalSplitCloneWorker) tableStatuses() ([]string, time.Time) {
-	copiedRows := uint64(0)
-	rowCount := uint64(0)
-	result := make([]string, len(vscw.tableStatus))
-	for i, ts := range vscw.tableStatus {
-		ts.mu.Lock()
-		if ts.rowCount > 0 {
-			result[i] = fmt.Sprintf("%v: %v (%v/%v)", ts.name, ts.state, ts.copiedRows, ts.rowCount)
-			copiedRows += ts.copiedRows
-			rowCount += ts.rowCount
-		} else {
-			result[i] = fmt.Sprintf("%v: %v", ts.name, ts.state)
-		}
-		ts.mu.Unlock()
-	}
-	now := time.Now()
-	if rowCount == 0 || copiedRows == 0 {
-		return result, now
-	}
-	eta := now.Add(time.Duration(float64(now.Sub(vscw.startTime)) * float64(copiedRows) / float64(rowCount)))
-	return result, eta
-}
-
 // StatusAsHTML implements the Worker interface
 func (vscw *VerticalSplitCloneWorker) StatusAsHTML() template.HTML {
 	vscw.mu.Lock()
@@ -164,12 +121,12 @@ func (vscw *VerticalSplitCloneWorker) StatusAsHTML() template.HTML {
 	case stateVSCCopy:
 		result += "<b>Running</b>:</br>\n"
 		result += "<b>Copying from</b>: " + vscw.sourceAlias.String() + "</br>\n"
-		statuses, eta := vscw.tableStatuses()
+		statuses, eta := formatTableStatuses(vscw.tableStatus, vscw.startTime)
 		result += "<b>ETA</b>: " + eta.String() + "</br>\n"
 		result += strings.Join(statuses, "</br>\n")
 	case stateVSCDone:
 		result += "<b>Success</b>:</br>\n"
-		statuses, _ := vscw.tableStatuses()
+		statuses, _ := formatTableStatuses(vscw.tableStatus, vscw.startTime)
 		result += strings.Join(statuses, "</br>\n")
 	}
 
@@ -188,12 +145,12 @@ func (vscw *VerticalSplitCloneWorker) StatusAsText() string {
 	case stateVSCCopy:
 		result += "Running:\n"
 		result += "Copying from: " + vscw.sourceAlias.String() + "\n"
-		statuses, eta := vscw.tableStatuses()
+		statuses, eta := formatTableStatuses(vscw.tableStatus, vscw.startTime)
 		result += "ETA: " + eta.String() + "\n"
 		result += strings.Join(statuses, "\n")
 	case stateVSCDone:
 		result += "Success:\n"
-		statuses, _ := vscw.tableStatuses()
+		statuses, _ := formatTableStatuses(vscw.tableStatus, vscw.startTime)
 		result += strings.Join(statuses, "\n")
 	}
 	return result
@@ -252,6 +209,9 @@ func (vscw *VerticalSplitCloneWorker) run() error {
 
 	// third state: copy data
 	if err := vscw.copy(); err != nil {
+		if vscw.resumable {
+			keepCheckerStopped(vscw.cleaner, vscw.sourceAlias)
+		}
 		return fmt.Errorf("copy() failed: %v", err)
 	}
 	if vscw.CheckInterrupted() {
@@ -297,27 +257,14 @@ func (vscw *VerticalSplitCloneWorker) init() error {
 }
 
 // findTargets phase:
-// - find one rdonly in the source shard
-// - mark it as 'checker' pointing back to us
 // - get the aliases of all the targets
+// - find one rdonly in the source shard, or reuse the job's one if resuming
+// - mark it as 'checker' pointing back to us
 func (vscw *VerticalSplitCloneWorker) findTargets() error {
 	vscw.setState(stateVSCFindTargets)
 
-	// find an appropriate endpoint in the source shard
-	var err error
-	vscw.sourceAlias, err = findChecker(vscw.wr, vscw.cleaner, vscw.cell, vscw.sourceKeyspace, "0")
-	if err != nil {
-		return fmt.Errorf("cannot find checker for %v/%v/0: %v", vscw.cell, vscw.sourceKeyspace, err)
-	}
-	log.Infof("Using tablet %v as the source", vscw.sourceAlias)
-
-	// get the tablet info for it
-	vscw.sourceTablet, err = vscw.wr.TopoServer().GetTablet(vscw.sourceAlias)
-	if err != nil {
-		return fmt.Errorf("cannot read tablet %v: %v", vscw.sourceTablet, err)
-	}
-
 	// find all the targets in the destination keyspace / shard
+	var err error
 	vscw.destinationAliases, err = topo.FindAllTabletAliasesInShard(vscw.wr.TopoServer(), vscw.destinationKeyspace, vscw.destinationShard)
 	if err != nil {
 		return fmt.Errorf("cannot find all target tablets in %v/%v: %v", vscw.destinationKeyspace, vscw.destinationShard, err)
@@ -344,13 +291,50 @@ func (vscw *VerticalSplitCloneWorker) findTargets() error {
 		return fmt.Errorf("no master in destination shard")
 	}
 
+	// The job checkpoint is stored on all destination tablets, along
+	// with the data. It records the source tablet and its position
+	// when the job started, and the chunks that are already copied.
+	destinationTablets := make([]*topo.TabletInfo, 0, len(vscw.destinationAliases))
+	for _, tabletAlias := range vscw.destinationAliases {
+		destinationTablets = append(destinationTablets, vscw.destinationTablets[tabletAlias])
+	}
+	vscw.checkpoint = newCloneCheckpoint(vscw.wr, "VerticalSplitClone", vscw.sourceKeyspace+"/0", destinationTablets, true)
+
+	if vscw.resume {
+		// use the source the job was started with, that was
+		// left stopped at the job position
+		if err := vscw.checkpoint.load(); err != nil {
+			return fmt.Errorf("cannot resume: %v", err)
+		}
+		vscw.sourceAlias = vscw.checkpoint.sourceTablet
+		vscw.sourceTablet, err = resumeChecker(vscw.wr, vscw.cleaner, vscw.sourceAlias)
+		if err != nil {
+			return fmt.Errorf("cannot resume from source %v: %v", vscw.sourceAlias, err)
+		}
+		log.Infof("Using tablet %v as the source", vscw.sourceAlias)
+		return nil
+	}
+
+	// find an appropriate endpoint in the source shard
+	vscw.sourceAlias, err = findChecker(vscw.wr, vscw.cleaner, vscw.cell, vscw.sourceKeyspace, "0")
+	if err != nil {
+		return fmt.Errorf("cannot find checker for %v/%v/0: %v", vscw.cell, vscw.sourceKeyspace, err)
+	}
+	log.Infof("Using tablet %v as the source", vscw.sourceAlias)
+
+	// get the tablet info for it
+	vscw.sourceTablet, err = vscw.wr.TopoServer().GetTablet(vscw.sourceAlias)
+	if err != nil {
+		return fmt.Errorf("cannot read tablet %v: %v", vscw.sourceTablet, err)
+	}
 	return nil
 }
 
 // copy phase:
 // - get schema on the source, filter tables
-// - create tables on all destinations
-// - copy the data
+// - stop replication on the source, and get its position
+// - create tables on all destinations, or check the source position if resuming
+// - copy the data, checkpointing each chunk
 func (vscw *VerticalSplitCloneWorker) copy() error {
 	vscw.setState(stateVSCCopy)
 
@@ -402,10 +386,6 @@ func (vscw *VerticalSplitCloneWorker) copy() error {
 		vscw.tableStatus[i].mu.Unlock()
 	}
 
-	// For each destination tablet (in parallel):
-	// - create the schema
-	// - setup the channels to send SQL data chunks
-	//
 	// mu protects the abort channel for closing, and firstError
 	mu := sync.Mutex{}
 	abort := make(chan struct{})
@@ -414,15 +394,70 @@ func (vscw *VerticalSplitCloneWorker) copy() error {
 	processError := func(format string, args ...interface{}) {
 		log.Errorf(format, args...)
 		mu.Lock()
-		if abort != nil {
-			close(abort)
-			abort = nil
+		if firstError == nil {
 			firstError = fmt.Errorf(format, args...)
+			close(abort)
 		}
 		mu.Unlock()
 	}
 
-	insertChannels := make([]chan string, len(vscw.destinationAliases))
+	checkpoint := vscw.checkpoint
+	destinationTablets := checkpoint.tablets
+	if vscw.resume {
+		// The schema is already there, and the checkpoint is
+		// loaded. Make sure the source didn't move.
+		vscw.resumable = true
+		sourcePos, err := vscw.wr.ActionInitiator().SlavePosition(vscw.sourceTablet, 30*time.Second)
+		if err != nil {
+			return fmt.Errorf("cannot get position of source %v: %v", vscw.sourceAlias, err)
+		}
+		if err := checkpoint.checkPosition(sourcePos.MasterLogGTIDField.Value); err != nil {
+			vscw.resumable = false
+			return fmt.Errorf("cannot resume from source %v: %v", vscw.sourceAlias, err)
+		}
+		log.Infof("Resuming copy from source %v", vscw.sourceAlias)
+	} else {
+		// The source doesn't replicate during the copy, so all
+		// the data is at the same position, where filtered
+		// replication starts.
+		sourcePos, err := stopChecker(vscw.wr, vscw.cleaner, vscw.sourceTablet)
+		if err != nil {
+			return err
+		}
+
+		// create the schema on all destination tablets (in parallel)
+		destinationWaitGroup := sync.WaitGroup{}
+		for _, ti := range destinationTablets {
+			destinationWaitGroup.Add(1)
+			go func(ti *topo.TabletInfo) {
+				defer destinationWaitGroup.Done()
+				log.Infof("Creating tables on tablet %v", ti.Alias)
+				if err := runSqlCommands(vscw.wr, ti, createDbCmds, abort, true); err != nil {
+					processError("createDbCmds failed: %v", err)
+					return
+				}
+				if len(createViewCmds) > 0 {
+					log.Infof("Creating views on tablet %v", ti.Alias)
+					if err := runSqlCommands(vscw.wr, ti, createViewCmds, abort, true); err != nil {
+						processError("createViewCmds failed: %v", err)
+						return
+					}
+				}
+			}(ti)
+		}
+		destinationWaitGroup.Wait()
+		if firstError != nil {
+			return firstError
+		}
+		if err := checkpoint.start(sourcePos.MasterLogGTIDField.Value, vscw.sourceAlias, abort); err != nil {
+			return fmt.Errorf("cannot start job checkpoint: %v", err)
+		}
+		vscw.resumable = true
+	}
+
+	// For each destination tablet (in parallel), setup the channels
+	// to send SQL data chunks.
+	insertChannels := make([]chan insertCmd, len(vscw.destinationAliases))
 	destinationWaitGroup := sync.WaitGroup{}
 	for i, tabletAlias := range vscw.destinationAliases {
 		// we create one channel per destination tablet.  It
@@ -430,46 +465,30 @@ func (vscw *VerticalSplitCloneWorker) copy() error {
 		// destinationWriterCount * 2 items, to hopefully
 		// always have data. We then have
 		// destinationWriterCount go routines reading from it.
-		insertChannels[i] = make(chan string, vscw.destinationWriterCount*2)
+		insertChannels[i] = make(chan insertCmd, vscw.destinationWriterCount*2)
 
-		destinationWaitGroup.Add(1)
-		go func(ti *topo.TabletInfo, insertChannel chan string) {
-			defer destinationWaitGroup.Done()
-			log.Infof("Creating tables on tablet %v", ti.Alias)
-			if err := vscw.runSqlCommands(ti, createDbCmds, abort); err != nil {
-				processError("createDbCmds failed: %v", err)
-				return
-			}
-			if len(createViewCmds) > 0 {
-				log.Infof("Creating views on tablet %v", ti.Alias)
-				if err := vscw.runSqlCommands(ti, createViewCmds, abort); err != nil {
-					processError("createViewCmds failed: %v", err)
-					return
-				}
-			}
-			for j := 0; j < vscw.destinationWriterCount; j++ {
-				destinationWaitGroup.Add(1)
-				go func() {
-					defer destinationWaitGroup.Done()
-					for {
-						select {
-						case cmd, ok := <-insertChannel:
-							if !ok {
-								return
-							}
-							cmd = "INSERT INTO `" + ti.DbName() + "`." + cmd
-							_, err := vscw.wr.ActionInitiator().ExecuteFetch(ti, cmd, 0, false, true, 30*time.Second)
-							if err != nil {
-								processError("ExecuteFetch failed: %v", err)
-								return
-							}
-						case <-abort:
+		for j := 0; j < vscw.destinationWriterCount; j++ {
+			destinationWaitGroup.Add(1)
+			go func(ti *topo.TabletInfo, insertChannel chan insertCmd) {
+				defer destinationWaitGroup.Done()
+				for {
+					select {
+					case cmd, ok := <-insertChannel:
+						if !ok {
 							return
 						}
+						_, err := vscw.wr.ActionInitiator().ExecuteFetch(ti, "INSERT INTO `"+ti.DbName()+"`."+cmd.sql, 0, false, true, 30*time.Second)
+						cmd.done()
+						if err != nil {
+							processError("ExecuteFetch failed: %v", err)
+							return
+						}
+					case <-abort:
+						return
 					}
-				}()
-			}
-		}(vscw.destinationTablets[tabletAlias], insertChannels[i])
+				}
+			}(vscw.destinationTablets[tabletAlias], insertChannels[i])
+		}
 	}
 
 	// Now for each table, read data chunks and send them to all
@@ -483,12 +502,23 @@ func (vscw *VerticalSplitCloneWorker) copy() error {
 		}
 
 		vscw.tableStatus[tableIndex].setState("before copy")
-		chunks, err := vscw.findChunks(vscw.sourceTablet, td)
-		if err != nil {
-			return err
+		chunks, resumed := checkpoint.tableChunks(td.Name)
+		if !resumed {
+			chunks, err = findChunks(vscw.wr, vscw.sourceTablet, &td, vscw.minTableSizeForSplit, vscw.sourceReaderCount)
+			if err != nil {
+				return err
+			}
+			if err := checkpoint.recordChunks(td.Name, chunks, abort); err != nil {
+				return err
+			}
 		}
 
 		for chunkIndex := 0; chunkIndex < len(chunks)-1; chunkIndex++ {
+			if checkpoint.isDone(td.Name, chunkIndex) {
+				log.Infof("Skipping chunk %v of table %v, already copied", chunkIndex, td.Name)
+				continue
+			}
+
 			sourceWaitGroup.Add(1)
 			go func(td myproto.TableDefinition, tableIndex, chunkIndex int) {
 				defer sourceWaitGroup.Done()
@@ -496,26 +526,18 @@ func (vscw *VerticalSplitCloneWorker) copy() error {
 				sema.Acquire()
 				defer sema.Release()
 
+				// a previous run may have copied part of the chunk
+				if resumed {
+					if err := checkpoint.runOnAllTablets([]string{buildDeleteFromChunks(&td, chunks, chunkIndex)}, abort); err != nil {
+						processError("cannot clean up chunk %v of table %v: %v", chunkIndex, td.Name, err)
+						return
+					}
+				}
+
 				vscw.tableStatus[tableIndex].setState("started the copy")
 
 				// build the query, and start the streaming
-				selectSQL := "SELECT " + strings.Join(td.Columns, ", ") + " FROM " + td.Name
-				if chunks[chunkIndex] != "" || chunks[chunkIndex+1] != "" {
-					log.Infof("Starting to stream all data from table %v between '%v' and '%v'", td.Name, chunks[chunkIndex], chunks[chunkIndex+1])
-					clauses := make([]string, 0, 2)
-					if chunks[chunkIndex] != "" {
-						clauses = append(clauses, td.PrimaryKeyColumns[0]+">="+chunks[chunkIndex])
-					}
-					if chunks[chunkIndex+1] != "" {
-						clauses = append(clauses, td.PrimaryKeyColumns[0]+"<"+chunks[chunkIndex+1])
-					}
-					selectSQL += " WHERE " + strings.Join(clauses, " AND ")
-				} else {
-					log.Infof("Starting to stream all data from table %v", td.Name)
-				}
-				if len(td.PrimaryKeyColumns) > 0 {
-					selectSQL += " ORDER BY " + strings.Join(td.PrimaryKeyColumns, ", ")
-				}
+				selectSQL := buildSQLFromChunks(&td, chunks, chunkIndex)
 				qrr, err := NewQueryResultReaderForTablet(vscw.wr.TopoServer(), vscw.sourceAlias, selectSQL)
 				if err != nil {
 					processError("NewQueryResultReaderForTablet failed: %v", err)
@@ -523,6 +545,7 @@ func (vscw *VerticalSplitCloneWorker) copy() error {
 				}
 
 				// process the data
+				chunkWaitGroup := sync.WaitGroup{}
 				baseCmd := td.Name + "(" + strings.Join(td.Columns, ", ") + ") VALUES "
 			loop:
 				for {
@@ -541,14 +564,28 @@ func (vscw *VerticalSplitCloneWorker) copy() error {
 
 						// send the rows to be inserted
 						vscw.tableStatus[tableIndex].addCopiedRows(len(r.Rows))
-						cmd := baseCmd + makeValueString(qrr.Fields, r)
+						cmd := insertCmd{baseCmd + makeValueString(qrr.Fields, r), &chunkWaitGroup}
 						for _, c := range insertChannels {
-							c <- cmd
+							chunkWaitGroup.Add(1)
+							select {
+							case c <- cmd:
+							case <-abort:
+								return
+							}
 						}
 					case <-abort:
 						return
 					}
 				}
+
+				// wait for all the rows to be inserted, and save
+				// the progress
+				if !waitForChunk(&chunkWaitGroup, abort) {
+					return
+				}
+				if err := checkpoint.markDone(td.Name, chunkIndex, abort); err != nil {
+					processError("cannot checkpoint chunk %v of table %v: %v", chunkIndex, td.Name, err)
+				}
 			}(td, tableIndex, chunkIndex)
 		}
 	}
@@ -569,7 +606,7 @@ func (vscw *VerticalSplitCloneWorker) copy() error {
 			go func(ti *topo.TabletInfo) {
 				defer destinationWaitGroup.Done()
 				log.Infof("Altering tables on tablet %v", ti.Alias)
-				if err := vscw.runSqlCommands(ti, alterTablesCmds, abort); err != nil {
+				if err := runSqlCommands(vscw.wr, ti, alterTablesCmds, abort, true); err != nil {
 					processError("alterTablesCmds failed on tablet %v: %v", ti.Alias, err)
 				}
 			}(vscw.destinationTablets[tabletAlias])
@@ -582,25 +619,23 @@ func (vscw *VerticalSplitCloneWorker) copy() error {
 
 	// then create and populate the blp_checkpoint table
 	if strings.Index(vscw.strategy, "populateBlpCheckpoint") != -1 {
-		// get the current position from the source
-		pos, err := vscw.wr.ActionInitiator().SlavePosition(vscw.sourceTablet, 30*time.Second)
-		if err != nil {
-			return err
-		}
-
 		queries := make([]string, 0, 4)
 		queries = append(queries, binlogplayer.CreateBlpCheckpoint()...)
+		if vscw.resume {
+			// a previous run may have populated it already
+			queries = append(queries, "DELETE FROM _vt.blp_checkpoint WHERE source_shard_uid=0")
+		}
 		flags := ""
 		if strings.Index(vscw.strategy, "dontStartBinlogPlayer") != -1 {
 			flags = binlogplayer.BLP_FLAG_DONT_START
 		}
-		queries = append(queries, binlogplayer.PopulateBlpCheckpoint(0, pos.MasterLogGTIDField.Value, time.Now().Unix(), flags))
+		queries = append(queries, binlogplayer.PopulateBlpCheckpoint(0, checkpoint.gtid, time.Now().Unix(), flags))
 		for _, tabletAlias := range vscw.destinationAliases {
 			destinationWaitGroup.Add(1)
 			go func(ti *topo.TabletInfo) {
 				defer destinationWaitGroup.Done()
 				log.Infof("Making and populating blp_checkpoint table on tablet %v", ti.Alias)
-				if err := vscw.runSqlCommands(ti, queries, abort); err != nil {
+				if err := runSqlCommands(vscw.wr, ti, queries, abort, true); err != nil {
 					processError("blp_checkpoint queries failed on tablet %v: %v", ti.Alias, err)
 				}
 			}(vscw.destinationTablets[tabletAlias])
@@ -633,185 +668,3 @@ func (vscw *VerticalSplitCloneWorker) copy() error {
 	destinationWaitGroup.Wait()
 	return firstError
 }
-
-func (vscw *VerticalSplitCloneWorker) runSqlCommands(ti *topo.TabletInfo, commands []string, abort chan struct{}) error {
-	for _, command := range commands {
-		command, err := fillStringTemplate(command, map[string]string{"DatabaseName": ti.DbName()})
-		if err != nil {
-			return fmt.Errorf("fillStringTemplate failed: %v", err)
-		}
-
-		_, err = vscw.wr.ActionInitiator().ExecuteFetch(ti, command, 0, false, true, 30*time.Second)
-		if err != nil {
-			return err
-		}
-
-		// check on abort
-		select {
-		case <-abort:
-			return nil
-		default:
-			break
-		}
-	}
-
-	return nil
-}
-
-// findChunks returns an array of chunks to use for splitting up a table
-// into multiple data chunks. It only works for tables with a primary key
-// (and the primary key first column is an integer type).
-// The array will always look like:
-// "", "value1", "value2", ""
-// A non-split tablet will just return:
-// "", ""
-func (vscw *VerticalSplitCloneWorker) findChunks(ti *topo.TabletInfo, td myproto.TableDefinition) ([]string, error) {
-	result := []string{"", ""}
-
-	// eliminate a few cases we don't split tables for
-	if len(td.PrimaryKeyColumns) == 0 {
-		// no primary key, what can we do?
-		return result, nil
-	}
-	if td.DataLength < vscw.minTableSizeForSplit {
-		// table is too small to split up
-		return result, nil
-	}
-
-	// get the min and max of the leading column of the primary key
-	query := fmt.Sprintf("SELECT MIN(%v), MAX(%v) FROM %v.%v", td.PrimaryKeyColumns[0], td.PrimaryKeyColumns[0], ti.DbName(), td.Name)
-	qr, err := vscw.wr.ActionInitiator().ExecuteFetch(ti, query, 1, true, false, 30*time.Second)
-	if err != nil {
-		log.Infof("Not splitting table %v into multiple chunks: %v", td.Name, err)
-		return result, nil
-	}
-	if len(qr.Rows) != 1 {
-		log.Infof("Not splitting table %v into multiple chunks, cannot get min and max", td.Name)
-		return result, nil
-	}
-	if qr.Rows[0][0].IsNull() || qr.Rows[0][1].IsNull() {
-		log.Infof("Not splitting table %v into multiple chunks, min or max is NULL: %v %v", td.Name, qr.Rows[0][0], qr.Rows[0][1])
-		return result, nil
-	}
-	switch qr.Fields[0].Type {
-	case mproto.VT_TINY, mproto.VT_SHORT, mproto.VT_LONG, mproto.VT_LONGLONG, mproto.VT_INT24:
-		minNumeric := sqltypes.MakeNumeric(qr.Rows[0][0].Raw())
-		maxNumeric := sqltypes.MakeNumeric(qr.Rows[0][1].Raw())
-		if qr.Rows[0][0].Raw()[0] == '-' {
-			// signed values, use int64
-			min, err := minNumeric.ParseInt64()
-			if err != nil {
-				log.Infof("Not splitting table %v into multiple chunks, cannot convert min: %v %v", td.Name, minNumeric, err)
-				return result, nil
-			}
-			max, err := maxNumeric.ParseInt64()
-			if err != nil {
-				log.Infof("Not splitting table %v into multiple chunks, cannot convert max: %v %v", td.Name, maxNumeric, err)
-				return result, nil
-			}
-			interval := (max - min) / int64(vscw.sourceReaderCount)
-			if interval == 0 {
-				log.Infof("Not splitting table %v into multiple chunks, interval=0: %v %v", max, min)
-				return result, nil
-			}
-
-			result = make([]string, vscw.sourceReaderCount+1)
-			result[0] = ""
-			result[vscw.sourceReaderCount] = ""
-			for i := int64(1); i < int64(vscw.sourceReaderCount); i++ {
-				result[i] = fmt.Sprintf("%v", min+interval*i)
-			}
-			return result, nil
-		}
-
-		// unsigned values, use uint64
-		min, err := minNumeric.ParseUint64()
-		if err != nil {
-			log.Infof("Not splitting table %v into multiple chunks, cannot convert min: %v %v", td.Name, minNumeric, err)
-			return result, nil
-		}
-		max, err := maxNumeric.ParseUint64()
-		if err != nil {
-			log.Infof("Not splitting table %v into multiple chunks, cannot convert max: %v %v", td.Name, maxNumeric, err)
-			return result, nil
-		}
-		interval := (max - min) / uint64(vscw.sourceReaderCount)
-		if interval == 0 {
-			log.Infof("Not splitting table %v into multiple chunks, interval=0: %v %v", max, min)
-			return result, nil
-		}
-
-		result = make([]string, vscw.sourceReaderCount+1)
-		result[0] = ""
-		result[vscw.sourceReaderCount] = ""
-		for i := uint64(1); i < uint64(vscw.sourceReaderCount); i++ {
-			result[i] = fmt.Sprintf("%v", min+interval*i)
-		}
-		return result, nil
-
-	case mproto.VT_FLOAT, mproto.VT_DOUBLE:
-		min, err := strconv.ParseFloat(qr.Rows[0][0].String(), 64)
-		if err != nil {
-			log.Infof("Not splitting table %v into multiple chunks, cannot convert min: %v %v", td.Name, qr.Rows[0][0], err)
-			return result, nil
-		}
-		max, err := strconv.ParseFloat(qr.Rows[0][1].String(), 64)
-		if err != nil {
-			log.Infof("Not splitting table %v into multiple chunks, cannot convert max: %v %v", td.Name, qr.Rows[0][1].String(), err)
-			return result, nil
-		}
-		interval := (max - min) / float64(vscw.sourceReaderCount)
-		if interval == 0 {
-			log.Infof("Not splitting table %v into multiple chunks, interval=0: %v %v", max, min)
-			return result, nil
-		}
-
-		result = make([]string, vscw.sourceReaderCount+1)
-		result[0] = ""
-		result[vscw.sourceReaderCount] = ""
-		for i := 1; i < vscw.sourceReaderCount; i++ {
-			result[i] = fmt.Sprintf("%v", min+interval*float64(i))
-		}
-		return result, nil
-	}
-
-	log.Infof("Not splitting table %v into multiple chunks, primary key not numeric", td.Name)
-	return result, nil
-}
-
-func fillStringTemplate(tmpl string, vars interface{}) (string, error) {
-	myTemplate := ttemplate.Must(ttemplate.New("").Parse(tmpl))
-	data := new(bytes.Buffer)
-	if err := myTemplate.Execute(data, vars); err != nil {
-		return "", err
-	}
-	return data.String(), nil
-}
-
-func makeValueString(fields []mproto.Field, qr *mproto.QueryResult) string {
-	buf := bytes.Buffer{}
-	for i, row := range qr.Rows {
-		if i > 0 {
-			buf.Write([]byte(",("))
-		} else {
-			buf.WriteByte('(')
-		}
-		for j, value := range row {
-			if j > 0 {
-				buf.WriteByte(',')
-			}
-			// convert value back to its original type
-			if !value.IsNull() {
-				switch fields[j].Type {
-				case mproto.VT_TINY, mproto.VT_SHORT, mproto.VT_LONG, mproto.VT_LONGLONG, mproto.VT_INT24:
-					value = sqltypes.MakeNumeric(value.Raw())
-				case mproto.VT_FLOAT, mproto.VT_DOUBLE:
-					value = sqltypes.MakeFractional(value.Raw())
-				}
-			}
-			value.EncodeSql(&buf)
-		}
-		buf.WriteByte(')')
-	}
-	return buf.String()
-}
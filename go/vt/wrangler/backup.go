@@ -0,0 +1,195 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wrangler
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/mysqlctl"
+	"github.com/youtube/vitess/go/vt/mysqlctl/backupstorage"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// Backup takes a backup of a tablet into the backup storage. The
+// tablet is taken out of serving as a backup tablet while mysqld is
+// shut down, and goes back to its original type after.
+func (wr *Wrangler) Backup(tabletAlias topo.TabletAlias, concurrency int) error {
+	ti, err := wr.ts.GetTablet(tabletAlias)
+	if err != nil {
+		return err
+	}
+	if ti.Type == topo.TYPE_MASTER {
+		return fmt.Errorf("cannot backup a master tablet: %v", tabletAlias)
+	}
+	originalType := ti.Type
+
+	if err := wr.ChangeType(tabletAlias, topo.TYPE_BACKUP, false); err != nil {
+		return err
+	}
+
+	// do the work, and save the error
+	actionPath, actionErr := wr.ai.Backup(tabletAlias, &actionnode.BackupArgs{Concurrency: concurrency})
+	if actionErr == nil {
+		actionErr = wr.WaitForCompletion(actionPath)
+	}
+	if actionErr != nil {
+		log.Errorf("backup failed, still restoring tablet type: %v", actionErr)
+	}
+
+	// go back to original type
+	log.Infof("change type after backup: %v %v", tabletAlias, originalType)
+	if err := wr.ChangeType(tabletAlias, originalType, false); err != nil {
+		// failure in changing the topology type is probably worse,
+		// so returning that (we logged actionErr anyway)
+		return err
+	}
+	return actionErr
+}
+
+// RestoreFromBackup restores the latest backup of a shard into an
+// idle tablet, that then replicates from the shard master as a spare.
+func (wr *Wrangler) RestoreFromBackup(dstTabletAlias topo.TabletAlias, keyspace, shard string, concurrency int, dontWaitForSlaveStart bool) error {
+	// read our current tablet, verify its state before sending it
+	// to the tablet itself
+	tablet, err := wr.ts.GetTablet(dstTabletAlias)
+	if err != nil {
+		return err
+	}
+	if tablet.Type != topo.TYPE_IDLE {
+		return fmt.Errorf("expected idle type, not %v: %v", tablet.Type, dstTabletAlias)
+	}
+
+	// update the shard record if we need to, to update Cells
+	si, err := wr.ts.GetShard(keyspace, shard)
+	if err != nil {
+		return fmt.Errorf("Cannot read shard: %v", err)
+	}
+	if err := wr.updateShardCellsAndMaster(si, tablet.Alias, topo.TYPE_SPARE, false); err != nil {
+		return err
+	}
+
+	// do the work
+	actionPath, err := wr.ai.RestoreFromBackup(dstTabletAlias, &actionnode.RestoreFromBackupArgs{Keyspace: keyspace, Shard: shard, Concurrency: concurrency, DontWaitForSlaveStart: dontWaitForSlaveStart})
+	if err != nil {
+		return err
+	}
+
+	// RestoreFromBackup moves us into the replication graph as a
+	// spare. There are no consequences to the replication or
+	// serving graphs, so no rebuild required.
+	return wr.WaitForCompletion(actionPath)
+}
+
+// PointInTimeRecovery restores a shard into an idle tablet, at the
+// state it was in at stopAtGTID if set, or else at stopAtTimestamp
+// (in seconds since the epoch). The latest backup taken before the
+// target is restored, then the binlogs of sourceTabletAlias (the
+// shard master if zero) are replayed up to the target, within the
+// action timeout. The tablet ends up in the restore type, neither
+// replicating nor serving. It returns the position that was reached.
+func (wr *Wrangler) PointInTimeRecovery(dstTabletAlias topo.TabletAlias, keyspace, shard string, sourceTabletAlias topo.TabletAlias, concurrency int, stopAtGTID myproto.GTID, stopAtTimestamp int64) (*myproto.ReplicationPosition, error) {
+	if stopAtGTID == nil && stopAtTimestamp == 0 {
+		return nil, fmt.Errorf("PointInTimeRecovery needs a GTID or a timestamp to stop at")
+	}
+
+	// read our current tablet, verify its state before sending it
+	// to the tablet itself
+	tablet, err := wr.ts.GetTablet(dstTabletAlias)
+	if err != nil {
+		return nil, err
+	}
+	if tablet.Type != topo.TYPE_IDLE {
+		return nil, fmt.Errorf("expected idle type, not %v: %v", tablet.Type, dstTabletAlias)
+	}
+
+	// default to streaming the binlogs from the master, and update
+	// the shard record if we need to, to update Cells
+	si, err := wr.ts.GetShard(keyspace, shard)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read shard: %v", err)
+	}
+	if sourceTabletAlias.IsZero() {
+		if si.MasterAlias.IsZero() {
+			return nil, fmt.Errorf("no master in shard %v/%v to stream binlogs from", keyspace, shard)
+		}
+		sourceTabletAlias = si.MasterAlias
+	}
+	if err := wr.updateShardCellsAndMaster(si, tablet.Alias, topo.TYPE_RESTORE, false); err != nil {
+		return nil, err
+	}
+
+	// when stopping at a timestamp that has passed, everything to
+	// replay is before the current position of the source, so the
+	// replay can stop there instead of waiting for a later transaction
+	var sourceGTID myproto.GTID
+	if stopAtGTID == nil && time.Now().Unix() > stopAtTimestamp {
+		sourceTablet, err := wr.ts.GetTablet(sourceTabletAlias)
+		if err != nil {
+			return nil, err
+		}
+		var pos *myproto.ReplicationPosition
+		if sourceTablet.Type == topo.TYPE_MASTER {
+			pos, err = wr.ai.MasterPosition(sourceTablet, wr.actionTimeout())
+		} else {
+			pos, err = wr.ai.SlavePosition(sourceTablet, wr.actionTimeout())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the position of source tablet %v: %v", sourceTabletAlias, err)
+		}
+		sourceGTID = pos.MasterLogGTIDField.Value
+	}
+
+	// do the work
+	actionPath, err := wr.ai.PointInTimeRecovery(dstTabletAlias, &actionnode.PointInTimeRecoveryArgs{
+		Keyspace:          keyspace,
+		Shard:             shard,
+		SourceTabletAlias: sourceTabletAlias,
+		Concurrency:       concurrency,
+		StopAtGTID:        myproto.GTIDField{Value: stopAtGTID},
+		StopAtTimestamp:   stopAtTimestamp,
+		SourceGTID:        myproto.GTIDField{Value: sourceGTID},
+		WaitTime:          wr.actionTimeout(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	result, err := wr.WaitForCompletionReply(actionPath)
+	if err != nil {
+		return nil, err
+	}
+	return &result.(*actionnode.PointInTimeRecoveryReply).ReplicationPosition, nil
+}
+
+// ParseRecoveryTimestamp parses the timestamp to stop a point in time
+// recovery at, either in RFC 3339 format or in seconds since the epoch.
+// An empty string is 0 (no timestamp).
+func ParseRecoveryTimestamp(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.Unix(), nil
+	}
+	timestamp, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || timestamp <= 0 {
+		return 0, fmt.Errorf("invalid timestamp %v, expecting RFC 3339 or seconds since the epoch", s)
+	}
+	return timestamp, nil
+}
+
+// ListBackups returns the backups of a shard, from the oldest to the
+// newest. It reads the backup storage directly.
+func (wr *Wrangler) ListBackups(keyspace, shard string) ([]backupstorage.BackupHandle, error) {
+	bs, err := backupstorage.GetBackupStorage()
+	if err != nil {
+		return nil, err
+	}
+	return bs.ListBackups(mysqlctl.BackupBucket(keyspace, shard))
+}
@@ -41,6 +41,19 @@ func (wr *Wrangler) reparentShardBrutal(ev *events.Reparent, si *topo.ShardInfo,
 			return err
 		}
 
+		// Promote the semi-sync slave that has everything the old
+		// master acknowledged, if the master-elect doesn't.
+		ev.UpdateStatus("checking semi-sync slaves")
+		semiSyncMasterElect, err := wr.chooseSemiSyncMasterElect(slaveTabletMap, masterElectTablet)
+		if err != nil {
+			return err
+		}
+		if semiSyncMasterElect != masterElectTablet {
+			masterElectTablet = semiSyncMasterElect
+			ev.NewMaster = *masterElectTablet.Tablet
+			ev.UpdateStatus(fmt.Sprintf("picked semi-sync slave %v as master-elect", masterElectTablet.Alias))
+		}
+
 		// Check the master-elect is fit for duty - call out for hardware checks.
 		ev.UpdateStatus("checking that new master is ready to serve")
 		if err := wr.checkMasterElect(masterElectTablet); err != nil {
@@ -55,9 +68,20 @@ func (wr *Wrangler) reparentShardBrutal(ev *events.Reparent, si *topo.ShardInfo,
 			return err
 		}
 	} else {
+		// The master-elect was forced, so we can only check it
+		// has what the semi-sync slaves acknowledged.
+		ev.UpdateStatus("checking semi-sync slaves")
+		semiSyncMasterElect, err := wr.chooseSemiSyncMasterElect(slaveTabletMap, masterElectTablet)
+		if err != nil {
+			return err
+		}
+		if semiSyncMasterElect != masterElectTablet {
+			return fmt.Errorf("semi-sync slave %v acknowledged transactions forced master-elect %v doesn't have, reparent to %v to not lose them", semiSyncMasterElect.Alias, masterElectTablet.Alias, semiSyncMasterElect.Alias)
+		}
+
 		ev.UpdateStatus("stopping slave replication")
 		log.Infof("forcing reparent to same master %v", masterElectTablet.Alias)
-		err := wr.breakReplication(slaveTabletMap, masterElectTablet)
+		err = wr.breakReplication(slaveTabletMap, masterElectTablet)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,378 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wrangler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/topotools"
+	"github.com/youtube/vitess/go/vt/wrangler/events"
+)
+
+/*
+An emergency reparent is used when the master is unreachable, and
+we don't know which slave is the best master-elect:
+
+- make the old master read-only if it can still be reached, so it
+  doesn't take writes the new master won't have.
+- stop replication on all the slaves, so their positions don't move.
+- collect the slave positions. The slave that received the most
+  data from the old master (IO position) is the master-elect. Only
+  replica tablets in the preferred cell (if any) are considered.
+- the slaves that received as much data as the master-elect apply
+  their relay logs, without restarting their IO thread, so they all
+  end up at the same position.
+- promote the master-elect, and restart these slaves on it.
+
+The slaves that received less data cannot catch up from their relay
+logs. With GTID auto-positioning, they get the transactions they
+miss from the new master, so they are restarted on it too. Otherwise
+they are left stopped, and need to be scrapped and restored.
+*/
+
+// emergencyPingTimeout is how long we wait for the old master to
+// answer, before deciding it is unreachable.
+const emergencyPingTimeout = 10 * time.Second
+
+// EmergencyReparentShard promotes the most advanced slave of a shard
+// whose master is dead.
+//
+// preferredCell: if not empty, the master-elect is picked among the
+//   replicas of that cell.
+// leaveMasterReadOnly: leave the master in read-only mode, even
+//   though all the other necessary updates have been made.
+func (wr *Wrangler) EmergencyReparentShard(keyspace, shard, preferredCell string, leaveMasterReadOnly bool) error {
+	// lock the shard
+	actionNode := actionnode.EmergencyReparentShard(preferredCell)
+	lockPath, err := wr.lockShard(keyspace, shard, actionNode)
+	if err != nil {
+		return err
+	}
+
+	// do the work
+	err = wr.emergencyReparentShardLocked(keyspace, shard, preferredCell, leaveMasterReadOnly)
+
+	// and unlock
+	return wr.unlockShard(keyspace, shard, actionNode, lockPath, err)
+}
+
+func (wr *Wrangler) emergencyReparentShardLocked(keyspace, shard, preferredCell string, leaveMasterReadOnly bool) (err error) {
+	// critical read, we want up to date info (and the shard is locked).
+	shardInfo, err := wr.ts.GetShardCritical(keyspace, shard)
+	if err != nil {
+		return err
+	}
+
+	// The master cell may be the one that is down, so keep going
+	// with a partial tablet map.
+	tabletMap, err := topo.GetTabletMapForShard(wr.ts, keyspace, shard)
+	switch err {
+	case nil:
+		// keep going
+	case topo.ErrPartialResult:
+		log.Warningf("Got topo.ErrPartialResult from GetTabletMapForShard, some slaves won't be reparented")
+	default:
+		return err
+	}
+	slaveTabletMap, masterTabletMap := sortedTabletMap(tabletMap)
+
+	// Create reusable Reparent event with available info, the new
+	// master is filled in once we pick it.
+	ev := &events.Reparent{
+		ShardInfo: *shardInfo,
+	}
+	if oldMasterTablet, ok := tabletMap[shardInfo.MasterAlias]; ok {
+		ev.OldMaster = *oldMasterTablet.Tablet
+	}
+
+	defer func() {
+		if err != nil {
+			ev.UpdateStatus("failed: " + err.Error())
+		}
+	}()
+
+	ev.UpdateStatus("starting emergency")
+
+	for _, alias := range sortedAliases(masterTabletMap) {
+		ev.UpdateStatus(fmt.Sprintf("making old master %v read-only", alias))
+		if err := wr.emergencyFenceOldMaster(ev, masterTabletMap[alias]); err != nil {
+			return err
+		}
+	}
+
+	ev.UpdateStatus("stopping slave replication")
+	slaves := wr.emergencyStopSlaves(ev, slaveTabletMap)
+
+	ev.UpdateStatus("reading slave positions")
+	positions := wr.emergencySlavePositions(ev, slaves)
+	if len(positions) == 0 {
+		return fmt.Errorf("no slave position available in %v/%v, cannot pick a master-elect", keyspace, shard)
+	}
+
+	masterElectTablet, masterElectPosition, err := chooseEmergencyMasterElect(slaves, positions, preferredCell)
+	if err != nil {
+		return err
+	}
+	ev.NewMaster = *masterElectTablet.Tablet
+	if preferredCell != "" && masterElectTablet.Alias.Cell != preferredCell {
+		ev.UpdateStatus(fmt.Sprintf("no replica available in preferred cell %v, picking from all cells", preferredCell))
+	}
+	ev.UpdateStatus(fmt.Sprintf("picked %v as master-elect, it received up to %v", masterElectTablet.Alias, masterElectPosition.MapKeyIo()))
+
+	// Only the slaves that received everything the master-elect did
+	// can catch up from their relay logs. The others can be restarted
+	// on the new master if they use GTID auto-positioning.
+	catchUpTabletMap := map[topo.TabletAlias]*topo.TabletInfo{
+		masterElectTablet.Alias: masterElectTablet,
+	}
+	laggingTabletMap := make(map[topo.TabletAlias]*topo.TabletInfo)
+	for _, alias := range sortedAliases(positions) {
+		if alias == masterElectTablet.Alias {
+			continue
+		}
+		switch {
+		case positions[alias].MapKeyIo() == masterElectPosition.MapKeyIo():
+			catchUpTabletMap[alias] = slaves[alias]
+		case autoPositioned(positions[alias]):
+			ev.UpdateStatus(fmt.Sprintf("%v only received up to %v, it will get the rest from the new master", alias, positions[alias].MapKeyIo()))
+			laggingTabletMap[alias] = slaves[alias]
+		default:
+			ev.UpdateStatus(fmt.Sprintf("leaving %v stopped, it only received up to %v", alias, positions[alias].MapKeyIo()))
+		}
+	}
+
+	ev.UpdateStatus("applying relay logs")
+	catchUpPosition := &myproto.ReplicationPosition{
+		MasterLogFile:     masterElectPosition.MasterLogFileIo,
+		MasterLogPosition: masterElectPosition.MasterLogPositionIo,
+	}
+	if err := wr.emergencyCatchUp(ev, catchUpTabletMap, catchUpPosition); err != nil {
+		return err
+	}
+
+	ev.UpdateStatus("promoting new master")
+	rsd, err := wr.promoteSlave(masterElectTablet)
+	if err != nil {
+		return fmt.Errorf("promote slave failed: %v %v", err, masterElectTablet.Alias)
+	}
+	delete(catchUpTabletMap, masterElectTablet.Alias)
+	for alias, ti := range laggingTabletMap {
+		catchUpTabletMap[alias] = ti
+	}
+
+	ev.UpdateStatus("restarting slaves")
+	majorityRestart, restartSlaveErr := wr.restartSlaves(catchUpTabletMap, rsd)
+
+	for _, failedMaster := range masterTabletMap {
+		ev.UpdateStatus("scrapping old master")
+		log.Infof("scrap dead master %v", failedMaster.Alias)
+		// The master is dead so execute the action locally instead of
+		// enqueing the scrap action for an arbitrary amount of time.
+		if scrapErr := topotools.Scrap(wr.ts, failedMaster.Alias, false); scrapErr != nil {
+			log.Warningf("scrapping failed master failed: %v", scrapErr)
+		}
+	}
+
+	ev.UpdateStatus("rebuilding shard serving graph")
+	err = wr.finishReparent(shardInfo, masterElectTablet, majorityRestart, leaveMasterReadOnly)
+	if err != nil {
+		return err
+	}
+
+	ev.UpdateStatus("finished")
+
+	if restartSlaveErr != nil {
+		// This is more of a warning at this point.
+		return restartSlaveErr
+	}
+	return nil
+}
+
+// emergencyFenceOldMaster makes the old master read-only, if it
+// answers. It fails if the old master is reachable but cannot be
+// made read-only, as promoting another master would then split the
+// shard.
+func (wr *Wrangler) emergencyFenceOldMaster(ev *events.Reparent, ti *topo.TabletInfo) error {
+	if err := wr.ai.RpcPing(ti.Alias, emergencyPingTimeout); err != nil {
+		ev.UpdateStatus(fmt.Sprintf("old master %v is unreachable: %v", ti.Alias, err))
+		return nil
+	}
+	actionPath, err := wr.ai.SetReadOnly(ti.Alias)
+	if err == nil {
+		err = wr.WaitForCompletion(actionPath)
+	}
+	if err != nil {
+		return fmt.Errorf("old master %v is reachable but cannot be made read-only: %v", ti.Alias, err)
+	}
+	return nil
+}
+
+// emergencyStopSlaves stops replication on all the slaves that
+// replicate, and returns the ones that were stopped. The others are
+// left out of the reparent.
+func (wr *Wrangler) emergencyStopSlaves(ev *events.Reparent, slaveTabletMap map[topo.TabletAlias]*topo.TabletInfo) map[topo.TabletAlias]*topo.TabletInfo {
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	errs := make(map[topo.TabletAlias]error)
+	stopped := make(map[topo.TabletAlias]*topo.TabletInfo)
+	for alias, ti := range slaveTabletMap {
+		// Lag slaves are too far behind to be waited on, and the
+		// other types don't replicate.
+		if !ti.IsSlaveType() || ti.Type == topo.TYPE_LAG {
+			log.Infof("skipping emergency reparent for tablet %v %v", ti.Type, alias)
+			continue
+		}
+		wg.Add(1)
+		go func(alias topo.TabletAlias, ti *topo.TabletInfo) {
+			defer wg.Done()
+			err := wr.ai.StopSlave(ti, wr.actionTimeout())
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[alias] = err
+			} else {
+				stopped[alias] = ti
+			}
+		}(alias, ti)
+	}
+	wg.Wait()
+
+	for _, alias := range sortedAliases(errs) {
+		ev.UpdateStatus(fmt.Sprintf("leaving %v out, cannot stop its replication: %v", alias, errs[alias]))
+	}
+	return stopped
+}
+
+// emergencySlavePositions returns the replication positions of the
+// stopped slaves. The slaves that don't answer are left out.
+func (wr *Wrangler) emergencySlavePositions(ev *events.Reparent, slaves map[topo.TabletAlias]*topo.TabletInfo) map[topo.TabletAlias]*myproto.ReplicationPosition {
+	tablets := CopyMapValues(slaves, []*topo.TabletInfo{}).([]*topo.TabletInfo)
+	positionList, err := wr.tabletReplicationPositions(tablets)
+	if err != nil {
+		log.Warningf("emergencySlavePositions: %v", err)
+	}
+
+	positions := make(map[topo.TabletAlias]*myproto.ReplicationPosition)
+	var missing []string
+	for i, ti := range tablets {
+		if positionList[i] == nil {
+			missing = append(missing, ti.Alias.String())
+			continue
+		}
+		positions[ti.Alias] = positionList[i]
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		ev.UpdateStatus(fmt.Sprintf("leaving %v out, cannot read their positions", strings.Join(missing, ", ")))
+	}
+	return positions
+}
+
+// chooseEmergencyMasterElect returns the replica that received the
+// most data from the old master, in the preferred cell if it has
+// replicas, preferring a semi-sync slave on a tie. It fails if a
+// slave outside of the candidates received more, as its transactions
+// would be lost.
+func chooseEmergencyMasterElect(slaves map[topo.TabletAlias]*topo.TabletInfo, positions map[topo.TabletAlias]*myproto.ReplicationPosition, preferredCell string) (*topo.TabletInfo, *myproto.ReplicationPosition, error) {
+	var candidates []topo.TabletAlias
+	for _, alias := range sortedAliases(positions) {
+		if slaves[alias].Type == topo.TYPE_REPLICA {
+			candidates = append(candidates, alias)
+		}
+	}
+	if preferredCell != "" {
+		var inCell []topo.TabletAlias
+		for _, alias := range candidates {
+			if alias.Cell == preferredCell {
+				inCell = append(inCell, alias)
+			}
+		}
+		if len(inCell) > 0 {
+			candidates = inCell
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil, fmt.Errorf("no replica available to become master, slaves: %v", mapKeys(positions))
+	}
+
+	best := candidates[0]
+	for _, alias := range candidates[1:] {
+		if ioPositionLess(positions[best], positions[alias]) {
+			best = alias
+			continue
+		}
+		// between slaves that received as much, prefer one that
+		// acknowledged transactions as a semi-sync slave
+		if !ioPositionLess(positions[alias], positions[best]) && positions[alias].SemiSyncAcked && !positions[best].SemiSyncAcked {
+			best = alias
+		}
+	}
+	for _, alias := range sortedAliases(positions) {
+		if ioPositionLess(positions[best], positions[alias]) {
+			return nil, nil, fmt.Errorf("slave %v received up to %v, past the best master-elect %v at %v, reparent in cell %v to not lose transactions", alias, positions[alias].MapKeyIo(), best, positions[best].MapKeyIo(), alias.Cell)
+		}
+	}
+	return slaves[best], positions[best], nil
+}
+
+// emergencyCatchUp makes the slaves apply their relay logs up to
+// position. Their IO thread is not restarted, so they don't receive
+// anything new even if the old master comes back. The slaves that
+// cannot catch up are removed from tabletMap. It fails if one of
+// them is the master-elect.
+func (wr *Wrangler) emergencyCatchUp(ev *events.Reparent, tabletMap map[topo.TabletAlias]*topo.TabletInfo, position *myproto.ReplicationPosition) error {
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	errs := make(map[topo.TabletAlias]error)
+	for alias, ti := range tabletMap {
+		wg.Add(1)
+		go func(alias topo.TabletAlias, ti *topo.TabletInfo) {
+			defer wg.Done()
+			if _, err := wr.ai.ApplyRelayLogs(ti, position, wr.actionTimeout()); err != nil {
+				mu.Lock()
+				errs[alias] = err
+				mu.Unlock()
+			}
+		}(alias, ti)
+	}
+	wg.Wait()
+
+	masterElectAlias := ev.NewMaster.Alias
+	if err, ok := errs[masterElectAlias]; ok {
+		return fmt.Errorf("master-elect %v cannot apply its relay logs up to %v: %v", masterElectAlias, position.MapKey(), err)
+	}
+	for _, alias := range sortedAliases(errs) {
+		ev.UpdateStatus(fmt.Sprintf("leaving %v out, cannot apply its relay logs: %v", alias, errs[alias]))
+		delete(tabletMap, alias)
+	}
+	return nil
+}
+
+// autoPositioned returns true if the slave replicates with GTID
+// auto-positioning, so it can be restarted on any master that has the
+// transactions it misses.
+func autoPositioned(pos *myproto.ReplicationPosition) bool {
+	_, ok := pos.MasterLogGTIDField.Value.(myproto.Mysql56GTIDSet)
+	return ok
+}
+
+// sortedAliases returns the keys of a map indexed by TabletAlias, sorted.
+func sortedAliases(m interface{}) []topo.TabletAlias {
+	keys := mapKeys(m)
+	result := make([]topo.TabletAlias, len(keys))
+	for i, key := range keys {
+		result[i] = key.(topo.TabletAlias)
+	}
+	sort.Sort(topo.TabletAliasList(result))
+	return result
+}
@@ -86,6 +86,10 @@ func (wr *Wrangler) shardExternallyReparentedLocked(keyspace, shard string, mast
 
 	// sort the tablets, and handle them
 	slaveTabletMap, masterTabletMap := sortedTabletMap(tabletMap)
+	ev.UpdateStatus("checking semi-sync slaves")
+	if err = wr.checkSemiSyncMaster(slaveTabletMap, masterElectTablet); err != nil {
+		return err
+	}
 	err = wr.reparentShardExternal(ev, slaveTabletMap, masterTabletMap, masterElectTablet)
 	if err != nil {
 		log.Infof("Skipping shard rebuild with failed reparent")
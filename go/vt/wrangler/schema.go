@@ -540,3 +540,21 @@ func (wr *Wrangler) applySchemaKeyspace(keyspace string, change string, simple,
 
 	return &myproto.SchemaChangeResult{BeforeSchema: preflight.BeforeSchema, AfterSchema: preflight.AfterSchema}, nil
 }
+
+// OnlineSchemaChangeSync runs a pass of an online schema change on the
+// master of a shard: the rows of the table changed since the start
+// position are copied again into the shadow table, and if
+// args.Cutover is set the tables are swapped. It returns the position
+// the pass synced up to, and how many rows it copied.
+func (wr *Wrangler) OnlineSchemaChangeSync(masterTabletAlias topo.TabletAlias, args *actionnode.OnlineSchemaChangeSyncArgs) (*actionnode.OnlineSchemaChangeSyncReply, error) {
+	args.WaitTime = wr.actionTimeout()
+	actionPath, err := wr.ai.OnlineSchemaChangeSync(masterTabletAlias, args)
+	if err != nil {
+		return nil, err
+	}
+	result, err := wr.WaitForCompletionReply(actionPath)
+	if err != nil {
+		return nil, err
+	}
+	return result.(*actionnode.OnlineSchemaChangeSyncReply), nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wrangler
+
+import (
+	"fmt"
+
+	log "github.com/golang/glog"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// A semi-sync master doesn't commit a transaction before one of its
+// semi-sync slaves received it. When the master is lost, the
+// transactions it acknowledged to its clients are only guaranteed
+// to be on the most advanced semi-sync slave, so this is the one
+// we have to promote.
+
+// semiSyncSlavePositions returns the replication positions of the
+// slaves that acknowledge the transactions of a semi-sync master.
+// Slaves we cannot reach are skipped.
+func (wr *Wrangler) semiSyncSlavePositions(slaveTabletMap map[topo.TabletAlias]*topo.TabletInfo) map[topo.TabletAlias]*myproto.ReplicationPosition {
+	tablets := CopyMapValues(slaveTabletMap, []*topo.TabletInfo{}).([]*topo.TabletInfo)
+	positions, err := wr.tabletReplicationPositions(tablets)
+	if err != nil {
+		log.Warningf("semiSyncSlavePositions: %v", err)
+	}
+	result := make(map[topo.TabletAlias]*myproto.ReplicationPosition)
+	for i, pos := range positions {
+		if pos != nil && pos.SemiSyncAcked {
+			result[tablets[i].Alias] = pos
+		}
+	}
+	return result
+}
+
+// ioPositionLess returns true if a received less data from the
+// master than b.
+func ioPositionLess(a, b *myproto.ReplicationPosition) bool {
+	if a.MasterLogFileIo != b.MasterLogFileIo {
+		return a.MasterLogFileIo < b.MasterLogFileIo
+	}
+	return a.MasterLogPositionIo < b.MasterLogPositionIo
+}
+
+// chooseSemiSyncMasterElect returns the tablet to promote instead
+// of the dead master: the master-elect if it received everything the
+// semi-sync slaves acknowledged, or else the most advanced semi-sync
+// slave, if it is a replica. If the master-elect already is a master,
+// it only checks no semi-sync slave is past it.
+func (wr *Wrangler) chooseSemiSyncMasterElect(slaveTabletMap map[topo.TabletAlias]*topo.TabletInfo, masterElectTablet *topo.TabletInfo) (*topo.TabletInfo, error) {
+	if masterElectTablet.Type == topo.TYPE_MASTER {
+		return masterElectTablet, wr.checkSemiSyncMaster(slaveTabletMap, masterElectTablet)
+	}
+	positions := wr.semiSyncSlavePositions(slaveTabletMap)
+	if len(positions) == 0 {
+		// no semi-sync slave, nothing was acknowledged
+		return masterElectTablet, nil
+	}
+
+	var bestAlias topo.TabletAlias
+	var bestPosition *myproto.ReplicationPosition
+	for _, alias := range sortedAliases(positions) {
+		pos := positions[alias]
+		if bestPosition == nil || ioPositionLess(bestPosition, pos) {
+			bestAlias = alias
+			bestPosition = pos
+		}
+	}
+
+	masterElectPosition, ok := positions[masterElectTablet.Alias]
+	if !ok {
+		var err error
+		masterElectPosition, err = wr.ai.SlavePosition(masterElectTablet, wr.actionTimeout())
+		if err != nil {
+			return nil, fmt.Errorf("cannot get the replication position of master-elect %v: %v", masterElectTablet.Alias, err)
+		}
+	}
+	if !ioPositionLess(masterElectPosition, bestPosition) {
+		return masterElectTablet, nil
+	}
+	if slaveTabletMap[bestAlias].Type != topo.TYPE_REPLICA {
+		return nil, fmt.Errorf("master-elect %v is at %v but semi-sync %v slave %v acknowledged transactions up to %v, and cannot be promoted", masterElectTablet.Alias, masterElectPosition.MapKeyIo(), slaveTabletMap[bestAlias].Type, bestAlias, bestPosition.MapKeyIo())
+	}
+	log.Warningf("master-elect %v is at %v but semi-sync slave %v acknowledged transactions up to %v, promoting %v instead", masterElectTablet.Alias, masterElectPosition.MapKeyIo(), bestAlias, bestPosition.MapKeyIo(), bestAlias)
+	return slaveTabletMap[bestAlias], nil
+}
+
+// checkSemiSyncMaster makes sure no semi-sync slave applied
+// transactions the new master doesn't have, when the new master
+// already is one: after an external reparent, or when forcing a
+// reparent to the current master. If there are semi-sync slaves, it
+// fails when their positions can't be compared with the master's.
+func (wr *Wrangler) checkSemiSyncMaster(slaveTabletMap map[topo.TabletAlias]*topo.TabletInfo, masterElectTablet *topo.TabletInfo) error {
+	otherSlaves := make(map[topo.TabletAlias]*topo.TabletInfo, len(slaveTabletMap))
+	for alias, ti := range slaveTabletMap {
+		if alias != masterElectTablet.Alias {
+			otherSlaves[alias] = ti
+		}
+	}
+	positions := wr.semiSyncSlavePositions(otherSlaves)
+	if len(positions) == 0 {
+		// no semi-sync slave, nothing was acknowledged
+		return nil
+	}
+
+	masterPosition, err := wr.ai.MasterPosition(masterElectTablet, wr.actionTimeout())
+	if err != nil {
+		return fmt.Errorf("cannot get the position of new master %v to check its semi-sync slaves: %v", masterElectTablet.Alias, err)
+	}
+	masterSet, err := myproto.ToGTIDSet(masterPosition.MasterLogGTIDField.Value)
+	if err != nil {
+		return fmt.Errorf("cannot check the semi-sync slaves of new master %v: %v", masterElectTablet.Alias, err)
+	}
+	for _, alias := range sortedAliases(positions) {
+		slaveSet, err := myproto.ToGTIDSet(positions[alias].MasterLogGTIDField.Value)
+		if err != nil {
+			return fmt.Errorf("cannot compare semi-sync slave %v with new master %v: %v", alias, masterElectTablet.Alias, err)
+		}
+		atLeast, err := masterSet.AtLeast(slaveSet)
+		if err != nil {
+			return fmt.Errorf("cannot compare semi-sync slave %v at %v with new master %v at %v: %v", alias, slaveSet, masterElectTablet.Alias, masterSet, err)
+		}
+		if !atLeast {
+			return fmt.Errorf("semi-sync slave %v is at %v, past new master %v at %v: the transactions it acknowledged would be lost", alias, slaveSet, masterElectTablet.Alias, masterSet)
+		}
+	}
+	return nil
+}
@@ -108,12 +108,14 @@ func NewFakeTablet(t *testing.T, wr *wrangler.Wrangler, cell string, uid uint32,
 }
 
 // StartActionLoop will start the action loop for a fake tablet,
-// using ft.FakeMysqlDaemon as the backing mysqld.
+// using ft.FakeMysqlDaemon as the backing mysqld. The tablet also
+// answers the fake tablet manager RPCs.
 func (ft *FakeTablet) StartActionLoop(t *testing.T, wr *wrangler.Wrangler) {
 	if ft.Done != nil {
 		t.Fatalf("ActionLoop for %v is already running", ft.Tablet.Alias)
 	}
 	ft.Done = make(chan struct{}, 1)
+	registerRunningTablet(ft)
 	go func() {
 		wr.TopoServer().ActionEventLoop(ft.Tablet.Alias, func(actionPath, data string) error {
 			actionNode, err := actionnode.ActionNodeFromJson(data, actionPath)
@@ -146,6 +148,7 @@ func (ft *FakeTablet) StopActionLoop(t *testing.T) {
 	if ft.Done == nil {
 		t.Fatalf("ActionLoop for %v is not running", ft.Tablet.Alias)
 	}
+	unregisterRunningTablet(ft)
 	close(ft.Done)
 	ft.Done = nil
 }
@@ -0,0 +1,268 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testlib
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	blproto "github.com/youtube/vitess/go/vt/binlog/proto"
+	"github.com/youtube/vitess/go/vt/mysqlctl"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+	"github.com/youtube/vitess/go/vt/tabletmanager/initiator"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// This file contains a fake implementation of the tablet manager
+// RPCs, that works on the FakeMysqlDaemon of the fake tablets whose
+// action loop is running. The other tablets don't answer, like dead
+// ones. Use it with:
+//   flag.Set("tablet_manager_protocol", FakeTabletManagerProtocol)
+// before creating the wrangler.
+
+// FakeTabletManagerProtocol is the name of the fake tablet manager
+// protocol.
+const FakeTabletManagerProtocol = "fake"
+
+func init() {
+	initiator.RegisterTabletManagerConnFactory(FakeTabletManagerProtocol, func(ts topo.Server) initiator.TabletManagerConn {
+		return &fakeTabletManagerConn{}
+	})
+}
+
+var (
+	runningTabletsMu sync.Mutex
+	runningTablets   = make(map[topo.TabletAlias]*FakeTablet)
+)
+
+func registerRunningTablet(ft *FakeTablet) {
+	runningTabletsMu.Lock()
+	defer runningTabletsMu.Unlock()
+	runningTablets[ft.Tablet.Alias] = ft
+}
+
+func unregisterRunningTablet(ft *FakeTablet) {
+	runningTabletsMu.Lock()
+	defer runningTabletsMu.Unlock()
+	delete(runningTablets, ft.Tablet.Alias)
+}
+
+// fakeTabletManagerConn implements initiator.TabletManagerConn.
+type fakeTabletManagerConn struct{}
+
+// mysqlDaemon returns the FakeMysqlDaemon of a running tablet.
+func (client *fakeTabletManagerConn) mysqlDaemon(tablet *topo.TabletInfo) (*mysqlctl.FakeMysqlDaemon, error) {
+	runningTabletsMu.Lock()
+	defer runningTabletsMu.Unlock()
+	ft, ok := runningTablets[tablet.Alias]
+	if !ok {
+		return nil, fmt.Errorf("RPC error for %v: tablet is not running", tablet.Alias)
+	}
+	return ft.FakeMysqlDaemon, nil
+}
+
+func (client *fakeTabletManagerConn) unsupported(tablet *topo.TabletInfo, name string) error {
+	return fmt.Errorf("fake TabletManager.%v is not supported, for %v", name, tablet.Alias)
+}
+
+//
+// Various read-only methods
+//
+
+func (client *fakeTabletManagerConn) Ping(tablet *topo.TabletInfo, waitTime time.Duration) error {
+	_, err := client.mysqlDaemon(tablet)
+	return err
+}
+
+// GetSchema returns the tables of FakeMysqlDaemon.Schema that match.
+func (client *fakeTabletManagerConn) GetSchema(tablet *topo.TabletInfo, tables, excludeTables []string, includeViews bool, waitTime time.Duration) (*myproto.SchemaDefinition, error) {
+	fmd, err := client.mysqlDaemon(tablet)
+	if err != nil {
+		return nil, err
+	}
+	if fmd.Schema == nil {
+		return nil, client.unsupported(tablet, actionnode.TABLET_ACTION_GET_SCHEMA)
+	}
+	sd := &myproto.SchemaDefinition{
+		DatabaseSchema: fmd.Schema.DatabaseSchema,
+		Version:        fmd.Schema.Version,
+	}
+	for _, td := range fmd.Schema.TableDefinitions {
+		if !includeViews && td.Type != myproto.TABLE_BASE_TABLE {
+			continue
+		}
+		if len(tables) > 0 && !matchesAny(td.Name, tables) {
+			continue
+		}
+		if matchesAny(td.Name, excludeTables) {
+			continue
+		}
+		sd.TableDefinitions = append(sd.TableDefinitions, td)
+	}
+	return sd, nil
+}
+
+func matchesAny(name string, exprs []string) bool {
+	for _, expr := range exprs {
+		if ok, err := regexp.MatchString(expr, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (client *fakeTabletManagerConn) GetPermissions(tablet *topo.TabletInfo, waitTime time.Duration) (*myproto.Permissions, error) {
+	return nil, client.unsupported(tablet, actionnode.TABLET_ACTION_GET_PERMISSIONS)
+}
+
+//
+// Various read-write methods
+//
+
+func (client *fakeTabletManagerConn) ChangeType(tablet *topo.TabletInfo, dbType topo.TabletType, waitTime time.Duration) error {
+	return client.unsupported(tablet, actionnode.TABLET_ACTION_CHANGE_TYPE)
+}
+
+func (client *fakeTabletManagerConn) SetBlacklistedTables(tablet *topo.TabletInfo, tables []string, waitTime time.Duration) error {
+	return client.unsupported(tablet, actionnode.TABLET_ACTION_SET_BLACKLISTED_TABLES)
+}
+
+func (client *fakeTabletManagerConn) ReloadSchema(tablet *topo.TabletInfo, waitTime time.Duration) error {
+	_, err := client.mysqlDaemon(tablet)
+	return err
+}
+
+// ExecuteFetch runs the query with FakeMysqlDaemon.FetchSuperQuery.
+func (client *fakeTabletManagerConn) ExecuteFetch(tablet *topo.TabletInfo, query string, maxRows int, wantFields, disableBinlogs bool, waitTime time.Duration) (*mproto.QueryResult, error) {
+	fmd, err := client.mysqlDaemon(tablet)
+	if err != nil {
+		return nil, err
+	}
+	if fmd.FetchSuperQuery == nil {
+		return nil, client.unsupported(tablet, actionnode.TABLET_ACTION_EXECUTE_FETCH)
+	}
+	return fmd.FetchSuperQuery(query)
+}
+
+//
+// Replication related methods
+//
+
+func (client *fakeTabletManagerConn) SlavePosition(tablet *topo.TabletInfo, waitTime time.Duration) (*myproto.ReplicationPosition, error) {
+	fmd, err := client.mysqlDaemon(tablet)
+	if err != nil {
+		return nil, err
+	}
+	if fmd.CurrentSlaveStatus == nil {
+		return nil, mysqlctl.ErrNotSlave
+	}
+	rp := *fmd.CurrentSlaveStatus
+	return &rp, nil
+}
+
+// WaitSlavePosition returns the slave position if the slave applied
+// replicationPosition, and times out otherwise.
+func (client *fakeTabletManagerConn) WaitSlavePosition(tablet *topo.TabletInfo, replicationPosition *myproto.ReplicationPosition, waitTime time.Duration) (*myproto.ReplicationPosition, error) {
+	fmd, err := client.mysqlDaemon(tablet)
+	if err != nil {
+		return nil, err
+	}
+	if fmd.CurrentSlaveStatus == nil {
+		return nil, mysqlctl.ErrNotSlave
+	}
+	if fmd.CurrentSlaveStatus.MasterLogFile != replicationPosition.MasterLogFile || fmd.CurrentSlaveStatus.MasterLogPosition < replicationPosition.MasterLogPosition {
+		return nil, fmt.Errorf("%v only applied up to %v, timed out waiting for %v", tablet.Alias, fmd.CurrentSlaveStatus.MapKey(), replicationPosition.MapKey())
+	}
+	rp := *fmd.CurrentSlaveStatus
+	return &rp, nil
+}
+
+func (client *fakeTabletManagerConn) MasterPosition(tablet *topo.TabletInfo, waitTime time.Duration) (*myproto.ReplicationPosition, error) {
+	fmd, err := client.mysqlDaemon(tablet)
+	if err != nil {
+		return nil, err
+	}
+	return fmd.MasterStatus()
+}
+
+func (client *fakeTabletManagerConn) StopSlave(tablet *topo.TabletInfo, waitTime time.Duration) error {
+	fmd, err := client.mysqlDaemon(tablet)
+	if err != nil {
+		return err
+	}
+	fmd.Replicating = false
+	return nil
+}
+
+func (client *fakeTabletManagerConn) StopSlaveMinimum(tablet *topo.TabletInfo, gtid myproto.GTID, waitTime time.Duration) (*myproto.ReplicationPosition, error) {
+	return nil, client.unsupported(tablet, actionnode.TABLET_ACTION_STOP_SLAVE_MINIMUM)
+}
+
+func (client *fakeTabletManagerConn) StartSlave(tablet *topo.TabletInfo, waitTime time.Duration) error {
+	fmd, err := client.mysqlDaemon(tablet)
+	if err != nil {
+		return err
+	}
+	fmd.Replicating = true
+	return nil
+}
+
+// ApplyRelayLogs moves the SQL position of the slave to
+// replicationPosition, if it received it.
+func (client *fakeTabletManagerConn) ApplyRelayLogs(tablet *topo.TabletInfo, replicationPosition *myproto.ReplicationPosition, waitTime time.Duration) (*myproto.ReplicationPosition, error) {
+	fmd, err := client.mysqlDaemon(tablet)
+	if err != nil {
+		return nil, err
+	}
+	if fmd.Replicating {
+		return nil, fmt.Errorf("replication is running on %v", tablet.Alias)
+	}
+	if fmd.CurrentSlaveStatus == nil {
+		return nil, mysqlctl.ErrNotSlave
+	}
+	if fmd.CurrentSlaveStatus.MasterLogFileIo != replicationPosition.MasterLogFile || fmd.CurrentSlaveStatus.MasterLogPositionIo < replicationPosition.MasterLogPosition {
+		return nil, fmt.Errorf("%v only received up to %v", tablet.Alias, fmd.CurrentSlaveStatus.MapKeyIo())
+	}
+	fmd.CurrentSlaveStatus.MasterLogFile = replicationPosition.MasterLogFile
+	fmd.CurrentSlaveStatus.MasterLogPosition = replicationPosition.MasterLogPosition
+	rp := *fmd.CurrentSlaveStatus
+	return &rp, nil
+}
+
+func (client *fakeTabletManagerConn) GetSlaves(tablet *topo.TabletInfo, waitTime time.Duration) ([]string, error) {
+	return nil, client.unsupported(tablet, actionnode.TABLET_ACTION_GET_SLAVES)
+}
+
+func (client *fakeTabletManagerConn) WaitBlpPosition(tablet *topo.TabletInfo, blpPosition blproto.BlpPosition, waitTime time.Duration) error {
+	return client.unsupported(tablet, actionnode.TABLET_ACTION_WAIT_BLP_POSITION)
+}
+
+func (client *fakeTabletManagerConn) StopBlp(tablet *topo.TabletInfo, waitTime time.Duration) (*blproto.BlpPositionList, error) {
+	return nil, client.unsupported(tablet, actionnode.TABLET_ACTION_STOP_BLP)
+}
+
+func (client *fakeTabletManagerConn) StartBlp(tablet *topo.TabletInfo, waitTime time.Duration) error {
+	return client.unsupported(tablet, actionnode.TABLET_ACTION_START_BLP)
+}
+
+func (client *fakeTabletManagerConn) RunBlpUntil(tablet *topo.TabletInfo, positions *blproto.BlpPositionList, waitTime time.Duration) (*myproto.ReplicationPosition, error) {
+	return nil, client.unsupported(tablet, actionnode.TABLET_ACTION_RUN_BLP_UNTIL)
+}
+
+//
+// Reparenting related functions
+//
+
+func (client *fakeTabletManagerConn) SlaveWasPromoted(tablet *topo.TabletInfo, waitTime time.Duration) error {
+	return client.unsupported(tablet, actionnode.TABLET_ACTION_SLAVE_WAS_PROMOTED)
+}
+
+func (client *fakeTabletManagerConn) SlaveWasRestarted(tablet *topo.TabletInfo, args *actionnode.SlaveWasRestartedArgs, waitTime time.Duration) error {
+	return client.unsupported(tablet, actionnode.TABLET_ACTION_SLAVE_WAS_RESTARTED)
+}
@@ -0,0 +1,85 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testlib
+
+import (
+	"strings"
+	"testing"
+
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+func TestReparentShardBrutalSemiSync(t *testing.T) {
+	ts, wr := newEmergencyWrangler(t)
+
+	// The old master is dead, and not in the shard record any
+	// more. Both slaves applied the same, but only the semi-sync
+	// slave received the last transactions.
+	oldMaster := NewFakeTablet(t, wr, "cell1", 0, topo.TYPE_MASTER)
+	masterElect := NewFakeTablet(t, wr, "cell1", 1, topo.TYPE_REPLICA,
+		TabletParent(oldMaster.Tablet.Alias))
+	semiSyncSlave := NewFakeTablet(t, wr, "cell1", 2, topo.TYPE_REPLICA,
+		TabletParent(oldMaster.Tablet.Alias))
+
+	si, err := ts.GetShard("test_keyspace", "0")
+	if err != nil {
+		t.Fatalf("GetShard failed: %v", err)
+	}
+	si.MasterAlias = topo.TabletAlias{}
+	if err := ts.UpdateShard(si); err != nil {
+		t.Fatalf("UpdateShard failed: %v", err)
+	}
+
+	emergencySlave(masterElect, 300, 300, nil)
+	masterElect.StartActionLoop(t, wr)
+	defer masterElect.StopActionLoop(t)
+
+	emergencySlave(semiSyncSlave, 400, 300, nil)
+	semiSyncSlave.FakeMysqlDaemon.CurrentSlaveStatus.SemiSyncAcked = true
+	semiSyncSlave.FakeMysqlDaemon.PromoteSlaveResult = &myproto.ReplicationState{
+		MasterHost: "102.0.0.1",
+		MasterPort: 3302,
+	}
+	semiSyncSlave.StartActionLoop(t, wr)
+	defer semiSyncSlave.StopActionLoop(t)
+
+	// the semi-sync slave is promoted instead of the master-elect
+	if err := wr.ReparentShard("test_keyspace", "0", masterElect.Tablet.Alias, false, false); err != nil {
+		t.Fatalf("ReparentShard failed: %v", err)
+	}
+	checkNewMaster(t, ts, semiSyncSlave, oldMaster)
+	if !masterElect.FakeMysqlDaemon.Replicating || masterElect.FakeMysqlDaemon.MasterAddr != semiSyncSlave.Tablet.MysqlIpAddr() {
+		t.Errorf("master-elect was not restarted on the semi-sync slave: %#v", masterElect.FakeMysqlDaemon)
+	}
+}
+
+func TestReparentShardBrutalForceSemiSync(t *testing.T) {
+	_, wr := newEmergencyWrangler(t)
+
+	// The master lost transactions a semi-sync slave acknowledged.
+	master := NewFakeTablet(t, wr, "cell1", 0, topo.TYPE_MASTER)
+	semiSyncSlave := NewFakeTablet(t, wr, "cell1", 1, topo.TYPE_REPLICA,
+		TabletParent(master.Tablet.Alias))
+
+	master.FakeMysqlDaemon.CurrentMasterPosition = &myproto.ReplicationPosition{
+		MasterLogGTIDField: myproto.GTIDField{Value: myproto.GoogleGTID{GroupID: 12}},
+	}
+	master.StartActionLoop(t, wr)
+	defer master.StopActionLoop(t)
+
+	emergencySlave(semiSyncSlave, 400, 400, myproto.GoogleGTID{GroupID: 14})
+	semiSyncSlave.FakeMysqlDaemon.CurrentSlaveStatus.SemiSyncAcked = true
+	semiSyncSlave.StartActionLoop(t, wr)
+	defer semiSyncSlave.StopActionLoop(t)
+
+	err := wr.ReparentShard("test_keyspace", "0", master.Tablet.Alias, false, true)
+	if err == nil || !strings.Contains(err.Error(), "semi-sync slave "+semiSyncSlave.Tablet.Alias.String()) {
+		t.Fatalf("forced ReparentShard with a semi-sync slave ahead: got %v, want a semi-sync slave error", err)
+	}
+	if !semiSyncSlave.FakeMysqlDaemon.Replicating {
+		t.Errorf("replication was stopped on the semi-sync slave")
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testlib
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/memorytopo"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/wrangler"
+)
+
+// emergencySlave makes ft a read-only replicating slave, that
+// received up to ioPosition and applied up to sqlPosition.
+func emergencySlave(ft *FakeTablet, ioPosition, sqlPosition uint, gtid myproto.GTID) {
+	ft.FakeMysqlDaemon.ReadOnly = true
+	ft.FakeMysqlDaemon.Replicating = true
+	ft.FakeMysqlDaemon.CurrentSlaveStatus = &myproto.ReplicationPosition{
+		MasterLogFile:       "vt-0000000000-bin.000003",
+		MasterLogPosition:   sqlPosition,
+		MasterLogGTIDField:  myproto.GTIDField{Value: gtid},
+		MasterLogFileIo:     "vt-0000000000-bin.000003",
+		MasterLogPositionIo: ioPosition,
+	}
+}
+
+func newEmergencyWrangler(t *testing.T) (topo.Server, *wrangler.Wrangler) {
+	flag.Set("tablet_manager_protocol", FakeTabletManagerProtocol)
+	ts := memorytopo.NewTestServer(t, []string{"cell1", "cell2"})
+	wr := wrangler.New(ts, time.Minute, time.Second)
+	wr.UseRPCs = false
+	return ts, wr
+}
+
+func checkNewMaster(t *testing.T, ts topo.Server, newMaster, oldMaster *FakeTablet) {
+	if newMaster.FakeMysqlDaemon.ReadOnly || newMaster.FakeMysqlDaemon.MasterAddr != "" {
+		t.Errorf("master-elect was not promoted: %#v", newMaster.FakeMysqlDaemon)
+	}
+	si, err := ts.GetShard("test_keyspace", "0")
+	if err != nil {
+		t.Fatalf("GetShard failed: %v", err)
+	}
+	if si.MasterAlias != newMaster.Tablet.Alias {
+		t.Errorf("shard master is %v, want %v", si.MasterAlias, newMaster.Tablet.Alias)
+	}
+	ti, err := ts.GetTablet(oldMaster.Tablet.Alias)
+	if err != nil {
+		t.Fatalf("GetTablet failed: %v", err)
+	}
+	if ti.Type != topo.TYPE_SCRAP {
+		t.Errorf("old master was not scrapped: %v", ti.Type)
+	}
+}
+
+func TestEmergencyReparentShard(t *testing.T) {
+	ts, wr := newEmergencyWrangler(t)
+
+	// The old master is dead. The replica in cell1 received the
+	// most, the replica in cell2 as much, the rdonly less.
+	oldMaster := NewFakeTablet(t, wr, "cell1", 0, topo.TYPE_MASTER)
+	newMaster := NewFakeTablet(t, wr, "cell1", 1, topo.TYPE_REPLICA,
+		TabletParent(oldMaster.Tablet.Alias))
+	goodSlave := NewFakeTablet(t, wr, "cell2", 2, topo.TYPE_REPLICA,
+		TabletParent(oldMaster.Tablet.Alias))
+	laggingSlave := NewFakeTablet(t, wr, "cell1", 3, topo.TYPE_RDONLY,
+		TabletParent(oldMaster.Tablet.Alias))
+
+	emergencySlave(newMaster, 400, 300, nil)
+	newMaster.FakeMysqlDaemon.PromoteSlaveResult = &myproto.ReplicationState{
+		MasterHost: "101.0.0.1",
+		MasterPort: 3301,
+	}
+	newMaster.StartActionLoop(t, wr)
+	defer newMaster.StopActionLoop(t)
+
+	emergencySlave(goodSlave, 400, 200, nil)
+	goodSlave.StartActionLoop(t, wr)
+	defer goodSlave.StopActionLoop(t)
+
+	emergencySlave(laggingSlave, 300, 300, nil)
+	laggingSlave.StartActionLoop(t, wr)
+	defer laggingSlave.StopActionLoop(t)
+
+	if err := wr.EmergencyReparentShard("test_keyspace", "0", "cell1", false); err != nil {
+		t.Fatalf("EmergencyReparentShard failed: %v", err)
+	}
+	checkNewMaster(t, ts, newMaster, oldMaster)
+
+	// the good slave applied its relay logs, and was restarted
+	// on the new master
+	if pos := goodSlave.FakeMysqlDaemon.CurrentSlaveStatus.MasterLogPosition; pos != 400 {
+		t.Errorf("good slave didn't apply its relay logs: %v", pos)
+	}
+	if !goodSlave.FakeMysqlDaemon.Replicating || goodSlave.FakeMysqlDaemon.MasterAddr != newMaster.Tablet.MysqlIpAddr() {
+		t.Errorf("good slave was not restarted on the new master: %#v", goodSlave.FakeMysqlDaemon)
+	}
+
+	// the lagging slave was left stopped, on the old master
+	if laggingSlave.FakeMysqlDaemon.Replicating || laggingSlave.FakeMysqlDaemon.MasterAddr != oldMaster.Tablet.MysqlIpAddr() {
+		t.Errorf("lagging slave should have been left stopped: %#v", laggingSlave.FakeMysqlDaemon)
+	}
+}
+
+func TestEmergencyReparentShardGTID(t *testing.T) {
+	ts, wr := newEmergencyWrangler(t)
+	sid, err := myproto.ParseSID("00010203-0405-0607-0809-0a0b0c0d0e0f")
+	if err != nil {
+		t.Fatalf("ParseSID failed: %v", err)
+	}
+
+	// The old master still answers, but it is not replicating to
+	// its slaves any more.
+	oldMaster := NewFakeTablet(t, wr, "cell1", 0, topo.TYPE_MASTER)
+	newMaster := NewFakeTablet(t, wr, "cell1", 1, topo.TYPE_REPLICA,
+		TabletParent(oldMaster.Tablet.Alias))
+	laggingSlave := NewFakeTablet(t, wr, "cell1", 2, topo.TYPE_REPLICA,
+		TabletParent(oldMaster.Tablet.Alias))
+
+	oldMaster.StartActionLoop(t, wr)
+	defer oldMaster.StopActionLoop(t)
+
+	emergencySlave(newMaster, 400, 400, myproto.NewMysql56GTIDSet(sid, 12))
+	newMaster.FakeMysqlDaemon.PromoteSlaveResult = &myproto.ReplicationState{
+		MasterHost: "101.0.0.1",
+		MasterPort: 3301,
+	}
+	newMaster.StartActionLoop(t, wr)
+	defer newMaster.StopActionLoop(t)
+
+	emergencySlave(laggingSlave, 300, 300, myproto.NewMysql56GTIDSet(sid, 10))
+	laggingSlave.StartActionLoop(t, wr)
+	defer laggingSlave.StopActionLoop(t)
+
+	if err := wr.EmergencyReparentShard("test_keyspace", "0", "", false); err != nil {
+		t.Fatalf("EmergencyReparentShard failed: %v", err)
+	}
+	checkNewMaster(t, ts, newMaster, oldMaster)
+
+	if !oldMaster.FakeMysqlDaemon.ReadOnly {
+		t.Errorf("reachable old master was not made read-only")
+	}
+
+	// the lagging slave gets what it misses from the new master
+	if !laggingSlave.FakeMysqlDaemon.Replicating || laggingSlave.FakeMysqlDaemon.MasterAddr != newMaster.Tablet.MysqlIpAddr() {
+		t.Errorf("lagging slave was not restarted on the new master: %#v", laggingSlave.FakeMysqlDaemon)
+	}
+}
+
+func TestEmergencyReparentShardSemiSync(t *testing.T) {
+	ts, wr := newEmergencyWrangler(t)
+
+	// Both replicas received as much, the semi-sync one is
+	// promoted.
+	oldMaster := NewFakeTablet(t, wr, "cell1", 0, topo.TYPE_MASTER)
+	goodSlave := NewFakeTablet(t, wr, "cell1", 1, topo.TYPE_REPLICA,
+		TabletParent(oldMaster.Tablet.Alias))
+	newMaster := NewFakeTablet(t, wr, "cell1", 2, topo.TYPE_REPLICA,
+		TabletParent(oldMaster.Tablet.Alias))
+
+	emergencySlave(goodSlave, 400, 400, nil)
+	goodSlave.StartActionLoop(t, wr)
+	defer goodSlave.StopActionLoop(t)
+
+	emergencySlave(newMaster, 400, 400, nil)
+	newMaster.FakeMysqlDaemon.CurrentSlaveStatus.SemiSyncAcked = true
+	newMaster.FakeMysqlDaemon.PromoteSlaveResult = &myproto.ReplicationState{
+		MasterHost: "102.0.0.1",
+		MasterPort: 3302,
+	}
+	newMaster.StartActionLoop(t, wr)
+	defer newMaster.StopActionLoop(t)
+
+	if err := wr.EmergencyReparentShard("test_keyspace", "0", "", false); err != nil {
+		t.Fatalf("EmergencyReparentShard failed: %v", err)
+	}
+	checkNewMaster(t, ts, newMaster, oldMaster)
+}
@@ -5,18 +5,28 @@
 package testlib
 
 import (
+	"flag"
 	"strings"
 	"testing"
 	"time"
 
-	_ "github.com/youtube/vitess/go/vt/tabletmanager/gorpctmclient"
+	"github.com/youtube/vitess/go/vt/memorytopo"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/wrangler"
 	"github.com/youtube/vitess/go/vt/zktopo"
 )
 
 func TestShardExternallyReparented(t *testing.T) {
-	ts := zktopo.NewTestServer(t, []string{"cell1", "cell2"})
+	testShardExternallyReparented(t, zktopo.NewTestServer(t, []string{"cell1", "cell2"}))
+}
+
+func TestShardExternallyReparentedMemory(t *testing.T) {
+	testShardExternallyReparented(t, memorytopo.NewTestServer(t, []string{"cell1", "cell2"}))
+}
+
+func testShardExternallyReparented(t *testing.T, ts topo.Server) {
+	flag.Set("tablet_manager_protocol", FakeTabletManagerProtocol)
 	wr := wrangler.New(ts, time.Minute, time.Second)
 	wr.UseRPCs = false
 
@@ -145,7 +155,15 @@ func TestShardExternallyReparented(t *testing.T) {
 // that if mysql is restarted on the master-elect tablet and has a different
 // port, we pick it up correctly.
 func TestShardExternallyReparentedWithDifferentMysqlPort(t *testing.T) {
-	ts := zktopo.NewTestServer(t, []string{"cell1"})
+	testShardExternallyReparentedWithDifferentMysqlPort(t, zktopo.NewTestServer(t, []string{"cell1"}))
+}
+
+func TestShardExternallyReparentedWithDifferentMysqlPortMemory(t *testing.T) {
+	testShardExternallyReparentedWithDifferentMysqlPort(t, memorytopo.NewTestServer(t, []string{"cell1"}))
+}
+
+func testShardExternallyReparentedWithDifferentMysqlPort(t *testing.T, ts topo.Server) {
+	flag.Set("tablet_manager_protocol", FakeTabletManagerProtocol)
 	wr := wrangler.New(ts, time.Minute, time.Second)
 	wr.UseRPCs = false
 
@@ -189,7 +207,15 @@ func TestShardExternallyReparentedWithDifferentMysqlPort(t *testing.T) {
 // TestShardExternallyReparentedContinueOnUnexpectedMaster makes sure
 // that we ignore mysql's master if the flag is set
 func TestShardExternallyReparentedContinueOnUnexpectedMaster(t *testing.T) {
-	ts := zktopo.NewTestServer(t, []string{"cell1"})
+	testShardExternallyReparentedContinueOnUnexpectedMaster(t, zktopo.NewTestServer(t, []string{"cell1"}))
+}
+
+func TestShardExternallyReparentedContinueOnUnexpectedMasterMemory(t *testing.T) {
+	testShardExternallyReparentedContinueOnUnexpectedMaster(t, memorytopo.NewTestServer(t, []string{"cell1"}))
+}
+
+func testShardExternallyReparentedContinueOnUnexpectedMaster(t *testing.T, ts topo.Server) {
+	flag.Set("tablet_manager_protocol", FakeTabletManagerProtocol)
 	wr := wrangler.New(ts, time.Minute, time.Second)
 	wr.UseRPCs = false
 
@@ -226,3 +252,78 @@ func TestShardExternallyReparentedContinueOnUnexpectedMaster(t *testing.T) {
 		t.Fatalf("ShardExternallyReparented(replica) failed: %v", err)
 	}
 }
+
+// TestShardExternallyReparentedSemiSync makes sure we don't accept a
+// new master that misses transactions a semi-sync slave acknowledged.
+func TestShardExternallyReparentedSemiSync(t *testing.T) {
+	testShardExternallyReparentedSemiSync(t, zktopo.NewTestServer(t, []string{"cell1"}))
+}
+
+func TestShardExternallyReparentedSemiSyncMemory(t *testing.T) {
+	testShardExternallyReparentedSemiSync(t, memorytopo.NewTestServer(t, []string{"cell1"}))
+}
+
+func testShardExternallyReparentedSemiSync(t *testing.T, ts topo.Server) {
+	flag.Set("tablet_manager_protocol", FakeTabletManagerProtocol)
+	wr := wrangler.New(ts, time.Minute, time.Second)
+	wr.UseRPCs = false
+
+	oldMaster := NewFakeTablet(t, wr, "cell1", 0, topo.TYPE_MASTER)
+	newMaster := NewFakeTablet(t, wr, "cell1", 1, topo.TYPE_REPLICA,
+		TabletParent(oldMaster.Tablet.Alias))
+	semiSyncSlave := NewFakeTablet(t, wr, "cell1", 2, topo.TYPE_REPLICA,
+		TabletParent(oldMaster.Tablet.Alias))
+
+	newMaster.FakeMysqlDaemon.MasterAddr = ""
+	newMaster.FakeMysqlDaemon.CurrentMasterPosition = &myproto.ReplicationPosition{
+		MasterLogGTIDField: myproto.GTIDField{Value: myproto.GoogleGTID{GroupID: 12}},
+	}
+	newMaster.StartActionLoop(t, wr)
+	defer newMaster.StopActionLoop(t)
+
+	oldMaster.FakeMysqlDaemon.MasterAddr = newMaster.Tablet.MysqlIpAddr()
+	oldMaster.StartActionLoop(t, wr)
+	defer oldMaster.StopActionLoop(t)
+
+	// the semi-sync slave acknowledged a transaction the new
+	// master doesn't have
+	semiSyncSlave.FakeMysqlDaemon.MasterAddr = newMaster.Tablet.MysqlIpAddr()
+	semiSyncSlave.FakeMysqlDaemon.CurrentSlaveStatus = &myproto.ReplicationPosition{
+		MasterLogGTIDField: myproto.GTIDField{Value: myproto.GoogleGTID{GroupID: 14}},
+		SemiSyncAcked:      true,
+	}
+	semiSyncSlave.StartActionLoop(t, wr)
+	defer semiSyncSlave.StopActionLoop(t)
+
+	if err := wr.ShardExternallyReparented("test_keyspace", "0", newMaster.Tablet.Alias); err == nil || !strings.Contains(err.Error(), "semi-sync slave "+semiSyncSlave.Tablet.Alias.String()) {
+		t.Fatalf("ShardExternallyReparented with a semi-sync slave ahead: got %v, want a semi-sync slave error", err)
+	}
+
+	// positions that can't be compared fail the reparent
+	semiSyncSlave.FakeMysqlDaemon.CurrentSlaveStatus.MasterLogGTIDField.Value = myproto.MariadbGTID{Domain: 0, Server: 1, Sequence: 14}
+	if err := wr.ShardExternallyReparented("test_keyspace", "0", newMaster.Tablet.Alias); err == nil || !strings.Contains(err.Error(), "cannot compare semi-sync slave "+semiSyncSlave.Tablet.Alias.String()) {
+		t.Fatalf("ShardExternallyReparented with positions of different flavors: got %v, want a compare error", err)
+	}
+	semiSyncSlave.FakeMysqlDaemon.CurrentSlaveStatus.MasterLogGTIDField.Value = myproto.GoogleGTID{GroupID: 14}
+
+	// so does a new master without a position
+	masterPosition := newMaster.FakeMysqlDaemon.CurrentMasterPosition
+	newMaster.FakeMysqlDaemon.CurrentMasterPosition = nil
+	if err := wr.ShardExternallyReparented("test_keyspace", "0", newMaster.Tablet.Alias); err == nil || !strings.Contains(err.Error(), "cannot get the position of new master") {
+		t.Fatalf("ShardExternallyReparented without a master position: got %v, want a position error", err)
+	}
+	newMaster.FakeMysqlDaemon.CurrentMasterPosition = masterPosition
+
+	// once the new master has it, the reparent goes through
+	newMaster.FakeMysqlDaemon.CurrentMasterPosition.MasterLogGTIDField.Value = myproto.GoogleGTID{GroupID: 14}
+	if err := wr.ShardExternallyReparented("test_keyspace", "0", newMaster.Tablet.Alias); err != nil {
+		t.Fatalf("ShardExternallyReparented failed: %v", err)
+	}
+	si, err := ts.GetShard("test_keyspace", "0")
+	if err != nil {
+		t.Fatalf("GetShard failed: %v", err)
+	}
+	if si.MasterAlias != newMaster.Tablet.Alias {
+		t.Errorf("shard master is %v, want %v", si.MasterAlias, newMaster.Tablet.Alias)
+	}
+}
@@ -7,6 +7,7 @@ package zktopo
 import (
 	"fmt"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -127,3 +128,56 @@ func (zkts *Server) LockSrvShardForAction(cell, keyspace, shard, contents string
 func (zkts *Server) UnlockSrvShardForAction(cell, keyspace, shard, lockPath, results string) error {
 	return zkts.unlockForAction(lockPath, results)
 }
+
+func (zkts *Server) LockShardForElection(keyspace, shard, contents string, timeout time.Duration, interrupted chan struct{}) (string, error) {
+	// Don't create an election for a shard that doesn't exist.
+	shardPath := path.Join(globalKeyspacesPath, keyspace, "shards", shard)
+	if stat, err := zkts.zconn.Exists(shardPath); err != nil {
+		return "", err
+	} else if stat == nil {
+		return "", topo.ErrNoNode
+	}
+
+	// The election directory is created on demand. The election
+	// nodes are ephemeral, so they go away with the holder's session.
+	electionDir := path.Join(shardPath, "election")
+	p, err := zkts.lockForAction(electionDir+"/", contents, timeout, interrupted)
+	if err != nil && zookeeper.IsError(err, zookeeper.ZNONODE) {
+		_, err = zk.CreateRecursive(zkts.zconn, electionDir, "", 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
+		if err != nil && !zookeeper.IsError(err, zookeeper.ZNODEEXISTS) {
+			return "", err
+		}
+		p, err = zkts.lockForAction(electionDir+"/", contents, timeout, interrupted)
+	}
+	return p, err
+}
+
+func (zkts *Server) UnlockShardForElection(keyspace, shard, lockPath string) error {
+	// there is no actionlog for elections, just delete the node
+	return zkts.zconn.Delete(lockPath, -1)
+}
+
+func (zkts *Server) GetShardElectionLeader(keyspace, shard string) (string, error) {
+	electionDir := path.Join(globalKeyspacesPath, keyspace, "shards", shard, "election")
+	children, _, err := zkts.zconn.Children(electionDir)
+	if err != nil {
+		if zookeeper.IsError(err, zookeeper.ZNONODE) {
+			err = topo.ErrNoNode
+		}
+		return "", err
+	}
+	if len(children) == 0 {
+		return "", topo.ErrNoNode
+	}
+
+	// the lowest sequence number holds the lock
+	sort.Strings(children)
+	data, _, err := zkts.zconn.Get(path.Join(electionDir, children[0]))
+	if err != nil {
+		if zookeeper.IsError(err, zookeeper.ZNONODE) {
+			err = topo.ErrNoNode
+		}
+		return "", err
+	}
+	return data, nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zktopo
+
+import (
+	"path"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/zk"
+	"launchpad.net/gozk/zookeeper"
+)
+
+/*
+This file contains the query rules management code for zktopo.Server
+*/
+
+func zkPathForQueryRules(keyspace, shard string) string {
+	return path.Join(globalKeyspacesPath, keyspace, "shards", shard, "query_rules")
+}
+
+// SaveQueryRules is part of the topo.Server interface
+func (zkts *Server) SaveQueryRules(keyspace, shard, rules string) error {
+	_, err := zk.CreateOrUpdate(zkts.zconn, zkPathForQueryRules(keyspace, shard), rules, 0, zookeeper.WorldACL(zookeeper.PERM_ALL), false)
+	if zookeeper.IsError(err, zookeeper.ZNONODE) {
+		err = topo.ErrNoNode
+	}
+	return err
+}
+
+// GetQueryRules is part of the topo.Server interface
+func (zkts *Server) GetQueryRules(keyspace, shard string) (string, error) {
+	data, _, err := zkts.zconn.Get(zkPathForQueryRules(keyspace, shard))
+	if err != nil {
+		if zookeeper.IsError(err, zookeeper.ZNONODE) {
+			err = topo.ErrNoNode
+		}
+		return "", err
+	}
+	return data, nil
+}
+
+// WatchQueryRules is part of the topo.Server interface
+func (zkts *Server) WatchQueryRules(keyspace, shard string, stopWatching chan struct{}) (<-chan string, error) {
+	if !zk.CanWatch(zkts.zconn) {
+		return nil, errWatchNotSupported
+	}
+	notifications := make(chan string, 10)
+	go func() {
+		defer close(notifications)
+		zkts.watchNode(zkPathForQueryRules(keyspace, shard), stopWatching, func(data string, stat zk.Stat) bool {
+			select {
+			case notifications <- data:
+				return true
+			case <-stopWatching:
+				return false
+			}
+		})
+	}()
+	return notifications, nil
+}
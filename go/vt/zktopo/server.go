@@ -29,6 +29,12 @@ func (zkts *Server) GetZConn() zk.Conn {
 	return zkts.zconn
 }
 
+// CanWatch returns false if the zk connection can't watch, e.g. over
+// zkocc. The Watch methods return an error then.
+func (zkts *Server) CanWatch() bool {
+	return zk.CanWatch(zkts.zconn)
+}
+
 // NewServer can be used to create a custom Server
 // (for tests for instance) but it cannot change the globally
 // registered one.
@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"path"
 	"sort"
+	"time"
 
+	log "github.com/golang/glog"
 	"github.com/youtube/vitess/go/jscfg"
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/zk"
@@ -231,3 +233,108 @@ func (zkts *Server) UpdateTabletEndpoint(cell, keyspace, shard string, tabletTyp
 	}
 	return err
 }
+
+// errWatchNotSupported is returned by the Watch methods when the
+// underlying zk.Conn can't watch, e.g. over zkocc.
+var errWatchNotSupported = fmt.Errorf("watches are not supported by this zk connection")
+
+// watchNode watches zkPath, and calls notify with its contents every
+// time it changes, until stopWatching is closed or notify returns
+// false. stat is nil if the node doesn't exist. ZooKeeper errors are
+// retried every 5 seconds.
+func (zkts *Server) watchNode(zkPath string, stopWatching chan struct{}, notify func(data string, stat zk.Stat) bool) {
+	for {
+		data, stat, watch, err := zkts.zconn.GetW(zkPath)
+		if zookeeper.IsError(err, zookeeper.ZNONODE) {
+			// The node doesn't exist, wait for its creation.
+			stat, watch, err = zkts.zconn.ExistsW(zkPath)
+			if err == nil && stat != nil {
+				// It was created in the meantime, read it.
+				continue
+			}
+		}
+		if err != nil {
+			log.Warningf("watch on %v failed, will retry in 5 seconds: %v", zkPath, err)
+			select {
+			case <-time.After(5 * time.Second):
+				continue
+			case <-stopWatching:
+				return
+			}
+		}
+		if !notify(data, stat) {
+			return
+		}
+
+		select {
+		case event := <-watch:
+			if !event.Ok() {
+				// Reconnects are handled by zk.Conn, setting the
+				// watch again will handle a disconnect.
+				log.Warningf("unexpected zk event on %v: %v", zkPath, event)
+			}
+		case <-stopWatching:
+			return
+		}
+	}
+}
+
+func (zkts *Server) WatchEndPoints(cell, keyspace, shard string, tabletType topo.TabletType, stopWatching chan struct{}) (<-chan *topo.EndPoints, error) {
+	if !zk.CanWatch(zkts.zconn) {
+		return nil, errWatchNotSupported
+	}
+	path := zkPathForVtName(cell, keyspace, shard, tabletType)
+	notifications := make(chan *topo.EndPoints, 10)
+	go func() {
+		defer close(notifications)
+		zkts.watchNode(path, stopWatching, func(data string, stat zk.Stat) bool {
+			var addrs *topo.EndPoints
+			if stat != nil {
+				addrs = topo.NewEndPoints()
+				if len(data) > 0 {
+					if err := json.Unmarshal([]byte(data), addrs); err != nil {
+						log.Errorf("EndPoints unmarshal failed: %v %v", data, err)
+						return true
+					}
+				}
+			}
+			select {
+			case notifications <- addrs:
+				return true
+			case <-stopWatching:
+				return false
+			}
+		})
+	}()
+	return notifications, nil
+}
+
+func (zkts *Server) WatchSrvKeyspace(cell, keyspace string, stopWatching chan struct{}) (<-chan *topo.SrvKeyspace, error) {
+	if !zk.CanWatch(zkts.zconn) {
+		return nil, errWatchNotSupported
+	}
+	path := zkPathForVtKeyspace(cell, keyspace)
+	notifications := make(chan *topo.SrvKeyspace, 10)
+	go func() {
+		defer close(notifications)
+		zkts.watchNode(path, stopWatching, func(data string, stat zk.Stat) bool {
+			var srvKeyspace *topo.SrvKeyspace
+			if stat != nil {
+				srvKeyspace = topo.NewSrvKeyspace(int64(stat.Version()))
+				if len(data) > 0 {
+					if err := json.Unmarshal([]byte(data), srvKeyspace); err != nil {
+						log.Errorf("SrvKeyspace unmarshal failed: %v %v", data, err)
+						return true
+					}
+				}
+			}
+			select {
+			case notifications <- srvKeyspace:
+				return true
+			case <-stopWatching:
+				return false
+			}
+		})
+	}()
+	return notifications, nil
+}
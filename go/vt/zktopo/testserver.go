@@ -16,8 +16,16 @@ type TestServer struct {
 }
 
 func NewTestServer(t *testing.T, cells []string) topo.Server {
-	zconn := fakezk.NewConn()
+	return newTestServer(t, cells, fakezk.NewConn())
+}
+
+// NewTestServerWithoutWatches returns a test server that can't
+// watch, like the zkocc one.
+func NewTestServerWithoutWatches(t *testing.T, cells []string) topo.Server {
+	return newTestServer(t, cells, noWatchConn{fakezk.NewConn()})
+}
 
+func newTestServer(t *testing.T, cells []string, zconn zk.Conn) topo.Server {
 	// create the toplevel zk paths
 	if _, err := zk.CreateRecursive(zconn, "/zk/global/vt", "", 0, zookeeper.WorldACL(zookeeper.PERM_ALL)); err != nil {
 		t.Fatalf("cannot init ZooKeeper: %v", err)
@@ -33,3 +41,30 @@ func NewTestServer(t *testing.T, cells []string) topo.Server {
 func (s TestServer) GetKnownCells() ([]string, error) {
 	return s.localCells, nil
 }
+
+// CanWatch returns false for the servers of NewTestServerWithoutWatches.
+func (s TestServer) CanWatch() bool {
+	return topo.CanWatch(s.Server)
+}
+
+// noWatchConn is a zk.Conn that doesn't support watches, and panics
+// like zk.ZkoccConn if they're used.
+type noWatchConn struct {
+	zk.Conn
+}
+
+func (conn noWatchConn) CanWatch() bool {
+	return false
+}
+
+func (conn noWatchConn) GetW(path string) (string, zk.Stat, <-chan zookeeper.Event, error) {
+	panic(zk.ZkoccUnimplementedError("GetW"))
+}
+
+func (conn noWatchConn) ChildrenW(path string) ([]string, zk.Stat, <-chan zookeeper.Event, error) {
+	panic(zk.ZkoccUnimplementedError("ChildrenW"))
+}
+
+func (conn noWatchConn) ExistsW(path string) (zk.Stat, <-chan zookeeper.Event, error) {
+	panic(zk.ZkoccUnimplementedError("ExistsW"))
+}
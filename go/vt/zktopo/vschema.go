@@ -0,0 +1,37 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zktopo
+
+import (
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/zk"
+	"launchpad.net/gozk/zookeeper"
+)
+
+/*
+This file contains the VSchema management code for zktopo.Server
+*/
+
+const (
+	globalVSchemaPath = "/zk/global/vt/vschema"
+)
+
+// SaveVSchema is part of the topo.Server interface
+func (zkts *Server) SaveVSchema(vschema string) error {
+	_, err := zk.CreateOrUpdate(zkts.zconn, globalVSchemaPath, vschema, 0, zookeeper.WorldACL(zookeeper.PERM_ALL), true)
+	return err
+}
+
+// GetVSchema is part of the topo.Server interface
+func (zkts *Server) GetVSchema() (string, error) {
+	data, _, err := zkts.zconn.Get(globalVSchemaPath)
+	if err != nil {
+		if zookeeper.IsError(err, zookeeper.ZNONODE) {
+			err = topo.ErrNoNode
+		}
+		return "", err
+	}
+	return data, nil
+}
@@ -3,6 +3,7 @@ package zktopo
 import (
 	"testing"
 
+	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/topo/test"
 )
 
@@ -12,6 +13,18 @@ func TestKeyspace(t *testing.T) {
 	test.CheckKeyspace(t, ts)
 }
 
+func TestVSchema(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckVSchema(t, ts)
+}
+
+func TestQueryRules(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckQueryRules(t, ts)
+}
+
 func TestShard(t *testing.T) {
 	ts := NewTestServer(t, []string{"test"})
 	defer ts.Close()
@@ -36,6 +49,32 @@ func TestServingGraph(t *testing.T) {
 	test.CheckServingGraph(t, ts)
 }
 
+func TestWatchEndPoints(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckWatchEndPoints(t, ts)
+}
+
+func TestWatchSrvKeyspace(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckWatchSrvKeyspace(t, ts)
+}
+
+func TestWatchNotSupported(t *testing.T) {
+	ts := NewTestServerWithoutWatches(t, []string{"test"})
+	defer ts.Close()
+	if _, err := ts.WatchEndPoints("test", "test_keyspace", "-10", topo.TYPE_MASTER, nil); err != errWatchNotSupported {
+		t.Errorf("WatchEndPoints returned %v, was expecting errWatchNotSupported", err)
+	}
+	if _, err := ts.WatchSrvKeyspace("test", "test_keyspace", nil); err != errWatchNotSupported {
+		t.Errorf("WatchSrvKeyspace returned %v, was expecting errWatchNotSupported", err)
+	}
+	if _, err := ts.WatchQueryRules("test_keyspace", "-10", nil); err != errWatchNotSupported {
+		t.Errorf("WatchQueryRules returned %v, was expecting errWatchNotSupported", err)
+	}
+}
+
 func TestKeyspaceLock(t *testing.T) {
 	ts := NewTestServer(t, []string{"test"})
 	defer ts.Close()
@@ -52,6 +91,16 @@ func TestShardLock(t *testing.T) {
 	test.CheckShardLock(t, ts)
 }
 
+func TestShardElection(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping wait-based test in short mode.")
+	}
+
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckShardElection(t, ts)
+}
+
 func TestSrvShardLock(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping wait-based test in short mode.")
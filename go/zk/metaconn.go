@@ -58,6 +58,21 @@ type Conn interface {
 
 type ChangeFunc func(oldValue string, oldStat Stat) (newValue string, err error)
 
+// watchChecker is implemented by the Conn that may not support the
+// watch methods (GetW, ChildrenW and ExistsW).
+type watchChecker interface {
+	CanWatch() bool
+}
+
+// CanWatch returns true if conn supports the watch methods. zkocc
+// connections don't, and panic if they're called.
+func CanWatch(conn Conn) bool {
+	if wc, ok := conn.(watchChecker); ok {
+		return wc.CanWatch()
+	}
+	return true
+}
+
 // Smooth API to talk to any zk path in the global system.  Emulates
 // "/zk/local" paths by guessing and substituting the correct cell for
 // your current environment.
@@ -130,6 +145,11 @@ func (conn *MetaConn) GetW(path string) (data string, stat Stat, watch <-chan zo
 	return zconn.GetW(resolveZkPath(path))
 }
 
+// CanWatch returns false if conn talks to zkocc.
+func (conn *MetaConn) CanWatch() bool {
+	return !conn.connCache.useZkocc
+}
+
 func (conn *MetaConn) Children(path string) (children []string, stat Stat, err error) {
 	if path == ("/" + MagicPrefix) {
 		// NOTE(msolo) There is a slight hack there - but there really is
@@ -50,11 +50,22 @@ func DialZkocc(addr string, connectTimeout time.Duration) (zkocc *ZkoccConn, err
 	return nil, fmt.Errorf("zkocc connect failed: %v", addr)
 }
 
+// convertError converts the zookeeper errors that were turned into
+// strings by the RPC layer back to zookeeper.Error, so
+// zookeeper.IsError works the same with zkocc. Only ZNONODE is
+// converted, it's the only one clients act on.
+func convertError(op, path string, err error) error {
+	if serverError, ok := err.(rpcplus.ServerError); ok && strings.HasSuffix(string(serverError), ": "+zookeeper.ZNONODE.String()) {
+		return &zookeeper.Error{Op: op, Code: zookeeper.ZNONODE, Path: path}
+	}
+	return err
+}
+
 func (conn *ZkoccConn) Get(path string) (data string, stat Stat, err error) {
 	zkPath := &ZkPath{path}
 	zkNode := &ZkNode{}
 	if err := conn.rpcClient.Call("ZkReader.Get", zkPath, zkNode); err != nil {
-		return "", nil, err
+		return "", nil, convertError("zkocc get", path, err)
 	}
 	return zkNode.Data, &zkNode.Stat, nil
 }
@@ -63,11 +74,16 @@ func (conn *ZkoccConn) GetW(path string) (data string, stat Stat, watch <-chan z
 	panic(ZkoccUnimplementedError("GetW"))
 }
 
+// CanWatch returns false, zkocc doesn't support watches.
+func (conn *ZkoccConn) CanWatch() bool {
+	return false
+}
+
 func (conn *ZkoccConn) Children(path string) (children []string, stat Stat, err error) {
 	zkPath := &ZkPath{path}
 	zkNode := &ZkNode{}
 	if err := conn.rpcClient.Call("ZkReader.Children", zkPath, zkNode); err != nil {
-		return nil, nil, err
+		return nil, nil, convertError("zkocc children", path, err)
 	}
 	return zkNode.Children, &zkNode.Stat, nil
 }
@@ -82,7 +98,7 @@ func (conn *ZkoccConn) Exists(path string) (stat Stat, err error) {
 	zkPath := &ZkPath{path}
 	zkNode := &ZkNode{}
 	if err := conn.rpcClient.Call("ZkReader.Get", zkPath, zkNode); err != nil {
-		return nil, err
+		return nil, convertError("zkocc exists", path, err)
 	}
 	return &zkNode.Stat, nil
 }
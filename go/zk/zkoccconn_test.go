@@ -0,0 +1,43 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zk
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/youtube/vitess/go/rpcplus"
+	"launchpad.net/gozk/zookeeper"
+)
+
+func TestConvertError(t *testing.T) {
+	// this is what zkocc sends back for a missing node
+	zkErr := &zookeeper.Error{Op: "get", Code: zookeeper.ZNONODE, Path: "/zk/global/vt/vschema"}
+	err := convertError("zkocc get", "/zk/global/vt/vschema", rpcplus.ServerError(zkErr.Error()))
+	if !zookeeper.IsError(err, zookeeper.ZNONODE) {
+		t.Errorf("convertError didn't return ZNONODE: %v", err)
+	}
+
+	for _, other := range []error{
+		rpcplus.ServerError((&zookeeper.Error{Op: "get", Code: zookeeper.ZNOAUTH}).Error()),
+		fmt.Errorf("connection closed"),
+	} {
+		if err := convertError("zkocc get", "/zk/global/vt/vschema", other); err != other {
+			t.Errorf("convertError(%v) changed the error: %v", other, err)
+		}
+	}
+}
+
+func TestCanWatch(t *testing.T) {
+	if CanWatch(&ZkoccConn{}) {
+		t.Errorf("ZkoccConn shouldn't be able to watch")
+	}
+	if CanWatch(NewMetaConn(true)) {
+		t.Errorf("zkocc MetaConn shouldn't be able to watch")
+	}
+	if !CanWatch(NewMetaConn(false)) {
+		t.Errorf("zk MetaConn should be able to watch")
+	}
+}
@@ -45,7 +45,28 @@ class MariaDB(MysqlFlavor):
         "RESET SLAVE",
     ]
 
+
+class MySQL56(MysqlFlavor):
+  """Overrides specific to MySQL 5.6"""
+
+  def promote_slave_commands(self):
+    # RESET MASTER would clear @@gtid_executed, which the slaves
+    # need to find their position on the new master.
+    return [
+        "STOP SLAVE",
+        "RESET SLAVE ALL",
+    ]
+
+  def reset_replication_commands(self):
+    return [
+        "RESET MASTER",
+        "STOP SLAVE",
+        "RESET SLAVE ALL",
+    ]
+
 if environment.mysql_flavor == "MariaDB":
   mysql_flavor = MariaDB()
+elif environment.mysql_flavor == "MySQL56":
+  mysql_flavor = MySQL56()
 else:
   mysql_flavor = GoogleMysql()
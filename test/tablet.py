@@ -69,6 +69,9 @@ class Tablet(object):
     if environment.mysql_flavor == "GoogleMysql":
       # we have to manually enable hierarchical replication to support group_id
       all_extra_my_cnf.append(environment.vttop + "/config/mycnf/master_google.cnf")
+    elif environment.mysql_flavor == "MySQL56":
+      # GTIDs have to be enabled explicitly in MySQL 5.6
+      all_extra_my_cnf.append(environment.vttop + "/config/mycnf/master_mysql56.cnf")
     if extra_my_cnf:
        all_extra_my_cnf.append(extra_my_cnf)
     extra_env = None